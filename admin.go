@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// adminClient sends requests to the admin API of a running server.
+type adminClient struct {
+	base   string
+	token  string
+	client *http.Client
+}
+
+func newAdminClient(address string, token string) *adminClient {
+	c := &adminClient{
+		base:   "http://" + address,
+		token:  token,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+
+	if strings.HasPrefix(address, "unix:") {
+		socket := strings.TrimPrefix(address, "unix:")
+		c.base = "http://godns"
+		c.client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+	}
+
+	return c
+}
+
+func (c *adminClient) do(method string, path string, query url.Values) (string, error) {
+	target := c.base + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "preparing admin request")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "sending admin request")
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", errors.Wrap(err, "reading admin response")
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return "", errors.Errorf("admin api: %s", strings.TrimSpace(string(body)))
+	}
+
+	return string(body), nil
+}
+
+// admin runs the subcommands talking to the admin API of a running server:
+//
+//	godns cache flush [NAME]
+//	godns blocklist reload
+//	godns rpz reload
+//	godns zones reload
+//	godns stats
+//	godns dump
+func admin(command string, args []string) int {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	address := flags.String("admin-addr", "127.0.0.1:8053", "address of the admin API, e.g. unix:/run/godns.sock")
+	token := flags.String("admin-token", os.Getenv("GODNS_ADMIN_TOKEN"), "bearer token of the admin API, defaults to $GODNS_ADMIN_TOKEN")
+
+	usage := func() int {
+		fmt.Println("usage: godns cache flush [NAME] | godns blocklist reload | godns rpz reload | godns zones reload | godns stats | godns dump")
+		return 2
+	}
+
+	// The action of every command but stats and dump comes before the flags
+	action := ""
+	if command != "stats" && command != "dump" {
+		if len(args) == 0 {
+			return usage()
+		}
+		action, args = args[0], args[1:]
+	}
+	flags.Parse(args)
+
+	client := newAdminClient(*address, *token)
+
+	var out string
+	var err error
+	switch {
+	case command == "cache" && action == "flush" && flags.NArg() <= 1:
+		query := url.Values{}
+		if flags.NArg() == 1 {
+			query.Set("name", flags.Arg(0))
+		}
+		out, err = client.do(http.MethodPost, "/cache/flush", query)
+	case command == "blocklist" && action == "reload" && flags.NArg() == 0:
+		out, err = client.do(http.MethodPost, "/blocklist/reload", nil)
+	case command == "rpz" && action == "reload" && flags.NArg() == 0:
+		out, err = client.do(http.MethodPost, "/rpz/reload", nil)
+	case command == "zones" && action == "reload" && flags.NArg() == 0:
+		out, err = client.do(http.MethodPost, "/zones/reload", nil)
+	case command == "stats" && flags.NArg() == 0:
+		out, err = client.do(http.MethodGet, "/stats", nil)
+	case command == "dump" && flags.NArg() == 0:
+		out, err = client.do(http.MethodGet, "/dump", nil)
+	default:
+		return usage()
+	}
+
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return 1
+	}
+	fmt.Print(out)
+
+	return 0
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/pkg/errors"
+)
+
+// benchTick is how often the bench releases the queries due, finer pacing
+// than the OS timer resolution isn't achievable anyway.
+const benchTick = 10 * time.Millisecond
+
+// defaultQueryMix is queried when no query file is given.
+var defaultQueryMix = []*dns.DNSQuestion{
+	dns.NewDNSQuestion("example.com", dns.AQueryType),
+	dns.NewDNSQuestion("example.com", dns.AAAAQueryType),
+	dns.NewDNSQuestion("www.example.com", dns.AQueryType),
+	dns.NewDNSQuestion("example.com", dns.MXQueryType),
+	dns.NewDNSQuestion("example.com", dns.NSQueryType),
+}
+
+// benchResult collects the outcome of the queries of a bench run.
+type benchResult struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	rcodes    map[dns.ResultCode]int
+	timeouts  int
+	errors    int
+	skipped   int
+}
+
+// bench sends queries to a running server at a fixed rate and reports the
+// latency percentiles:
+//
+//	godns bench [-server ADDR] [-qps N] [-duration D] [-queries FILE]
+func bench(args []string) int {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	serverAddr := flags.String("server", "127.0.0.1:2053", "UDP address of the server under test")
+	qps := flags.Int("qps", 1000, "queries sent per second")
+	duration := flags.Duration("duration", 10*time.Second, "how long to send queries")
+	concurrency := flags.Int("concurrency", 64, "queries in flight at most, queries due while all are busy are skipped")
+	timeout := flags.Duration("timeout", 2*time.Second, "how long to wait for each response")
+	queriesFile := flags.String("queries", "", "file of queries to cycle through, one \"NAME TYPE\" per line")
+	flags.Parse(args)
+
+	if flags.NArg() != 0 || *qps <= 0 || *concurrency <= 0 {
+		fmt.Println("usage: godns bench [-server ADDR] [-qps N] [-duration D] [-concurrency N] [-queries FILE]")
+		return 2
+	}
+
+	questions := defaultQueryMix
+	if *queriesFile != "" {
+		var err error
+		questions, err = readQueryMix(*queriesFile)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return 1
+		}
+	}
+
+	remote, err := net.ResolveUDPAddr("udp", *serverAddr)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return 1
+	}
+
+	result := &benchResult{rcodes: make(map[dns.ResultCode]int)}
+	jobs := make(chan *dns.DNSQuestion, *concurrency)
+
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for q := range jobs {
+				result.add(benchQuery(remote, q, *timeout))
+			}
+		}()
+	}
+
+	started := time.Now()
+	ticker := time.NewTicker(benchTick)
+	sent := 0
+	for now := range ticker.C {
+		elapsed := now.Sub(started)
+		if elapsed > *duration {
+			break
+		}
+
+		for due := int(elapsed.Seconds() * float64(*qps)); sent < due; sent++ {
+			select {
+			case jobs <- questions[sent%len(questions)]:
+			default:
+				result.skipped++
+			}
+		}
+	}
+	ticker.Stop()
+	close(jobs)
+	wg.Wait()
+
+	result.print(time.Since(started))
+	return 0
+}
+
+// benchOutcome is the result of one query, err is a timeout or another
+// failure.
+type benchOutcome struct {
+	latency time.Duration
+	rcode   dns.ResultCode
+	err     error
+}
+
+func benchQuery(remote *net.UDPAddr, q *dns.DNSQuestion, timeout time.Duration) benchOutcome {
+	conn, err := net.DialUDP("udp", nil, remote)
+	if err != nil {
+		return benchOutcome{err: err}
+	}
+	defer conn.Close()
+
+	query := dns.NewDNSPacket()
+	query.Header.ID = uint16(time.Now().UnixNano())
+	query.Header.RecursionDesired = true
+	query.Questions = append(query.Questions, q)
+
+	start := time.Now()
+	conn.SetDeadline(start.Add(timeout))
+	if _, err := query.WriteTo(conn); err != nil {
+		return benchOutcome{err: err}
+	}
+
+	response, err := dns.ReadPacket(conn)
+	if err != nil {
+		return benchOutcome{err: err}
+	}
+
+	return benchOutcome{latency: time.Since(start), rcode: response.Header.ResCode}
+}
+
+func (r *benchResult) add(o benchOutcome) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var netErr net.Error
+	switch {
+	case errors.As(o.err, &netErr) && netErr.Timeout():
+		r.timeouts++
+	case o.err != nil:
+		r.errors++
+	default:
+		r.latencies = append(r.latencies, o.latency)
+		r.rcodes[o.rcode]++
+	}
+}
+
+func (r *benchResult) print(elapsed time.Duration) {
+	sort.Slice(r.latencies, func(i, j int) bool {
+		return r.latencies[i] < r.latencies[j]
+	})
+
+	answered := len(r.latencies)
+	fmt.Printf("answered %d in %s (%.0f qps)\n", answered, elapsed.Truncate(time.Millisecond), float64(answered)/elapsed.Seconds())
+	fmt.Printf("timeouts %d, errors %d, skipped %d\n", r.timeouts, r.errors, r.skipped)
+
+	codes := make([]dns.ResultCode, 0, len(r.rcodes))
+	for code := range r.rcodes {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+	for _, code := range codes {
+		fmt.Printf("%s %d\n", code, r.rcodes[code])
+	}
+
+	if answered == 0 {
+		return
+	}
+	for _, p := range []float64{50, 90, 99, 99.9} {
+		fmt.Printf("p%g %s\n", p, percentile(r.latencies, p))
+	}
+	fmt.Printf("max %s\n", r.latencies[answered-1])
+}
+
+// percentile returns the latency below which p percent of the sorted
+// latencies fall.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(float64(len(sorted))*p/100+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+
+	return sorted[i]
+}
+
+// readQueryMix reads "NAME TYPE" lines, the type defaults to A. Blank lines
+// and lines starting with # are skipped.
+func readQueryMix(path string) ([]*dns.DNSQuestion, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening query file")
+	}
+	defer f.Close()
+
+	questions := make([]*dns.DNSQuestion, 0)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		qtype := dns.AQueryType
+		if len(fields) > 1 {
+			qtype, err = dns.ParseQueryType(fields[1])
+			if err != nil {
+				return nil, errors.Wrapf(err, "parsing query file line %d", line)
+			}
+		}
+		questions = append(questions, dns.NewDNSQuestion(fields[0], qtype))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "reading query file")
+	}
+
+	if len(questions) == 0 {
+		return nil, errors.Errorf("query file %s has no queries", path)
+	}
+
+	return questions, nil
+}
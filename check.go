@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/msarvar/godns/pkg/logger"
+	"github.com/msarvar/godns/pkg/server"
+)
+
+// checkCommand loads a server configuration without serving it and reports
+// everything wrong with it, to hold back a deployment that wouldn't start or
+// couldn't resolve. It takes the flags of the server:
+//
+//	godns check -zone example.com=example.com.zone -forward 192.0.2.1 ...
+func checkCommand(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	f := newServerFlags(flags)
+	// Only the problems are of interest
+	f.logLevel = logger.LevelError
+	flags.Parse(args)
+
+	logger.SetLevel(f.logLevel)
+
+	if flags.NArg() > 0 {
+		fmt.Println("usage: godns check [server flags]")
+		return 2
+	}
+
+	cfg, err := f.config()
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return 1
+	}
+
+	problems := server.NewServer(cfg).Check(context.Background())
+	for _, problem := range problems {
+		fmt.Printf("Error: %s\n", problem)
+	}
+	switch len(problems) {
+	case 0:
+	case 1:
+		fmt.Println("1 problem found")
+		return 1
+	default:
+		fmt.Printf("%d problems found\n", len(problems))
+		return 1
+	}
+
+	fmt.Println("Configuration OK")
+	return 0
+}
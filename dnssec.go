@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/dnssec"
+	"github.com/pkg/errors"
+)
+
+// dnssecTTL is the TTL of the DNSKEY and DS records printed.
+const dnssecTTL = 3600
+
+// dnssecCommand manages DNSSEC keys and signs zone files offline:
+//
+//	godns dnssec keygen [-ksk] [-out FILE] ZONE
+//	godns dnssec ds KEYFILE...
+//	godns dnssec sign -key KEYFILE [-key KEYFILE] ZONE ZONEFILE
+func dnssecCommand(args []string) int {
+	usage := func() int {
+		fmt.Println("usage: godns dnssec keygen [-ksk] [-out FILE] ZONE | godns dnssec ds KEYFILE... | godns dnssec sign -key KEYFILE ZONE ZONEFILE")
+		return 2
+	}
+	if len(args) == 0 {
+		return usage()
+	}
+
+	flags := flag.NewFlagSet("dnssec "+args[0], flag.ExitOnError)
+	var err error
+	switch args[0] {
+	case "keygen":
+		ksk := flags.Bool("ksk", false, "create a key signing key, referred to by the DS record at the parent")
+		out := flags.String("out", "", "file to store the key in, defaults to ZONE+TAG.key")
+		flags.Parse(args[1:])
+		if flags.NArg() != 1 {
+			return usage()
+		}
+		err = keygen(flags.Arg(0), *ksk, *out)
+	case "ds":
+		flags.Parse(args[1:])
+		if flags.NArg() == 0 {
+			return usage()
+		}
+		err = printDS(flags.Args())
+	case "sign":
+		var keys dnssec.Keys
+		flags.Var(&keys, "key", "key file of the zone (repeatable)")
+		flags.Parse(args[1:])
+		if flags.NArg() != 2 || len(keys) == 0 {
+			return usage()
+		}
+		err = signZone(flags.Arg(0), flags.Arg(1), keys)
+	default:
+		return usage()
+	}
+
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+// keygen creates a key for the zone and prints its DNSKEY record, and the DS
+// record of key signing keys.
+func keygen(zone string, ksk bool, out string) error {
+	k, err := dnssec.GenerateKey(zone, ksk)
+	if err != nil {
+		return err
+	}
+
+	if out == "" {
+		out = fmt.Sprintf("%s+%05d.key", k.Zone, k.KeyTag())
+	}
+	if err := k.WriteFile(out); err != nil {
+		return err
+	}
+
+	fmt.Printf("; key %d stored in %s\n", k.KeyTag(), out)
+	fmt.Println(dnssec.FormatDNSKEY(k.DNSKEY(dnssecTTL)))
+	if ksk {
+		return printDS([]string{out})
+	}
+
+	return nil
+}
+
+// printDS prints the DS records to publish at the parent of the zones of the
+// keys.
+func printDS(paths []string) error {
+	for _, path := range paths {
+		k, err := dnssec.ReadKeyFile(path)
+		if err != nil {
+			return err
+		}
+
+		ds, err := k.DS(dnssecTTL)
+		if err != nil {
+			return err
+		}
+		fmt.Println(dnssec.FormatDS(ds))
+	}
+
+	return nil
+}
+
+// signZone prints the zone file signed with the keys.
+func signZone(zone string, path string, keys dnssec.Keys) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "opening zone file")
+	}
+	defer f.Close()
+
+	records, err := dns.ReadZone(f, zone, dnssecTTL)
+	if err != nil {
+		return err
+	}
+
+	signed, err := dnssec.Sign(zone, records, keys, time.Now())
+	if err != nil {
+		return err
+	}
+
+	for _, r := range signed {
+		fmt.Println(r.Text())
+	}
+
+	return nil
+}
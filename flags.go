@@ -0,0 +1,224 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/msarvar/godns/pkg/docker"
+	"github.com/msarvar/godns/pkg/logger"
+	"github.com/msarvar/godns/pkg/querylog"
+	"github.com/msarvar/godns/pkg/records"
+	"github.com/msarvar/godns/pkg/server"
+	"github.com/pkg/errors"
+)
+
+// serverFlags are the flags configuring the server, godns check takes them
+// too.
+type serverFlags struct {
+	cfg      *server.Config
+	queryLog querylog.Config
+	logLevel logger.Level
+
+	pcapFile       *string
+	dns64          *bool
+	dns64Prefix    *string
+	ecsPrefixV4    *uint
+	ecsPrefixV6    *uint
+	maxUDPSize     *uint
+	dockerEndpoint *string
+	dockerMapping  *string
+	consul         *string
+	consulDomain   *string
+	etcd           *string
+	etcdPrefix     *string
+	viewsFile      *string
+	groupsFile     *string
+	rulesFile      *string
+}
+
+func newServerFlags(flags *flag.FlagSet) *serverFlags {
+	f := &serverFlags{cfg: server.DefaultConfig(), logLevel: logger.LevelDebug}
+	flags.Var(&f.cfg.Listeners, "listen", "endpoint to serve on, e.g. udp+tcp://127.0.0.1:53 (repeatable)")
+	flags.Var(&f.cfg.AddressPreference, "ip-preference", "address family for upstream queries: prefer-v4, prefer-v6 or dual")
+	flags.StringVar(&f.cfg.HealthAddress, "health-addr", "", "address for the /healthz and /readyz endpoints, e.g. :8080")
+	flags.BoolVar(&f.cfg.ReadinessSelfQuery, "readiness-self-query", false, "make /readyz query the UDP listener")
+	flags.StringVar(&f.cfg.CaptureDir, "capture-dir", "", "save every upstream query and response to this directory for debugging")
+	flags.StringVar(&f.cfg.DumpFile, "dump-file", "", "write the state dump made on SIGUSR1 to this file instead of the log")
+	f.pcapFile = flags.String("pcap", "", "record client and upstream exchanges to this pcap file")
+	flags.StringVar(&f.queryLog.Path, "query-log", "", "store the queries answered as JSON lines in this file, see godns logs")
+	flags.Int64Var(&f.queryLog.MaxSize, "query-log-max-size", 100<<20, "rotate the -query-log at this many bytes, 0 disables it")
+	flags.DurationVar(&f.queryLog.MaxAge, "query-log-max-age", 24*time.Hour, "rotate the -query-log after this long, 0 disables it")
+	flags.IntVar(&f.queryLog.MaxBackups, "query-log-backups", 7, "rotated query logs kept, 0 keeps all of them")
+	flags.DurationVar(&f.queryLog.Retention, "query-log-retention", 0, "remove queries from the -query-log after about this long, e.g. 168h, 0 keeps them")
+	flags.Var(&f.queryLog.Clients, "query-log-clients", "how client addresses are stored in the -query-log: keep, truncate to their network or hash")
+	flags.Var(&f.queryLog.Sensitive, "query-log-sensitive", "store queries for this domain and the names below it without their name (repeatable)")
+	f.dns64 = flags.Bool("dns64", false, "synthesize AAAA records from A records for IPv6-only clients")
+	f.dns64Prefix = flags.String("dns64-prefix", server.DefaultDNS64Prefix, "NAT64 prefix used by -dns64")
+	flags.BoolVar(&f.cfg.ECSForward, "ecs-forward", false, "forward the EDNS Client Subnet of clients to upstreams")
+	f.ecsPrefixV4 = flags.Uint("ecs-prefix-v4", uint(f.cfg.ECSPrefixV4), "longest IPv4 client subnet prefix forwarded")
+	f.ecsPrefixV6 = flags.Uint("ecs-prefix-v6", uint(f.cfg.ECSPrefixV6), "longest IPv6 client subnet prefix forwarded")
+	flags.BoolVar(&f.cfg.Recursion, "recursion", f.cfg.Recursion, "resolve names for clients setting RD, -recursion=false only answers from zones and policies")
+	f.maxUDPSize = flags.Uint("max-udp-size", uint(f.cfg.MaxUDPSize), "largest UDP response sent to EDNS clients, larger ones are truncated")
+	flags.BoolVar(&f.cfg.MinimalResponses, "minimal-responses", false, "leave authority and additional records out of answers unless needed")
+	flags.IntVar(&f.cfg.PrefetchHits, "prefetch-hits", 0, "refresh cache entries requested this often before they expire, 0 disables prefetching")
+	flags.IntVar(&f.cfg.CacheMaxEntries, "cache-max-entries", f.cfg.CacheMaxEntries, "most responses cached per view, 0 leaves it unbounded")
+	flags.IntVar(&f.cfg.CacheMaxBytes, "cache-max-bytes", f.cfg.CacheMaxBytes, "largest estimated size of the cache of a view, 0 leaves it unbounded")
+	flags.StringVar(&f.cfg.CacheFile, "cache-file", "", "keep the cache in this file across restarts")
+	flags.DurationVar(&f.cfg.CacheSaveInterval, "cache-save-interval", f.cfg.CacheSaveInterval, "how often the cache is saved to -cache-file")
+	flags.Var(&f.cfg.Strategies, "strategy", "resolution steps tried in order for a domain, e.g. example.com=recursive,forward:8.8.8.8, a step ending in @IP or @INTERFACE sends from there (repeatable)")
+	flags.BoolVar(&f.cfg.Proxy, "proxy", false, "relay queries to the -forward resolvers unmodified, only blocking, policies and zones apply")
+	flags.DurationVar(&f.cfg.QueryTimeout, "query-timeout", f.cfg.QueryTimeout, "stop working on a UDP query after this long, 0 leaves it to the resolver's limit")
+	flags.DurationVar(&f.cfg.TCPQueryTimeout, "tcp-query-timeout", f.cfg.TCPQueryTimeout, "stop working on a TCP query after this long, 0 leaves it to the resolver's limit")
+	flags.IntVar(&f.cfg.UDPWorkers, "udp-workers", f.cfg.UDPWorkers, "UDP queries answered at once on each listener, 0 answers one at a time")
+	flags.IntVar(&f.cfg.MaxTCPConnections, "max-tcp-connections", f.cfg.MaxTCPConnections, "TCP connections open at once, more are closed right away, 0 disables the limit")
+	flags.DurationVar(&f.cfg.TCPIdleTimeout, "tcp-idle-timeout", f.cfg.TCPIdleTimeout, "close TCP connections idle for this long, 0 keeps them open")
+	flags.IntVar(&f.cfg.MaxTCPQueries, "max-tcp-queries", f.cfg.MaxTCPQueries, "close TCP connections after this many queries, 0 disables the limit")
+	flags.DurationVar(&f.cfg.UpstreamCheckInterval, "upstream-check-interval", f.cfg.UpstreamCheckInterval, "how often forwarders are probed, 0 disables probing")
+	flags.BoolVar(&f.cfg.DiscoverDesignated, "ddr", false, "upgrade plain forwarders to the DoT or DoH resolvers they designate (RFC9462)")
+	flags.Var(&f.cfg.Privacy, "privacy", "when encrypted forwarders fail: opportunistic falls back to plain DNS with a warning, strict fails the query")
+	flags.DurationVar(&f.cfg.MinTTL, "min-ttl", 0, "raise TTLs of upstream records below this, e.g. 1m")
+	flags.DurationVar(&f.cfg.MaxTTL, "max-ttl", 0, "lower TTLs of upstream records above this, e.g. 24h")
+	flags.DurationVar(&f.cfg.MaxStale, "max-stale", 0, "answer from cache entries expired up to this long ago when upstreams fail, e.g. 24h")
+	flags.StringVar(&f.cfg.BlocklistFile, "blocklist", "", "answer NXDOMAIN for the domains listed in this file or http(s) URL")
+	flags.StringVar(&f.cfg.AllowlistFile, "allowlist", "", "never block the names in this file, exact or as *.example.com for every subdomain")
+	flags.DurationVar(&f.cfg.BlocklistRefresh, "blocklist-refresh", f.cfg.BlocklistRefresh, "how often a -blocklist URL is downloaded again when it changed, 0 disables it")
+	flags.StringVar(&f.cfg.HostsFile, "hosts", "", "answer A, AAAA and PTR queries from this hosts file before recursing, e.g. /etc/hosts")
+	flags.StringVar(&f.cfg.LeaseFile, "dhcp-leases", "", "answer A, AAAA and PTR queries for DHCP clients from this dnsmasq or ISC dhcpd lease file")
+	flags.StringVar(&f.cfg.LeaseDomain, "dhcp-domain", f.cfg.LeaseDomain, "domain the hostnames of -dhcp-leases are answered under")
+	f.dockerEndpoint = flags.String("docker", "", "answer the names of the containers of this Docker daemon, e.g. "+docker.DefaultEndpoint)
+	f.dockerMapping = flags.String("docker-mapping", "", "JSON file mapping container names to addresses, instead of asking -docker")
+	flags.StringVar(&f.cfg.ContainerSuffix, "docker-suffix", f.cfg.ContainerSuffix, "domain container names are answered under")
+	f.consul = flags.String("consul", "", "serve the services registered in the Consul agent at this URL, e.g. http://127.0.0.1:8500")
+	f.consulDomain = flags.String("consul-domain", "consul", "domain the services of -consul are answered under")
+	f.etcd = flags.String("etcd", "", "serve the records stored in SkyDNS layout in the etcd server at this URL, e.g. http://127.0.0.1:2379")
+	f.etcdPrefix = flags.String("etcd-prefix", "/skydns", "key prefix of the records in -etcd")
+	flags.Var(&f.cfg.Zones, "zone", "zone to answer authoritatively as ZONE=FILE or ZONE=axfr://HOST:PORT (repeatable)")
+	flags.Var(&f.cfg.SigningKeys, "dnssec-key", "key file created by godns dnssec keygen signing its zone (repeatable)")
+	flags.Var(&f.cfg.Catalogs, "catalog", "catalog zone listing zones to transfer from its primary, as ZONE=axfr://HOST:PORT (repeatable)")
+	flags.Var(&f.cfg.TransferClients, "allow-transfer", "network or address allowed to transfer zones with AXFR over TCP, e.g. 10.0.0.2 (repeatable)")
+	flags.Var(&f.cfg.Forwarders, "forward", "resolver to forward queries to instead of recursing, as IP, IP:PORT or a DoH, DoT, DNSCrypt or plain DNS stamp sdns://..., ending in @IP or @INTERFACE to send from there (repeatable)")
+	f.viewsFile = flags.String("views", "", "JSON file of views giving client networks their own zones, forwarders and blocklist")
+	f.groupsFile = flags.String("client-groups", "", "JSON file of client groups, by network or MAC address, with their own blocklists and blocking schedules")
+	f.rulesFile = flags.String("query-rules", "", "JSON file of rules refusing, dropping, rewriting or answering NXDOMAIN to queries by client, name, type or a \"when\" expression")
+	flags.Var(&f.cfg.Rewrites, "rewrite", "resolve names as other names, as exact:FROM=TO, suffix:FROM=TO or regex:FROM=TO (repeatable)")
+	flags.BoolVar(&f.cfg.SafeSearch, "safe-search", false, "send every client to the safe search of Google, Bing, DuckDuckGo and YouTube")
+	flags.Var(&f.cfg.RPZ, "rpz", "response policy zone as ZONE=FILE or ZONE=axfr://HOST:PORT, consulted in order (repeatable)")
+	flags.StringVar(&f.cfg.AdminAddress, "admin-addr", "", "address of the admin API, e.g. 127.0.0.1:8053 or unix:/run/godns.sock")
+	flags.StringVar(&f.cfg.AdminToken, "admin-token", os.Getenv("GODNS_ADMIN_TOKEN"), "bearer token required by the admin API, defaults to $GODNS_ADMIN_TOKEN")
+	flags.BoolVar(&f.cfg.Dashboard, "dashboard", false, "serve a web dashboard of query rates, top names and clients and blocking at /dashboard of the admin API")
+	flags.StringVar(&f.cfg.ChaosVersion, "chaos-version", "", "answer CHAOS TXT queries for version.bind with this, they are refused when empty")
+	flags.StringVar(&f.cfg.ChaosHostname, "chaos-hostname", "", "answer CHAOS TXT queries for hostname.bind and id.server with this, they are refused when empty")
+	flags.StringVar(&f.cfg.NSID, "nsid", "", "identifier of this instance sent to clients asking with the EDNS NSID option, e.g. dns1.fra")
+	flags.Var(&f.cfg.Plugins, "plugin", "Go plugin built with -buildmode=plugin exporting NewPlugin, run on every query and response (repeatable)")
+	flags.Var(&f.cfg.Peers, "peer", "admin API URL of another instance repeating flushes and reloads, e.g. http://10.0.0.2:8053 (repeatable)")
+	flags.Var(&f.logLevel, "log-level", "least severity printed: debug, info or error")
+
+	// Faults are for testing the resolver against a bad network, they are
+	// left out of the usage
+	flags.Var(&f.cfg.UpstreamFaults, "upstream-faults", "degrade upstream UDP exchanges, e.g. drop=0.1,delay=200ms,corrupt=0.05,wrong-id=0.05")
+	hideFlags(flags, "upstream-faults")
+	return f
+}
+
+// hideFlags leaves the named flags out of the usage of flags, they can still
+// be set.
+func hideFlags(flags *flag.FlagSet, names ...string) {
+	hidden := make(map[string]bool, len(names))
+	for _, name := range names {
+		hidden[name] = true
+	}
+
+	flags.Usage = func() {
+		visible := flag.NewFlagSet(flags.Name(), flag.ContinueOnError)
+		visible.SetOutput(flags.Output())
+		flags.VisitAll(func(fl *flag.Flag) {
+			if !hidden[fl.Name] {
+				visible.Var(fl.Value, fl.Name, fl.Usage)
+				visible.Lookup(fl.Name).DefValue = fl.DefValue
+			}
+		})
+
+		if flags.Name() == "" {
+			fmt.Fprintf(flags.Output(), "Usage:\n")
+		} else {
+			fmt.Fprintf(flags.Output(), "Usage of %s:\n", flags.Name())
+		}
+		visible.PrintDefaults()
+	}
+}
+
+// config completes the server config once the flags are parsed, loading the
+// files they name. The pcap file and the query log are left to the caller,
+// opening them creates them.
+func (f *serverFlags) config() (*server.Config, error) {
+	cfg := f.cfg
+	if *f.ecsPrefixV4 > 32 {
+		return nil, errors.Errorf("invalid -ecs-prefix-v4 %d, expected at most 32", *f.ecsPrefixV4)
+	}
+	if *f.ecsPrefixV6 > 128 {
+		return nil, errors.Errorf("invalid -ecs-prefix-v6 %d, expected at most 128", *f.ecsPrefixV6)
+	}
+	cfg.ECSPrefixV4 = uint8(*f.ecsPrefixV4)
+	cfg.ECSPrefixV6 = uint8(*f.ecsPrefixV6)
+	if *f.maxUDPSize < 512 || *f.maxUDPSize > 65535 {
+		return nil, errors.Errorf("invalid -max-udp-size %d, expected 512 to 65535", *f.maxUDPSize)
+	}
+	cfg.MaxUDPSize = uint16(*f.maxUDPSize)
+
+	var err error
+	if *f.viewsFile != "" {
+		cfg.Views, err = server.LoadViews(*f.viewsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if *f.groupsFile != "" {
+		cfg.ClientGroups, err = server.LoadClientGroups(*f.groupsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if *f.rulesFile != "" {
+		cfg.QueryRules, err = server.LoadQueryRules(*f.rulesFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case *f.dockerMapping != "":
+		cfg.Containers, err = docker.LoadStatic(*f.dockerMapping)
+	case *f.dockerEndpoint != "":
+		cfg.Containers, err = docker.NewAPI(*f.dockerEndpoint)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case *f.consul != "":
+		cfg.Registry, err = records.NewConsul(*f.consul, *f.consulDomain)
+	case *f.etcd != "":
+		cfg.Registry, err = records.NewEtcd(*f.etcd, *f.etcdPrefix)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if *f.dns64 {
+		cfg.DNS64, err = server.ParseDNS64Prefix(*f.dns64Prefix)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(cfg.Listeners) == 0 {
+		cfg.Listeners = server.Listeners{server.DefaultListener}
+	}
+
+	return cfg, nil
+}
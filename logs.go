@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/msarvar/godns/pkg/querylog"
+	"github.com/pkg/errors"
+)
+
+// followInterval is how often logs tail -f checks for new queries.
+const followInterval = 500 * time.Millisecond
+
+// logsCommand inspects the query log written with -query-log:
+//
+//	godns logs tail -file FILE [-n 10] [-f] [--name NAME] [--client IP|CIDR] [--since 1h]
+//	godns logs search -file FILE [--name NAME] [--client IP|CIDR] [--since 1h]
+func logsCommand(args []string) int {
+	usage := func() int {
+		fmt.Println("usage: godns logs tail -file FILE [-n 10] [-f] [--name NAME] [--client IP|CIDR] [--since 1h] | godns logs search -file FILE [--name NAME] [--client IP|CIDR] [--since 1h]")
+		return 2
+	}
+	if len(args) == 0 {
+		return usage()
+	}
+
+	flags := flag.NewFlagSet("logs "+args[0], flag.ExitOnError)
+	file := flags.String("file", "", "query log to read, as given to -query-log of the server")
+	name := flags.String("name", "", "only show queries for this name and the names below it")
+	client := flags.String("client", "", "only show queries from this address or network, e.g. 192.168.1.0/24")
+	since := flags.String("since", "", "only show queries from this long ago or later, as a duration like 1h or an RFC3339 time")
+
+	var n *int
+	var follow *bool
+	switch args[0] {
+	case "tail":
+		n = flags.Int("n", 10, "number of queries shown")
+		follow = flags.Bool("f", false, "keep showing queries as they are logged")
+	case "search":
+	default:
+		return usage()
+	}
+	flags.Parse(args[1:])
+	if flags.NArg() != 0 || *file == "" {
+		return usage()
+	}
+
+	filter := &querylog.Filter{Name: *name, Client: *client}
+	var err error
+	filter.Since, err = parseSince(*since, time.Now())
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return 2
+	}
+
+	print := func(e *querylog.Entry) error {
+		fmt.Printf("%s %s %s %s %s %d answers %s\n",
+			e.Time.Local().Format(time.RFC3339), e.Client, e.Name, e.Type, e.ResCode, e.Answers,
+			time.Duration(e.Elapsed)*time.Microsecond)
+		return nil
+	}
+
+	if args[0] == "search" {
+		err = querylog.Search(*file, filter, print)
+	} else {
+		err = tailLog(*file, filter, *n, *follow, print)
+	}
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+// tailLog prints the last n queries and, when following, the queries logged
+// afterwards until interrupted.
+func tailLog(file string, filter *querylog.Filter, n int, follow bool, print func(*querylog.Entry) error) error {
+	entries, err := querylog.Tail(file, filter, n)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		print(e)
+	}
+
+	if !follow {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	return querylog.Follow(ctx, file, filter, followInterval, print)
+}
+
+// parseSince parses --since as a duration before now or as a time, an empty
+// value doesn't limit the time.
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.Errorf("invalid --since %q, expected a duration like 1h or an RFC3339 time", value)
+	}
+
+	return t, nil
+}
@@ -2,11 +2,137 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/logger"
+	"github.com/msarvar/godns/pkg/pcap"
+	"github.com/msarvar/godns/pkg/querylog"
+	"github.com/msarvar/godns/pkg/resolver"
 	"github.com/msarvar/godns/pkg/server"
+	"github.com/pkg/errors"
 )
 
 func main() {
-	ctx := context.Background()
-	server.Serve(ctx)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "lookup":
+			os.Exit(lookup(os.Args[2:]))
+		case "bench":
+			os.Exit(bench(os.Args[2:]))
+		case "dnssec":
+			os.Exit(dnssecCommand(os.Args[2:]))
+		case "check":
+			os.Exit(checkCommand(os.Args[2:]))
+		case "logs":
+			os.Exit(logsCommand(os.Args[2:]))
+		case "cache", "blocklist", "rpz", "zones", "stats", "dump":
+			os.Exit(admin(os.Args[1], os.Args[2:]))
+		}
+	}
+
+	f := newServerFlags(flag.CommandLine)
+	flag.Parse()
+
+	logger.SetLevel(f.logLevel)
+
+	cfg, err := f.config()
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	cfg.Pcap, err = openPcap(*f.pcapFile)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	if f.queryLog.Path != "" {
+		cfg.QueryLog, err = querylog.Open(f.queryLog)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		defer cfg.QueryLog.Close()
+	}
+
+	// Stop serving on interrupt so the cache gets saved
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	if err := server.Serve(ctx, cfg); err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// lookup resolves a single name and prints the response like dig does:
+//
+//	godns lookup [-unicode] NAME [TYPE]
+func lookup(args []string) int {
+	cfg := &resolver.Config{}
+	flags := flag.NewFlagSet("lookup", flag.ExitOnError)
+	flags.Var(&cfg.AddressPreference, "ip-preference", "address family for upstream queries: prefer-v4, prefer-v6 or dual")
+	flags.StringVar(&cfg.CaptureDir, "capture-dir", "", "save every upstream query and response to this directory")
+	pcapFile := flags.String("pcap", "", "record upstream exchanges to this pcap file")
+	unicode := flags.Bool("unicode", false, "render internationalized names as unicode")
+	flags.Parse(args)
+
+	var err error
+	cfg.Pcap, err = openPcap(*pcapFile)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return 1
+	}
+
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		fmt.Println("usage: godns lookup [-unicode] NAME [TYPE]")
+		return 2
+	}
+
+	qtype := dns.AQueryType
+	if flags.NArg() == 2 {
+		qtype, err = dns.ParseQueryType(flags.Arg(1))
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return 2
+		}
+	}
+
+	response, err := resolver.NewResolver(cfg).Resolve(context.Background(), flags.Arg(0), qtype)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return 1
+	}
+
+	if *unicode {
+		response = response.ToUnicode()
+	}
+	fmt.Println(response)
+
+	return 0
+}
+
+// openPcap creates the capture file, there is no capture when path is empty.
+func openPcap(path string) (*pcap.Writer, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating pcap file")
+	}
+
+	return pcap.NewWriter(f)
 }
@@ -0,0 +1,112 @@
+package blocklist
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/pkg/errors"
+)
+
+// Allowlist holds the names exempt from blocking. An entry allows exactly
+// its name, *.example.com allows every name below example.com but not
+// example.com itself. It is safe for concurrent use and can be reloaded while
+// queries are answered.
+type Allowlist struct {
+	path string
+
+	mu       sync.RWMutex
+	exact    map[string]struct{}
+	wildcard map[string]struct{}
+}
+
+// NewAllowlist returns an empty allowlist, Load reads the entries from path.
+func NewAllowlist(path string) *Allowlist {
+	return &Allowlist{
+		path:     path,
+		exact:    make(map[string]struct{}),
+		wildcard: make(map[string]struct{}),
+	}
+}
+
+// Load replaces the entries with the ones in the file and returns how many
+// were read. The current entries are kept when the file can't be read.
+func (a *Allowlist) Load() (int, error) {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return 0, errors.Wrap(err, "opening allowlist")
+	}
+	defer f.Close()
+
+	exact, wildcard, err := parseAllowlist(f)
+	if err != nil {
+		return 0, errors.Wrapf(err, "reading allowlist %s", a.path)
+	}
+
+	a.mu.Lock()
+	a.exact, a.wildcard = exact, wildcard
+	a.mu.Unlock()
+
+	return len(exact) + len(wildcard), nil
+}
+
+// parseAllowlist reads one entry per line, # starts a comment.
+func parseAllowlist(r io.Reader) (map[string]struct{}, map[string]struct{}, error) {
+	exact := make(map[string]struct{})
+	wildcard := make(map[string]struct{})
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		entry := scanner.Text()
+		if i := strings.IndexByte(entry, '#'); i >= 0 {
+			entry = entry[:i]
+		}
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		entries := exact
+		if strings.HasPrefix(entry, "*.") {
+			entry, entries = entry[2:], wildcard
+		}
+		name := buffer.NewDomainName(entry).Normalized()
+		if !validName(name) {
+			return nil, nil, errors.Errorf("line %d: invalid entry %q", line, scanner.Text())
+		}
+		entries[name] = struct{}{}
+	}
+
+	return exact, wildcard, scanner.Err()
+}
+
+// Allowed reports whether name is exempt from blocking.
+func (a *Allowlist) Allowed(name string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	name = buffer.NewDomainName(name).Normalized()
+	if _, ok := a.exact[name]; ok {
+		return true
+	}
+
+	labels := strings.Split(name, ".")
+	for i := 1; i < len(labels); i++ {
+		if _, ok := a.wildcard[strings.Join(labels[i:], ".")]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Len returns the number of entries.
+func (a *Allowlist) Len() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return len(a.exact) + len(a.wildcard)
+}
@@ -0,0 +1,211 @@
+// Package blocklist decides which names the server refuses to resolve.
+package blocklist
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/pkg/errors"
+)
+
+// fetchTimeout bounds the download of a blocklist.
+const fetchTimeout = time.Minute
+
+// Blocklist holds the blocked domains, blocking a domain blocks every name
+// below it too. It is safe for concurrent use and can be reloaded while
+// queries are answered.
+type Blocklist struct {
+	path string
+	// failures counts the downloads that failed
+	failures uint64
+
+	mu      sync.RWMutex
+	domains map[string]struct{}
+	// etag and modified validate the last download, the list isn't
+	// downloaded again until it changes
+	etag     string
+	modified string
+}
+
+// New returns an empty blocklist, Load reads the domains from path, a file
+// or an http or https URL.
+func New(path string) *Blocklist {
+	return &Blocklist{
+		path:    path,
+		domains: make(map[string]struct{}),
+	}
+}
+
+// Remote reports whether the blocklist is downloaded from a URL.
+func (b *Blocklist) Remote() bool {
+	return strings.HasPrefix(b.path, "http://") || strings.HasPrefix(b.path, "https://")
+}
+
+// Load replaces the domains with the ones in the file and returns how many
+// were read. The current domains are kept when the file can't be read.
+// Downloaded lists are only replaced when they changed and aren't empty.
+func (b *Blocklist) Load() (int, error) {
+	if b.Remote() {
+		n, err := b.fetch()
+		if err != nil {
+			atomic.AddUint64(&b.failures, 1)
+		}
+		return n, err
+	}
+
+	f, err := os.Open(b.path)
+	if err != nil {
+		return 0, errors.Wrap(err, "opening blocklist")
+	}
+	defer f.Close()
+
+	domains, err := parse(f)
+	if err != nil {
+		return 0, errors.Wrapf(err, "reading blocklist %s", b.path)
+	}
+
+	b.mu.Lock()
+	b.domains = domains
+	b.mu.Unlock()
+
+	return len(domains), nil
+}
+
+// fetch downloads the list unless the server says it didn't change since the
+// last download.
+func (b *Blocklist) fetch() (int, error) {
+	req, err := http.NewRequest(http.MethodGet, b.path, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "creating blocklist request")
+	}
+
+	b.mu.RLock()
+	if b.etag != "" {
+		req.Header.Set("If-None-Match", b.etag)
+	}
+	if b.modified != "" {
+		req.Header.Set("If-Modified-Since", b.modified)
+	}
+	b.mu.RUnlock()
+
+	client := &http.Client{Timeout: fetchTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, errors.Wrap(err, "downloading blocklist")
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return b.Len(), nil
+	case http.StatusOK:
+	default:
+		return 0, errors.Errorf("downloading blocklist %s: server answered %s", b.path, resp.Status)
+	}
+
+	domains, err := parse(resp.Body)
+	if err != nil {
+		return 0, errors.Wrapf(err, "reading blocklist %s", b.path)
+	}
+	// Error pages and truncated downloads mustn't unblock everything
+	if len(domains) == 0 {
+		return 0, errors.Errorf("downloaded blocklist %s has no domains", b.path)
+	}
+
+	b.mu.Lock()
+	b.domains = domains
+	b.etag = resp.Header.Get("ETag")
+	b.modified = resp.Header.Get("Last-Modified")
+	b.mu.Unlock()
+
+	return len(domains), nil
+}
+
+// Failures returns how many downloads of the list failed.
+func (b *Blocklist) Failures() uint64 {
+	return atomic.LoadUint64(&b.failures)
+}
+
+// parse reads one domain per line, lines in hosts file format like
+// "0.0.0.0 ads.example.com" are accepted too. # starts a comment, words
+// that can't be domains are skipped.
+func parse(r io.Reader) (map[string]struct{}, error) {
+	domains := make(map[string]struct{})
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) > 1 {
+			// The address of a hosts file entry is ignored
+			fields = fields[1:]
+		}
+
+		for _, name := range fields {
+			if name = buffer.NewDomainName(name).Normalized(); validName(name) {
+				domains[name] = struct{}{}
+			}
+		}
+	}
+
+	return domains, scanner.Err()
+}
+
+// validName reports whether name can be a domain, letters, digits, hyphens
+// and underscores in labels of up to 63 characters.
+func validName(name string) bool {
+	if name == "" || len(name) > 253 {
+		return false
+	}
+
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// Blocked reports whether name or one of its parent domains is blocked.
+func (b *Blocklist) Blocked(name string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if len(b.domains) == 0 {
+		return false
+	}
+
+	labels := strings.Split(buffer.NewDomainName(name).Normalized(), ".")
+	for i := range labels {
+		if _, ok := b.domains[strings.Join(labels[i:], ".")]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Len returns the number of blocked domains.
+func (b *Blocklist) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return len(b.domains)
+}
@@ -0,0 +1,131 @@
+package blocklist_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/blocklist"
+)
+
+func TestBlocklist(t *testing.T) {
+	t.Run("blocks_domains_and_subdomains", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "blocklist.txt")
+		list := "# ads\nads.example.com\n0.0.0.0 Tracker.example.net. # hosts format\n\n"
+		NoError(t, ioutil.WriteFile(path, []byte(list), 0644))
+
+		b := blocklist.New(path)
+		n, err := b.Load()
+		NoError(t, err)
+		Equal(t, 2, n)
+
+		True(t, b.Blocked("ads.example.com"))
+		True(t, b.Blocked("x.ADS.example.com."))
+		True(t, b.Blocked("tracker.example.net"))
+		False(t, b.Blocked("example.com"))
+		False(t, b.Blocked("bads.example.com"))
+	})
+
+	t.Run("failed_reload_keeps_domains", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "blocklist.txt")
+		NoError(t, ioutil.WriteFile(path, []byte("ads.example.com\n"), 0644))
+
+		b := blocklist.New(path)
+		_, err := b.Load()
+		NoError(t, err)
+
+		NoError(t, os.Remove(path))
+		_, err = b.Load()
+		Error(t, err)
+		True(t, b.Blocked("ads.example.com"))
+	})
+	t.Run("downloads_when_changed", func(t *testing.T) {
+		list := "ads.example.com\n"
+		downloads := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			etag := fmt.Sprintf("%q", list)
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			downloads++
+			w.Header().Set("ETag", etag)
+			w.Write([]byte(list))
+		}))
+		defer server.Close()
+
+		b := blocklist.New(server.URL + "/list.txt")
+		True(t, b.Remote())
+		n, err := b.Load()
+		NoError(t, err)
+		Equal(t, 1, n)
+
+		n, err = b.Load()
+		NoError(t, err)
+		Equal(t, 1, n)
+		Equal(t, 1, downloads, "unchanged list isn't downloaded again")
+
+		list = "ads.example.com\ntracker.example.net\n"
+		n, err = b.Load()
+		NoError(t, err)
+		Equal(t, 2, n)
+		True(t, b.Blocked("tracker.example.net"))
+	})
+
+	t.Run("invalid_downloads_keep_domains", func(t *testing.T) {
+		body, status := "ads.example.com\n", http.StatusOK
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+		}))
+		defer server.Close()
+
+		b := blocklist.New(server.URL)
+		_, err := b.Load()
+		NoError(t, err)
+
+		body = "<html><body>Maintenance</body></html>\n"
+		_, err = b.Load()
+		Error(t, err)
+
+		status = http.StatusInternalServerError
+		_, err = b.Load()
+		Error(t, err)
+
+		True(t, b.Blocked("ads.example.com"))
+		Equal(t, uint64(2), b.Failures())
+	})
+}
+
+func TestAllowlist(t *testing.T) {
+	t.Run("exact_and_wildcard_entries", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "allowlist.txt")
+		list := "# false positives\ncdn.ads.example.com\n*.Tracker.example.net # every subdomain\n\n"
+		NoError(t, ioutil.WriteFile(path, []byte(list), 0644))
+
+		a := blocklist.NewAllowlist(path)
+		n, err := a.Load()
+		NoError(t, err)
+		Equal(t, 2, n)
+
+		True(t, a.Allowed("CDN.ads.example.com."))
+		False(t, a.Allowed("x.cdn.ads.example.com"))
+		True(t, a.Allowed("a.b.tracker.example.net"))
+		False(t, a.Allowed("tracker.example.net"))
+		False(t, a.Allowed("ads.example.com"))
+	})
+
+	t.Run("invalid_entries", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "allowlist.txt")
+		NoError(t, ioutil.WriteFile(path, []byte("ok.example.com\n<html>\n"), 0644))
+
+		_, err := blocklist.NewAllowlist(path).Load()
+		Error(t, err)
+	})
+}
@@ -0,0 +1,20 @@
+package buffer
+
+import (
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrTruncated is returned when reading past the end of the packet.
+	ErrTruncated = errors.New("packet truncated")
+	// ErrBufferOverflow is returned when a write doesn't fit in the buffer.
+	ErrBufferOverflow = errors.New("buffer overflow")
+	// ErrLabelTooLong is returned when writing a label longer than 63 bytes.
+	ErrLabelTooLong = errors.New("label exceeds 63 bytes")
+	// ErrMaxJumps is returned when a name follows more than MAX_JUMPS
+	// compression pointers.
+	ErrMaxJumps = errors.New("too many compression pointers")
+	// ErrMalformedName is returned by ReadQname for names that can't be
+	// decoded, such as compression pointers that loop or point forward.
+	ErrMalformedName = errors.New("malformed domain name")
+)
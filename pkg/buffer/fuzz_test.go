@@ -0,0 +1,35 @@
+package buffer_test
+
+import (
+	"testing"
+
+	"github.com/msarvar/godns/pkg/buffer"
+)
+
+// FuzzReadQname reads a name from arbitrary bytes at an arbitrary offset.
+func FuzzReadQname(f *testing.F) {
+	f.Add([]byte{3, 'w', 'w', 'w', 6, 'g', 'o', 'o', 'g', 'l', 'e', 3, 'c', 'o', 'm', 0}, 0)
+	f.Add([]byte{3, 'c', 'o', 'm', 0, 6, 'g', 'o', 'o', 'g', 'l', 'e', 0xC0, 0}, 5)
+	f.Add([]byte{0xC0, 0}, 0)
+
+	f.Fuzz(func(t *testing.T, data []byte, pos int) {
+		buf := buffer.NewBytePacketBuffer()
+		buf.Buf = data
+		buf.Seek(pos)
+
+		qname := buffer.NewDomainName("")
+		if err := buf.ReadQname(qname); err != nil {
+			return
+		}
+
+		if len(qname.String()) > buffer.MAX_NAME_LENGTH {
+			t.Fatalf("name of %d bytes exceeds limit", len(qname.String()))
+		}
+
+		// Names that were read must be writable again
+		out := buffer.NewBytePacketBuffer()
+		if err := out.WriteQname(qname); err != nil {
+			t.Fatalf("writing name %q: %s", qname, err)
+		}
+	})
+}
@@ -1,7 +1,6 @@
 package buffer
 
 import (
-	"fmt"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -9,25 +8,81 @@ import (
 
 const (
 	MAX_JUMPS = 5
+	// MAX_NAME_LENGTH is the limit of a domain name in wire format (RFC1035)
+	MAX_NAME_LENGTH = 255
 )
 
+// NewDomainName creates a domain name from its dotted form. A trailing dot is
+// accepted and dropped, the case of the name is kept as is.
 func NewDomainName(qName string) *DomainName {
 	return &DomainName{
-		str: qName,
+		str: strings.TrimSuffix(qName, "."),
 	}
 }
 
+// DomainName is a domain name without the trailing dot, the root domain is
+// the empty string. Comparisons are case-insensitive as required by RFC4343.
 type DomainName struct {
-	str string
+	str    string
+	labels []string
 }
 
 func (n *DomainName) String() string {
 	return n.str
 }
 
+// Normalized returns the lowercased name, suitable as a map key.
+func (n *DomainName) Normalized() string {
+	return strings.ToLower(n.str)
+}
+
+// SplitLabels returns the labels of the name from left to right, the root
+// domain has none.
+func (n *DomainName) SplitLabels() []string {
+	if n.labels == nil && n.str != "" {
+		n.labels = strings.Split(n.str, ".")
+	}
+
+	return n.labels
+}
+
+// Equal reports whether both names are the same ignoring case.
+func (n *DomainName) Equal(other *DomainName) bool {
+	return other != nil && strings.EqualFold(n.str, other.str)
+}
+
+// IsSubdomainOf reports whether n is parent or lies below it. Only whole
+// labels match, "notexample.com" is not a subdomain of "example.com".
+func (n *DomainName) IsSubdomainOf(parent *DomainName) bool {
+	if parent == nil {
+		return false
+	}
+
+	if parent.str == "" {
+		return true
+	}
+
+	if len(n.str) < len(parent.str) || !strings.EqualFold(n.str[len(n.str)-len(parent.str):], parent.str) {
+		return false
+	}
+
+	return len(n.str) == len(parent.str) || n.str[len(n.str)-len(parent.str)-1] == '.'
+}
+
+// maxPointer is the furthest offset a compression pointer reaches.
+const maxPointer = 0x3FFF
+
+// PacketSize is the size of new and pooled buffers, a DNS message over UDP
+// without EDNS (RFC1035 2.3.4). Larger messages need SetSize.
+const PacketSize = 512
+
+// MaxPooledSize bounds the buffers kept by the pool, enough for EDNS
+// datagrams and most TCP messages. Larger buffers are dropped on release.
+const MaxPooledSize = 4096
+
 func NewBytePacketBuffer() *BytePacketBuffer {
 	return &BytePacketBuffer{
-		Buf:    make([]uint8, 512),
+		Buf:    make([]uint8, PacketSize),
 		lookup: map[string]int{},
 		pos:    0,
 	}
@@ -37,6 +92,31 @@ type BytePacketBuffer struct {
 	Buf    []uint8
 	pos    int
 	lookup map[string]int
+	// uncompressed makes WriteQname write every name in full
+	uncompressed bool
+	// used is the largest size set since the buffer was reset, nothing was
+	// written past it
+	used int
+}
+
+// DisableCompression makes the names written from now on never use or offer
+// compression pointers, as required by canonical forms (RFC4034 6.2).
+func (b *BytePacketBuffer) DisableCompression() {
+	b.uncompressed = true
+}
+
+// SetSize makes the buffer hold size bytes, writing past them fails with
+// ErrBufferOverflow. The content up to the smaller of both sizes is kept.
+func (b *BytePacketBuffer) SetSize(size int) {
+	if cap(b.Buf) < size {
+		buf := make([]uint8, size)
+		copy(buf, b.Buf)
+		b.Buf = buf
+	}
+	b.Buf = b.Buf[:size]
+	if size > b.used {
+		b.used = size
+	}
 }
 
 func (b *BytePacketBuffer) Pos() int {
@@ -51,9 +131,20 @@ func (b *BytePacketBuffer) Seek(pos int) {
 	b.pos = pos
 }
 
+// Truncate moves back to pos and forgets the names written after it, so that
+// names written later aren't compressed against discarded bytes.
+func (b *BytePacketBuffer) Truncate(pos int) {
+	for name, at := range b.lookup {
+		if at >= pos {
+			delete(b.lookup, name)
+		}
+	}
+	b.pos = pos
+}
+
 func (b *BytePacketBuffer) Get(pos int) (uint8, error) {
-	if pos >= 512 {
-		return 0, errors.New("end of buffer")
+	if pos < 0 || pos >= len(b.Buf) {
+		return 0, ErrTruncated
 	}
 
 	return b.Buf[pos], nil
@@ -69,23 +160,23 @@ func (b *BytePacketBuffer) Set16(pos int, value uint16) {
 }
 
 func (b *BytePacketBuffer) GetRangeAtPos() ([]uint8, error) {
-	if b.pos >= 512 {
-		return nil, errors.New("buffer overflow")
+	if b.pos > len(b.Buf) {
+		return nil, ErrBufferOverflow
 	}
 	return b.Buf[0:b.pos], nil
 }
 
-func (b *BytePacketBuffer) GetRange(start int, len int) ([]uint8, error) {
-	if start+len >= 512 {
-		return nil, errors.New("buffer overflow")
+func (b *BytePacketBuffer) GetRange(start int, length int) ([]uint8, error) {
+	if start < 0 || length < 0 || start+length > len(b.Buf) {
+		return nil, ErrTruncated
 	}
 
-	return b.Buf[start : start+len], nil
+	return b.Buf[start : start+length], nil
 }
 
 func (b *BytePacketBuffer) Read() (uint8, error) {
-	if b.pos >= 512 {
-		return 0, errors.New("buffer overflow")
+	if b.pos < 0 || b.pos >= len(b.Buf) {
+		return 0, ErrTruncated
 	}
 
 	res := b.Buf[b.pos]
@@ -143,14 +234,19 @@ func (b *BytePacketBuffer) ReadQname(qname *DomainName) error {
 
 	jumped := false
 	jumps_performed := 0
-	delim := ""
+	// Pointers must point strictly before every pointer followed so far,
+	// which rules out loops. The jump limit bounds the work besides.
+	lowest := pos
+
+	name := make([]byte, 0, MAX_NAME_LENGTH)
+	labels := make([]string, 0, 4)
 
 	for {
 		if jumps_performed > MAX_JUMPS {
-			return errors.New(fmt.Sprintf("Limit of %d max jumps exceeded", MAX_JUMPS))
+			return errors.Wrapf(ErrMaxJumps, "limit of %d jumps exceeded", MAX_JUMPS)
 		}
 
-		len, err := b.Get(pos)
+		length, err := b.Get(pos)
 		if err != nil {
 			return errors.Wrap(err, "reading query name")
 		}
@@ -159,7 +255,7 @@ func (b *BytePacketBuffer) ReadQname(qname *DomainName) error {
 		// to other part of the packet.
 		// 11000000 -> MSBs are set
 		// 00001100 -> MSB are not set
-		if (len & 0xC0) == 0xC0 {
+		if (length & 0xC0) == 0xC0 {
 			// If no jumps were performed put the cursor 2 positions ahead.
 			if !jumped {
 				b.Seek(pos + 2)
@@ -171,30 +267,49 @@ func (b *BytePacketBuffer) ReadQname(qname *DomainName) error {
 			}
 			// bitwise xor
 			// 11000000^11000000 = 00000000
-			offset := uint16(len^0xC0)<<8 | uint16(b2)
-			pos = int(offset)
+			offset := int(uint16(length^0xC0)<<8 | uint16(b2))
+			if offset >= lowest {
+				return errors.Wrapf(ErrMalformedName, "compression pointer at %d points forward to %d", pos, offset)
+			}
+			lowest = offset
+			pos = offset
 
 			// Jump was performed and loop continues to next part
 			jumped = true
 			jumps_performed += 1
 			continue
+		} else if length&0xC0 != 0 {
+			// 01 and 10 prefixes are reserved label types, a plain label
+			// is never longer than 63 bytes
+			return errors.Wrapf(ErrMalformedName, "unsupported label type %#x", length&0xC0)
 		} else {
 			pos += 1
 
-			if len == 0 {
+			if length == 0 {
 				break
 			}
 
-			qname.str = fmt.Sprintf("%s%s", qname.str, delim)
-			str_buffer, err := b.GetRange(pos, int(len))
+			label, err := b.GetRange(pos, int(length))
 			if err != nil {
 				return errors.Wrap(err, "reading the label")
 			}
-			qname.str = fmt.Sprintf("%s%s", qname.str, str_buffer)
 
-			delim = "."
+			if err := validateLabel(label); err != nil {
+				return err
+			}
+
+			if len(name) != 0 {
+				name = append(name, '.')
+			}
+			name = append(name, label...)
+			labels = append(labels, string(label))
+
+			// Wire length counts every label's length byte and the root label
+			if len(name)+2 > MAX_NAME_LENGTH {
+				return errors.Wrapf(ErrMalformedName, "domain name exceeds %d bytes", MAX_NAME_LENGTH)
+			}
 
-			pos += int(len)
+			pos += int(length)
 		}
 	}
 
@@ -202,6 +317,21 @@ func (b *BytePacketBuffer) ReadQname(qname *DomainName) error {
 		b.Seek(pos)
 	}
 
+	qname.str = string(name)
+	qname.labels = labels
+
+	return nil
+}
+
+// validateLabel rejects labels that can't be represented in the dotted form,
+// a dot would split the label and control characters corrupt output.
+func validateLabel(label []byte) error {
+	for _, c := range label {
+		if c == '.' || c < 0x20 || c == 0x7F {
+			return errors.Wrapf(ErrMalformedName, "label %q contains invalid character %#x", label, c)
+		}
+	}
+
 	return nil
 }
 
@@ -219,8 +349,8 @@ func (b *BytePacketBuffer) Write(p []byte) (n int, err error) {
 }
 
 func (b *BytePacketBuffer) writePacketByte(value uint8) error {
-	if b.pos >= 512 {
-		return errors.New("end of buffer")
+	if b.pos < 0 || b.pos >= len(b.Buf) {
+		return ErrBufferOverflow
 	}
 
 	b.Buf[b.pos] = value
@@ -272,12 +402,23 @@ func (b *BytePacketBuffer) Write32(value uint32) error {
 }
 
 func (b *BytePacketBuffer) WriteQname(qname *DomainName) error {
-	names := strings.Split(qname.str, ".")
-	size := len(names)
+	if b.uncompressed {
+		return b.WriteQnameUncompressed(qname)
+	}
+
+	name := qname.str
+
+	// Root domain is encoded as a single zero length label
+	if name == "" {
+		return errors.Wrap(b.Write8(0), "writing root domain")
+	}
+
+	names := strings.Split(name, ".")
 	jumpPerformed := false
 
 	for i, label := range names {
-		searchLabel := strings.Join(names[i:size-1], ".")
+		// Compression is case-insensitive like every name comparison
+		searchLabel := strings.ToLower(strings.Join(names[i:], "."))
 		if pos, ok := b.lookup[searchLabel]; ok {
 			jumpInst := uint16(pos) | 0xC000
 			err := b.Write16(jumpInst)
@@ -288,12 +429,14 @@ func (b *BytePacketBuffer) WriteQname(qname *DomainName) error {
 			break
 		}
 
-		pos := b.Pos()
-		b.lookup[searchLabel] = pos
+		// Pointers have 14 bits, names further in can't be pointed at
+		if pos := b.Pos(); pos <= maxPointer {
+			b.lookup[searchLabel] = pos
+		}
 
 		len := len(label)
 		if len > 0x3f {
-			return errors.New("single label exceeds 63 character of length")
+			return errors.Wrapf(ErrLabelTooLong, "label %q", label)
 		}
 
 		err := b.Write8(uint8(len))
@@ -318,3 +461,25 @@ func (b *BytePacketBuffer) WriteQname(qname *DomainName) error {
 
 	return nil
 }
+
+// WriteQnameUncompressed writes the name label by label without compression
+// pointers, as required for the names in some record types like SVCB.
+func (b *BytePacketBuffer) WriteQnameUncompressed(qname *DomainName) error {
+	for _, label := range qname.SplitLabels() {
+		if len(label) > 0x3f {
+			return errors.Wrapf(ErrLabelTooLong, "label %q", label)
+		}
+
+		err := b.Write8(uint8(len(label)))
+		if err != nil {
+			return errors.Wrap(err, "writing single label")
+		}
+
+		_, err = b.Write([]byte(label))
+		if err != nil {
+			return errors.Wrap(err, "writing domain name")
+		}
+	}
+
+	return errors.Wrap(b.Write8(0), "writing last byte")
+}
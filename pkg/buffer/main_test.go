@@ -1,6 +1,7 @@
 package buffer_test
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/msarvar/godns/pkg/buffer"
@@ -54,6 +55,22 @@ func TestNewBytePacketBuffer_WriteQname(t *testing.T) {
 		Equal(t, byte(0xC0), buf.Buf[22])
 		Equal(t, byte(11), buf.Buf[23])
 	})
+
+	t.Run("write_qname_with_shared_prefix", func(t *testing.T) {
+		buf := buffer.NewBytePacketBuffer()
+		buf.WriteQname(buffer.NewDomainName("www.google.com"))
+		buf.WriteQname(buffer.NewDomainName("www.google.org"))
+		buf.Seek(0)
+
+		// only whole suffixes may be compressed
+		qname1 := buffer.NewDomainName("")
+		buf.ReadQname(qname1)
+		Equal(t, "www.google.com", qname1.String())
+
+		qname2 := buffer.NewDomainName("")
+		buf.ReadQname(qname2)
+		Equal(t, "www.google.org", qname2.String())
+	})
 }
 
 func TestNewBytePacketBuffer_ReadQname(t *testing.T) {
@@ -118,6 +135,49 @@ func TestNewBytePacketBuffer_ReadQname(t *testing.T) {
 		buf.ReadQname(qname2)
 		Equal(t, "yahoo.com", qname2.String())
 	})
+
+	t.Run("read_qname_labels", func(t *testing.T) {
+		buf := buffer.NewBytePacketBuffer()
+		buf.WriteQname(buffer.NewDomainName("www.google.com"))
+		buf.Seek(0)
+
+		qname := buffer.NewDomainName("")
+		NoError(t, buf.ReadQname(qname))
+		Equal(t, []string{"www", "google", "com"}, qname.SplitLabels())
+		Equal(t, 16, buf.Pos())
+	})
+
+	t.Run("read_qname_with_invalid_label", func(t *testing.T) {
+		buf := buffer.NewBytePacketBuffer()
+		buf.Write([]byte{3, 'w', '.', 'w', 0})
+		buf.Seek(0)
+
+		Error(t, buf.ReadQname(buffer.NewDomainName("")))
+	})
+
+	t.Run("read_qname_with_pointer_loop", func(t *testing.T) {
+		buf := buffer.NewBytePacketBuffer()
+		// "a" followed by a pointer back to itself
+		buf.Write([]byte{1, 'a', 0xC0, 0})
+		buf.Seek(0)
+
+		ErrorIs(t, buf.ReadQname(buffer.NewDomainName("")), buffer.ErrMalformedName)
+	})
+
+	t.Run("read_qname_with_forward_pointer", func(t *testing.T) {
+		buf := buffer.NewBytePacketBuffer()
+		buf.Write([]byte{0xC0, 2, 3, 'c', 'o', 'm', 0})
+		buf.Seek(0)
+
+		ErrorIs(t, buf.ReadQname(buffer.NewDomainName("")), buffer.ErrMalformedName)
+	})
+
+	t.Run("read_qname_past_the_end", func(t *testing.T) {
+		buf := buffer.NewBytePacketBuffer()
+		buf.Buf = []byte{3, 'c', 'o'}
+
+		ErrorIs(t, buf.ReadQname(buffer.NewDomainName("")), buffer.ErrTruncated)
+	})
 }
 
 func TestNewBytePacketBuffer_Write(t *testing.T) {
@@ -134,4 +194,110 @@ func TestNewBytePacketBuffer_Write(t *testing.T) {
 		Equal(t, byte(255), buf.Buf[2])
 		Equal(t, byte(255), buf.Buf[3])
 	})
+
+	t.Run("write_past_the_end", func(t *testing.T) {
+		buf := buffer.NewBytePacketBuffer()
+		buf.Seek(len(buf.Buf) - 1)
+
+		ErrorIs(t, buf.Write16(1), buffer.ErrBufferOverflow)
+	})
+
+	t.Run("truncate_forgets_compressed_names", func(t *testing.T) {
+		buf := buffer.NewBytePacketBuffer()
+		NoError(t, buf.WriteQname(buffer.NewDomainName("example.com")))
+		end := buf.Pos()
+		NoError(t, buf.WriteQname(buffer.NewDomainName("www.example.org")))
+
+		buf.Truncate(end)
+		Equal(t, end, buf.Pos())
+
+		// example.org was discarded and is written in full again
+		NoError(t, buf.WriteQname(buffer.NewDomainName("example.org")))
+		Equal(t, end+len("example.org")+2, buf.Pos())
+	})
+
+	t.Run("names_past_pointer_range_are_not_compressed_against", func(t *testing.T) {
+		buf := buffer.NewBytePacketBuffer()
+		buf.SetSize(0x5000)
+		buf.Seek(0x4000)
+		NoError(t, buf.WriteQname(buffer.NewDomainName("example.com")))
+		end := buf.Pos()
+
+		// A pointer to 0x4000 would read as 0x0000, the name is written again
+		NoError(t, buf.WriteQname(buffer.NewDomainName("example.com")))
+		Equal(t, end+len("example.com")+2, buf.Pos())
+	})
+
+	t.Run("write_label_too_long", func(t *testing.T) {
+		buf := buffer.NewBytePacketBuffer()
+		name := buffer.NewDomainName(strings.Repeat("a", 64) + ".com")
+
+		ErrorIs(t, buf.WriteQname(name), buffer.ErrLabelTooLong)
+	})
+}
+
+func TestBytePacketBuffer_Pool(t *testing.T) {
+	t.Run("released_buffer_is_reset", func(t *testing.T) {
+		buf := buffer.AcquireBytePacketBuffer()
+		buf.WriteQname(buffer.NewDomainName("www.google.com"))
+		buf.Release()
+
+		buf = buffer.AcquireBytePacketBuffer()
+		defer buf.Release()
+
+		Equal(t, 0, buf.Pos())
+		Equal(t, 512, len(buf.Buf))
+		Equal(t, byte(0), buf.Buf[0])
+
+		// no compression pointers into the previous packet
+		buf.WriteQname(buffer.NewDomainName("google.com"))
+		Equal(t, byte(6), buf.Buf[0])
+	})
+
+	t.Run("grown_buffer_is_reset", func(t *testing.T) {
+		buf := buffer.NewBytePacketBuffer()
+		buf.Buf[0] = 1
+		buf.SetSize(1232)
+		Equal(t, 1232, len(buf.Buf))
+		Equal(t, byte(1), buf.Buf[0], "content is kept")
+
+		// Shrinking to the message read doesn't hide what was written
+		buf.Buf[1231] = 1
+		buf.SetSize(100)
+		buf.Reset()
+		Equal(t, buffer.PacketSize, len(buf.Buf))
+
+		buf.SetSize(1232)
+		Equal(t, byte(0), buf.Buf[1231])
+	})
+
+	t.Run("large_buffer_is_dropped", func(t *testing.T) {
+		buf := buffer.NewBytePacketBuffer()
+		buf.SetSize(65535)
+		buf.Buf[65534] = 1
+		buf.Reset()
+		Equal(t, buffer.PacketSize, len(buf.Buf))
+		Equal(t, buffer.PacketSize, cap(buf.Buf))
+	})
+}
+
+func TestDomainName(t *testing.T) {
+	t.Run("normalizes_trailing_dot_and_case", func(t *testing.T) {
+		name := buffer.NewDomainName("WWW.Google.com.")
+		Equal(t, "WWW.Google.com", name.String())
+		Equal(t, "www.google.com", name.Normalized())
+		True(t, name.Equal(buffer.NewDomainName("www.google.com")))
+		Equal(t, []string{"WWW", "Google", "com"}, name.SplitLabels())
+		Equal(t, 0, len(buffer.NewDomainName(".").SplitLabels()))
+	})
+
+	t.Run("subdomains_match_whole_labels", func(t *testing.T) {
+		example := buffer.NewDomainName("example.com")
+
+		True(t, buffer.NewDomainName("www.Example.com").IsSubdomainOf(example))
+		True(t, buffer.NewDomainName("example.com.").IsSubdomainOf(example))
+		True(t, example.IsSubdomainOf(buffer.NewDomainName("")))
+		False(t, buffer.NewDomainName("notexample.com").IsSubdomainOf(example))
+		False(t, buffer.NewDomainName("com").IsSubdomainOf(example))
+	})
 }
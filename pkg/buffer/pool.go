@@ -0,0 +1,59 @@
+package buffer
+
+import (
+	"sync"
+)
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return NewBytePacketBuffer()
+	},
+}
+
+// AcquireBytePacketBuffer returns an empty buffer from a shared pool. It must
+// be handed back with Release once neither the buffer nor any slice taken
+// from it is used anymore.
+func AcquireBytePacketBuffer() *BytePacketBuffer {
+	return bufferPool.Get().(*BytePacketBuffer)
+}
+
+// Release resets the buffer and returns it to the pool.
+func (b *BytePacketBuffer) Release() {
+	b.Reset()
+	bufferPool.Put(b)
+}
+
+// Reset empties the buffer so it can be reused for another packet, back to
+// PacketSize bytes. The bytes used are zeroed so a short read never exposes
+// data of a previous packet, buffers grown past MaxPooledSize are replaced
+// rather than zeroed and kept.
+func (b *BytePacketBuffer) Reset() {
+	used := b.used
+	if len(b.Buf) > used {
+		used = len(b.Buf)
+	}
+	if used < PacketSize {
+		used = PacketSize
+	}
+	// Buf may have been replaced by a smaller slice since
+	if used > cap(b.Buf) {
+		used = cap(b.Buf)
+	}
+
+	if cap(b.Buf) < PacketSize || cap(b.Buf) > MaxPooledSize {
+		b.Buf = make([]uint8, PacketSize)
+	} else {
+		b.Buf = b.Buf[:used]
+		for i := range b.Buf {
+			b.Buf[i] = 0
+		}
+		b.Buf = b.Buf[:PacketSize]
+	}
+	b.used = 0
+
+	for k := range b.lookup {
+		delete(b.lookup, k)
+	}
+	b.pos = 0
+	b.uncompressed = false
+}
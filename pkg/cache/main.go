@@ -0,0 +1,502 @@
+// Package cache keeps resolved responses until their TTL expires.
+package cache
+
+import (
+	"container/list"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+// StaleTTL is the TTL of records in stale answers, RFC8767 recommends 30
+// seconds.
+const StaleTTL = 30
+
+// Cache stores final responses keyed by name, record type and, for answers an
+// upstream scoped with EDNS Client Subnet, the client network. Implementations
+// are safe for concurrent use.
+type Cache interface {
+	// Get returns a copy of the cached response with the TTLs reduced by the
+	// time it spent in the cache
+	Get(name string, qtype dns.QueryType, subnet *dns.ClientSubnet, now time.Time) (*dns.DNSPacket, bool)
+	// Put stores the response for as long as its TTL allows
+	Put(name string, qtype dns.QueryType, subnet *dns.ClientSubnet, response *dns.DNSPacket, now time.Time)
+	// Flush drops every entry and returns how many there were
+	Flush() int
+	// Len returns the number of cached responses
+	Len() int
+}
+
+// StaleCache is a Cache keeping expired responses to answer with when the
+// upstreams fail (RFC8767).
+type StaleCache interface {
+	GetStale(name string, qtype dns.QueryType, subnet *dns.ClientSubnet, now time.Time) (*dns.DNSPacket, bool)
+}
+
+// Prefetcher is a Cache refreshing popular entries ahead of their expiry.
+type Prefetcher interface {
+	Prefetch(name string, qtype dns.QueryType, subnet *dns.ClientSubnet, now time.Time) bool
+}
+
+// Config holds the cache settings.
+type Config struct {
+	// MaxStale is how long expired responses are kept to answer when the
+	// upstreams can't be reached (RFC8767), zero disables serving stale
+	MaxStale time.Duration
+	// PrefetchHits is how often an entry must be requested before it is
+	// refreshed ahead of its expiry, zero disables prefetching
+	PrefetchHits int
+	// MaxEntries and MaxBytes bound the number of responses and their
+	// estimated size, the least recently used ones are evicted first. Zero
+	// leaves them unbounded
+	MaxEntries int
+	MaxBytes   int
+}
+
+// Stats are counters of a Memory cache.
+type Stats struct {
+	Entries int
+	// Bytes is the estimated size of the cached responses
+	Bytes     int
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+// Memory is a Cache in memory evicting the least recently used responses
+// once it holds MaxEntries or MaxBytes, so that clients asking for random
+// names can't grow it without bounds.
+type Memory struct {
+	mu           sync.Mutex
+	entries      map[key]*entry
+	maxStale     time.Duration
+	prefetchHits int
+
+	// lru has the keys of the entries, most recently used first
+	lru        *list.List
+	maxEntries int
+	maxBytes   int
+	bytes      int
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+type key struct {
+	name  string
+	qtype dns.QueryType
+	// subnet is empty for answers valid for every client
+	subnet string
+}
+
+type entry struct {
+	packet  *dns.DNSPacket
+	stored  time.Time
+	expires time.Time
+	size    int
+	elem    *list.Element
+
+	hits        int
+	prefetching bool
+}
+
+func New(cfg *Config) *Memory {
+	return &Memory{
+		entries:      make(map[key]*entry),
+		maxStale:     cfg.MaxStale,
+		prefetchHits: cfg.PrefetchHits,
+		lru:          list.New(),
+		maxEntries:   cfg.MaxEntries,
+		maxBytes:     cfg.MaxBytes,
+	}
+}
+
+func subnetKey(ip net.IP, prefix uint8) string {
+	s := dns.NewClientSubnet(ip, prefix)
+	return fmt.Sprintf("%s/%d", s.Address, s.SourcePrefix)
+}
+
+// Get returns a copy of the cached response with the TTLs reduced by the time
+// it spent in the cache. Answers scoped to a network containing the client
+// subnet are preferred over answers valid for everyone.
+func (c *Memory) Get(name string, qtype dns.QueryType, subnet *dns.ClientSubnet, now time.Time) (*dns.DNSPacket, bool) {
+	c.mu.Lock()
+	e, ok := c.find(name, qtype, subnet, now, false)
+	if ok {
+		e.hits++
+		c.hits++
+	} else {
+		c.misses++
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		return nil, false
+	}
+
+	return age(e.packet, uint32(now.Sub(e.stored)/time.Second)), true
+}
+
+// Prefetch reports whether the entry is popular and has less than a tenth
+// of its TTL left. The entry is then marked as being refreshed so that only
+// the first caller refreshes it, storing the new response ends the refresh.
+func (c *Memory) Prefetch(name string, qtype dns.QueryType, subnet *dns.ClientSubnet, now time.Time) bool {
+	if c.prefetchHits == 0 {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.find(name, qtype, subnet, now, false)
+	if !ok || e.prefetching || e.hits < c.prefetchHits {
+		return false
+	}
+
+	if 10*e.expires.Sub(now) > e.expires.Sub(e.stored) {
+		return false
+	}
+
+	e.prefetching = true
+	return true
+}
+
+// GetStale is Get for when the upstreams failed, it also returns responses
+// that expired less than MaxStale ago. Their records get StaleTTL.
+func (c *Memory) GetStale(name string, qtype dns.QueryType, subnet *dns.ClientSubnet, now time.Time) (*dns.DNSPacket, bool) {
+	c.mu.Lock()
+	e, ok := c.find(name, qtype, subnet, now, true)
+	c.mu.Unlock()
+
+	if !ok {
+		return nil, false
+	}
+
+	if now.Before(e.expires) {
+		return age(e.packet, uint32(now.Sub(e.stored)/time.Second)), true
+	}
+
+	return stale(e.packet), true
+}
+
+// find looks the entry up trying the subnets containing the client subnet
+// from the most specific one down to answers valid for everyone. The caller
+// holds the lock.
+func (c *Memory) find(name string, qtype dns.QueryType, subnet *dns.ClientSubnet, now time.Time, allowStale bool) (*entry, bool) {
+	k := key{name: buffer.NewDomainName(name).Normalized(), qtype: qtype}
+	if subnet != nil {
+		for prefix := int(subnet.SourcePrefix); prefix > 0; prefix-- {
+			k.subnet = subnetKey(subnet.Address, uint8(prefix))
+			if e, ok := c.get(k, now, allowStale); ok {
+				return e, true
+			}
+		}
+		k.subnet = ""
+	}
+
+	return c.get(k, now, allowStale)
+}
+
+func (c *Memory) get(k key, now time.Time, allowStale bool) (*entry, bool) {
+	e, ok := c.entries[k]
+	if !ok {
+		return nil, false
+	}
+
+	if now.Before(e.expires) {
+		c.lru.MoveToFront(e.elem)
+		return e, true
+	}
+
+	if now.Before(e.expires.Add(c.maxStale)) {
+		if allowStale {
+			c.lru.MoveToFront(e.elem)
+		}
+		return e, allowStale
+	}
+
+	c.remove(k, e)
+	return nil, false
+}
+
+// insert adds the entry as the most recently used one, replacing the entry of
+// the same key, and evicts the least recently used entries while the cache
+// is too large. The caller holds the lock.
+func (c *Memory) insert(k key, e *entry) {
+	if old, ok := c.entries[k]; ok {
+		c.remove(k, old)
+	}
+
+	e.size = size(k, e.packet)
+	e.elem = c.lru.PushFront(k)
+	c.entries[k] = e
+	c.bytes += e.size
+
+	for c.lru.Len() > 1 && ((c.maxEntries > 0 && c.lru.Len() > c.maxEntries) || (c.maxBytes > 0 && c.bytes > c.maxBytes)) {
+		oldest := c.lru.Back().Value.(key)
+		c.remove(oldest, c.entries[oldest])
+		c.evictions++
+	}
+}
+
+// remove drops the entry. The caller holds the lock.
+func (c *Memory) remove(k key, e *entry) {
+	c.lru.Remove(e.elem)
+	delete(c.entries, k)
+	c.bytes -= e.size
+}
+
+// Put stores the response for as long as its TTL allows, responses that
+// can't be cached are ignored. subnet is the client subnet sent upstream, the
+// scope of the upstream answer narrows it further.
+func (c *Memory) Put(name string, qtype dns.QueryType, subnet *dns.ClientSubnet, response *dns.DNSPacket, now time.Time) {
+	ttl, ok := TTL(response)
+	if !ok || ttl == 0 {
+		return
+	}
+
+	k := key{name: buffer.NewDomainName(name).Normalized(), qtype: qtype}
+	if ecs, err := response.ClientSubnet(); err == nil && ecs != nil && ecs.ScopePrefix > 0 && subnet != nil {
+		prefix := ecs.ScopePrefix
+		if prefix > subnet.SourcePrefix {
+			prefix = subnet.SourcePrefix
+		}
+		k.subnet = subnetKey(subnet.Address, prefix)
+	}
+
+	// The caller keeps using the response, store a copy whose RRsets each
+	// have a single TTL
+	packet := age(response, 0)
+	for _, records := range [][]*dns.DNSRecord{packet.Answers, packet.Authorities, packet.Resources} {
+		dns.HarmonizeTTLs(records)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.insert(k, &entry{
+		packet:  packet,
+		stored:  now,
+		expires: now.Add(time.Duration(ttl) * time.Second),
+	})
+}
+
+// Flush drops every entry and returns how many there were.
+func (c *Memory) Flush() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := len(c.entries)
+	c.entries = make(map[key]*entry)
+	c.lru.Init()
+	c.bytes = 0
+
+	return n
+}
+
+// FlushName drops the entries of name for every record type and subnet and
+// returns how many there were.
+func (c *Memory) FlushName(name string) int {
+	normalized := buffer.NewDomainName(name).Normalized()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for k, e := range c.entries {
+		if k.name == normalized {
+			c.remove(k, e)
+			n++
+		}
+	}
+
+	return n
+}
+
+// Len returns the number of cached responses, expired ones included until
+// they are looked up again.
+func (c *Memory) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries)
+}
+
+// Stats returns the size of the cache and how often it was used.
+func (c *Memory) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return Stats{
+		Entries:   len(c.entries),
+		Bytes:     c.bytes,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+}
+
+// Summary describes what the cache holds, for debugging.
+type Summary struct {
+	Stats
+	// Types counts the entries by query type
+	Types map[dns.QueryType]int
+	// Expired counts the entries kept past their TTL to answer stale
+	Expired int
+	// Hottest are the most often hit entries, most hits first
+	Hottest []HotEntry
+}
+
+// HotEntry is a cached response and how often it was hit.
+type HotEntry struct {
+	Name string
+	Type dns.QueryType
+	Hits int
+}
+
+// Summarize returns what the cache holds at now with its n most hit entries.
+func (c *Memory) Summarize(now time.Time, n int) Summary {
+	summary := Summary{Stats: c.Stats(), Types: make(map[dns.QueryType]int)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k, e := range c.entries {
+		summary.Types[k.qtype]++
+		if !now.Before(e.expires) {
+			summary.Expired++
+		}
+		if e.hits > 0 {
+			summary.Hottest = append(summary.Hottest, HotEntry{Name: k.name, Type: k.qtype, Hits: e.hits})
+		}
+	}
+
+	sort.Slice(summary.Hottest, func(i, j int) bool {
+		return summary.Hottest[i].Hits > summary.Hottest[j].Hits
+	})
+	if len(summary.Hottest) > n {
+		summary.Hottest = summary.Hottest[:n]
+	}
+
+	return summary
+}
+
+// entryOverhead and recordOverhead approximate the memory an entry and each
+// of its records take besides names and data.
+const (
+	entryOverhead  = 256
+	recordOverhead = 128
+)
+
+// size estimates the memory used by the entry of the packet.
+func size(k key, p *dns.DNSPacket) int {
+	n := entryOverhead + len(k.name) + len(k.subnet)
+	for _, records := range [][]*dns.DNSRecord{p.Answers, p.Authorities, p.Resources} {
+		for _, r := range records {
+			n += recordOverhead + len(r.Addr) + len(r.Data)
+			for _, name := range []*buffer.DomainName{r.Domain, r.Host, r.MailHost} {
+				if name != nil {
+					n += len(name.String())
+				}
+			}
+			for _, o := range r.Options {
+				n += len(o.Data)
+			}
+		}
+	}
+
+	return n
+}
+
+// TTL returns how long the response may be cached. Positive answers live as
+// long as their shortest record, negative answers as long as the SOA of the
+// zone allows (RFC2308). ok is false for responses that mustn't be cached.
+func TTL(response *dns.DNSPacket) (ttl uint32, ok bool) {
+	switch response.Header.ResCode {
+	case dns.NoError, dns.NxDomain:
+	default:
+		return 0, false
+	}
+
+	if response.Header.ResCode == dns.NoError && len(response.Answers) != 0 {
+		return minTTL(response.Answers), true
+	}
+
+	for _, auth := range response.Authorities {
+		if auth.QType == dns.SOAQueryType {
+			ttl := auth.TTL
+			if auth.Minimum < ttl {
+				ttl = auth.Minimum
+			}
+			return ttl, true
+		}
+	}
+
+	return 0, false
+}
+
+func minTTL(records []*dns.DNSRecord) uint32 {
+	ttl := records[0].TTL
+	for _, r := range records[1:] {
+		if r.TTL < ttl {
+			ttl = r.TTL
+		}
+	}
+
+	return ttl
+}
+
+// stale copies the packet with every TTL set to StaleTTL.
+func stale(p *dns.DNSPacket) *dns.DNSPacket {
+	aged := age(p, 0)
+	for _, records := range [][]*dns.DNSRecord{aged.Answers, aged.Authorities, aged.Resources} {
+		for _, r := range records {
+			if r.QType != dns.OPTQueryType {
+				r.TTL = StaleTTL
+			}
+		}
+	}
+
+	return aged
+}
+
+// age copies the packet with every TTL reduced by elapsed seconds, cached
+// packets are shared and never modified.
+func age(p *dns.DNSPacket, elapsed uint32) *dns.DNSPacket {
+	header := *p.Header
+	aged := &dns.DNSPacket{
+		Header:      &header,
+		Questions:   p.Questions,
+		Answers:     ageRecords(p.Answers, elapsed),
+		Authorities: ageRecords(p.Authorities, elapsed),
+		Resources:   ageRecords(p.Resources, elapsed),
+	}
+
+	return aged
+}
+
+func ageRecords(records []*dns.DNSRecord, elapsed uint32) []*dns.DNSRecord {
+	aged := make([]*dns.DNSRecord, 0, len(records))
+	for _, r := range records {
+		copied := *r
+		// OPT reuses the TTL field for flags, its options may be added to
+		if copied.QType == dns.OPTQueryType {
+			copied.Options = append([]*dns.EDNSOption(nil), r.Options...)
+		} else {
+			if copied.TTL > elapsed {
+				copied.TTL -= elapsed
+			} else {
+				copied.TTL = 0
+			}
+		}
+		aged = append(aged, &copied)
+	}
+
+	return aged
+}
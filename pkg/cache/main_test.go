@@ -0,0 +1,203 @@
+package cache_test
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/cache"
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+func aResponse(ttl uint32, ip string) *dns.DNSPacket {
+	packet := dns.NewDNSPacket()
+	packet.Header.Response = true
+	packet.Questions = append(packet.Questions, dns.NewDNSQuestion("www.example.com", dns.AQueryType))
+
+	r, _ := dns.ParseRecord("www.example.com. 300 IN A "+ip, 0)
+	r.TTL = ttl
+	packet.Answers = append(packet.Answers, r)
+
+	return packet
+}
+
+func TestCache(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	t.Run("ttl_counts_down_and_expires", func(t *testing.T) {
+		c := cache.New(&cache.Config{})
+		c.Put("www.example.com", dns.AQueryType, nil, aResponse(60, "1.2.3.4"), now)
+
+		cached, ok := c.Get("WWW.example.com.", dns.AQueryType, nil, now.Add(20*time.Second))
+		True(t, ok)
+		Equal(t, uint32(40), cached.Answers[0].TTL)
+
+		_, ok = c.Get("www.example.com", dns.AAAAQueryType, nil, now)
+		False(t, ok)
+
+		_, ok = c.Get("www.example.com", dns.AQueryType, nil, now.Add(60*time.Second))
+		False(t, ok)
+		Equal(t, 0, c.Len())
+	})
+
+	t.Run("stale_answers_within_max_stale", func(t *testing.T) {
+		c := cache.New(&cache.Config{MaxStale: time.Hour})
+		c.Put("www.example.com", dns.AQueryType, nil, aResponse(60, "1.2.3.4"), now)
+
+		later := now.Add(30 * time.Minute)
+		_, ok := c.Get("www.example.com", dns.AQueryType, nil, later)
+		False(t, ok)
+
+		stale, ok := c.GetStale("www.example.com", dns.AQueryType, nil, later)
+		True(t, ok)
+		Equal(t, uint32(cache.StaleTTL), stale.Answers[0].TTL)
+
+		_, ok = c.GetStale("www.example.com", dns.AQueryType, nil, now.Add(2*time.Hour))
+		False(t, ok)
+		Equal(t, 0, c.Len())
+	})
+
+	t.Run("prefetch_popular_entries_near_expiry", func(t *testing.T) {
+		c := cache.New(&cache.Config{PrefetchHits: 2})
+		c.Put("www.example.com", dns.AQueryType, nil, aResponse(100, "1.2.3.4"), now)
+
+		nearExpiry := now.Add(95 * time.Second)
+		c.Get("www.example.com", dns.AQueryType, nil, nearExpiry)
+		False(t, c.Prefetch("www.example.com", dns.AQueryType, nil, nearExpiry), "not popular yet")
+
+		c.Get("www.example.com", dns.AQueryType, nil, now)
+		False(t, c.Prefetch("www.example.com", dns.AQueryType, nil, now), "far from expiry")
+
+		True(t, c.Prefetch("www.example.com", dns.AQueryType, nil, nearExpiry))
+		False(t, c.Prefetch("www.example.com", dns.AQueryType, nil, nearExpiry), "already refreshing")
+
+		c.Put("www.example.com", dns.AQueryType, nil, aResponse(100, "1.2.3.4"), nearExpiry)
+		False(t, c.Prefetch("www.example.com", dns.AQueryType, nil, nearExpiry), "refreshed")
+	})
+
+	t.Run("rrsets_share_lowest_ttl", func(t *testing.T) {
+		c := cache.New(&cache.Config{})
+		response := aResponse(300, "1.2.3.4")
+		response.Answers = append(response.Answers, aResponse(60, "1.2.3.5").Answers...)
+		c.Put("www.example.com", dns.AQueryType, nil, response, now)
+
+		cached, ok := c.Get("www.example.com", dns.AQueryType, nil, now)
+		True(t, ok)
+		Equal(t, uint32(60), cached.Answers[0].TTL)
+		Equal(t, uint32(60), cached.Answers[1].TTL)
+		Equal(t, uint32(300), response.Answers[0].TTL, "the caller's response is left alone")
+	})
+
+	t.Run("negative_answers_use_soa_minimum", func(t *testing.T) {
+		packet := dns.NewDNSPacket()
+		packet.Header.ResCode = dns.NxDomain
+		soa, err := dns.ParseRecord("example.com. 3600 IN SOA ns.example.com. admin.example.com. 1 7200 3600 1209600 300", 0)
+		NoError(t, err)
+		packet.Authorities = append(packet.Authorities, soa)
+
+		ttl, ok := cache.TTL(packet)
+		True(t, ok)
+		Equal(t, uint32(300), ttl)
+
+		packet.Header.ResCode = dns.ServFail
+		_, ok = cache.TTL(packet)
+		False(t, ok)
+	})
+
+	t.Run("scoped_answers_stay_in_their_subnet", func(t *testing.T) {
+		c := cache.New(&cache.Config{})
+
+		client := dns.NewClientSubnet(net.ParseIP("192.0.2.0"), 24)
+		response := aResponse(60, "1.2.3.4")
+		response.SetClientSubnet(&dns.ClientSubnet{Address: client.Address, SourcePrefix: 24, ScopePrefix: 16})
+		c.Put("www.example.com", dns.AQueryType, client, response, now)
+
+		cached, ok := c.Get("www.example.com", dns.AQueryType, dns.NewClientSubnet(net.ParseIP("192.0.77.0"), 24), now)
+		True(t, ok)
+		Equal(t, "1.2.3.4", cached.Answers[0].Addr.String())
+
+		_, ok = c.Get("www.example.com", dns.AQueryType, dns.NewClientSubnet(net.ParseIP("198.51.100.0"), 24), now)
+		False(t, ok)
+
+		_, ok = c.Get("www.example.com", dns.AQueryType, nil, now)
+		False(t, ok)
+	})
+
+	t.Run("snapshot_survives_restart", func(t *testing.T) {
+		c := cache.New(&cache.Config{MaxStale: time.Hour})
+		c.Put("www.example.com", dns.AQueryType, nil, aResponse(60, "1.2.3.4"), now)
+		c.Put("old.example.com", dns.AQueryType, nil, aResponse(60, "1.2.3.5"), now.Add(-2*time.Hour))
+
+		path := filepath.Join(t.TempDir(), "cache.snapshot")
+		NoError(t, c.SaveFile(path, now))
+
+		restarted := cache.New(&cache.Config{MaxStale: time.Hour})
+		loaded, err := restarted.LoadFile(path, now.Add(10*time.Second))
+		NoError(t, err)
+		Equal(t, 1, loaded)
+
+		cached, ok := restarted.Get("www.example.com", dns.AQueryType, nil, now.Add(10*time.Second))
+		True(t, ok)
+		Equal(t, uint32(50), cached.Answers[0].TTL)
+		Equal(t, "1.2.3.4", cached.Answers[0].Addr.String())
+
+		loaded, err = cache.New(&cache.Config{}).LoadFile(filepath.Join(t.TempDir(), "missing"), now)
+		NoError(t, err)
+		Equal(t, 0, loaded)
+	})
+	t.Run("least_recently_used_evicted", func(t *testing.T) {
+		c := cache.New(&cache.Config{MaxEntries: 2})
+
+		c.Put("a.example.com", dns.AQueryType, nil, aResponse(60, "1.2.3.4"), now)
+		c.Put("b.example.com", dns.AQueryType, nil, aResponse(60, "1.2.3.4"), now)
+		_, ok := c.Get("a.example.com", dns.AQueryType, nil, now)
+		True(t, ok)
+
+		c.Put("c.example.com", dns.AQueryType, nil, aResponse(60, "1.2.3.4"), now)
+		Equal(t, 2, c.Len())
+
+		_, ok = c.Get("b.example.com", dns.AQueryType, nil, now)
+		False(t, ok, "least recently used")
+		_, ok = c.Get("a.example.com", dns.AQueryType, nil, now)
+		True(t, ok)
+
+		st := c.Stats()
+		Equal(t, uint64(2), st.Hits)
+		Equal(t, uint64(1), st.Misses)
+		Equal(t, uint64(1), st.Evictions)
+	})
+
+	t.Run("size_bounded_by_bytes", func(t *testing.T) {
+		c := cache.New(&cache.Config{MaxBytes: 4096})
+		for i := 0; i < 100; i++ {
+			c.Put(fmt.Sprintf("%d.example.com", i), dns.AQueryType, nil, aResponse(60, "1.2.3.4"), now)
+		}
+
+		st := c.Stats()
+		True(t, st.Bytes <= 4096)
+		Equal(t, uint64(100-st.Entries), st.Evictions)
+
+		c.Flush()
+		Equal(t, 0, c.Stats().Bytes)
+	})
+
+	t.Run("summary_counts_types_and_hits", func(t *testing.T) {
+		c := cache.New(&cache.Config{MaxStale: time.Hour})
+		c.Put("www.example.com", dns.AQueryType, nil, aResponse(60, "1.2.3.4"), now)
+		c.Put("www.example.com", dns.AAAAQueryType, nil, aResponse(60, "1.2.3.4"), now)
+		c.Put("api.example.com", dns.AQueryType, nil, aResponse(600, "1.2.3.4"), now)
+		c.Get("api.example.com", dns.AQueryType, nil, now)
+		c.Get("api.example.com", dns.AQueryType, nil, now)
+		c.Get("www.example.com", dns.AQueryType, nil, now)
+
+		summary := c.Summarize(now.Add(2*time.Minute), 1)
+		Equal(t, 3, summary.Entries)
+		Equal(t, map[dns.QueryType]int{dns.AQueryType: 2, dns.AAAAQueryType: 1}, summary.Types)
+		Equal(t, 2, summary.Expired)
+		Equal(t, []cache.HotEntry{{Name: "api.example.com", Type: dns.AQueryType, Hits: 2}}, summary.Hottest)
+	})
+}
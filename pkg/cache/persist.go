@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"encoding/gob"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/pkg/errors"
+)
+
+// snapshotVersion changes whenever the snapshot layout does, older snapshots
+// are then ignored.
+const snapshotVersion = 1
+
+type snapshot struct {
+	Version int
+	Entries []snapshotEntry
+}
+
+// snapshotEntry is an entry with its response in wire format.
+type snapshotEntry struct {
+	Name    string
+	QType   uint16
+	Subnet  string
+	Stored  time.Time
+	Expires time.Time
+	Packet  []byte
+}
+
+// Save writes every entry that can still be answered from to w.
+func (c *Memory) Save(w io.Writer, now time.Time) error {
+	c.mu.Lock()
+	snap := snapshot{Version: snapshotVersion, Entries: make([]snapshotEntry, 0, len(c.entries))}
+	for k, e := range c.entries {
+		if !now.Before(e.expires.Add(c.maxStale)) {
+			continue
+		}
+
+		data, err := pack(e.packet)
+		if err != nil {
+			c.mu.Unlock()
+			return errors.Wrapf(err, "packing %s %s", k.name, k.qtype)
+		}
+
+		snap.Entries = append(snap.Entries, snapshotEntry{
+			Name:    k.name,
+			QType:   uint16(k.qtype),
+			Subnet:  k.subnet,
+			Stored:  e.stored,
+			Expires: e.expires,
+			Packet:  data,
+		})
+	}
+	c.mu.Unlock()
+
+	return errors.Wrap(gob.NewEncoder(w).Encode(&snap), "encoding cache snapshot")
+}
+
+// Load adds the entries saved by Save to the cache and returns how many of
+// them were still usable. Entries already in the cache are kept.
+func (c *Memory) Load(r io.Reader, now time.Time) (int, error) {
+	var snap snapshot
+	if err := gob.NewDecoder(r).Decode(&snap); err != nil {
+		return 0, errors.Wrap(err, "decoding cache snapshot")
+	}
+
+	if snap.Version != snapshotVersion {
+		return 0, errors.Errorf("unsupported cache snapshot version %d", snap.Version)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	loaded := 0
+	for _, s := range snap.Entries {
+		if !now.Before(s.Expires.Add(c.maxStale)) {
+			continue
+		}
+
+		k := key{name: s.Name, qtype: dns.QueryType(s.QType), subnet: s.Subnet}
+		if _, ok := c.entries[k]; ok {
+			continue
+		}
+
+		packet, err := unpack(s.Packet)
+		if err != nil {
+			return loaded, errors.Wrapf(err, "unpacking %s %s", s.Name, k.qtype)
+		}
+
+		c.insert(k, &entry{
+			packet:  packet,
+			stored:  s.Stored,
+			expires: s.Expires,
+		})
+		loaded++
+	}
+
+	return loaded, nil
+}
+
+// SaveFile writes the snapshot to path. It is written next to it first and
+// renamed so a crash never leaves a truncated snapshot behind.
+func (c *Memory) SaveFile(path string, now time.Time) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return errors.Wrap(err, "creating cache snapshot")
+	}
+	defer os.Remove(f.Name())
+
+	if err := c.Save(f, now); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "writing cache snapshot")
+	}
+
+	return errors.Wrap(os.Rename(f.Name(), path), "replacing cache snapshot")
+}
+
+// LoadFile loads the snapshot at path, a missing file loads nothing.
+func (c *Memory) LoadFile(path string, now time.Time) (int, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "opening cache snapshot")
+	}
+	defer f.Close()
+
+	return c.Load(f, now)
+}
+
+func pack(p *dns.DNSPacket) ([]byte, error) {
+	buf := buffer.NewBytePacketBuffer()
+	// Responses received over TCP may exceed the UDP size
+	buf.Buf = make([]byte, 65535)
+
+	// Write updates the header counts, leave the cached packet alone
+	if err := age(p, 0).Write(buf); err != nil {
+		return nil, err
+	}
+
+	return append([]byte(nil), buf.Buf[:buf.Pos()]...), nil
+}
+
+func unpack(data []byte) (*dns.DNSPacket, error) {
+	buf := buffer.NewBytePacketBuffer()
+	buf.Buf = data
+
+	return dns.DNSPacketFromBuffer(buf)
+}
@@ -0,0 +1,241 @@
+package dns
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/pkg/errors"
+)
+
+// maxCharacterString is the length limit of a single TXT string (RFC1035 3.3)
+const maxCharacterString = 255
+
+// NewARecord creates an A record, ip must be an IPv4 address.
+func NewARecord(name string, ip net.IP, ttl uint32) (*DNSRecord, error) {
+	if ip.To4() == nil {
+		return nil, errors.Errorf("address %q is not an IPv4 address", ip)
+	}
+
+	return newRecord(name, AQueryType, ttl, func(r *DNSRecord) error {
+		r.Addr = ip.To4()
+		return nil
+	})
+}
+
+// NewAAAARecord creates an AAAA record, ip must be an IPv6 address.
+func NewAAAARecord(name string, ip net.IP, ttl uint32) (*DNSRecord, error) {
+	if ip.To16() == nil || ip.To4() != nil {
+		return nil, errors.Errorf("address %q is not an IPv6 address", ip)
+	}
+
+	return newRecord(name, AAAAQueryType, ttl, func(r *DNSRecord) error {
+		r.Addr = ip.To16()
+		return nil
+	})
+}
+
+// NewNSRecord creates an NS record delegating name to host.
+func NewNSRecord(name string, host string, ttl uint32) (*DNSRecord, error) {
+	return newRecord(name, NSQueryType, ttl, func(r *DNSRecord) (err error) {
+		r.Host, err = checkedName(host)
+		return err
+	})
+}
+
+// NewCNAMERecord creates a CNAME record aliasing name to target.
+func NewCNAMERecord(name string, target string, ttl uint32) (*DNSRecord, error) {
+	return newRecord(name, CNAMEQueryType, ttl, func(r *DNSRecord) (err error) {
+		r.Host, err = checkedName(target)
+		return err
+	})
+}
+
+// NewPTRRecord creates a PTR record pointing name to target.
+func NewPTRRecord(name string, target string, ttl uint32) (*DNSRecord, error) {
+	return newRecord(name, PTRQueryType, ttl, func(r *DNSRecord) (err error) {
+		r.Host, err = checkedName(target)
+		return err
+	})
+}
+
+// NewMXRecord creates an MX record, lower preferences are tried first.
+func NewMXRecord(name string, preference uint16, host string, ttl uint32) (*DNSRecord, error) {
+	return newRecord(name, MXQueryType, ttl, func(r *DNSRecord) (err error) {
+		r.Priority = preference
+		r.Host, err = checkedName(host)
+		return err
+	})
+}
+
+// NewSOARecord creates an SOA record, the timers are in seconds.
+func NewSOARecord(name string, ns string, mbox string, serial, refresh, retry, expire, minimum uint32, ttl uint32) (*DNSRecord, error) {
+	return newRecord(name, SOAQueryType, ttl, func(r *DNSRecord) (err error) {
+		if r.Host, err = checkedName(ns); err != nil {
+			return err
+		}
+		if r.MailHost, err = checkedName(mbox); err != nil {
+			return err
+		}
+
+		r.Serial, r.Refresh, r.Retry, r.Expire, r.Minimum = serial, refresh, retry, expire, minimum
+		return nil
+	})
+}
+
+// NewTXTRecord creates a TXT record holding the strings in order, each at
+// most 255 bytes long.
+func NewTXTRecord(name string, ttl uint32, texts ...string) (*DNSRecord, error) {
+	if len(texts) == 0 {
+		return nil, errors.New("TXT record needs at least one string")
+	}
+
+	data := make([]byte, 0)
+	for _, text := range texts {
+		if len(text) > maxCharacterString {
+			return nil, errors.Errorf("TXT string of %d bytes exceeds %d", len(text), maxCharacterString)
+		}
+		data = append(append(data, byte(len(text))), text...)
+	}
+
+	return newRecord(name, TXTQueryType, ttl, func(r *DNSRecord) error {
+		return r.setData(data)
+	})
+}
+
+// SRV is the data of an SRV record (RFC2782).
+type SRV struct {
+	Priority uint16
+	Weight   uint16
+	Port     uint16
+	Target   string
+}
+
+// NewSRVRecord creates an SRV record, name is the service name like
+// "_sip._tcp.example.com".
+func NewSRVRecord(name string, srv SRV, ttl uint32) (*DNSRecord, error) {
+	target, err := checkedName(srv.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, 6)
+	binary.BigEndian.PutUint16(data[0:], srv.Priority)
+	binary.BigEndian.PutUint16(data[2:], srv.Weight)
+	binary.BigEndian.PutUint16(data[4:], srv.Port)
+
+	// The target is never compressed (RFC2782)
+	for _, label := range target.SplitLabels() {
+		data = append(append(data, byte(len(label))), label...)
+	}
+	data = append(data, 0)
+
+	return newRecord(name, SRVQueryType, ttl, func(r *DNSRecord) error {
+		return r.setData(data)
+	})
+}
+
+// TXT returns the strings of a TXT record.
+func (r *DNSRecord) TXT() ([]string, error) {
+	if r.QType != TXTQueryType {
+		return nil, errors.Errorf("%s record has no TXT data", r.QType)
+	}
+
+	texts := make([]string, 0)
+	for data := r.Data; len(data) > 0; {
+		n := int(data[0])
+		if 1+n > len(data) {
+			return nil, errors.Wrap(ErrMalformedRecord, "TXT string overruns data")
+		}
+		texts = append(texts, string(data[1:1+n]))
+		data = data[1+n:]
+	}
+
+	return texts, nil
+}
+
+// SRV returns the data of an SRV record.
+func (r *DNSRecord) SRV() (*SRV, error) {
+	if r.QType != SRVQueryType {
+		return nil, errors.Errorf("%s record has no SRV data", r.QType)
+	}
+
+	if len(r.Data) < 7 {
+		return nil, errors.Wrap(ErrMalformedRecord, "SRV data too short")
+	}
+
+	labels := make([]string, 0)
+	data := r.Data[6:]
+	for data[0] != 0 {
+		n := int(data[0])
+		// Compression pointers aren't allowed in SRV targets
+		if n > 0x3f || 2+n > len(data) {
+			return nil, errors.Wrap(ErrMalformedRecord, "SRV target")
+		}
+		labels = append(labels, string(data[1:1+n]))
+		data = data[1+n:]
+	}
+
+	return &SRV{
+		Priority: binary.BigEndian.Uint16(r.Data[0:]),
+		Weight:   binary.BigEndian.Uint16(r.Data[2:]),
+		Port:     binary.BigEndian.Uint16(r.Data[4:]),
+		Target:   strings.Join(labels, "."),
+	}, nil
+}
+
+// newRecord creates a record of class IN with a validated owner name, set
+// fills in the type specific fields.
+func newRecord(name string, qtype QueryType, ttl uint32, set func(r *DNSRecord) error) (*DNSRecord, error) {
+	owner, err := checkedName(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "creating %s record", qtype)
+	}
+
+	r := &DNSRecord{
+		QType:  qtype,
+		Domain: owner,
+		Class:  ClassIN,
+		TTL:    ttl,
+	}
+
+	if err := set(r); err != nil {
+		return nil, errors.Wrapf(err, "creating %s record", qtype)
+	}
+
+	return r, nil
+}
+
+func (r *DNSRecord) setData(data []byte) error {
+	if len(data) > 0xffff {
+		return errors.Errorf("record data of %d bytes exceeds 65535", len(data))
+	}
+
+	r.Data = data
+	r.DataLen = uint16(len(data))
+	return nil
+}
+
+// checkedName parses a domain name rejecting empty labels, labels longer than
+// 63 bytes and names that don't fit the wire format.
+func checkedName(name string) (*buffer.DomainName, error) {
+	n := parseName(name)
+
+	wireLength := 1
+	for _, label := range n.SplitLabels() {
+		if label == "" {
+			return nil, errors.Errorf("name %q has an empty label", name)
+		}
+		if len(label) > 0x3f {
+			return nil, errors.Wrapf(buffer.ErrLabelTooLong, "name %q", name)
+		}
+		wireLength += 1 + len(label)
+	}
+
+	if wireLength > buffer.MAX_NAME_LENGTH {
+		return nil, errors.Errorf("name %q exceeds %d bytes", name, buffer.MAX_NAME_LENGTH)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,108 @@
+package dns_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+func TestRecordConstructors(t *testing.T) {
+	t.Run("render_like_zone_files", func(t *testing.T) {
+		a, err := dns.NewARecord("www.example.com", net.ParseIP("192.0.2.1"), 300)
+		NoError(t, err)
+		Equal(t, "www.example.com. 300 IN A 192.0.2.1", a.Text())
+
+		aaaa, err := dns.NewAAAARecord("www.example.com.", net.ParseIP("2001:db8::1"), 300)
+		NoError(t, err)
+		Equal(t, "www.example.com. 300 IN AAAA 2001:db8::1", aaaa.Text())
+
+		mx, err := dns.NewMXRecord("example.com", 10, "mail.example.com", 3600)
+		NoError(t, err)
+		Equal(t, "example.com. 3600 IN MX 10 mail.example.com.", mx.Text())
+
+		ptr, err := dns.NewPTRRecord("1.2.0.192.in-addr.arpa", "www.example.com", 300)
+		NoError(t, err)
+		Equal(t, "1.2.0.192.in-addr.arpa. 300 IN PTR www.example.com.", ptr.Text())
+		Equal(t, ptr.Text(), roundTrip(t, ptr).Text())
+
+		soa, err := dns.NewSOARecord("example.com", "ns.example.com", "hostmaster.example.com", 1, 7200, 3600, 1209600, 300, 3600)
+		NoError(t, err)
+		Equal(t, "example.com. 3600 IN SOA ns.example.com. hostmaster.example.com. 1 7200 3600 1209600 300", soa.Text())
+	})
+
+	t.Run("reject_invalid_input", func(t *testing.T) {
+		_, err := dns.NewARecord("www.example.com", net.ParseIP("2001:db8::1"), 300)
+		Error(t, err)
+
+		_, err = dns.NewAAAARecord("www.example.com", net.ParseIP("192.0.2.1"), 300)
+		Error(t, err)
+
+		_, err = dns.NewNSRecord("example..com", "ns.example.com", 300)
+		Error(t, err)
+
+		_, err = dns.NewCNAMERecord("www.example.com", strings.Repeat("a", 64)+".example.com", 300)
+		ErrorIs(t, err, buffer.ErrLabelTooLong)
+
+		_, err = dns.NewMXRecord(strings.Repeat("abcdefgh.", 32)+"com", 10, "mail.example.com", 300)
+		Error(t, err)
+
+		_, err = dns.NewTXTRecord("example.com", 300, strings.Repeat("x", 256))
+		Error(t, err)
+
+		_, err = dns.NewTXTRecord("example.com", 300)
+		Error(t, err)
+	})
+
+	t.Run("txt_round_trip", func(t *testing.T) {
+		txt, err := dns.NewTXTRecord("example.com", 300, "v=spf1 -all", "")
+		NoError(t, err)
+
+		texts, err := roundTrip(t, txt).TXT()
+		NoError(t, err)
+		Equal(t, []string{"v=spf1 -all", ""}, texts)
+	})
+
+	t.Run("srv_round_trip", func(t *testing.T) {
+		want := dns.SRV{Priority: 10, Weight: 60, Port: 5060, Target: "sip.example.com"}
+		srv, err := dns.NewSRVRecord("_sip._tcp.example.com", want, 300)
+		NoError(t, err)
+
+		got, err := roundTrip(t, srv).SRV()
+		NoError(t, err)
+		Equal(t, &want, got)
+	})
+
+	t.Run("accessors_check_type", func(t *testing.T) {
+		a, err := dns.NewARecord("www.example.com", net.ParseIP("192.0.2.1"), 300)
+		NoError(t, err)
+
+		_, err = a.TXT()
+		Error(t, err)
+		_, err = a.SRV()
+		Error(t, err)
+
+		malformed := &dns.DNSRecord{QType: dns.TXTQueryType, Data: []byte{5, 'a'}}
+		_, err = malformed.TXT()
+		ErrorIs(t, err, dns.ErrMalformedRecord)
+	})
+}
+
+// roundTrip writes the record as the answer of a packet and reads it back.
+func roundTrip(t *testing.T, r *dns.DNSRecord) *dns.DNSRecord {
+	t.Helper()
+
+	packet := dns.NewDNSPacket()
+	packet.Answers = append(packet.Answers, r)
+
+	buf := buffer.NewBytePacketBuffer()
+	if err := packet.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	return decode(t, buf.Buf[:buf.Pos()]).Answers[0]
+}
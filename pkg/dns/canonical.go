@@ -0,0 +1,84 @@
+package dns
+
+import (
+	"sort"
+
+	"github.com/msarvar/godns/pkg/buffer"
+)
+
+// Canonical returns a copy of the packet in a canonical form, writing it
+// gives the same bytes however the packet was built, e.g. for golden tests.
+// Names are lowercased like in DNSSEC canonical form (RFC4034 6.2),
+// duplicate records are dropped and every section is ordered by RRset, by
+// owner in canonical order (RFC4034 6.1), type and class, and then by
+// record data (RFC4034 6.3). The OPT record stays last. Names are compressed
+// against their first occurrence as always.
+func (p *DNSPacket) Canonical() (*DNSPacket, error) {
+	header := *p.Header
+	canonical := &DNSPacket{Header: &header}
+
+	for _, q := range p.Questions {
+		copied := *q
+		copied.Name = lowerName(q.Name)
+		canonical.Questions = append(canonical.Questions, &copied)
+	}
+
+	var err error
+	if canonical.Answers, err = canonicalSection(p.Answers); err != nil {
+		return nil, err
+	}
+	if canonical.Authorities, err = canonicalSection(p.Authorities); err != nil {
+		return nil, err
+	}
+	if canonical.Resources, err = canonicalSection(p.Resources); err != nil {
+		return nil, err
+	}
+
+	return canonical, nil
+}
+
+// canonicalSection returns the records of a section lowercased, without
+// duplicates and in canonical order, pseudo records last.
+func canonicalSection(records []*DNSRecord) ([]*DNSRecord, error) {
+	lowered := make([]*DNSRecord, 0, len(records))
+	var pseudo []*DNSRecord
+	for _, r := range records {
+		if r.QType == OPTQueryType {
+			pseudo = append(pseudo, r)
+			continue
+		}
+		lowered = append(lowered, lowercased(r))
+	}
+
+	sets := GroupRRsets(lowered)
+	sort.SliceStable(sets, func(i, j int) bool {
+		a, b := sets[i], sets[j]
+		if c := CompareNames(a.Name.String(), b.Name.String()); c != 0 {
+			return c < 0
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Class < b.Class
+	})
+
+	var section []*DNSRecord
+	for _, set := range sets {
+		if err := set.Sort(); err != nil {
+			return nil, err
+		}
+		section = append(section, set.Records...)
+	}
+
+	return append(section, pseudo...), nil
+}
+
+// WriteCanonical writes the canonical form of the packet, see Canonical.
+func (p *DNSPacket) WriteCanonical(packetBuffer *buffer.BytePacketBuffer) error {
+	canonical, err := p.Canonical()
+	if err != nil {
+		return err
+	}
+
+	return canonical.Write(packetBuffer)
+}
@@ -0,0 +1,84 @@
+package dns_test
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+func TestCanonical(t *testing.T) {
+	build := func(question string, answers ...string) *dns.DNSPacket {
+		packet := dns.NewDNSPacket()
+		packet.Header.ID = 4660
+		packet.Header.Response = true
+		packet.Questions = append(packet.Questions, dns.NewDNSQuestion(question, dns.ANYQueryType))
+		for _, line := range answers {
+			r, err := dns.ParseRecord(line, 0)
+			NoError(t, err)
+			packet.Answers = append(packet.Answers, r)
+		}
+		packet.Resources = append(packet.Resources, dns.NewOPTRecord(1232))
+		r, err := dns.ParseRecord("ns.Example.com. 300 IN A 192.0.2.53", 0)
+		NoError(t, err)
+		packet.Resources = append(packet.Resources, r)
+		return packet
+	}
+	write := func(packet *dns.DNSPacket) []byte {
+		b := buffer.NewBytePacketBuffer()
+		NoError(t, packet.WriteCanonical(b))
+		return append([]byte(nil), b.Buf[:b.Pos()]...)
+	}
+
+	a := build("WWW.Example.com",
+		"www.example.com. 300 IN MX 20 Mail2.Example.com.",
+		"www.example.com. 300 IN A 192.0.2.2",
+		"Example.com. 300 IN NS ns.example.com.",
+		"WWW.example.com. 300 IN MX 10 mail.example.com.",
+		"www.example.com. 300 IN A 192.0.2.1",
+	)
+	b := build("www.example.com",
+		"www.example.com. 300 IN A 192.0.2.1",
+		"www.example.com. 300 IN MX 10 mail.example.com.",
+		"www.example.com. 300 IN A 192.0.2.2",
+		"example.com. 300 IN NS NS.example.com.",
+		"www.example.com. 300 IN A 192.0.2.1",
+		"www.example.com. 300 IN MX 20 mail2.example.com.",
+	)
+
+	t.Run("same_bytes_however_built", func(t *testing.T) {
+		Equal(t, write(a), write(b))
+	})
+
+	t.Run("canonical_order", func(t *testing.T) {
+		canonical, err := b.Canonical()
+		if !NoError(t, err) {
+			return
+		}
+
+		Equal(t, "www.example.com", canonical.Questions[0].Name.String())
+		var answers []string
+		for _, r := range canonical.Answers {
+			answers = append(answers, r.String())
+		}
+		Equal(t, []string{
+			"example.com.\t300\tIN\tNS\tns.example.com.",
+			"www.example.com.\t300\tIN\tA\t192.0.2.1",
+			"www.example.com.\t300\tIN\tA\t192.0.2.2",
+			"www.example.com.\t300\tIN\tMX\t10 mail.example.com.",
+			"www.example.com.\t300\tIN\tMX\t20 mail2.example.com.",
+		}, answers)
+		if Len(t, canonical.Resources, 2) {
+			Equal(t, "ns.example.com", canonical.Resources[0].Domain.String())
+			Equal(t, dns.OPTQueryType, canonical.Resources[1].QType)
+		}
+	})
+
+	t.Run("original_unchanged", func(t *testing.T) {
+		Equal(t, "WWW.Example.com", a.Questions[0].Name.String())
+		Equal(t, "Mail2.Example.com", a.Answers[0].Host.String())
+		Len(t, b.Answers, 6)
+	})
+}
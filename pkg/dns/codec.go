@@ -0,0 +1,108 @@
+package dns
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+
+	buf "github.com/msarvar/godns/pkg/buffer"
+	"github.com/pkg/errors"
+)
+
+// MaxMessageSize is the largest message that fits the two byte length prefix
+// of stream transports.
+const MaxMessageSize = 65535
+
+// ReadPacket reads one message from r. Datagram connections return a message
+// per read, stream connections prefix every message with its two byte length
+// (RFC1035 4.2.2) and any other reader, like a file, holds a single message up
+// to its end.
+func ReadPacket(r io.Reader) (*DNSPacket, error) {
+	var data []byte
+
+	switch {
+	case isDatagram(r):
+		data = make([]byte, MaxMessageSize)
+		n, err := r.Read(data)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading dns message")
+		}
+		data = data[:n]
+	case isStream(r):
+		var length [2]byte
+		if _, err := io.ReadFull(r, length[:]); err != nil {
+			return nil, errors.Wrap(err, "reading dns message length")
+		}
+
+		data = make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, errors.Wrap(err, "reading dns message")
+		}
+	default:
+		var err error
+		data, err = ioutil.ReadAll(io.LimitReader(r, MaxMessageSize+1))
+		if err != nil {
+			return nil, errors.Wrap(err, "reading dns message")
+		}
+		if len(data) > MaxMessageSize {
+			return nil, errors.Errorf("dns message larger than %d bytes", MaxMessageSize)
+		}
+	}
+
+	packetBuffer := buf.NewBytePacketBuffer()
+	packetBuffer.Buf = data
+
+	return DNSPacketFromBuffer(packetBuffer)
+}
+
+// WriteTo writes the packet to w framed like ReadPacket expects it. Datagrams
+// are limited to DefaultUDPPayloadSize bytes, records that don't fit are left
+// out and the packet is marked truncated.
+func (p *DNSPacket) WriteTo(w io.Writer) (int64, error) {
+	packetBuffer := buf.NewBytePacketBuffer()
+	if !isDatagram(w) {
+		packetBuffer.Buf = make([]byte, MaxMessageSize)
+	}
+
+	if err := p.Write(packetBuffer); err != nil {
+		return 0, errors.Wrap(err, "writing dns message")
+	}
+
+	data, err := packetBuffer.GetRangeAtPos()
+	if err != nil {
+		return 0, errors.Wrap(err, "retrieving buffer")
+	}
+
+	msg := net.Buffers{data}
+	if isStream(w) {
+		var length [2]byte
+		binary.BigEndian.PutUint16(length[:], uint16(len(data)))
+		msg = net.Buffers{length[:], data}
+	}
+
+	n, err := msg.WriteTo(w)
+	if err != nil {
+		return n, errors.Wrap(err, "sending dns message")
+	}
+
+	return n, nil
+}
+
+// isDatagram reports whether c is a connection preserving message
+// boundaries.
+func isDatagram(c interface{}) bool {
+	if _, ok := c.(net.PacketConn); ok {
+		return true
+	}
+
+	conn, ok := c.(net.Conn)
+	return ok && strings.HasPrefix(conn.LocalAddr().Network(), "udp")
+}
+
+// isStream reports whether c is a connection without message boundaries.
+func isStream(c interface{}) bool {
+	_, ok := c.(net.Conn)
+	return ok && !isDatagram(c)
+}
@@ -0,0 +1,81 @@
+package dns_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+func TestCodec(t *testing.T) {
+	newPacket := func() *dns.DNSPacket {
+		packet := dns.NewDNSPacket()
+		packet.Header.ID = 4660
+		packet.Header.Response = true
+		packet.Questions = append(packet.Questions, dns.NewDNSQuestion("www.example.com", dns.AQueryType))
+		r, _ := dns.ParseRecord("www.example.com. 300 IN A 192.0.2.1", 0)
+		packet.Answers = append(packet.Answers, r)
+		return packet
+	}
+
+	check := func(t *testing.T, parsed *dns.DNSPacket) {
+		Equal(t, uint16(4660), parsed.Header.ID)
+		if Len(t, parsed.Answers, 1) {
+			Equal(t, "192.0.2.1", parsed.Answers[0].Addr.String())
+		}
+	}
+
+	t.Run("in_memory", func(t *testing.T) {
+		var b bytes.Buffer
+		_, err := newPacket().WriteTo(&b)
+		NoError(t, err)
+
+		parsed, err := dns.ReadPacket(&b)
+		NoError(t, err)
+		check(t, parsed)
+	})
+
+	t.Run("stream_messages_are_length_prefixed", func(t *testing.T) {
+		client, server := net.Pipe()
+		defer client.Close()
+		defer server.Close()
+
+		go func() {
+			for i := 0; i < 2; i++ {
+				newPacket().WriteTo(client)
+			}
+		}()
+
+		for i := 0; i < 2; i++ {
+			parsed, err := dns.ReadPacket(server)
+			NoError(t, err)
+			check(t, parsed)
+		}
+	})
+
+	t.Run("datagrams", func(t *testing.T) {
+		server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		NoError(t, err)
+		defer server.Close()
+
+		client, err := net.DialUDP("udp", nil, server.LocalAddr().(*net.UDPAddr))
+		NoError(t, err)
+		defer client.Close()
+
+		n, err := newPacket().WriteTo(client)
+		NoError(t, err)
+
+		parsed, err := dns.ReadPacket(server)
+		NoError(t, err)
+		check(t, parsed)
+		Less(t, n, int64(dns.DefaultUDPPayloadSize))
+	})
+
+	t.Run("oversized_message", func(t *testing.T) {
+		_, err := dns.ReadPacket(bytes.NewReader(make([]byte, dns.MaxMessageSize+1)))
+		Error(t, err)
+	})
+}
@@ -0,0 +1,75 @@
+package dns
+
+import (
+	"github.com/pkg/errors"
+)
+
+const (
+	ClientCookieLength    = 8
+	MinServerCookieLength = 8
+	MaxServerCookieLength = 32
+)
+
+// Cookie is the payload of the COOKIE EDNS option described in RFC7873. A
+// query carries only the client cookie until the server has handed out a
+// server cookie in an earlier response.
+type Cookie struct {
+	Client []byte
+	Server []byte
+}
+
+// ParseCookie validates the option length and splits it into the client and
+// server parts.
+func ParseCookie(data []byte) (*Cookie, error) {
+	if len(data) < ClientCookieLength {
+		return nil, errors.Wrap(ErrMalformedOption, "cookie shorter than client cookie")
+	}
+
+	serverLen := len(data) - ClientCookieLength
+	if serverLen != 0 && (serverLen < MinServerCookieLength || serverLen > MaxServerCookieLength) {
+		return nil, errors.Wrapf(ErrMalformedOption, "invalid server cookie length %d", serverLen)
+	}
+
+	c := &Cookie{
+		Client: append([]byte(nil), data[:ClientCookieLength]...),
+	}
+	if serverLen > 0 {
+		c.Server = append([]byte(nil), data[ClientCookieLength:]...)
+	}
+
+	return c, nil
+}
+
+// Bytes encodes the cookie into the option data format.
+func (c *Cookie) Bytes() []byte {
+	data := make([]byte, 0, len(c.Client)+len(c.Server))
+	data = append(data, c.Client...)
+	return append(data, c.Server...)
+}
+
+// Cookie returns the cookie option of the packet. Packets without EDNS or
+// without the option return a nil cookie and no error.
+func (p *DNSPacket) Cookie() (*Cookie, error) {
+	opt := p.OPT()
+	if opt == nil {
+		return nil, nil
+	}
+
+	o := opt.Option(CookieOptionCode)
+	if o == nil {
+		return nil, nil
+	}
+
+	return ParseCookie(o.Data)
+}
+
+// SetCookie attaches the cookie to the packet adding an OPT record if needed.
+func (p *DNSPacket) SetCookie(c *Cookie) {
+	opt := p.OPT()
+	if opt == nil {
+		opt = NewOPTRecord(DefaultUDPPayloadSize)
+		p.Resources = append(p.Resources, opt)
+	}
+
+	opt.SetOption(CookieOptionCode, c.Bytes())
+}
@@ -0,0 +1,136 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ecsFamilyIPv4 = 1
+	ecsFamilyIPv6 = 2
+)
+
+// ClientSubnet is the payload of the EDNS Client Subnet option described in
+// RFC7871. Queries carry the network of the client in Address and
+// SourcePrefix, responses tell in ScopePrefix which part of it the answer is
+// valid for.
+type ClientSubnet struct {
+	Address      net.IP
+	SourcePrefix uint8
+	ScopePrefix  uint8
+}
+
+// NewClientSubnet returns the subnet of ip with the given prefix length, the
+// host bits are cleared.
+func NewClientSubnet(ip net.IP, prefix uint8) *ClientSubnet {
+	bits := 8 * net.IPv6len
+	if v4 := ip.To4(); v4 != nil {
+		ip, bits = v4, 8*net.IPv4len
+	}
+	if int(prefix) > bits {
+		prefix = uint8(bits)
+	}
+
+	return &ClientSubnet{
+		Address:      ip.Mask(net.CIDRMask(int(prefix), bits)),
+		SourcePrefix: prefix,
+	}
+}
+
+// ParseClientSubnet validates the option data and decodes it.
+func ParseClientSubnet(data []byte) (*ClientSubnet, error) {
+	if len(data) < 4 {
+		return nil, errors.Wrap(ErrMalformedOption, "client subnet shorter than 4 bytes")
+	}
+
+	family := uint16(data[0])<<8 | uint16(data[1])
+	source, scope := data[2], data[3]
+
+	var size int
+	switch family {
+	case ecsFamilyIPv4:
+		size = net.IPv4len
+	case ecsFamilyIPv6:
+		size = net.IPv6len
+	default:
+		return nil, errors.Wrapf(ErrMalformedOption, "unknown client subnet family %d", family)
+	}
+
+	if int(source) > 8*size || int(scope) > 8*size {
+		return nil, errors.Wrapf(ErrMalformedOption, "client subnet prefix /%d exceeds address", source)
+	}
+
+	// The address is sent without the bytes past the source prefix
+	addr := data[4:]
+	if len(addr) != (int(source)+7)/8 {
+		return nil, errors.Wrapf(ErrMalformedOption, "client subnet address of %d bytes for /%d", len(addr), source)
+	}
+
+	ip := make(net.IP, size)
+	copy(ip, addr)
+	if !ip.Mask(net.CIDRMask(int(source), 8*size)).Equal(ip) {
+		return nil, errors.Wrap(ErrMalformedOption, "client subnet address has bits past the prefix")
+	}
+
+	return &ClientSubnet{
+		Address:      ip,
+		SourcePrefix: source,
+		ScopePrefix:  scope,
+	}, nil
+}
+
+// Bytes encodes the subnet into the option data format.
+func (c *ClientSubnet) Bytes() []byte {
+	family := ecsFamilyIPv6
+	addr := c.Address.To16()
+	if v4 := c.Address.To4(); v4 != nil {
+		family, addr = ecsFamilyIPv4, v4
+	}
+
+	data := []byte{0, byte(family), c.SourcePrefix, c.ScopePrefix}
+	return append(data, addr[:(int(c.SourcePrefix)+7)/8]...)
+}
+
+// Truncate returns the subnet shortened to at most prefix bits, it is used to
+// limit how much of the client address is revealed upstream.
+func (c *ClientSubnet) Truncate(prefix uint8) *ClientSubnet {
+	if c.SourcePrefix <= prefix {
+		return c
+	}
+
+	return NewClientSubnet(c.Address, prefix)
+}
+
+func (c *ClientSubnet) String() string {
+	return fmt.Sprintf("%s/%d/%d", c.Address, c.SourcePrefix, c.ScopePrefix)
+}
+
+// ClientSubnet returns the client subnet option of the packet. Packets without
+// EDNS or without the option return nil and no error.
+func (p *DNSPacket) ClientSubnet() (*ClientSubnet, error) {
+	opt := p.OPT()
+	if opt == nil {
+		return nil, nil
+	}
+
+	o := opt.Option(ClientSubnetOptionCode)
+	if o == nil {
+		return nil, nil
+	}
+
+	return ParseClientSubnet(o.Data)
+}
+
+// SetClientSubnet attaches the subnet to the packet adding an OPT record if
+// needed.
+func (p *DNSPacket) SetClientSubnet(c *ClientSubnet) {
+	opt := p.OPT()
+	if opt == nil {
+		opt = NewOPTRecord(DefaultUDPPayloadSize)
+		p.Resources = append(p.Resources, opt)
+	}
+
+	opt.SetOption(ClientSubnetOptionCode, c.Bytes())
+}
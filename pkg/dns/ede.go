@@ -0,0 +1,140 @@
+package dns
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// ExtendedErrorCode is the INFO-CODE of an Extended DNS Error (RFC8914).
+type ExtendedErrorCode uint16
+
+const (
+	EDEOther                      ExtendedErrorCode = 0
+	EDEUnsupportedDNSKEYAlgorithm ExtendedErrorCode = 1
+	EDEUnsupportedDSDigestType    ExtendedErrorCode = 2
+	EDEStaleAnswer                ExtendedErrorCode = 3
+	EDEForgedAnswer               ExtendedErrorCode = 4
+	EDEDNSSECIndeterminate        ExtendedErrorCode = 5
+	EDEDNSSECBogus                ExtendedErrorCode = 6
+	EDESignatureExpired           ExtendedErrorCode = 7
+	EDESignatureNotYetValid       ExtendedErrorCode = 8
+	EDEDNSKEYMissing              ExtendedErrorCode = 9
+	EDERRSIGsMissing              ExtendedErrorCode = 10
+	EDENoZoneKeyBitSet            ExtendedErrorCode = 11
+	EDENSECMissing                ExtendedErrorCode = 12
+	EDECachedError                ExtendedErrorCode = 13
+	EDENotReady                   ExtendedErrorCode = 14
+	EDEBlocked                    ExtendedErrorCode = 15
+	EDECensored                   ExtendedErrorCode = 16
+	EDEFiltered                   ExtendedErrorCode = 17
+	EDEProhibited                 ExtendedErrorCode = 18
+	EDEStaleNXDomainAnswer        ExtendedErrorCode = 19
+	EDENotAuthoritative           ExtendedErrorCode = 20
+	EDENotSupported               ExtendedErrorCode = 21
+	EDENoReachableAuthority       ExtendedErrorCode = 22
+	EDENetworkError               ExtendedErrorCode = 23
+	EDEInvalidData                ExtendedErrorCode = 24
+)
+
+var extendedErrorNames = map[ExtendedErrorCode]string{
+	EDEOther:                      "Other Error",
+	EDEUnsupportedDNSKEYAlgorithm: "Unsupported DNSKEY Algorithm",
+	EDEUnsupportedDSDigestType:    "Unsupported DS Digest Type",
+	EDEStaleAnswer:                "Stale Answer",
+	EDEForgedAnswer:               "Forged Answer",
+	EDEDNSSECIndeterminate:        "DNSSEC Indeterminate",
+	EDEDNSSECBogus:                "DNSSEC Bogus",
+	EDESignatureExpired:           "Signature Expired",
+	EDESignatureNotYetValid:       "Signature Not Yet Valid",
+	EDEDNSKEYMissing:              "DNSKEY Missing",
+	EDERRSIGsMissing:              "RRSIGs Missing",
+	EDENoZoneKeyBitSet:            "No Zone Key Bit Set",
+	EDENSECMissing:                "NSEC Missing",
+	EDECachedError:                "Cached Error",
+	EDENotReady:                   "Not Ready",
+	EDEBlocked:                    "Blocked",
+	EDECensored:                   "Censored",
+	EDEFiltered:                   "Filtered",
+	EDEProhibited:                 "Prohibited",
+	EDEStaleNXDomainAnswer:        "Stale NXDOMAIN Answer",
+	EDENotAuthoritative:           "Not Authoritative",
+	EDENotSupported:               "Not Supported",
+	EDENoReachableAuthority:       "No Reachable Authority",
+	EDENetworkError:               "Network Error",
+	EDEInvalidData:                "Invalid Data",
+}
+
+func (c ExtendedErrorCode) String() string {
+	if name, ok := extendedErrorNames[c]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("Unknown Error %d", uint16(c))
+}
+
+// ExtendedError tells why a response failed, Text is an optional
+// explanation for humans.
+type ExtendedError struct {
+	Code ExtendedErrorCode
+	Text string
+}
+
+// ParseExtendedError decodes the data of an EDE option.
+func ParseExtendedError(data []byte) (*ExtendedError, error) {
+	if len(data) < 2 {
+		return nil, errors.Wrap(ErrMalformedOption, "extended error shorter than its code")
+	}
+
+	return &ExtendedError{
+		Code: ExtendedErrorCode(uint16(data[0])<<8 | uint16(data[1])),
+		Text: string(data[2:]),
+	}, nil
+}
+
+// Bytes encodes the error into the option data format.
+func (e *ExtendedError) Bytes() []byte {
+	data := []byte{byte(e.Code >> 8), byte(e.Code)}
+	return append(data, e.Text...)
+}
+
+func (e *ExtendedError) String() string {
+	if e.Text == "" {
+		return fmt.Sprintf("%d (%s)", uint16(e.Code), e.Code)
+	}
+
+	return fmt.Sprintf("%d (%s): (%s)", uint16(e.Code), e.Code, e.Text)
+}
+
+// ExtendedErrors returns the extended errors of the packet, a response may
+// carry several of them. Malformed options are skipped.
+func (p *DNSPacket) ExtendedErrors() []*ExtendedError {
+	opt := p.OPT()
+	if opt == nil {
+		return nil
+	}
+
+	errs := make([]*ExtendedError, 0)
+	for _, o := range opt.Options {
+		if o.Code != ExtendedErrorOptionCode {
+			continue
+		}
+		if e, err := ParseExtendedError(o.Data); err == nil {
+			errs = append(errs, e)
+		}
+	}
+
+	return errs
+}
+
+// AddExtendedError attaches the error to the packet adding an OPT record if
+// needed. Unlike other options it doesn't replace earlier errors.
+func (p *DNSPacket) AddExtendedError(e *ExtendedError) {
+	opt := p.OPT()
+	if opt == nil {
+		opt = NewOPTRecord(DefaultUDPPayloadSize)
+		p.Resources = append(p.Resources, opt)
+	}
+
+	opt.Options = append(opt.Options, &EDNSOption{Code: ExtendedErrorOptionCode, Data: e.Bytes()})
+}
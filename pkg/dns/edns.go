@@ -0,0 +1,173 @@
+package dns
+
+import (
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/pkg/errors"
+)
+
+// DefaultUDPPayloadSize is the payload size advertised in OPT records created
+// by godns. It matches the size of BytePacketBuffer.
+const DefaultUDPPayloadSize = 512
+
+type EDNSOptionCode uint16
+
+const (
+	NSIDOptionCode          EDNSOptionCode = 3
+	ClientSubnetOptionCode  EDNSOptionCode = 8
+	CookieOptionCode        EDNSOptionCode = 10
+	TCPKeepaliveOptionCode  EDNSOptionCode = 11
+	ExtendedErrorOptionCode EDNSOptionCode = 15
+)
+
+// EDNSOption is a single option carried in the RDATA of an OPT pseudo record
+// as described in RFC6891.
+type EDNSOption struct {
+	Code EDNSOptionCode
+	Data []byte
+}
+
+// NewOPTRecord creates an OPT pseudo record for the additional section.
+// OPT reuses the class field for the requestor's UDP payload size and the TTL
+// field for the extended rcode, version and flags.
+func NewOPTRecord(udpSize uint16) *DNSRecord {
+	return &DNSRecord{
+		QType:  OPTQueryType,
+		Domain: buffer.NewDomainName(""),
+		Class:  udpSize,
+	}
+}
+
+// UDPSize returns the payload size advertised by the OPT record.
+func (r *DNSRecord) UDPSize() uint16 {
+	return r.Class
+}
+
+// ExtendedRCode returns the upper 8 bits of the 12 bit response code.
+func (r *DNSRecord) ExtendedRCode() uint8 {
+	return uint8(r.TTL >> 24)
+}
+
+// SetExtendedRCode stores the upper 8 bits of a 12 bit response code.
+func (r *DNSRecord) SetExtendedRCode(code uint8) {
+	r.TTL = r.TTL&0x00FFFFFF | uint32(code)<<24
+}
+
+// dnssecOK is the DO flag in the TTL field of OPT records (RFC3225).
+const dnssecOK = 0x8000
+
+// DNSSECOK reports whether the sender of the OPT record wants DNSSEC records.
+func (r *DNSRecord) DNSSECOK() bool {
+	return r.TTL&dnssecOK != 0
+}
+
+// SetDNSSECOK sets or clears the DO flag, responses copy it from the query.
+func (r *DNSRecord) SetDNSSECOK(ok bool) {
+	if ok {
+		r.TTL |= dnssecOK
+	} else {
+		r.TTL &^= dnssecOK
+	}
+}
+
+// Option returns the first option with the given code or nil.
+func (r *DNSRecord) Option(code EDNSOptionCode) *EDNSOption {
+	for _, o := range r.Options {
+		if o.Code == code {
+			return o
+		}
+	}
+
+	return nil
+}
+
+// SetOption replaces any existing option with the same code.
+func (r *DNSRecord) SetOption(code EDNSOptionCode, data []byte) {
+	for _, o := range r.Options {
+		if o.Code == code {
+			o.Data = data
+			return
+		}
+	}
+
+	r.Options = append(r.Options, &EDNSOption{Code: code, Data: data})
+}
+
+// OPT returns the OPT pseudo record of the packet or nil when the sender
+// doesn't support EDNS.
+func (p *DNSPacket) OPT() *DNSRecord {
+	for _, r := range p.Resources {
+		if r.QType == OPTQueryType {
+			return r
+		}
+	}
+
+	return nil
+}
+
+// RemoveOPT strips the OPT pseudo record from the additional section. OPT is
+// hop-by-hop so it must never be relayed from an upstream to a client.
+func (p *DNSPacket) RemoveOPT() {
+	resources := make([]*DNSRecord, 0, len(p.Resources))
+	for _, r := range p.Resources {
+		if r.QType != OPTQueryType {
+			resources = append(resources, r)
+		}
+	}
+	p.Resources = resources
+}
+
+func readEDNSOptions(buffer *buffer.BytePacketBuffer, dataLen uint16) ([]*EDNSOption, error) {
+	options := make([]*EDNSOption, 0)
+	end := buffer.Pos() + int(dataLen)
+
+	for buffer.Pos() < end {
+		code, err := buffer.Read16()
+		if err != nil {
+			return nil, errors.Wrap(err, "reading edns option code")
+		}
+
+		optLen, err := buffer.Read16()
+		if err != nil {
+			return nil, errors.Wrap(err, "reading edns option length")
+		}
+
+		if buffer.Pos()+int(optLen) > end {
+			return nil, errors.Wrap(ErrMalformedOption, "option exceeds record data length")
+		}
+
+		data, err := buffer.GetRange(buffer.Pos(), int(optLen))
+		if err != nil {
+			return nil, errors.Wrap(err, "reading edns option data")
+		}
+		buffer.Steps(int(optLen))
+
+		// GetRange returns a view into the buffer which can be reused
+		options = append(options, &EDNSOption{
+			Code: EDNSOptionCode(code),
+			Data: append([]byte(nil), data...),
+		})
+	}
+
+	return options, nil
+}
+
+func writeEDNSOptions(buffer *buffer.BytePacketBuffer, options []*EDNSOption) error {
+	for _, o := range options {
+		err := buffer.Write16(uint16(o.Code))
+		if err != nil {
+			return errors.Wrap(err, "writing edns option code")
+		}
+
+		err = buffer.Write16(uint16(len(o.Data)))
+		if err != nil {
+			return errors.Wrap(err, "writing edns option length")
+		}
+
+		_, err = buffer.Write(o.Data)
+		if err != nil {
+			return errors.Wrap(err, "writing edns option data")
+		}
+	}
+
+	return nil
+}
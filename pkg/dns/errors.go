@@ -0,0 +1,34 @@
+package dns
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrMalformedRecord is returned for records whose data doesn't match
+	// their type or declared length.
+	ErrMalformedRecord = errors.New("malformed dns record")
+	// ErrMalformedOption is returned for EDNS options that can't be decoded.
+	ErrMalformedOption = errors.New("malformed edns option")
+)
+
+// ErrRcode reports a response carrying an error response code. It lets
+// callers branch on the code with errors.As instead of inspecting the packet.
+type ErrRcode struct {
+	Code ResultCode
+}
+
+func (e ErrRcode) Error() string {
+	return fmt.Sprintf("response code %s", e.Code)
+}
+
+// Err returns ErrRcode for responses with a code other than NOERROR.
+func (p *DNSPacket) Err() error {
+	if p.Header.ResCode == NoError {
+		return nil
+	}
+
+	return ErrRcode{Code: p.Header.ResCode}
+}
@@ -0,0 +1,181 @@
+package dns
+
+import (
+	"fmt"
+	"strings"
+)
+
+func (c ResultCode) String() string {
+	switch c {
+	case NoError:
+		return "NOERROR"
+	case FormErr:
+		return "FORMERR"
+	case ServFail:
+		return "SERVFAIL"
+	case NxDomain:
+		return "NXDOMAIN"
+	case NoTimp:
+		return "NOTIMP"
+	case Refused:
+		return "REFUSED"
+	case NotAuth:
+		return "NOTAUTH"
+	case BadCookie:
+		return "BADCOOKIE"
+	default:
+		return fmt.Sprintf("RCODE%d", int(c))
+	}
+}
+
+func opcodeString(opcode uint8) string {
+	switch opcode {
+	case OpcodeQuery:
+		return "QUERY"
+	case 1:
+		return "IQUERY"
+	case 2:
+		return "STATUS"
+	case OpcodeNotify:
+		return "NOTIFY"
+	case 5:
+		return "UPDATE"
+	default:
+		return fmt.Sprintf("OPCODE%d", opcode)
+	}
+}
+
+func classString(class uint16) string {
+	switch class {
+	case ClassIN:
+		return "IN"
+	case ClassCH:
+		return "CH"
+	case ClassHS:
+		return "HS"
+	case ClassANY:
+		return "ANY"
+	default:
+		return fmt.Sprintf("CLASS%d", class)
+	}
+}
+
+// String renders the header the way dig prints it.
+func (h *DNSHeader) String() string {
+	flags := make([]string, 0)
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"qr", h.Response},
+		{"aa", h.AuthoritativeAnswer},
+		{"tc", h.TruncatedMessage},
+		{"rd", h.RecursionDesired},
+		{"ra", h.RecursionAvailable},
+		{"ad", h.AuthedData},
+		{"cd", h.CheckingDisabled},
+	} {
+		if f.set {
+			flags = append(flags, f.name)
+		}
+	}
+
+	return fmt.Sprintf(";; ->>HEADER<<- opcode: %s, status: %s, id: %d\n;; flags: %s; QUERY: %d, ANSWER: %d, AUTHORITY: %d, ADDITIONAL: %d",
+		opcodeString(h.Opcode), h.ResCode, h.ID,
+		strings.Join(flags, " "),
+		h.Questions, h.Answers, h.AuthoritativeEntries, h.ResourceEntries,
+	)
+}
+
+// String renders the question as a commented out record without TTL and data.
+func (q *DNSQuestion) String() string {
+	return fmt.Sprintf(";%s\t\t%s\t%s", fqdn(q.Name), classString(q.Class), q.QType)
+}
+
+// String renders the record in presentation format.
+func (r *DNSRecord) String() string {
+	return fmt.Sprintf("%s\t%d\t%s\t%s\t%s", fqdn(r.Domain), r.TTL, classString(r.Class), r.QType, r.RData())
+}
+
+func (r *DNSRecord) optString() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "; EDNS: version: %d, flags:; udp: %d", uint8(r.TTL>>16), r.UDPSize())
+	for _, o := range r.Options {
+		switch o.Code {
+		case NSIDOptionCode:
+			fmt.Fprintf(&sb, "\n; NSID: %x (%q)", o.Data, o.Data)
+		case CookieOptionCode:
+			fmt.Fprintf(&sb, "\n; COOKIE: %x", o.Data)
+		case TCPKeepaliveOptionCode:
+			if timeout, ok := parseTCPKeepalive(o.Data); ok {
+				fmt.Fprintf(&sb, "\n; TCP-KEEPALIVE: %s", timeout)
+			} else {
+				fmt.Fprintf(&sb, "\n; TCP-KEEPALIVE: %x", o.Data)
+			}
+		case ExtendedErrorOptionCode:
+			if ede, err := ParseExtendedError(o.Data); err == nil {
+				fmt.Fprintf(&sb, "\n; EDE: %s", ede)
+			} else {
+				fmt.Fprintf(&sb, "\n; EDE: %x", o.Data)
+			}
+		case ClientSubnetOptionCode:
+			if ecs, err := ParseClientSubnet(o.Data); err == nil {
+				fmt.Fprintf(&sb, "\n; CLIENT-SUBNET: %s", ecs)
+			} else {
+				fmt.Fprintf(&sb, "\n; CLIENT-SUBNET: %x", o.Data)
+			}
+		default:
+			fmt.Fprintf(&sb, "\n; OPT=%d: %x", o.Code, o.Data)
+		}
+	}
+
+	return sb.String()
+}
+
+// String renders the packet like dig does, with a header followed by the
+// non empty sections.
+func (p *DNSPacket) String() string {
+	var sb strings.Builder
+
+	header := *p.Header
+	header.Questions = uint16(len(p.Questions))
+	header.Answers = uint16(len(p.Answers))
+	header.AuthoritativeEntries = uint16(len(p.Authorities))
+	header.ResourceEntries = uint16(len(p.Resources))
+	sb.WriteString(header.String())
+
+	if opt := p.OPT(); opt != nil {
+		sb.WriteString("\n\n;; OPT PSEUDOSECTION:\n")
+		sb.WriteString(opt.optString())
+	}
+
+	if len(p.Questions) != 0 {
+		sb.WriteString("\n\n;; QUESTION SECTION:")
+		for _, q := range p.Questions {
+			sb.WriteString("\n" + q.String())
+		}
+	}
+
+	sections := []struct {
+		name    string
+		records []*DNSRecord
+	}{
+		{"ANSWER", p.Answers},
+		{"AUTHORITY", p.Authorities},
+		{"ADDITIONAL", p.Resources},
+	}
+	for _, section := range sections {
+		lines := make([]string, 0, len(section.records))
+		for _, r := range section.records {
+			if r.QType != OPTQueryType {
+				lines = append(lines, r.String())
+			}
+		}
+
+		if len(lines) != 0 {
+			fmt.Fprintf(&sb, "\n\n;; %s SECTION:\n%s", section.name, strings.Join(lines, "\n"))
+		}
+	}
+
+	return sb.String()
+}
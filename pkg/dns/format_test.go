@@ -0,0 +1,140 @@
+package dns_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+func TestDNSPacketJSON(t *testing.T) {
+	t.Run("marshal_a_type_response", func(t *testing.T) {
+		packetBinary, err := ioutil.ReadFile(filepath.Join("../testfixtures", "response_A_packet.txt"))
+		NoError(t, err, "failed read")
+		buffer := buffer.NewBytePacketBuffer()
+		buffer.Buf = packetBinary
+		packet := dns.NewDNSPacket()
+		packet.Read(buffer)
+
+		data, err := json.Marshal(packet)
+		NoError(t, err)
+		JSONEq(t, `{
+			"Status": 0, "TC": false, "RD": true, "RA": true, "AD": false, "CD": false,
+			"Question": [{"name": "www.google.com.", "type": 1}],
+			"Answer": [{"name": "www.google.com.", "type": 1, "TTL": 300, "data": "172.217.164.100"}]
+		}`, string(data))
+	})
+
+	t.Run("unmarshal_round_trip", func(t *testing.T) {
+		data := `{
+			"Status": 3, "TC": false, "RD": true, "RA": true, "AD": false, "CD": false,
+			"Question": [{"name": "example.com.", "type": 15}],
+			"Answer": [{"name": "example.com.", "type": 15, "TTL": 60, "data": "10 mail.example.com."}],
+			"Authority": [{"name": "example.com.", "type": 6, "TTL": 60,
+				"data": "ns.example.com. admin.example.com. 1 7200 3600 1209600 300"}]
+		}`
+
+		packet := dns.NewDNSPacket()
+		NoError(t, json.Unmarshal([]byte(data), packet))
+		Equal(t, dns.NxDomain, packet.Header.ResCode)
+		Equal(t, "example.com", packet.Questions[0].Name.String())
+		Equal(t, uint16(10), packet.Answers[0].Priority)
+		Equal(t, "mail.example.com", packet.Answers[0].Host.String())
+		Equal(t, uint32(300), packet.Authorities[0].Minimum)
+
+		encoded, err := json.Marshal(packet)
+		NoError(t, err)
+		JSONEq(t, data, string(encoded))
+	})
+}
+
+func TestDNSRecordText(t *testing.T) {
+	t.Run("round_trip_records", func(t *testing.T) {
+		for _, line := range []string{
+			"www.example.com. 300 IN A 1.2.3.4",
+			"www.example.com. 300 IN AAAA 2001:db8::1",
+			"example.com. 3600 IN NS ns1.example.com.",
+			"example.com. 60 IN MX 10 mail.example.com.",
+			"example.com. 60 IN SOA ns.example.com. admin.example.com. 1 7200 3600 1209600 300",
+			"example.com. 300 IN HTTPS 1 . alpn=h2,h3 ipv4hint=1.2.3.4 ech=AEX+DQ== ipv6hint=2001:db8::1",
+			"example.com. 300 IN TYPE99 \\# 4 0a000001",
+			"example.com. 300 IN TYPE260 \\# 0",
+			"_dns.example.com. 300 IN SVCB 1 dns.example.com. mandatory=alpn,port alpn=dot port=853 key667=\"a\\032b\"",
+			"_dns.resolver.arpa. 300 IN SVCB 1 dns.example.com. alpn=h2 port=8443 dohpath=\"/dns-query{?dns}\"",
+		} {
+			r, err := dns.ParseRecord(line, 0)
+			NoError(t, err, line)
+			Equal(t, line, r.Text())
+		}
+	})
+
+	t.Run("optional_ttl_and_class", func(t *testing.T) {
+		r, err := dns.ParseRecord("www.example.com IN 60 CNAME example.com ; comment", 300)
+		NoError(t, err)
+		Equal(t, "www.example.com. 60 IN CNAME example.com.", r.Text())
+
+		r, err = dns.ParseRecord("www.example.com A 1.2.3.4", 300)
+		NoError(t, err)
+		Equal(t, uint32(300), r.TTL)
+	})
+
+	t.Run("svcb_wire_round_trip", func(t *testing.T) {
+		r, err := dns.ParseRecord("example.com. 300 IN HTTPS 1 svc.example.com. alpn=h3 no-default-alpn port=8443 ipv4hint=1.2.3.4,5.6.7.8", 0)
+		NoError(t, err)
+
+		packet := dns.NewDNSPacket()
+		packet.Answers = append(packet.Answers, r)
+		buf := buffer.NewBytePacketBuffer()
+		NoError(t, packet.Write(buf))
+		buf.Seek(0)
+
+		parsed, err := dns.DNSPacketFromBuffer(buf)
+		NoError(t, err)
+		https := parsed.Answers[0]
+		Equal(t, []string{"h3"}, https.ALPN())
+		port, ok := https.Port()
+		True(t, ok)
+		Equal(t, uint16(8443), port)
+		Equal(t, "5.6.7.8", https.IPv4Hint()[1].String())
+		Equal(t, r.Text(), https.Text())
+	})
+
+	t.Run("unknown_type_wire_round_trip", func(t *testing.T) {
+		// TXT isn't understood by godns and must pass through untouched
+		r, err := dns.ParseRecord(`example.com. 300 IN TYPE16 \# 6 05 68656c6c6f`, 0)
+		NoError(t, err)
+
+		packet := dns.NewDNSPacket()
+		packet.Answers = append(packet.Answers, r)
+		buf := buffer.NewBytePacketBuffer()
+		NoError(t, packet.Write(buf))
+		buf.Seek(0)
+
+		parsed, err := dns.DNSPacketFromBuffer(buf)
+		NoError(t, err)
+		Equal(t, []byte("\x05hello"), parsed.Answers[0].Data)
+		Equal(t, `example.com. 300 IN TXT \# 6 0568656c6c6f`, parsed.Answers[0].Text())
+	})
+
+	t.Run("invalid_records", func(t *testing.T) {
+		_, err := dns.ParseRecord("www.example.com. 300 IN A", 0)
+		Error(t, err)
+
+		_, err = dns.ParseRecord("www.example.com. 300 IN A 2001:db8::1", 0)
+		Error(t, err)
+
+		_, err = dns.ParseRecord("www.example.com. 300 IN BOGUS x", 0)
+		Error(t, err)
+
+		_, err = dns.ParseRecord("example.com. 300 IN HTTPS 1 . port=99999", 0)
+		Error(t, err)
+
+		_, err = dns.ParseRecord(`example.com. 300 IN TYPE99 \# 3 0a00`, 0)
+		Error(t, err)
+	})
+}
@@ -0,0 +1,49 @@
+package dns_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+// FuzzDNSPacketFromBuffer feeds arbitrary datagrams to the parser. Parsing may
+// fail but must never panic or hang, and whatever parses must encode again.
+func FuzzDNSPacketFromBuffer(f *testing.F) {
+	fixtures, err := filepath.Glob(filepath.Join("../testfixtures", "*.txt"))
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	for _, fixture := range fixtures {
+		data, err := ioutil.ReadFile(fixture)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		buf := buffer.NewBytePacketBuffer()
+		buf.Buf = data
+
+		packet, err := dns.DNSPacketFromBuffer(buf)
+		if err != nil {
+			return
+		}
+
+		_ = packet.String()
+
+		out := buffer.NewBytePacketBuffer()
+		if err := packet.Write(out); err != nil {
+			return
+		}
+		out.Seek(0)
+
+		if _, err := dns.DNSPacketFromBuffer(out); err != nil {
+			t.Fatalf("re-parsing encoded packet: %s", err)
+		}
+	})
+}
@@ -0,0 +1,98 @@
+package dns_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+// TestGoldenFixtures decodes every captured packet, encodes it again and
+// compares the bytes. The fixtures are compressed canonically, every name
+// points at the first occurrence of its longest known suffix, so encoding
+// must reproduce them exactly.
+func TestGoldenFixtures(t *testing.T) {
+	tests := []struct {
+		fixture string
+		qtype   dns.QueryType
+		answers []string
+	}{
+		{"answer_NS_packet.txt", dns.NSQueryType, []string{
+			"google.com. 345600 IN NS ns2.google.com.",
+			"google.com. 345600 IN NS ns1.google.com.",
+			"google.com. 345600 IN NS ns4.google.com.",
+			"google.com. 345600 IN NS ns3.google.com.",
+		}},
+		{"answer_MX_packet.txt", dns.MXQueryType, []string{
+			"gmail.com. 3600 IN MX 5 gmail-smtp-in.l.google.com.",
+			"gmail.com. 3600 IN MX 10 alt1.gmail-smtp-in.l.google.com.",
+			"gmail.com. 3600 IN MX 20 alt2.gmail-smtp-in.l.google.com.",
+			"gmail.com. 3600 IN MX 30 alt3.gmail-smtp-in.l.google.com.",
+			"gmail.com. 3600 IN MX 40 alt4.gmail-smtp-in.l.google.com.",
+		}},
+		{"answer_AAAA_packet.txt", dns.AAAAQueryType, []string{
+			"www.google.com. 300 IN AAAA 2607:f8b0:4005:80f::2004",
+		}},
+		{"answer_SOA_packet.txt", dns.SOAQueryType, []string{
+			"google.com. 60 IN SOA ns1.google.com. dns-admin.google.com. 362483190 900 900 1800 60",
+		}},
+		{"answer_TXT_packet.txt", dns.TXTQueryType, []string{
+			`example.com. 86400 IN TXT \# 12 0b763d73706631202d616c6c`,
+			`example.com. 86400 IN TXT \# 33 2077677966387a386367766d32716d78706e626e6c6472636c74766b347871666e`,
+		}},
+		{"answer_SRV_packet.txt", dns.SRVQueryType, []string{
+			`_xmpp-server._tcp.gmail.com. 900 IN SRV \# 32 0005000014950b786d70702d736572766572016c06676f6f676c6503636f6d00`,
+			`_xmpp-server._tcp.gmail.com. 900 IN SRV \# 37 00140000149504616c74310b786d70702d736572766572016c06676f6f676c6503636f6d00`,
+			`_xmpp-server._tcp.gmail.com. 900 IN SRV \# 37 00140000149504616c74320b786d70702d736572766572016c06676f6f676c6503636f6d00`,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			packetBinary, err := ioutil.ReadFile(filepath.Join("../testfixtures", tt.fixture))
+			NoError(t, err, "failed read")
+
+			packet := decode(t, packetBinary)
+			Equal(t, tt.qtype, packet.Questions[0].QType)
+
+			answers := make([]string, 0, len(packet.Answers))
+			for _, r := range packet.Answers {
+				Equal(t, tt.qtype, r.QType)
+				answers = append(answers, r.Text())
+			}
+			Equal(t, tt.answers, answers)
+		})
+	}
+
+	fixtures, err := filepath.Glob(filepath.Join("../testfixtures", "*.txt"))
+	NoError(t, err)
+
+	for _, fixture := range fixtures {
+		t.Run("round_trip_"+filepath.Base(fixture), func(t *testing.T) {
+			packetBinary, err := ioutil.ReadFile(fixture)
+			NoError(t, err, "failed read")
+
+			out := buffer.NewBytePacketBuffer()
+			NoError(t, decode(t, packetBinary).Write(out))
+			Equal(t, packetBinary, out.Buf[:out.Pos()])
+		})
+	}
+}
+
+func decode(t *testing.T, packetBinary []byte) *dns.DNSPacket {
+	t.Helper()
+
+	packetBuffer := buffer.NewBytePacketBuffer()
+	packetBuffer.Buf = packetBinary
+
+	packet, err := dns.DNSPacketFromBuffer(packetBuffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return packet
+}
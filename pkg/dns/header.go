@@ -6,7 +6,9 @@ import (
 	"github.com/pkg/errors"
 )
 
-type ResultCode int8
+// ResultCode is the response code of a message, 12 bits long when the
+// upper 8 bits are carried by an OPT record (RFC6891 6.1.3).
+type ResultCode uint16
 
 const (
 	NoError ResultCode = iota
@@ -17,6 +19,22 @@ const (
 	Refused
 )
 
+// NotAuth tells that the server isn't authoritative for the zone of a
+// NOTIFY or UPDATE (RFC2136).
+const NotAuth ResultCode = 9
+
+// Opcodes of the kinds of messages godns handles.
+const (
+	OpcodeQuery  uint8 = 0
+	OpcodeNotify uint8 = 4
+)
+
+// Extended response codes only fit in a packet carrying an OPT record, the
+// upper 8 bits are stored in the OPT TTL field.
+const (
+	BadCookie ResultCode = 23
+)
+
 // DNSPacketReadWriter implements dns packet reader and writer.
 // Based on RFC1035 dns request/response should be 512 byte long
 type DNSPacketReadWriter interface {
@@ -28,7 +46,7 @@ type DNSPacketReadWriter interface {
 
 // DNSHeader contains header information.
 // This should be 12 byte long header based on DNS RFC but golang doesn't have 4
-// bit types. So ResultCode is 8bit int, bool types is also 8bits.
+// bit types. So the opcode is 8bit int, bool types is also 8bits.
 type DNSHeader struct {
 	ID                   uint16
 	RecursionDesired     bool
@@ -129,13 +147,13 @@ func (h *DNSHeader) Write(buffer *buffer.BytePacketBuffer) error {
 	err = buffer.Write8(utils.BoolToUint8(h.RecursionDesired) |
 		(utils.BoolToUint8(h.TruncatedMessage) << 1) |
 		(utils.BoolToUint8(h.AuthoritativeAnswer) << 2) |
-		(h.Opcode << 3) |
+		((h.Opcode & 0x0F) << 3) |
 		(utils.BoolToUint8(h.Response) << 7))
 	if err != nil {
 		return errors.Wrap(err, "writing dns header flags first byte")
 	}
 
-	err = buffer.Write8(uint8(h.ResCode) |
+	err = buffer.Write8(uint8(h.ResCode)&0x0F |
 		utils.BoolToUint8(h.CheckingDisabled)<<4 |
 		utils.BoolToUint8(h.AuthedData)<<5 |
 		utils.BoolToUint8(h.Z)<<6 |
@@ -182,6 +200,7 @@ func (d *DNSHeader) GetResCode(code uint8) ResultCode {
 	case 5:
 		return Refused
 	default:
-		return NoError
+		// Keep the raw bits, they may be the lower half of an extended code
+		return ResultCode(code)
 	}
 }
@@ -0,0 +1,45 @@
+package dns
+
+import (
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/idna"
+)
+
+func unicodeName(name *buffer.DomainName) *buffer.DomainName {
+	if name == nil {
+		return nil
+	}
+
+	return buffer.NewDomainName(idna.ToUnicode(name.String()))
+}
+
+// ToUnicode returns a copy of the packet with every A-label rendered as its
+// U-label. The copy is meant for display, names in it may not be encodable.
+func (p *DNSPacket) ToUnicode() *DNSPacket {
+	header := *p.Header
+	packet := &DNSPacket{Header: &header}
+
+	for _, q := range p.Questions {
+		question := *q
+		question.Name = unicodeName(q.Name)
+		packet.Questions = append(packet.Questions, &question)
+	}
+
+	records := func(section []*DNSRecord) []*DNSRecord {
+		converted := make([]*DNSRecord, 0, len(section))
+		for _, r := range section {
+			record := *r
+			record.Domain = unicodeName(r.Domain)
+			record.Host = unicodeName(r.Host)
+			record.MailHost = unicodeName(r.MailHost)
+			converted = append(converted, &record)
+		}
+		return converted
+	}
+
+	packet.Answers = records(p.Answers)
+	packet.Authorities = records(p.Authorities)
+	packet.Resources = records(p.Resources)
+
+	return packet
+}
@@ -0,0 +1,130 @@
+package dns
+
+import (
+	"encoding/json"
+)
+
+// The JSON representation follows the DNS over HTTPS JSON API used by Google
+// and Cloudflare, see https://developers.google.com/speed/public-dns/docs/doh/json
+
+type jsonQuestion struct {
+	Name string    `json:"name"`
+	Type QueryType `json:"type"`
+}
+
+type jsonRecord struct {
+	Name string    `json:"name"`
+	Type QueryType `json:"type"`
+	TTL  uint32    `json:"TTL"`
+	Data string    `json:"data"`
+}
+
+type jsonPacket struct {
+	Status     ResultCode     `json:"Status"`
+	TC         bool           `json:"TC"`
+	RD         bool           `json:"RD"`
+	RA         bool           `json:"RA"`
+	AD         bool           `json:"AD"`
+	CD         bool           `json:"CD"`
+	Question   []*DNSQuestion `json:"Question"`
+	Answer     []*DNSRecord   `json:"Answer,omitempty"`
+	Authority  []*DNSRecord   `json:"Authority,omitempty"`
+	Additional []*DNSRecord   `json:"Additional,omitempty"`
+}
+
+func (q *DNSQuestion) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonQuestion{
+		Name: fqdn(q.Name),
+		Type: q.QType,
+	})
+}
+
+func (q *DNSQuestion) UnmarshalJSON(data []byte) error {
+	var jq jsonQuestion
+	if err := json.Unmarshal(data, &jq); err != nil {
+		return err
+	}
+
+	*q = *NewDNSQuestion(parseName(jq.Name).String(), jq.Type)
+	return nil
+}
+
+func (r *DNSRecord) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonRecord{
+		Name: fqdn(r.Domain),
+		Type: r.QType,
+		TTL:  r.TTL,
+		Data: r.RData(),
+	})
+}
+
+func (r *DNSRecord) UnmarshalJSON(data []byte) error {
+	var jr jsonRecord
+	if err := json.Unmarshal(data, &jr); err != nil {
+		return err
+	}
+
+	rec := DNSRecord{
+		QType:  jr.Type,
+		Domain: parseName(jr.Name),
+		Class:  ClassIN,
+		TTL:    jr.TTL,
+	}
+	if err := rec.SetRData(jr.Data); err != nil {
+		return err
+	}
+
+	*r = rec
+	return nil
+}
+
+// MarshalJSON drops the OPT pseudo record, EDNS has no place in the schema.
+func (p *DNSPacket) MarshalJSON() ([]byte, error) {
+	additional := make([]*DNSRecord, 0, len(p.Resources))
+	for _, r := range p.Resources {
+		if r.QType != OPTQueryType {
+			additional = append(additional, r)
+		}
+	}
+
+	return json.Marshal(jsonPacket{
+		Status:     p.Header.ResCode,
+		TC:         p.Header.TruncatedMessage,
+		RD:         p.Header.RecursionDesired,
+		RA:         p.Header.RecursionAvailable,
+		AD:         p.Header.AuthedData,
+		CD:         p.Header.CheckingDisabled,
+		Question:   p.Questions,
+		Answer:     p.Answers,
+		Authority:  p.Authorities,
+		Additional: additional,
+	})
+}
+
+func (p *DNSPacket) UnmarshalJSON(data []byte) error {
+	var jp jsonPacket
+	if err := json.Unmarshal(data, &jp); err != nil {
+		return err
+	}
+
+	packet := NewDNSPacket()
+	packet.Header.Response = true
+	packet.Header.ResCode = jp.Status
+	packet.Header.TruncatedMessage = jp.TC
+	packet.Header.RecursionDesired = jp.RD
+	packet.Header.RecursionAvailable = jp.RA
+	packet.Header.AuthedData = jp.AD
+	packet.Header.CheckingDisabled = jp.CD
+	packet.Questions = jp.Question
+	packet.Answers = jp.Answer
+	packet.Authorities = jp.Authority
+	packet.Resources = jp.Additional
+
+	packet.Header.Questions = uint16(len(packet.Questions))
+	packet.Header.Answers = uint16(len(packet.Answers))
+	packet.Header.AuthoritativeEntries = uint16(len(packet.Authorities))
+	packet.Header.ResourceEntries = uint16(len(packet.Resources))
+
+	*p = *packet
+	return nil
+}
@@ -0,0 +1,58 @@
+package dns
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// tcpKeepaliveUnit is the unit of the edns-tcp-keepalive timeout.
+const tcpKeepaliveUnit = 100 * time.Millisecond
+
+// TCPKeepalive returns the timeout of the edns-tcp-keepalive option
+// (RFC7828) of the packet and whether it has a valid one. Queries carry it
+// without a timeout, returned as 0, to ask how long the server keeps their
+// connection open while idle.
+func (p *DNSPacket) TCPKeepalive() (time.Duration, bool) {
+	opt := p.OPT()
+	if opt == nil {
+		return 0, false
+	}
+
+	o := opt.Option(TCPKeepaliveOptionCode)
+	if o == nil {
+		return 0, false
+	}
+
+	return parseTCPKeepalive(o.Data)
+}
+
+// SetTCPKeepalive attaches the edns-tcp-keepalive option with the idle
+// timeout to the packet adding an OPT record if needed. The timeout is
+// rounded down to 100ms units, a zero timeout tells the client to close the
+// connection.
+func (p *DNSPacket) SetTCPKeepalive(timeout time.Duration) {
+	opt := p.OPT()
+	if opt == nil {
+		opt = NewOPTRecord(DefaultUDPPayloadSize)
+		p.Resources = append(p.Resources, opt)
+	}
+
+	units := timeout / tcpKeepaliveUnit
+	if units > 0xFFFF {
+		units = 0xFFFF
+	}
+	data := make([]byte, 2)
+	binary.BigEndian.PutUint16(data, uint16(units))
+	opt.SetOption(TCPKeepaliveOptionCode, data)
+}
+
+func parseTCPKeepalive(data []byte) (time.Duration, bool) {
+	switch len(data) {
+	case 0:
+		return 0, true
+	case 2:
+		return time.Duration(binary.BigEndian.Uint16(data)) * tcpKeepaliveUnit, true
+	default:
+		return 0, false
+	}
+}
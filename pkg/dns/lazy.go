@@ -0,0 +1,81 @@
+package dns
+
+import (
+	"encoding/binary"
+
+	buf "github.com/msarvar/godns/pkg/buffer"
+	"github.com/pkg/errors"
+)
+
+// LazyPacket is a message of which only the header and questions are decoded,
+// enough to route or relay it. The records are decoded on first use, a
+// message that is only relayed never has them materialized.
+type LazyPacket struct {
+	Header    *DNSHeader
+	Questions []*DNSQuestion
+
+	raw    []byte
+	packet *DNSPacket
+}
+
+// ParseLazy decodes the header and questions of the message. The message
+// isn't copied and must not be modified while the packet is used.
+func ParseLazy(data []byte) (*LazyPacket, error) {
+	packetBuffer := buf.NewBytePacketBuffer()
+	packetBuffer.Buf = data
+
+	header := NewDNSHeader()
+	if err := header.Read(packetBuffer); err != nil {
+		return nil, errors.Wrap(err, "reading header")
+	}
+
+	questions := make([]*DNSQuestion, 0, header.Questions)
+	for i := 0; i < int(header.Questions); i++ {
+		question := NewDNSQuestion("", UnknownQueryType)
+		if err := question.Read(packetBuffer); err != nil {
+			return nil, errors.Wrapf(err, "reading dns question at offset %d", question.Offset)
+		}
+		questions = append(questions, question)
+	}
+
+	return &LazyPacket{
+		Header:    header,
+		Questions: questions,
+		raw:       data,
+	}, nil
+}
+
+// Raw returns the message as it was received apart from the id, which SetID
+// changes in place.
+func (p *LazyPacket) Raw() []byte {
+	return p.raw
+}
+
+// SetID changes the id of the message without decoding or encoding it, e.g.
+// to relay a response under the id of the client's query.
+func (p *LazyPacket) SetID(id uint16) {
+	binary.BigEndian.PutUint16(p.raw, id)
+	p.Header.ID = id
+	if p.packet != nil {
+		p.packet.Header.ID = id
+	}
+}
+
+// Packet decodes the whole message on first use. The header then also carries
+// the extended response code from the OPT record.
+func (p *LazyPacket) Packet() (*DNSPacket, error) {
+	if p.packet != nil {
+		return p.packet, nil
+	}
+
+	packetBuffer := buf.NewBytePacketBuffer()
+	packetBuffer.Buf = p.raw
+
+	packet, err := DNSPacketFromBuffer(packetBuffer)
+	if err != nil {
+		return nil, err
+	}
+	p.packet = packet
+
+	return packet, nil
+}
@@ -0,0 +1,87 @@
+package dns_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+func TestLazyPacket(t *testing.T) {
+	packetBinary, err := ioutil.ReadFile(filepath.Join("../testfixtures", "response_CNAME_packet.txt"))
+	NoError(t, err, "failed read")
+
+	t.Run("decodes_questions_up_front", func(t *testing.T) {
+		lazy, err := dns.ParseLazy(packetBinary)
+		NoError(t, err)
+
+		Equal(t, 1, len(lazy.Questions))
+		Equal(t, "www.yahoo.com", lazy.Questions[0].Name.String())
+		Equal(t, packetBinary, lazy.Raw())
+	})
+
+	t.Run("records_match_full_parse", func(t *testing.T) {
+		lazy, err := dns.ParseLazy(packetBinary)
+		NoError(t, err)
+
+		full := buffer.NewBytePacketBuffer()
+		full.Buf = packetBinary
+		want, err := dns.DNSPacketFromBuffer(full)
+		NoError(t, err)
+
+		got, err := lazy.Packet()
+		NoError(t, err)
+		Equal(t, want, got)
+	})
+
+	t.Run("set_id_rewrites_message", func(t *testing.T) {
+		raw := append([]byte(nil), packetBinary...)
+		lazy, err := dns.ParseLazy(raw)
+		NoError(t, err)
+
+		lazy.SetID(4660)
+		Equal(t, []byte{0x12, 0x34}, lazy.Raw()[:2])
+
+		packet, err := lazy.Packet()
+		NoError(t, err)
+		Equal(t, uint16(4660), packet.Header.ID)
+	})
+
+	t.Run("truncated_question", func(t *testing.T) {
+		_, err := dns.ParseLazy(packetBinary[:14])
+		ErrorIs(t, err, buffer.ErrTruncated)
+	})
+}
+
+// BenchmarkParse compares decoding a whole response with decoding only what a
+// relay needs.
+func BenchmarkParse(b *testing.B) {
+	packetBinary, err := ioutil.ReadFile(filepath.Join("../testfixtures", "response_CNAME_packet.txt"))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("full", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			packetBuffer := buffer.NewBytePacketBuffer()
+			packetBuffer.Buf = packetBinary
+			if _, err := dns.DNSPacketFromBuffer(packetBuffer); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("lazy", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := dns.ParseLazy(packetBinary); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,30 @@
+package dns
+
+// NSID returns the payload of the NSID option (RFC5001) of the packet and
+// whether it has one. Queries carry it empty to ask for the identifier of
+// the server answering them.
+func (p *DNSPacket) NSID() ([]byte, bool) {
+	opt := p.OPT()
+	if opt == nil {
+		return nil, false
+	}
+
+	o := opt.Option(NSIDOptionCode)
+	if o == nil {
+		return nil, false
+	}
+
+	return o.Data, true
+}
+
+// SetNSID attaches the NSID option to the packet adding an OPT record if
+// needed, an empty id asks the server for its identifier.
+func (p *DNSPacket) SetNSID(id []byte) {
+	opt := p.OPT()
+	if opt == nil {
+		opt = NewOPTRecord(DefaultUDPPayloadSize)
+		p.Resources = append(p.Resources, opt)
+	}
+
+	opt.SetOption(NSIDOptionCode, id)
+}
@@ -1,7 +1,6 @@
 package dns
 
 import (
-	"fmt"
 	"math/rand"
 	"net"
 	"strings"
@@ -25,37 +24,6 @@ func NewDNSPacket() *DNSPacket {
 	}
 }
 
-func (p *DNSPacket) String() string {
-
-	qStr := make([]string, 0)
-	for _, q := range p.Questions {
-		qStr = append(qStr, fmt.Sprintf("%+v", q))
-	}
-
-	answers := make([]string, 0)
-	for _, q := range p.Answers {
-		answers = append(answers, fmt.Sprintf("%+v", q))
-	}
-
-	authorities := make([]string, 0)
-	for _, q := range p.Authorities {
-		authorities = append(authorities, fmt.Sprintf("%+v", q))
-	}
-
-	resources := make([]string, 0)
-	for _, q := range p.Resources {
-		resources = append(resources, fmt.Sprintf("%+v", q))
-	}
-
-	return fmt.Sprintf("Header: %+v\nQuestions: [%s]\nAnswers: [%s]\nAuthorities: [%s]\nResources [%s]",
-		p.Header,
-		strings.Join(qStr, ","),
-		strings.Join(answers, ","),
-		strings.Join(authorities, ","),
-		strings.Join(resources, ","),
-	)
-}
-
 func (p *DNSPacket) Read(buffer *buf.BytePacketBuffer) error {
 	err := p.Header.Read(buffer)
 	if err != nil {
@@ -67,7 +35,7 @@ func (p *DNSPacket) Read(buffer *buf.BytePacketBuffer) error {
 		question := NewDNSQuestion("", UnknownQueryType)
 		err := question.Read(buffer)
 		if err != nil {
-			return errors.Wrap(err, "reading dns question")
+			return errors.Wrapf(err, "reading dns question at offset %d", question.Offset)
 		}
 
 		questions = append(questions, question)
@@ -79,7 +47,7 @@ func (p *DNSPacket) Read(buffer *buf.BytePacketBuffer) error {
 		rec := DNSRecord{}
 		err := rec.Read(buffer)
 		if err != nil {
-			return errors.Wrap(err, "reading dns record answers")
+			return errors.Wrapf(err, "reading dns record answers at offset %d", rec.Offset)
 		}
 
 		answers = append(answers, &rec)
@@ -91,7 +59,7 @@ func (p *DNSPacket) Read(buffer *buf.BytePacketBuffer) error {
 		rec := DNSRecord{}
 		err := rec.Read(buffer)
 		if err != nil {
-			return errors.Wrap(err, "reading dns record authoritative entries")
+			return errors.Wrapf(err, "reading dns record authoritative entries at offset %d", rec.Offset)
 		}
 
 		authorities = append(authorities, &rec)
@@ -103,25 +71,39 @@ func (p *DNSPacket) Read(buffer *buf.BytePacketBuffer) error {
 		rec := DNSRecord{}
 		err := rec.Read(buffer)
 		if err != nil {
-			return errors.Wrap(err, "reading dns record resources")
+			return errors.Wrapf(err, "reading dns record resources at offset %d", rec.Offset)
 		}
 
 		resources = append(resources, &rec)
 	}
 	p.Resources = resources
 
+	if opt := p.OPT(); opt != nil && opt.ExtendedRCode() != 0 {
+		p.Header.ResCode = ResultCode(int(opt.ExtendedRCode())<<4 | int(p.Header.ResCode))
+	}
+
 	return nil
 }
 
 func (p *DNSPacket) Write(buffer *buf.BytePacketBuffer) error {
-	// Populating packet header with right array length for questions, answers,
-	// authEntries, and resourceEntries
-	p.Header.Questions = uint16(len(p.Questions))
-	p.Header.Answers = uint16(len(p.Answers))
-	p.Header.AuthoritativeEntries = uint16(len(p.Authorities))
-	p.Header.ResourceEntries = uint16(len(p.Resources))
-
-	err := p.Header.Write(buffer)
+	// The header is written from a copy, writing leaves the packet alone so
+	// cached and shared packets encode the same way every time
+	header := *p.Header
+
+	// Response codes above 15 need the OPT record to carry the upper bits,
+	// an OPT record kept from another packet mustn't carry stale ones
+	resources := p.Resources
+	if p.OPT() == nil && header.ResCode > 0x0F {
+		resources = append(resources[:len(resources):len(resources)], NewOPTRecord(DefaultUDPPayloadSize))
+	}
+
+	start := buffer.Pos()
+	header.Questions = uint16(len(p.Questions))
+	header.Answers = uint16(len(p.Answers))
+	header.AuthoritativeEntries = uint16(len(p.Authorities))
+	header.ResourceEntries = uint16(len(resources))
+
+	err := header.Write(buffer)
 	if err != nil {
 		return errors.Wrap(err, "writing header information")
 	}
@@ -134,30 +116,81 @@ func (p *DNSPacket) Write(buffer *buf.BytePacketBuffer) error {
 		}
 	}
 
-	for _, a := range p.Answers {
-		_, err = a.Write(buffer)
-		if err != nil {
-			return errors.Wrap(err, "updating packet with answers")
-		}
+	// Records that don't fit are left out whole. Missing answers or
+	// authorities set TC so the client retries over TCP, missing additional
+	// records don't (RFC2181 9).
+	answers, err := writeRecords(buffer, p.Answers)
+	if err != nil {
+		return errors.Wrap(err, "updating packet with answers")
 	}
 
-	for _, auth := range p.Authorities {
-		_, err = auth.Write(buffer)
+	authorities := 0
+	if answers == len(p.Answers) {
+		authorities, err = writeRecords(buffer, p.Authorities)
 		if err != nil {
 			return errors.Wrap(err, "updating packet with authoritative answers")
 		}
 	}
+	truncated := answers < len(p.Answers) || authorities < len(p.Authorities)
+
+	// Truncated responses still carry the OPT record (RFC6891 7), additional
+	// records that don't fit are skipped
+	written := 0
+	for _, r := range resources {
+		if r.QType == OPTQueryType {
+			opt := *r
+			opt.SetExtendedRCode(uint8(header.ResCode >> 4))
+			r = &opt
+		} else if header.TruncatedMessage || truncated {
+			continue
+		}
 
-	for _, r := range p.Resources {
-		_, err = r.Write(buffer)
+		n, err := writeRecords(buffer, []*DNSRecord{r})
 		if err != nil {
 			return errors.Wrap(err, "updating packet with resource entries")
 		}
+		written += n
+	}
+
+	if !truncated && written == len(resources) {
+		return nil
 	}
 
+	// Some records were left out, the counts in the header must match what
+	// was written
+	end := buffer.Pos()
+	header.TruncatedMessage = header.TruncatedMessage || truncated
+	header.Answers = uint16(answers)
+	header.AuthoritativeEntries = uint16(authorities)
+	header.ResourceEntries = uint16(written)
+
+	buffer.Seek(start)
+	if err := header.Write(buffer); err != nil {
+		return errors.Wrap(err, "rewriting header information")
+	}
+	buffer.Seek(end)
+
 	return nil
 }
 
+// writeRecords writes records until one doesn't fit into the buffer and
+// returns how many were written. The record that didn't fit is rolled back.
+func writeRecords(buffer *buf.BytePacketBuffer, records []*DNSRecord) (int, error) {
+	for i, r := range records {
+		pos := buffer.Pos()
+		if _, err := r.Write(buffer); err != nil {
+			if !errors.Is(err, buf.ErrBufferOverflow) {
+				return i, err
+			}
+
+			buffer.Truncate(pos)
+			return i, nil
+		}
+	}
+
+	return len(records), nil
+}
+
 func (p *DNSPacket) GetRandomA() net.IP {
 	rand.Seed(time.Now().UnixNano())
 
@@ -180,9 +213,10 @@ type DomainHostTuple []string
 
 func (p *DNSPacket) getNS(qname string) []DomainHostTuple {
 	domainHostTuple := make([]DomainHostTuple, 0)
+	name := buf.NewDomainName(qname)
 
 	for _, record := range p.Authorities {
-		if record.QType == NSQueryType && strings.HasSuffix(qname, record.Domain.String()) {
+		if record.QType == NSQueryType && name.IsSubdomainOf(record.Domain) {
 			domainHostTuple = append(
 				domainHostTuple,
 				DomainHostTuple{
@@ -195,10 +229,27 @@ func (p *DNSPacket) getNS(qname string) []DomainHostTuple {
 	return domainHostTuple
 }
 
+// GetReferralZone returns the zone a referral response delegates qname to, or
+// an empty string when the response isn't a referral.
+func (p *DNSPacket) GetReferralZone(qname string) string {
+	if len(p.Answers) != 0 {
+		return ""
+	}
+
+	zone := ""
+	for _, tuple := range p.getNS(qname) {
+		if len(tuple[0]) > len(zone) {
+			zone = tuple[0]
+		}
+	}
+
+	return zone
+}
+
 func (p *DNSPacket) GetResolverNS(qname string) net.IP {
 	for _, tuple := range p.getNS(qname) {
 		for _, r := range p.Resources {
-			if r.QType == AQueryType && tuple[1] == r.Domain.String() {
+			if r.QType == AQueryType && strings.EqualFold(tuple[1], r.Domain.String()) {
 				return r.Addr
 			}
 		}
@@ -206,6 +257,21 @@ func (p *DNSPacket) GetResolverNS(qname string) net.IP {
 	return nil
 }
 
+// GetResolverNSAddrs returns the A and AAAA glue addresses of every name
+// server qname is delegated to.
+func (p *DNSPacket) GetResolverNSAddrs(qname string) []net.IP {
+	addrs := make([]net.IP, 0)
+	for _, tuple := range p.getNS(qname) {
+		for _, r := range p.Resources {
+			if (r.QType == AQueryType || r.QType == AAAAQueryType) && strings.EqualFold(tuple[1], r.Domain.String()) {
+				addrs = append(addrs, r.Addr)
+			}
+		}
+	}
+
+	return addrs
+}
+
 func (p *DNSPacket) GetUnresolvedNS(qname string) string {
 	for _, tuple := range p.getNS(qname) {
 		if tuple[1] != "" {
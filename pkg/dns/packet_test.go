@@ -1,9 +1,14 @@
 package dns_test
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"net"
 	"path/filepath"
 	"testing"
+	"time"
 
 	. "github.com/stretchr/testify/assert"
 
@@ -92,6 +97,418 @@ func TestDNSPacket(t *testing.T) {
 		}
 	})
 
+	t.Run("records_wire_offsets", func(t *testing.T) {
+		packetBinary, err := ioutil.ReadFile(filepath.Join("../testfixtures", "response_A_packet.txt"))
+		NoError(t, err, "failed read")
+		buffer := buffer.NewBytePacketBuffer()
+		buffer.Buf = packetBinary
+		packet := dns.NewDNSPacket()
+		packet.Read(buffer)
+
+		// header is always 12 bytes long
+		Equal(t, 12, packet.Questions[0].Offset)
+		Equal(t, 20, packet.Questions[0].WireLength)
+
+		// answer name is a 2 byte pointer to the question name
+		Equal(t, 32, packet.Answers[0].Offset)
+		Equal(t, 16, packet.Answers[0].WireLength)
+		Equal(t, len(packetBinary), packet.Answers[0].Offset+packet.Answers[0].WireLength)
+	})
+
+	t.Run("round_trip_multiple_questions", func(t *testing.T) {
+		packet := dns.NewDNSPacket()
+		packet.Header.ID = 4660
+		packet.Header.Response = true
+		packet.Questions = append(packet.Questions,
+			dns.NewDNSQuestion("www.example.com", dns.AQueryType),
+			dns.NewDNSQuestion("example.com", dns.MXQueryType),
+			dns.NewDNSQuestion("www.example.org", dns.AAAAQueryType),
+		)
+		packet.Questions[1].Class = 3
+		for _, line := range []string{
+			"www.example.com. 300 IN A 192.0.2.1",
+			"example.com. 300 IN MX 10 mail.example.com.",
+			"www.example.org. 300 IN AAAA 2001:db8::1",
+		} {
+			r, err := dns.ParseRecord(line, 0)
+			NoError(t, err)
+			packet.Answers = append(packet.Answers, r)
+		}
+		packet.Resources = append(packet.Resources, dns.NewOPTRecord(dns.DefaultUDPPayloadSize))
+
+		buf := buffer.NewBytePacketBuffer()
+		NoError(t, packet.Write(buf))
+		data := append([]byte(nil), buf.Buf[:buf.Pos()]...)
+		buf.Seek(0)
+
+		check := func(t *testing.T, questions []*dns.DNSQuestion) {
+			if !Len(t, questions, 3) {
+				return
+			}
+			for i, q := range questions {
+				Equal(t, packet.Questions[i].Name.String(), q.Name.String())
+				Equal(t, packet.Questions[i].QType, q.QType)
+				Equal(t, packet.Questions[i].Class, q.Class)
+			}
+			// Each question starts where the previous one ends, later ones
+			// point at the names of earlier ones
+			Equal(t, 12, questions[0].Offset)
+			Equal(t, questions[0].Offset+questions[0].WireLength, questions[1].Offset)
+			Equal(t, questions[1].Offset+questions[1].WireLength, questions[2].Offset)
+			Equal(t, 6, questions[1].WireLength)
+		}
+
+		parsed, err := dns.DNSPacketFromBuffer(buf)
+		if NoError(t, err) {
+			Equal(t, uint16(3), parsed.Header.Questions)
+			check(t, parsed.Questions)
+			if Len(t, parsed.Answers, 3) {
+				Equal(t, "mail.example.com", parsed.Answers[1].Host.String())
+				Equal(t, "2001:db8::1", parsed.Answers[2].Addr.String())
+			}
+			NotNil(t, parsed.OPT())
+
+			again := buffer.NewBytePacketBuffer()
+			NoError(t, parsed.Write(again))
+			Equal(t, data, again.Buf[:again.Pos()])
+		}
+
+		lazy, err := dns.ParseLazy(data)
+		if NoError(t, err) {
+			check(t, lazy.Questions)
+			full, err := lazy.Packet()
+			NoError(t, err)
+			Len(t, full.Answers, 3)
+		}
+
+		encoded, err := json.Marshal(packet)
+		NoError(t, err)
+		var decoded dns.DNSPacket
+		NoError(t, json.Unmarshal(encoded, &decoded))
+		if Len(t, decoded.Questions, 3) {
+			Equal(t, "www.example.org", decoded.Questions[2].Name.String())
+		}
+	})
+
+	t.Run("truncated_multiple_questions_keep_their_count", func(t *testing.T) {
+		packet := dns.NewDNSPacket()
+		packet.Header.Response = true
+		packet.Questions = append(packet.Questions,
+			dns.NewDNSQuestion("www.example.com", dns.AQueryType),
+			dns.NewDNSQuestion("www.example.net", dns.AQueryType),
+		)
+		for i := 0; i < 60; i++ {
+			r, err := dns.ParseRecord(fmt.Sprintf("www.example.com. 300 IN A 192.0.2.%d", i), 0)
+			NoError(t, err)
+			packet.Answers = append(packet.Answers, r)
+		}
+
+		buf := buffer.NewBytePacketBuffer()
+		NoError(t, packet.Write(buf))
+		buf.Seek(0)
+
+		parsed, err := dns.DNSPacketFromBuffer(buf)
+		if NoError(t, err) {
+			True(t, parsed.Header.TruncatedMessage)
+			Len(t, parsed.Questions, 2)
+			Equal(t, "www.example.net", parsed.Questions[1].Name.String())
+		}
+	})
+
+	t.Run("round_trip_cookie_and_extended_rcode", func(t *testing.T) {
+		packet := dns.NewDNSPacket()
+		packet.Header.ResCode = dns.BadCookie
+		packet.Questions = append(packet.Questions, dns.NewDNSQuestion("www.google.com", dns.AQueryType))
+		packet.SetCookie(&dns.Cookie{
+			Client: []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			Server: []byte{9, 10, 11, 12, 13, 14, 15, 16},
+		})
+
+		buf := buffer.NewBytePacketBuffer()
+		NoError(t, packet.Write(buf))
+		buf.Seek(0)
+
+		parsed, err := dns.DNSPacketFromBuffer(buf)
+		NoError(t, err)
+		Equal(t, dns.BadCookie, parsed.Header.ResCode)
+		Equal(t, "www.google.com", parsed.Questions[0].Name.String())
+
+		cookie, err := parsed.Cookie()
+		NoError(t, err)
+		Equal(t, []byte{1, 2, 3, 4, 5, 6, 7, 8}, cookie.Client)
+		Equal(t, []byte{9, 10, 11, 12, 13, 14, 15, 16}, cookie.Server)
+
+		// The upper bits left in the OPT record don't outlive the code
+		parsed.Header.ResCode = dns.NoError
+		out := buffer.NewBytePacketBuffer()
+		NoError(t, parsed.Write(out))
+		out.Seek(0)
+
+		reparsed, err := dns.DNSPacketFromBuffer(out)
+		NoError(t, err)
+		Equal(t, dns.NoError, reparsed.Header.ResCode)
+	})
+
+	t.Run("write_leaves_packet_alone", func(t *testing.T) {
+		write := func(packet *dns.DNSPacket) []byte {
+			buf := buffer.NewBytePacketBuffer()
+			NoError(t, packet.Write(buf))
+			data, err := buf.GetRangeAtPos()
+			NoError(t, err)
+			return data
+		}
+
+		packet := dns.NewDNSPacket()
+		packet.Header.ResCode = dns.BadCookie
+		packet.Questions = append(packet.Questions, dns.NewDNSQuestion("www.google.com", dns.AQueryType))
+		first := write(packet)
+		Empty(t, packet.Resources)
+		Equal(t, first, write(packet))
+
+		packet.Resources = append(packet.Resources, dns.NewOPTRecord(dns.DefaultUDPPayloadSize))
+		first = write(packet)
+		Equal(t, uint8(0), packet.OPT().ExtendedRCode())
+		Equal(t, first, write(packet))
+
+		for i := 0; i < 60; i++ {
+			r, err := dns.ParseRecord(fmt.Sprintf("www.google.com. 300 IN A 192.0.2.%d", i), 0)
+			NoError(t, err)
+			packet.Answers = append(packet.Answers, r)
+		}
+		first = write(packet)
+		False(t, packet.Header.TruncatedMessage)
+		Equal(t, first, write(packet))
+	})
+
+	t.Run("malformed_cookie", func(t *testing.T) {
+		_, err := dns.ParseCookie([]byte{1, 2, 3})
+		ErrorIs(t, err, dns.ErrMalformedOption)
+
+		_, err = dns.ParseCookie([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9})
+		ErrorIs(t, err, dns.ErrMalformedOption)
+	})
+
+	t.Run("round_trip_client_subnet", func(t *testing.T) {
+		packet := dns.NewDNSPacket()
+		packet.SetClientSubnet(dns.NewClientSubnet(net.ParseIP("2001:db8:1234:5678::1"), 56))
+
+		buf := buffer.NewBytePacketBuffer()
+		NoError(t, packet.Write(buf))
+		buf.Seek(0)
+
+		parsed, err := dns.DNSPacketFromBuffer(buf)
+		NoError(t, err)
+		ecs, err := parsed.ClientSubnet()
+		NoError(t, err)
+		Equal(t, "2001:db8:1234:5600::/56/0", ecs.String())
+		Equal(t, []byte{0, 2, 56, 0, 0x20, 0x01, 0x0d, 0xb8, 0x12, 0x34, 0x56}, ecs.Bytes())
+		Equal(t, "2001:db8:1234::/48/0", ecs.Truncate(48).String())
+	})
+
+	t.Run("round_trip_nsid", func(t *testing.T) {
+		query := dns.NewDNSPacket()
+		_, ok := query.NSID()
+		False(t, ok)
+		query.SetNSID(nil)
+
+		buf := buffer.NewBytePacketBuffer()
+		NoError(t, query.Write(buf))
+		buf.Seek(0)
+		parsed, err := dns.DNSPacketFromBuffer(buf)
+		NoError(t, err)
+		id, ok := parsed.NSID()
+		True(t, ok, "empty options are kept")
+		Empty(t, id)
+
+		response := dns.NewDNSPacket()
+		response.SetNSID([]byte("dns1.fra"))
+		buf = buffer.NewBytePacketBuffer()
+		NoError(t, response.Write(buf))
+		buf.Seek(0)
+		parsed, err = dns.DNSPacketFromBuffer(buf)
+		NoError(t, err)
+		id, ok = parsed.NSID()
+		True(t, ok)
+		Equal(t, []byte("dns1.fra"), id)
+		Contains(t, parsed.String(), `; NSID: 646e73312e667261 ("dns1.fra")`)
+	})
+
+	t.Run("round_trip_tcp_keepalive", func(t *testing.T) {
+		query := dns.NewDNSPacket()
+		_, ok := query.TCPKeepalive()
+		False(t, ok)
+		query.Resources = append(query.Resources, dns.NewOPTRecord(1232))
+		query.OPT().SetOption(dns.TCPKeepaliveOptionCode, nil)
+		timeout, ok := query.TCPKeepalive()
+		True(t, ok)
+		Zero(t, timeout)
+
+		response := dns.NewDNSPacket()
+		response.SetTCPKeepalive(10*time.Second + 50*time.Millisecond)
+		buf := buffer.NewBytePacketBuffer()
+		NoError(t, response.Write(buf))
+		buf.Seek(0)
+		parsed, err := dns.DNSPacketFromBuffer(buf)
+		NoError(t, err)
+		timeout, ok = parsed.TCPKeepalive()
+		True(t, ok)
+		Equal(t, 10*time.Second, timeout)
+		Contains(t, parsed.String(), "; TCP-KEEPALIVE: 10s")
+
+		response.SetTCPKeepalive(time.Hour * 24)
+		timeout, _ = response.TCPKeepalive()
+		Equal(t, 0xFFFF*100*time.Millisecond, timeout)
+
+		response.OPT().SetOption(dns.TCPKeepaliveOptionCode, []byte{1})
+		_, ok = response.TCPKeepalive()
+		False(t, ok)
+	})
+
+	t.Run("malformed_client_subnet", func(t *testing.T) {
+		// address longer than the prefix
+		_, err := dns.ParseClientSubnet([]byte{0, 1, 8, 0, 10, 0})
+		ErrorIs(t, err, dns.ErrMalformedOption)
+
+		// bits set past the prefix
+		_, err = dns.ParseClientSubnet([]byte{0, 1, 7, 0, 11})
+		ErrorIs(t, err, dns.ErrMalformedOption)
+
+		_, err = dns.ParseClientSubnet([]byte{0, 3, 0, 0})
+		ErrorIs(t, err, dns.ErrMalformedOption)
+	})
+
+	t.Run("round_trip_extended_errors", func(t *testing.T) {
+		packet := dns.NewDNSPacket()
+		packet.Header.ResCode = dns.ServFail
+		packet.AddExtendedError(&dns.ExtendedError{Code: dns.EDENetworkError})
+		packet.AddExtendedError(&dns.ExtendedError{Code: dns.EDEBlocked, Text: "ads"})
+
+		buf := buffer.NewBytePacketBuffer()
+		NoError(t, packet.Write(buf))
+		buf.Seek(0)
+
+		parsed, err := dns.DNSPacketFromBuffer(buf)
+		NoError(t, err)
+		errs := parsed.ExtendedErrors()
+		Len(t, errs, 2)
+		Equal(t, "23 (Network Error)", errs[0].String())
+		Equal(t, "15 (Blocked): (ads)", errs[1].String())
+	})
+
+	t.Run("truncated_packet", func(t *testing.T) {
+		packetBinary, err := ioutil.ReadFile(filepath.Join("../testfixtures", "response_A_packet.txt"))
+		NoError(t, err, "failed read")
+		buf := buffer.NewBytePacketBuffer()
+		buf.Buf = packetBinary[:len(packetBinary)-2]
+
+		_, err = dns.DNSPacketFromBuffer(buf)
+		ErrorIs(t, err, buffer.ErrTruncated)
+	})
+
+	t.Run("answers_that_dont_fit_set_tc", func(t *testing.T) {
+		packet := dns.NewDNSPacket()
+		packet.Header.Response = true
+		packet.Questions = append(packet.Questions, dns.NewDNSQuestion("www.example.com", dns.AQueryType))
+		for i := 0; i < 60; i++ {
+			r, err := dns.ParseRecord(fmt.Sprintf("www.example.com. 300 IN A 192.0.2.%d", i), 0)
+			NoError(t, err)
+			packet.Answers = append(packet.Answers, r)
+		}
+		ns, _ := dns.ParseRecord("example.com. 300 IN NS ns.example.com.", 0)
+		packet.Authorities = append(packet.Authorities, ns)
+		packet.Resources = append(packet.Resources, dns.NewOPTRecord(dns.DefaultUDPPayloadSize))
+
+		buf := buffer.NewBytePacketBuffer()
+		NoError(t, packet.Write(buf))
+		LessOrEqual(t, buf.Pos(), 512)
+		buf.Seek(0)
+
+		parsed, err := dns.DNSPacketFromBuffer(buf)
+		NoError(t, err)
+		True(t, parsed.Header.TruncatedMessage)
+		Less(t, len(parsed.Answers), 60)
+		Greater(t, len(parsed.Answers), 20)
+		Empty(t, parsed.Authorities)
+		NotNil(t, parsed.OPT())
+	})
+
+	t.Run("additional_records_that_dont_fit_are_dropped", func(t *testing.T) {
+		packet := dns.NewDNSPacket()
+		packet.Header.Response = true
+		packet.Questions = append(packet.Questions, dns.NewDNSQuestion("example.com", dns.NSQueryType))
+		for i := 0; i < 15; i++ {
+			ns, _ := dns.ParseRecord(fmt.Sprintf("example.com. 300 IN NS ns%d.example.com.", i), 0)
+			glue, _ := dns.ParseRecord(fmt.Sprintf("ns%d.example.com. 300 IN AAAA 2001:db8::%d", i, i), 0)
+			packet.Answers = append(packet.Answers, ns)
+			packet.Resources = append(packet.Resources, glue)
+		}
+
+		buf := buffer.NewBytePacketBuffer()
+		NoError(t, packet.Write(buf))
+		buf.Seek(0)
+
+		parsed, err := dns.DNSPacketFromBuffer(buf)
+		NoError(t, err)
+		False(t, parsed.Header.TruncatedMessage)
+		Len(t, parsed.Answers, 15)
+		Less(t, len(parsed.Resources), 15)
+		for i, r := range parsed.Resources {
+			Equal(t, packet.Resources[i].Text(), r.Text())
+		}
+	})
+
+	t.Run("error_response_code", func(t *testing.T) {
+		packet := dns.NewDNSPacket()
+		NoError(t, packet.Err())
+
+		packet.Header.ResCode = dns.NxDomain
+		var rcodeErr dns.ErrRcode
+		True(t, errors.As(packet.Err(), &rcodeErr))
+		Equal(t, dns.NxDomain, rcodeErr.Code)
+	})
+
+	t.Run("dig_style_string", func(t *testing.T) {
+		packetBinary, err := ioutil.ReadFile(filepath.Join("../testfixtures", "response_A_packet.txt"))
+		NoError(t, err, "failed read")
+		buffer := buffer.NewBytePacketBuffer()
+		buffer.Buf = packetBinary
+		packet := dns.NewDNSPacket()
+		packet.Read(buffer)
+		packet.Header.ID = 4660
+
+		Equal(t, `;; ->>HEADER<<- opcode: QUERY, status: NOERROR, id: 4660
+;; flags: qr rd ra; QUERY: 1, ANSWER: 1, AUTHORITY: 0, ADDITIONAL: 0
+
+;; QUESTION SECTION:
+;www.google.com.		IN	A
+
+;; ANSWER SECTION:
+www.google.com.	300	IN	A	172.217.164.100`, packet.String())
+	})
+
 	// TODO: Add tests for other query types
 	// SOA, MX, NS, AAAA
 }
+
+func BenchmarkWrite(b *testing.B) {
+	packetBinary, err := ioutil.ReadFile(filepath.Join("../testfixtures", "response_CNAME_packet.txt"))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	packetBuffer := buffer.NewBytePacketBuffer()
+	packetBuffer.Buf = packetBinary
+	packet, err := dns.DNSPacketFromBuffer(packetBuffer)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		out := buffer.AcquireBytePacketBuffer()
+		if err := packet.Write(out); err != nil {
+			b.Fatal(err)
+		}
+		out.Release()
+	}
+}
@@ -0,0 +1,320 @@
+package dns_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+// packetGenerator builds random valid packets, every type godns encodes
+// with random names, TTLs and header fields.
+type packetGenerator struct {
+	rand *rand.Rand
+}
+
+func (g *packetGenerator) packet() *dns.DNSPacket {
+	r := g.rand
+	packet := dns.NewDNSPacket()
+	// TC is left clear, truncated messages leave the additional records
+	// out on purpose
+	*packet.Header = dns.DNSHeader{
+		ID:                  uint16(r.Intn(0x10000)),
+		RecursionDesired:    r.Intn(2) == 0,
+		AuthoritativeAnswer: r.Intn(2) == 0,
+		Opcode:              uint8(r.Intn(16)),
+		Response:            r.Intn(2) == 0,
+		CheckingDisabled:    r.Intn(2) == 0,
+		AuthedData:          r.Intn(2) == 0,
+		Z:                   r.Intn(2) == 0,
+		RecursionAvailable:  r.Intn(2) == 0,
+	}
+	// Extended response codes take all 12 bits, the OPT record is added
+	// when writing
+	if r.Intn(4) == 0 {
+		packet.Header.ResCode = dns.ResultCode(r.Intn(0x1000))
+	} else {
+		packet.Header.ResCode = dns.ResultCode(r.Intn(16))
+	}
+
+	for i := r.Intn(3); i > 0; i-- {
+		q := dns.NewDNSQuestion(g.name(), g.qtype())
+		q.Class = []uint16{dns.ClassIN, dns.ClassCH, dns.ClassANY}[r.Intn(3)]
+		packet.Questions = append(packet.Questions, q)
+	}
+	for i := r.Intn(5); i > 0; i-- {
+		packet.Answers = append(packet.Answers, g.record())
+	}
+	for i := r.Intn(3); i > 0; i-- {
+		packet.Authorities = append(packet.Authorities, g.record())
+	}
+	for i := r.Intn(3); i > 0; i-- {
+		packet.Resources = append(packet.Resources, g.record())
+	}
+	if r.Intn(2) == 0 {
+		packet.Resources = append(packet.Resources, g.opt())
+	}
+
+	return packet
+}
+
+func (g *packetGenerator) name() string {
+	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-"
+	r := g.rand
+
+	labels := make([]string, 1+r.Intn(4))
+	for i := range labels {
+		label := make([]byte, 1+r.Intn(20))
+		for j := range label {
+			label[j] = letters[r.Intn(len(letters))]
+		}
+		labels[i] = string(label)
+	}
+
+	return strings.Join(labels, ".")
+}
+
+func (g *packetGenerator) qtype() dns.QueryType {
+	types := []dns.QueryType{
+		dns.AQueryType, dns.NSQueryType, dns.CNAMEQueryType, dns.SOAQueryType,
+		dns.PTRQueryType, dns.MXQueryType, dns.TXTQueryType, dns.AAAAQueryType,
+		dns.SRVQueryType, dns.DSQueryType, dns.RRSIGQueryType, dns.NSECQueryType,
+		dns.DNSKEYQueryType, dns.SVCBQueryType, dns.HTTPSQueryType,
+		dns.AXFRQueryType, dns.ANYQueryType,
+	}
+
+	return types[g.rand.Intn(len(types))]
+}
+
+func (g *packetGenerator) bytes(n int) []byte {
+	b := make([]byte, n)
+	g.rand.Read(b)
+	return b
+}
+
+func (g *packetGenerator) record() *dns.DNSRecord {
+	r := g.rand
+	name := g.name()
+	ttl := r.Uint32()
+
+	var record *dns.DNSRecord
+	var err error
+	switch qtype := g.qtype(); qtype {
+	case dns.AQueryType:
+		record, err = dns.NewARecord(name, net.IP(g.bytes(4)), ttl)
+	case dns.AAAAQueryType:
+		ip := g.bytes(16)
+		// Keep it from reading as an IPv4 mapped address
+		ip[0] |= 0x20
+		record, err = dns.NewAAAARecord(name, ip, ttl)
+	case dns.NSQueryType:
+		record, err = dns.NewNSRecord(name, g.name(), ttl)
+	case dns.CNAMEQueryType:
+		record, err = dns.NewCNAMERecord(name, g.name(), ttl)
+	case dns.PTRQueryType:
+		record, err = dns.NewPTRRecord(name, g.name(), ttl)
+	case dns.MXQueryType:
+		record, err = dns.NewMXRecord(name, uint16(r.Intn(0x10000)), g.name(), ttl)
+	case dns.SOAQueryType:
+		record, err = dns.NewSOARecord(name, g.name(), g.name(), r.Uint32(), r.Uint32(), r.Uint32(), r.Uint32(), r.Uint32(), ttl)
+	case dns.TXTQueryType:
+		texts := make([]string, 1+r.Intn(3))
+		for i := range texts {
+			texts[i] = string(g.bytes(r.Intn(256)))
+		}
+		record, err = dns.NewTXTRecord(name, ttl, texts...)
+	case dns.SRVQueryType:
+		record, err = dns.NewSRVRecord(name, dns.SRV{
+			Priority: uint16(r.Intn(0x10000)),
+			Weight:   uint16(r.Intn(0x10000)),
+			Port:     uint16(r.Intn(0x10000)),
+			Target:   g.name(),
+		}, ttl)
+	case dns.SVCBQueryType, dns.HTTPSQueryType:
+		record = &dns.DNSRecord{
+			QType:    qtype,
+			Domain:   buffer.NewDomainName(name),
+			Class:    dns.ClassIN,
+			TTL:      ttl,
+			Priority: uint16(r.Intn(0x10000)),
+			Host:     buffer.NewDomainName(g.name()),
+			Params:   g.svcParams(),
+		}
+	default:
+		// DNSSEC and other types are kept as opaque data, like the types
+		// godns doesn't know (RFC3597)
+		if r.Intn(4) == 0 {
+			qtype = dns.QueryType(1000 + r.Intn(1000))
+		}
+		data := g.bytes(r.Intn(100))
+		record = &dns.DNSRecord{
+			QType:   qtype,
+			Domain:  buffer.NewDomainName(name),
+			Class:   dns.ClassIN,
+			TTL:     ttl,
+			Data:    data,
+			DataLen: uint16(len(data)),
+		}
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	return record
+}
+
+func (g *packetGenerator) svcParams() []*dns.SvcParam {
+	r := g.rand
+
+	var params []*dns.SvcParam
+	if r.Intn(2) == 0 {
+		var alpn []byte
+		for _, id := range []string{"h2", "h3", "dot"}[:1+r.Intn(3)] {
+			alpn = append(append(alpn, byte(len(id))), id...)
+		}
+		params = append(params, &dns.SvcParam{Key: dns.SvcParamALPN, Value: alpn})
+	}
+	if r.Intn(2) == 0 {
+		port := make([]byte, 2)
+		binary.BigEndian.PutUint16(port, uint16(r.Intn(0x10000)))
+		params = append(params, &dns.SvcParam{Key: dns.SvcParamPort, Value: port})
+	}
+	if r.Intn(2) == 0 {
+		params = append(params, &dns.SvcParam{Key: dns.SvcParamIPv4Hint, Value: g.bytes(4 * (1 + r.Intn(3)))})
+	}
+	if r.Intn(2) == 0 {
+		params = append(params, &dns.SvcParam{Key: dns.SvcParamIPv6Hint, Value: g.bytes(16 * (1 + r.Intn(2)))})
+	}
+	if r.Intn(2) == 0 {
+		params = append(params, &dns.SvcParam{Key: dns.SvcParamDoHPath, Value: []byte("/dns-query{?dns}")})
+	}
+
+	return params
+}
+
+func (g *packetGenerator) opt() *dns.DNSRecord {
+	r := g.rand
+	opt := dns.NewOPTRecord(uint16(512 + r.Intn(4096)))
+	opt.SetDNSSECOK(r.Intn(2) == 0)
+	if r.Intn(2) == 0 {
+		opt.SetOption(dns.NSIDOptionCode, g.bytes(r.Intn(16)))
+	}
+	if r.Intn(2) == 0 {
+		opt.SetOption(dns.CookieOptionCode, g.bytes(8))
+	}
+
+	return opt
+}
+
+// comparable strips what only decoding sets, the offsets, lengths and
+// counts, and the cached labels of names. Empty and nil slices are the same
+// on the wire.
+func comparable(p *dns.DNSPacket) *dns.DNSPacket {
+	header := *p.Header
+	header.Questions, header.Answers, header.AuthoritativeEntries, header.ResourceEntries = 0, 0, 0, 0
+	out := &dns.DNSPacket{Header: &header}
+
+	name := func(n *buffer.DomainName) *buffer.DomainName {
+		if n == nil {
+			return nil
+		}
+		return buffer.NewDomainName(n.String())
+	}
+
+	for _, q := range p.Questions {
+		copied := *q
+		copied.Name = name(q.Name)
+		copied.Offset, copied.WireLength = 0, 0
+		out.Questions = append(out.Questions, &copied)
+	}
+
+	section := func(records []*dns.DNSRecord) []*dns.DNSRecord {
+		var out []*dns.DNSRecord
+		for _, r := range records {
+			copied := *r
+			copied.Domain = name(r.Domain)
+			copied.Host = name(r.Host)
+			copied.MailHost = name(r.MailHost)
+			copied.Offset, copied.WireLength, copied.DataLen = 0, 0, 0
+			if r.Addr != nil {
+				copied.Addr = r.Addr.To16()
+			}
+			copied.Options = nil
+			for _, o := range r.Options {
+				option := *o
+				if len(o.Data) == 0 {
+					option.Data = nil
+				}
+				copied.Options = append(copied.Options, &option)
+			}
+			if len(r.Params) == 0 {
+				copied.Params = nil
+			}
+			if len(r.Data) == 0 {
+				copied.Data = nil
+			}
+			out = append(out, &copied)
+		}
+		return out
+	}
+	out.Answers = section(p.Answers)
+	out.Authorities = section(p.Authorities)
+	out.Resources = section(p.Resources)
+
+	return out
+}
+
+// TestEncodeDecodeProperty checks decode(encode(p)) == p under
+// canonicalization for random packets, a field the codec truncates or
+// drops shows up as a difference.
+func TestEncodeDecodeProperty(t *testing.T) {
+	const packets = 2000
+	g := &packetGenerator{rand: rand.New(rand.NewSource(1))}
+
+	for i := 0; i < packets; i++ {
+		packet := g.packet()
+
+		buf := buffer.NewBytePacketBuffer()
+		buf.Buf = make([]byte, dns.MaxMessageSize)
+		if !NoError(t, packet.Write(buf), "packet %d", i) {
+			return
+		}
+		encoded := append([]byte(nil), buf.Buf[:buf.Pos()]...)
+
+		decoded, err := dns.DNSPacketFromBuffer(&buffer.BytePacketBuffer{Buf: encoded})
+		if !NoError(t, err, "packet %d: %x", i, encoded) {
+			return
+		}
+
+		// Writing completes the encoding with the upper bits of an extended
+		// response code in an OPT record, the packet itself is left alone
+		if packet.OPT() == nil && packet.Header.ResCode > 0x0F {
+			packet.Resources = append(packet.Resources, dns.NewOPTRecord(dns.DefaultUDPPayloadSize))
+		}
+		if opt := packet.OPT(); opt != nil {
+			opt.SetExtendedRCode(uint8(packet.Header.ResCode >> 4))
+		}
+		want, err := packet.Canonical()
+		NoError(t, err)
+		got, err := decoded.Canonical()
+		NoError(t, err)
+		if !Equal(t, comparable(want), comparable(got), "packet %d:\n%s", i, packet) {
+			return
+		}
+
+		// Decoding gave the same packet, so it encodes to the same bytes
+		out := buffer.NewBytePacketBuffer()
+		out.Buf = make([]byte, dns.MaxMessageSize)
+		NoError(t, decoded.Write(out))
+		if !True(t, bytes.Equal(encoded, out.Buf[:out.Pos()]), "packet %d re-encodes differently", i) {
+			return
+		}
+	}
+}
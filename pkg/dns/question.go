@@ -17,14 +17,39 @@ func (q QueryType) String() string {
 		return "NS"
 	case MXQueryType:
 		return "MX"
+	case TXTQueryType:
+		return "TXT"
 	case CNAMEQueryType:
 		return "CNAME"
+	case PTRQueryType:
+		return "PTR"
 	case AAAAQueryType:
 		return "AAAA"
+	case SRVQueryType:
+		return "SRV"
 	case SOAQueryType:
 		return "SOA"
+	case OPTQueryType:
+		return "OPT"
+	case DSQueryType:
+		return "DS"
+	case RRSIGQueryType:
+		return "RRSIG"
+	case NSECQueryType:
+		return "NSEC"
+	case DNSKEYQueryType:
+		return "DNSKEY"
+	case SVCBQueryType:
+		return "SVCB"
+	case HTTPSQueryType:
+		return "HTTPS"
+	case AXFRQueryType:
+		return "AXFR"
+	case ANYQueryType:
+		return "ANY"
 	default:
-		return fmt.Sprintf("%v", int(q))
+		// Generic form of RFC3597
+		return fmt.Sprintf("TYPE%d", int(q))
 	}
 }
 
@@ -34,25 +59,53 @@ const (
 	NSQueryType      QueryType = 2
 	CNAMEQueryType   QueryType = 5
 	SOAQueryType     QueryType = 6
+	PTRQueryType     QueryType = 12
 	MXQueryType      QueryType = 15
+	TXTQueryType     QueryType = 16
 	AAAAQueryType    QueryType = 28
+	SRVQueryType     QueryType = 33
+	OPTQueryType     QueryType = 41
+	DSQueryType      QueryType = 43
+	RRSIGQueryType   QueryType = 46
+	NSECQueryType    QueryType = 47
+	DNSKEYQueryType  QueryType = 48
+	SVCBQueryType    QueryType = 64
+	HTTPSQueryType   QueryType = 65
+	AXFRQueryType    QueryType = 252
+	ANYQueryType     QueryType = 255
+)
+
+// Classes of questions and records (RFC1035 3.2.4), nearly everything is IN.
+// CH is left to server identification like version.bind.
+const (
+	ClassIN  uint16 = 1
+	ClassCH  uint16 = 3
+	ClassHS  uint16 = 4
+	ClassANY uint16 = 255
 )
 
 type DNSQuestion struct {
 	Name  *buffer.DomainName
 	Class uint16
 	QType QueryType
+
+	// Offset and WireLength locate the question inside the packet it was read
+	// from. Both are zero for questions that were never parsed.
+	Offset     int
+	WireLength int
 }
 
 func NewDNSQuestion(qname string, qtype QueryType) *DNSQuestion {
 	return &DNSQuestion{
 		Name:  buffer.NewDomainName(qname),
-		Class: 1,
+		Class: ClassIN,
 		QType: qtype,
 	}
 }
 
 func (q *DNSQuestion) Read(buffer *buffer.BytePacketBuffer) error {
+	q.Offset = buffer.Pos()
+
 	err := buffer.ReadQname(q.Name)
 	if err != nil {
 		return errors.Wrap(err, "reading dns question name")
@@ -71,6 +124,7 @@ func (q *DNSQuestion) Read(buffer *buffer.BytePacketBuffer) error {
 		return errors.Wrap(err, "reading dns question query class")
 	}
 	q.Class = c
+	q.WireLength = buffer.Pos() - q.Offset
 
 	return nil
 }
@@ -86,7 +140,6 @@ func (q *DNSQuestion) Write(buffer *buffer.BytePacketBuffer) error {
 		return errors.Wrap(err, "writing query type")
 	}
 
-	// Class of the DNSQuestion in practise always 1
 	err = buffer.Write16(q.Class)
 	if err != nil {
 		return errors.Wrap(err, "writing query class")
@@ -0,0 +1,142 @@
+package dns
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/pkg/errors"
+)
+
+// fqdn renders a domain name with the trailing dot used by presentation
+// formats.
+func fqdn(name *buffer.DomainName) string {
+	if name == nil {
+		return "."
+	}
+
+	return strings.TrimSuffix(name.String(), ".") + "."
+}
+
+// parseName is the inverse of fqdn.
+func parseName(name string) *buffer.DomainName {
+	return buffer.NewDomainName(strings.TrimSuffix(name, "."))
+}
+
+// RData renders the record data in presentation format, e.g. "10 mx.example.com."
+// for MX records.
+func (r *DNSRecord) RData() string {
+	switch r.QType {
+	case AQueryType, AAAAQueryType:
+		if r.Addr == nil {
+			return ""
+		}
+		return r.Addr.String()
+	case NSQueryType, CNAMEQueryType, PTRQueryType:
+		return fqdn(r.Host)
+	case MXQueryType:
+		return fmt.Sprintf("%d %s", r.Priority, fqdn(r.Host))
+	case SOAQueryType:
+		return fmt.Sprintf("%s %s %d %d %d %d %d",
+			fqdn(r.Host), fqdn(r.MailHost), r.Serial, r.Refresh, r.Retry, r.Expire, r.Minimum)
+	case SVCBQueryType, HTTPSQueryType:
+		return r.svcbRData()
+	default:
+		return genericRData(r.Data)
+	}
+}
+
+// SetRData parses presentation format record data for the record's type.
+func (r *DNSRecord) SetRData(data string) error {
+	fields := strings.Fields(data)
+
+	switch r.QType {
+	case AQueryType, AAAAQueryType:
+		ip := net.ParseIP(data)
+		if ip == nil {
+			return errors.Errorf("invalid address %q", data)
+		}
+		if (r.QType == AQueryType) != (ip.To4() != nil) {
+			return errors.Errorf("address %q doesn't match record type %s", data, r.QType)
+		}
+		r.Addr = ip
+	case NSQueryType, CNAMEQueryType, PTRQueryType:
+		if len(fields) != 1 {
+			return errors.Errorf("invalid %s data %q", r.QType, data)
+		}
+		r.Host = parseName(fields[0])
+	case MXQueryType:
+		if len(fields) != 2 {
+			return errors.Errorf("invalid MX data %q", data)
+		}
+		priority, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil {
+			return errors.Wrap(err, "parsing MX priority")
+		}
+		r.Priority = uint16(priority)
+		r.Host = parseName(fields[1])
+	case SOAQueryType:
+		if len(fields) != 7 {
+			return errors.Errorf("invalid SOA data %q", data)
+		}
+		r.Host = parseName(fields[0])
+		r.MailHost = parseName(fields[1])
+
+		values := []*uint32{&r.Serial, &r.Refresh, &r.Retry, &r.Expire, &r.Minimum}
+		for i, v := range values {
+			n, err := strconv.ParseUint(fields[2+i], 10, 32)
+			if err != nil {
+				return errors.Wrap(err, "parsing SOA timers")
+			}
+			*v = uint32(n)
+		}
+	case SVCBQueryType, HTTPSQueryType:
+		return r.setSVCBRData(fields)
+	default:
+		rdata, err := parseGenericRData(fields)
+		if err != nil {
+			return errors.Wrapf(err, "parsing %s data", r.QType)
+		}
+		r.Data = rdata
+		r.DataLen = uint16(len(rdata))
+	}
+
+	return nil
+}
+
+// genericRData renders data in the generic syntax of RFC3597, e.g.
+// "\# 4 0a000001".
+func genericRData(data []byte) string {
+	if len(data) == 0 {
+		return `\# 0`
+	}
+
+	return fmt.Sprintf(`\# %d %s`, len(data), hex.EncodeToString(data))
+}
+
+// parseGenericRData is the inverse of genericRData, the hex digits may be
+// split into several fields.
+func parseGenericRData(fields []string) ([]byte, error) {
+	if len(fields) < 2 || fields[0] != `\#` {
+		return nil, errors.New("data is not in the generic \\# syntax")
+	}
+
+	length, err := strconv.ParseUint(fields[1], 10, 16)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing data length")
+	}
+
+	data, err := hex.DecodeString(strings.Join(fields[2:], ""))
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing data")
+	}
+
+	if len(data) != int(length) {
+		return nil, errors.Errorf("data of %d bytes doesn't match length %d", len(data), length)
+	}
+
+	return data, nil
+}
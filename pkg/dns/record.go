@@ -1,7 +1,6 @@
 package dns
 
 import (
-	"fmt"
 	"net"
 
 	bufHandler "github.com/msarvar/godns/pkg/buffer"
@@ -23,16 +22,18 @@ type DNSRecord struct {
 	Addr     net.IP
 	TTL      uint32
 	DataLen  uint16
-}
-
-func (r *DNSRecord) String() string {
-	return fmt.Sprintf(`
-%s: {
-	Addr: %s,
-	Host: %s,
-	Domain: %s,
-	MailHost: %s,
-}`, r.QType, r.Addr, r.Host, r.Domain, r.MailHost)
+	// Data is the raw RDATA of types godns doesn't understand (RFC3597)
+	Data    []byte
+	Options []*EDNSOption
+	// Params of SVCB and HTTPS records, their SvcPriority and TargetName are
+	// kept in Priority and Host
+	Params []*SvcParam
+
+	// Offset is the position of the record's owner name inside the packet it
+	// was read from and WireLength is the number of bytes the record occupies,
+	// RDATA included. Both are zero for records built in code.
+	Offset     int
+	WireLength int
 }
 
 func (r *DNSRecord) convertTo32to8(value uint32) []byte {
@@ -45,6 +46,8 @@ func (r *DNSRecord) convertTo32to8(value uint32) []byte {
 }
 
 func (r *DNSRecord) Read(buffer *bufHandler.BytePacketBuffer) error {
+	r.Offset = buffer.Pos()
+
 	var domain bufHandler.DomainName
 	err := buffer.ReadQname(&domain)
 	if err != nil {
@@ -75,6 +78,13 @@ func (r *DNSRecord) Read(buffer *bufHandler.BytePacketBuffer) error {
 		return errors.Wrap(err, "reading dns record data_len")
 	}
 
+	// Record data must fit in the packet, anything else is a truncated or
+	// forged packet.
+	dataStart := buffer.Pos()
+	if _, err := buffer.GetRange(dataStart, int(dataLen)); err != nil {
+		return errors.Wrapf(err, "dns record data of %d bytes exceeds packet", dataLen)
+	}
+
 	switch r.QType {
 	case AQueryType:
 		rawIpv4Addr, err := buffer.Read32()
@@ -96,7 +106,7 @@ func (r *DNSRecord) Read(buffer *bufHandler.BytePacketBuffer) error {
 		}
 
 		r.Host = ns
-	case CNAMEQueryType:
+	case CNAMEQueryType, PTRQueryType:
 		cname := bufHandler.NewDomainName("")
 		err := buffer.ReadQname(cname)
 		if err != nil {
@@ -199,12 +209,48 @@ func (r *DNSRecord) Read(buffer *bufHandler.BytePacketBuffer) error {
 
 		r.Host = mx
 		r.Priority = priority
+	case SVCBQueryType, HTTPSQueryType:
+		priority, err := buffer.Read16()
+		if err != nil {
+			return errors.Wrap(err, "reading svc priority")
+		}
+		r.Priority = priority
+
+		target := bufHandler.NewDomainName("")
+		err = buffer.ReadQname(target)
+		if err != nil {
+			return errors.Wrap(err, "reading svc target name")
+		}
+		r.Host = target
+
+		params, err := readSvcParams(buffer, dataStart+int(dataLen))
+		if err != nil {
+			return errors.Wrap(err, "reading svc params")
+		}
+		r.Params = params
+	case OPTQueryType:
+		options, err := readEDNSOptions(buffer, dataLen)
+		if err != nil {
+			return errors.Wrap(err, "reading dns record edns options")
+		}
+
+		r.Options = options
 	default:
+		// Record data was checked to fit in the packet above
+		data, _ := buffer.GetRange(dataStart, int(dataLen))
+		r.Data = append([]byte(nil), data...)
+		r.DataLen = dataLen
+
 		// Ensure position is set to after the datalen
 		buffer.Steps(int(dataLen))
-		r.DataLen = dataLen
 	}
 
+	if buffer.Pos() != dataStart+int(dataLen) {
+		return errors.Wrapf(ErrMalformedRecord, "dns record %s data length %d doesn't match its content of %d bytes",
+			r.QType, dataLen, buffer.Pos()-dataStart)
+	}
+	r.WireLength = buffer.Pos() - r.Offset
+
 	return nil
 }
 
@@ -221,7 +267,6 @@ func (r *DNSRecord) Write(buffer *bufHandler.BytePacketBuffer) (int, error) {
 		return 0, errors.Wrap(err, "writing dns record query type")
 	}
 
-	// DNS Record Class which always 1
 	err = buffer.Write16(r.Class)
 	if err != nil {
 		return 0, errors.Wrap(err, "writing dns record class")
@@ -262,18 +307,18 @@ func (r *DNSRecord) Write(buffer *bufHandler.BytePacketBuffer) (int, error) {
 
 		sizeu16 := uint16(buffer.Pos() - (pos + 2))
 		buffer.Set16(pos, sizeu16)
-	case CNAMEQueryType:
+	case CNAMEQueryType, PTRQueryType:
 		pos := buffer.Pos()
 
 		// Setting mock to data len to make sure it bytes are in right order
 		err = buffer.Write16(0)
 		if err != nil {
-			return 0, errors.Wrap(err, "setting datalen CNAME type")
+			return 0, errors.Wrapf(err, "setting datalen %s type", r.QType)
 		}
 
 		err = buffer.WriteQname(r.Host)
 		if err != nil {
-			return 0, errors.Wrap(err, "setting CNAME host")
+			return 0, errors.Wrapf(err, "setting %s host", r.QType)
 		}
 
 		// Update data len to actual value
@@ -359,9 +404,76 @@ func (r *DNSRecord) Write(buffer *bufHandler.BytePacketBuffer) (int, error) {
 				return 0, errors.Wrap(err, "setting ipv6 value")
 			}
 		}
+	case SVCBQueryType, HTTPSQueryType:
+		pos := buffer.Pos()
+
+		// Setting mock to data len to make sure it bytes are in right order
+		err = buffer.Write16(0)
+		if err != nil {
+			return 0, errors.Wrapf(err, "setting datalen %s type", r.QType)
+		}
+
+		err = buffer.Write16(r.Priority)
+		if err != nil {
+			return 0, errors.Wrap(err, "setting svc priority")
+		}
+
+		// The target name must not be compressed (RFC9460 2.2)
+		err = buffer.WriteQnameUncompressed(r.Host)
+		if err != nil {
+			return 0, errors.Wrap(err, "setting svc target name")
+		}
+
+		err = writeSvcParams(buffer, r.Params)
+		if err != nil {
+			return 0, errors.Wrap(err, "setting svc params")
+		}
+
+		sizeu16 := uint16(buffer.Pos() - (pos + 2))
+		buffer.Set16(pos, sizeu16)
+	case OPTQueryType:
+		pos := buffer.Pos()
+
+		// Setting mock to data len to make sure it bytes are in right order
+		err = buffer.Write16(0)
+		if err != nil {
+			return 0, errors.Wrap(err, "setting datalen OPT type")
+		}
+
+		err = writeEDNSOptions(buffer, r.Options)
+		if err != nil {
+			return 0, errors.Wrap(err, "setting edns options")
+		}
+
+		sizeu16 := uint16(buffer.Pos() - (pos + 2))
+		buffer.Set16(pos, sizeu16)
 	default:
-		fmt.Printf("Skipping record: %+v\n", r)
+		// Unknown types are written back verbatim
+		err = buffer.Write16(uint16(len(r.Data)))
+		if err != nil {
+			return 0, errors.Wrapf(err, "setting datalen %s type", r.QType)
+		}
+
+		_, err = buffer.Write(r.Data)
+		if err != nil {
+			return 0, errors.Wrap(err, "setting record data")
+		}
 	}
 
 	return buffer.Pos() - startPos, nil
 }
+
+// CompareSerials compares SOA serials in serial number arithmetic (RFC1982),
+// serials wrap around so that 1 follows 4294967295. It returns -1, 0 or 1
+// like strings.Compare, serials exactly half the space apart are undefined
+// and compare as equal so that neither replaces the other.
+func CompareSerials(a uint32, b uint32) int {
+	switch d := int32(a - b); {
+	case d == 0 || a-b == 1<<31:
+		return 0
+	case d > 0:
+		return 1
+	default:
+		return -1
+	}
+}
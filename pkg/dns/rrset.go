@@ -0,0 +1,253 @@
+package dns
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/pkg/errors"
+)
+
+// RRset is the set of records sharing owner name, type and class, the unit
+// DNS caches, signs and answers with (RFC2181 5).
+type RRset struct {
+	Name    *buffer.DomainName
+	Type    QueryType
+	Class   uint16
+	Records []*DNSRecord
+}
+
+// RRsetKey identifies an RRset, the name is normalized.
+type RRsetKey struct {
+	Name  string
+	Type  QueryType
+	Class uint16
+}
+
+// Key returns the key of the RRset the record belongs to.
+func (r *DNSRecord) Key() RRsetKey {
+	return RRsetKey{Name: r.Domain.Normalized(), Type: r.QType, Class: r.Class}
+}
+
+// NewRRset creates an RRset holding r.
+func NewRRset(r *DNSRecord) *RRset {
+	return &RRset{Name: r.Domain, Type: r.QType, Class: r.Class, Records: []*DNSRecord{r}}
+}
+
+// GroupRRsets splits records into RRsets in the order their first record
+// appears. OPT pseudo records aren't part of any RRset and are skipped.
+func GroupRRsets(records []*DNSRecord) []*RRset {
+	sets := make([]*RRset, 0)
+	index := make(map[RRsetKey]*RRset)
+	for _, r := range records {
+		if r.QType == OPTQueryType {
+			continue
+		}
+
+		if set, ok := index[r.Key()]; ok {
+			// The keys match, Add can't fail
+			_ = set.Add(r)
+			continue
+		}
+
+		set := NewRRset(r)
+		index[r.Key()] = set
+		sets = append(sets, set)
+	}
+
+	return sets
+}
+
+// Key returns the key shared by the records of the RRset.
+func (s *RRset) Key() RRsetKey {
+	return RRsetKey{Name: s.Name.Normalized(), Type: s.Type, Class: s.Class}
+}
+
+// Add adds r to the RRset, records with the data of one already in the set
+// are dropped as RRsets hold no duplicates.
+func (s *RRset) Add(r *DNSRecord) error {
+	if r.Key() != s.Key() {
+		return errors.Errorf("%s record of %s doesn't belong to the %s RRset of %s", r.QType, r.Domain, s.Type, s.Name)
+	}
+
+	for _, existing := range s.Records {
+		if existing.RData() == r.RData() {
+			return nil
+		}
+	}
+
+	s.Records = append(s.Records, r)
+	return nil
+}
+
+// TTL returns the TTL of the RRset, the lowest TTL of its records.
+func (s *RRset) TTL() uint32 {
+	if len(s.Records) == 0 {
+		return 0
+	}
+
+	ttl := s.Records[0].TTL
+	for _, r := range s.Records[1:] {
+		if r.TTL < ttl {
+			ttl = r.TTL
+		}
+	}
+
+	return ttl
+}
+
+// HarmonizeTTL sets every record to the TTL of the RRset. Records of an
+// RRset with differing TTLs are deprecated and treated as having the lowest
+// of them (RFC2181 5.2). The records are modified in place.
+func (s *RRset) HarmonizeTTL() {
+	ttl := s.TTL()
+	for _, r := range s.Records {
+		r.TTL = ttl
+	}
+}
+
+// HarmonizeTTLs sets the records of every RRset in records to the TTL of
+// their RRset, in place.
+func HarmonizeTTLs(records []*DNSRecord) {
+	for _, set := range GroupRRsets(records) {
+		set.HarmonizeTTL()
+	}
+}
+
+// Sort orders the records canonically by their data (RFC4034 6.3).
+func (s *RRset) Sort() error {
+	rdatas := make(map[*DNSRecord][]byte, len(s.Records))
+	for _, r := range s.Records {
+		_, rdata, err := canonicalRecord(r, r.TTL)
+		if err != nil {
+			return err
+		}
+		rdatas[r] = rdata
+	}
+
+	sort.SliceStable(s.Records, func(i, j int) bool {
+		return bytes.Compare(rdatas[s.Records[i]], rdatas[s.Records[j]]) < 0
+	})
+
+	return nil
+}
+
+// Canonical returns the records in canonical form and order (RFC4034 6.2 and
+// 6.3) with the given TTL, the input of DNSSEC signatures.
+func (s *RRset) Canonical(ttl uint32) ([]byte, error) {
+	type encoded struct {
+		wire  []byte
+		rdata []byte
+	}
+
+	records := make([]encoded, 0, len(s.Records))
+	for _, r := range s.Records {
+		wire, rdata, err := canonicalRecord(r, ttl)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, encoded{wire: wire, rdata: rdata})
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return bytes.Compare(records[i].rdata, records[j].rdata) < 0
+	})
+
+	var out bytes.Buffer
+	for i, r := range records {
+		if i > 0 && bytes.Equal(r.rdata, records[i-1].rdata) {
+			continue
+		}
+		out.Write(r.wire)
+	}
+
+	return out.Bytes(), nil
+}
+
+// canonicalRecord encodes r with lowercase, uncompressed names and returns
+// the whole record and its data.
+func canonicalRecord(r *DNSRecord, ttl uint32) (wire []byte, rdata []byte, err error) {
+	copied := lowercased(r)
+	copied.TTL = ttl
+
+	for _, size := range []int{512, MaxMessageSize} {
+		buf := buffer.NewBytePacketBuffer()
+		buf.Buf = make([]byte, size)
+		buf.DisableCompression()
+
+		_, err = copied.Write(buf)
+		if errors.Is(err, buffer.ErrBufferOverflow) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "encoding canonical record")
+		}
+
+		wire = buf.Buf[:buf.Pos()]
+		// The data follows the owner name and the type, class, TTL and data
+		// length fields
+		ownerLength := 1
+		for _, label := range copied.Domain.SplitLabels() {
+			ownerLength += 1 + len(label)
+		}
+
+		return wire, wire[ownerLength+10:], nil
+	}
+
+	return nil, nil, errors.Wrap(err, "encoding canonical record")
+}
+
+// lowercased returns a copy of r with its owner and the names in its data
+// lowercased. Only the names in the data of the types RFC4034 6.2 lists are
+// lowercased, SVCB targets keep their case.
+func lowercased(r *DNSRecord) *DNSRecord {
+	copied := *r
+	copied.Domain = lowerName(r.Domain)
+	switch r.QType {
+	case NSQueryType, CNAMEQueryType, PTRQueryType, MXQueryType, SOAQueryType:
+		copied.Host = lowerName(r.Host)
+		copied.MailHost = lowerName(r.MailHost)
+	}
+
+	return &copied
+}
+
+func lowerName(name *buffer.DomainName) *buffer.DomainName {
+	if name == nil {
+		return nil
+	}
+
+	return buffer.NewDomainName(strings.ToLower(name.String()))
+}
+
+// CompareNames orders names canonically (RFC4034 6.1), label by label from
+// the root with uppercase letters sorting as lowercase ones. It returns -1, 0
+// or 1 like strings.Compare.
+func CompareNames(a string, b string) int {
+	la := nameLabels(a)
+	lb := nameLabels(b)
+	for i := 1; i <= len(la) && i <= len(lb); i++ {
+		if c := strings.Compare(la[len(la)-i], lb[len(lb)-i]); c != 0 {
+			return c
+		}
+	}
+
+	switch {
+	case len(la) < len(lb):
+		return -1
+	case len(la) > len(lb):
+		return 1
+	default:
+		return 0
+	}
+}
+
+func nameLabels(name string) []string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name == "" {
+		return nil
+	}
+
+	return strings.Split(name, ".")
+}
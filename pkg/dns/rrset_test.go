@@ -0,0 +1,109 @@
+package dns_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+func TestRRset(t *testing.T) {
+	parse := func(lines ...string) []*dns.DNSRecord {
+		records := make([]*dns.DNSRecord, 0, len(lines))
+		for _, line := range lines {
+			r, err := dns.ParseRecord(line, 0)
+			NoError(t, err)
+			records = append(records, r)
+		}
+		return records
+	}
+
+	t.Run("groups_by_name_type_and_class", func(t *testing.T) {
+		records := parse(
+			"www.example.com. 300 IN A 192.0.2.1",
+			"www.example.com. 300 IN AAAA 2001:db8::1",
+			"WWW.example.com. 60 IN A 192.0.2.2",
+			"www.example.com. 300 IN A 192.0.2.1",
+			"www.example.com. 300 CH A 192.0.2.1",
+		)
+		records = append(records, dns.NewOPTRecord(1232))
+
+		sets := dns.GroupRRsets(records)
+		if !Len(t, sets, 3) {
+			return
+		}
+		Equal(t, dns.AQueryType, sets[0].Type)
+		Len(t, sets[0].Records, 2, "duplicates are dropped")
+		Equal(t, uint32(60), sets[0].TTL())
+		Equal(t, dns.AAAAQueryType, sets[1].Type)
+		Equal(t, uint16(3), sets[2].Class)
+
+		Error(t, sets[0].Add(records[1]))
+	})
+
+	t.Run("harmonize_ttls", func(t *testing.T) {
+		records := parse(
+			"www.example.com. 300 IN A 192.0.2.1",
+			"www.example.com. 60 IN A 192.0.2.2",
+			"www.example.com. 300 IN AAAA 2001:db8::1",
+		)
+		dns.HarmonizeTTLs(records)
+
+		Equal(t, uint32(60), records[0].TTL)
+		Equal(t, uint32(60), records[1].TTL)
+		Equal(t, uint32(300), records[2].TTL)
+	})
+
+	t.Run("canonical_form", func(t *testing.T) {
+		set := dns.GroupRRsets(parse(
+			"Example.com. 300 IN MX 20 Mail2.Example.com.",
+			"Example.com. 60 IN MX 10 mail.example.com.",
+		))[0]
+
+		wire, err := set.Canonical(3600)
+		NoError(t, err)
+		Equal(t, ""+
+			// example.com. MX IN 3600, 10 mail.example.com. in full
+			"076578616d706c6503636f6d00"+"000f"+"0001"+"00000e10"+"0014"+"000a"+"046d61696c076578616d706c6503636f6d00"+
+			"076578616d706c6503636f6d00"+"000f"+"0001"+"00000e10"+"0015"+"0014"+"056d61696c32076578616d706c6503636f6d00",
+			hex.EncodeToString(wire))
+
+		NoError(t, set.Sort())
+		Equal(t, "mail.example.com", set.Records[0].Host.String())
+	})
+	t.Run("canonical_name_order", func(t *testing.T) {
+		// The example of RFC4034 6.1
+		names := []string{
+			"example", "a.example", "yljkjljk.a.example", "Z.a.example",
+			"zABC.a.EXAMPLE", "z.example", "*.z.example",
+		}
+		for i := 1; i < len(names); i++ {
+			Equal(t, -1, dns.CompareNames(names[i-1], names[i]), names[i])
+			Equal(t, 1, dns.CompareNames(names[i], names[i-1]), names[i])
+		}
+		Equal(t, 0, dns.CompareNames("Example.", "example"))
+		Equal(t, -1, dns.CompareNames("", "example"))
+	})
+}
+
+func TestCompareSerials(t *testing.T) {
+	for _, c := range []struct {
+		a, b uint32
+		want int
+	}{
+		{1, 1, 0},
+		{2, 1, 1},
+		{1, 2, -1},
+		{0, 4294967295, 1},
+		{4294967295, 0, -1},
+		{5, 4294967290, 1},
+		{2147483647, 0, 1},
+		{0, 2147483648, 0},
+		{2147483648, 0, 0},
+		{2021010101, 2020123199, 1},
+	} {
+		Equal(t, c.want, dns.CompareSerials(c.a, c.b), "%d %d", c.a, c.b)
+	}
+}
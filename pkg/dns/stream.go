@@ -0,0 +1,50 @@
+package dns
+
+import (
+	buf "github.com/msarvar/godns/pkg/buffer"
+	"github.com/pkg/errors"
+)
+
+// Messages splits the answers of the packet over as many messages of at most
+// size bytes as they need, the way zone transfers are streamed over TCP
+// (RFC5936 2.2). Every message has the header of the packet and its OPT
+// record, only the first one has the question. Authority and additional
+// records are left out, transfers have none.
+func (p *DNSPacket) Messages(size int) ([]*DNSPacket, error) {
+	opt := p.OPT()
+
+	messages := make([]*DNSPacket, 0, 1)
+	remaining := p.Answers
+	for len(messages) == 0 || len(remaining) > 0 {
+		header := *p.Header
+		message := &DNSPacket{Header: &header}
+		if len(messages) == 0 {
+			message.Questions = p.Questions
+		}
+		if opt != nil {
+			message.Resources = []*DNSRecord{opt}
+		}
+
+		// The records are written after the OPT record but compress the same
+		// as before it, the root name never points anywhere
+		packetBuffer := buf.NewBytePacketBuffer()
+		packetBuffer.Buf = make([]byte, size)
+		if err := message.Write(packetBuffer); err != nil {
+			return nil, errors.Wrap(err, "writing message header")
+		}
+
+		n, err := writeRecords(packetBuffer, remaining)
+		if err != nil {
+			return nil, errors.Wrap(err, "writing message answers")
+		}
+		if n == 0 && len(remaining) > 0 {
+			return nil, errors.Errorf("%s record of %s doesn't fit in a message of %d bytes", remaining[0].QType, remaining[0].Domain, size)
+		}
+
+		message.Answers = remaining[:n]
+		remaining = remaining[n:]
+		messages = append(messages, message)
+	}
+
+	return messages, nil
+}
@@ -0,0 +1,84 @@
+package dns_test
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+func TestMessages(t *testing.T) {
+	build := func(answers int) *dns.DNSPacket {
+		packet := dns.NewDNSPacket()
+		packet.Header.ID = 4660
+		packet.Header.Response = true
+		packet.Header.AuthoritativeAnswer = true
+		packet.Questions = append(packet.Questions, dns.NewDNSQuestion("example.com", dns.AXFRQueryType))
+		for i := 0; i < answers; i++ {
+			r, err := dns.NewARecord(fmt.Sprintf("host%d.example.com", i), net.IPv4(192, 0, 2, byte(i)), 300)
+			NoError(t, err)
+			packet.Answers = append(packet.Answers, r)
+		}
+		return packet
+	}
+
+	t.Run("small_packet_is_one_message", func(t *testing.T) {
+		packet := build(3)
+
+		messages, err := packet.Messages(dns.MaxMessageSize)
+		NoError(t, err)
+		if Len(t, messages, 1) {
+			Equal(t, packet.Questions, messages[0].Questions)
+			Equal(t, packet.Answers, messages[0].Answers)
+		}
+	})
+
+	t.Run("answers_are_split_in_order", func(t *testing.T) {
+		packet := build(500)
+		packet.Resources = append(packet.Resources, dns.NewOPTRecord(1232))
+
+		messages, err := packet.Messages(512)
+		NoError(t, err)
+		Greater(t, len(messages), 1)
+
+		var answers []*dns.DNSRecord
+		for i, message := range messages {
+			Equal(t, uint16(4660), message.Header.ID)
+			True(t, message.Header.AuthoritativeAnswer)
+			if i == 0 {
+				Len(t, message.Questions, 1)
+			} else {
+				Empty(t, message.Questions)
+			}
+			NotNil(t, message.OPT())
+
+			packetBuffer := buffer.NewBytePacketBuffer()
+			packetBuffer.Buf = make([]byte, dns.MaxMessageSize)
+			NoError(t, message.Write(packetBuffer))
+			LessOrEqual(t, packetBuffer.Pos(), 512)
+			False(t, message.Header.TruncatedMessage)
+
+			packetBuffer.Seek(0)
+			parsed, err := dns.DNSPacketFromBuffer(packetBuffer)
+			NoError(t, err)
+			Len(t, parsed.Answers, len(message.Answers))
+			answers = append(answers, message.Answers...)
+		}
+		Equal(t, packet.Answers, answers)
+	})
+
+	t.Run("record_larger_than_a_message", func(t *testing.T) {
+		packet := build(0)
+		r, err := dns.NewTXTRecord("example.com", 300, strings.Repeat("x", 255), strings.Repeat("y", 255))
+		NoError(t, err)
+		packet.Answers = append(packet.Answers, r)
+
+		_, err = packet.Messages(512)
+		Error(t, err)
+	})
+}
@@ -0,0 +1,425 @@
+package dns
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/pkg/errors"
+)
+
+// SvcParamKey identifies a parameter of SVCB and HTTPS records (RFC9460).
+type SvcParamKey uint16
+
+const (
+	SvcParamMandatory     SvcParamKey = 0
+	SvcParamALPN          SvcParamKey = 1
+	SvcParamNoDefaultALPN SvcParamKey = 2
+	SvcParamPort          SvcParamKey = 3
+	SvcParamIPv4Hint      SvcParamKey = 4
+	SvcParamECH           SvcParamKey = 5
+	SvcParamIPv6Hint      SvcParamKey = 6
+	// SvcParamDoHPath is the URI template of a DNS over HTTPS resolver
+	// (RFC9461)
+	SvcParamDoHPath SvcParamKey = 7
+)
+
+var svcParamKeyNames = map[SvcParamKey]string{
+	SvcParamMandatory:     "mandatory",
+	SvcParamALPN:          "alpn",
+	SvcParamNoDefaultALPN: "no-default-alpn",
+	SvcParamPort:          "port",
+	SvcParamIPv4Hint:      "ipv4hint",
+	SvcParamECH:           "ech",
+	SvcParamIPv6Hint:      "ipv6hint",
+	SvcParamDoHPath:       "dohpath",
+}
+
+func (k SvcParamKey) String() string {
+	if name, ok := svcParamKeyNames[k]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("key%d", uint16(k))
+}
+
+func parseSvcParamKey(s string) (SvcParamKey, error) {
+	for k, name := range svcParamKeyNames {
+		if name == s {
+			return k, nil
+		}
+	}
+
+	if strings.HasPrefix(s, "key") {
+		n, err := strconv.ParseUint(s[3:], 10, 16)
+		if err == nil {
+			return SvcParamKey(n), nil
+		}
+	}
+
+	return 0, errors.Errorf("unknown svc param key %q", s)
+}
+
+// SvcParam is a single key and its wire format value.
+type SvcParam struct {
+	Key   SvcParamKey
+	Value []byte
+}
+
+// validate checks the value length of the parameters with a fixed layout.
+func (p *SvcParam) validate() error {
+	n := len(p.Value)
+
+	var valid bool
+	switch p.Key {
+	case SvcParamMandatory:
+		valid = n > 0 && n%2 == 0
+	case SvcParamALPN:
+		valid = n > 0 && splitALPN(p.Value) != nil
+	case SvcParamNoDefaultALPN:
+		valid = n == 0
+	case SvcParamPort:
+		valid = n == 2
+	case SvcParamIPv4Hint:
+		valid = n > 0 && n%net.IPv4len == 0
+	case SvcParamIPv6Hint:
+		valid = n > 0 && n%net.IPv6len == 0
+	default:
+		valid = true
+	}
+
+	if !valid {
+		return errors.Wrapf(ErrMalformedRecord, "invalid %s value of %d bytes", p.Key, n)
+	}
+
+	return nil
+}
+
+// splitALPN decodes the list of length prefixed protocol ids, nil means the
+// value is malformed.
+func splitALPN(value []byte) []string {
+	ids := make([]string, 0)
+	for len(value) > 0 {
+		n := int(value[0])
+		if n == 0 || n+1 > len(value) {
+			return nil
+		}
+		ids = append(ids, string(value[1:n+1]))
+		value = value[n+1:]
+	}
+
+	return ids
+}
+
+// SvcParam returns the parameter with the given key or nil.
+func (r *DNSRecord) SvcParam(key SvcParamKey) *SvcParam {
+	for _, p := range r.Params {
+		if p.Key == key {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// ALPN returns the protocol ids of the alpn parameter.
+func (r *DNSRecord) ALPN() []string {
+	if p := r.SvcParam(SvcParamALPN); p != nil {
+		return splitALPN(p.Value)
+	}
+
+	return nil
+}
+
+// Port returns the port parameter, ok is false when it is absent.
+func (r *DNSRecord) Port() (port uint16, ok bool) {
+	if p := r.SvcParam(SvcParamPort); p != nil && len(p.Value) == 2 {
+		return binary.BigEndian.Uint16(p.Value), true
+	}
+
+	return 0, false
+}
+
+// IPv4Hint returns the addresses of the ipv4hint parameter.
+func (r *DNSRecord) IPv4Hint() []net.IP {
+	return r.addrHint(SvcParamIPv4Hint, net.IPv4len)
+}
+
+// IPv6Hint returns the addresses of the ipv6hint parameter.
+func (r *DNSRecord) IPv6Hint() []net.IP {
+	return r.addrHint(SvcParamIPv6Hint, net.IPv6len)
+}
+
+func (r *DNSRecord) addrHint(key SvcParamKey, size int) []net.IP {
+	p := r.SvcParam(key)
+	if p == nil {
+		return nil
+	}
+
+	addrs := make([]net.IP, 0, len(p.Value)/size)
+	for i := 0; i+size <= len(p.Value); i += size {
+		addrs = append(addrs, net.IP(append([]byte(nil), p.Value[i:i+size]...)))
+	}
+
+	return addrs
+}
+
+// ECH returns the ECHConfigList of the ech parameter.
+func (r *DNSRecord) ECH() []byte {
+	if p := r.SvcParam(SvcParamECH); p != nil {
+		return p.Value
+	}
+
+	return nil
+}
+
+func readSvcParams(buffer *buffer.BytePacketBuffer, end int) ([]*SvcParam, error) {
+	params := make([]*SvcParam, 0)
+
+	for buffer.Pos() < end {
+		key, err := buffer.Read16()
+		if err != nil {
+			return nil, errors.Wrap(err, "reading svc param key")
+		}
+
+		// Keys are sorted and appear at most once
+		if len(params) > 0 && SvcParamKey(key) <= params[len(params)-1].Key {
+			return nil, errors.Wrapf(ErrMalformedRecord, "svc param %s out of order", SvcParamKey(key))
+		}
+
+		length, err := buffer.Read16()
+		if err != nil {
+			return nil, errors.Wrap(err, "reading svc param length")
+		}
+
+		if buffer.Pos()+int(length) > end {
+			return nil, errors.Wrap(ErrMalformedRecord, "svc param exceeds record data length")
+		}
+
+		value, err := buffer.GetRange(buffer.Pos(), int(length))
+		if err != nil {
+			return nil, errors.Wrap(err, "reading svc param value")
+		}
+		buffer.Steps(int(length))
+
+		p := &SvcParam{Key: SvcParamKey(key), Value: append([]byte(nil), value...)}
+		if err := p.validate(); err != nil {
+			return nil, err
+		}
+		params = append(params, p)
+	}
+
+	return params, nil
+}
+
+func writeSvcParams(buffer *buffer.BytePacketBuffer, params []*SvcParam) error {
+	for _, p := range params {
+		err := buffer.Write16(uint16(p.Key))
+		if err != nil {
+			return errors.Wrap(err, "writing svc param key")
+		}
+
+		err = buffer.Write16(uint16(len(p.Value)))
+		if err != nil {
+			return errors.Wrap(err, "writing svc param length")
+		}
+
+		_, err = buffer.Write(p.Value)
+		if err != nil {
+			return errors.Wrap(err, "writing svc param value")
+		}
+	}
+
+	return nil
+}
+
+// DoHPath returns the URI template of the dohpath parameter, empty when it
+// is absent.
+func (r *DNSRecord) DoHPath() string {
+	if p := r.SvcParam(SvcParamDoHPath); p != nil {
+		return string(p.Value)
+	}
+
+	return ""
+}
+
+// svcbRData renders the record data like "1 . alpn=h2,h3 port=443".
+func (r *DNSRecord) svcbRData() string {
+	fields := []string{strconv.Itoa(int(r.Priority)), fqdn(r.Host)}
+
+	for _, p := range r.Params {
+		var value string
+		switch p.Key {
+		case SvcParamMandatory:
+			keys := make([]string, 0, len(p.Value)/2)
+			for i := 0; i+1 < len(p.Value); i += 2 {
+				keys = append(keys, SvcParamKey(binary.BigEndian.Uint16(p.Value[i:])).String())
+			}
+			value = strings.Join(keys, ",")
+		case SvcParamALPN:
+			value = strings.Join(splitALPN(p.Value), ",")
+		case SvcParamNoDefaultALPN:
+			fields = append(fields, p.Key.String())
+			continue
+		case SvcParamPort:
+			port, _ := r.Port()
+			value = strconv.Itoa(int(port))
+		case SvcParamIPv4Hint, SvcParamIPv6Hint:
+			size := net.IPv4len
+			if p.Key == SvcParamIPv6Hint {
+				size = net.IPv6len
+			}
+			addrs := make([]string, 0)
+			for _, addr := range r.addrHint(p.Key, size) {
+				addrs = append(addrs, addr.String())
+			}
+			value = strings.Join(addrs, ",")
+		case SvcParamECH:
+			value = base64.StdEncoding.EncodeToString(p.Value)
+		default:
+			value = escapeSvcValue(p.Value)
+		}
+
+		fields = append(fields, p.Key.String()+"="+value)
+	}
+
+	return strings.Join(fields, " ")
+}
+
+// setSVCBRData parses the presentation format produced by svcbRData.
+func (r *DNSRecord) setSVCBRData(fields []string) error {
+	if len(fields) < 2 {
+		return errors.Errorf("invalid %s data %q", r.QType, strings.Join(fields, " "))
+	}
+
+	priority, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil {
+		return errors.Wrapf(err, "parsing %s priority", r.QType)
+	}
+	r.Priority = uint16(priority)
+	r.Host = parseName(fields[1])
+	r.Params = nil
+
+	for _, field := range fields[2:] {
+		kv := strings.SplitN(field, "=", 2)
+		key, err := parseSvcParamKey(kv[0])
+		if err != nil {
+			return err
+		}
+
+		var value string
+		if len(kv) == 2 {
+			value = kv[1]
+		}
+
+		p := &SvcParam{Key: key}
+		switch key {
+		case SvcParamMandatory:
+			for _, k := range strings.Split(value, ",") {
+				mk, err := parseSvcParamKey(k)
+				if err != nil {
+					return err
+				}
+				p.Value = append(p.Value, byte(mk>>8), byte(mk))
+			}
+		case SvcParamALPN:
+			for _, id := range strings.Split(value, ",") {
+				if len(id) == 0 || len(id) > 255 {
+					return errors.Errorf("invalid alpn id %q", id)
+				}
+				p.Value = append(append(p.Value, byte(len(id))), id...)
+			}
+		case SvcParamNoDefaultALPN:
+		case SvcParamPort:
+			port, err := strconv.ParseUint(value, 10, 16)
+			if err != nil {
+				return errors.Wrap(err, "parsing svc port")
+			}
+			p.Value = []byte{byte(port >> 8), byte(port)}
+		case SvcParamIPv4Hint, SvcParamIPv6Hint:
+			for _, s := range strings.Split(value, ",") {
+				ip := net.ParseIP(s)
+				if ip == nil || (key == SvcParamIPv4Hint) != (ip.To4() != nil) {
+					return errors.Errorf("invalid %s address %q", key, s)
+				}
+				if key == SvcParamIPv4Hint {
+					ip = ip.To4()
+				}
+				p.Value = append(p.Value, ip...)
+			}
+		case SvcParamECH:
+			p.Value, err = base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return errors.Wrap(err, "parsing ech config")
+			}
+		default:
+			p.Value, err = unescapeSvcValue(value)
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := p.validate(); err != nil {
+			return err
+		}
+		r.Params = append(r.Params, p)
+	}
+
+	// Presentation format allows any order but the wire format is sorted
+	sort.Slice(r.Params, func(i, j int) bool {
+		return r.Params[i].Key < r.Params[j].Key
+	})
+	for i := 1; i < len(r.Params); i++ {
+		if r.Params[i].Key == r.Params[i-1].Key {
+			return errors.Errorf("duplicate svc param %s", r.Params[i].Key)
+		}
+	}
+
+	return nil
+}
+
+// escapeSvcValue renders opaque values as a quoted string with non printable
+// bytes in the \DDD form.
+func escapeSvcValue(value []byte) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, c := range value {
+		if c < 0x21 || c > 0x7E || c == '"' || c == '\\' {
+			fmt.Fprintf(&sb, "\\%03d", c)
+			continue
+		}
+		sb.WriteByte(c)
+	}
+	sb.WriteByte('"')
+
+	return sb.String()
+}
+
+func unescapeSvcValue(s string) ([]byte, error) {
+	s = strings.TrimSuffix(strings.TrimPrefix(s, `"`), `"`)
+
+	value := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			value = append(value, s[i])
+			continue
+		}
+
+		if i+4 > len(s) {
+			return nil, errors.Errorf("invalid escape in %q", s)
+		}
+		n, err := strconv.ParseUint(s[i+1:i+4], 10, 8)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid escape in %q", s)
+		}
+		value = append(value, byte(n))
+		i += 3
+	}
+
+	return value, nil
+}
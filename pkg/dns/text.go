@@ -0,0 +1,114 @@
+package dns
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ParseQueryType parses a record type mnemonic like "AAAA" or the generic
+// "TYPE28" form.
+func ParseQueryType(s string) (QueryType, error) {
+	s = strings.ToUpper(s)
+	for _, t := range []QueryType{
+		AQueryType, NSQueryType, CNAMEQueryType, SOAQueryType, PTRQueryType,
+		MXQueryType, TXTQueryType, AAAAQueryType, SRVQueryType, OPTQueryType,
+		SVCBQueryType, HTTPSQueryType, AXFRQueryType, ANYQueryType, DSQueryType,
+		RRSIGQueryType, NSECQueryType, DNSKEYQueryType,
+	} {
+		if t.String() == s {
+			return t, nil
+		}
+	}
+
+	if strings.HasPrefix(s, "TYPE") {
+		n, err := strconv.ParseUint(s[4:], 10, 16)
+		if err == nil {
+			return QueryType(n), nil
+		}
+	}
+
+	return UnknownQueryType, errors.Errorf("unknown record type %q", s)
+}
+
+// ParseClass parses a class mnemonic like "IN" or the generic "CLASS1" form.
+func ParseClass(s string) (uint16, error) {
+	s = strings.ToUpper(s)
+	for _, c := range []uint16{ClassIN, ClassCH, ClassHS, ClassANY} {
+		if classString(c) == s {
+			return c, nil
+		}
+	}
+
+	if strings.HasPrefix(s, "CLASS") {
+		n, err := strconv.ParseUint(s[5:], 10, 16)
+		if err == nil {
+			return uint16(n), nil
+		}
+	}
+
+	return 0, errors.Errorf("unknown class %q", s)
+}
+
+// Text renders the record in master file syntax:
+//
+//	www.example.com. 300 IN A 1.2.3.4
+func (r *DNSRecord) Text() string {
+	return fmt.Sprintf("%s %d %s %s %s", fqdn(r.Domain), r.TTL, classString(r.Class), r.QType, r.RData())
+}
+
+// ParseRecord parses a single master file line as written by Text. The TTL
+// and class are optional and may come in either order, missing values default
+// to defaultTTL and IN. Names are always treated as absolute.
+func ParseRecord(line string, defaultTTL uint32) (*DNSRecord, error) {
+	if i := strings.Index(line, ";"); i >= 0 {
+		line = line[:i]
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return nil, errors.Errorf("record %q has too few fields", line)
+	}
+
+	r := &DNSRecord{
+		Domain: parseName(fields[0]),
+		Class:  ClassIN,
+		TTL:    defaultTTL,
+	}
+
+	rest := fields[1:]
+	for len(rest) > 0 {
+		if ttl, err := strconv.ParseUint(rest[0], 10, 32); err == nil {
+			r.TTL = uint32(ttl)
+			rest = rest[1:]
+			continue
+		}
+
+		if class, err := ParseClass(rest[0]); err == nil {
+			r.Class = class
+			rest = rest[1:]
+			continue
+		}
+
+		break
+	}
+
+	if len(rest) == 0 {
+		return nil, errors.Errorf("record %q has no type", line)
+	}
+
+	qtype, err := ParseQueryType(rest[0])
+	if err != nil {
+		return nil, err
+	}
+	r.QType = qtype
+
+	err = r.SetRData(strings.Join(rest[1:], " "))
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing record %q", line)
+	}
+
+	return r, nil
+}
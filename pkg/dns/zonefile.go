@@ -0,0 +1,162 @@
+package dns
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ReadZone parses a master file (RFC1035 5). $ORIGIN and $TTL directives,
+// "@", relative owner names, blank owners repeating the previous one and
+// records spanning lines in parentheses are supported. Names inside record
+// data must be absolute.
+func ReadZone(r io.Reader, origin string, defaultTTL uint32) ([]*DNSRecord, error) {
+	origin = fqdn(parseName(origin))
+	ttl := defaultTTL
+	owner := ""
+
+	records := make([]*DNSRecord, 0)
+	lines := newZoneLines(r)
+	for {
+		line, lineNo, err := lines.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "$ORIGIN":
+			if len(fields) != 2 {
+				return nil, errors.Errorf("line %d: $ORIGIN needs a name", lineNo)
+			}
+			origin = absoluteName(fields[1], origin)
+			continue
+		case "$TTL":
+			if len(fields) != 2 {
+				return nil, errors.Errorf("line %d: $TTL needs a value", lineNo)
+			}
+			n, err := strconv.ParseUint(fields[1], 10, 32)
+			if err != nil {
+				return nil, errors.Wrapf(err, "line %d: parsing $TTL", lineNo)
+			}
+			ttl = uint32(n)
+			continue
+		}
+
+		if strings.HasPrefix(fields[0], "$") {
+			return nil, errors.Errorf("line %d: unsupported directive %s", lineNo, fields[0])
+		}
+
+		// Lines starting with blanks belong to the previous owner
+		if line[0] != ' ' && line[0] != '\t' {
+			owner = absoluteName(fields[0], origin)
+			fields = fields[1:]
+		}
+		if owner == "" {
+			return nil, errors.Errorf("line %d: record without owner", lineNo)
+		}
+
+		record, err := ParseRecord(owner+" "+strings.Join(fields, " "), ttl)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", lineNo)
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// absoluteName resolves "@" and names relative to origin.
+func absoluteName(name string, origin string) string {
+	switch {
+	case name == "@":
+		return origin
+	case strings.HasSuffix(name, "."):
+		return name
+	case origin == ".":
+		return name + "."
+	default:
+		return name + "." + origin
+	}
+}
+
+// zoneLines returns the logical lines of a master file, comments removed and
+// lines in parentheses joined.
+type zoneLines struct {
+	scanner *bufio.Scanner
+	lineNo  int
+}
+
+func newZoneLines(r io.Reader) *zoneLines {
+	return &zoneLines{scanner: bufio.NewScanner(r)}
+}
+
+func (z *zoneLines) next() (string, int, error) {
+	var joined strings.Builder
+	start := 0
+	depth := 0
+
+	for z.scanner.Scan() {
+		z.lineNo++
+		if start == 0 {
+			start = z.lineNo
+		}
+
+		text, parens := stripZoneLine(z.scanner.Text())
+		joined.WriteString(text)
+		depth += parens
+
+		if depth < 0 {
+			return "", start, errors.Errorf("line %d: unbalanced parentheses", z.lineNo)
+		}
+		if depth == 0 {
+			return joined.String(), start, nil
+		}
+		joined.WriteByte(' ')
+	}
+
+	if err := z.scanner.Err(); err != nil {
+		return "", z.lineNo, errors.Wrap(err, "reading zone")
+	}
+	if depth > 0 {
+		return "", start, errors.Errorf("line %d: unbalanced parentheses", start)
+	}
+
+	return "", z.lineNo, io.EOF
+}
+
+// stripZoneLine removes the comment and parentheses from a line, parens is
+// how many more were opened than closed.
+func stripZoneLine(line string) (text string, parens int) {
+	var sb strings.Builder
+	quoted := false
+
+	for _, c := range line {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == ';':
+			return sb.String(), parens
+		case c == '(':
+			parens++
+			c = ' '
+		case c == ')':
+			parens--
+			c = ' '
+		}
+		sb.WriteRune(c)
+	}
+
+	return sb.String(), parens
+}
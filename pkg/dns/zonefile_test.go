@@ -0,0 +1,53 @@
+package dns_test
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+func TestReadZone(t *testing.T) {
+	t.Run("directives_relative_names_and_parentheses", func(t *testing.T) {
+		zone := `$TTL 600
+@	IN SOA ns.example.com. admin.example.com. (
+		1     ; serial
+		7200 3600 1209600
+		300 )
+www	A 1.2.3.4
+	60 AAAA ::1 ; same owner
+$ORIGIN sub.example.com.
+mail	MX 10 mx.example.com.
+txt.example.org. TYPE99 \# 2 abcd
+`
+		records, err := dns.ReadZone(strings.NewReader(zone), "example.com", 3600)
+		NoError(t, err)
+
+		texts := make([]string, 0)
+		for _, r := range records {
+			texts = append(texts, r.Text())
+		}
+		Equal(t, []string{
+			"example.com. 600 IN SOA ns.example.com. admin.example.com. 1 7200 3600 1209600 300",
+			"www.example.com. 600 IN A 1.2.3.4",
+			"www.example.com. 60 IN AAAA ::1",
+			"mail.sub.example.com. 600 IN MX 10 mx.example.com.",
+			`txt.example.org. 600 IN TYPE99 \# 2 abcd`,
+		}, texts)
+	})
+
+	t.Run("invalid_zones", func(t *testing.T) {
+		for _, zone := range []string{
+			"\tA 1.2.3.4",
+			"$TTL soon",
+			"$INCLUDE other.zone",
+			"@ SOA ns.example.com. admin.example.com. ( 1 2 3 4 5",
+			"www A 1.2.3",
+		} {
+			_, err := dns.ReadZone(strings.NewReader(zone), "example.com", 3600)
+			Error(t, err, zone)
+		}
+	})
+}
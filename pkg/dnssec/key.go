@@ -0,0 +1,297 @@
+// Package dnssec signs the zones godns is authoritative for (RFC4033 to
+// RFC4035), keys are ECDSA P-256 with SHA-256 (RFC6605).
+package dnssec
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/pkg/errors"
+)
+
+// AlgorithmECDSAP256SHA256 is the only signing algorithm supported, small
+// signatures keep signed answers within UDP sizes.
+const AlgorithmECDSAP256SHA256 = 13
+
+// DigestSHA256 is the digest type of the DS records created.
+const DigestSHA256 = 2
+
+// Flags of DNSKEY records, zone keys have FlagZone and key signing keys also
+// FlagSEP (RFC4034 2.1.1).
+const (
+	FlagZone = 256
+	FlagSEP  = 1
+)
+
+// protocol is the fixed protocol field of DNSKEY records.
+const protocol = 3
+
+// Key is a signing key of a zone. A key signing key (KSK) signs the DNSKEY
+// RRset and is referred to by the DS record at the parent, a zone signing key
+// (ZSK) signs everything else. A zone with a single key uses it for both.
+type Key struct {
+	Zone  string
+	Flags uint16
+
+	private *ecdsa.PrivateKey
+}
+
+// GenerateKey creates a new key for the zone, a KSK when ksk is set.
+func GenerateKey(zone string, ksk bool) (*Key, error) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "generating key")
+	}
+
+	flags := uint16(FlagZone)
+	if ksk {
+		flags |= FlagSEP
+	}
+
+	return &Key{Zone: buffer.NewDomainName(zone).Normalized(), Flags: flags, private: private}, nil
+}
+
+// KSK reports whether the key is a key signing key.
+func (k *Key) KSK() bool {
+	return k.Flags&FlagSEP != 0
+}
+
+// publicKey returns the public key in the format of DNSKEY records, the
+// coordinates of the point padded to 32 bytes each (RFC6605 4).
+func (k *Key) publicKey() []byte {
+	public := make([]byte, 64)
+	k.private.X.FillBytes(public[:32])
+	k.private.Y.FillBytes(public[32:])
+
+	return public
+}
+
+// rdata returns the data of the DNSKEY record of the key.
+func (k *Key) rdata() []byte {
+	data := make([]byte, 4, 4+64)
+	binary.BigEndian.PutUint16(data, k.Flags)
+	data[2] = protocol
+	data[3] = AlgorithmECDSAP256SHA256
+
+	return append(data, k.publicKey()...)
+}
+
+// DNSKEY returns the DNSKEY record publishing the key at the zone apex.
+func (k *Key) DNSKEY(ttl uint32) *dns.DNSRecord {
+	data := k.rdata()
+	return &dns.DNSRecord{
+		QType:   dns.DNSKEYQueryType,
+		Domain:  buffer.NewDomainName(k.Zone),
+		Class:   dns.ClassIN,
+		TTL:     ttl,
+		Data:    data,
+		DataLen: uint16(len(data)),
+	}
+}
+
+// KeyTag identifies the key in signatures and DS records (RFC4034 B).
+func (k *Key) KeyTag() uint16 {
+	var sum uint32
+	for i, b := range k.rdata() {
+		if i%2 == 0 {
+			sum += uint32(b) << 8
+		} else {
+			sum += uint32(b)
+		}
+	}
+	sum += sum >> 16 & 0xffff
+
+	return uint16(sum)
+}
+
+// DS returns the record to publish at the parent zone to delegate trust to
+// the key (RFC4034 5), only KSKs should be referred to.
+func (k *Key) DS(ttl uint32) (*dns.DNSRecord, error) {
+	owner, err := canonicalName(k.Zone)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256(append(owner, k.rdata()...))
+
+	data := make([]byte, 4, 4+len(digest))
+	binary.BigEndian.PutUint16(data, k.KeyTag())
+	data[2] = AlgorithmECDSAP256SHA256
+	data[3] = DigestSHA256
+	data = append(data, digest[:]...)
+
+	return &dns.DNSRecord{
+		QType:   dns.DSQueryType,
+		Domain:  buffer.NewDomainName(k.Zone),
+		Class:   dns.ClassIN,
+		TTL:     ttl,
+		Data:    data,
+		DataLen: uint16(len(data)),
+	}, nil
+}
+
+// FormatDNSKEY renders a DNSKEY record in the presentation format of RFC4034
+// 2.2, e.g. "example.com. 3600 IN DNSKEY 257 3 13 base64".
+func FormatDNSKEY(r *dns.DNSRecord) string {
+	if len(r.Data) < 4 {
+		return r.Text()
+	}
+
+	return fmt.Sprintf("%s. %d IN DNSKEY %d %d %d %s", r.Domain, r.TTL,
+		binary.BigEndian.Uint16(r.Data), r.Data[2], r.Data[3], base64.StdEncoding.EncodeToString(r.Data[4:]))
+}
+
+// FormatDS renders a DS record in the presentation format of RFC4034 5.3, as
+// registrars expect it.
+func FormatDS(r *dns.DNSRecord) string {
+	if len(r.Data) < 4 {
+		return r.Text()
+	}
+
+	return fmt.Sprintf("%s. %d IN DS %d %d %d %X", r.Domain, r.TTL,
+		binary.BigEndian.Uint16(r.Data), r.Data[2], r.Data[3], r.Data[4:])
+}
+
+// Write stores the key, private part included, as "Field: value" lines.
+func (k *Key) Write(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "Zone: %s\nFlags: %d\nAlgorithm: %d\nPrivateKey: %s\n",
+		k.Zone, k.Flags, AlgorithmECDSAP256SHA256, base64.StdEncoding.EncodeToString(k.private.D.Bytes()))
+
+	return errors.Wrap(err, "writing key")
+}
+
+// ReadKey reads a key stored by Write.
+func ReadKey(r io.Reader) (*Key, error) {
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		i := strings.Index(line, ":")
+		if i < 0 {
+			return nil, errors.Errorf("key line %q is not FIELD: VALUE", line)
+		}
+		fields[line[:i]] = strings.TrimSpace(line[i+1:])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "reading key")
+	}
+
+	if fields["Algorithm"] != strconv.Itoa(AlgorithmECDSAP256SHA256) {
+		return nil, errors.Errorf("unsupported key algorithm %q", fields["Algorithm"])
+	}
+
+	flags, err := strconv.ParseUint(fields["Flags"], 10, 16)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing key flags")
+	}
+	if flags&FlagZone == 0 {
+		return nil, errors.Errorf("key flags %d lack the zone key flag", flags)
+	}
+
+	d, err := base64.StdEncoding.DecodeString(fields["PrivateKey"])
+	if err != nil || len(d) == 0 {
+		return nil, errors.Errorf("invalid private key %q", fields["PrivateKey"])
+	}
+
+	if fields["Zone"] == "" {
+		return nil, errors.New("key has no zone")
+	}
+
+	private := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(d)}
+	private.Curve = elliptic.P256()
+	private.X, private.Y = private.Curve.ScalarBaseMult(d)
+
+	return &Key{Zone: buffer.NewDomainName(fields["Zone"]).Normalized(), Flags: uint16(flags), private: private}, nil
+}
+
+// WriteFile stores the key at path, readable by its owner only.
+func (k *Key) WriteFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return errors.Wrap(err, "creating key file")
+	}
+
+	if err := k.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return errors.Wrap(f.Close(), "writing key file")
+}
+
+// ReadKeyFile reads the key stored at path.
+func ReadKeyFile(path string) (*Key, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening key file")
+	}
+	defer f.Close()
+
+	k, err := ReadKey(f)
+	return k, errors.Wrapf(err, "reading key file %s", path)
+}
+
+// Keys implements flag.Value, every use of the flag reads another key file.
+type Keys []*Key
+
+func (ks *Keys) String() string {
+	keys := make([]string, 0, len(*ks))
+	for _, k := range *ks {
+		keys = append(keys, fmt.Sprintf("%s/%d", k.Zone, k.KeyTag()))
+	}
+
+	return strings.Join(keys, ",")
+}
+
+func (ks *Keys) Set(value string) error {
+	k, err := ReadKeyFile(value)
+	if err != nil {
+		return err
+	}
+
+	*ks = append(*ks, k)
+	return nil
+}
+
+// ForZone returns the keys of the zone.
+func (ks Keys) ForZone(zone string) Keys {
+	zone = buffer.NewDomainName(zone).Normalized()
+
+	keys := make(Keys, 0)
+	for _, k := range ks {
+		if k.Zone == zone {
+			keys = append(keys, k)
+		}
+	}
+
+	return keys
+}
+
+// canonicalName encodes name in uncompressed wire format with lowercase
+// letters (RFC4034 6.2).
+func canonicalName(name string) ([]byte, error) {
+	buf := buffer.NewBytePacketBuffer()
+	buf.DisableCompression()
+	if err := buf.WriteQname(buffer.NewDomainName(strings.ToLower(name))); err != nil {
+		return nil, errors.Wrapf(err, "encoding name %s", name)
+	}
+
+	return append([]byte(nil), buf.Buf[:buf.Pos()]...), nil
+}
@@ -0,0 +1,151 @@
+package dnssec_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/dnssec"
+)
+
+const exampleZone = `$TTL 3600
+@ SOA ns.example.com. admin.example.com. 1 7200 3600 1209600 300
+@ NS ns.example.com.
+ns A 10.0.0.53
+www A 10.0.0.1
+*.apps A 10.0.0.2
+child NS ns.child.example.com.
+ns.child A 10.0.1.53
+`
+
+func TestKey(t *testing.T) {
+	t.Run("round_trip", func(t *testing.T) {
+		k, err := dnssec.GenerateKey("Example.com.", true)
+		NoError(t, err)
+		True(t, k.KSK())
+		Equal(t, "example.com", k.Zone)
+
+		var buf bytes.Buffer
+		NoError(t, k.Write(&buf))
+
+		read, err := dnssec.ReadKey(&buf)
+		NoError(t, err)
+		Equal(t, k.Flags, read.Flags)
+		Equal(t, k.KeyTag(), read.KeyTag())
+		Equal(t, k.DNSKEY(3600).Data, read.DNSKEY(3600).Data)
+	})
+
+	t.Run("ds_refers_to_key", func(t *testing.T) {
+		k, err := dnssec.GenerateKey("example.com", true)
+		NoError(t, err)
+
+		ds, err := k.DS(3600)
+		NoError(t, err)
+		Len(t, ds.Data, 4+32)
+		True(t, strings.HasPrefix(dnssec.FormatDS(ds), "example.com. 3600 IN DS "))
+		Contains(t, dnssec.FormatDS(ds), " 13 2 ")
+		True(t, strings.HasPrefix(dnssec.FormatDNSKEY(k.DNSKEY(3600)), "example.com. 3600 IN DNSKEY 257 3 13 "))
+	})
+
+	t.Run("invalid_keys", func(t *testing.T) {
+		for _, text := range []string{
+			"Zone: example.com\nFlags: 257\nAlgorithm: 8\nPrivateKey: AQ==\n",
+			"Zone: example.com\nFlags: 1\nAlgorithm: 13\nPrivateKey: AQ==\n",
+			"Zone: example.com\nFlags: 257\nAlgorithm: 13\nPrivateKey: !\n",
+			"Flags: 257\nAlgorithm: 13\nPrivateKey: AQ==\n",
+			"garbage\n",
+		} {
+			_, err := dnssec.ReadKey(strings.NewReader(text))
+			Error(t, err, text)
+		}
+	})
+}
+
+func TestSign(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	records, err := dns.ReadZone(strings.NewReader(exampleZone), "example.com", 3600)
+	NoError(t, err)
+
+	ksk, err := dnssec.GenerateKey("example.com", true)
+	NoError(t, err)
+	zsk, err := dnssec.GenerateKey("example.com", false)
+	NoError(t, err)
+
+	signed, err := dnssec.Sign("example.com", records, []*dnssec.Key{ksk, zsk}, now)
+	NoError(t, err)
+
+	sets := make(map[dns.RRsetKey]*dns.RRset)
+	sigs := make(map[dns.RRsetKey][]*dns.DNSRecord)
+	nsecs := make([]string, 0)
+	for _, set := range dns.GroupRRsets(signed) {
+		sets[set.Key()] = set
+		if set.Type == dns.NSECQueryType {
+			nsecs = append(nsecs, set.Key().Name)
+		}
+	}
+	for _, r := range signed {
+		if r.QType == dns.RRSIGQueryType {
+			k := dns.RRsetKey{Name: r.Domain.Normalized(), Type: dnssec.TypeCovered(r), Class: r.Class}
+			sigs[k] = append(sigs[k], r)
+		}
+	}
+
+	t.Run("rrsets_verify", func(t *testing.T) {
+		for k, set := range sets {
+			if set.Type == dns.RRSIGQueryType || len(sigs[k]) == 0 {
+				continue
+			}
+
+			key := zsk
+			if set.Type == dns.DNSKEYQueryType {
+				key = ksk
+			}
+			Len(t, sigs[k], 1, k)
+			NoError(t, dnssec.Verify(set, sigs[k][0], key.DNSKEY(3600), now), k)
+			Error(t, dnssec.Verify(set, sigs[k][0], key.DNSKEY(3600), now.Add(dnssec.SignatureValidity+time.Hour)), k)
+		}
+	})
+
+	t.Run("authoritative_data_is_signed", func(t *testing.T) {
+		for _, k := range []dns.RRsetKey{
+			{Name: "example.com", Type: dns.SOAQueryType, Class: 1},
+			{Name: "example.com", Type: dns.NSQueryType, Class: 1},
+			{Name: "example.com", Type: dns.DNSKEYQueryType, Class: 1},
+			{Name: "www.example.com", Type: dns.AQueryType, Class: 1},
+			{Name: "*.apps.example.com", Type: dns.AQueryType, Class: 1},
+			{Name: "child.example.com", Type: dns.NSECQueryType, Class: 1},
+		} {
+			NotEmpty(t, sigs[k], k)
+		}
+
+		// Delegations and glue belong to the child zone
+		Empty(t, sigs[dns.RRsetKey{Name: "child.example.com", Type: dns.NSQueryType, Class: 1}])
+		Empty(t, sigs[dns.RRsetKey{Name: "ns.child.example.com", Type: dns.AQueryType, Class: 1}])
+	})
+
+	t.Run("nsec_chain_in_canonical_order", func(t *testing.T) {
+		Equal(t, []string{
+			"example.com", "*.apps.example.com", "child.example.com", "ns.example.com", "www.example.com",
+		}, nsecs)
+
+		// The last NSEC points back to the apex and lists A, RRSIG and NSEC
+		last := sets[dns.RRsetKey{Name: "www.example.com", Type: dns.NSECQueryType, Class: 1}].Records[0]
+		Equal(t, "076578616d706c6503636f6d00"+"0006"+"400000000003", hex.EncodeToString(last.Data))
+	})
+
+	t.Run("resigning_replaces_signatures", func(t *testing.T) {
+		again, err := dnssec.Sign("example.com", signed, []*dnssec.Key{ksk, zsk}, now)
+		NoError(t, err)
+		Equal(t, len(signed), len(again))
+	})
+
+	t.Run("keys_of_other_zones", func(t *testing.T) {
+		_, err := dnssec.Sign("example.org", records, []*dnssec.Key{ksk}, now)
+		Error(t, err)
+	})
+}
@@ -0,0 +1,341 @@
+package dnssec
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/pkg/errors"
+)
+
+// SignatureValidity is how long signatures stay valid, signed zones must be
+// signed again before that.
+const SignatureValidity = 30 * 24 * time.Hour
+
+// inceptionSkew backdates signatures for validators whose clocks lag.
+const inceptionSkew = time.Hour
+
+// rrsigHeaderLength is the size of the RRSIG data before the signer name.
+const rrsigHeaderLength = 18
+
+// Sign signs the records of the zone with its keys and returns them along
+// with the DNSKEY records of the keys, the NSEC chain and the signatures.
+// Signatures and NSEC records already among the records are replaced. Names
+// below zone cuts are glue and stay unsigned (RFC4035 2.2).
+func Sign(zone string, records []*dns.DNSRecord, keys []*Key, now time.Time) ([]*dns.DNSRecord, error) {
+	if len(keys) == 0 {
+		return nil, errors.Errorf("zone %s has no keys", zone)
+	}
+	origin := buffer.NewDomainName(zone).Normalized()
+
+	var soa *dns.DNSRecord
+	unsigned := make([]*dns.DNSRecord, 0, len(records)+len(keys))
+	for _, r := range records {
+		switch r.QType {
+		case dns.RRSIGQueryType, dns.NSECQueryType:
+			continue
+		case dns.SOAQueryType:
+			soa = r
+		}
+		unsigned = append(unsigned, r)
+	}
+	if soa == nil {
+		return nil, errors.Errorf("zone %s has no SOA record", zone)
+	}
+
+	for _, k := range keys {
+		if k.Zone != origin {
+			return nil, errors.Errorf("key %d is for zone %s, not %s", k.KeyTag(), k.Zone, origin)
+		}
+		if dnskey := k.DNSKEY(soa.TTL); !published(unsigned, dnskey) {
+			unsigned = append(unsigned, dnskey)
+		}
+	}
+
+	sets := dns.GroupRRsets(unsigned)
+	cuts := make(map[string]bool)
+	for _, set := range sets {
+		if set.Type == dns.NSQueryType && set.Key().Name != origin {
+			cuts[set.Key().Name] = true
+		}
+	}
+
+	// Only NS and DS records of a zone cut belong to the zone, the rest is
+	// glue like the names below the cut
+	types := make(map[string][]dns.QueryType)
+	signed := make([]*dns.RRset, 0, len(sets))
+	for _, set := range sets {
+		owner := set.Key().Name
+		if below(owner, origin, cuts) {
+			continue
+		}
+		if cuts[owner] && set.Type != dns.NSQueryType && set.Type != dns.DSQueryType {
+			continue
+		}
+
+		types[owner] = append(types[owner], set.Type)
+		if !cuts[owner] || set.Type == dns.DSQueryType {
+			signed = append(signed, set)
+		}
+	}
+
+	nsecs, err := nsecChain(types, nsecTTL(soa))
+	if err != nil {
+		return nil, err
+	}
+	for _, nsec := range nsecs {
+		signed = append(signed, dns.NewRRset(nsec))
+	}
+
+	signatures := make([]*dns.DNSRecord, 0, len(signed))
+	for _, set := range signed {
+		for _, k := range signers(keys, set.Type) {
+			sig, err := k.sign(set, now.Add(-inceptionSkew), now.Add(SignatureValidity))
+			if err != nil {
+				return nil, errors.Wrapf(err, "signing %s %s", set.Type, set.Name)
+			}
+			signatures = append(signatures, sig)
+		}
+	}
+
+	return append(append(unsigned, nsecs...), signatures...), nil
+}
+
+// published reports whether records already hold the DNSKEY record, e.g.
+// when a signed zone is signed again.
+func published(records []*dns.DNSRecord, dnskey *dns.DNSRecord) bool {
+	for _, r := range records {
+		if r.QType == dns.DNSKEYQueryType && r.Key() == dnskey.Key() && bytes.Equal(r.Data, dnskey.Data) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// below reports whether owner is below a zone cut of the zone.
+func below(owner string, origin string, cuts map[string]bool) bool {
+	for owner != origin {
+		i := strings.IndexByte(owner, '.')
+		if i < 0 {
+			return false
+		}
+		owner = owner[i+1:]
+		if cuts[owner] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// signers returns the keys signing RRsets of the type, KSKs sign the DNSKEY
+// RRset and ZSKs the others. Keys of the missing kind are stood in for by the
+// others.
+func signers(keys []*Key, qtype dns.QueryType) []*Key {
+	ksk := qtype == dns.DNSKEYQueryType
+
+	selected := make([]*Key, 0, len(keys))
+	for _, k := range keys {
+		if k.KSK() == ksk {
+			selected = append(selected, k)
+		}
+	}
+	if len(selected) == 0 {
+		return keys
+	}
+
+	return selected
+}
+
+// nsecTTL is the TTL of NSEC records, as long as negative answers may be
+// cached (RFC9077).
+func nsecTTL(soa *dns.DNSRecord) uint32 {
+	if soa.Minimum < soa.TTL {
+		return soa.Minimum
+	}
+
+	return soa.TTL
+}
+
+// nsecChain links the owner names in canonical order, each NSEC record lists
+// the types at its owner (RFC4034 4).
+func nsecChain(types map[string][]dns.QueryType, ttl uint32) ([]*dns.DNSRecord, error) {
+	owners := make([]string, 0, len(types))
+	for owner := range types {
+		owners = append(owners, owner)
+	}
+	sort.Slice(owners, func(i, j int) bool {
+		return dns.CompareNames(owners[i], owners[j]) < 0
+	})
+
+	nsecs := make([]*dns.DNSRecord, 0, len(owners))
+	for i, owner := range owners {
+		next, err := canonicalName(owners[(i+1)%len(owners)])
+		if err != nil {
+			return nil, err
+		}
+
+		data := append(next, typeBitmap(append(types[owner], dns.RRSIGQueryType, dns.NSECQueryType))...)
+		nsecs = append(nsecs, &dns.DNSRecord{
+			QType:   dns.NSECQueryType,
+			Domain:  buffer.NewDomainName(owner),
+			Class:   dns.ClassIN,
+			TTL:     ttl,
+			Data:    data,
+			DataLen: uint16(len(data)),
+		})
+	}
+
+	return nsecs, nil
+}
+
+// typeBitmap encodes the types in the window blocks of RFC4034 4.1.2.
+func typeBitmap(types []dns.QueryType) []byte {
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+
+	bitmap := make([]byte, 0)
+	for i := 0; i < len(types); {
+		window := types[i] >> 8
+		bits := make([]byte, 0, 32)
+		for ; i < len(types) && types[i]>>8 == window; i++ {
+			low := int(types[i] & 0xff)
+			for len(bits) <= low/8 {
+				bits = append(bits, 0)
+			}
+			bits[low/8] |= 0x80 >> uint(low%8)
+		}
+		bitmap = append(append(bitmap, byte(window), byte(len(bits))), bits...)
+	}
+
+	return bitmap
+}
+
+// TypeCovered returns the type of the RRset an RRSIG record signs.
+func TypeCovered(sig *dns.DNSRecord) dns.QueryType {
+	if sig.QType != dns.RRSIGQueryType || len(sig.Data) < 2 {
+		return dns.UnknownQueryType
+	}
+
+	return dns.QueryType(binary.BigEndian.Uint16(sig.Data))
+}
+
+// sign creates the RRSIG record of the RRset valid between inception and
+// expiration (RFC4034 3).
+func (k *Key) sign(set *dns.RRset, inception time.Time, expiration time.Time) (*dns.DNSRecord, error) {
+	signer, err := canonicalName(k.Zone)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := set.TTL()
+	data := make([]byte, rrsigHeaderLength, rrsigHeaderLength+len(signer)+64)
+	binary.BigEndian.PutUint16(data, uint16(set.Type))
+	data[2] = AlgorithmECDSAP256SHA256
+	data[3] = byte(labels(set.Name))
+	binary.BigEndian.PutUint32(data[4:], ttl)
+	binary.BigEndian.PutUint32(data[8:], uint32(expiration.Unix()))
+	binary.BigEndian.PutUint32(data[12:], uint32(inception.Unix()))
+	binary.BigEndian.PutUint16(data[16:], k.KeyTag())
+	data = append(data, signer...)
+
+	canonical, err := set.Canonical(ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256(append(append([]byte(nil), data...), canonical...))
+	r, s, err := ecdsa.Sign(rand.Reader, k.private, digest[:])
+	if err != nil {
+		return nil, errors.Wrap(err, "computing signature")
+	}
+
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	data = append(data, signature...)
+
+	return &dns.DNSRecord{
+		QType:   dns.RRSIGQueryType,
+		Domain:  set.Name,
+		Class:   set.Class,
+		TTL:     ttl,
+		Data:    data,
+		DataLen: uint16(len(data)),
+	}, nil
+}
+
+// labels counts the labels of the owner name of a signature, a wildcard
+// label isn't counted so that validators can tell expansions (RFC4034 3.1.3).
+func labels(name *buffer.DomainName) int {
+	n := len(name.SplitLabels())
+	if n > 0 && name.SplitLabels()[0] == "*" {
+		n--
+	}
+
+	return n
+}
+
+// Verify checks that sig is a valid signature of the RRset by the key of
+// the DNSKEY record at now. RRsets synthesized from a wildcard are verified
+// against the wildcard owner.
+func Verify(set *dns.RRset, sig *dns.DNSRecord, dnskey *dns.DNSRecord, now time.Time) error {
+	if len(dnskey.Data) != 4+64 || dnskey.Data[3] != AlgorithmECDSAP256SHA256 {
+		return errors.New("unsupported DNSKEY")
+	}
+	if len(sig.Data) < rrsigHeaderLength+1+64 || TypeCovered(sig) != set.Type || sig.Data[2] != AlgorithmECDSAP256SHA256 {
+		return errors.New("signature doesn't cover the RRset")
+	}
+
+	expiration := binary.BigEndian.Uint32(sig.Data[8:])
+	inception := binary.BigEndian.Uint32(sig.Data[12:])
+	if t := uint32(now.Unix()); t < inception || t > expiration {
+		return errors.New("signature isn't valid at this time")
+	}
+
+	// The labels field tells how many labels of the owner the wildcard
+	// stands for
+	owner := set.Name
+	if n := int(sig.Data[3]); n < len(owner.SplitLabels()) {
+		parts := owner.SplitLabels()
+		owner = buffer.NewDomainName(strings.Join(append([]string{"*"}, parts[len(parts)-n:]...), "."))
+	}
+	renamed := &dns.RRset{Name: owner, Type: set.Type, Class: set.Class}
+	for _, r := range set.Records {
+		copied := *r
+		copied.Domain = owner
+		renamed.Records = append(renamed.Records, &copied)
+	}
+
+	ttl := binary.BigEndian.Uint32(sig.Data[4:])
+	canonical, err := renamed.Canonical(ttl)
+	if err != nil {
+		return err
+	}
+
+	signed := sig.Data[:len(sig.Data)-64]
+	signature := sig.Data[len(sig.Data)-64:]
+	digest := sha256.Sum256(append(append([]byte(nil), signed...), canonical...))
+
+	public := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(dnskey.Data[4:36]),
+		Y:     new(big.Int).SetBytes(dnskey.Data[36:]),
+	}
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	if !ecdsa.Verify(public, digest[:], r, s) {
+		return errors.New("signature doesn't match")
+	}
+
+	return nil
+}
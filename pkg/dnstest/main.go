@@ -0,0 +1,253 @@
+// Package dnstest runs fake name servers answering from canned zones, so that
+// resolver and server tests don't need network access.
+package dnstest
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/zone"
+)
+
+// Server is a name server on a loopback UDP port answering authoritatively
+// for its zones, queries for other names are refused. Zones are transferred
+// over TCP on the same port.
+type Server struct {
+	Addr *net.UDPAddr
+
+	conn *net.UDPConn
+	ln   net.Listener
+
+	mu      sync.Mutex
+	zones   []*zone.Zone
+	queries []*dns.DNSQuestion
+}
+
+// NewServer starts a server for the zones, given as zone files keyed by their
+// origin. It is closed when the test ends.
+func NewServer(t testing.TB, zones map[string]string) *Server {
+	t.Helper()
+
+	s := &Server{}
+	for origin, text := range zones {
+		s.SetZone(t, origin, text)
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listening: %s", err)
+	}
+	s.conn = conn
+	s.Addr = conn.LocalAddr().(*net.UDPAddr)
+
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: s.Addr.IP, Port: s.Addr.Port})
+	if err != nil {
+		conn.Close()
+		t.Fatalf("listening: %s", err)
+	}
+	s.ln = ln
+	t.Cleanup(s.Close)
+
+	go s.serve()
+	go s.serveTransfers()
+
+	return s
+}
+
+// SetZone adds the zone or replaces it if the server already has it, e.g. to
+// publish a new version of a zone.
+func (s *Server) SetZone(t testing.TB, origin string, text string) {
+	t.Helper()
+
+	records, err := dns.ReadZone(strings.NewReader(text), origin, 3600)
+	if err != nil {
+		t.Fatalf("reading zone %s: %s", origin, err)
+	}
+
+	z, err := zone.New(origin, records)
+	if err != nil {
+		t.Fatalf("loading zone %s: %s", origin, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, existing := range s.zones {
+		if existing.Name == z.Name {
+			s.zones[i] = z
+			return
+		}
+	}
+	s.zones = append(s.zones, z)
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	s.conn.Close()
+	s.ln.Close()
+}
+
+// Queries returns the questions received so far.
+func (s *Server) Queries() []*dns.DNSQuestion {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*dns.DNSQuestion(nil), s.queries...)
+}
+
+func (s *Server) serve() {
+	for {
+		reqBuffer := buffer.NewBytePacketBuffer()
+		n, addr, err := s.conn.ReadFromUDP(reqBuffer.Buf)
+		if err != nil {
+			return
+		}
+		reqBuffer.Buf = reqBuffer.Buf[:n]
+
+		query, err := dns.DNSPacketFromBuffer(reqBuffer)
+		if err != nil || len(query.Questions) != 1 {
+			continue
+		}
+
+		response := s.answer(query)
+		resBuffer := buffer.NewBytePacketBuffer()
+		if err := response.Write(resBuffer); err != nil {
+			continue
+		}
+		s.conn.WriteToUDP(resBuffer.Buf[:resBuffer.Pos()], addr)
+	}
+}
+
+func (s *Server) answer(query *dns.DNSPacket) *dns.DNSPacket {
+	q := query.Questions[0]
+
+	s.mu.Lock()
+	s.queries = append(s.queries, q)
+	s.mu.Unlock()
+
+	response := dns.NewDNSPacket()
+	if z := s.find(q.Name); z != nil && q.QType != dns.AXFRQueryType {
+		response = z.Answer(q.Name.String(), q.QType)
+	} else {
+		response.Header.Response = true
+		response.Header.ResCode = dns.Refused
+	}
+
+	response.Header.ID = query.Header.ID
+	response.Header.RecursionDesired = query.Header.RecursionDesired
+	response.Questions = query.Questions
+
+	return response
+}
+
+// serveTransfers answers AXFR queries over TCP with the whole zone in a
+// single message.
+func (s *Server) serveTransfers() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			query, err := dns.ReadPacket(conn)
+			if err != nil || len(query.Questions) != 1 {
+				return
+			}
+
+			q := query.Questions[0]
+			s.mu.Lock()
+			s.queries = append(s.queries, q)
+			s.mu.Unlock()
+
+			response := dns.NewDNSPacket()
+			response.Header.ID = query.Header.ID
+			response.Header.Response = true
+			response.Header.AuthoritativeAnswer = true
+			response.Questions = query.Questions
+
+			z := s.find(q.Name)
+			if z == nil || q.QType != dns.AXFRQueryType || z.Name != q.Name.Normalized() {
+				response.Header.ResCode = dns.Refused
+			} else {
+				response.Answers = append(z.Records(), z.SOA)
+			}
+			response.WriteTo(conn)
+		}()
+	}
+}
+
+// find returns the most specific zone containing name.
+func (s *Server) find(name *buffer.DomainName) *zone.Zone {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var found *zone.Zone
+	for _, z := range s.zones {
+		if name.IsSubdomainOf(buffer.NewDomainName(z.Name)) && (found == nil || len(z.Name) > len(found.Name)) {
+			found = z
+		}
+	}
+
+	return found
+}
+
+// Exchange sends a query to addr over UDP and returns the response.
+func Exchange(t testing.TB, addr string, name string, qtype dns.QueryType) *dns.DNSPacket {
+	t.Helper()
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatalf("dialing %s: %s", addr, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	query := dns.NewDNSPacket()
+	query.Header.ID = 4660
+	query.Header.RecursionDesired = true
+	query.Questions = append(query.Questions, dns.NewDNSQuestion(name, qtype))
+	query.Resources = append(query.Resources, dns.NewOPTRecord(dns.DefaultUDPPayloadSize))
+
+	if _, err := query.WriteTo(conn); err != nil {
+		t.Fatalf("sending query: %s", err)
+	}
+
+	response, err := dns.ReadPacket(conn)
+	if err != nil {
+		t.Fatalf("reading response: %s", err)
+	}
+
+	return response
+}
+
+// Record parses a record in zone file format, e.g.
+// "www.example.com. 300 IN A 192.0.2.1".
+func Record(t testing.TB, text string) *dns.DNSRecord {
+	t.Helper()
+
+	r, err := dns.ParseRecord(text, 0)
+	if err != nil {
+		t.Fatalf("parsing record %q: %s", text, err)
+	}
+
+	return r
+}
+
+// Texts returns the records in zone file format, for comparing them in
+// tests.
+func Texts(records []*dns.DNSRecord) []string {
+	out := make([]string, 0, len(records))
+	for _, r := range records {
+		out = append(out, r.Text())
+	}
+	return out
+}
@@ -0,0 +1,51 @@
+package dnstest_test
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/dnstest"
+)
+
+func TestServer(t *testing.T) {
+	s := dnstest.NewServer(t, map[string]string{
+		"example.com": `
+@	IN SOA	ns.example.com. hostmaster.example.com. 1 7200 3600 1209600 300
+@	IN NS	ns.example.com.
+ns	IN A	192.0.2.53
+www	IN A	192.0.2.1
+sub	IN NS	ns.sub.example.com.
+ns.sub	IN A	192.0.2.54
+`,
+	})
+
+	t.Run("answers_from_zone", func(t *testing.T) {
+		response := dnstest.Exchange(t, s.Addr.String(), "www.example.com", dns.AQueryType)
+		Equal(t, uint16(4660), response.Header.ID)
+		True(t, response.Header.AuthoritativeAnswer)
+		if Len(t, response.Answers, 1) {
+			Equal(t, "192.0.2.1", response.Answers[0].Addr.String())
+			Equal(t, uint32(3600), response.Answers[0].TTL)
+		}
+	})
+
+	t.Run("refers_delegated_names", func(t *testing.T) {
+		response := dnstest.Exchange(t, s.Addr.String(), "www.sub.example.com", dns.AQueryType)
+		False(t, response.Header.AuthoritativeAnswer)
+		Empty(t, response.Answers)
+		if Len(t, response.Authorities, 1) {
+			Equal(t, "ns.sub.example.com", response.Authorities[0].Host.String())
+		}
+	})
+
+	t.Run("refuses_other_names", func(t *testing.T) {
+		response := dnstest.Exchange(t, s.Addr.String(), "www.example.org", dns.AQueryType)
+		Equal(t, dns.Refused, response.Header.ResCode)
+	})
+
+	t.Run("records_queries", func(t *testing.T) {
+		Len(t, s.Queries(), 3)
+	})
+}
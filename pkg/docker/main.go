@@ -0,0 +1,133 @@
+// Package docker answers queries for the names of containers, discovered
+// from the Docker API or a static mapping, under a suffix like docker.
+package docker
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/logger"
+)
+
+// TTL is the TTL of container records, short since containers come and go.
+const TTL = 10
+
+// Source lists the running containers by name.
+type Source interface {
+	Containers(ctx context.Context) (map[string][]net.IP, error)
+}
+
+// Containers holds the addresses of the containers of a source. It is safe
+// for concurrent use and can be refreshed while queries are answered.
+type Containers struct {
+	source Source
+	suffix string
+
+	mu    sync.RWMutex
+	addrs map[string][]net.IP
+}
+
+// New returns no containers answering under suffix, Refresh lists them from
+// source.
+func New(source Source, suffix string) *Containers {
+	return &Containers{
+		source: source,
+		suffix: buffer.NewDomainName(suffix).Normalized(),
+		addrs:  make(map[string][]net.IP),
+	}
+}
+
+// Refresh replaces the containers with the ones the source lists now and
+// returns how many names they have. The current containers are kept when the
+// source fails.
+func (c *Containers) Refresh(ctx context.Context) (int, error) {
+	containers, err := c.source.Containers(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	addrs := make(map[string][]net.IP, len(containers))
+	for name, ips := range containers {
+		name = buffer.NewDomainName(name).Normalized()
+		if name == "" {
+			continue
+		}
+		name = name + "." + c.suffix
+		addrs[name] = append(addrs[name], ips...)
+	}
+	for _, ips := range addrs {
+		sort.Slice(ips, func(i, j int) bool { return ips[i].String() < ips[j].String() })
+	}
+
+	c.mu.Lock()
+	c.addrs = addrs
+	c.mu.Unlock()
+
+	return len(addrs), nil
+}
+
+// Lookup returns the A or AAAA records of the container name of the type and
+// whether a container has the name at all.
+func (c *Containers) Lookup(name string, qtype dns.QueryType) ([]*dns.DNSRecord, bool) {
+	name = buffer.NewDomainName(name).Normalized()
+	if !strings.HasSuffix(name, "."+c.suffix) {
+		return nil, false
+	}
+
+	c.mu.RLock()
+	addrs, ok := c.addrs[name]
+	c.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+
+	records := make([]*dns.DNSRecord, 0, len(addrs))
+	for _, ip := range addrs {
+		var r *dns.DNSRecord
+		var err error
+		switch {
+		case ip.To4() != nil && qtype == dns.AQueryType:
+			r, err = dns.NewARecord(name, ip.To4(), TTL)
+		case ip.To4() == nil && qtype == dns.AAAAQueryType:
+			r, err = dns.NewAAAARecord(name, ip, TTL)
+		default:
+			continue
+		}
+		if err == nil {
+			records = append(records, r)
+		}
+	}
+
+	return records, true
+}
+
+// Len returns the number of container names.
+func (c *Containers) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return len(c.addrs)
+}
+
+// Watch refreshes the containers every interval until ctx is cancelled.
+func (c *Containers) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := c.Refresh(ctx); err != nil {
+				logger.Errorf("Error: listing containers: %s\n", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package docker_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/dnstest"
+	"github.com/msarvar/godns/pkg/docker"
+)
+
+const containersJSON = `[
+  {"Names": ["/shop-web-1"], "Labels": {"com.docker.compose.project": "shop", "com.docker.compose.service": "web"},
+   "NetworkSettings": {"Networks": {"shop_default": {"IPAddress": "172.18.0.2", "GlobalIPv6Address": "fd00::2"}}}},
+  {"Names": ["/shop-web-2"], "Labels": {"com.docker.compose.project": "shop", "com.docker.compose.service": "web"},
+   "NetworkSettings": {"Networks": {"shop_default": {"IPAddress": "172.18.0.3", "GlobalIPv6Address": ""}}}},
+  {"Names": ["/Postgres"], "Labels": {},
+   "NetworkSettings": {"Networks": {"bridge": {"IPAddress": "172.17.0.2"}}}}
+]`
+
+func TestAPI(t *testing.T) {
+	daemon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/containers/json" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(containersJSON))
+	}))
+	defer daemon.Close()
+
+	api, err := docker.NewAPI(daemon.URL)
+	NoError(t, err)
+	c := docker.New(api, "docker.")
+	n, err := c.Refresh(context.Background())
+	NoError(t, err)
+	Equal(t, 4, n)
+
+	t.Run("container_names", func(t *testing.T) {
+		records, ok := c.Lookup("postgres.docker", dns.AQueryType)
+		True(t, ok)
+		Equal(t, []string{"postgres.docker. 10 IN A 172.17.0.2"}, dnstest.Texts(records))
+
+		records, _ = c.Lookup("shop-web-1.docker", dns.AAAAQueryType)
+		Equal(t, []string{"shop-web-1.docker. 10 IN AAAA fd00::2"}, dnstest.Texts(records))
+	})
+
+	t.Run("compose_services_have_every_replica", func(t *testing.T) {
+		records, ok := c.Lookup("web.shop.docker", dns.AQueryType)
+		True(t, ok)
+		Equal(t, []string{"web.shop.docker. 10 IN A 172.18.0.2", "web.shop.docker. 10 IN A 172.18.0.3"}, dnstest.Texts(records))
+	})
+
+	t.Run("unknown_names", func(t *testing.T) {
+		_, ok := c.Lookup("redis.docker", dns.AQueryType)
+		False(t, ok)
+		_, ok = c.Lookup("postgres", dns.AQueryType)
+		False(t, ok)
+	})
+
+	t.Run("failed_refresh_keeps_containers", func(t *testing.T) {
+		daemon.Close()
+		_, err := c.Refresh(context.Background())
+		Error(t, err)
+		Equal(t, 4, c.Len())
+	})
+
+	t.Run("invalid_endpoints", func(t *testing.T) {
+		_, err := docker.NewAPI("tcp://127.0.0.1:2375")
+		Error(t, err)
+	})
+}
+
+func TestStatic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "containers.json")
+	NoError(t, ioutil.WriteFile(path, []byte(`{"web": "172.18.0.2", "db": ["172.18.0.3", "fd00::3"]}`), 0644))
+
+	static, err := docker.LoadStatic(path)
+	NoError(t, err)
+	c := docker.New(static, "docker")
+	_, err = c.Refresh(context.Background())
+	NoError(t, err)
+
+	records, ok := c.Lookup("db.docker", dns.AAAAQueryType)
+	True(t, ok)
+	Equal(t, []string{"db.docker. 10 IN AAAA fd00::3"}, dnstest.Texts(records))
+
+	NoError(t, ioutil.WriteFile(path, []byte(`{"web": "not an address"}`), 0644))
+	_, err = docker.LoadStatic(path)
+	Error(t, err)
+}
@@ -0,0 +1,163 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultEndpoint is where the Docker daemon listens by default.
+const DefaultEndpoint = "unix:/var/run/docker.sock"
+
+// composeProject and composeService are the labels docker compose puts on
+// the containers of a service.
+const (
+	composeProject = "com.docker.compose.project"
+	composeService = "com.docker.compose.service"
+)
+
+// API lists the running containers of a Docker daemon. Containers are named
+// by their name and compose containers also by SERVICE.PROJECT, replicas of
+// a service share the name.
+type API struct {
+	base   string
+	client *http.Client
+}
+
+// NewAPI returns the API of the daemon at endpoint, a unix socket given as
+// "unix:/path" or an http URL like http://127.0.0.1:2375.
+func NewAPI(endpoint string) (*API, error) {
+	transport := &http.Transport{}
+	base := strings.TrimSuffix(endpoint, "/")
+
+	switch {
+	case strings.HasPrefix(endpoint, "unix:"):
+		path := strings.TrimPrefix(strings.TrimPrefix(endpoint, "unix:"), "//")
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		}
+		// The host is ignored when dialing the socket
+		base = "http://docker"
+	case strings.HasPrefix(endpoint, "http://") || strings.HasPrefix(endpoint, "https://"):
+	default:
+		return nil, errors.Errorf("docker endpoint %q is neither unix:/path nor an http URL", endpoint)
+	}
+
+	return &API{
+		base:   base,
+		client: &http.Client{Transport: transport, Timeout: 10 * time.Second},
+	}, nil
+}
+
+// container is what the list containers endpoint returns of a container.
+type container struct {
+	Names           []string
+	Labels          map[string]string
+	NetworkSettings struct {
+		Networks map[string]struct {
+			IPAddress         string
+			GlobalIPv6Address string
+		}
+	}
+}
+
+// Containers lists the addresses of the running containers on every network
+// by name.
+func (a *API) Containers(ctx context.Context) (map[string][]net.IP, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.base+"/containers/json", nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating docker request")
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing containers")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("listing containers: docker answered %s", resp.Status)
+	}
+
+	var containers []container
+	if err := json.NewDecoder(resp.Body).Decode(&containers); err != nil {
+		return nil, errors.Wrap(err, "decoding containers")
+	}
+
+	addrs := make(map[string][]net.IP)
+	for _, c := range containers {
+		ips := make([]net.IP, 0, len(c.NetworkSettings.Networks))
+		for _, network := range c.NetworkSettings.Networks {
+			for _, address := range []string{network.IPAddress, network.GlobalIPv6Address} {
+				if ip := net.ParseIP(address); ip != nil {
+					ips = append(ips, ip)
+				}
+			}
+		}
+
+		names := make([]string, 0, len(c.Names)+1)
+		for _, name := range c.Names {
+			names = append(names, strings.TrimPrefix(name, "/"))
+		}
+		if project, service := c.Labels[composeProject], c.Labels[composeService]; project != "" && service != "" {
+			names = append(names, service+"."+project)
+		}
+
+		for _, name := range names {
+			addrs[name] = append(addrs[name], ips...)
+		}
+	}
+
+	return addrs, nil
+}
+
+// Static is a fixed mapping of container names to addresses, for
+// environments without a daemon to ask.
+type Static map[string][]net.IP
+
+// LoadStatic reads a JSON object mapping names to an address or a list of
+// addresses, e.g. {"web": "172.18.0.2", "db": ["172.18.0.3"]}.
+func LoadStatic(path string) (Static, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading container mapping")
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, errors.Wrap(err, "parsing container mapping")
+	}
+
+	static := make(Static, len(raw))
+	for name, value := range raw {
+		var addresses []string
+		if err := json.Unmarshal(value, &addresses); err != nil {
+			var address string
+			if err := json.Unmarshal(value, &address); err != nil {
+				return nil, errors.Errorf("container %s: expected an address or a list of addresses", name)
+			}
+			addresses = []string{address}
+		}
+
+		for _, address := range addresses {
+			ip := net.ParseIP(address)
+			if ip == nil {
+				return nil, errors.Errorf("container %s: invalid address %q", name, address)
+			}
+			static[name] = append(static[name], ip)
+		}
+	}
+
+	return static, nil
+}
+
+func (s Static) Containers(ctx context.Context) (map[string][]net.IP, error) {
+	return s, nil
+}
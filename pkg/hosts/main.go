@@ -0,0 +1,219 @@
+// Package hosts answers queries from a hosts(5) file like /etc/hosts.
+package hosts
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/logger"
+	"github.com/pkg/errors"
+)
+
+// TTL is the TTL of the records answered from the file, short so that
+// clients notice edits soon.
+const TTL = 60
+
+// Hosts holds the addresses of the names in a hosts file. It is safe for
+// concurrent use and can be reloaded while queries are answered.
+type Hosts struct {
+	path string
+
+	mu sync.RWMutex
+	// addrs maps names to their addresses in file order
+	addrs map[string][]net.IP
+	// names maps reverse names (in-addr.arpa and ip6.arpa) to the canonical
+	// names of the address, the first name of each line
+	names   map[string][]string
+	modTime time.Time
+}
+
+// New returns empty hosts, Load reads them from path.
+func New(path string) *Hosts {
+	return &Hosts{
+		path:  path,
+		addrs: make(map[string][]net.IP),
+		names: make(map[string][]string),
+	}
+}
+
+// Load replaces the entries with the ones in the file and returns how many
+// names were read. The current entries are kept when the file can't be read.
+func (h *Hosts) Load() (int, error) {
+	f, err := os.Open(h.path)
+	if err != nil {
+		return 0, errors.Wrap(err, "opening hosts file")
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, errors.Wrap(err, "reading hosts file")
+	}
+
+	addrs, names, err := parse(f)
+	if err != nil {
+		return 0, errors.Wrapf(err, "reading hosts file %s", h.path)
+	}
+
+	h.mu.Lock()
+	h.addrs, h.names, h.modTime = addrs, names, info.ModTime()
+	h.mu.Unlock()
+
+	return len(addrs), nil
+}
+
+// parse reads lines of an address followed by the canonical name and its
+// aliases, # starts a comment. Lines with invalid addresses are skipped like
+// the C library does.
+func parse(r io.Reader) (map[string][]net.IP, map[string][]string, error) {
+	addrs := make(map[string][]net.IP)
+	names := make(map[string][]string)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		// Link local addresses may name their interface, e.g. fe80::1%lo0
+		address := fields[0]
+		if i := strings.IndexByte(address, '%'); i >= 0 {
+			address = address[:i]
+		}
+		ip := net.ParseIP(address)
+		if ip == nil {
+			continue
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+
+		for _, name := range fields[1:] {
+			name = buffer.NewDomainName(name).Normalized()
+			addrs[name] = append(addrs[name], ip)
+		}
+
+		reverse := ReverseName(ip)
+		names[reverse] = append(names[reverse], buffer.NewDomainName(fields[1]).Normalized())
+	}
+
+	return addrs, names, scanner.Err()
+}
+
+// ReverseName returns the name PTR queries for ip ask for.
+func ReverseName(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", ip4[3], ip4[2], ip4[1], ip4[0])
+	}
+
+	const digits = "0123456789abcdef"
+	labels := make([]string, 0, 2*net.IPv6len+1)
+	for i := net.IPv6len - 1; i >= 0; i-- {
+		b := ip.To16()[i]
+		labels = append(labels, string(digits[b&0xf]), string(digits[b>>4]))
+	}
+
+	return strings.Join(append(labels, "ip6.arpa"), ".")
+}
+
+// Lookup returns the A, AAAA or PTR records of name of the type and whether
+// the file has the name at all, names without records of the type have no
+// data rather than not existing.
+func (h *Hosts) Lookup(name string, qtype dns.QueryType) ([]*dns.DNSRecord, bool) {
+	name = buffer.NewDomainName(name).Normalized()
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	records := make([]*dns.DNSRecord, 0)
+	if targets, ok := h.names[name]; ok {
+		if qtype != dns.PTRQueryType && qtype != dns.ANYQueryType {
+			return records, true
+		}
+		for _, target := range targets {
+			if r, err := dns.NewPTRRecord(name, target, TTL); err == nil {
+				records = append(records, r)
+			}
+		}
+		return records, true
+	}
+
+	addrs, ok := h.addrs[name]
+	if !ok {
+		return nil, false
+	}
+
+	for _, ip := range addrs {
+		var r *dns.DNSRecord
+		var err error
+		switch {
+		case ip.To4() != nil && (qtype == dns.AQueryType || qtype == dns.ANYQueryType):
+			r, err = dns.NewARecord(name, ip, TTL)
+		case ip.To4() == nil && (qtype == dns.AAAAQueryType || qtype == dns.ANYQueryType):
+			r, err = dns.NewAAAARecord(name, ip, TTL)
+		default:
+			continue
+		}
+		if err == nil {
+			records = append(records, r)
+		}
+	}
+
+	return records, true
+}
+
+// Len returns the number of names in the file.
+func (h *Hosts) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return len(h.addrs)
+}
+
+// Watch reloads the file whenever its modification time changes, checking
+// every interval until ctx is cancelled.
+func (h *Hosts) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			info, err := os.Stat(h.path)
+			if err != nil {
+				continue
+			}
+
+			h.mu.RLock()
+			changed := !info.ModTime().Equal(h.modTime)
+			h.mu.RUnlock()
+			if !changed {
+				continue
+			}
+
+			n, err := h.Load()
+			if err != nil {
+				logger.Errorf("Error: %s\n", err)
+				continue
+			}
+			logger.Infof("Reloaded %d names from hosts file %s\n", n, h.path)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
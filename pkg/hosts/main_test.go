@@ -0,0 +1,92 @@
+package hosts_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/dnstest"
+	"github.com/msarvar/godns/pkg/hosts"
+)
+
+const hostsFile = `# comment
+127.0.0.1 localhost
+::1       localhost ip6-localhost
+10.0.0.1  NAS.lan nas # storage
+10.0.0.2  printer.lan
+10.0.0.2  printer-old.lan
+fe80::1%lo0 router.lan
+not-an-ip broken.lan
+`
+
+func TestHosts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	NoError(t, ioutil.WriteFile(path, []byte(hostsFile), 0644))
+
+	h := hosts.New(path)
+	n, err := h.Load()
+	NoError(t, err)
+	Equal(t, 7, n)
+
+	t.Run("addresses", func(t *testing.T) {
+		records, ok := h.Lookup("nas.lan.", dns.AQueryType)
+		True(t, ok)
+		Equal(t, []string{"nas.lan. 60 IN A 10.0.0.1"}, dnstest.Texts(records))
+
+		records, ok = h.Lookup("localhost", dns.AAAAQueryType)
+		True(t, ok)
+		Equal(t, []string{"localhost. 60 IN AAAA ::1"}, dnstest.Texts(records))
+
+		records, _ = h.Lookup("router.lan", dns.AAAAQueryType)
+		Equal(t, []string{"router.lan. 60 IN AAAA fe80::1"}, dnstest.Texts(records))
+	})
+
+	t.Run("other_types_have_no_data", func(t *testing.T) {
+		records, ok := h.Lookup("printer.lan", dns.AAAAQueryType)
+		True(t, ok)
+		Empty(t, records)
+	})
+
+	t.Run("unknown_names", func(t *testing.T) {
+		_, ok := h.Lookup("broken.lan", dns.AQueryType)
+		False(t, ok)
+		_, ok = h.Lookup("example.com", dns.AQueryType)
+		False(t, ok)
+	})
+
+	t.Run("reverse_lookups_return_canonical_names", func(t *testing.T) {
+		records, ok := h.Lookup("2.0.0.10.in-addr.arpa", dns.PTRQueryType)
+		True(t, ok)
+		Equal(t, []string{
+			"2.0.0.10.in-addr.arpa. 60 IN PTR printer.lan.",
+			"2.0.0.10.in-addr.arpa. 60 IN PTR printer-old.lan.",
+		}, dnstest.Texts(records))
+
+		records, ok = h.Lookup(hosts.ReverseName(net.ParseIP("::1")), dns.PTRQueryType)
+		True(t, ok)
+		Equal(t, []string{"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa. 60 IN PTR localhost."}, dnstest.Texts(records))
+	})
+
+	t.Run("changes_are_reloaded", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go h.Watch(ctx, 10*time.Millisecond)
+
+		NoError(t, ioutil.WriteFile(path, []byte("10.0.0.9 nas.lan\n"), 0644))
+		later := time.Now().Add(time.Second)
+		NoError(t, os.Chtimes(path, later, later))
+
+		Eventually(t, func() bool {
+			records, _ := h.Lookup("nas.lan", dns.AQueryType)
+			return len(records) == 1 && records[0].Addr.Equal(net.ParseIP("10.0.0.9"))
+		}, time.Second, 10*time.Millisecond)
+		Equal(t, 1, h.Len())
+	})
+}
@@ -0,0 +1,70 @@
+// Package idna converts internationalized domain names between the Unicode
+// form users type (U-labels) and the ASCII form sent on the wire (A-labels),
+// see RFC5890 and RFC3492.
+package idna
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// acePrefix marks a punycode encoded label
+const acePrefix = "xn--"
+
+// dots are the label separators accepted in Unicode names (RFC3490 3.1)
+var dots = strings.NewReplacer("。", ".", "．", ".", "｡", ".")
+
+// ToASCII converts every non ASCII label of name into its A-label. ASCII
+// labels are left untouched.
+func ToASCII(name string) (string, error) {
+	labels := strings.Split(dots.Replace(name), ".")
+	for i, label := range labels {
+		if isASCII(label) {
+			continue
+		}
+
+		encoded, err := encodePunycode([]rune(strings.ToLower(label)))
+		if err != nil {
+			return "", errors.Wrapf(err, "encoding label %q", label)
+		}
+
+		labels[i] = acePrefix + encoded
+		if len(labels[i]) > 63 {
+			return "", errors.Errorf("label %q exceeds 63 characters once encoded", label)
+		}
+	}
+
+	return strings.Join(labels, "."), nil
+}
+
+// ToUnicode converts every A-label of name into its U-label. Labels that
+// don't decode are returned unchanged.
+func ToUnicode(name string) string {
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if len(label) <= len(acePrefix) || !strings.EqualFold(label[:len(acePrefix)], acePrefix) {
+			continue
+		}
+
+		decoded, err := decodePunycode(label[len(acePrefix):])
+		if err != nil {
+			continue
+		}
+
+		labels[i] = string(decoded)
+	}
+
+	return strings.Join(labels, ".")
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,41 @@
+package idna_test
+
+import (
+	"testing"
+
+	"github.com/msarvar/godns/pkg/idna"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestIDNA(t *testing.T) {
+	cases := []struct {
+		unicode string
+		ascii   string
+	}{
+		{"bücher.example", "xn--bcher-kva.example"},
+		{"münchen.de", "xn--mnchen-3ya.de"},
+		{"例え.テスト", "xn--r8jz45g.xn--zckzah"},
+		{"www.google.com", "www.google.com"},
+	}
+
+	t.Run("to_ascii", func(t *testing.T) {
+		for _, c := range cases {
+			ascii, err := idna.ToASCII(c.unicode)
+			NoError(t, err)
+			Equal(t, c.ascii, ascii)
+		}
+
+		ascii, err := idna.ToASCII("例え。テスト")
+		NoError(t, err)
+		Equal(t, "xn--r8jz45g.xn--zckzah", ascii)
+	})
+
+	t.Run("to_unicode", func(t *testing.T) {
+		for _, c := range cases {
+			Equal(t, c.unicode, idna.ToUnicode(c.ascii))
+		}
+
+		// invalid punycode is kept as is
+		Equal(t, "xn--!!.com", idna.ToUnicode("xn--!!.com"))
+	})
+}
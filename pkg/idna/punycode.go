@@ -0,0 +1,189 @@
+package idna
+
+import (
+	"math"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Bootstring parameters for punycode, RFC3492 section 5
+const (
+	base        = 36
+	tmin        = 1
+	tmax        = 26
+	skew        = 38
+	damp        = 700
+	initialBias = 72
+	initialN    = 128
+)
+
+func adapt(delta, numPoints int, firstTime bool) int {
+	if firstTime {
+		delta /= damp
+	} else {
+		delta /= 2
+	}
+
+	delta += delta / numPoints
+	k := 0
+	for delta > ((base-tmin)*tmax)/2 {
+		delta /= base - tmin
+		k += base
+	}
+
+	return k + (base-tmin+1)*delta/(delta+skew)
+}
+
+// threshold is the t(j) value of the bootstring algorithm
+func threshold(k, bias int) int {
+	switch {
+	case k <= bias:
+		return tmin
+	case k >= bias+tmax:
+		return tmax
+	default:
+		return k - bias
+	}
+}
+
+func encodeDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+
+	return byte('0' + d - 26)
+}
+
+func decodeDigit(c byte) (int, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c-'0') + 26, true
+	case c >= 'a' && c <= 'z':
+		return int(c - 'a'), true
+	case c >= 'A' && c <= 'Z':
+		return int(c - 'A'), true
+	default:
+		return 0, false
+	}
+}
+
+// encodePunycode encodes a label, without the ACE prefix.
+func encodePunycode(input []rune) (string, error) {
+	var out strings.Builder
+
+	for _, r := range input {
+		if r < 0x80 {
+			out.WriteByte(byte(r))
+		}
+	}
+
+	basic := out.Len()
+	handled := basic
+	if basic > 0 {
+		out.WriteByte('-')
+	}
+
+	n, delta, bias := initialN, 0, initialBias
+	for handled < len(input) {
+		m := math.MaxInt32
+		for _, r := range input {
+			if int(r) >= n && int(r) < m {
+				m = int(r)
+			}
+		}
+
+		if (m - n) > (math.MaxInt32-delta)/(handled+1) {
+			return "", errors.New("punycode overflow")
+		}
+		delta += (m - n) * (handled + 1)
+		n = m
+
+		for _, r := range input {
+			if int(r) < n {
+				delta++
+			}
+
+			if int(r) != n {
+				continue
+			}
+
+			q := delta
+			for k := base; ; k += base {
+				t := threshold(k, bias)
+				if q < t {
+					break
+				}
+				out.WriteByte(encodeDigit(t + (q-t)%(base-t)))
+				q = (q - t) / (base - t)
+			}
+			out.WriteByte(encodeDigit(q))
+
+			bias = adapt(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+		}
+
+		delta++
+		n++
+	}
+
+	return out.String(), nil
+}
+
+// decodePunycode decodes a label, without the ACE prefix.
+func decodePunycode(input string) ([]rune, error) {
+	output := make([]rune, 0, len(input))
+
+	rest := input
+	if i := strings.LastIndexByte(input, '-'); i >= 0 {
+		for _, c := range []byte(input[:i]) {
+			if c >= 0x80 {
+				return nil, errors.New("non basic code point before delimiter")
+			}
+			output = append(output, rune(c))
+		}
+		rest = input[i+1:]
+	}
+
+	n, i, bias := initialN, 0, initialBias
+	for len(rest) > 0 {
+		oldi, w := i, 1
+		for k := base; ; k += base {
+			if len(rest) == 0 {
+				return nil, errors.New("truncated punycode")
+			}
+
+			digit, ok := decodeDigit(rest[0])
+			if !ok {
+				return nil, errors.Errorf("invalid punycode digit %q", rest[0])
+			}
+			rest = rest[1:]
+
+			if digit > (math.MaxInt32-i)/w {
+				return nil, errors.New("punycode overflow")
+			}
+			i += digit * w
+
+			t := threshold(k, bias)
+			if digit < t {
+				break
+			}
+			w *= base - t
+		}
+
+		bias = adapt(i-oldi, len(output)+1, oldi == 0)
+		n += i / (len(output) + 1)
+		if n > 0x10FFFF {
+			return nil, errors.New("punycode code point out of range")
+		}
+		i %= len(output) + 1
+
+		output = append(output, 0)
+		copy(output[i+1:], output[i:])
+		output[i] = rune(n)
+		i++
+	}
+
+	return output, nil
+}
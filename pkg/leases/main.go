@@ -0,0 +1,358 @@
+// Package leases answers queries for the hostnames of DHCP clients from the
+// lease file of dnsmasq or ISC dhcpd.
+package leases
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/hosts"
+	"github.com/msarvar/godns/pkg/logger"
+	"github.com/pkg/errors"
+)
+
+// TTL is the TTL of the records answered from leases, short since leases
+// come and go.
+const TTL = 60
+
+// Lease is a DHCP lease of a client that sent its hostname.
+type Lease struct {
+	IP       net.IP
+	Hostname string
+	// Expires is zero for leases that never expire
+	Expires time.Time
+}
+
+// Leases holds the leases of a lease file and answers for their hostnames
+// under a local domain. It is safe for concurrent use and can be reloaded
+// while queries are answered.
+type Leases struct {
+	path   string
+	domain string
+
+	mu sync.RWMutex
+	// names maps the hostnames under domain and reverse names to leases
+	names   map[string][]*Lease
+	reverse map[string][]*Lease
+	modTime time.Time
+}
+
+// New returns empty leases answering for hostnames under domain, e.g. lan,
+// Load reads them from path.
+func New(path string, domain string) *Leases {
+	return &Leases{
+		path:    path,
+		domain:  buffer.NewDomainName(domain).Normalized(),
+		names:   make(map[string][]*Lease),
+		reverse: make(map[string][]*Lease),
+	}
+}
+
+// Load replaces the leases with the ones in the file and returns how many
+// were read. The current leases are kept when the file can't be read.
+func (l *Leases) Load() (int, error) {
+	f, err := os.Open(l.path)
+	if err != nil {
+		return 0, errors.Wrap(err, "opening lease file")
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, errors.Wrap(err, "reading lease file")
+	}
+
+	leases, err := Parse(f)
+	if err != nil {
+		return 0, errors.Wrapf(err, "reading lease file %s", l.path)
+	}
+
+	names := make(map[string][]*Lease)
+	reverse := make(map[string][]*Lease)
+	for _, lease := range leases {
+		name := lease.Hostname + "." + l.domain
+		names[name] = append(names[name], lease)
+		reverse[hosts.ReverseName(lease.IP)] = append(reverse[hosts.ReverseName(lease.IP)], lease)
+	}
+
+	l.mu.Lock()
+	l.names, l.reverse, l.modTime = names, reverse, info.ModTime()
+	l.mu.Unlock()
+
+	return len(leases), nil
+}
+
+// Parse reads a dnsmasq lease file, lines of expiry time, MAC address, IP
+// address, hostname and client ID, or an ISC dhcpd.leases file of lease
+// blocks. Leases of clients that sent no usable hostname are left out.
+func Parse(r io.Reader) ([]*Lease, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "lease ") {
+			return parseISC(lines)
+		}
+	}
+
+	return parseDnsmasq(lines)
+}
+
+// parseDnsmasq reads the lines of a dnsmasq lease file, IPv6 leases follow
+// a "duid" line and have the IAID in place of the MAC address.
+func parseDnsmasq(lines []string) ([]*Lease, error) {
+	leases := make([]*Lease, 0, len(lines))
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] == "duid" {
+			continue
+		}
+		if len(fields) < 4 {
+			return nil, errors.Errorf("line %d: expected expiry, MAC, address and hostname", i+1)
+		}
+
+		expiry, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d: parsing expiry", i+1)
+		}
+		ip := net.ParseIP(fields[2])
+		if ip == nil {
+			return nil, errors.Errorf("line %d: invalid address %q", i+1, fields[2])
+		}
+
+		hostname, ok := hostname(fields[3])
+		if !ok {
+			continue
+		}
+
+		lease := &Lease{IP: ip, Hostname: hostname}
+		if expiry > 0 {
+			lease.Expires = time.Unix(expiry, 0)
+		}
+		leases = append(leases, lease)
+	}
+
+	return leases, nil
+}
+
+// parseISC reads the lease blocks of an ISC dhcpd.leases file. dhcpd appends
+// a new block whenever a lease changes, the last block of an address wins.
+func parseISC(lines []string) ([]*Lease, error) {
+	byIP := make(map[string]*Lease)
+	order := make([]string, 0)
+
+	var current *Lease
+	active := true
+	for i, line := range lines {
+		if j := strings.IndexByte(line, '#'); j >= 0 {
+			line = line[:j]
+		}
+		fields := strings.Fields(strings.TrimSuffix(strings.TrimSpace(line), ";"))
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch {
+		case fields[0] == "lease" && len(fields) == 3 && fields[2] == "{":
+			ip := net.ParseIP(fields[1])
+			if ip == nil {
+				return nil, errors.Errorf("line %d: invalid address %q", i+1, fields[1])
+			}
+			current, active = &Lease{IP: ip}, true
+		case fields[0] == "}" && current != nil:
+			key := current.IP.String()
+			if _, ok := byIP[key]; !ok {
+				order = append(order, key)
+			}
+			byIP[key] = nil
+			if active && current.Hostname != "" {
+				byIP[key] = current
+			}
+			current = nil
+		case current == nil:
+			continue
+		case fields[0] == "client-hostname" && len(fields) == 2:
+			if name, ok := hostname(strings.Trim(fields[1], `"`)); ok {
+				current.Hostname = name
+			}
+		case fields[0] == "binding" && len(fields) == 3 && fields[1] == "state":
+			active = fields[2] == "active"
+		case fields[0] == "ends":
+			expires, err := parseISCTime(fields[1:])
+			if err != nil {
+				return nil, errors.Wrapf(err, "line %d", i+1)
+			}
+			current.Expires = expires
+		}
+	}
+
+	leases := make([]*Lease, 0, len(byIP))
+	for _, key := range order {
+		if lease := byIP[key]; lease != nil {
+			leases = append(leases, lease)
+		}
+	}
+
+	return leases, nil
+}
+
+// parseISCTime parses the time of an ends statement, "never", "epoch
+// SECONDS" or a weekday followed by a UTC date and time.
+func parseISCTime(fields []string) (time.Time, error) {
+	switch {
+	case len(fields) == 1 && fields[0] == "never":
+		return time.Time{}, nil
+	case len(fields) == 2 && fields[0] == "epoch":
+		seconds, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return time.Time{}, errors.Wrap(err, "parsing lease end")
+		}
+		return time.Unix(seconds, 0), nil
+	case len(fields) == 3:
+		t, err := time.Parse("2006/01/02 15:04:05", fields[1]+" "+fields[2])
+		return t, errors.Wrap(err, "parsing lease end")
+	default:
+		return time.Time{}, errors.Errorf("invalid lease end %q", strings.Join(fields, " "))
+	}
+}
+
+// hostname normalizes a client's hostname to a single label, false when it
+// is missing ("*" in dnsmasq) or not a valid label.
+func hostname(name string) (string, bool) {
+	name = strings.ToLower(name)
+	if name == "" || name == "*" || len(name) > 63 || name[0] == '-' || name[len(name)-1] == '-' {
+		return "", false
+	}
+
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			return "", false
+		}
+	}
+
+	return name, true
+}
+
+// Lookup returns the A, AAAA or PTR records of name of the type from the
+// leases not expired at now, and whether a lease has the name at all.
+func (l *Leases) Lookup(name string, qtype dns.QueryType, now time.Time) ([]*dns.DNSRecord, bool) {
+	name = buffer.NewDomainName(name).Normalized()
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	records := make([]*dns.DNSRecord, 0)
+	if leases := current(l.reverse[name], now); len(leases) > 0 {
+		if qtype != dns.PTRQueryType {
+			return records, true
+		}
+		for _, lease := range leases {
+			if r, err := dns.NewPTRRecord(name, lease.Hostname+"."+l.domain, TTL); err == nil {
+				records = append(records, r)
+			}
+		}
+		return records, true
+	}
+
+	leases := current(l.names[name], now)
+	if len(leases) == 0 {
+		return nil, false
+	}
+
+	for _, lease := range leases {
+		var r *dns.DNSRecord
+		var err error
+		switch {
+		case lease.IP.To4() != nil && qtype == dns.AQueryType:
+			r, err = dns.NewARecord(name, lease.IP.To4(), TTL)
+		case lease.IP.To4() == nil && qtype == dns.AAAAQueryType:
+			r, err = dns.NewAAAARecord(name, lease.IP, TTL)
+		default:
+			continue
+		}
+		if err == nil {
+			records = append(records, r)
+		}
+	}
+
+	return records, true
+}
+
+// current returns the leases not expired at now, the most recent first.
+func current(leases []*Lease, now time.Time) []*Lease {
+	valid := make([]*Lease, 0, len(leases))
+	for _, lease := range leases {
+		if lease.Expires.IsZero() || lease.Expires.After(now) {
+			valid = append(valid, lease)
+		}
+	}
+
+	sort.SliceStable(valid, func(i, j int) bool {
+		a, b := valid[i].Expires, valid[j].Expires
+		return a.IsZero() && !b.IsZero() || !b.IsZero() && a.After(b)
+	})
+
+	return valid
+}
+
+// Len returns the number of leases.
+func (l *Leases) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	n := 0
+	for _, leases := range l.names {
+		n += len(leases)
+	}
+
+	return n
+}
+
+// Watch reloads the file whenever its modification time changes, checking
+// every interval until ctx is cancelled.
+func (l *Leases) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			info, err := os.Stat(l.path)
+			if err != nil {
+				continue
+			}
+
+			l.mu.RLock()
+			changed := !info.ModTime().Equal(l.modTime)
+			l.mu.RUnlock()
+			if !changed {
+				continue
+			}
+
+			n, err := l.Load()
+			if err != nil {
+				logger.Errorf("Error: %s\n", err)
+				continue
+			}
+			logger.Infof("Reloaded %d DHCP leases from %s\n", n, l.path)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package leases_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/dnstest"
+	"github.com/msarvar/godns/pkg/leases"
+)
+
+const dnsmasqLeases = `1700003600 00:11:22:33:44:55 192.168.1.10 Laptop 01:00:11:22:33:44:55
+0 00:11:22:33:44:66 192.168.1.11 printer *
+1699990000 00:11:22:33:44:77 192.168.1.12 old-phone *
+1700003600 00:11:22:33:44:88 192.168.1.13 * *
+duid 00:01:00:01:2a:2b:2c:2d:00:11:22:33:44:55
+1700003600 1234 fd00::10 laptop 00:01:00:01
+`
+
+const iscLeases = `# The format of this file is documented in the dhcpd.leases(5) manual page.
+lease 192.168.1.20 {
+  starts 2 2023/11/14 20:00:00;
+  ends 2 2023/11/14 23:00:00;
+  binding state active;
+  client-hostname "desktop";
+}
+lease 192.168.1.21 {
+  ends never;
+  binding state active;
+  client-hostname "nas";
+}
+lease 192.168.1.21 {
+  ends epoch 1700003600; # Tue Nov 14 23:13:20 2023
+  binding state free;
+  client-hostname "nas";
+}
+lease 192.168.1.22 {
+  ends never;
+  binding state active;
+  client-hostname "bad_name";
+}
+`
+
+func TestParse(t *testing.T) {
+	t.Run("dnsmasq", func(t *testing.T) {
+		parsed, err := leases.Parse(strings.NewReader(dnsmasqLeases))
+		NoError(t, err)
+
+		names := make([]string, 0)
+		for _, l := range parsed {
+			names = append(names, l.Hostname+"="+l.IP.String())
+		}
+		Equal(t, []string{"laptop=192.168.1.10", "printer=192.168.1.11", "old-phone=192.168.1.12", "laptop=fd00::10"}, names)
+		True(t, parsed[1].Expires.IsZero(), "never expires")
+		Equal(t, time.Unix(1700003600, 0), parsed[0].Expires)
+	})
+
+	t.Run("isc", func(t *testing.T) {
+		parsed, err := leases.Parse(strings.NewReader(iscLeases))
+		NoError(t, err)
+		Len(t, parsed, 1, "freed and unnamed leases are left out")
+		Equal(t, "desktop", parsed[0].Hostname)
+		Equal(t, time.Date(2023, 11, 14, 23, 0, 0, 0, time.UTC), parsed[0].Expires)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := leases.Parse(strings.NewReader("soon 00:11:22:33:44:55 192.168.1.10 laptop *\n"))
+		Error(t, err)
+		_, err = leases.Parse(strings.NewReader("lease 192.168.1.20 {\n  ends 2 tomorrow;\n}\n"))
+		Error(t, err)
+	})
+}
+
+func TestLookup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dnsmasq.leases")
+	NoError(t, ioutil.WriteFile(path, []byte(dnsmasqLeases), 0644))
+
+	l := leases.New(path, "LAN.")
+	n, err := l.Load()
+	NoError(t, err)
+	Equal(t, 4, n)
+	now := time.Unix(1700000000, 0)
+
+	t.Run("hostnames_under_domain", func(t *testing.T) {
+		records, ok := l.Lookup("laptop.lan", dns.AQueryType, now)
+		True(t, ok)
+		Equal(t, []string{"laptop.lan. 60 IN A 192.168.1.10"}, dnstest.Texts(records))
+
+		records, _ = l.Lookup("laptop.lan", dns.AAAAQueryType, now)
+		Equal(t, []string{"laptop.lan. 60 IN AAAA fd00::10"}, dnstest.Texts(records))
+
+		_, ok = l.Lookup("laptop", dns.AQueryType, now)
+		False(t, ok)
+	})
+
+	t.Run("reverse_names", func(t *testing.T) {
+		records, ok := l.Lookup("11.1.168.192.in-addr.arpa", dns.PTRQueryType, now)
+		True(t, ok)
+		Equal(t, []string{"11.1.168.192.in-addr.arpa. 60 IN PTR printer.lan."}, dnstest.Texts(records))
+	})
+
+	t.Run("expired_leases", func(t *testing.T) {
+		_, ok := l.Lookup("old-phone.lan", dns.AQueryType, now)
+		False(t, ok)
+		_, ok = l.Lookup("laptop.lan", dns.AQueryType, now.Add(time.Hour+time.Second))
+		False(t, ok)
+	})
+}
@@ -0,0 +1,82 @@
+// Package logger prints messages filtered by a level that can be changed
+// while the server runs.
+package logger
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// Level is the least severity of messages that get printed.
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelInfo:
+		return "info"
+	case LevelError:
+		return "error"
+	default:
+		return "debug"
+	}
+}
+
+// Set implements flag.Value so the level can be passed on the command line.
+func (l *Level) Set(value string) error {
+	switch value {
+	case "debug":
+		*l = LevelDebug
+	case "info":
+		*l = LevelInfo
+	case "error":
+		*l = LevelError
+	default:
+		return errors.Errorf("unknown log level %q", value)
+	}
+
+	return nil
+}
+
+// level defaults to debug so everything is printed unless asked otherwise.
+var level int32 = int32(LevelDebug)
+
+// SetLevel changes the level, it is safe to call while logging.
+func SetLevel(l Level) {
+	atomic.StoreInt32(&level, int32(l))
+}
+
+// GetLevel returns the current level.
+func GetLevel() Level {
+	return Level(atomic.LoadInt32(&level))
+}
+
+// Debugf prints traces of the resolution useful when troubleshooting.
+func Debugf(format string, args ...interface{}) {
+	logf(LevelDebug, format, args...)
+}
+
+// Infof prints messages about normal operation.
+func Infof(format string, args ...interface{}) {
+	logf(LevelInfo, format, args...)
+}
+
+// Errorf prints failures.
+func Errorf(format string, args ...interface{}) {
+	logf(LevelError, format, args...)
+}
+
+func logf(l Level, format string, args ...interface{}) {
+	if l < GetLevel() {
+		return
+	}
+
+	fmt.Printf(format, args...)
+}
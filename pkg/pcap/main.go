@@ -0,0 +1,169 @@
+// Package pcap writes DNS messages to a pcap file. Every message is wrapped in
+// synthetic IP and UDP headers so the capture opens in Wireshark and similar
+// tools.
+package pcap
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	magic        = 0xa1b2c3d4
+	versionMajor = 2
+	versionMinor = 4
+	snapLen      = 65535
+	// linkTypeRaw means every packet starts directly with an IPv4 or IPv6
+	// header
+	linkTypeRaw = 101
+
+	protocolUDP = 17
+	ttl         = 64
+)
+
+// Writer appends packets to a pcap stream. It is safe for concurrent use.
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriter writes the pcap file header to w and returns a writer for the
+// packets.
+func NewWriter(w io.Writer) (*Writer, error) {
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr[0:], magic)
+	binary.LittleEndian.PutUint16(hdr[4:], versionMajor)
+	binary.LittleEndian.PutUint16(hdr[6:], versionMinor)
+	binary.LittleEndian.PutUint32(hdr[16:], snapLen)
+	binary.LittleEndian.PutUint32(hdr[20:], linkTypeRaw)
+
+	if _, err := w.Write(hdr); err != nil {
+		return nil, errors.Wrap(err, "writing pcap header")
+	}
+
+	return &Writer{w: w}, nil
+}
+
+// WriteUDP records msg as a UDP datagram sent from src to dst at ts. Messages
+// exchanged over TCP are recorded the same way, without the length prefix.
+func (pw *Writer) WriteUDP(ts time.Time, src net.Addr, dst net.Addr, msg []byte) error {
+	srcIP, srcPort := endpoint(src)
+	dstIP, dstPort := endpoint(dst)
+
+	packet := ipPacket(srcIP, srcPort, dstIP, dstPort, msg)
+
+	record := make([]byte, 16, 16+len(packet))
+	binary.LittleEndian.PutUint32(record[0:], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(record[4:], uint32(ts.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(record[12:], uint32(len(packet)))
+	record = append(record, packet...)
+
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	_, err := pw.w.Write(record)
+	return errors.Wrap(err, "writing pcap record")
+}
+
+func endpoint(addr net.Addr) (net.IP, uint16) {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP, uint16(a.Port)
+	case *net.TCPAddr:
+		return a.IP, uint16(a.Port)
+	default:
+		return nil, 0
+	}
+}
+
+// ipPacket builds an IPv4 packet when both addresses are IPv4 and an IPv6
+// packet otherwise, IPv4 addresses are then mapped into IPv6.
+func ipPacket(src net.IP, srcPort uint16, dst net.IP, dstPort uint16, msg []byte) []byte {
+	udp := make([]byte, 8, 8+len(msg))
+	binary.BigEndian.PutUint16(udp[0:], srcPort)
+	binary.BigEndian.PutUint16(udp[2:], dstPort)
+	binary.BigEndian.PutUint16(udp[4:], uint16(8+len(msg)))
+	udp = append(udp, msg...)
+
+	if src4, dst4 := src.To4(), dst.To4(); src4 != nil && dst4 != nil {
+		pseudo := make([]byte, 0, 12)
+		pseudo = append(pseudo, src4...)
+		pseudo = append(pseudo, dst4...)
+		pseudo = append(pseudo, 0, protocolUDP, udp[4], udp[5])
+		binary.BigEndian.PutUint16(udp[6:], udpChecksum(pseudo, udp))
+
+		hdr := make([]byte, 20, 20+len(udp))
+		hdr[0] = 0x45
+		binary.BigEndian.PutUint16(hdr[2:], uint16(20+len(udp)))
+		// don't fragment
+		hdr[6] = 0x40
+		hdr[8] = ttl
+		hdr[9] = protocolUDP
+		copy(hdr[12:], src4)
+		copy(hdr[16:], dst4)
+		binary.BigEndian.PutUint16(hdr[10:], checksum(0, hdr))
+
+		return append(hdr, udp...)
+	}
+
+	src16, dst16 := to16(src), to16(dst)
+
+	pseudo := make([]byte, 0, 40)
+	pseudo = append(pseudo, src16...)
+	pseudo = append(pseudo, dst16...)
+	pseudo = append(pseudo, 0, 0, udp[4], udp[5], 0, 0, 0, protocolUDP)
+	binary.BigEndian.PutUint16(udp[6:], udpChecksum(pseudo, udp))
+
+	hdr := make([]byte, 40, 40+len(udp))
+	hdr[0] = 0x60
+	binary.BigEndian.PutUint16(hdr[4:], uint16(len(udp)))
+	hdr[6] = protocolUDP
+	hdr[7] = ttl
+	copy(hdr[8:], src16)
+	copy(hdr[24:], dst16)
+
+	return append(hdr, udp...)
+}
+
+func to16(ip net.IP) net.IP {
+	if ip16 := ip.To16(); ip16 != nil {
+		return ip16
+	}
+
+	return net.IPv6zero
+}
+
+// udpChecksum computes the checksum over the pseudo header and the datagram,
+// a zero result is sent as all ones (RFC768).
+func udpChecksum(pseudo []byte, udp []byte) uint16 {
+	sum := checksum(0, pseudo)
+	sum = checksum(^sum, udp)
+	if sum == 0 {
+		return 0xFFFF
+	}
+
+	return sum
+}
+
+// checksum continues the internet checksum (RFC1071) of initial over data.
+func checksum(initial uint16, data []byte) uint16 {
+	sum := uint32(initial)
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(data[i])<<8 | uint32(data[i+1])
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+
+	for sum > 0xFFFF {
+		sum = sum&0xFFFF + sum>>16
+	}
+
+	return ^uint16(sum)
+}
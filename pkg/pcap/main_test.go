@@ -0,0 +1,91 @@
+package pcap_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/pcap"
+)
+
+// onesComplementSum folds data the way receivers verify a checksum, a valid
+// checksum makes it 0xFFFF.
+func onesComplementSum(data []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum > 0xFFFF {
+		sum = sum&0xFFFF + sum>>16
+	}
+
+	return uint16(sum)
+}
+
+func TestWriter(t *testing.T) {
+	msg := []byte{0x12, 0x34, 1, 0, 0, 1, 0, 0, 0, 0, 0}
+	ts := time.Unix(1700000000, 5000)
+
+	t.Run("writes_file_header", func(t *testing.T) {
+		var out bytes.Buffer
+		_, err := pcap.NewWriter(&out)
+		NoError(t, err)
+
+		Equal(t, 24, out.Len())
+		Equal(t, uint32(0xa1b2c3d4), binary.LittleEndian.Uint32(out.Bytes()[0:]))
+		Equal(t, uint32(101), binary.LittleEndian.Uint32(out.Bytes()[20:]))
+	})
+
+	t.Run("ipv4_datagram", func(t *testing.T) {
+		var out bytes.Buffer
+		w, err := pcap.NewWriter(&out)
+		NoError(t, err)
+
+		src := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5353}
+		dst := &net.UDPAddr{IP: net.ParseIP("198.41.0.4"), Port: 53}
+		NoError(t, w.WriteUDP(ts, src, dst, msg))
+
+		record := out.Bytes()[24:]
+		Equal(t, uint32(1700000000), binary.LittleEndian.Uint32(record[0:]))
+		Equal(t, uint32(5), binary.LittleEndian.Uint32(record[4:]))
+		Equal(t, uint32(20+8+len(msg)), binary.LittleEndian.Uint32(record[8:]))
+
+		packet := record[16:]
+		Equal(t, byte(0x45), packet[0])
+		Equal(t, uint16(0xFFFF), onesComplementSum(packet[:20]))
+		Equal(t, []byte(net.ParseIP("198.41.0.4").To4()), packet[16:20])
+		Equal(t, uint16(5353), binary.BigEndian.Uint16(packet[20:]))
+		Equal(t, uint16(53), binary.BigEndian.Uint16(packet[22:]))
+		Equal(t, msg, packet[28:])
+
+		pseudo := append(append([]byte{}, packet[12:20]...), 0, 17, packet[24], packet[25])
+		Equal(t, uint16(0xFFFF), onesComplementSum(append(pseudo, packet[20:]...)))
+	})
+
+	t.Run("ipv6_datagram", func(t *testing.T) {
+		var out bytes.Buffer
+		w, err := pcap.NewWriter(&out)
+		NoError(t, err)
+
+		src := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 40000}
+		dst := &net.UDPAddr{IP: net.ParseIP("2001:503:ba3e::2:30"), Port: 53}
+		NoError(t, w.WriteUDP(ts, src, dst, msg))
+
+		packet := out.Bytes()[24+16:]
+		Equal(t, byte(0x60), packet[0])
+		Equal(t, uint16(8+len(msg)), binary.BigEndian.Uint16(packet[4:]))
+		Equal(t, byte(17), packet[6])
+		Equal(t, []byte(net.ParseIP("2001:db8::1")), packet[8:24])
+		Equal(t, msg, packet[48:])
+
+		pseudo := append(append([]byte{}, packet[8:40]...), 0, 0, packet[44], packet[45], 0, 0, 0, 17)
+		Equal(t, uint16(0xFFFF), onesComplementSum(append(pseudo, packet[40:]...)))
+	})
+}
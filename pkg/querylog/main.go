@@ -0,0 +1,215 @@
+// Package querylog stores the queries the server answered as JSON lines in a
+// file that is rotated by size and age, and searches the stored queries.
+package querylog
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// rotatedSuffix is the time format appended to the names of rotated files,
+// it sorts in the order the files were rotated.
+const rotatedSuffix = "20060102T150405.000000000"
+
+// Entry is a query and how it was answered.
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Client  string    `json:"client"`
+	Name    string    `json:"name"`
+	Type    string    `json:"type"`
+	ResCode string    `json:"rcode"`
+	Answers int       `json:"answers"`
+	// Elapsed is how long answering took in microseconds
+	Elapsed int64 `json:"elapsed_us"`
+}
+
+// Config says where the log is stored, when it is rotated and what it may
+// store. A zero MaxSize or MaxAge doesn't rotate by that, a zero MaxBackups
+// keeps every rotated file.
+type Config struct {
+	Path       string
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+	// Retention removes rotated files once they were rotated this long ago,
+	// the file is rotated at least as often so that no query is kept much
+	// longer than twice Retention
+	Retention time.Duration
+	// Clients is how client addresses are stored
+	Clients ClientPrivacy
+	// Sensitive queries for these domains and the names below them are
+	// stored without their name
+	Sensitive Suffixes
+}
+
+// Log appends entries to the file at the configured path. It is safe for
+// concurrent use.
+type Log struct {
+	config  Config
+	hashKey []byte
+
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	created time.Time
+}
+
+// Open opens the log for appending, creating it when it doesn't exist.
+func Open(cfg Config) (*Log, error) {
+	l := &Log{config: cfg}
+	if cfg.Clients == ClientsHashed {
+		key, err := newHashKey()
+		if err != nil {
+			return nil, err
+		}
+		l.hashKey = key
+	}
+
+	if err := l.open(time.Now()); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (l *Log) open(now time.Time) error {
+	f, err := os.OpenFile(l.config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrap(err, "opening query log")
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return errors.Wrap(err, "opening query log")
+	}
+
+	l.f, l.size, l.created = f, info.Size(), now
+	if info.Size() > 0 {
+		// Appending to a file from before a restart, its age counts
+		l.created = info.ModTime()
+	}
+
+	return nil
+}
+
+// Write appends the entry, anonymized as configured, rotating the file first
+// when it grew past MaxSize or is older than MaxAge.
+func (l *Log) Write(e *Entry) error {
+	stored := *e
+	l.anonymize(&stored)
+
+	line, err := json.Marshal(&stored)
+	if err != nil {
+		return errors.Wrap(err, "encoding query log entry")
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil {
+		return errors.New("query log is closed")
+	}
+
+	if l.size > 0 && l.due(int64(len(line)), e.Time) {
+		if err := l.rotate(e.Time); err != nil {
+			return err
+		}
+	}
+
+	n, err := l.f.Write(line)
+	l.size += int64(n)
+
+	return errors.Wrap(err, "writing query log")
+}
+
+func (l *Log) due(size int64, now time.Time) bool {
+	if l.config.MaxSize > 0 && l.size+size > l.config.MaxSize {
+		return true
+	}
+
+	maxAge := l.config.MaxAge
+	if l.config.Retention > 0 && (maxAge <= 0 || maxAge > l.config.Retention) {
+		maxAge = l.config.Retention
+	}
+
+	return maxAge > 0 && now.Sub(l.created) >= maxAge
+}
+
+// rotate renames the current file after the time it is rotated, removes the
+// oldest rotated files beyond MaxBackups and the expired ones and starts a
+// new file.
+func (l *Log) rotate(now time.Time) error {
+	if err := l.f.Close(); err != nil {
+		return errors.Wrap(err, "closing query log")
+	}
+	l.f = nil
+
+	if err := os.Rename(l.config.Path, l.config.Path+"."+now.UTC().Format(rotatedSuffix)); err != nil {
+		// Keep appending to the file rather than losing the queries
+		if err := l.open(now); err != nil {
+			return err
+		}
+		return errors.Wrap(err, "rotating query log")
+	}
+
+	if l.config.MaxBackups > 0 {
+		rotated, err := Rotated(l.config.Path)
+		if err != nil {
+			return err
+		}
+		for len(rotated) > l.config.MaxBackups {
+			if err := os.Remove(rotated[0]); err != nil {
+				return errors.Wrap(err, "removing rotated query log")
+			}
+			rotated = rotated[1:]
+		}
+	}
+
+	if err := l.expire(now); err != nil {
+		return err
+	}
+
+	return l.open(now)
+}
+
+// Close closes the file, later writes fail.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil {
+		return nil
+	}
+
+	err := l.f.Close()
+	l.f = nil
+
+	return err
+}
+
+// Rotated returns the rotated files of the log at path, oldest first.
+func Rotated(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, errors.Wrap(err, "listing rotated query logs")
+	}
+
+	rotated := matches[:0]
+	for _, m := range matches {
+		if _, err := time.Parse(rotatedSuffix, strings.TrimPrefix(m, path+".")); err == nil {
+			rotated = append(rotated, m)
+		}
+	}
+	sort.Strings(rotated)
+
+	return rotated, nil
+}
@@ -0,0 +1,209 @@
+package querylog_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/querylog"
+)
+
+func entry(at time.Time, client string, name string) *querylog.Entry {
+	return &querylog.Entry{Time: at, Client: client, Name: name, Type: "A", ResCode: "NOERROR", Answers: 1}
+}
+
+func TestLog(t *testing.T) {
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("rotates_by_size_and_keeps_backups", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "queries.log")
+		l, err := querylog.Open(querylog.Config{Path: path, MaxSize: 300, MaxBackups: 2})
+		NoError(t, err)
+		defer l.Close()
+
+		for i := 0; i < 20; i++ {
+			NoError(t, l.Write(entry(start.Add(time.Duration(i)*time.Second), "192.0.2.1", fmt.Sprintf("host%d.example.com", i))))
+		}
+
+		rotated, err := querylog.Rotated(path)
+		NoError(t, err)
+		Len(t, rotated, 2)
+
+		for _, file := range append(rotated, path) {
+			data, err := ioutil.ReadFile(file)
+			NoError(t, err)
+			LessOrEqual(t, len(data), 300, file)
+		}
+
+		// The oldest queries were removed with their files
+		entries, err := querylog.Tail(path, &querylog.Filter{}, 100)
+		NoError(t, err)
+		if NotEmpty(t, entries) {
+			Less(t, len(entries), 20)
+			Equal(t, "host19.example.com", entries[len(entries)-1].Name)
+		}
+	})
+
+	t.Run("rotates_by_age", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "queries.log")
+		l, err := querylog.Open(querylog.Config{Path: path, MaxAge: time.Hour})
+		NoError(t, err)
+		defer l.Close()
+
+		now := time.Now()
+		NoError(t, l.Write(entry(now, "192.0.2.1", "a.example.com")))
+		NoError(t, l.Write(entry(now.Add(30*time.Minute), "192.0.2.1", "b.example.com")))
+		rotated, _ := querylog.Rotated(path)
+		Empty(t, rotated)
+
+		NoError(t, l.Write(entry(now.Add(2*time.Hour), "192.0.2.1", "c.example.com")))
+		rotated, _ = querylog.Rotated(path)
+		Len(t, rotated, 1)
+	})
+
+	t.Run("search_filters", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "queries.log")
+		l, err := querylog.Open(querylog.Config{Path: path, MaxSize: 400})
+		NoError(t, err)
+		NoError(t, l.Write(entry(start, "192.0.2.1", "www.example.com")))
+		NoError(t, l.Write(entry(start.Add(time.Minute), "192.0.2.2", "example.com")))
+		NoError(t, l.Write(entry(start.Add(2*time.Minute), "198.51.100.1", "notexample.com")))
+		NoError(t, l.Write(entry(start.Add(3*time.Minute), "192.0.2.1", "api.example.com")))
+		NoError(t, l.Close())
+
+		names := func(filter *querylog.Filter) []string {
+			var found []string
+			NoError(t, querylog.Search(path, filter, func(e *querylog.Entry) error {
+				found = append(found, e.Name)
+				return nil
+			}))
+			return found
+		}
+
+		Equal(t, []string{"www.example.com", "example.com", "api.example.com"}, names(&querylog.Filter{Name: "Example.com."}))
+		Equal(t, []string{"www.example.com", "api.example.com"}, names(&querylog.Filter{Client: "192.0.2.1"}))
+		Equal(t, []string{"www.example.com", "example.com", "api.example.com"}, names(&querylog.Filter{Client: "192.0.2.0/24"}))
+		Equal(t, []string{"notexample.com", "api.example.com"}, names(&querylog.Filter{Since: start.Add(2 * time.Minute)}))
+
+		entries, err := querylog.Tail(path, &querylog.Filter{}, 2)
+		NoError(t, err)
+		if Len(t, entries, 2) {
+			Equal(t, "notexample.com", entries[0].Name)
+		}
+	})
+
+	t.Run("follow_new_queries", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "queries.log")
+		l, err := querylog.Open(querylog.Config{Path: path})
+		NoError(t, err)
+		defer l.Close()
+		NoError(t, l.Write(entry(start, "192.0.2.1", "old.example.com")))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		followed := make(chan string, 10)
+		done := make(chan error)
+		go func() {
+			done <- querylog.Follow(ctx, path, &querylog.Filter{Name: "example.com"}, 10*time.Millisecond, func(e *querylog.Entry) error {
+				followed <- e.Name
+				return nil
+			})
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		NoError(t, l.Write(entry(start, "192.0.2.1", "other.example.net")))
+		NoError(t, l.Write(entry(start, "192.0.2.1", "new.example.com")))
+
+		select {
+		case name := <-followed:
+			Equal(t, "new.example.com", name)
+		case <-time.After(5 * time.Second):
+			t.Error("new query wasn't followed")
+		}
+
+		cancel()
+		NoError(t, <-done)
+	})
+}
+
+func TestPrivacy(t *testing.T) {
+	now := time.Now()
+
+	write := func(t *testing.T, cfg querylog.Config, entries ...*querylog.Entry) []*querylog.Entry {
+		cfg.Path = filepath.Join(t.TempDir(), "queries.log")
+		l, err := querylog.Open(cfg)
+		NoError(t, err)
+		for _, e := range entries {
+			NoError(t, l.Write(e))
+		}
+		NoError(t, l.Close())
+
+		stored, err := querylog.Tail(cfg.Path, &querylog.Filter{}, 100)
+		NoError(t, err)
+		return stored
+	}
+
+	t.Run("truncate_clients", func(t *testing.T) {
+		stored := write(t, querylog.Config{Clients: querylog.ClientsTruncated},
+			entry(now, "192.0.2.77", "www.example.com"),
+			entry(now, "2001:db8:1:2::7", "www.example.com"))
+		if Len(t, stored, 2) {
+			Equal(t, "192.0.2.0/24", stored[0].Client)
+			Equal(t, "2001:db8:1::/48", stored[1].Client)
+			True(t, (&querylog.Filter{Client: "192.0.2.10"}).Match(stored[0]))
+			False(t, (&querylog.Filter{Client: "198.51.100.10"}).Match(stored[0]))
+		}
+	})
+
+	t.Run("hash_clients", func(t *testing.T) {
+		stored := write(t, querylog.Config{Clients: querylog.ClientsHashed},
+			entry(now, "192.0.2.77", "www.example.com"),
+			entry(now, "192.0.2.77", "api.example.com"),
+			entry(now, "192.0.2.78", "www.example.com"))
+		if Len(t, stored, 3) {
+			NotContains(t, stored[0].Client, "192.0.2")
+			Equal(t, stored[0].Client, stored[1].Client)
+			NotEqual(t, stored[0].Client, stored[2].Client)
+		}
+	})
+
+	t.Run("drop_sensitive_names", func(t *testing.T) {
+		var sensitive querylog.Suffixes
+		NoError(t, sensitive.Set("Health.example."))
+		Error(t, sensitive.Set("."))
+
+		stored := write(t, querylog.Config{Sensitive: sensitive},
+			entry(now, "192.0.2.1", "clinic.health.example"),
+			entry(now, "192.0.2.1", "www.example.com"))
+		if Len(t, stored, 2) {
+			Empty(t, stored[0].Name)
+			Equal(t, "192.0.2.1", stored[0].Client)
+			Equal(t, "www.example.com", stored[1].Name)
+		}
+	})
+
+	t.Run("retention_removes_old_queries", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "queries.log")
+		l, err := querylog.Open(querylog.Config{Path: path, Retention: time.Hour})
+		NoError(t, err)
+		defer l.Close()
+
+		NoError(t, l.Write(entry(now, "192.0.2.1", "a.example.com")))
+		NoError(t, l.Prune(now.Add(30*time.Minute)))
+		rotated, _ := querylog.Rotated(path)
+		Empty(t, rotated)
+
+		// Rotated once an hour old, removed an hour after that
+		NoError(t, l.Prune(now.Add(61*time.Minute)))
+		rotated, _ = querylog.Rotated(path)
+		Len(t, rotated, 1)
+
+		NoError(t, l.Prune(now.Add(122*time.Minute)))
+		rotated, _ = querylog.Rotated(path)
+		Empty(t, rotated)
+	})
+}
@@ -0,0 +1,185 @@
+package querylog
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/msarvar/godns/pkg/logger"
+	"github.com/pkg/errors"
+)
+
+// ClientPrivacy is how the addresses of clients are stored.
+type ClientPrivacy int
+
+const (
+	// ClientsKept stores addresses as they are
+	ClientsKept ClientPrivacy = iota
+	// ClientsTruncated stores the /24 network of IPv4 addresses and the /48
+	// of IPv6 addresses
+	ClientsTruncated
+	// ClientsHashed stores a keyed hash of the address, the same client gets
+	// the same hash until the server restarts
+	ClientsHashed
+)
+
+func (p ClientPrivacy) String() string {
+	switch p {
+	case ClientsTruncated:
+		return "truncate"
+	case ClientsHashed:
+		return "hash"
+	default:
+		return "keep"
+	}
+}
+
+// Set implements flag.Value so the privacy can be passed on the command
+// line.
+func (p *ClientPrivacy) Set(value string) error {
+	switch value {
+	case "keep":
+		*p = ClientsKept
+	case "truncate":
+		*p = ClientsTruncated
+	case "hash":
+		*p = ClientsHashed
+	default:
+		return errors.Errorf("unknown client privacy %q", value)
+	}
+
+	return nil
+}
+
+// Suffixes implements flag.Value, every use of the flag adds a domain.
+type Suffixes []string
+
+func (ss *Suffixes) String() string {
+	return strings.Join(*ss, ",")
+}
+
+func (ss *Suffixes) Set(value string) error {
+	suffix := strings.ToLower(strings.Trim(value, "."))
+	if suffix == "" {
+		return errors.Errorf("invalid domain %q", value)
+	}
+
+	*ss = append(*ss, suffix)
+	return nil
+}
+
+// contains reports whether name is one of the domains or below it.
+func (ss Suffixes) contains(name string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for _, s := range ss {
+		if name == s || strings.HasSuffix(name, "."+s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newHashKey returns the random key client addresses are hashed with.
+func newHashKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, errors.Wrap(err, "generating query log hash key")
+	}
+
+	return key, nil
+}
+
+// anonymize removes from the entry what the config doesn't allow to store.
+func (l *Log) anonymize(e *Entry) {
+	if l.config.Sensitive.contains(e.Name) {
+		e.Name = ""
+	}
+
+	switch l.config.Clients {
+	case ClientsTruncated:
+		e.Client = truncateClient(e.Client)
+	case ClientsHashed:
+		mac := hmac.New(sha256.New, l.hashKey)
+		mac.Write([]byte(e.Client))
+		e.Client = hex.EncodeToString(mac.Sum(nil)[:8])
+	}
+}
+
+func truncateClient(client string) string {
+	ip := net.ParseIP(client)
+	if ip == nil {
+		return ""
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+
+	return ip.Mask(net.CIDRMask(48, 128)).String() + "/48"
+}
+
+// Prune rotates the file when it is due and removes the rotated files that
+// were rotated longer than Retention ago. Writing prunes as well, but a log
+// without queries must still lose its old ones.
+func (l *Log) Prune(now time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil {
+		return errors.New("query log is closed")
+	}
+
+	if l.size > 0 && l.due(0, now) {
+		return l.rotate(now)
+	}
+
+	return l.expire(now)
+}
+
+// Maintain prunes the log every interval until ctx is cancelled.
+func (l *Log) Maintain(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := l.Prune(time.Now()); err != nil {
+				logger.Errorf("Error: pruning query log: %s\n", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// expire removes the rotated files older than Retention.
+func (l *Log) expire(now time.Time) error {
+	if l.config.Retention <= 0 {
+		return nil
+	}
+
+	rotated, err := Rotated(l.config.Path)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range rotated {
+		at, err := time.Parse(rotatedSuffix, strings.TrimPrefix(file, l.config.Path+"."))
+		if err != nil || now.Sub(at) < l.config.Retention {
+			continue
+		}
+		if err := os.Remove(file); err != nil {
+			return errors.Wrap(err, "removing expired query log")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,180 @@
+package querylog
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Filter picks the entries searched for, its zero value matches every entry.
+type Filter struct {
+	// Name matches the name and the names below it
+	Name string
+	// Client is an address or a network in CIDR notation
+	Client string
+	// Since skips older entries
+	Since time.Time
+}
+
+// Match reports whether the entry passes the filter.
+func (f *Filter) Match(e *Entry) bool {
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+
+	if f.Name != "" {
+		name := strings.ToLower(strings.TrimSuffix(e.Name, "."))
+		want := strings.ToLower(strings.TrimSuffix(f.Name, "."))
+		if name != want && !strings.HasSuffix(name, "."+want) {
+			return false
+		}
+	}
+
+	if f.Client != "" && f.Client != e.Client && !matchClient(f.Client, e.Client) {
+		return false
+	}
+
+	return true
+}
+
+// matchClient reports whether the client of an entry, an address or the
+// network it was truncated to, is in the network wanted or has the address
+// wanted.
+func matchClient(want string, client string) bool {
+	if _, network, err := net.ParseCIDR(want); err == nil {
+		return network.Contains(net.ParseIP(strings.SplitN(client, "/", 2)[0]))
+	}
+
+	if _, network, err := net.ParseCIDR(client); err == nil {
+		return network.Contains(net.ParseIP(want))
+	}
+
+	return false
+}
+
+// Search calls fn with the entries of the log at path and of its rotated
+// files passing the filter, oldest first. Lines that aren't entries are
+// skipped.
+func Search(path string, filter *Filter, fn func(*Entry) error) error {
+	files, err := Rotated(path)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range append(files, path) {
+		f, err := os.Open(file)
+		if err != nil {
+			// Rotated or removed while searching
+			if os.IsNotExist(err) {
+				continue
+			}
+			return errors.Wrap(err, "opening query log")
+		}
+
+		_, err = scan(f, filter, fn)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Tail returns the last n entries passing the filter.
+func Tail(path string, filter *Filter, n int) ([]*Entry, error) {
+	var entries []*Entry
+	err := Search(path, filter, func(e *Entry) error {
+		entries = append(entries, e)
+		if len(entries) > n {
+			entries = entries[1:]
+		}
+		return nil
+	})
+
+	return entries, err
+}
+
+// Follow calls fn with the entries passing the filter as they are appended
+// to the log at path until ctx is cancelled, checking for them every
+// interval. It starts at the end of the file and follows it across
+// rotations.
+func Follow(ctx context.Context, path string, filter *Filter, interval time.Duration, fn func(*Entry) error) error {
+	var offset int64
+	if info, err := os.Stat(path); err == nil {
+		offset = info.Size()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		// A smaller file was rotated in its place
+		if info.Size() < offset {
+			offset = 0
+		}
+		if info.Size() == offset {
+			continue
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return errors.Wrap(err, "reading query log")
+		}
+
+		read, err := scan(f, filter, fn)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		offset += read
+	}
+}
+
+// scan calls fn with the complete lines of r that are entries passing the
+// filter and returns how many bytes those lines took.
+func scan(r io.Reader, filter *Filter, fn func(*Entry) error) (int64, error) {
+	var read int64
+
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// A line still being written is read next time
+			return read, nil
+		}
+		if err != nil {
+			return read, errors.Wrap(err, "reading query log")
+		}
+		read += int64(len(line))
+
+		var e Entry
+		if json.Unmarshal(line, &e) != nil || !filter.Match(&e) {
+			continue
+		}
+		if err := fn(&e); err != nil {
+			return read, err
+		}
+	}
+}
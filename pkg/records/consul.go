@@ -0,0 +1,204 @@
+package records
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/pkg/errors"
+)
+
+// consulWait is how long a blocking query waits for the services to change.
+// Health changes don't change the service list and show up after it.
+const consulWait = 30 * time.Second
+
+// Consul lists the healthy instances of the services registered in Consul
+// like its DNS interface names them under domain:
+//
+//	web.service.consul A, AAAA and SRV records of every instance
+//	v2.web.service.consul records of the instances tagged v2
+//	node1.node.consul the address of a node, the target of the SRV records
+//
+// The metadata of the instances is served as TXT records of the service.
+type Consul struct {
+	base   string
+	domain string
+	client *http.Client
+	// index of the last service list, for the blocking query of Wait
+	index string
+}
+
+// NewConsul returns the services of the Consul agent at address, an http
+// URL like http://127.0.0.1:8500.
+func NewConsul(address string, domain string) (*Consul, error) {
+	u, err := url.Parse(address)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.Errorf("consul address %q is not an http URL", address)
+	}
+
+	return &Consul{
+		base:   strings.TrimSuffix(address, "/"),
+		domain: buffer.NewDomainName(domain).Normalized(),
+		client: &http.Client{},
+	}, nil
+}
+
+// consulInstance is what the health endpoint returns of a service instance.
+type consulInstance struct {
+	Node struct {
+		Node    string
+		Address string
+	}
+	Service struct {
+		Service string
+		Address string
+		Port    uint16
+		Tags    []string
+		Meta    map[string]string
+	}
+}
+
+func (c *Consul) Records(ctx context.Context) ([]*dns.DNSRecord, error) {
+	var services map[string][]string
+	index, err := c.get(ctx, "/v1/catalog/services", &services)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing consul services")
+	}
+	c.index = index
+
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b builder
+	nodes := make(map[string]bool)
+	for _, name := range names {
+		var instances []consulInstance
+		if _, err := c.get(ctx, "/v1/health/service/"+url.PathEscape(name)+"?passing=1", &instances); err != nil {
+			return nil, errors.Wrapf(err, "listing instances of consul service %s", name)
+		}
+
+		service := strings.ToLower(name) + ".service." + c.domain
+		meta := make(map[string]bool)
+		for _, instance := range instances {
+			address := instance.Service.Address
+			if address == "" {
+				address = instance.Node.Address
+			}
+			ip := net.ParseIP(address)
+			if ip == nil {
+				continue
+			}
+
+			owners := []string{service}
+			for _, tag := range instance.Service.Tags {
+				owners = append(owners, strings.ToLower(tag)+"."+service)
+			}
+
+			node := strings.ToLower(instance.Node.Node) + ".node." + c.domain
+			for _, owner := range owners {
+				b.add(addressRecord(owner, ip, TTL))
+				b.add(dns.NewSRVRecord(owner, dns.SRV{Priority: 1, Weight: 1, Port: instance.Service.Port, Target: node}, TTL))
+			}
+			if !nodes[node] {
+				nodes[node] = true
+				b.add(addressRecord(node, net.ParseIP(instance.Node.Address), TTL))
+			}
+
+			for key, value := range instance.Service.Meta {
+				meta[key+"="+value] = true
+			}
+		}
+
+		texts := make([]string, 0, len(meta))
+		for text := range meta {
+			texts = append(texts, text)
+		}
+		sort.Strings(texts)
+		for _, text := range texts {
+			b.add(dns.NewTXTRecord(service, TTL, text))
+		}
+	}
+
+	return b.records, nil
+}
+
+// Wait blocks until the service list changes or consulWait passes.
+func (c *Consul) Wait(ctx context.Context) error {
+	if c.index == "" {
+		return nil
+	}
+
+	// Consul adds up to a 16th of the wait as jitter
+	ctx, cancel := context.WithTimeout(ctx, consulWait+consulWait/16+10*time.Second)
+	defer cancel()
+
+	path := fmt.Sprintf("/v1/catalog/services?index=%s&wait=%ds", url.QueryEscape(c.index), int(consulWait.Seconds()))
+	_, err := c.get(ctx, path, new(map[string][]string))
+	return errors.Wrap(err, "waiting for consul services")
+}
+
+// get decodes the JSON response to path into v and returns the index of the
+// data for blocking queries.
+func (c *Consul) get(ctx context.Context, path string, v interface{}) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+path, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("consul answered %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return "", errors.Wrap(err, "decoding consul response")
+	}
+
+	index := resp.Header.Get("X-Consul-Index")
+	if _, err := strconv.ParseUint(index, 10, 64); err != nil {
+		index = ""
+	}
+
+	return index, nil
+}
+
+// addressRecord returns the A or AAAA record of ip.
+func addressRecord(name string, ip net.IP, ttl uint32) (*dns.DNSRecord, error) {
+	switch {
+	case ip == nil:
+		return nil, errors.Errorf("%s has no address", name)
+	case ip.To4() != nil:
+		return dns.NewARecord(name, ip.To4(), ttl)
+	default:
+		return dns.NewAAAARecord(name, ip, ttl)
+	}
+}
+
+// builder collects the records built from registry entries.
+type builder struct {
+	records []*dns.DNSRecord
+}
+
+// add adds the record unless building it failed, registries hold names and
+// values that don't make valid records.
+func (b *builder) add(r *dns.DNSRecord, err error) {
+	if err == nil {
+		b.records = append(b.records, r)
+	}
+}
@@ -0,0 +1,221 @@
+package records
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/pkg/errors"
+)
+
+// Etcd lists the records stored under a prefix of etcd in the SkyDNS layout
+// CoreDNS uses too: the key /skydns/com/example/www holds the records of
+// www.example.com as JSON like
+//
+//	{"host": "10.0.0.1", "port": 8080, "text": "v=1", "ttl": 30}
+//
+// host is served as an A or AAAA record or, when it is a name, as the target
+// of the SRV record a port adds. It talks to the JSON gateway of the etcd v3
+// API.
+type Etcd struct {
+	base   string
+	prefix string
+	client *http.Client
+	// revision of the last listing, Wait watches for later changes
+	revision int64
+}
+
+// NewEtcd returns the records under prefix, e.g. /skydns, of the etcd
+// server at address, an http URL like http://127.0.0.1:2379.
+func NewEtcd(address string, prefix string) (*Etcd, error) {
+	u, err := url.Parse(address)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.Errorf("etcd address %q is not an http URL", address)
+	}
+
+	return &Etcd{
+		base:   strings.TrimSuffix(address, "/"),
+		prefix: "/" + strings.Trim(prefix, "/") + "/",
+		client: &http.Client{},
+	}, nil
+}
+
+// etcdValue is the JSON stored at a key.
+type etcdValue struct {
+	Host     string `json:"host"`
+	Port     uint16 `json:"port"`
+	Priority uint16 `json:"priority"`
+	Weight   uint16 `json:"weight"`
+	Text     string `json:"text"`
+	TTL      uint32 `json:"ttl"`
+}
+
+// etcdRange is the request of a range of keys, etcd wants them base64
+// encoded.
+type etcdRange struct {
+	Key           string `json:"key"`
+	RangeEnd      string `json:"range_end"`
+	StartRevision int64  `json:"start_revision,string,omitempty"`
+}
+
+// etcdHeader is part of every response, int64s are JSON strings.
+type etcdHeader struct {
+	Revision int64 `json:"revision,string"`
+}
+
+func (e *Etcd) Records(ctx context.Context) ([]*dns.DNSRecord, error) {
+	var response struct {
+		Header etcdHeader
+		Kvs    []struct {
+			Key   string
+			Value string
+		}
+	}
+	if err := e.post(ctx, "/v3/kv/range", e.keyRange(0), func(d *json.Decoder) error {
+		return d.Decode(&response)
+	}); err != nil {
+		return nil, errors.Wrap(err, "listing etcd keys")
+	}
+	e.revision = response.Header.Revision
+
+	var b builder
+	for _, kv := range response.Kvs {
+		key, err := base64.StdEncoding.DecodeString(kv.Key)
+		if err != nil {
+			continue
+		}
+		raw, err := base64.StdEncoding.DecodeString(kv.Value)
+		if err != nil {
+			continue
+		}
+
+		var value etcdValue
+		if err := json.Unmarshal(raw, &value); err != nil {
+			continue
+		}
+		if value.TTL == 0 {
+			value.TTL = TTL
+		}
+
+		name := etcdName(strings.TrimPrefix(string(key), e.prefix))
+		if name == "" {
+			continue
+		}
+
+		ip := net.ParseIP(value.Host)
+		if ip != nil {
+			b.add(addressRecord(name, ip, value.TTL))
+		}
+		if value.Port != 0 {
+			target := value.Host
+			if ip != nil || target == "" {
+				target = name
+			}
+			srv := dns.SRV{Priority: value.Priority, Weight: value.Weight, Port: value.Port, Target: target}
+			b.add(dns.NewSRVRecord(name, srv, value.TTL))
+		}
+		if value.Text != "" {
+			b.add(dns.NewTXTRecord(name, value.TTL, value.Text))
+		}
+	}
+
+	return b.records, nil
+}
+
+// etcdName turns the path below the prefix, the labels from the top, into a
+// domain name.
+func etcdName(path string) string {
+	labels := strings.Split(strings.Trim(path, "/"), "/")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+
+	return strings.ToLower(strings.Join(labels, "."))
+}
+
+// Wait watches the prefix for changes after the last listing and returns at
+// the first.
+func (e *Etcd) Wait(ctx context.Context) error {
+	if e.revision == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	watch := map[string]interface{}{"create_request": e.keyRange(e.revision + 1)}
+	err := e.post(ctx, "/v3/watch", watch, func(d *json.Decoder) error {
+		for {
+			var message struct {
+				Result struct {
+					Events       []json.RawMessage
+					Canceled     bool
+					CancelReason string `json:"cancel_reason"`
+				}
+				Error *struct {
+					Message string
+				}
+			}
+			if err := d.Decode(&message); err != nil {
+				return err
+			}
+			if message.Error != nil {
+				return errors.New(message.Error.Message)
+			}
+			// Watches of compacted revisions are canceled, listing
+			// again catches up
+			if message.Result.Canceled {
+				return errors.Errorf("watch canceled: %s", message.Result.CancelReason)
+			}
+			if len(message.Result.Events) > 0 {
+				return nil
+			}
+		}
+	})
+
+	return errors.Wrap(err, "watching etcd keys")
+}
+
+// keyRange is the range of every key under the prefix.
+func (e *Etcd) keyRange(revision int64) etcdRange {
+	end := []byte(e.prefix)
+	end[len(end)-1]++
+
+	return etcdRange{
+		Key:           base64.StdEncoding.EncodeToString([]byte(e.prefix)),
+		RangeEnd:      base64.StdEncoding.EncodeToString(end),
+		StartRevision: revision,
+	}
+}
+
+// post sends the request as JSON and lets read decode the response stream.
+func (e *Etcd) post(ctx context.Context, path string, request interface{}, read func(*json.Decoder) error) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.base+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("etcd answered %s", resp.Status)
+	}
+
+	return read(json.NewDecoder(resp.Body))
+}
@@ -0,0 +1,125 @@
+// Package records answers queries from records registered in a service
+// registry like Consul or etcd, following its changes.
+package records
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/logger"
+)
+
+// TTL is the TTL of registry records that don't give one, short since
+// services come and go.
+const TTL = 5
+
+// retryDelay is how long Watch waits after the registry failed.
+const retryDelay = 5 * time.Second
+
+// Source lists the records of a registry.
+type Source interface {
+	Records(ctx context.Context) ([]*dns.DNSRecord, error)
+	// Wait blocks until the records may have changed since they were last
+	// listed, or returns after a while when they didn't
+	Wait(ctx context.Context) error
+}
+
+// Records holds the records of a source. It is safe for concurrent use and
+// can be refreshed while queries are answered.
+type Records struct {
+	source Source
+
+	mu    sync.RWMutex
+	names map[string][]*dns.DNSRecord
+}
+
+// New returns no records, Refresh lists them from source.
+func New(source Source) *Records {
+	return &Records{
+		source: source,
+		names:  make(map[string][]*dns.DNSRecord),
+	}
+}
+
+// Refresh replaces the records with the ones the source lists now and
+// returns how many there are. The current records are kept when the source
+// fails.
+func (r *Records) Refresh(ctx context.Context) (int, error) {
+	records, err := r.source.Records(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	names := make(map[string][]*dns.DNSRecord)
+	for _, record := range records {
+		name := record.Domain.Normalized()
+		names[name] = append(names[name], record)
+	}
+
+	r.mu.Lock()
+	r.names = names
+	r.mu.Unlock()
+
+	return len(records), nil
+}
+
+// Lookup returns the records of name of the type and whether the registry
+// has the name at all.
+func (r *Records) Lookup(name string, qtype dns.QueryType) ([]*dns.DNSRecord, bool) {
+	r.mu.RLock()
+	records, ok := r.names[buffer.NewDomainName(name).Normalized()]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+
+	matching := make([]*dns.DNSRecord, 0, len(records))
+	for _, record := range records {
+		if record.QType == qtype || qtype == dns.ANYQueryType {
+			matching = append(matching, record)
+		}
+	}
+
+	return matching, true
+}
+
+// Len returns the number of records.
+func (r *Records) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	n := 0
+	for _, records := range r.names {
+		n += len(records)
+	}
+
+	return n
+}
+
+// Watch refreshes the records whenever the source reports a change until
+// ctx is cancelled. They are refreshed after failed waits too, so that the
+// source catches up with changes it missed.
+func (r *Records) Watch(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := r.source.Wait(ctx); err != nil && ctx.Err() == nil {
+			logger.Errorf("Error: watching records: %s\n", err)
+			r.sleep(ctx)
+		}
+
+		if _, err := r.Refresh(ctx); err != nil && ctx.Err() == nil {
+			logger.Errorf("Error: %s\n", err)
+			r.sleep(ctx)
+		}
+	}
+}
+
+// sleep waits retryDelay unless ctx is cancelled first.
+func (r *Records) sleep(ctx context.Context) {
+	select {
+	case <-time.After(retryDelay):
+	case <-ctx.Done():
+	}
+}
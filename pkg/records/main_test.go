@@ -0,0 +1,164 @@
+package records_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/dnstest"
+	"github.com/msarvar/godns/pkg/records"
+)
+
+func TestConsul(t *testing.T) {
+	index := make(chan string, 1)
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/catalog/services":
+			if r.URL.Query().Get("index") != "" {
+				w.Header().Set("X-Consul-Index", <-index)
+			} else {
+				w.Header().Set("X-Consul-Index", "7")
+			}
+			w.Write([]byte(`{"consul": [], "web": ["v2"]}`))
+		case "/v1/health/service/web":
+			Equal(t, "1", r.URL.Query().Get("passing"))
+			w.Write([]byte(`[
+			  {"Node": {"Node": "node1", "Address": "10.0.0.1"},
+			   "Service": {"Service": "web", "Address": "", "Port": 8080, "Tags": ["v2"], "Meta": {"version": "2"}}},
+			  {"Node": {"Node": "node2", "Address": "10.0.0.2"},
+			   "Service": {"Service": "web", "Address": "10.0.1.2", "Port": 8081, "Tags": null, "Meta": {}}}
+			]`))
+		case "/v1/health/service/consul":
+			w.Write([]byte(`[]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer agent.Close()
+
+	source, err := records.NewConsul(agent.URL, "consul")
+	NoError(t, err)
+	r := records.New(source)
+	_, err = r.Refresh(context.Background())
+	NoError(t, err)
+
+	t.Run("instances", func(t *testing.T) {
+		rs, ok := r.Lookup("web.service.consul", dns.AQueryType)
+		True(t, ok)
+		Equal(t, []string{"web.service.consul. 5 IN A 10.0.0.1", "web.service.consul. 5 IN A 10.0.1.2"}, dnstest.Texts(rs))
+
+		rs, _ = r.Lookup("web.service.consul", dns.SRVQueryType)
+		Len(t, rs, 2)
+		srv, err := rs[1].SRV()
+		NoError(t, err)
+		Equal(t, &dns.SRV{Priority: 1, Weight: 1, Port: 8081, Target: "node2.node.consul"}, srv)
+
+		rs, _ = r.Lookup("node2.node.consul", dns.AQueryType)
+		Equal(t, []string{"node2.node.consul. 5 IN A 10.0.0.2"}, dnstest.Texts(rs))
+	})
+
+	t.Run("tags_and_meta", func(t *testing.T) {
+		rs, _ := r.Lookup("v2.web.service.consul", dns.AQueryType)
+		Equal(t, []string{"v2.web.service.consul. 5 IN A 10.0.0.1"}, dnstest.Texts(rs))
+
+		rs, _ = r.Lookup("web.service.consul", dns.TXTQueryType)
+		Len(t, rs, 1)
+		txt, err := rs[0].TXT()
+		NoError(t, err)
+		Equal(t, []string{"version=2"}, txt)
+	})
+
+	t.Run("wait_blocks_on_index", func(t *testing.T) {
+		index <- "8"
+		NoError(t, source.Wait(context.Background()))
+	})
+
+	t.Run("invalid_address", func(t *testing.T) {
+		_, err := records.NewConsul("127.0.0.1:8500", "consul")
+		Error(t, err)
+	})
+}
+
+func TestEtcd(t *testing.T) {
+	b64 := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	kvs := map[string]string{
+		"/skydns/com/example/www":  `{"host": "10.0.0.1", "port": 443}`,
+		"/skydns/com/example/api":  `{"host": "api.internal.example.com", "port": 8443, "priority": 10, "text": "v=1", "ttl": 30}`,
+		"/skydns/com/example/junk": `not json`,
+	}
+	changed := make(chan bool, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request map[string]json.RawMessage
+		NoError(t, json.NewDecoder(r.Body).Decode(&request))
+
+		switch r.URL.Path {
+		case "/v3/kv/range":
+			Equal(t, `"`+b64("/skydns/")+`"`, string(request["key"]))
+			Equal(t, `"`+b64("/skydns0")+`"`, string(request["range_end"]))
+
+			items := make([]string, 0)
+			for k, v := range kvs {
+				items = append(items, fmt.Sprintf(`{"key": %q, "value": %q}`, b64(k), b64(v)))
+			}
+			fmt.Fprintf(w, `{"header": {"revision": "41"}, "kvs": [%s]}`, strings.Join(items, ","))
+		case "/v3/watch":
+			Contains(t, string(request["create_request"]), `"start_revision":"42"`)
+			w.Write([]byte(`{"result": {"header": {"revision": "41"}, "created": true}}`))
+			w.(http.Flusher).Flush()
+			<-changed
+			w.Write([]byte(`{"result": {"header": {"revision": "42"}, "events": [{"kv": {}}]}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	source, err := records.NewEtcd(server.URL, "/skydns/")
+	NoError(t, err)
+	r := records.New(source)
+	_, err = r.Refresh(context.Background())
+	NoError(t, err)
+
+	t.Run("skydns_layout", func(t *testing.T) {
+		rs, ok := r.Lookup("www.example.com", dns.AQueryType)
+		True(t, ok)
+		Equal(t, []string{"www.example.com. 5 IN A 10.0.0.1"}, dnstest.Texts(rs))
+
+		rs, _ = r.Lookup("www.example.com", dns.SRVQueryType)
+		srv, err := rs[0].SRV()
+		NoError(t, err)
+		Equal(t, "www.example.com", srv.Target)
+
+		rs, _ = r.Lookup("api.example.com", dns.SRVQueryType)
+		srv, err = rs[0].SRV()
+		NoError(t, err)
+		Equal(t, &dns.SRV{Priority: 10, Port: 8443, Target: "api.internal.example.com"}, srv)
+		Equal(t, uint32(30), rs[0].TTL)
+
+		_, ok = r.Lookup("junk.example.com", dns.AQueryType)
+		False(t, ok)
+	})
+
+	t.Run("wait_returns_on_change", func(t *testing.T) {
+		done := make(chan error, 1)
+		go func() { done <- source.Wait(context.Background()) }()
+
+		changed <- true
+		select {
+		case err := <-done:
+			NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("watch didn't return")
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package resolver
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/msarvar/godns/pkg/logger"
+)
+
+// capture saves a raw message exchanged with an upstream when a capture
+// directory is configured. The files are named after the time, the query id,
+// the server and the direction so an exchange sorts next to its reply, e.g.
+// 20240102T150405.000001234-1a2b-198.41.0.4-query.bin
+func (r *Resolver) capture(direction string, id uint16, server net.IP, msg []byte) {
+	if r.captureDir == "" {
+		return
+	}
+
+	name := fmt.Sprintf("%s-%04x-%s-%s.bin",
+		time.Now().UTC().Format("20060102T150405.000000000"),
+		id,
+		strings.ReplaceAll(server.String(), ":", "_"),
+		direction,
+	)
+
+	err := ioutil.WriteFile(filepath.Join(r.captureDir, name), msg, 0644)
+	if err != nil {
+		logger.Errorf("Error: capturing %s: %s\n", direction, err)
+	}
+}
+
+// captureMessage captures a message exchanged over UDP, the header tells
+// queries from responses.
+func (r *Resolver) captureMessage(src net.Addr, dst net.Addr, msg []byte) {
+	if len(msg) < 3 {
+		return
+	}
+
+	id := binary.BigEndian.Uint16(msg)
+	if msg[2]&0x80 == 0 {
+		if remote, ok := dst.(*net.UDPAddr); ok {
+			r.capture("query", id, remote.IP, msg)
+		}
+	} else if remote, ok := src.(*net.UDPAddr); ok {
+		r.capture("response", id, remote.IP, msg)
+	}
+
+	r.capturePcap(src, dst, msg)
+}
+
+// capturePcap appends the message to the pcap capture when one is configured.
+func (r *Resolver) capturePcap(src net.Addr, dst net.Addr, msg []byte) {
+	if r.pcap == nil {
+		return
+	}
+
+	if err := r.pcap.WriteUDP(time.Now(), src, dst, msg); err != nil {
+		logger.Errorf("Error: %s\n", err)
+	}
+}
@@ -0,0 +1,207 @@
+package resolver
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/msarvar/godns/pkg/cache"
+	"github.com/msarvar/godns/pkg/pcap"
+	"github.com/pkg/errors"
+)
+
+// AddressPreference selects the address family used to reach upstream name
+// servers when glue provides both A and AAAA addresses.
+type AddressPreference int
+
+const (
+	PreferIPv4 AddressPreference = iota
+	PreferIPv6
+	// DualStack alternates between both families
+	DualStack
+)
+
+func (p AddressPreference) String() string {
+	switch p {
+	case PreferIPv6:
+		return "prefer-v6"
+	case DualStack:
+		return "dual"
+	default:
+		return "prefer-v4"
+	}
+}
+
+// Set implements flag.Value so the preference can be passed on the command
+// line.
+func (p *AddressPreference) Set(value string) error {
+	switch value {
+	case "prefer-v4":
+		*p = PreferIPv4
+	case "prefer-v6":
+		*p = PreferIPv6
+	case "dual":
+		*p = DualStack
+	default:
+		return errors.Errorf("unknown address preference %q", value)
+	}
+
+	return nil
+}
+
+// Privacy decides what happens when the encrypted transport to a forwarder
+// can't be established or verified.
+type Privacy int
+
+const (
+	// PrivacyOpportunistic falls back to plain DNS with a warning
+	PrivacyOpportunistic Privacy = iota
+	// PrivacyStrict fails the query instead, forwarders without a verified
+	// encrypted transport aren't used at all
+	PrivacyStrict
+)
+
+func (p Privacy) String() string {
+	if p == PrivacyStrict {
+		return "strict"
+	}
+
+	return "opportunistic"
+}
+
+// Set implements flag.Value so the mode can be passed on the command line.
+func (p *Privacy) Set(value string) error {
+	switch value {
+	case "opportunistic":
+		*p = PrivacyOpportunistic
+	case "strict":
+		*p = PrivacyStrict
+	default:
+		return errors.Errorf("unknown privacy mode %q", value)
+	}
+
+	return nil
+}
+
+// Config holds the resolver settings.
+type Config struct {
+	AddressPreference AddressPreference
+	// CaptureDir enables saving every upstream query and response to
+	// timestamped files in the directory, meant for debugging
+	CaptureDir string
+	// Pcap records every upstream exchange when set
+	Pcap *pcap.Writer
+	// Cache keeps final responses, nil disables caching. Stale answers and
+	// prefetching need a cache implementing StaleCache and Prefetcher
+	Cache cache.Cache
+	// Forwarders are asked to resolve names instead of iterating from the
+	// root servers
+	Forwarders Forwarders
+	// Strategies override how names of their domains are resolved
+	Strategies Strategies
+	// Privacy is how forwarders fall back from their encrypted transport,
+	// in strict mode forwarders given as plain addresses are only used once
+	// discovery finds them an encrypted one
+	Privacy Privacy
+	// Exchanger carries the queries to name servers, UDP when nil
+	Exchanger UpstreamExchanger
+	// Faults degrade the UDP exchanges with name servers to test how
+	// resolution copes, they don't apply to a custom Exchanger
+	Faults Faults
+	// MaxParallel bounds how many questions ResolveMany resolves at the same
+	// time, zero means defaultMaxParallel
+	MaxParallel int
+	// MinTTL and MaxTTL bound the TTLs of upstream records, a zero MaxTTL
+	// leaves them uncapped
+	MinTTL time.Duration
+	MaxTTL time.Duration
+	// MaxUDPSize is the payload size advertised to name servers and the
+	// largest UDP response read, zero means 512 bytes
+	MaxUDPSize uint16
+}
+
+// Forwarder is a resolver names are forwarded to, reached over UDP unless it
+// was configured by a DNS stamp.
+type Forwarder struct {
+	*net.UDPAddr
+	// Stamp is set for forwarders given as a DNS stamp, queries take the
+	// transport it describes
+	Stamp *Stamp
+	// Source is where queries to the forwarder leave from, any local
+	// address when nil
+	Source *Source
+}
+
+// Forwarders implements flag.Value, every use of the flag adds a resolver
+// given as "IP", "IP:PORT" or a DNS stamp "sdns://...", followed by
+// "@SOURCE" to send its queries from a local IP address or interface.
+type Forwarders []*Forwarder
+
+func (fs *Forwarders) String() string {
+	addrs := make([]string, 0, len(*fs))
+	for _, f := range *fs {
+		addr := f.String()
+		if f.Stamp != nil {
+			addr = f.Stamp.String()
+		}
+		if f.Source != nil {
+			addr += "@" + f.Source.String()
+		}
+		addrs = append(addrs, addr)
+	}
+
+	return strings.Join(addrs, ",")
+}
+
+func (fs *Forwarders) Set(value string) error {
+	var source *Source
+	if i := strings.LastIndex(value, "@"); i >= 0 {
+		var err error
+		if source, err = ParseSource(value[i+1:]); err != nil {
+			return errors.Wrapf(err, "parsing forwarder %q", value)
+		}
+		value = value[:i]
+	}
+
+	if strings.HasPrefix(value, "sdns://") {
+		stamp, err := ParseStamp(value)
+		if err != nil {
+			return errors.Wrapf(err, "parsing forwarder %q", value)
+		}
+		if _, _, err := stamp.exchanger(); err != nil {
+			return errors.Wrapf(err, "parsing forwarder %q", value)
+		}
+
+		*fs = append(*fs, &Forwarder{UDPAddr: stamp.Addr, Stamp: stamp, Source: source})
+		return nil
+	}
+
+	addr, err := ParseForwarder(value)
+	if err != nil {
+		return err
+	}
+
+	*fs = append(*fs, &Forwarder{UDPAddr: addr, Source: source})
+	return nil
+}
+
+// ParseForwarder parses the address of a forwarder, the port defaults to 53.
+func ParseForwarder(value string) (*net.UDPAddr, error) {
+	host, port := value, "53"
+	if h, p, err := net.SplitHostPort(value); err == nil {
+		host, port = h, p
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, errors.Errorf("forwarder %q is not an IP address", value)
+	}
+
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing forwarder port of %q", value)
+	}
+
+	return &net.UDPAddr{IP: ip, Port: int(n)}, nil
+}
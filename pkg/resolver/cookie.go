@@ -0,0 +1,75 @@
+package resolver
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"net"
+	"sync"
+
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+// cookieJar derives the client cookies sent to upstream servers and remembers
+// the server cookies they hand out (RFC7873).
+type cookieJar struct {
+	secret []byte
+
+	mu      sync.Mutex
+	servers map[string][]byte
+}
+
+func newCookieJar() *cookieJar {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+
+	return &cookieJar{
+		secret:  secret,
+		servers: map[string][]byte{},
+	}
+}
+
+// upstreamCookie builds the cookie sent to an upstream server. The client
+// cookie is stable per server so that server cookies stay valid.
+func (j *cookieJar) upstreamCookie(server net.IP) *dns.Cookie {
+	mac := hmac.New(sha256.New, j.secret)
+	mac.Write(server.To16())
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return &dns.Cookie{
+		Client: mac.Sum(nil)[:dns.ClientCookieLength],
+		Server: j.servers[server.String()],
+	}
+}
+
+// checkUpstreamCookie verifies that the response echoes our client cookie and
+// stores the server cookie for the next query.
+func (j *cookieJar) checkUpstreamCookie(server net.IP, response *dns.DNSPacket) bool {
+	c, err := response.Cookie()
+	if err != nil {
+		return false
+	}
+
+	// Server doesn't implement cookies
+	if c == nil {
+		return true
+	}
+
+	sent := j.upstreamCookie(server)
+	if !bytes.Equal(sent.Client, c.Client) {
+		return false
+	}
+
+	if c.Server != nil {
+		j.mu.Lock()
+		j.servers[server.String()] = c.Server
+		j.mu.Unlock()
+	}
+
+	return true
+}
@@ -0,0 +1,181 @@
+package resolver
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/logger"
+	"github.com/msarvar/godns/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+// designatedName is asked for the encrypted resolvers a resolver designates
+// (RFC9462 4).
+const designatedName = "_dns.resolver.arpa"
+
+// designated is an encrypted endpoint of a resolver.
+type designated struct {
+	exchanger UpstreamExchanger
+	addr      string
+}
+
+// DiscoverDesignated asks every forwarder reached over plain DNS for the
+// encrypted resolvers it designates (RFC9462) and sends its queries to the
+// first one answering over DoT or DoH from then on. A designated resolver is
+// only used when its certificate is valid for the forwarder's IP address,
+// anyone on the path could answer the plain discovery query. In strict
+// privacy mode forwarders without one stay unused.
+func (r *Resolver) DiscoverDesignated(ctx context.Context) {
+	for _, f := range r.forwarders {
+		if f.Stamp != nil {
+			continue
+		}
+
+		d, err := r.discover(withSource(ctx, f.Source), f.UDPAddr)
+		if err != nil {
+			if r.privacy == PrivacyStrict {
+				logger.Errorf("Error: forwarder %s has no verified encrypted transport, strict privacy leaves it unused: %s\n", f, err)
+			} else {
+				logger.Errorf("Warning: forwarder %s stays on plain DNS: %s\n", f, err)
+			}
+			continue
+		}
+
+		r.transportsMu.Lock()
+		r.transports[f.String()] = r.encrypted(d.exchanger, d.addr, f.String())
+		r.transportsMu.Unlock()
+		logger.Infof("Forwarder %s upgraded to %s\n", f, d.addr)
+	}
+}
+
+// discover returns the first designated resolver of the forwarder that
+// answers, in the order of the SVCB priorities.
+func (r *Resolver) discover(ctx context.Context, forwarder *net.UDPAddr) (*designated, error) {
+	response, err := exchangeWith(ctx, r.exchanger, forwarder.String(), designatedName, dns.SVCBQueryType)
+	if err != nil {
+		return nil, errors.Wrap(err, "asking for designated resolvers")
+	}
+
+	records := make([]*dns.DNSRecord, 0, len(response.Answers))
+	for _, record := range response.Answers {
+		// Priority zero is AliasMode, which designated resolvers don't use
+		if record.QType == dns.SVCBQueryType && record.Priority > 0 {
+			records = append(records, record)
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Priority < records[j].Priority
+	})
+
+	err = errors.New("no designated resolver over DoT or DoH")
+	for _, record := range records {
+		for _, d := range r.designatedEndpoints(record, forwarder.IP) {
+			if _, err = exchangeWith(ctx, d.exchanger, d.addr, "", dns.NSQueryType); err == nil {
+				return d, nil
+			}
+			logger.Debugf("Designated resolver %s of %s failed: %s\n", d.addr, forwarder, err)
+		}
+	}
+
+	return nil, err
+}
+
+// designatedEndpoints returns the DoT and DoH endpoints a record describes.
+// Without address hints the forwarder's own address is tried, godns doesn't
+// resolve the target name.
+func (r *Resolver) designatedEndpoints(record *dns.DNSRecord, forwarder net.IP) []*designated {
+	target := record.Host.String()
+	if target == "" {
+		return nil
+	}
+
+	ips := append(record.IPv4Hint(), record.IPv6Hint()...)
+	if len(ips) == 0 {
+		ips = []net.IP{forwarder}
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:            target,
+		RootCAs:               r.rootCAs,
+		VerifyPeerCertificate: verifyDesignated(forwarder),
+	}
+
+	endpoints := make([]*designated, 0)
+	for _, alpn := range record.ALPN() {
+		port, ok := record.Port()
+		switch {
+		case alpn == "dot":
+			if !ok {
+				port = 853
+			}
+			for _, ip := range ips {
+				addr := net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+				endpoints = append(endpoints, &designated{exchanger: &TLSExchanger{Config: tlsConfig}, addr: addr})
+			}
+		case alpn == "h2" && record.DoHPath() != "":
+			if !ok {
+				port = 443
+			}
+			// Queries are POSTed, the template's variable is left out
+			path := record.DoHPath()
+			if i := strings.Index(path, "{"); i >= 0 {
+				path = path[:i]
+			}
+			url := "https://" + net.JoinHostPort(target, strconv.Itoa(int(port))) + path
+			for _, ip := range ips {
+				addr := net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+				endpoints = append(endpoints, &designated{exchanger: dialingHTTPSExchanger(tlsConfig, addr), addr: url})
+			}
+		}
+	}
+
+	return endpoints
+}
+
+// verifyDesignated accepts the certificate of a designated resolver when it
+// is valid for the IP address of the forwarder designating it (RFC9462 4.2),
+// after the usual verification against the target name.
+func verifyDesignated(forwarder net.IP) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("designated resolver sent no certificate")
+		}
+
+		leaf, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return errors.Wrap(err, "parsing designated resolver certificate")
+		}
+
+		for _, ip := range leaf.IPAddresses {
+			if ip.Equal(forwarder) {
+				return nil
+			}
+		}
+
+		return errors.Errorf("designated resolver certificate isn't valid for %s", forwarder)
+	}
+}
+
+// exchangeWith sends a single query for qname to addr through exchanger.
+func exchangeWith(ctx context.Context, exchanger UpstreamExchanger, addr, qname string, qtype dns.QueryType) (*dns.DNSPacket, error) {
+	ctx, cancel := context.WithTimeout(ctx, upstreamTimeout)
+	defer cancel()
+
+	id, err := utils.RandomUint16()
+	if err != nil {
+		return nil, errors.Wrap(err, "generating query id")
+	}
+
+	query := dns.NewDNSPacket()
+	query.Header.ID = id
+	query.Header.RecursionDesired = true
+	query.Questions = append(query.Questions, dns.NewDNSQuestion(qname, qtype))
+
+	return exchanger.Exchange(ctx, query, addr)
+}
@@ -0,0 +1,135 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+// answerWith answers the questions of query with the records of answer.
+func answerWith(query *dns.DNSPacket, answer func(q *dns.DNSQuestion) []string) []byte {
+	response := dns.NewDNSPacket()
+	response.Header.ID = query.Header.ID
+	response.Header.Response = true
+	response.Questions = query.Questions
+	for _, line := range answer(query.Questions[0]) {
+		record, _ := dns.ParseRecord(line, 0)
+		response.Answers = append(response.Answers, record)
+	}
+
+	var b bytes.Buffer
+	response.WriteTo(&b)
+	return b.Bytes()
+}
+
+// serveDoH answers A queries with 192.0.2.2 over DNS over HTTPS at
+// /dns-query, its certificate is valid for example.com and 127.0.0.1.
+func serveDoH(t *testing.T) (*httptest.Server, *x509.CertPool) {
+	doh := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, err := dns.ReadPacket(r.Body)
+		if err != nil || r.URL.Path != "/dns-query" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(answerWith(query, func(q *dns.DNSQuestion) []string {
+			if q.QType != dns.AQueryType {
+				return nil
+			}
+			return []string{"www.example.com. 300 IN A 192.0.2.2"}
+		}))
+	}))
+	t.Cleanup(doh.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(doh.Certificate())
+	return doh, roots
+}
+
+// serveDesignations starts a plain forwarder on ip answering A queries with
+// 192.0.2.1 and designating the resolvers of the SVCB records.
+func serveDesignations(t *testing.T, ip string, designations ...string) Forwarders {
+	conn, err := net.ListenPacket("udp", ip+":0")
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		msg := make([]byte, dns.MaxMessageSize)
+		for {
+			n, client, err := conn.ReadFrom(msg)
+			if err != nil {
+				return
+			}
+			query, err := dns.ReadPacket(bytes.NewReader(msg[:n]))
+			if err != nil {
+				continue
+			}
+
+			conn.WriteTo(answerWith(query, func(q *dns.DNSQuestion) []string {
+				if q.QType == dns.SVCBQueryType {
+					return designations
+				}
+				return []string{"www.example.com. 300 IN A 192.0.2.1"}
+			}), client)
+		}
+	}()
+
+	var fs Forwarders
+	NoError(t, fs.Set(conn.LocalAddr().String()))
+	return fs
+}
+
+// designatingDoH is the SVCB record designating the DoH server.
+func designatingDoH(doh *httptest.Server) string {
+	port := strconv.Itoa(doh.Listener.Addr().(*net.TCPAddr).Port)
+	return "_dns.resolver.arpa. 300 IN SVCB 1 example.com. alpn=h2 port=" + port + ` dohpath="/dns-query{?dns}" ipv4hint=127.0.0.1`
+}
+
+func TestDiscoverDesignated(t *testing.T) {
+	doh, roots := serveDoH(t)
+
+	resolve := func(t *testing.T, fs Forwarders) string {
+		r := NewResolver(&Config{Forwarders: fs})
+		r.rootCAs = roots
+		r.DiscoverDesignated(context.Background())
+
+		response, err := r.Resolve(context.Background(), "www.example.com", dns.AQueryType)
+		if NoError(t, err) && Len(t, response.Answers, 1) {
+			return response.Answers[0].Addr.String()
+		}
+		return ""
+	}
+
+	t.Run("upgrades_to_the_designated_doh_resolver", func(t *testing.T) {
+		fs := serveDesignations(t, "127.0.0.1",
+			"_dns.resolver.arpa. 300 IN SVCB 2 example.com. alpn=dot port=1 ipv4hint=127.0.0.1",
+			designatingDoH(doh))
+		Equal(t, "192.0.2.2", resolve(t, fs))
+	})
+
+	t.Run("unreachable_designated_resolvers_are_skipped", func(t *testing.T) {
+		fs := serveDesignations(t, "127.0.0.1",
+			"_dns.resolver.arpa. 300 IN SVCB 1 example.com. alpn=dot port=1 ipv4hint=127.0.0.1")
+		Equal(t, "192.0.2.1", resolve(t, fs))
+	})
+
+	t.Run("certificates_must_name_the_forwarder", func(t *testing.T) {
+		Equal(t, "192.0.2.1", resolve(t, serveDesignations(t, "127.0.0.2", designatingDoH(doh))))
+	})
+
+	t.Run("forwarders_without_designations_stay_plain", func(t *testing.T) {
+		Equal(t, "192.0.2.1", resolve(t, serveDesignations(t, "127.0.0.1")))
+	})
+}
@@ -0,0 +1,233 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/pkg/errors"
+)
+
+var (
+	defaultResolver     *Resolver
+	defaultResolverOnce sync.Once
+)
+
+// Dial routes the standard library's name resolution through a shared default
+// Resolver:
+//
+//	net.Resolver{PreferGo: true, Dial: resolver.Dial}
+func Dial(ctx context.Context, network, address string) (net.Conn, error) {
+	defaultResolverOnce.Do(func() {
+		defaultResolver = NewResolver(&Config{})
+	})
+
+	return defaultResolver.Dial(ctx, network, address)
+}
+
+// Dial implements the net.Resolver Dial hook. The returned connection never
+// touches the network, queries written to it are resolved by r and the
+// responses are read back.
+func (r *Resolver) Dial(ctx context.Context, network, address string) (net.Conn, error) {
+	c := &dialConn{
+		ctx:      ctx,
+		resolver: r,
+		remote:   dialAddr{network: network, address: address},
+		stream:   !strings.HasPrefix(network, "udp"),
+	}
+
+	// The standard library picks the message framing by checking for
+	// net.PacketConn
+	if c.stream {
+		return c, nil
+	}
+	return &dialPacketConn{c}, nil
+}
+
+type dialAddr struct {
+	network string
+	address string
+}
+
+func (a dialAddr) Network() string { return a.network }
+func (a dialAddr) String() string  { return a.address }
+
+// dialConn answers DNS messages written to it. Stream connections frame every
+// message with a two byte length like DNS over TCP.
+type dialConn struct {
+	ctx      context.Context
+	resolver *Resolver
+	remote   dialAddr
+	stream   bool
+
+	mu       sync.Mutex
+	deadline time.Time
+	pending  bytes.Buffer
+	response bytes.Buffer
+	closed   bool
+}
+
+func (c *dialConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+
+	if !c.stream {
+		if err := c.answer(b); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	c.pending.Write(b)
+	for c.pending.Len() >= 2 {
+		length := int(binary.BigEndian.Uint16(c.pending.Bytes()))
+		if c.pending.Len() < 2+length {
+			break
+		}
+
+		c.pending.Next(2)
+		if err := c.answer(c.pending.Next(length)); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(b), nil
+}
+
+func (c *dialConn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+
+	if c.response.Len() == 0 {
+		return 0, io.EOF
+	}
+
+	return c.response.Read(b)
+}
+
+// answer resolves the query message and queues the response.
+func (c *dialConn) answer(msg []byte) error {
+	reqBuffer := buffer.NewBytePacketBuffer()
+	if len(msg) > len(reqBuffer.Buf) {
+		return errors.New("dns message too large")
+	}
+	copy(reqBuffer.Buf, msg)
+
+	request, err := dns.DNSPacketFromBuffer(reqBuffer)
+	if err != nil {
+		return errors.Wrap(err, "parsing dns query")
+	}
+
+	ctx := c.ctx
+	if !c.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+
+	response := dns.NewDNSPacket()
+	response.Header.ID = request.Header.ID
+	response.Header.Response = true
+	response.Header.RecursionDesired = request.Header.RecursionDesired
+	response.Header.RecursionAvailable = true
+	response.Questions = request.Questions
+
+	if len(request.Questions) != 1 {
+		response.Header.ResCode = dns.FormErr
+	} else {
+		q := request.Questions[0]
+		result, err := c.resolver.Resolve(ctx, q.Name.String(), q.QType)
+		if err != nil {
+			response.Header.ResCode = dns.ServFail
+		} else {
+			response.Header.ResCode = result.Header.ResCode
+			response.Answers = result.Answers
+			response.Authorities = result.Authorities
+			response.Resources = result.Resources
+			response.RemoveOPT()
+		}
+	}
+
+	resBuffer := buffer.NewBytePacketBuffer()
+	if err := response.Write(resBuffer); err != nil {
+		return errors.Wrap(err, "writing dns response")
+	}
+
+	data, err := resBuffer.GetRangeAtPos()
+	if err != nil {
+		return errors.Wrap(err, "retrieving buffer")
+	}
+
+	if c.stream {
+		binary.Write(&c.response, binary.BigEndian, uint16(len(data)))
+	}
+	c.response.Write(data)
+
+	return nil
+}
+
+func (c *dialConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	return nil
+}
+
+func (c *dialConn) LocalAddr() net.Addr  { return dialAddr{network: c.remote.network} }
+func (c *dialConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *dialConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.deadline = t
+	return nil
+}
+
+func (c *dialConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *dialConn) SetWriteDeadline(t time.Time) error { return c.SetDeadline(t) }
+
+// dialPacketConn is the datagram flavour of dialConn, every Read returns
+// exactly one response.
+type dialPacketConn struct {
+	*dialConn
+}
+
+func (c *dialPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, err := c.Read(b)
+	return n, c.remote, err
+}
+
+func (c *dialPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.Write(b)
+}
+
+func (c *dialPacketConn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.response.Len() == 0 {
+		return 0, io.EOF
+	}
+
+	// Datagrams are never split across reads
+	n := copy(b, c.response.Bytes())
+	c.response.Reset()
+	return n, nil
+}
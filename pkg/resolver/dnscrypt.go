@@ -0,0 +1,317 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/logger"
+	"github.com/msarvar/godns/pkg/utils"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/nacl/box"
+)
+
+const (
+	// dnscryptCertSize is the size of a certificate without extensions
+	dnscryptCertSize = 124
+	// dnscryptMinQuerySize is what UDP queries are padded to at least, it
+	// keeps responses from amplifying queries
+	dnscryptMinQuerySize = 256
+	// dnscryptBlockSize is the block queries are padded to a multiple of
+	dnscryptBlockSize = 64
+)
+
+var (
+	dnscryptCertMagic     = []byte("DNSC")
+	dnscryptResolverMagic = []byte("r6fnvWj8")
+)
+
+// dnscryptCert is the short term key a DNSCrypt resolver presents.
+type dnscryptCert struct {
+	serial      uint32
+	clientMagic [8]byte
+	notAfter    time.Time
+	// shared is the key of the resolver and the client key pair
+	shared [32]byte
+}
+
+// DNSCryptExchanger sends queries encrypted with DNSCrypt version 2
+// (https://dnscrypt.info/protocol) using X25519-XSalsa20Poly1305, over UDP
+// and over TCP when a response is truncated. The resolver's certificate is
+// fetched with a plain TXT query on first use and again once it expires.
+type DNSCryptExchanger struct {
+	// ProviderName and PublicKey identify the resolver, its certificates
+	// must be signed with PublicKey
+	ProviderName string
+	PublicKey    ed25519.PublicKey
+
+	mu         sync.Mutex
+	cert       *dnscryptCert
+	publicKey  *[32]byte
+	privateKey *[32]byte
+}
+
+func (e *DNSCryptExchanger) Exchange(ctx context.Context, query *dns.DNSPacket, addr string) (*dns.DNSPacket, error) {
+	cert, err := e.certificate(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var plain bytes.Buffer
+	if _, err := query.WriteTo(&plain); err != nil {
+		return nil, err
+	}
+
+	response, err := e.roundTrip(ctx, "udp", addr, cert, plain.Bytes())
+	if err == nil && response.Header.TruncatedMessage {
+		response, err = e.roundTrip(ctx, "tcp", addr, cert, plain.Bytes())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !responseMatches(query, response) {
+		return nil, errors.New("dns server response doesn't match the query")
+	}
+
+	return response, nil
+}
+
+// roundTrip encrypts msg for the resolver, sends it and decrypts the
+// response.
+func (e *DNSCryptExchanger) roundTrip(ctx context.Context, network, addr string, cert *dnscryptCert, msg []byte) (*dns.DNSPacket, error) {
+	var nonce [24]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:12]); err != nil {
+		return nil, errors.Wrap(err, "generating dnscrypt nonce")
+	}
+
+	minSize := 0
+	if network == "udp" {
+		minSize = dnscryptMinQuerySize
+	}
+	packet := make([]byte, 0, 52+len(msg)+dnscryptBlockSize+box.Overhead)
+	packet = append(packet, cert.clientMagic[:]...)
+	packet = append(packet, e.publicKey[:]...)
+	packet = append(packet, nonce[:12]...)
+	packet = box.SealAfterPrecomputation(packet, dnscryptPad(msg, minSize), &nonce, &cert.shared)
+
+	d, err := dialerFor(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to dnscrypt server")
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(upstreamTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	data, err := dnscryptSend(conn, network, packet)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, errors.Wrap(err, "exchanging dnscrypt messages")
+	}
+
+	if len(data) < 32+box.Overhead || !bytes.Equal(data[:8], dnscryptResolverMagic) || !bytes.Equal(data[8:20], nonce[:12]) {
+		return nil, errors.New("dnscrypt response doesn't match the query")
+	}
+	copy(nonce[:], data[8:32])
+
+	padded, ok := box.OpenAfterPrecomputation(nil, data[32:], &nonce, &cert.shared)
+	if !ok {
+		return nil, errors.New("decrypting dnscrypt response")
+	}
+	plain, err := dnscryptUnpad(padded)
+	if err != nil {
+		return nil, err
+	}
+
+	return dns.ReadPacket(bytes.NewReader(plain))
+}
+
+// dnscryptSend writes packet to conn and reads the response, prefixed with
+// their length on TCP.
+func dnscryptSend(conn net.Conn, network string, packet []byte) ([]byte, error) {
+	if network == "udp" {
+		if _, err := conn.Write(packet); err != nil {
+			return nil, err
+		}
+
+		data := make([]byte, dns.MaxMessageSize)
+		n, err := conn.Read(data)
+		return data[:n], err
+	}
+
+	var length [2]byte
+	binary.BigEndian.PutUint16(length[:], uint16(len(packet)))
+	if _, err := (&net.Buffers{length[:], packet}).WriteTo(conn); err != nil {
+		return nil, err
+	}
+
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint16(length[:]))
+	_, err := io.ReadFull(conn, data)
+	return data, err
+}
+
+// dnscryptPad appends 0x80 and zeros to msg up to a multiple of the block
+// size and at least minSize bytes.
+func dnscryptPad(msg []byte, minSize int) []byte {
+	size := len(msg) + 1
+	if size < minSize {
+		size = minSize
+	}
+	size = (size + dnscryptBlockSize - 1) / dnscryptBlockSize * dnscryptBlockSize
+
+	padded := make([]byte, size)
+	copy(padded, msg)
+	padded[len(msg)] = 0x80
+	return padded
+}
+
+// dnscryptUnpad removes the padding dnscryptPad adds.
+func dnscryptUnpad(padded []byte) ([]byte, error) {
+	end := bytes.LastIndexByte(padded, 0x80)
+	if end < 0 || len(bytes.Trim(padded[end+1:], "\x00")) != 0 {
+		return nil, errors.New("dnscrypt response padding is invalid")
+	}
+
+	return padded[:end], nil
+}
+
+// certificate returns the resolver's current certificate, fetching it when
+// there is none yet or it expired.
+func (e *DNSCryptExchanger) certificate(ctx context.Context, addr string) (*dnscryptCert, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.cert != nil && time.Now().Before(e.cert.notAfter) {
+		return e.cert, nil
+	}
+
+	if e.privateKey == nil {
+		var err error
+		e.publicKey, e.privateKey, err = box.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, errors.Wrap(err, "generating dnscrypt key pair")
+		}
+	}
+
+	cert, err := e.fetchCertificate(ctx, addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fetching dnscrypt certificate of %s", e.ProviderName)
+	}
+
+	e.cert = cert
+	return cert, nil
+}
+
+// fetchCertificate asks the resolver for the TXT records of the provider
+// name and keeps the valid certificate with the highest serial.
+func (e *DNSCryptExchanger) fetchCertificate(ctx context.Context, addr string) (*dnscryptCert, error) {
+	id, err := utils.RandomUint16()
+	if err != nil {
+		return nil, errors.Wrap(err, "generating query id")
+	}
+
+	query := dns.NewDNSPacket()
+	query.Header.ID = id
+	query.Header.RecursionDesired = true
+	query.Questions = append(query.Questions, dns.NewDNSQuestion(strings.TrimSuffix(e.ProviderName, "."), dns.TXTQueryType))
+
+	d, err := dialerFor(ctx, "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := d.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to dnscrypt server")
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(upstreamTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	if _, err := query.WriteTo(conn); err != nil {
+		return nil, errors.Wrap(err, "sending certificate request")
+	}
+	response, err := dns.ReadPacket(conn)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading certificate response")
+	}
+	if !responseMatches(query, response) {
+		return nil, errors.New("dns server response doesn't match the query")
+	}
+
+	var best *dnscryptCert
+	now := time.Now()
+	for _, record := range response.Answers {
+		texts, err := record.TXT()
+		if err != nil {
+			continue
+		}
+
+		cert, err := e.parseCertificate([]byte(strings.Join(texts, "")), now)
+		if err != nil {
+			logger.Debugf("Skipping dnscrypt certificate of %s: %s\n", e.ProviderName, err)
+			continue
+		}
+		if best == nil || cert.serial > best.serial {
+			best = cert
+		}
+	}
+
+	if best == nil {
+		return nil, errors.New("no valid certificate")
+	}
+
+	return best, nil
+}
+
+// parseCertificate verifies a certificate signed with PublicKey and valid at
+// now, and computes the key shared with the resolver.
+func (e *DNSCryptExchanger) parseCertificate(data []byte, now time.Time) (*dnscryptCert, error) {
+	if len(data) < dnscryptCertSize || !bytes.Equal(data[:4], dnscryptCertMagic) {
+		return nil, errors.New("not a dnscrypt certificate")
+	}
+	if version := binary.BigEndian.Uint16(data[4:6]); version != 1 {
+		return nil, errors.Errorf("certificate of unsupported es-version %d", version)
+	}
+	if !ed25519.Verify(e.PublicKey, data[72:], data[8:72]) {
+		return nil, errors.New("certificate signature doesn't match the provider key")
+	}
+
+	cert := &dnscryptCert{serial: binary.BigEndian.Uint32(data[112:116])}
+	copy(cert.clientMagic[:], data[104:112])
+	notBefore := time.Unix(int64(binary.BigEndian.Uint32(data[116:120])), 0)
+	cert.notAfter = time.Unix(int64(binary.BigEndian.Uint32(data[120:124])), 0)
+	if now.Before(notBefore) || !now.Before(cert.notAfter) {
+		return nil, errors.Errorf("certificate %d is valid from %s to %s", cert.serial, notBefore.UTC(), cert.notAfter.UTC())
+	}
+
+	var resolverKey [32]byte
+	copy(resolverKey[:], data[72:104])
+	box.Precompute(&cert.shared, &resolverKey, e.privateKey)
+
+	return cert, nil
+}
@@ -0,0 +1,185 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/nacl/box"
+
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+// dnscryptServer answers certificate requests and encrypted A queries for
+// www.example.com on a local UDP socket.
+type dnscryptServer struct {
+	conn        net.PacketConn
+	signingKey  ed25519.PrivateKey
+	publicKey   *[32]byte
+	privateKey  *[32]byte
+	clientMagic []byte
+	notAfter    time.Time
+}
+
+func newDNSCryptServer(t *testing.T) *dnscryptServer {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, signingKey, _ := ed25519.GenerateKey(rand.Reader)
+	publicKey, privateKey, _ := box.GenerateKey(rand.Reader)
+	s := &dnscryptServer{
+		conn:        conn,
+		signingKey:  signingKey,
+		publicKey:   publicKey,
+		privateKey:  privateKey,
+		clientMagic: []byte("godnstst"),
+		notAfter:    time.Now().Add(time.Hour),
+	}
+	go s.serve()
+
+	return s
+}
+
+func (s *dnscryptServer) stamp(publicKey ed25519.PublicKey) *Stamp {
+	return &Stamp{Protocol: StampDNSCrypt, Addr: s.conn.LocalAddr().(*net.UDPAddr),
+		PublicKey: publicKey, ProviderName: "2.dnscrypt-cert.example.com"}
+}
+
+func (s *dnscryptServer) certificate() []byte {
+	signed := append([]byte{}, s.publicKey[:]...)
+	signed = append(signed, s.clientMagic...)
+	var fields [12]byte
+	binary.BigEndian.PutUint32(fields[0:], 1)
+	binary.BigEndian.PutUint32(fields[4:], uint32(time.Now().Add(-time.Hour).Unix()))
+	binary.BigEndian.PutUint32(fields[8:], uint32(s.notAfter.Unix()))
+	signed = append(signed, fields[:]...)
+
+	cert := []byte("DNSC\x00\x01\x00\x00")
+	cert = append(cert, ed25519.Sign(s.signingKey, signed)...)
+	return append(cert, signed...)
+}
+
+func (s *dnscryptServer) serve() {
+	data := make([]byte, dns.MaxMessageSize)
+	for {
+		n, addr, err := s.conn.ReadFrom(data)
+		if err != nil {
+			return
+		}
+
+		var response []byte
+		if bytes.HasPrefix(data[:n], s.clientMagic) {
+			response = s.answer(data[:n])
+		} else {
+			response = s.answerCertificate(data[:n])
+		}
+		if response != nil {
+			s.conn.WriteTo(response, addr)
+		}
+	}
+}
+
+func (s *dnscryptServer) answerCertificate(msg []byte) []byte {
+	query, err := dns.ReadPacket(bytes.NewReader(msg))
+	if err != nil {
+		return nil
+	}
+
+	response := dns.NewDNSPacket()
+	response.Header.ID = query.Header.ID
+	response.Header.Response = true
+	response.Questions = query.Questions
+	record, _ := dns.NewTXTRecord(query.Questions[0].Name.String(), 3600, string(s.certificate()))
+	response.Answers = append(response.Answers, record)
+
+	var b bytes.Buffer
+	response.WriteTo(&b)
+	return b.Bytes()
+}
+
+func (s *dnscryptServer) answer(packet []byte) []byte {
+	var clientKey [32]byte
+	var nonce [24]byte
+	copy(clientKey[:], packet[8:40])
+	copy(nonce[:], packet[40:52])
+
+	padded, ok := box.Open(nil, packet[52:], &nonce, &clientKey, s.privateKey)
+	if !ok || len(padded) < dnscryptMinQuerySize {
+		return nil
+	}
+	msg, err := dnscryptUnpad(padded)
+	if err != nil {
+		return nil
+	}
+	query, err := dns.ReadPacket(bytes.NewReader(msg))
+	if err != nil {
+		return nil
+	}
+
+	response := dns.NewDNSPacket()
+	response.Header.ID = query.Header.ID
+	response.Header.Response = true
+	response.Questions = query.Questions
+	record, _ := dns.ParseRecord("www.example.com. 300 IN A 192.0.2.20", 0)
+	response.Answers = append(response.Answers, record)
+
+	var b bytes.Buffer
+	response.WriteTo(&b)
+	rand.Read(nonce[12:])
+	sealed := append([]byte("r6fnvWj8"), nonce[:]...)
+	return box.Seal(sealed, dnscryptPad(b.Bytes(), 0), &nonce, &clientKey, s.privateKey)
+}
+
+func TestDNSCrypt(t *testing.T) {
+	server := newDNSCryptServer(t)
+	defer server.conn.Close()
+
+	resolve := func(stamp *Stamp) (*dns.DNSPacket, error) {
+		var fs Forwarders
+		if err := fs.Set(stamp.String()); err != nil {
+			return nil, err
+		}
+
+		r := NewResolver(&Config{Forwarders: fs})
+		return r.Resolve(context.Background(), "www.example.com", dns.AQueryType)
+	}
+
+	t.Run("queries_are_encrypted_for_the_certified_key", func(t *testing.T) {
+		response, err := resolve(server.stamp(server.signingKey.Public().(ed25519.PublicKey)))
+		if NoError(t, err) && Len(t, response.Answers, 1) {
+			Equal(t, "192.0.2.20", response.Answers[0].Addr.String())
+		}
+	})
+
+	t.Run("certificates_signed_by_another_key_are_refused", func(t *testing.T) {
+		other, _, _ := ed25519.GenerateKey(rand.Reader)
+		_, err := resolve(server.stamp(other))
+		Error(t, err)
+	})
+
+	t.Run("stamps_need_a_public_key", func(t *testing.T) {
+		_, err := resolve(server.stamp([]byte{1, 2, 3}))
+		Error(t, err)
+	})
+
+	t.Run("padding_round_trips", func(t *testing.T) {
+		for _, msg := range [][]byte{{}, {0x80}, bytes.Repeat([]byte{1}, 63), bytes.Repeat([]byte{0}, 300)} {
+			padded := dnscryptPad(msg, dnscryptMinQuerySize)
+			True(t, len(padded) >= dnscryptMinQuerySize)
+			Zero(t, len(padded)%dnscryptBlockSize)
+
+			unpadded, err := dnscryptUnpad(padded)
+			if NoError(t, err) {
+				Equal(t, msg, unpadded)
+			}
+		}
+	})
+}
@@ -0,0 +1,426 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/pkg/errors"
+)
+
+// UpstreamExchanger sends a query to a name server and returns its response.
+// The resolver uses UDPExchanger unless configured otherwise, tests replace
+// it to resolve without network access.
+type UpstreamExchanger interface {
+	// Exchange sends the query to addr, "host:port" or a URL for DNS over
+	// HTTPS. The query id may be replaced by one the transport can track.
+	Exchange(ctx context.Context, query *dns.DNSPacket, addr string) (*dns.DNSPacket, error)
+}
+
+// UDPExchanger sends queries over pooled UDP sockets. Truncated responses
+// are retried over TCP.
+type UDPExchanger struct {
+	// Capture receives every message sent and received when set
+	Capture func(src net.Addr, dst net.Addr, msg []byte)
+	// Faults degrade the exchanges for testing
+	Faults Faults
+	// MaxUDPSize is the largest response read, it should match the payload
+	// size the queries advertise. It defaults to 512 bytes.
+	MaxUDPSize uint16
+
+	sockets *socketPool
+}
+
+func NewUDPExchanger() *UDPExchanger {
+	return &UDPExchanger{sockets: newSocketPool()}
+}
+
+// Close closes the pooled sockets.
+func (e *UDPExchanger) Close() error {
+	e.sockets.Close()
+	return nil
+}
+
+// Exchange sends the query under an id unused on the socket. Datagrams that
+// don't answer the query are dropped and the next one is awaited, an
+// off-path attacker racing the server has to match the id and question.
+func (e *UDPExchanger) Exchange(ctx context.Context, query *dns.DNSPacket, addr string) (*dns.DNSPacket, error) {
+	reqBuffer := buffer.AcquireBytePacketBuffer()
+	defer reqBuffer.Release()
+
+	var response *dns.DNSPacket
+	_, err := e.roundTrip(ctx, addr,
+		func(id uint16) ([]byte, error) {
+			query.Header.ID = id
+			if err := query.Write(reqBuffer); err != nil {
+				return nil, errors.Wrap(err, "preparing dns request packet")
+			}
+
+			req, err := reqBuffer.GetRangeAtPos()
+			return req, errors.Wrap(err, "retrieving buffer")
+		},
+		func(msg []byte) (err error) {
+			response, err = parseResponse(query, msg)
+			return err
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	// The server has more to say than fits a datagram (RFC7766 5)
+	if response.Header.TruncatedMessage {
+		return (&TCPExchanger{}).Exchange(ctx, query, addr)
+	}
+
+	return response, nil
+}
+
+// ExchangeRaw relays a query message as is, only its id is replaced while
+// it is in flight. The response is returned as received under the id of the
+// query, records and options godns doesn't understand pass through.
+func (e *UDPExchanger) ExchangeRaw(ctx context.Context, msg []byte, addr string) ([]byte, error) {
+	query, err := dns.ParseLazy(append([]byte(nil), msg...))
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing dns request")
+	}
+	originalID := query.Header.ID
+
+	var response *dns.LazyPacket
+	_, err = e.roundTrip(ctx, addr,
+		func(id uint16) ([]byte, error) {
+			query.SetID(id)
+			return query.Raw(), nil
+		},
+		func(msg []byte) (err error) {
+			response, err = dns.ParseLazy(append([]byte(nil), msg...))
+			if err != nil {
+				return errors.Wrap(err, "parsing dns server response")
+			}
+			if !lazyResponseMatches(query, response) {
+				return errors.New("dns server response doesn't match the query")
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	if response.Header.TruncatedMessage {
+		raw, err := (&TCPExchanger{}).ExchangeRaw(ctx, query.Raw(), addr)
+		if err != nil {
+			return nil, err
+		}
+		response, err = dns.ParseLazy(raw)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing dns server response")
+		}
+	}
+
+	response.SetID(originalID)
+	return response.Raw(), nil
+}
+
+// roundTrip sends the message build returns for the id of a pooled socket
+// and waits for a datagram accept takes until the timeout.
+func (e *UDPExchanger) roundTrip(ctx context.Context, addr string, build func(id uint16) ([]byte, error), accept func(msg []byte) error) ([]byte, error) {
+	remote, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing upstream address")
+	}
+
+	source, _ := ctx.Value(sourceKey{}).(*Source)
+	size := int(e.MaxUDPSize)
+	if size < dns.DefaultUDPPayloadSize {
+		size = dns.DefaultUDPPayloadSize
+	}
+	conn, id, responses, err := e.sockets.acquire(remote, source, size)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.release(id)
+
+	req, err := build(id)
+	if err != nil {
+		return nil, err
+	}
+
+	e.capture(conn.LocalAddr(), remote, req)
+	if !e.Faults.drop() {
+		if err := conn.Write(req); err != nil {
+			return nil, errors.Wrap(err, "sending dns request")
+		}
+	}
+
+	timeout := time.NewTimer(upstreamTimeout)
+	defer timeout.Stop()
+
+	var rejected error
+	timedOut := func() error {
+		if rejected != nil {
+			return errors.Wrap(rejected, "no valid dns server response")
+		}
+		return errors.New("reading dns server response: i/o timeout")
+	}
+	for {
+		select {
+		case msg, ok := <-responses:
+			if !ok {
+				return nil, errors.Wrap(errSocketClosed, "reading dns server response")
+			}
+
+			msg, delay := e.Faults.damage(msg)
+			if delay > 0 {
+				select {
+				case <-time.After(delay):
+				case <-timeout.C:
+					return nil, timedOut()
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+			}
+			e.capture(remote, conn.LocalAddr(), msg)
+
+			err := accept(msg)
+			if err == nil {
+				return msg, nil
+			}
+			rejected = err
+		case <-timeout.C:
+			return nil, timedOut()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (e *UDPExchanger) capture(src net.Addr, dst net.Addr, msg []byte) {
+	if e.Capture != nil {
+		e.Capture(src, dst, msg)
+	}
+}
+
+// parseResponse accepts msg only when it answers query.
+func parseResponse(query *dns.DNSPacket, msg []byte) (*dns.DNSPacket, error) {
+	resBuffer := buffer.NewBytePacketBuffer()
+	resBuffer.Buf = msg
+
+	response, err := dns.DNSPacketFromBuffer(resBuffer)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing dns server response")
+	}
+
+	if !responseMatches(query, response) {
+		return nil, errors.New("dns server response doesn't match the query")
+	}
+
+	return response, nil
+}
+
+// TCPExchanger sends every query over a new TCP connection (RFC7766).
+type TCPExchanger struct{}
+
+func (e *TCPExchanger) Exchange(ctx context.Context, query *dns.DNSPacket, addr string) (*dns.DNSPacket, error) {
+	d, err := dialerFor(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to dns server")
+	}
+	defer conn.Close()
+
+	return exchangeStream(ctx, conn, query)
+}
+
+// ExchangeRaw relays the query message as is over a new TCP connection and
+// returns the response as received.
+func (e *TCPExchanger) ExchangeRaw(ctx context.Context, msg []byte, addr string) ([]byte, error) {
+	query, err := dns.ParseLazy(append([]byte(nil), msg...))
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing dns request")
+	}
+
+	d, err := dialerFor(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to dns server")
+	}
+	defer conn.Close()
+	defer watchStream(ctx, conn)()
+
+	var length [2]byte
+	binary.BigEndian.PutUint16(length[:], uint16(len(msg)))
+	if _, err := (&net.Buffers{length[:], msg}).WriteTo(conn); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, errors.Wrap(err, "sending dns request")
+	}
+
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, errors.Wrap(err, "reading dns server response length")
+	}
+	data := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, data); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, errors.Wrap(err, "reading dns server response")
+	}
+
+	response, err := dns.ParseLazy(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing dns server response")
+	}
+	if !lazyResponseMatches(query, response) {
+		return nil, errors.New("dns server response doesn't match the query")
+	}
+
+	return data, nil
+}
+
+// TLSExchanger sends every query over a new TLS connection, DNS over TLS
+// (RFC7858). The server name is verified against the host of addr unless
+// Config says otherwise.
+type TLSExchanger struct {
+	Config *tls.Config
+}
+
+func (e *TLSExchanger) Exchange(ctx context.Context, query *dns.DNSPacket, addr string) (*dns.DNSPacket, error) {
+	cfg := &tls.Config{}
+	if e.Config != nil {
+		cfg = e.Config.Clone()
+	}
+	if cfg.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing upstream address")
+		}
+		cfg.ServerName = host
+	}
+
+	netDialer, err := dialerFor(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	d := &tls.Dialer{NetDialer: netDialer, Config: cfg}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to dns server")
+	}
+	defer conn.Close()
+
+	return exchangeStream(ctx, conn, query)
+}
+
+// exchangeStream sends the query on a stream connection and reads the
+// response, both prefixed with their length. The exchange stops at the
+// deadline of ctx or when it is cancelled.
+func exchangeStream(ctx context.Context, conn net.Conn, query *dns.DNSPacket) (*dns.DNSPacket, error) {
+	defer watchStream(ctx, conn)()
+
+	if _, err := query.WriteTo(conn); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, errors.Wrap(err, "sending dns request")
+	}
+
+	response, err := dns.ReadPacket(conn)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, errors.Wrap(err, "reading dns server response")
+	}
+
+	if !responseMatches(query, response) {
+		return nil, errors.New("dns server response doesn't match the query")
+	}
+
+	return response, nil
+}
+
+// watchStream bounds the exchange on conn by the upstream timeout and the
+// deadline of ctx, cancelling ctx unblocks it. The returned function stops
+// watching.
+func watchStream(ctx context.Context, conn net.Conn) func() {
+	deadline := time.Now().Add(upstreamTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	// Cancelling unblocks the read by moving the deadline to now
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// HTTPSExchanger sends queries as DNS over HTTPS POST requests (RFC8484) to
+// the URL given as address.
+type HTTPSExchanger struct {
+	// Client defaults to a client with the upstream timeout
+	Client *http.Client
+}
+
+func (e *HTTPSExchanger) Exchange(ctx context.Context, query *dns.DNSPacket, addr string) (*dns.DNSPacket, error) {
+	// The id is always zero to keep responses cacheable (RFC8484 4.1)
+	query.Header.ID = 0
+
+	var body bytes.Buffer
+	if _, err := query.WriteTo(&body); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, addr, &body)
+	if err != nil {
+		return nil, errors.Wrap(err, "preparing dns over https request")
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	client := e.Client
+	if client == nil {
+		client = &http.Client{Timeout: upstreamTimeout}
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "sending dns over https request")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("dns over https server answered %s", res.Status)
+	}
+
+	msg, err := ioutil.ReadAll(io.LimitReader(res.Body, dns.MaxMessageSize))
+	if err != nil {
+		return nil, errors.Wrap(err, "reading dns over https response")
+	}
+
+	return parseResponse(query, msg)
+}
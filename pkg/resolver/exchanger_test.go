@@ -0,0 +1,331 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/cache"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/pkg/errors"
+)
+
+// mockServer answers from its records and refers names below its delegations
+// to the name servers of the child zone.
+type mockServer struct {
+	records []string
+	// delegations maps a child zone to its NS and glue records
+	delegations map[string][]string
+}
+
+// mockExchanger answers queries from the mock servers keyed by address
+// without touching the network.
+type mockExchanger struct {
+	servers map[string]*mockServer
+
+	mu      sync.Mutex
+	queries []string
+}
+
+func (m *mockExchanger) Exchange(ctx context.Context, query *dns.DNSPacket, addr string) (*dns.DNSPacket, error) {
+	q := query.Questions[0]
+
+	m.mu.Lock()
+	m.queries = append(m.queries, addr+" "+q.QType.String()+" "+q.Name.String())
+	m.mu.Unlock()
+
+	server, ok := m.servers[addr]
+	if !ok {
+		return nil, &net.OpError{Op: "read", Net: "udp", Err: errors.New("i/o timeout")}
+	}
+
+	response := dns.NewDNSPacket()
+	response.Header.ID = query.Header.ID
+	response.Header.Response = true
+	response.Questions = query.Questions
+
+	name := buffer.NewDomainName(q.Name.String())
+	for zone, records := range server.delegations {
+		if !name.IsSubdomainOf(buffer.NewDomainName(zone)) {
+			continue
+		}
+
+		for _, text := range records {
+			r := mustParseRecord(text)
+			if r.QType == dns.NSQueryType {
+				response.Authorities = append(response.Authorities, r)
+			} else {
+				response.Resources = append(response.Resources, r)
+			}
+		}
+		return response, nil
+	}
+
+	exists := false
+	for _, text := range server.records {
+		r := mustParseRecord(text)
+		if !r.Domain.Equal(name) {
+			continue
+		}
+
+		exists = true
+		if r.QType == q.QType {
+			response.Answers = append(response.Answers, r)
+		}
+	}
+	if !exists {
+		response.Header.ResCode = dns.NxDomain
+	}
+
+	return response, nil
+}
+
+func mustParseRecord(text string) *dns.DNSRecord {
+	r, err := dns.ParseRecord(text, 0)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+func TestRecursiveLookup(t *testing.T) {
+	root := rootServers[0].String() + ":53"
+
+	t.Run("follows_referrals_from_the_root", func(t *testing.T) {
+		m := &mockExchanger{servers: map[string]*mockServer{
+			root: {delegations: map[string][]string{
+				"com": {"com. 172800 IN NS a.gtld-servers.net.", "a.gtld-servers.net. 172800 IN A 192.5.6.30"},
+			}},
+			"192.5.6.30:53": {delegations: map[string][]string{
+				"example.com": {"example.com. 172800 IN NS ns.example.com.", "ns.example.com. 172800 IN A 192.0.2.53"},
+			}},
+			"192.0.2.53:53": {records: []string{
+				"example.com. 300 IN NS ns.example.com.",
+				"www.example.com. 300 IN A 192.0.2.1",
+			}},
+		}}
+
+		r := NewResolver(&Config{Exchanger: m})
+		response, err := r.Resolve(context.Background(), "www.example.com", dns.AQueryType)
+		NoError(t, err)
+		if Len(t, response.Answers, 1) {
+			Equal(t, "192.0.2.1", response.Answers[0].Addr.String())
+		}
+
+		// Only one more label is revealed to every server
+		for _, q := range m.queries {
+			if strings.HasPrefix(q, root) {
+				Equal(t, root+" A com", q)
+			}
+		}
+	})
+
+	t.Run("name_servers_inside_each_other_hit_the_limit", func(t *testing.T) {
+		m := &mockExchanger{servers: map[string]*mockServer{
+			root: {delegations: map[string][]string{
+				"a.test": {"a.test. 172800 IN NS ns.b.test."},
+				"b.test": {"b.test. 172800 IN NS ns.a.test."},
+			}},
+		}}
+
+		r := NewResolver(&Config{Exchanger: m})
+		_, err := r.Resolve(context.Background(), "www.a.test", dns.AQueryType)
+		True(t, errors.Is(err, ErrLimitExceeded), "%v", err)
+	})
+}
+
+func TestSanitize(t *testing.T) {
+	root := rootServers[0].String() + ":53"
+	servers := map[string]*mockServer{
+		root: {delegations: map[string][]string{
+			"com": {"com. 172800 IN NS a.gtld-servers.net.", "a.gtld-servers.net. 172800 IN A 192.5.6.30"},
+		}},
+		"192.5.6.30:53": {delegations: map[string][]string{
+			"example.com": {
+				"example.com. 172800 IN NS ns.example.com.",
+				"ns.example.com. 172800 IN A 192.0.2.53",
+				// Poison for a zone the com servers don't serve
+				"www.example.org. 172800 IN A 203.0.113.66",
+			},
+		}},
+		"192.0.2.53:53": {records: []string{
+			"www.example.com. 5 IN A 192.0.2.1",
+			"www.example.com. 5 IN A 192.0.2.1",
+			"www.example.com. 5 IN A 192.0.2.2",
+		}},
+	}
+
+	t.Run("drops_out_of_bailiwick_records", func(t *testing.T) {
+		r := NewResolver(&Config{Exchanger: &mockExchanger{servers: servers}})
+
+		response := dns.NewDNSPacket()
+		response.Resources = []*dns.DNSRecord{
+			mustParseRecord("ns.example.com. 300 IN A 192.0.2.53"),
+			mustParseRecord("www.example.org. 300 IN A 203.0.113.66"),
+		}
+		r.sanitize(response, "com")
+		if Len(t, response.Resources, 1) {
+			Equal(t, "ns.example.com", response.Resources[0].Domain.String())
+		}
+	})
+
+	t.Run("dedups_and_caps_ttls", func(t *testing.T) {
+		r := NewResolver(&Config{
+			Exchanger: &mockExchanger{servers: servers},
+			MinTTL:    time.Minute,
+			MaxTTL:    time.Hour,
+		})
+
+		response, err := r.Resolve(context.Background(), "www.example.com", dns.AQueryType)
+		NoError(t, err)
+		if Len(t, response.Answers, 2) {
+			Equal(t, uint32(60), response.Answers[0].TTL)
+			Equal(t, "192.0.2.2", response.Answers[1].Addr.String())
+		}
+
+		response = dns.NewDNSPacket()
+		response.Authorities = []*dns.DNSRecord{mustParseRecord("example.com. 172800 IN NS ns.example.com.")}
+		r.sanitize(response, "")
+		Equal(t, uint32(3600), response.Authorities[0].TTL)
+	})
+}
+
+func TestTCPExchanger(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	NoError(t, err)
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		query, err := dns.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		query.Header.Response = true
+		query.Answers = append(query.Answers, mustParseRecord("www.example.com. 300 IN A 192.0.2.1"))
+		query.WriteTo(conn)
+	}()
+
+	query := dns.NewDNSPacket()
+	query.Header.ID = 4660
+	query.Questions = append(query.Questions, dns.NewDNSQuestion("www.example.com", dns.AQueryType))
+
+	response, err := (&TCPExchanger{}).Exchange(context.Background(), query, ln.Addr().String())
+	NoError(t, err)
+	if Len(t, response.Answers, 1) {
+		Equal(t, "192.0.2.1", response.Answers[0].Addr.String())
+	}
+}
+
+func TestTruncatedResponses(t *testing.T) {
+	// Answers over UDP are truncated, the answer only fits over TCP
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if !NoError(t, err) {
+		return
+	}
+	defer ln.Close()
+	conn, err := net.ListenPacket("udp", ln.Addr().String())
+	if !NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	answer := func(q *dns.DNSQuestion) []string {
+		return []string{"www.example.com. 300 IN A 192.0.2.1"}
+	}
+	advertised := make(chan uint16, 10)
+	go func() {
+		msg := make([]byte, dns.MaxMessageSize)
+		for {
+			n, client, err := conn.ReadFrom(msg)
+			if err != nil {
+				return
+			}
+			query, err := dns.ReadPacket(bytes.NewReader(msg[:n]))
+			if err != nil {
+				continue
+			}
+			if opt := query.OPT(); opt != nil {
+				advertised <- opt.UDPSize()
+			}
+
+			response := answerWith(query, func(q *dns.DNSQuestion) []string { return nil })
+			response[2] |= 0x02
+			conn.WriteTo(response, client)
+		}
+	}()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			query, err := dns.ReadPacket(c)
+			if err == nil {
+				response := answerWith(query, answer)
+				var length [2]byte
+				binary.BigEndian.PutUint16(length[:], uint16(len(response)))
+				c.Write(append(length[:], response...))
+			}
+			c.Close()
+		}
+	}()
+
+	var fs Forwarders
+	NoError(t, fs.Set(ln.Addr().String()))
+
+	t.Run("resolving_retries_over_tcp", func(t *testing.T) {
+		c := cache.New(&cache.Config{})
+		r := NewResolver(&Config{Forwarders: fs, Cache: c, MaxUDPSize: 1232})
+		defer r.Close()
+
+		response, err := r.Resolve(context.Background(), "www.example.com", dns.AQueryType)
+		if !NoError(t, err) {
+			return
+		}
+		False(t, response.Header.TruncatedMessage)
+		Len(t, response.Answers, 1)
+		Equal(t, uint16(1232), <-advertised)
+
+		cached, ok := c.Get("www.example.com", dns.AQueryType, nil, time.Now())
+		if True(t, ok) {
+			False(t, cached.Header.TruncatedMessage)
+			Len(t, cached.Answers, 1)
+		}
+	})
+
+	t.Run("relaying_retries_over_tcp", func(t *testing.T) {
+		r := NewResolver(&Config{Forwarders: fs})
+		defer r.Close()
+
+		query := dns.NewDNSPacket()
+		query.Header.ID = 4660
+		query.Questions = append(query.Questions, dns.NewDNSQuestion("www.example.com", dns.AQueryType))
+		var msg bytes.Buffer
+		query.WriteTo(&msg)
+
+		raw, err := r.Relay(context.Background(), msg.Bytes())
+		if !NoError(t, err) {
+			return
+		}
+		response, err := dns.ReadPacket(bytes.NewReader(raw))
+		if NoError(t, err) {
+			Equal(t, uint16(4660), response.Header.ID)
+			False(t, response.Header.TruncatedMessage)
+			Len(t, response.Answers, 1)
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package resolver
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Faults degrade the UDP exchanges with name servers on purpose, to check
+// how retries, response validation and timeouts cope with a bad network.
+// Rates are the fraction of messages hit, from 0 to 1. The zero value
+// leaves the network alone.
+type Faults struct {
+	// Drop loses queries before they are sent
+	Drop float64
+	// Delay holds every response back for up to this long
+	Delay time.Duration
+	// Corrupt flips a random bit of responses
+	Corrupt float64
+	// WrongID changes the id of responses, as if spoofed
+	WrongID float64
+}
+
+// ParseFaults parses faults given as comma separated settings, e.g.
+// "drop=0.1,delay=200ms,corrupt=0.05,wrong-id=0.05".
+func ParseFaults(value string) (Faults, error) {
+	var f Faults
+	for _, setting := range strings.Split(value, ",") {
+		parts := strings.SplitN(setting, "=", 2)
+		if len(parts) != 2 {
+			return Faults{}, errors.Errorf("fault %q is not NAME=VALUE", setting)
+		}
+		name, v := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+
+		if name == "delay" {
+			delay, err := time.ParseDuration(v)
+			if err != nil || delay < 0 {
+				return Faults{}, errors.Errorf("invalid fault delay %q", v)
+			}
+			f.Delay = delay
+			continue
+		}
+
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return Faults{}, errors.Errorf("fault rate %s=%s is not between 0 and 1", name, v)
+		}
+		switch name {
+		case "drop":
+			f.Drop = rate
+		case "corrupt":
+			f.Corrupt = rate
+		case "wrong-id":
+			f.WrongID = rate
+		default:
+			return Faults{}, errors.Errorf("unknown fault %q", name)
+		}
+	}
+
+	return f, nil
+}
+
+func (f *Faults) String() string {
+	var settings []string
+	for _, s := range []struct {
+		name string
+		rate float64
+	}{{"drop", f.Drop}, {"corrupt", f.Corrupt}, {"wrong-id", f.WrongID}} {
+		if s.rate > 0 {
+			settings = append(settings, s.name+"="+strconv.FormatFloat(s.rate, 'g', -1, 64))
+		}
+	}
+	if f.Delay > 0 {
+		settings = append(settings, "delay="+f.Delay.String())
+	}
+
+	return strings.Join(settings, ",")
+}
+
+// Set implements flag.Value.
+func (f *Faults) Set(value string) error {
+	parsed, err := ParseFaults(value)
+	if err != nil {
+		return err
+	}
+
+	*f = parsed
+	return nil
+}
+
+// drop reports whether a query is lost.
+func (f *Faults) drop() bool {
+	return f.Drop > 0 && rand.Float64() < f.Drop
+}
+
+// damage returns a copy of the response in msg with the faults applied, or
+// msg itself when it is left alone, and how long it is held back.
+func (f *Faults) damage(msg []byte) ([]byte, time.Duration) {
+	var delay time.Duration
+	if f.Delay > 0 {
+		delay = time.Duration(rand.Int63n(int64(f.Delay) + 1))
+	}
+
+	corrupt := f.Corrupt > 0 && rand.Float64() < f.Corrupt && len(msg) > 0
+	wrongID := f.WrongID > 0 && rand.Float64() < f.WrongID && len(msg) >= 2
+	if !corrupt && !wrongID {
+		return msg, delay
+	}
+
+	damaged := append([]byte(nil), msg...)
+	if corrupt {
+		damaged[rand.Intn(len(damaged))] ^= 1 << uint(rand.Intn(8))
+	}
+	if wrongID {
+		id := binary.BigEndian.Uint16(damaged)
+		binary.BigEndian.PutUint16(damaged, id^uint16(1+rand.Intn(0xFFFF)))
+	}
+
+	return damaged, delay
+}
@@ -0,0 +1,115 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"math/bits"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+func TestFaults(t *testing.T) {
+	// The server answers every query and counts them
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if !NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	var queries int32
+	go func() {
+		msg := make([]byte, dns.MaxMessageSize)
+		for {
+			n, client, err := conn.ReadFrom(msg)
+			if err != nil {
+				return
+			}
+			query, err := dns.ReadPacket(bytes.NewReader(msg[:n]))
+			if err != nil {
+				continue
+			}
+
+			atomic.AddInt32(&queries, 1)
+			conn.WriteTo(answerWith(query, func(q *dns.DNSQuestion) []string {
+				return []string{"www.example.com. 300 IN A 192.0.2.1"}
+			}), client)
+		}
+	}()
+	var fs Forwarders
+	NoError(t, fs.Set(conn.LocalAddr().String()))
+
+	resolve := func(faults Faults) (*dns.DNSPacket, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+
+		r := NewResolver(&Config{Forwarders: fs, Faults: faults})
+		defer r.Close()
+		return r.Resolve(ctx, "www.example.com", dns.AQueryType)
+	}
+
+	t.Run("parse", func(t *testing.T) {
+		f, err := ParseFaults("drop=0.1, delay=200ms,corrupt=0.05,wrong-id=1")
+		if NoError(t, err) {
+			Equal(t, Faults{Drop: 0.1, Delay: 200 * time.Millisecond, Corrupt: 0.05, WrongID: 1}, f)
+			Equal(t, "drop=0.1,corrupt=0.05,wrong-id=1,delay=200ms", f.String())
+		}
+
+		for _, invalid := range []string{"", "drop", "drop=2", "drop=-0.1", "delay=soon", "delay=-1s", "reorder=0.1"} {
+			_, err := ParseFaults(invalid)
+			Error(t, err, invalid)
+		}
+	})
+
+	t.Run("no_faults", func(t *testing.T) {
+		response, err := resolve(Faults{})
+		if NoError(t, err) {
+			Len(t, response.Answers, 1)
+		}
+	})
+
+	t.Run("dropped_queries_time_out", func(t *testing.T) {
+		before := atomic.LoadInt32(&queries)
+		_, err := resolve(Faults{Drop: 1})
+		Error(t, err)
+		Equal(t, before, atomic.LoadInt32(&queries))
+	})
+
+	t.Run("responses_with_wrong_ids_are_rejected", func(t *testing.T) {
+		before := atomic.LoadInt32(&queries)
+		_, err := resolve(Faults{WrongID: 1})
+		Error(t, err)
+		Greater(t, atomic.LoadInt32(&queries), before)
+	})
+
+	t.Run("delayed_responses_arrive", func(t *testing.T) {
+		response, err := resolve(Faults{Delay: 50 * time.Millisecond})
+		if NoError(t, err) {
+			Len(t, response.Answers, 1)
+		}
+	})
+
+	t.Run("damage", func(t *testing.T) {
+		msg := []byte{0x12, 0x34, 0x81, 0x80, 0, 1, 0, 1}
+
+		corrupted, delay := (&Faults{Corrupt: 1}).damage(msg)
+		Zero(t, delay)
+		flipped := 0
+		for i := range msg {
+			flipped += bits.OnesCount8(msg[i] ^ corrupted[i])
+		}
+		Equal(t, 1, flipped)
+
+		spoofed, _ := (&Faults{WrongID: 1}).damage(msg)
+		NotEqual(t, msg[:2], spoofed[:2])
+		Equal(t, msg[2:], spoofed[2:])
+		Equal(t, []byte{0x12, 0x34}, msg[:2], "the message is copied")
+
+		_, delay = (&Faults{Delay: time.Second}).damage(msg)
+		LessOrEqual(t, delay, time.Second)
+	})
+}
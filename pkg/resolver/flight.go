@@ -0,0 +1,165 @@
+package resolver
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+// flight is a resolution in progress, the callers joining it wait for its
+// result.
+type flight struct {
+	done     chan struct{}
+	response *dns.DNSPacket
+	err      error
+
+	started time.Time
+	// cancel stops the resolution once every caller gave up
+	cancel context.CancelFunc
+	wait   *waitDeadline
+	// waiters counts the callers that joined the first one, callers those
+	// still waiting, both guarded by the group
+	waiters int
+	callers int
+}
+
+// waitDeadline is the latest deadline of the callers of a flight, upstream
+// attempts share the time of the caller waiting longest.
+type waitDeadline struct {
+	mu        sync.Mutex
+	deadline  time.Time
+	unbounded bool
+}
+
+type waitDeadlineKey struct{}
+
+// join extends the deadline to the one of ctx.
+func (w *waitDeadline) join(ctx context.Context) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		w.unbounded = true
+	} else if deadline.After(w.deadline) {
+		w.deadline = deadline
+	}
+}
+
+// waitUntil returns the deadline of ctx, or of the callers waiting for the
+// flight ctx belongs to when that is sooner.
+func waitUntil(ctx context.Context) (time.Time, bool) {
+	deadline, ok := ctx.Deadline()
+
+	w, shared := ctx.Value(waitDeadlineKey{}).(*waitDeadline)
+	if !shared {
+		return deadline, ok
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.unbounded && (!ok || w.deadline.Before(deadline)) {
+		return w.deadline, true
+	}
+	return deadline, ok
+}
+
+// InFlight is a resolution in progress.
+type InFlight struct {
+	// Query is the name, type and client subnet resolved
+	Query   string
+	Started time.Time
+	// Waiters counts the callers waiting for the resolution besides the one
+	// that started it
+	Waiters int
+}
+
+// flightGroup coalesces identical resolutions running at the same time so
+// that they share one upstream resolution.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{
+		flights: make(map[string]*flight),
+	}
+}
+
+// do runs fn unless a call with the same key is already running, in which
+// case it joins that call. The call runs on a context of its own, the caller
+// that started it doesn't bound it, and every caller stops waiting when its
+// ctx is done. The call is cancelled once no caller waits for it.
+func (g *flightGroup) do(ctx context.Context, key string, fn func(ctx context.Context) (*dns.DNSPacket, error)) (*dns.DNSPacket, error) {
+	g.mu.Lock()
+	f, ok := g.flights[key]
+	if ok {
+		f.waiters++
+		f.wait.join(ctx)
+	} else {
+		f = &flight{done: make(chan struct{}), started: time.Now(), wait: &waitDeadline{}}
+		f.wait.join(ctx)
+		flightCtx, cancel := context.WithCancel(context.WithValue(context.Background(), waitDeadlineKey{}, f.wait))
+		f.cancel = cancel
+		g.flights[key] = f
+		go g.run(flightCtx, key, f, fn)
+	}
+	f.callers++
+	g.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.response, f.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		f.callers--
+		if f.callers == 0 {
+			// Callers coming later start over instead of joining a
+			// cancelled call
+			if g.flights[key] == f {
+				delete(g.flights, key)
+			}
+			f.cancel()
+		}
+		g.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (g *flightGroup) run(ctx context.Context, key string, f *flight, fn func(ctx context.Context) (*dns.DNSPacket, error)) {
+	f.response, f.err = fn(ctx)
+
+	g.mu.Lock()
+	if g.flights[key] == f {
+		delete(g.flights, key)
+	}
+	g.mu.Unlock()
+	f.cancel()
+	close(f.done)
+}
+
+// inFlight returns the resolutions in progress, the longest running first.
+func (g *flightGroup) inFlight() []InFlight {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	flights := make([]InFlight, 0, len(g.flights))
+	for key, f := range g.flights {
+		flights = append(flights, InFlight{Query: key, Started: f.started, Waiters: f.waiters})
+	}
+
+	sort.Slice(flights, func(i, j int) bool {
+		return flights[i].Started.Before(flights[j].Started)
+	})
+
+	return flights
+}
+
+// InFlight returns the resolutions in progress, the longest running first.
+func (r *Resolver) InFlight() []InFlight {
+	return r.flights.inFlight()
+}
@@ -0,0 +1,148 @@
+package resolver
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+func TestFlightGroup(t *testing.T) {
+	t.Run("concurrent_calls_share_one_resolution", func(t *testing.T) {
+		g := newFlightGroup()
+		release := make(chan struct{})
+		var calls int32
+
+		response := dns.NewDNSPacket()
+		fn := func(ctx context.Context) (*dns.DNSPacket, error) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+			return response, nil
+		}
+
+		var wg sync.WaitGroup
+		results := make([]*dns.DNSPacket, 50)
+		query := func(i int) {
+			defer wg.Done()
+			results[i], _ = g.do(context.Background(), "www.example.com/A", fn)
+		}
+
+		wg.Add(1)
+		go query(0)
+		for atomic.LoadInt32(&calls) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		// Everyone asking while the first resolution runs joins it
+		for i := 1; i < len(results); i++ {
+			wg.Add(1)
+			go query(i)
+		}
+		time.Sleep(20 * time.Millisecond)
+		if flights := g.inFlight(); Len(t, flights, 1) {
+			Equal(t, "www.example.com/A", flights[0].Query)
+			Equal(t, len(results)-1, flights[0].Waiters)
+		}
+		close(release)
+		wg.Wait()
+		Empty(t, g.inFlight())
+
+		Equal(t, int32(1), atomic.LoadInt32(&calls))
+		for _, r := range results {
+			Same(t, response, r)
+		}
+	})
+
+	t.Run("different_keys_run_separately", func(t *testing.T) {
+		g := newFlightGroup()
+		var calls int32
+		fn := func(ctx context.Context) (*dns.DNSPacket, error) {
+			atomic.AddInt32(&calls, 1)
+			return dns.NewDNSPacket(), nil
+		}
+
+		g.do(context.Background(), "www.example.com/A", fn)
+		g.do(context.Background(), "www.example.com/AAAA", fn)
+		Equal(t, int32(2), calls)
+	})
+
+	t.Run("callers_stop_waiting_when_their_context_is_done", func(t *testing.T) {
+		g := newFlightGroup()
+		release := make(chan struct{})
+		defer close(release)
+		fn := func(ctx context.Context) (*dns.DNSPacket, error) {
+			<-release
+			return dns.NewDNSPacket(), nil
+		}
+
+		go g.do(context.Background(), "www.example.com/A", fn)
+		for len(g.inFlight()) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err := g.do(ctx, "www.example.com/A", fn)
+		Equal(t, context.DeadlineExceeded, err)
+	})
+
+	t.Run("the_first_caller_giving_up_doesnt_end_the_call", func(t *testing.T) {
+		g := newFlightGroup()
+		release := make(chan struct{})
+		response := dns.NewDNSPacket()
+		fn := func(ctx context.Context) (*dns.DNSPacket, error) {
+			select {
+			case <-release:
+				return response, ctx.Err()
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		first, cancel := context.WithCancel(context.Background())
+		errs := make(chan error, 1)
+		go func() {
+			_, err := g.do(first, "www.example.com/A", fn)
+			errs <- err
+		}()
+		for len(g.inFlight()) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		results := make(chan *dns.DNSPacket, 1)
+		go func() {
+			r, _ := g.do(context.Background(), "www.example.com/A", fn)
+			results <- r
+		}()
+		for g.inFlight()[0].Waiters == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		cancel()
+		Equal(t, context.Canceled, <-errs)
+		close(release)
+		Same(t, response, <-results)
+	})
+
+	t.Run("the_call_is_cancelled_when_nobody_waits", func(t *testing.T) {
+		g := newFlightGroup()
+		cancelled := make(chan struct{})
+		fn := func(ctx context.Context) (*dns.DNSPacket, error) {
+			<-ctx.Done()
+			close(cancelled)
+			return nil, ctx.Err()
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := g.do(ctx, "www.example.com/A", fn)
+		Equal(t, context.DeadlineExceeded, err)
+		<-cancelled
+		Empty(t, g.inFlight())
+	})
+}
@@ -0,0 +1,162 @@
+package resolver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/logger"
+	"github.com/pkg/errors"
+)
+
+// unhealthyAfter is how many failures in a row mark a forwarder down.
+const unhealthyAfter = 3
+
+// UpstreamHealth is the state of a forwarder as seen by the resolver.
+type UpstreamHealth struct {
+	Addr    string
+	Healthy bool
+	// Failures counts the failed exchanges since the last success
+	Failures int
+	// LastError is the failure of the last exchange, empty after a success
+	LastError string
+	Checked   time.Time
+}
+
+// healthTracker remembers which forwarders recently failed. Both queries and
+// probes report to it, a single success brings a forwarder back.
+type healthTracker struct {
+	mu     sync.Mutex
+	states map[string]*UpstreamHealth
+}
+
+func newHealthTracker() *healthTracker {
+	return &healthTracker{states: make(map[string]*UpstreamHealth)}
+}
+
+// record notes the outcome of an exchange with addr, cancelled exchanges
+// say nothing about the upstream and are ignored.
+func (h *healthTracker) record(addr string, err error, now time.Time) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	state, ok := h.states[addr]
+	if !ok {
+		state = &UpstreamHealth{Addr: addr, Healthy: true}
+		h.states[addr] = state
+	}
+	state.Checked = now
+
+	if err == nil {
+		if !state.Healthy {
+			logger.Infof("Upstream %s is up again\n", addr)
+		}
+		state.Healthy, state.Failures, state.LastError = true, 0, ""
+		return
+	}
+
+	state.Failures++
+	state.LastError = err.Error()
+	if state.Healthy && state.Failures >= unhealthyAfter {
+		logger.Errorf("Error: upstream %s is down: %s\n", addr, err)
+		state.Healthy = false
+	}
+}
+
+func (h *healthTracker) healthy(addr string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	state, ok := h.states[addr]
+	return !ok || state.Healthy
+}
+
+func (h *healthTracker) get(addr string) UpstreamHealth {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if state, ok := h.states[addr]; ok {
+		return *state
+	}
+
+	return UpstreamHealth{Addr: addr, Healthy: true}
+}
+
+// Health returns the state of every forwarder in configuration order.
+func (r *Resolver) Health() []UpstreamHealth {
+	health := make([]UpstreamHealth, 0, len(r.forwarders))
+	for _, f := range r.forwarders {
+		health = append(health, r.health.get(f.String()))
+	}
+
+	return health
+}
+
+// orderedForwarders returns the healthy forwarders before the ones marked
+// down, each group in the given order. Down forwarders are still tried last
+// in case every forwarder is down.
+func (r *Resolver) orderedForwarders(forwarders []*Forwarder) []*Forwarder {
+	ordered := make([]*Forwarder, 0, len(forwarders))
+	down := make([]*Forwarder, 0)
+	for _, f := range forwarders {
+		if r.health.healthy(f.String()) {
+			ordered = append(ordered, f)
+		} else {
+			down = append(down, f)
+		}
+	}
+
+	return append(ordered, down...)
+}
+
+// CheckHealth probes every forwarder each interval until ctx is cancelled,
+// so that forwarders marked down are noticed when they recover without
+// sending them client queries first.
+func (r *Resolver) CheckHealth(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.ProbeForwarders(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// ProbeForwarders asks every forwarder for the root name servers and records
+// whether it answered. Any response counts, it proves the forwarder is
+// reachable.
+func (r *Resolver) ProbeForwarders(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, f := range r.forwarders {
+		wg.Add(1)
+		go func(f *Forwarder) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, upstreamTimeout)
+			defer cancel()
+
+			_, err := r.lookup(withSource(ctx, f.Source), "", dns.NSQueryType, f.UDPAddr, nil)
+			r.health.record(f.String(), err, time.Now())
+		}(f)
+	}
+	wg.Wait()
+}
+
+// ProbeRoots asks the root servers for their names until one of them
+// answers, without one recursion can't work.
+func (r *Resolver) ProbeRoots(ctx context.Context) error {
+	ctx, cancel := withBudget(ctx)
+	defer cancel()
+
+	_, err := r.lookupAny(ctx, "", dns.NSQueryType, r.orderAddrs(rootServers), nil)
+	return err
+}
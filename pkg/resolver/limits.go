@@ -0,0 +1,113 @@
+package resolver
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxUpstreamQueries bounds the queries sent to name servers while
+	// resolving one question, including the name server lookups
+	maxUpstreamQueries = 100
+	// maxNSDepth bounds how deeply name server names are resolved to reach
+	// the servers of a zone, zones whose servers live in each other would
+	// otherwise recurse forever
+	maxNSDepth = 4
+	// resolutionTimeout bounds the time spent resolving one question
+	resolutionTimeout = 10 * time.Second
+	// minAttemptTimeout is the least time an upstream gets to answer when
+	// the time left is shared between many
+	minAttemptTimeout = 200 * time.Millisecond
+)
+
+// ErrLimitExceeded is returned when resolving a question takes more work than
+// any legitimate delegation chain needs.
+var ErrLimitExceeded = errors.New("resolution limit exceeded")
+
+// budget tracks the work done for one question, it is shared by the lookups
+// of the name servers it leads to.
+type budget struct {
+	queries *int32
+	depth   int
+	// deadline is when resolutionTimeout runs out, the caller may give up
+	// sooner
+	deadline time.Time
+}
+
+type budgetKey struct{}
+
+// withBudget starts the budget of a question unless ctx already carries one.
+func withBudget(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Value(budgetKey{}).(*budget); ok {
+		return ctx, func() {}
+	}
+
+	deadline := time.Now().Add(resolutionTimeout)
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	return context.WithValue(ctx, budgetKey{}, &budget{queries: new(int32), deadline: deadline}), cancel
+}
+
+// spendQuery accounts for a query to a name server.
+func spendQuery(ctx context.Context) error {
+	b, ok := ctx.Value(budgetKey{}).(*budget)
+	if !ok {
+		return nil
+	}
+
+	if atomic.AddInt32(b.queries, 1) > maxUpstreamQueries {
+		return errors.Wrapf(ErrLimitExceeded, "more than %d upstream queries", maxUpstreamQueries)
+	}
+
+	return nil
+}
+
+// nestedLookup returns the context for resolving a name server name, one
+// level deeper than ctx.
+func nestedLookup(ctx context.Context) (context.Context, error) {
+	b, ok := ctx.Value(budgetKey{}).(*budget)
+	if !ok {
+		return ctx, nil
+	}
+
+	if b.depth >= maxNSDepth {
+		return nil, errors.Wrapf(ErrLimitExceeded, "name servers nested more than %d deep", maxNSDepth)
+	}
+
+	return context.WithValue(ctx, budgetKey{}, &budget{queries: b.queries, depth: b.depth + 1, deadline: b.deadline}), nil
+}
+
+// attempt returns the context for one of n upstream attempts left, it gets
+// an equal share of the time left so that a silent server leaves time to
+// ask the others, but no more than upstreamTimeout.
+func attempt(ctx context.Context, n int) (context.Context, context.CancelFunc) {
+	timeout := upstreamTimeout
+	if deadline, ok := waitUntil(ctx); ok && n > 1 {
+		if share := time.Until(deadline) / time.Duration(n); share < timeout {
+			timeout = share
+		}
+	}
+	if timeout < minAttemptTimeout {
+		timeout = minAttemptTimeout
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// stopped returns why the resolution in ctx must stop, if it must. Running
+// out of resolutionTimeout is a limit like the others, a cancelled or
+// expired caller's context is returned as is.
+func stopped(ctx context.Context) error {
+	err := ctx.Err()
+	if err != context.DeadlineExceeded {
+		return err
+	}
+
+	if b, ok := ctx.Value(budgetKey{}).(*budget); ok && !time.Now().Before(b.deadline) {
+		return errors.Wrapf(ErrLimitExceeded, "resolution took longer than %s", resolutionTimeout)
+	}
+
+	return err
+}
@@ -0,0 +1,50 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/pkg/errors"
+)
+
+func TestBudget(t *testing.T) {
+	t.Run("upstream_queries_are_bounded", func(t *testing.T) {
+		ctx, cancel := withBudget(context.Background())
+		defer cancel()
+
+		for i := 0; i < maxUpstreamQueries; i++ {
+			NoError(t, spendQuery(ctx))
+		}
+
+		// Name server lookups draw from the same budget
+		nested, err := nestedLookup(ctx)
+		NoError(t, err)
+		True(t, errors.Is(spendQuery(nested), ErrLimitExceeded))
+	})
+
+	t.Run("name_server_nesting_is_bounded", func(t *testing.T) {
+		ctx, cancel := withBudget(context.Background())
+		defer cancel()
+
+		var err error
+		for i := 0; i < maxNSDepth; i++ {
+			ctx, err = nestedLookup(ctx)
+			NoError(t, err)
+		}
+
+		_, err = nestedLookup(ctx)
+		True(t, errors.Is(err, ErrLimitExceeded))
+	})
+
+	t.Run("nested_questions_keep_the_budget", func(t *testing.T) {
+		ctx, cancel := withBudget(context.Background())
+		defer cancel()
+		NoError(t, spendQuery(ctx))
+
+		same, cancelSame := withBudget(ctx)
+		defer cancelSame()
+		Equal(t, ctx, same)
+	})
+}
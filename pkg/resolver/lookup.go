@@ -0,0 +1,110 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/pkg/errors"
+)
+
+// LookupHost returns the IPv4 and IPv6 addresses of host.
+func (r *Resolver) LookupHost(ctx context.Context, host string) ([]net.IP, error) {
+	addrs := make([]net.IP, 0)
+	for _, qtype := range []dns.QueryType{dns.AQueryType, dns.AAAAQueryType} {
+		records, err := r.lookupType(ctx, host, qtype)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, record := range records {
+			addrs = append(addrs, record.Addr)
+		}
+	}
+
+	if len(addrs) == 0 {
+		return nil, errors.Errorf("no addresses found for %s", host)
+	}
+
+	return addrs, nil
+}
+
+// HostResult is the outcome of one of the address queries of
+// LookupHostParallel.
+type HostResult struct {
+	QType dns.QueryType
+	Addrs []net.IP
+	Err   error
+}
+
+// LookupHostParallel queries the AAAA and A records of host at the same time
+// and sends each result as soon as it arrives, so that callers can start
+// connecting before the slower family answers (RFC8305). The channel is
+// closed after both results were sent.
+func (r *Resolver) LookupHostParallel(ctx context.Context, host string) <-chan HostResult {
+	qtypes := []dns.QueryType{dns.AAAAQueryType, dns.AQueryType}
+	results := make(chan HostResult, len(qtypes))
+
+	var wg sync.WaitGroup
+	for _, qtype := range qtypes {
+		wg.Add(1)
+		go func(qtype dns.QueryType) {
+			defer wg.Done()
+
+			result := HostResult{QType: qtype}
+			records, err := r.lookupType(ctx, host, qtype)
+			if err != nil {
+				result.Err = err
+			}
+			for _, record := range records {
+				result.Addrs = append(result.Addrs, record.Addr)
+			}
+			results <- result
+		}(qtype)
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
+
+// LookupMX returns the mail servers of name sorted by priority.
+func (r *Resolver) LookupMX(ctx context.Context, name string) ([]*dns.DNSRecord, error) {
+	records, err := r.lookupType(ctx, name, dns.MXQueryType)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Priority < records[j].Priority
+	})
+
+	return records, nil
+}
+
+// lookupType resolves name and returns only the answers of the requested
+// type, dropping the CNAME records that led to them.
+func (r *Resolver) lookupType(ctx context.Context, name string, qtype dns.QueryType) ([]*dns.DNSRecord, error) {
+	response, err := r.Resolve(ctx, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := response.Err(); err != nil {
+		return nil, errors.Wrapf(err, "resolving %s %s", qtype, name)
+	}
+
+	records := make([]*dns.DNSRecord, 0)
+	for _, record := range response.Answers {
+		if record.QType == qtype {
+			records = append(records, record)
+		}
+	}
+
+	return records, nil
+}
@@ -0,0 +1,55 @@
+package resolver
+
+import (
+	"context"
+	"sync"
+
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+// defaultMaxParallel is how many questions ResolveMany resolves at the same
+// time unless configured otherwise.
+const defaultMaxParallel = 16
+
+// Result is the outcome of resolving one of the questions of ResolveMany.
+type Result struct {
+	Question *dns.DNSQuestion
+	Response *dns.DNSPacket
+	Err      error
+}
+
+// ResolveMany resolves the questions concurrently, at most MaxParallel at a
+// time, and returns their results in the order of the questions. They share
+// the cache, so repeated questions are resolved once. Questions not started
+// when ctx is done fail with its error.
+func (r *Resolver) ResolveMany(ctx context.Context, questions []*dns.DNSQuestion) []*Result {
+	results := make([]*Result, len(questions))
+	slots := make(chan struct{}, r.maxParallel)
+
+	var wg sync.WaitGroup
+	for i, q := range questions {
+		results[i] = &Result{Question: q}
+		if err := ctx.Err(); err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+			continue
+		}
+
+		wg.Add(1)
+		go func(result *Result) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			result.Response, result.Err = r.Resolve(ctx, result.Question.Name.String(), result.Question.QType)
+		}(results[i])
+	}
+	wg.Wait()
+
+	return results
+}
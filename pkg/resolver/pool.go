@@ -0,0 +1,272 @@
+package resolver
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/msarvar/godns/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	// socketsPerUpstream bounds the UDP sockets kept open to a name server,
+	// queries are spread over them
+	socketsPerUpstream = 4
+	// maxSocketQueries retires a socket after that many queries so that the
+	// source ports keep changing like with a socket per query
+	maxSocketQueries = 100
+	// socketIdleTimeout closes sockets that haven't been used for a while,
+	// iterative resolution talks to many servers only once
+	socketIdleTimeout = 30 * time.Second
+	// pendingResponses is how many datagrams with its id a query buffers,
+	// more are dropped until it reads them
+	pendingResponses = 4
+)
+
+// errSocketClosed is returned to queries waiting on a socket that failed.
+var errSocketClosed = errors.New("upstream socket closed")
+
+// socketPool keeps long-lived UDP sockets per name server. Queries sharing a
+// socket are told apart by their id, a reader per socket hands every response
+// to the query waiting for it.
+type socketPool struct {
+	mu        sync.Mutex
+	sockets   map[string][]*pooledSocket
+	lastSweep time.Time
+}
+
+func newSocketPool() *socketPool {
+	return &socketPool{
+		sockets: make(map[string][]*pooledSocket),
+	}
+}
+
+// pooledSocket is a UDP socket connected to one name server.
+type pooledSocket struct {
+	conn *net.UDPConn
+
+	mu       sync.Mutex
+	pending  map[uint16]chan []byte
+	queries  int
+	lastUsed time.Time
+	closed   bool
+}
+
+// acquire returns a socket to the server from source, nil for any address,
+// and a query id unused on it, the
+// response to that id is sent on the channel. The id must be released once
+// the exchange is over. New sockets read datagrams of up to size bytes.
+func (p *socketPool) acquire(remote *net.UDPAddr, source *Source, size int) (*pooledSocket, uint16, chan []byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	p.sweep(now)
+
+	key := remote.String()
+	if source != nil {
+		key += "@" + source.String()
+	}
+	var socket *pooledSocket
+	for _, s := range p.sockets[key] {
+		if s.usable() && (socket == nil || s.load() < socket.load()) {
+			socket = s
+		}
+	}
+
+	if socket == nil || (socket.load() > 0 && p.count(key) < socketsPerUpstream) {
+		conn, err := dialUpstream(remote, source)
+		if err != nil {
+			return nil, 0, nil, errors.Wrap(err, "creating UDP connection")
+		}
+
+		socket = &pooledSocket{
+			conn:     conn,
+			pending:  make(map[uint16]chan []byte),
+			lastUsed: now,
+		}
+		p.sockets[key] = append(p.sockets[key], socket)
+		go socket.read(size)
+	}
+
+	id, responses, err := socket.register(now)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	return socket, id, responses, nil
+}
+
+// count returns how many sockets to the server still take queries.
+func (p *socketPool) count(key string) int {
+	n := 0
+	for _, s := range p.sockets[key] {
+		if s.usable() {
+			n++
+		}
+	}
+
+	return n
+}
+
+// sweep closes the sockets that were retired or left idle and have no query
+// waiting, at most once a second as it walks every socket.
+func (p *socketPool) sweep(now time.Time) {
+	if now.Sub(p.lastSweep) < time.Second {
+		return
+	}
+	p.lastSweep = now
+
+	for key, sockets := range p.sockets {
+		kept := sockets[:0]
+		for _, s := range sockets {
+			if !s.closeIfDone(now) {
+				kept = append(kept, s)
+			}
+		}
+
+		if len(kept) == 0 {
+			delete(p.sockets, key)
+		} else {
+			p.sockets[key] = kept
+		}
+	}
+}
+
+// Close closes every socket, queries waiting on them fail.
+func (p *socketPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, sockets := range p.sockets {
+		for _, s := range sockets {
+			s.conn.Close()
+		}
+		delete(p.sockets, key)
+	}
+}
+
+func (s *pooledSocket) usable() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return !s.closed && s.queries < maxSocketQueries
+}
+
+// load is the number of queries waiting on the socket.
+func (s *pooledSocket) load() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.pending)
+}
+
+// register picks a random id that no query on the socket is waiting for.
+func (s *pooledSocket) register(now time.Time) (uint16, chan []byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, nil, errSocketClosed
+	}
+
+	for {
+		id, err := utils.RandomUint16()
+		if err != nil {
+			return 0, nil, errors.Wrap(err, "generating query id")
+		}
+
+		if _, ok := s.pending[id]; ok {
+			continue
+		}
+
+		// Spoofed datagrams with the id may arrive before the response
+		responses := make(chan []byte, pendingResponses)
+		s.pending[id] = responses
+		s.queries++
+		s.lastUsed = now
+		return id, responses, nil
+	}
+}
+
+// release forgets the query, later responses with its id are dropped.
+func (s *pooledSocket) release(id uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.pending, id)
+}
+
+// closeIfDone closes the socket when it is retired or idle and no query waits
+// on it.
+func (s *pooledSocket) closeIfDone(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return true
+	}
+
+	if len(s.pending) > 0 {
+		return false
+	}
+
+	if s.queries < maxSocketQueries && now.Sub(s.lastUsed) < socketIdleTimeout {
+		return false
+	}
+
+	s.closed = true
+	s.conn.Close()
+	return true
+}
+
+func (s *pooledSocket) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+func (s *pooledSocket) Write(msg []byte) error {
+	_, err := s.conn.Write(msg)
+	return err
+}
+
+// read hands every datagram of up to size bytes to the query waiting for its
+// id until the socket is closed, then fails the queries still waiting.
+func (s *pooledSocket) read(size int) {
+	buf := make([]byte, size)
+	for {
+		n, err := s.conn.Read(buf)
+		if err != nil {
+			// An ICMP port unreachable fails the read as well, every query
+			// to the server then fails at once instead of timing out
+			break
+		}
+
+		if n < 2 {
+			continue
+		}
+
+		s.mu.Lock()
+		responses, ok := s.pending[binary.BigEndian.Uint16(buf)]
+		if ok {
+			msg := make([]byte, n)
+			copy(msg, buf[:n])
+			select {
+			case responses <- msg:
+			default:
+			}
+		}
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	s.conn.Close()
+	for id, responses := range s.pending {
+		close(responses)
+		delete(s.pending, id)
+	}
+}
@@ -0,0 +1,134 @@
+package resolver
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+// echoServer returns every datagram to its sender and records the source
+// addresses it saw.
+type echoServer struct {
+	conn net.PacketConn
+
+	mu      sync.Mutex
+	sources map[string]bool
+}
+
+func newEchoServer(t *testing.T) *echoServer {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &echoServer{conn: conn, sources: make(map[string]bool)}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			s.mu.Lock()
+			s.sources[addr.String()] = true
+			s.mu.Unlock()
+
+			conn.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	return s
+}
+
+func (s *echoServer) addr() *net.UDPAddr {
+	return s.conn.LocalAddr().(*net.UDPAddr)
+}
+
+func TestSocketPool(t *testing.T) {
+	t.Run("responses_reach_their_query", func(t *testing.T) {
+		server := newEchoServer(t)
+		defer server.conn.Close()
+
+		pool := newSocketPool()
+		defer pool.Close()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+
+				socket, id, responses, err := pool.acquire(server.addr(), nil, 512)
+				if !NoError(t, err) {
+					return
+				}
+				defer socket.release(id)
+
+				msg := make([]byte, 3)
+				binary.BigEndian.PutUint16(msg, id)
+				msg[2] = byte(i)
+				NoError(t, socket.Write(msg))
+
+				select {
+				case response := <-responses:
+					Equal(t, msg, response)
+				case <-time.After(time.Second):
+					Fail(t, "no response")
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		LessOrEqual(t, len(server.sources), socketsPerUpstream)
+	})
+
+	t.Run("sockets_are_retired", func(t *testing.T) {
+		server := newEchoServer(t)
+		defer server.conn.Close()
+
+		pool := newSocketPool()
+		defer pool.Close()
+
+		first, id, _, err := pool.acquire(server.addr(), nil, 512)
+		NoError(t, err)
+		first.release(id)
+
+		for i := 1; i < maxSocketQueries; i++ {
+			socket, id, _, err := pool.acquire(server.addr(), nil, 512)
+			NoError(t, err)
+			Same(t, first, socket)
+			socket.release(id)
+		}
+
+		socket, id, _, err := pool.acquire(server.addr(), nil, 512)
+		NoError(t, err)
+		NotSame(t, first, socket)
+		socket.release(id)
+	})
+
+	t.Run("closed_socket_fails_waiting_queries", func(t *testing.T) {
+		server := newEchoServer(t)
+		defer server.conn.Close()
+
+		pool := newSocketPool()
+		socket, id, responses, err := pool.acquire(server.addr(), nil, 512)
+		NoError(t, err)
+		defer socket.release(id)
+
+		pool.Close()
+
+		select {
+		case _, ok := <-responses:
+			False(t, ok)
+		case <-time.After(time.Second):
+			Fail(t, "query still waiting")
+		}
+	})
+}
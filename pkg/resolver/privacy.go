@@ -0,0 +1,27 @@
+package resolver
+
+import (
+	"context"
+
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/pkg/errors"
+)
+
+// encrypted returns the transport of a forwarder reached through exchanger
+// at addr, falling back to plain DNS at plain unless privacy is strict.
+func (r *Resolver) encrypted(exchanger UpstreamExchanger, addr, plain string) UpstreamExchanger {
+	e := &stampedExchanger{exchanger: exchanger, addr: addr}
+	if r.privacy == PrivacyOpportunistic {
+		e.fallback, e.plain = r.exchanger, plain
+	}
+
+	return e
+}
+
+// refusedExchanger stands in for plain DNS to forwarders in strict privacy
+// mode, until discovery finds them an encrypted transport.
+type refusedExchanger struct{}
+
+func (refusedExchanger) Exchange(_ context.Context, _ *dns.DNSPacket, addr string) (*dns.DNSPacket, error) {
+	return nil, errors.Errorf("strict privacy refuses plain DNS to %s", addr)
+}
@@ -0,0 +1,69 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+func TestPrivacy(t *testing.T) {
+	doh, roots := serveDoH(t)
+
+	discovered := func(fs Forwarders, privacy Privacy) *Resolver {
+		r := NewResolver(&Config{Forwarders: fs, Privacy: privacy})
+		r.rootCAs = roots
+		r.DiscoverDesignated(context.Background())
+		return r
+	}
+
+	t.Run("strict_refuses_plain_forwarders", func(t *testing.T) {
+		r := NewResolver(&Config{Forwarders: serveDesignations(t, "127.0.0.1"), Privacy: PrivacyStrict})
+		_, err := r.Resolve(context.Background(), "www.example.com", dns.AQueryType)
+		Error(t, err)
+	})
+
+	t.Run("strict_uses_discovered_transports", func(t *testing.T) {
+		r := discovered(serveDesignations(t, "127.0.0.1", designatingDoH(doh)), PrivacyStrict)
+		response, err := r.Resolve(context.Background(), "www.example.com", dns.AQueryType)
+		if NoError(t, err) && Len(t, response.Answers, 1) {
+			Equal(t, "192.0.2.2", response.Answers[0].Addr.String())
+		}
+	})
+
+	t.Run("strict_leaves_forwarders_without_designations_unused", func(t *testing.T) {
+		r := discovered(serveDesignations(t, "127.0.0.1"), PrivacyStrict)
+		_, err := r.Resolve(context.Background(), "www.example.com", dns.AQueryType)
+		Error(t, err)
+	})
+
+	t.Run("fallback_to_plain_dns", func(t *testing.T) {
+		down, downRoots := serveDoH(t)
+		fs := serveDesignations(t, "127.0.0.1", designatingDoH(down))
+
+		opportunistic := NewResolver(&Config{Forwarders: fs})
+		strict := NewResolver(&Config{Forwarders: fs, Privacy: PrivacyStrict})
+		for _, r := range []*Resolver{opportunistic, strict} {
+			r.rootCAs = downRoots
+			r.DiscoverDesignated(context.Background())
+		}
+		down.Close()
+
+		response, err := opportunistic.Resolve(context.Background(), "www.example.com", dns.AQueryType)
+		if NoError(t, err) && Len(t, response.Answers, 1) {
+			Equal(t, "192.0.2.1", response.Answers[0].Addr.String())
+		}
+
+		_, err = strict.Resolve(context.Background(), "www.example.com", dns.AQueryType)
+		Error(t, err)
+	})
+
+	t.Run("parses_modes", func(t *testing.T) {
+		var p Privacy
+		NoError(t, p.Set("strict"))
+		Equal(t, "strict", p.String())
+		Error(t, p.Set("paranoid"))
+	})
+}
@@ -0,0 +1,52 @@
+package resolver
+
+import (
+	"context"
+	"time"
+
+	"github.com/msarvar/godns/pkg/logger"
+	"github.com/pkg/errors"
+)
+
+// RawExchanger is implemented by exchangers that can relay a message without
+// decoding and encoding it again.
+type RawExchanger interface {
+	ExchangeRaw(ctx context.Context, msg []byte, addr string) ([]byte, error)
+}
+
+// Relay passes the client query msg to the forwarders unmodified and returns
+// the first answer as received, under the id of the query. Options and
+// records godns doesn't understand survive the round trip, nothing is cached.
+func (r *Resolver) Relay(ctx context.Context, msg []byte) ([]byte, error) {
+	if len(r.forwarders) == 0 {
+		return nil, errors.New("relaying needs forwarders")
+	}
+
+	var err error
+	ordered := r.orderedForwarders(r.forwarders)
+	for i, forwarder := range ordered {
+		var response []byte
+		started := time.Now()
+		raw, ok := r.exchangerFor(forwarder.String()).(RawExchanger)
+		if !ok {
+			return nil, errors.Errorf("transport to %s can't relay messages", forwarder)
+		}
+
+		attemptCtx, cancel := attempt(ctx, len(ordered)-i)
+		response, err = raw.ExchangeRaw(withSource(attemptCtx, forwarder.Source), msg, forwarder.String())
+		cancel()
+		// The caller gave up, that says nothing about the forwarder
+		if err != nil && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		r.health.record(forwarder.String(), err, time.Now())
+		if err == nil {
+			r.rtts.record(forwarder.String(), time.Since(started), time.Now())
+			return response, nil
+		}
+
+		logger.Debugf("Relaying to %s failed: %s\n", forwarder, err)
+	}
+
+	return nil, &unreachableError{last: err}
+}
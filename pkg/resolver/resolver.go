@@ -0,0 +1,782 @@
+package resolver
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/cache"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/idna"
+	"github.com/msarvar/godns/pkg/logger"
+	"github.com/msarvar/godns/pkg/pcap"
+	"github.com/msarvar/godns/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+// maxPortAttempts bounds how many random source ports are tried before letting
+// the kernel pick one.
+const maxPortAttempts = 10
+
+// upstreamTimeout bounds a single exchange with a name server so that an
+// unreachable server doesn't block trying the next one.
+const upstreamTimeout = 3 * time.Second
+
+// maxCNAMEChain bounds how many aliases Resolve follows.
+const maxCNAMEChain = 8
+
+// rootServers are the addresses of a.root-servers.net, see config/named.root
+var rootServers = []net.IP{
+	net.ParseIP("198.41.0.4"),
+	net.ParseIP("2001:503:ba3e::2:30"),
+}
+
+// Resolver performs iterative resolution starting from the root servers or
+// forwards queries to other resolvers. It is safe for concurrent use.
+type Resolver struct {
+	cookies    *cookieJar
+	preference AddressPreference
+	// ipv6 is set when the host has a route to IPv6 name servers
+	ipv6 bool
+	// captureDir receives a copy of every upstream message when set
+	captureDir string
+	pcap       *pcap.Writer
+	cache      cache.Cache
+	flights    *flightGroup
+	exchanger  UpstreamExchanger
+	// transports carry the queries to the stamped and upgraded forwarders
+	// by address, upgrades are discovered while queries run
+	transportsMu sync.RWMutex
+	transports   map[string]UpstreamExchanger
+	// rootCAs verify designated resolvers, the system roots when nil
+	rootCAs *x509.CertPool
+	// forwarders replace iterative resolution when set
+	forwarders  []*Forwarder
+	strategies  []*Strategy
+	privacy     Privacy
+	health      *healthTracker
+	rtts        *rttTable
+	maxParallel int
+	// minTTL and maxTTL bound upstream TTLs in seconds
+	minTTL uint32
+	maxTTL uint32
+	// udpSize is the payload size advertised to name servers
+	udpSize uint16
+}
+
+func NewResolver(cfg *Config) *Resolver {
+	maxParallel := cfg.MaxParallel
+	if maxParallel <= 0 {
+		maxParallel = defaultMaxParallel
+	}
+	udpSize := cfg.MaxUDPSize
+	if udpSize < dns.DefaultUDPPayloadSize {
+		udpSize = dns.DefaultUDPPayloadSize
+	}
+
+	r := &Resolver{
+		cookies:     newCookieJar(),
+		preference:  cfg.AddressPreference,
+		ipv6:        hasIPv6Route(),
+		captureDir:  cfg.CaptureDir,
+		pcap:        cfg.Pcap,
+		cache:       cfg.Cache,
+		flights:     newFlightGroup(),
+		forwarders:  cfg.Forwarders,
+		strategies:  cfg.Strategies,
+		privacy:     cfg.Privacy,
+		health:      newHealthTracker(),
+		rtts:        newRTTTable(),
+		maxParallel: maxParallel,
+		exchanger:   cfg.Exchanger,
+		transports:  make(map[string]UpstreamExchanger),
+		minTTL:      uint32(cfg.MinTTL / time.Second),
+		maxTTL:      uint32(cfg.MaxTTL / time.Second),
+		udpSize:     udpSize,
+	}
+
+	if r.exchanger == nil {
+		udp := NewUDPExchanger()
+		udp.Capture = r.captureMessage
+		udp.Faults = cfg.Faults
+		udp.MaxUDPSize = udpSize
+		r.exchanger = udp
+	}
+
+	for _, f := range r.forwarders {
+		var exchanger UpstreamExchanger
+		var addr string
+		if f.Stamp != nil {
+			var err error
+			exchanger, addr, err = f.Stamp.exchanger()
+			if err != nil {
+				logger.Errorf("Error: forwarder %s: %s\n", f, err)
+				continue
+			}
+		}
+
+		switch {
+		case exchanger != nil:
+			r.transports[f.String()] = r.encrypted(exchanger, addr, net.JoinHostPort(f.IP.String(), "53"))
+		case r.privacy == PrivacyStrict:
+			r.transports[f.String()] = refusedExchanger{}
+		}
+	}
+
+	return r
+}
+
+// exchangerFor returns the transport to the name server at addr.
+func (r *Resolver) exchangerFor(addr string) UpstreamExchanger {
+	r.transportsMu.RLock()
+	defer r.transportsMu.RUnlock()
+
+	if e, ok := r.transports[addr]; ok {
+		return e
+	}
+
+	return r.exchanger
+}
+
+// Close closes the sockets kept open to the upstream name servers, resolving
+// afterwards opens new ones.
+func (r *Resolver) Close() error {
+	if c, ok := r.exchanger.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+// Resolve resolves the name recursively and returns the final response.
+// Unicode names are converted to their A-label form first.
+// CNAME answers are followed when the record type asked for is not CNAME, the
+// answer section then holds the whole chain.
+func (r *Resolver) Resolve(ctx context.Context, name string, qtype dns.QueryType) (*dns.DNSPacket, error) {
+	return r.ResolveSubnet(ctx, name, qtype, nil)
+}
+
+// ResolveSubnet is Resolve passing the client subnet on to the name servers
+// of the name (RFC7871), the servers delegating to them never see it. Cached
+// answers are scoped to the subnet the name server reports.
+func (r *Resolver) ResolveSubnet(ctx context.Context, name string, qtype dns.QueryType, ecs *dns.ClientSubnet) (*dns.DNSPacket, error) {
+	name, err := idna.ToASCII(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "converting name to ascii")
+	}
+
+	if r.cache != nil {
+		if cached, ok := r.cache.Get(name, qtype, ecs, time.Now()); ok {
+			if hit, ok := ctx.Value(cacheReportKey{}).(*bool); ok {
+				*hit = true
+			}
+			if p, ok := r.cache.(cache.Prefetcher); ok && p.Prefetch(name, qtype, ecs, time.Now()) {
+				go r.prefetch(name, qtype, ecs)
+			}
+			return cached, nil
+		}
+	}
+
+	response, err := r.resolveShared(ctx, name, qtype, ecs)
+
+	// An expired answer beats no answer when the upstreams fail (RFC8767)
+	if sc, ok := r.cache.(cache.StaleCache); ok && (err != nil || response.Header.ResCode == dns.ServFail) {
+		if stale, ok := sc.GetStale(name, qtype, ecs, time.Now()); ok {
+			logger.Infof("Serving stale answer for %s %s\n", qtype, name)
+			stale.AddExtendedError(&dns.ExtendedError{Code: dns.EDEStaleAnswer})
+			return stale, nil
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// A truncated response isn't the whole answer, it is never cached
+	if r.cache != nil && !response.Header.TruncatedMessage {
+		r.cache.Put(name, qtype, ecs, response, time.Now())
+	}
+
+	return response, nil
+}
+
+// Cached returns the cached response to the question without resolving it,
+// for clients that don't want recursion.
+func (r *Resolver) Cached(name string, qtype dns.QueryType, ecs *dns.ClientSubnet) (*dns.DNSPacket, bool) {
+	if r.cache == nil {
+		return nil, false
+	}
+
+	name, err := idna.ToASCII(name)
+	if err != nil {
+		return nil, false
+	}
+
+	return r.cache.Get(name, qtype, ecs, time.Now())
+}
+
+type cacheReportKey struct{}
+
+// WithCacheReport returns a context making ResolveSubnet set *hit when it
+// answers from the cache.
+func WithCacheReport(ctx context.Context, hit *bool) context.Context {
+	return context.WithValue(ctx, cacheReportKey{}, hit)
+}
+
+// prefetch refreshes a popular cache entry before it expires so that its
+// clients never wait for the resolution.
+func (r *Resolver) prefetch(name string, qtype dns.QueryType, ecs *dns.ClientSubnet) {
+	logger.Debugf("Prefetching %s %s\n", qtype, name)
+
+	response, err := r.resolveShared(context.Background(), name, qtype, ecs)
+	if err != nil {
+		logger.Errorf("Error: prefetching %s %s failed: %s\n", qtype, name, err)
+		return
+	}
+
+	if !response.Header.TruncatedMessage {
+		r.cache.Put(name, qtype, ecs, response, time.Now())
+	}
+}
+
+// resolveShared joins a running resolution of the same question instead of
+// starting another one. Callers share the response and must not modify it.
+// The resolution has a budget of its own, ctx only bounds how long the
+// caller waits for it.
+func (r *Resolver) resolveShared(ctx context.Context, name string, qtype dns.QueryType, ecs *dns.ClientSubnet) (*dns.DNSPacket, error) {
+	key := buffer.NewDomainName(name).Normalized() + "/" + qtype.String()
+	if ecs != nil {
+		key += "/" + ecs.String()
+	}
+
+	return r.flights.do(ctx, key, func(ctx context.Context) (*dns.DNSPacket, error) {
+		return r.resolve(ctx, name, qtype, ecs)
+	})
+}
+
+func (r *Resolver) resolve(ctx context.Context, name string, qtype dns.QueryType, ecs *dns.ClientSubnet) (*dns.DNSPacket, error) {
+	ctx, cancel := withBudget(ctx)
+	defer cancel()
+
+	response, err := r.lookupName(ctx, name, qtype, ecs)
+	if err != nil {
+		return nil, err
+	}
+
+	answers := response.Answers
+	for i := 0; i < maxCNAMEChain && qtype != dns.CNAMEQueryType; i++ {
+		target := cnameTarget(answers, name, qtype)
+		if target == "" {
+			break
+		}
+
+		next, err := r.lookupName(ctx, target, qtype, ecs)
+		if err != nil {
+			return nil, errors.Wrapf(err, "following cname to %s", target)
+		}
+
+		response.Header.ResCode = next.Header.ResCode
+		response.Answers = append(response.Answers, next.Answers...)
+		response.Authorities = next.Authorities
+		response.Resources = next.Resources
+
+		name, answers = target, next.Answers
+	}
+
+	return response, nil
+}
+
+// cnameTarget follows the alias chain for name inside answers and returns the
+// last alias when no record of the requested type terminates the chain.
+func cnameTarget(answers []*dns.DNSRecord, name string, qtype dns.QueryType) string {
+	target := ""
+	for i := 0; i < maxCNAMEChain; i++ {
+		if hasType(answers, name, qtype) {
+			return ""
+		}
+
+		next := ""
+		for _, a := range answers {
+			if a.QType == dns.CNAMEQueryType && a.Domain.Equal(buffer.NewDomainName(name)) {
+				next = a.Host.String()
+			}
+		}
+
+		if next == "" {
+			return target
+		}
+		target, name = next, next
+	}
+
+	return target
+}
+
+func hasType(answers []*dns.DNSRecord, name string, qtype dns.QueryType) bool {
+	for _, a := range answers {
+		if a.QType == qtype && a.Domain.Equal(buffer.NewDomainName(name)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasIPv6Route checks for IPv6 connectivity. Dialing UDP doesn't send any
+// packets but fails when there is no route to the destination.
+func hasIPv6Route() bool {
+	conn, err := net.DialUDP("udp6", nil, &net.UDPAddr{IP: rootServers[1], Port: 53})
+	if err != nil {
+		return false
+	}
+	conn.Close()
+
+	return true
+}
+
+// orderAddrs drops unreachable IPv6 addresses and orders the rest by the
+// configured address family preference.
+func (r *Resolver) orderAddrs(addrs []net.IP) []net.IP {
+	v4 := make([]net.IP, 0, len(addrs))
+	v6 := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		if addr.To4() != nil {
+			v4 = append(v4, addr)
+		} else if r.ipv6 {
+			v6 = append(v6, addr)
+		}
+	}
+
+	switch r.preference {
+	case PreferIPv6:
+		return append(v6, v4...)
+	case DualStack:
+		ordered := make([]net.IP, 0, len(v4)+len(v6))
+		for i := 0; i < len(v4) || i < len(v6); i++ {
+			if i < len(v6) {
+				ordered = append(ordered, v6[i])
+			}
+			if i < len(v4) {
+				ordered = append(ordered, v4[i])
+			}
+		}
+		return ordered
+	default:
+		return append(v4, v6...)
+	}
+}
+
+// ErrNoReachableServers is returned when none of the name servers of a zone
+// answered.
+var ErrNoReachableServers = errors.New("no reachable name servers")
+
+// unreachableError is ErrNoReachableServers carrying the failure of the last
+// name server tried.
+type unreachableError struct {
+	last error
+}
+
+func (e *unreachableError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrNoReachableServers, e.last)
+}
+
+func (e *unreachableError) Is(target error) bool {
+	return target == ErrNoReachableServers
+}
+
+func (e *unreachableError) Unwrap() error {
+	return e.last
+}
+
+// lookupAny queries the name servers in order until one of them responds.
+func (r *Resolver) lookupAny(ctx context.Context, qname string, qtype dns.QueryType, servers []net.IP, ecs *dns.ClientSubnet) (*dns.DNSPacket, error) {
+	if len(servers) == 0 {
+		return nil, ErrNoReachableServers
+	}
+
+	var err error
+	for i, server := range servers {
+		if err := spendQuery(ctx); err != nil {
+			return nil, err
+		}
+
+		var response *dns.DNSPacket
+		attemptCtx, cancel := attempt(ctx, len(servers)-i)
+		response, err = r.lookup(attemptCtx, qname, qtype, &net.UDPAddr{IP: server, Port: 53}, ecs)
+		cancel()
+		if err == nil {
+			return response, nil
+		}
+		// The other servers would fail the same way
+		if err := stopped(ctx); err != nil {
+			return nil, err
+		}
+
+		logger.Debugf("Lookup of %s with ns %s failed: %s\n", qname, server, err)
+	}
+
+	return nil, &unreachableError{last: err}
+}
+
+// forward asks the forwarders in order to resolve the name for us, the first
+// one responding answers. Forwarders marked down are asked last.
+func (r *Resolver) forward(ctx context.Context, forwarders []*Forwarder, qname string, qtype dns.QueryType, ecs *dns.ClientSubnet) (*dns.DNSPacket, error) {
+	var err error
+	ordered := r.orderedForwarders(forwarders)
+	for i, forwarder := range ordered {
+		var response *dns.DNSPacket
+		attemptCtx, cancel := attempt(ctx, len(ordered)-i)
+		response, err = r.lookup(withSource(attemptCtx, forwarder.Source), qname, qtype, forwarder.UDPAddr, ecs)
+		cancel()
+		// The caller gave up, that says nothing about the forwarder
+		if err != nil && ctx.Err() != nil {
+			return nil, stopped(ctx)
+		}
+		r.health.record(forwarder.String(), err, time.Now())
+		if err == nil {
+			// Forwarders answer for every zone
+			r.sanitize(response, "")
+			return response, nil
+		}
+
+		logger.Debugf("Forwarding %s to %s failed: %s\n", qname, forwarder, err)
+	}
+
+	return nil, &unreachableError{last: err}
+}
+
+// lookupName resolves the name as the strategy for its domain says when there
+// is one, through the forwarders when there are any and iteratively from the
+// root otherwise.
+func (r *Resolver) lookupName(ctx context.Context, qname string, qtype dns.QueryType, ecs *dns.ClientSubnet) (*dns.DNSPacket, error) {
+	if strategy := r.strategyFor(qname); strategy != nil {
+		return r.lookupStrategy(ctx, strategy, qname, qtype, ecs)
+	}
+
+	if len(r.forwarders) > 0 {
+		return r.forward(ctx, r.forwarders, qname, qtype, ecs)
+	}
+
+	return r.recursiveLookup(ctx, qname, qtype, ecs)
+}
+
+func (r *Resolver) lookup(ctx context.Context, qname string, qtype dns.QueryType, server *net.UDPAddr, ecs *dns.ClientSubnet) (*dns.DNSPacket, error) {
+	response, err := r.exchange(ctx, qname, qtype, server, ecs)
+	if err != nil {
+		return nil, err
+	}
+
+	// The server rejected our cookie, the fresh server cookie from the response
+	// was stored while validating it so a single retry is enough.
+	if response.Header.ResCode == dns.BadCookie {
+		return r.exchange(ctx, qname, qtype, server, ecs)
+	}
+
+	return response, nil
+}
+
+func (r *Resolver) exchange(ctx context.Context, qname string, qtype dns.QueryType, remote *net.UDPAddr, ecs *dns.ClientSubnet) (*dns.DNSPacket, error) {
+	server := remote.IP
+
+	id, err := utils.RandomUint16()
+	if err != nil {
+		return nil, errors.Wrap(err, "generating query id")
+	}
+
+	packet := dns.NewDNSPacket()
+	packet.Header.ID = id
+	packet.Header.RecursionDesired = true
+	packet.Questions = append(packet.Questions, dns.NewDNSQuestion(qname, qtype))
+	packet.Resources = append(packet.Resources, dns.NewOPTRecord(r.udpSize))
+	packet.SetCookie(r.cookies.upstreamCookie(server))
+	if ecs != nil {
+		packet.SetClientSubnet(&dns.ClientSubnet{Address: ecs.Address, SourcePrefix: ecs.SourcePrefix})
+	}
+
+	started := time.Now()
+	response, err := r.exchangerFor(remote.String()).Exchange(ctx, packet, remote.String())
+	if err != nil {
+		return nil, err
+	}
+	r.rtts.record(remote.String(), time.Since(started), time.Now())
+
+	if !r.cookies.checkUpstreamCookie(server, response) {
+		return nil, errors.New("dns server response cookie mismatch")
+	}
+
+	if !clientSubnetMatches(ecs, response) {
+		return nil, errors.New("dns server response client subnet mismatch")
+	}
+
+	return response, nil
+}
+
+// dialUpstream connects to the upstream from a random source port so that an
+// off-path attacker has to guess the port as well as the query id.
+func dialUpstream(remote *net.UDPAddr, source *Source) (*net.UDPConn, error) {
+	if source != nil {
+		return dialFromSource(remote, source)
+	}
+
+	var err error
+	for i := 0; i < maxPortAttempts; i++ {
+		var port uint16
+		port, err = utils.RandomUint16()
+		if err != nil {
+			return nil, err
+		}
+
+		// Skip the privileged ports
+		if port < 1024 {
+			continue
+		}
+
+		var conn *net.UDPConn
+		conn, err = net.DialUDP("udp", &net.UDPAddr{Port: int(port)}, remote)
+		if err == nil {
+			return conn, nil
+		}
+	}
+
+	// Fall back to the port picked by the kernel
+	return net.DialUDP("udp", nil, remote)
+}
+
+// dialFromSource is dialUpstream sending from source, from a random port
+// too.
+func dialFromSource(remote *net.UDPAddr, source *Source) (*net.UDPConn, error) {
+	var err error
+	for i := 0; i < maxPortAttempts; i++ {
+		var port uint16
+		port, err = utils.RandomUint16()
+		if err != nil {
+			return nil, err
+		}
+		if port < 1024 {
+			continue
+		}
+
+		var d *net.Dialer
+		d, err = source.dialer("udp", remote.String(), int(port))
+		if err != nil {
+			return nil, err
+		}
+
+		var conn net.Conn
+		conn, err = d.Dial("udp", remote.String())
+		if err == nil {
+			return conn.(*net.UDPConn), nil
+		}
+	}
+
+	return nil, err
+}
+
+// responseMatches verifies that the response answers the query we sent,
+// datagrams with any other id or question are spoofed or stale.
+func responseMatches(query *dns.DNSPacket, response *dns.DNSPacket) bool {
+	if !response.Header.Response || response.Header.ID != query.Header.ID {
+		return false
+	}
+
+	if len(response.Questions) != len(query.Questions) {
+		return false
+	}
+
+	for i, q := range query.Questions {
+		r := response.Questions[i]
+		if r.QType != q.QType || !r.Name.Equal(q.Name) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// lazyResponseMatches is responseMatches for messages relayed undecoded.
+func lazyResponseMatches(query *dns.LazyPacket, response *dns.LazyPacket) bool {
+	if !response.Header.Response || response.Header.ID != query.Header.ID {
+		return false
+	}
+
+	if len(response.Questions) != len(query.Questions) {
+		return false
+	}
+
+	for i, q := range query.Questions {
+		r := response.Questions[i]
+		if r.QType != q.QType || !r.Name.Equal(q.Name) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// clientSubnetMatches checks that a client subnet in the response repeats the
+// one sent, RFC7871 7.3 requires dropping responses that don't.
+func clientSubnetMatches(sent *dns.ClientSubnet, response *dns.DNSPacket) bool {
+	ecs, err := response.ClientSubnet()
+	if err != nil {
+		return false
+	}
+	if ecs == nil {
+		return true
+	}
+
+	return sent != nil && ecs.SourcePrefix == sent.SourcePrefix && ecs.Address.Equal(sent.Address)
+}
+
+// minimizedQuery returns the name and type sent to a name server that only
+// needs to see the first revealed labels of qName (RFC9156). Intermediate
+// queries use the A type since some servers mishandle NS queries.
+func minimizedQuery(labels []string, revealed int, qType dns.QueryType) (string, dns.QueryType, bool) {
+	if revealed >= len(labels) {
+		return strings.Join(labels, "."), qType, false
+	}
+
+	return strings.Join(labels[len(labels)-revealed:], "."), dns.AQueryType, true
+}
+
+// recursiveLookup resolves qName starting at the root servers. The client
+// subnet is only sent along with the full name, never in minimized queries.
+func (r *Resolver) recursiveLookup(ctx context.Context, qName string, qType dns.QueryType, ecs *dns.ClientSubnet) (*dns.DNSPacket, error) {
+	ns := r.orderAddrs(rootServers)
+
+	labels := buffer.NewDomainName(qName).SplitLabels()
+	// zoneLabels is the label count of the zone ns is authoritative for and
+	// revealed is the label count of the name we send to it.
+	zoneLabels := 0
+	revealed := 1
+
+	for {
+		if err := stopped(ctx); err != nil {
+			return nil, err
+		}
+
+		name, t, minimized := minimizedQuery(labels, revealed, qType)
+
+		queryECS := ecs
+		if minimized {
+			queryECS = nil
+		}
+
+		logger.Debugf("Attempting to lookup %s %s with ns %s\n", t, name, ns)
+		response, err := r.lookupAny(ctx, name, t, ns, queryECS)
+		if err != nil {
+			return nil, errors.Wrap(err, "looking up query name")
+		}
+		r.sanitize(response, strings.Join(labels[len(labels)-zoneLabels:], "."))
+
+		if minimized {
+			// Referral to a child zone, continue with its name servers one label
+			// deeper.
+			zone := buffer.NewDomainName(response.GetReferralZone(name))
+			if len(zone.SplitLabels()) > zoneLabels {
+				newNs, err := r.nextNameServers(ctx, response, name)
+				if err != nil {
+					return nil, err
+				}
+
+				if len(newNs) != 0 {
+					ns = newNs
+					zoneLabels = len(zone.SplitLabels())
+					revealed = zoneLabels + 1
+					continue
+				}
+			}
+
+			// Some servers answer NXDOMAIN for empty non-terminals, stop hiding
+			// labels and ask for the full name instead of trusting it.
+			if response.Header.ResCode == dns.NxDomain {
+				revealed = len(labels)
+				continue
+			}
+
+			// The name exists inside the current zone, reveal one more label
+			revealed++
+			continue
+		}
+
+		// if there are answers and no errors return the response
+		if len(response.Answers) != 0 && response.Header.ResCode == dns.NoError {
+			return response, nil
+		}
+
+		// If response code is NXDomain it means domain name doesn't exists, we
+		// return the response
+		if response.Header.ResCode == dns.NxDomain {
+			logger.Debugf("domain not found\n")
+			return response, nil
+		}
+
+		newNs, err := r.nextNameServers(ctx, response, qName)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(newNs) == 0 {
+			logger.Debugf("no new name servers to traverse\n")
+			return response, nil
+		}
+		ns = newNs
+	}
+}
+
+// nextNameServers returns the addresses of name servers the response
+// delegates qName to, resolving a name server's address when no glue was
+// provided.
+func (r *Resolver) nextNameServers(ctx context.Context, response *dns.DNSPacket, qName string) ([]net.IP, error) {
+	// Get new name servers from the glue records
+	if addrs := r.orderAddrs(response.GetResolverNSAddrs(qName)); len(addrs) != 0 {
+		return addrs, nil
+	}
+
+	newNSName := response.GetUnresolvedNS(qName)
+	if newNSName == "" {
+		return nil, nil
+	}
+
+	addrs, err := r.resolveAddrs(ctx, newNSName, dns.AQueryType)
+	if err != nil {
+		return nil, err
+	}
+
+	// IPv6 addresses are only worth the extra lookup when they'd be preferred
+	// or there is nothing else to use.
+	if r.ipv6 && (r.preference != PreferIPv4 || len(addrs) == 0) {
+		v6, err := r.resolveAddrs(ctx, newNSName, dns.AAAAQueryType)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, v6...)
+	}
+
+	return r.orderAddrs(addrs), nil
+}
+
+func (r *Resolver) resolveAddrs(ctx context.Context, host string, qtype dns.QueryType) ([]net.IP, error) {
+	ctx, err := nestedLookup(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolving name server %s", host)
+	}
+
+	response, err := r.recursiveLookup(ctx, host, qtype, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolving name server %s", host)
+	}
+
+	addrs := make([]net.IP, 0)
+	for _, record := range response.Answers {
+		if record.QType == qtype {
+			addrs = append(addrs, record.Addr)
+		}
+	}
+
+	return addrs, nil
+}
@@ -0,0 +1,422 @@
+package resolver_test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/dnstest"
+	"github.com/msarvar/godns/pkg/resolver"
+	"github.com/pkg/errors"
+)
+
+const exampleZone = `
+@	IN SOA	ns.example.com. hostmaster.example.com. 1 7200 3600 1209600 300
+@	IN NS	ns.example.com.
+ns	IN A	192.0.2.53
+www	IN A	192.0.2.10
+www	IN AAAA	2001:db8::10
+a	IN A	192.0.2.10
+b	IN AAAA	2001:db8::10
+c	IN A	192.0.2.10
+`
+
+func TestForwarders(t *testing.T) {
+	t.Run("parse_forwarders", func(t *testing.T) {
+		var fs resolver.Forwarders
+		NoError(t, fs.Set("192.0.2.1"))
+		NoError(t, fs.Set("[2001:db8::1]:5353"))
+		Equal(t, "192.0.2.1:53,[2001:db8::1]:5353", fs.String())
+
+		for _, value := range []string{"resolver.example", "192.0.2.1:dns", "192.0.2.1:70000"} {
+			Error(t, fs.Set(value), value)
+		}
+	})
+
+	t.Run("queries_go_to_first_responding_forwarder", func(t *testing.T) {
+		ns := dnstest.NewServer(t, map[string]string{"example.com": exampleZone})
+
+		// Nothing listens on the first forwarder
+		dead, err := net.ListenPacket("udp", "127.0.0.1:0")
+		NoError(t, err)
+		dead.Close()
+
+		var fs resolver.Forwarders
+		NoError(t, fs.Set(dead.LocalAddr().String()))
+		NoError(t, fs.Set(ns.Addr.String()))
+
+		r := resolver.NewResolver(&resolver.Config{Forwarders: fs})
+		response, err := r.Resolve(context.Background(), "www.example.com", dns.AQueryType)
+		NoError(t, err)
+		if Len(t, response.Answers, 1) {
+			Equal(t, "192.0.2.10", response.Answers[0].Addr.String())
+		}
+
+		// Only the forwarder that answered has a round trip time
+		if rtts := r.RTTs(); Len(t, rtts, 1) {
+			Equal(t, ns.Addr.String(), rtts[0].Addr)
+			Equal(t, 1, rtts[0].Samples)
+			Equal(t, rtts[0].Last, rtts[0].Smoothed)
+		}
+	})
+}
+
+func TestStrategies(t *testing.T) {
+	t.Run("parse_strategies", func(t *testing.T) {
+		var ss resolver.Strategies
+		NoError(t, ss.Set("example.com=forward:192.0.2.1,recursive"))
+		NoError(t, ss.Set(".=recursive,forward:[2001:db8::1]:5353"))
+		Equal(t, "example.com=forward:192.0.2.1:53,recursive .=recursive,forward:[2001:db8::1]:5353", ss.String())
+
+		for _, value := range []string{"example.com", "example.com=iterative", "example.com=forward:resolver.example", "example.com="} {
+			Error(t, ss.Set(value), value)
+		}
+	})
+
+	ns := dnstest.NewServer(t, map[string]string{"example.com": exampleZone})
+
+	// Answers every query with SERVFAIL
+	failing, err := net.ListenPacket("udp", "127.0.0.1:0")
+	NoError(t, err)
+	defer failing.Close()
+	go func() {
+		for {
+			msg := make([]byte, 512)
+			n, client, err := failing.ReadFrom(msg)
+			if err != nil {
+				return
+			}
+
+			msg[2] |= 0x80
+			msg[3] = msg[3]&0xf0 | byte(dns.ServFail)
+			failing.WriteTo(msg[:n], client)
+		}
+	}()
+
+	var ss resolver.Strategies
+	NoError(t, ss.Set(".=forward:"+failing.LocalAddr().String()))
+	NoError(t, ss.Set("example.com=forward:"+failing.LocalAddr().String()+",forward:"+ns.Addr.String()))
+	r := resolver.NewResolver(&resolver.Config{Strategies: ss})
+	defer r.Close()
+
+	t.Run("next_step_after_servfail", func(t *testing.T) {
+		response, err := r.Resolve(context.Background(), "www.example.com", dns.AQueryType)
+		NoError(t, err)
+		Equal(t, dns.NoError, response.Header.ResCode)
+		if Len(t, response.Answers, 1) {
+			Equal(t, "192.0.2.10", response.Answers[0].Addr.String())
+		}
+	})
+
+	t.Run("last_failure_reaches_client", func(t *testing.T) {
+		response, err := r.Resolve(context.Background(), "www.example.net", dns.AQueryType)
+		NoError(t, err)
+		Equal(t, dns.ServFail, response.Header.ResCode)
+	})
+}
+
+func TestForwarderHealth(t *testing.T) {
+	ns := dnstest.NewServer(t, map[string]string{"example.com": exampleZone})
+
+	dead, err := net.ListenPacket("udp", "127.0.0.1:0")
+	NoError(t, err)
+	dead.Close()
+
+	var fs resolver.Forwarders
+	NoError(t, fs.Set(dead.LocalAddr().String()))
+	NoError(t, fs.Set(ns.Addr.String()))
+	r := resolver.NewResolver(&resolver.Config{Forwarders: fs})
+
+	t.Run("failing_forwarder_is_marked_down", func(t *testing.T) {
+		for _, name := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+			_, err := r.Resolve(context.Background(), name, dns.AQueryType)
+			NoError(t, err)
+		}
+
+		health := r.Health()
+		if Len(t, health, 2) {
+			Equal(t, dead.LocalAddr().String(), health[0].Addr)
+			False(t, health[0].Healthy)
+			Equal(t, 3, health[0].Failures)
+			NotEmpty(t, health[0].LastError)
+			True(t, health[1].Healthy)
+		}
+	})
+
+	t.Run("down_forwarder_is_tried_last", func(t *testing.T) {
+		_, err := r.Resolve(context.Background(), "www.example.com", dns.AQueryType)
+		NoError(t, err)
+		Equal(t, 3, r.Health()[0].Failures)
+	})
+
+	t.Run("probes_check_every_forwarder", func(t *testing.T) {
+		before := len(ns.Queries())
+		r.ProbeForwarders(context.Background())
+
+		Equal(t, 4, r.Health()[0].Failures)
+		True(t, r.Health()[1].Healthy)
+		Equal(t, before+1, len(ns.Queries()))
+	})
+}
+
+func TestLookupHostParallel(t *testing.T) {
+	ns := dnstest.NewServer(t, map[string]string{"example.com": exampleZone})
+
+	var fs resolver.Forwarders
+	NoError(t, fs.Set(ns.Addr.String()))
+	r := resolver.NewResolver(&resolver.Config{Forwarders: fs})
+
+	addrs := make(map[dns.QueryType]string)
+	for result := range r.LookupHostParallel(context.Background(), "www.example.com") {
+		NoError(t, result.Err)
+		if Len(t, result.Addrs, 1) {
+			addrs[result.QType] = result.Addrs[0].String()
+		}
+	}
+
+	Equal(t, map[dns.QueryType]string{
+		dns.AQueryType:    "192.0.2.10",
+		dns.AAAAQueryType: "2001:db8::10",
+	}, addrs)
+}
+
+func TestResolveMany(t *testing.T) {
+	ns := dnstest.NewServer(t, map[string]string{"example.com": exampleZone})
+
+	var fs resolver.Forwarders
+	NoError(t, fs.Set(ns.Addr.String()))
+	r := resolver.NewResolver(&resolver.Config{Forwarders: fs, MaxParallel: 2})
+
+	questions := []*dns.DNSQuestion{
+		dns.NewDNSQuestion("a.example.com", dns.AQueryType),
+		dns.NewDNSQuestion("b.example.com", dns.AAAAQueryType),
+		dns.NewDNSQuestion("c.example.com", dns.AQueryType),
+	}
+
+	t.Run("results_follow_question_order", func(t *testing.T) {
+		results := r.ResolveMany(context.Background(), questions)
+		if !Len(t, results, 3) {
+			return
+		}
+
+		for i, want := range []string{"192.0.2.10", "2001:db8::10", "192.0.2.10"} {
+			Same(t, questions[i], results[i].Question)
+			NoError(t, results[i].Err)
+			if Len(t, results[i].Response.Answers, 1) {
+				Equal(t, want, results[i].Response.Answers[0].Addr.String())
+			}
+		}
+	})
+
+	t.Run("cancelled_context_fails_questions", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		for _, result := range r.ResolveMany(ctx, questions) {
+			Error(t, result.Err)
+		}
+	})
+}
+
+func TestMismatchedResponses(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	NoError(t, err)
+	defer conn.Close()
+
+	// Every query gets a truncated datagram and a response for another name
+	// with its id before the real response
+	go func() {
+		for {
+			reqBuffer := buffer.NewBytePacketBuffer()
+			_, client, err := conn.ReadFrom(reqBuffer.Buf)
+			if err != nil {
+				return
+			}
+
+			query, err := dns.DNSPacketFromBuffer(reqBuffer)
+			if err != nil {
+				continue
+			}
+
+			conn.WriteTo(reqBuffer.Buf[:3], client)
+			for _, name := range []string{"spoofed.example.com", query.Questions[0].Name.String()} {
+				response := dns.NewDNSPacket()
+				response.Header.ID = query.Header.ID
+				response.Header.Response = true
+				response.Questions = []*dns.DNSQuestion{dns.NewDNSQuestion(name, dns.AQueryType)}
+				addr := "192.0.2.10"
+				if name == "spoofed.example.com" {
+					addr = "203.0.113.66"
+				}
+				r, _ := dns.ParseRecord(query.Questions[0].Name.String()+". 60 IN A "+addr, 0)
+				response.Answers = append(response.Answers, r)
+
+				resBuffer := buffer.NewBytePacketBuffer()
+				if err := response.Write(resBuffer); err != nil {
+					continue
+				}
+				conn.WriteTo(resBuffer.Buf[:resBuffer.Pos()], client)
+			}
+		}
+	}()
+
+	var fs resolver.Forwarders
+	NoError(t, fs.Set(conn.LocalAddr().String()))
+	r := resolver.NewResolver(&resolver.Config{Forwarders: fs})
+
+	response, err := r.Resolve(context.Background(), "www.example.com", dns.AQueryType)
+	NoError(t, err)
+	if Len(t, response.Answers, 1) {
+		Equal(t, "192.0.2.10", response.Answers[0].Addr.String())
+	}
+}
+
+func TestRelay(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	NoError(t, err)
+	defer conn.Close()
+
+	received := make(chan []byte, 1)
+	// Echoes every query as its response
+	go func() {
+		for {
+			msg := make([]byte, 512)
+			n, client, err := conn.ReadFrom(msg)
+			if err != nil {
+				return
+			}
+
+			received <- append([]byte(nil), msg[:n]...)
+			msg[2] |= 0x80
+			conn.WriteTo(msg[:n], client)
+		}
+	}()
+
+	query := []byte{
+		0x12, 0x34, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+		0x03, 'w', 'w', 'w', 0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0x03, 'c', 'o', 'm', 0x00,
+		0x00, 0x01, 0x00, 0x01,
+		// OPT carrying option 65001, unknown to godns
+		0x00, 0x00, 0x29, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x08,
+		0xfd, 0xe9, 0x00, 0x04, 0xde, 0xad, 0xbe, 0xef,
+	}
+
+	var fs resolver.Forwarders
+	NoError(t, fs.Set(conn.LocalAddr().String()))
+	r := resolver.NewResolver(&resolver.Config{Forwarders: fs})
+	defer r.Close()
+
+	response, err := r.Relay(context.Background(), append([]byte(nil), query...))
+	NoError(t, err)
+
+	sent := <-received
+	Equal(t, query[2:], sent[2:], "only the id changes upstream")
+
+	expected := append([]byte(nil), query...)
+	expected[2] |= 0x80
+	Equal(t, expected, response, "the response comes back under the client's id")
+}
+
+func TestDeadlines(t *testing.T) {
+	// Receives queries and never answers them
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	NoError(t, err)
+	defer silent.Close()
+
+	stalled, err := net.Listen("tcp", "127.0.0.1:0")
+	NoError(t, err)
+	defer stalled.Close()
+	go func() {
+		for {
+			conn, err := stalled.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	resolve := func(t *testing.T, cfg *resolver.Config) {
+		r := resolver.NewResolver(cfg)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		started := time.Now()
+		_, err := r.Resolve(ctx, "www.example.com", dns.AQueryType)
+		True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+		False(t, errors.Is(err, resolver.ErrLimitExceeded))
+		Less(t, int64(time.Since(started)), int64(time.Second))
+
+		// The client giving up doesn't count against the forwarder
+		if Len(t, r.Health(), 1) {
+			Equal(t, 0, r.Health()[0].Failures)
+		}
+	}
+
+	t.Run("udp_exchange_stops_at_the_deadline", func(t *testing.T) {
+		var fs resolver.Forwarders
+		NoError(t, fs.Set(silent.LocalAddr().String()))
+		resolve(t, &resolver.Config{Forwarders: fs})
+	})
+
+	t.Run("tcp_exchange_stops_at_the_deadline", func(t *testing.T) {
+		var fs resolver.Forwarders
+		NoError(t, fs.Set(stalled.Addr().String()))
+		resolve(t, &resolver.Config{Forwarders: fs, Exchanger: &resolver.TCPExchanger{}})
+	})
+
+	t.Run("cancelled_relay_stops", func(t *testing.T) {
+		var fs resolver.Forwarders
+		NoError(t, fs.Set(silent.LocalAddr().String()))
+		r := resolver.NewResolver(&resolver.Config{Forwarders: fs})
+
+		query := dns.NewDNSPacket()
+		query.Header.ID = 1
+		query.Questions = append(query.Questions, dns.NewDNSQuestion("www.example.com", dns.AQueryType))
+		var msg bytes.Buffer
+		_, err := query.WriteTo(&msg)
+		NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		_, err = r.Relay(ctx, msg.Bytes())
+		True(t, errors.Is(err, context.Canceled), "%v", err)
+		Equal(t, 0, r.Health()[0].Failures)
+	})
+}
+
+func TestAttemptBudget(t *testing.T) {
+	ns := dnstest.NewServer(t, map[string]string{"example.com": exampleZone})
+
+	// Receives queries and never answers them
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	NoError(t, err)
+	defer silent.Close()
+
+	var fs resolver.Forwarders
+	NoError(t, fs.Set(silent.LocalAddr().String()))
+	NoError(t, fs.Set(ns.Addr.String()))
+	r := resolver.NewResolver(&resolver.Config{Forwarders: fs})
+
+	// The silent forwarder gets half of the budget, not all of it
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	response, err := r.Resolve(ctx, "www.example.com", dns.AQueryType)
+	if NoError(t, err) {
+		NotEmpty(t, response.Answers)
+	}
+
+	health := r.Health()
+	if Len(t, health, 2) {
+		Equal(t, 1, health[0].Failures)
+		True(t, health[1].Healthy)
+	}
+}
@@ -0,0 +1,81 @@
+package resolver
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxRTTServers bounds the servers whose round trip times are remembered,
+// iterative resolution talks to many name servers.
+const maxRTTServers = 1000
+
+// UpstreamRTT is how long a server took to answer.
+type UpstreamRTT struct {
+	Addr string
+	// Smoothed weighs the last round trip time by 1/8 like TCP does
+	Smoothed time.Duration
+	Last     time.Duration
+	Samples  int
+	Updated  time.Time
+}
+
+// rttTable remembers the round trip times of the servers answering.
+type rttTable struct {
+	mu      sync.Mutex
+	servers map[string]*UpstreamRTT
+}
+
+func newRTTTable() *rttTable {
+	return &rttTable{servers: make(map[string]*UpstreamRTT)}
+}
+
+// record notes that addr answered after rtt, the server updated longest ago
+// is forgotten when the table is full.
+func (t *rttTable) record(addr string, rtt time.Duration, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	server, ok := t.servers[addr]
+	if !ok {
+		if len(t.servers) >= maxRTTServers {
+			t.evict()
+		}
+		server = &UpstreamRTT{Addr: addr, Smoothed: rtt}
+		t.servers[addr] = server
+	}
+
+	server.Smoothed += (rtt - server.Smoothed) / 8
+	server.Last = rtt
+	server.Samples++
+	server.Updated = now
+}
+
+func (t *rttTable) evict() {
+	var oldest *UpstreamRTT
+	for _, server := range t.servers {
+		if oldest == nil || server.Updated.Before(oldest.Updated) {
+			oldest = server
+		}
+	}
+
+	delete(t.servers, oldest.Addr)
+}
+
+// RTTs returns the round trip times of the servers that answered, ordered
+// by address.
+func (r *Resolver) RTTs() []UpstreamRTT {
+	r.rtts.mu.Lock()
+	defer r.rtts.mu.Unlock()
+
+	rtts := make([]UpstreamRTT, 0, len(r.rtts.servers))
+	for _, server := range r.rtts.servers {
+		rtts = append(rtts, *server)
+	}
+
+	sort.Slice(rtts, func(i, j int) bool {
+		return rtts[i].Addr < rtts[j].Addr
+	})
+
+	return rtts
+}
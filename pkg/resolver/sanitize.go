@@ -0,0 +1,66 @@
+package resolver
+
+import (
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/logger"
+)
+
+// sanitize cleans an upstream response before it is used or cached. Records
+// outside zone, the bailiwick of the server that sent them, are dropped as a
+// server may only speak for its own zone and anything else could poison the
+// cache. Duplicate records are dropped and TTLs are capped to the configured
+// bounds. The response is modified in place.
+func (r *Resolver) sanitize(response *dns.DNSPacket, zone string) {
+	bailiwick := buffer.NewDomainName(zone)
+
+	response.Answers = r.sanitizeRecords(response.Answers, bailiwick)
+	response.Authorities = r.sanitizeRecords(response.Authorities, bailiwick)
+	response.Resources = r.sanitizeRecords(response.Resources, bailiwick)
+}
+
+func (r *Resolver) sanitizeRecords(records []*dns.DNSRecord, bailiwick *buffer.DomainName) []*dns.DNSRecord {
+	type seenKey struct {
+		key   dns.RRsetKey
+		rdata string
+	}
+
+	seen := make(map[seenKey]bool, len(records))
+	kept := records[:0]
+	for _, record := range records {
+		// OPT belongs to the message, not to a zone
+		if record.QType == dns.OPTQueryType {
+			kept = append(kept, record)
+			continue
+		}
+
+		if !record.Domain.IsSubdomainOf(bailiwick) {
+			logger.Debugf("Dropping record %s outside of zone %s.\n", record.Text(), bailiwick)
+			continue
+		}
+
+		k := seenKey{key: record.Key(), rdata: record.RData()}
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+
+		record.TTL = r.capTTL(record.TTL)
+		kept = append(kept, record)
+	}
+
+	return kept
+}
+
+// capTTL keeps ttl between the configured minimum and maximum, a zero
+// maximum doesn't cap.
+func (r *Resolver) capTTL(ttl uint32) uint32 {
+	if ttl < r.minTTL {
+		ttl = r.minTTL
+	}
+	if r.maxTTL > 0 && ttl > r.maxTTL {
+		ttl = r.maxTTL
+	}
+
+	return ttl
+}
@@ -0,0 +1,113 @@
+package resolver
+
+import (
+	"context"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// Source is where queries to an upstream leave from, a local IP address or
+// a network interface like a VPN tunnel. Queries through an interface are
+// sent from its address of the upstream's family, and on Linux bound to the
+// interface so they can't take another link.
+type Source struct {
+	IP        net.IP
+	Interface string
+}
+
+// ParseSource parses a source given as an IP address or an interface name.
+// Interfaces are looked up when queries are sent, a tunnel may come up after
+// godns starts.
+func ParseSource(value string) (*Source, error) {
+	if value == "" {
+		return nil, errors.New("empty source address")
+	}
+	if ip := net.ParseIP(value); ip != nil {
+		return &Source{IP: ip}, nil
+	}
+
+	return &Source{Interface: value}, nil
+}
+
+func (s *Source) String() string {
+	if s.Interface != "" {
+		return s.Interface
+	}
+
+	return s.IP.String()
+}
+
+// localIP returns the address to send from to reach remote.
+func (s *Source) localIP(remote net.IP) (net.IP, error) {
+	if s.Interface == "" {
+		return s.IP, nil
+	}
+
+	iface, err := net.InterfaceByName(s.Interface)
+	if err != nil {
+		return nil, errors.Wrapf(err, "looking up source interface %s", s.Interface)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, errors.Wrapf(err, "listing addresses of source interface %s", s.Interface)
+	}
+
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && (ipNet.IP.To4() != nil) == (remote.To4() != nil) {
+			return ipNet.IP, nil
+		}
+	}
+
+	return nil, errors.Errorf("source interface %s has no address to reach %s", s.Interface, remote)
+}
+
+// dialer returns a dialer sending from the source to remote, "host:port".
+// The local port is left to the kernel unless port is set.
+func (s *Source) dialer(network, remote string, port int) (*net.Dialer, error) {
+	host, _, err := net.SplitHostPort(remote)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing upstream address")
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, errors.Errorf("upstream %s must be an IP address to send from %s", remote, s)
+	}
+
+	local, err := s.localIP(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &net.Dialer{Control: s.control}
+	switch network {
+	case "udp", "udp4", "udp6":
+		d.LocalAddr = &net.UDPAddr{IP: local, Port: port}
+	default:
+		d.LocalAddr = &net.TCPAddr{IP: local, Port: port}
+	}
+
+	return d, nil
+}
+
+type sourceKey struct{}
+
+// withSource returns ctx sending the queries made with it from s, ctx as is
+// when s is nil.
+func withSource(ctx context.Context, s *Source) context.Context {
+	if s == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, sourceKey{}, s)
+}
+
+// dialerFor returns the dialer to reach remote with, from the source of ctx
+// when it has one.
+func dialerFor(ctx context.Context, network, remote string) (*net.Dialer, error) {
+	if s, ok := ctx.Value(sourceKey{}).(*Source); ok {
+		return s.dialer(network, remote, 0)
+	}
+
+	return &net.Dialer{}, nil
+}
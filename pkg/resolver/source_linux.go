@@ -0,0 +1,27 @@
+//go:build linux
+// +build linux
+
+package resolver
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// control binds sockets to the source interface, the routing table can't
+// send them over another link then.
+func (s *Source) control(_, _ string, c syscall.RawConn) error {
+	if s.Interface == "" {
+		return nil
+	}
+
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		err = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, s.Interface)
+	}); cerr != nil {
+		return cerr
+	}
+
+	return errors.Wrapf(err, "binding to source interface %s", s.Interface)
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package resolver
+
+import "syscall"
+
+// control leaves sockets unbound, only their address selects the interface.
+func (s *Source) control(_, _ string, _ syscall.RawConn) error {
+	return nil
+}
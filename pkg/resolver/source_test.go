@@ -0,0 +1,95 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+func TestSources(t *testing.T) {
+	// The server answers every query with the address it came from
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if !NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	go func() {
+		msg := make([]byte, dns.MaxMessageSize)
+		for {
+			n, client, err := conn.ReadFrom(msg)
+			if err != nil {
+				return
+			}
+			query, err := dns.ReadPacket(bytes.NewReader(msg[:n]))
+			if err != nil {
+				continue
+			}
+
+			conn.WriteTo(answerWith(query, func(q *dns.DNSQuestion) []string {
+				return []string{q.Name.String() + ". 60 IN A " + client.(*net.UDPAddr).IP.String()}
+			}), client)
+		}
+	}()
+	server := conn.LocalAddr().String()
+
+	resolve := func(t *testing.T, cfg *Config, name string) string {
+		response, err := NewResolver(cfg).Resolve(context.Background(), name, dns.AQueryType)
+		if err != nil && strings.Contains(err.Error(), "operation not permitted") {
+			t.Skip(err)
+		}
+		if NoError(t, err) && Len(t, response.Answers, 1) {
+			return response.Answers[0].Addr.String()
+		}
+		return ""
+	}
+
+	t.Run("forwarders_send_from_their_source", func(t *testing.T) {
+		var fs Forwarders
+		NoError(t, fs.Set(server+"@127.0.0.2"))
+		Equal(t, "127.0.0.2", resolve(t, &Config{Forwarders: fs}, "www.example.com"))
+	})
+
+	t.Run("strategies_send_from_the_source_of_the_step", func(t *testing.T) {
+		var fs Forwarders
+		NoError(t, fs.Set(server))
+		var ss Strategies
+		NoError(t, ss.Set("corp.example=forward:"+server+"@127.0.0.3"))
+
+		cfg := &Config{Forwarders: fs, Strategies: ss}
+		Equal(t, "127.0.0.3", resolve(t, cfg, "www.corp.example"))
+		Equal(t, "127.0.0.1", resolve(t, cfg, "www.example.com"))
+	})
+
+	t.Run("interfaces_send_from_their_address", func(t *testing.T) {
+		var fs Forwarders
+		NoError(t, fs.Set(server+"@lo"))
+		Equal(t, "127.0.0.1", resolve(t, &Config{Forwarders: fs}, "www.example.com"))
+	})
+
+	t.Run("missing_interfaces_fail", func(t *testing.T) {
+		var fs Forwarders
+		NoError(t, fs.Set(server+"@godns-missing0"))
+		_, err := NewResolver(&Config{Forwarders: fs}).Resolve(context.Background(), "www.example.com", dns.AQueryType)
+		Error(t, err)
+	})
+
+	t.Run("round_trips", func(t *testing.T) {
+		var fs Forwarders
+		NoError(t, fs.Set("10.0.0.53@tun0"))
+		NoError(t, fs.Set("[2001:db8::53]:5353@2001:db8::2"))
+		Equal(t, "10.0.0.53:53@tun0,[2001:db8::53]:5353@2001:db8::2", fs.String())
+
+		s, err := ParseStrategy("corp.example=forward:10.0.0.53@tun0,recursive@192.0.2.1")
+		if NoError(t, err) {
+			Equal(t, "corp.example=forward:10.0.0.53:53@tun0,recursive@192.0.2.1", s.String())
+		}
+
+		Error(t, fs.Set("10.0.0.53@"))
+	})
+}
@@ -0,0 +1,368 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/logger"
+	"github.com/pkg/errors"
+)
+
+// StampProtocol is the transport a DNS stamp describes.
+type StampProtocol byte
+
+const (
+	StampPlain    StampProtocol = 0x00
+	StampDNSCrypt StampProtocol = 0x01
+	StampDoH      StampProtocol = 0x02
+	StampDoT      StampProtocol = 0x03
+	StampDoQ      StampProtocol = 0x04
+)
+
+func (p StampProtocol) String() string {
+	switch p {
+	case StampPlain:
+		return "plain"
+	case StampDNSCrypt:
+		return "dnscrypt"
+	case StampDoH:
+		return "doh"
+	case StampDoT:
+		return "dot"
+	case StampDoQ:
+		return "doq"
+	default:
+		return "protocol " + strconv.Itoa(int(p))
+	}
+}
+
+// defaultPort is the port of the protocol when the stamp names none.
+func (p StampProtocol) defaultPort() int {
+	switch p {
+	case StampPlain:
+		return 53
+	case StampDoT, StampDoQ:
+		return 853
+	default:
+		return 443
+	}
+}
+
+// StampProps are the properties a server announces in its stamp.
+type StampProps uint64
+
+const (
+	StampDNSSEC   StampProps = 1 << 0
+	StampNoLogs   StampProps = 1 << 1
+	StampNoFilter StampProps = 1 << 2
+)
+
+// Stamp describes how to reach a DNS server, as published in public server
+// lists (https://dnscrypt.info/stamps-specifications).
+type Stamp struct {
+	Protocol StampProtocol
+	Props    StampProps
+	// Addr is the address of the server, nil when a DoH or DoT stamp leaves
+	// it to resolving Hostname
+	Addr *net.UDPAddr
+	// Hashes are SHA256 digests of the TBS certificates of DoH and DoT
+	// servers, one certificate of the chain must match when there are any
+	Hashes [][]byte
+	// Hostname is the TLS server name of DoH and DoT servers, it may carry
+	// a port
+	Hostname string
+	// Path is the URL path of a DoH server
+	Path string
+	// ProviderName and PublicKey identify a DNSCrypt server
+	ProviderName string
+	PublicKey    []byte
+}
+
+// ParseStamp decodes a stamp given as "sdns://...".
+func ParseStamp(value string) (*Stamp, error) {
+	if !strings.HasPrefix(value, "sdns://") {
+		return nil, errors.Errorf("stamp %q doesn't start with sdns://", value)
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(value, "sdns://"))
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding stamp")
+	}
+
+	r := &stampReader{data: data}
+	s := &Stamp{Protocol: StampProtocol(r.byte())}
+	if r.err == nil && len(r.data) < 8 {
+		r.err = errors.New("stamp too short")
+	}
+	if r.err == nil {
+		s.Props = StampProps(binary.LittleEndian.Uint64(r.data))
+		r.data = r.data[8:]
+	}
+
+	addr := string(r.lp())
+	switch s.Protocol {
+	case StampPlain:
+	case StampDNSCrypt:
+		s.PublicKey = r.lp()
+		s.ProviderName = string(r.lp())
+	case StampDoH:
+		s.Hashes = r.vlp()
+		s.Hostname = string(r.lp())
+		s.Path = string(r.lp())
+	case StampDoT, StampDoQ:
+		s.Hashes = r.vlp()
+		s.Hostname = string(r.lp())
+	default:
+		return nil, errors.Errorf("stamp of unknown %s", s.Protocol)
+	}
+	if r.err != nil {
+		return nil, errors.Wrapf(r.err, "parsing %s stamp", s.Protocol)
+	}
+
+	if addr != "" {
+		s.Addr, err = parseStampAddr(addr, s.Protocol.defaultPort())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// parseStampAddr parses "IP", "IP:PORT" or "[IPv6]:PORT".
+func parseStampAddr(addr string, port int) (*net.UDPAddr, error) {
+	host := strings.Trim(addr, "[]")
+	if h, p, err := net.SplitHostPort(addr); err == nil {
+		n, err := strconv.ParseUint(p, 10, 16)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing stamp port of %q", addr)
+		}
+		host, port = h, int(n)
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, errors.Errorf("stamp address %q is not an IP address", addr)
+	}
+
+	return &net.UDPAddr{IP: ip, Port: port}, nil
+}
+
+// String encodes the stamp as "sdns://...".
+func (s *Stamp) String() string {
+	var b bytes.Buffer
+	b.WriteByte(byte(s.Protocol))
+	var props [8]byte
+	binary.LittleEndian.PutUint64(props[:], uint64(s.Props))
+	b.Write(props[:])
+
+	addr := ""
+	if s.Addr != nil {
+		addr = s.Addr.String()
+	}
+	writeLP(&b, []byte(addr))
+
+	switch s.Protocol {
+	case StampDNSCrypt:
+		writeLP(&b, s.PublicKey)
+		writeLP(&b, []byte(s.ProviderName))
+	case StampDoH:
+		writeVLP(&b, s.Hashes)
+		writeLP(&b, []byte(s.Hostname))
+		writeLP(&b, []byte(s.Path))
+	case StampDoT, StampDoQ:
+		writeVLP(&b, s.Hashes)
+		writeLP(&b, []byte(s.Hostname))
+	}
+
+	return "sdns://" + base64.RawURLEncoding.EncodeToString(b.Bytes())
+}
+
+func writeLP(b *bytes.Buffer, value []byte) {
+	b.WriteByte(byte(len(value)))
+	b.Write(value)
+}
+
+// writeVLP writes a set of values, the length of every value but the last
+// has the high bit set.
+func writeVLP(b *bytes.Buffer, values [][]byte) {
+	if len(values) == 0 {
+		b.WriteByte(0)
+		return
+	}
+
+	for i, v := range values {
+		length := byte(len(v))
+		if i < len(values)-1 {
+			length |= 0x80
+		}
+		b.WriteByte(length)
+		b.Write(v)
+	}
+}
+
+// stampReader reads the fields of a stamp, the first error sticks.
+type stampReader struct {
+	data []byte
+	err  error
+}
+
+func (r *stampReader) byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.data) < 1 {
+		r.err = errors.New("stamp too short")
+		return 0
+	}
+
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b
+}
+
+// lp reads a value prefixed with its length.
+func (r *stampReader) lp() []byte {
+	length := int(r.byte())
+	if r.err != nil {
+		return nil
+	}
+	if len(r.data) < length {
+		r.err = errors.New("stamp too short")
+		return nil
+	}
+
+	v := r.data[:length]
+	r.data = r.data[length:]
+	return v
+}
+
+// vlp reads a set of values, the high bit of a length says another value
+// follows.
+func (r *stampReader) vlp() [][]byte {
+	var values [][]byte
+	for r.err == nil {
+		length := r.byte()
+		more := length&0x80 != 0
+		length &^= 0x80
+		if r.err != nil || len(r.data) < int(length) {
+			r.err = errors.New("stamp too short")
+			return nil
+		}
+
+		if length > 0 {
+			values = append(values, r.data[:length])
+		}
+		r.data = r.data[length:]
+		if !more {
+			break
+		}
+	}
+
+	return values
+}
+
+// exchanger returns the transport reaching the server of the stamp and the
+// address to pass it.
+func (s *Stamp) exchanger() (UpstreamExchanger, string, error) {
+	if s.Addr == nil {
+		return nil, "", errors.Errorf("%s stamp for %s has no address, godns needs one to reach the server without resolving its name", s.Protocol, s.Hostname)
+	}
+
+	serverName := s.Hostname
+	if h, _, err := net.SplitHostPort(s.Hostname); err == nil {
+		serverName = h
+	}
+	tlsConfig := &tls.Config{ServerName: serverName, VerifyPeerCertificate: s.verifyHashes}
+
+	switch s.Protocol {
+	case StampPlain:
+		return nil, s.Addr.String(), nil
+	case StampDoT:
+		return &TLSExchanger{Config: tlsConfig}, s.Addr.String(), nil
+	case StampDoH:
+		return dialingHTTPSExchanger(tlsConfig, s.Addr.String()), "https://" + s.Hostname + s.Path, nil
+	case StampDNSCrypt:
+		if len(s.PublicKey) != ed25519.PublicKeySize || s.ProviderName == "" {
+			return nil, "", errors.New("dnscrypt stamp needs a provider name and an ed25519 public key")
+		}
+		return &DNSCryptExchanger{ProviderName: s.ProviderName, PublicKey: s.PublicKey}, s.Addr.String(), nil
+	default:
+		return nil, "", errors.Errorf("%s upstreams aren't supported", s.Protocol)
+	}
+}
+
+// dialingHTTPSExchanger returns a DNS over HTTPS transport connecting to
+// addr, the name in the URL is never resolved.
+func dialingHTTPSExchanger(tlsConfig *tls.Config, addr string) *HTTPSExchanger {
+	transport := &http.Transport{
+		TLSClientConfig:   tlsConfig,
+		ForceAttemptHTTP2: true,
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			dialer, err := dialerFor(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+
+	return &HTTPSExchanger{Client: &http.Client{Timeout: upstreamTimeout, Transport: transport}}
+}
+
+// verifyHashes accepts the certificate chain when no hashes are pinned or
+// one of its certificates matches a hash, after the usual verification.
+func (s *Stamp) verifyHashes(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(s.Hashes) == 0 {
+		return nil
+	}
+
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			continue
+		}
+
+		digest := sha256.Sum256(cert.RawTBSCertificate)
+		for _, h := range s.Hashes {
+			if bytes.Equal(h, digest[:]) {
+				return nil
+			}
+		}
+	}
+
+	return errors.New("no certificate matches the hashes of the stamp")
+}
+
+// stampedExchanger sends the queries for a stamped or upgraded forwarder over
+// its own transport, whatever address the resolver asks for. Failed
+// exchanges are retried over plain DNS when fallback is set.
+type stampedExchanger struct {
+	exchanger UpstreamExchanger
+	addr      string
+	// fallback reaches the forwarder at plain over plain DNS, nil in strict
+	// privacy mode
+	fallback UpstreamExchanger
+	plain    string
+}
+
+func (e *stampedExchanger) Exchange(ctx context.Context, query *dns.DNSPacket, _ string) (*dns.DNSPacket, error) {
+	response, err := e.exchanger.Exchange(ctx, query, e.addr)
+	if err == nil || e.fallback == nil || ctx.Err() != nil {
+		return response, err
+	}
+
+	logger.Errorf("Warning: %s failed, falling back to plain DNS to %s: %s\n", e.addr, e.plain, err)
+	return e.fallback.Exchange(ctx, query, e.plain)
+}
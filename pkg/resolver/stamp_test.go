@@ -0,0 +1,131 @@
+package resolver
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+func TestStamps(t *testing.T) {
+	t.Run("parse_public_doh_stamp", func(t *testing.T) {
+		s, err := ParseStamp("sdns://AgcAAAAAAAAABzEuMC4wLjEAEmRucy5jbG91ZGZsYXJlLmNvbQovZG5zLXF1ZXJ5")
+		if NoError(t, err) {
+			Equal(t, StampDoH, s.Protocol)
+			Equal(t, StampDNSSEC|StampNoLogs|StampNoFilter, s.Props)
+			Equal(t, "1.0.0.1:443", s.Addr.String())
+			Equal(t, "dns.cloudflare.com", s.Hostname)
+			Equal(t, "/dns-query", s.Path)
+			Empty(t, s.Hashes)
+		}
+	})
+
+	t.Run("round_trips", func(t *testing.T) {
+		stamps := []*Stamp{
+			{Protocol: StampPlain, Addr: &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}},
+			{Protocol: StampDoT, Props: StampDNSSEC, Addr: &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 853},
+				Hashes: [][]byte{make([]byte, 32), {1, 2, 3}}, Hostname: "dot.example.com"},
+			{Protocol: StampDNSCrypt, Addr: &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 443},
+				PublicKey: make([]byte, 32), ProviderName: "2.dnscrypt-cert.example.com"},
+		}
+
+		for _, s := range stamps {
+			parsed, err := ParseStamp(s.String())
+			if NoError(t, err) {
+				Equal(t, s, parsed)
+			}
+		}
+	})
+
+	t.Run("invalid_stamps", func(t *testing.T) {
+		_, err := ParseStamp("https://dns.example.com")
+		Error(t, err)
+		_, err = ParseStamp("sdns://AgcAAAAAAAAABzEuMC4w")
+		Error(t, err)
+		_, err = ParseStamp((&Stamp{Protocol: 0x42}).String())
+		Error(t, err)
+	})
+
+	t.Run("forwarders_need_an_address", func(t *testing.T) {
+		var fs Forwarders
+		Error(t, fs.Set((&Stamp{Protocol: StampDoH, Hostname: "doh.example.com", Path: "/dns-query"}).String()))
+
+		plain := (&Stamp{Protocol: StampPlain, Addr: &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5353}}).String()
+		NoError(t, fs.Set(plain))
+		if Len(t, fs, 1) {
+			Equal(t, "192.0.2.1:5353", fs[0].UDPAddr.String())
+			Equal(t, plain, fs.String())
+		}
+	})
+}
+
+func TestStampedForwarder(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/dns-query" {
+			http.NotFound(w, r)
+			return
+		}
+
+		query, err := dns.ReadPacket(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		response := dns.NewDNSPacket()
+		response.Header.ID = query.Header.ID
+		response.Header.Response = true
+		response.Questions = query.Questions
+		record, _ := dns.ParseRecord("www.example.com. 300 IN A 192.0.2.10", 0)
+		response.Answers = append(response.Answers, record)
+
+		resBuffer := buffer.NewBytePacketBuffer()
+		response.Write(resBuffer)
+		msg, _ := resBuffer.GetRangeAtPos()
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(msg)
+	}))
+	defer server.Close()
+
+	addr := server.Listener.Addr().(*net.TCPAddr)
+	tbs := sha256.Sum256(server.Certificate().RawTBSCertificate)
+
+	resolve := func(hashes [][]byte) (*dns.DNSPacket, error) {
+		var fs Forwarders
+		stamp := &Stamp{Protocol: StampDoH, Addr: &net.UDPAddr{IP: addr.IP, Port: addr.Port},
+			Hashes: hashes, Hostname: "example.com", Path: "/dns-query"}
+		NoError(t, fs.Set(stamp.String()))
+
+		r := NewResolver(&Config{Forwarders: fs})
+		// Trust the test certificate, the hashes are checked on top
+		roots := x509.NewCertPool()
+		roots.AddCert(server.Certificate())
+		exchanger := r.transports[fs[0].String()].(*stampedExchanger).exchanger.(*HTTPSExchanger)
+		exchanger.Client.Transport.(*http.Transport).TLSClientConfig.RootCAs = roots
+
+		return r.Resolve(context.Background(), "www.example.com", dns.AQueryType)
+	}
+
+	t.Run("queries_take_the_stamped_transport", func(t *testing.T) {
+		response, err := resolve(nil)
+		if NoError(t, err) && Len(t, response.Answers, 1) {
+			Equal(t, "192.0.2.10", response.Answers[0].Addr.String())
+		}
+	})
+
+	t.Run("pinned_certificate_hash", func(t *testing.T) {
+		_, err := resolve([][]byte{tbs[:]})
+		NoError(t, err)
+
+		_, err = resolve([][]byte{make([]byte, 32)})
+		Error(t, err)
+	})
+}
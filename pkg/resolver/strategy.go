@@ -0,0 +1,161 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/logger"
+	"github.com/pkg/errors"
+)
+
+// StrategyStep is one way of resolving a name, iteratively from the root or
+// through a forwarder.
+type StrategyStep struct {
+	// Forwarder is asked by a forwarding step, recursive steps have none
+	Forwarder *net.UDPAddr
+	// Source is where the step's queries leave from, any local address
+	// when nil
+	Source *Source
+}
+
+func (s StrategyStep) String() string {
+	step := "recursive"
+	if s.Forwarder != nil {
+		step = "forward:" + s.Forwarder.String()
+	}
+	if s.Source != nil {
+		step += "@" + s.Source.String()
+	}
+
+	return step
+}
+
+// Strategy lists the steps tried in order for the names of a domain. The next
+// step is tried when one fails or answers SERVFAIL, the client sees the
+// failure of the last step.
+type Strategy struct {
+	Domain *buffer.DomainName
+	Steps  []StrategyStep
+}
+
+// ParseStrategy parses a strategy given as "DOMAIN=STEP,STEP", a step is
+// "recursive" or "forward:IP[:PORT]", followed by "@SOURCE" to send its
+// queries from a local IP address or interface. E.g.
+// "corp.example=forward:10.0.0.53@tun0,recursive".
+func ParseStrategy(value string) (*Strategy, error) {
+	i := strings.Index(value, "=")
+	if i < 0 {
+		return nil, errors.Errorf("strategy %q is not DOMAIN=STEP,STEP", value)
+	}
+
+	s := &Strategy{Domain: buffer.NewDomainName(value[:i])}
+	for _, step := range strings.Split(value[i+1:], ",") {
+		var source *Source
+		if at := strings.LastIndex(step, "@"); at >= 0 {
+			var err error
+			if source, err = ParseSource(step[at+1:]); err != nil {
+				return nil, errors.Wrapf(err, "parsing strategy %q", value)
+			}
+			step = step[:at]
+		}
+
+		switch {
+		case step == "recursive":
+			s.Steps = append(s.Steps, StrategyStep{Source: source})
+		case strings.HasPrefix(step, "forward:"):
+			addr, err := ParseForwarder(strings.TrimPrefix(step, "forward:"))
+			if err != nil {
+				return nil, errors.Wrapf(err, "parsing strategy %q", value)
+			}
+			s.Steps = append(s.Steps, StrategyStep{Forwarder: addr, Source: source})
+		default:
+			return nil, errors.Errorf("unknown step %q in strategy %q", step, value)
+		}
+	}
+
+	return s, nil
+}
+
+func (s *Strategy) String() string {
+	steps := make([]string, 0, len(s.Steps))
+	for _, step := range s.Steps {
+		steps = append(steps, step.String())
+	}
+
+	domain := s.Domain.String()
+	if domain == "" {
+		domain = "."
+	}
+
+	return domain + "=" + strings.Join(steps, ",")
+}
+
+// Strategies implements flag.Value, every use of the flag adds a strategy.
+type Strategies []*Strategy
+
+func (ss *Strategies) String() string {
+	strategies := make([]string, 0, len(*ss))
+	for _, s := range *ss {
+		strategies = append(strategies, s.String())
+	}
+
+	return strings.Join(strategies, " ")
+}
+
+func (ss *Strategies) Set(value string) error {
+	s, err := ParseStrategy(value)
+	if err != nil {
+		return err
+	}
+
+	*ss = append(*ss, s)
+	return nil
+}
+
+// strategyFor returns the strategy of the closest domain containing qname,
+// nil when there is none.
+func (r *Resolver) strategyFor(qname string) *Strategy {
+	name := buffer.NewDomainName(qname)
+
+	var closest *Strategy
+	for _, s := range r.strategies {
+		if !name.IsSubdomainOf(s.Domain) {
+			continue
+		}
+		if closest == nil || len(s.Domain.String()) > len(closest.Domain.String()) {
+			closest = s
+		}
+	}
+
+	return closest
+}
+
+// lookupStrategy tries the steps of the strategy until one answers without
+// SERVFAIL.
+func (r *Resolver) lookupStrategy(ctx context.Context, s *Strategy, qname string, qtype dns.QueryType, ecs *dns.ClientSubnet) (*dns.DNSPacket, error) {
+	var response *dns.DNSPacket
+	var err error
+	for _, step := range s.Steps {
+		if step.Forwarder == nil {
+			response, err = r.recursiveLookup(withSource(ctx, step.Source), qname, qtype, ecs)
+		} else {
+			response, err = r.forward(ctx, []*Forwarder{{UDPAddr: step.Forwarder, Source: step.Source}}, qname, qtype, ecs)
+		}
+
+		if err == nil && response.Header.ResCode != dns.ServFail {
+			return response, nil
+		}
+
+		// The client is gone, there is no point in trying the next step
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		logger.Debugf("Resolving %s %s with %s failed\n", qname, qtype, step)
+	}
+
+	return response, err
+}
@@ -0,0 +1,99 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+// transferTimeout bounds a whole zone transfer unless ctx ends it earlier.
+const transferTimeout = time.Minute
+
+// Transfer fetches every record of zone from the primary at address with an
+// AXFR over TCP (RFC5936). The SOA repeated at the end of the transfer is not
+// returned.
+func Transfer(ctx context.Context, address string, zone string) ([]*dns.DNSRecord, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to primary")
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(transferTimeout)
+	}
+	conn.SetDeadline(deadline)
+
+	id, err := utils.RandomUint16()
+	if err != nil {
+		return nil, errors.Wrap(err, "generating query id")
+	}
+
+	query := dns.NewDNSPacket()
+	query.Header.ID = id
+	query.Questions = append(query.Questions, dns.NewDNSQuestion(zone, dns.AXFRQueryType))
+
+	if _, err := query.WriteTo(conn); err != nil {
+		return nil, errors.Wrap(err, "sending transfer request")
+	}
+
+	// The transfer starts and ends with the SOA of the zone and may span
+	// any number of messages
+	records := make([]*dns.DNSRecord, 0)
+	for {
+		response, err := dns.ReadPacket(conn)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading transfer response")
+		}
+
+		if response.Header.ID != id {
+			return nil, errors.New("transfer response doesn't match the query")
+		}
+		if err := response.Err(); err != nil {
+			return nil, errors.Wrapf(err, "transferring %s", zone)
+		}
+
+		for _, r := range response.Answers {
+			if r.QType == dns.SOAQueryType && len(records) > 0 {
+				return records, nil
+			}
+			if len(records) == 0 && r.QType != dns.SOAQueryType {
+				return nil, errors.New("transfer doesn't start with the SOA")
+			}
+			records = append(records, r)
+		}
+	}
+}
+
+// QuerySOA asks the primary at address for the SOA of zone, secondaries
+// compare its serial with theirs to tell whether to transfer the zone again
+// (RFC1034 4.3.5).
+func QuerySOA(ctx context.Context, address string, zone string) (*dns.DNSRecord, error) {
+	e := NewUDPExchanger()
+	defer e.Close()
+
+	query := dns.NewDNSPacket()
+	query.Questions = append(query.Questions, dns.NewDNSQuestion(zone, dns.SOAQueryType))
+
+	response, err := e.Exchange(ctx, query, address)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying primary for the SOA")
+	}
+	if err := response.Err(); err != nil {
+		return nil, errors.Wrapf(err, "querying SOA of %s", zone)
+	}
+
+	for _, r := range response.Answers {
+		if r.QType == dns.SOAQueryType && r.Domain.Normalized() == query.Questions[0].Name.Normalized() {
+			return r, nil
+		}
+	}
+
+	return nil, errors.Errorf("primary has no SOA for %s", zone)
+}
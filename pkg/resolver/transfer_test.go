@@ -0,0 +1,76 @@
+package resolver_test
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/resolver"
+)
+
+// servePrimary answers one AXFR with the records split over two messages.
+func servePrimary(t *testing.T, ln net.Listener, records []string) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var length [2]byte
+	io.ReadFull(conn, length[:])
+	reqBuffer := buffer.NewBytePacketBuffer()
+	reqBuffer.Buf = make([]byte, binary.BigEndian.Uint16(length[:]))
+	io.ReadFull(conn, reqBuffer.Buf)
+	query, err := dns.DNSPacketFromBuffer(reqBuffer)
+	if !NoError(t, err) || !Equal(t, dns.AXFRQueryType, query.Questions[0].QType) {
+		return
+	}
+
+	for _, part := range [][]string{records[:2], records[2:]} {
+		response := dns.NewDNSPacket()
+		response.Header.ID = query.Header.ID
+		response.Header.Response = true
+		for _, text := range part {
+			r, _ := dns.ParseRecord(text, 300)
+			response.Answers = append(response.Answers, r)
+		}
+
+		resBuffer := buffer.NewBytePacketBuffer()
+		NoError(t, response.Write(resBuffer))
+		binary.BigEndian.PutUint16(length[:], uint16(resBuffer.Pos()))
+		conn.Write(append(length[:], resBuffer.Buf[:resBuffer.Pos()]...))
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	t.Run("collects_records_until_second_soa", func(t *testing.T) {
+		soa := "rpz.example. SOA ns.rpz.example. admin.rpz.example. 1 3600 600 86400 60"
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		NoError(t, err)
+		defer ln.Close()
+		go servePrimary(t, ln, []string{soa, "bad.example.com.rpz.example. CNAME .", "www.rpz.example. A 1.2.3.4", soa})
+
+		records, err := resolver.Transfer(context.Background(), ln.Addr().String(), "rpz.example")
+		NoError(t, err)
+		if Len(t, records, 3) {
+			Equal(t, dns.SOAQueryType, records[0].QType)
+			Equal(t, "www.rpz.example. 300 IN A 1.2.3.4", records[2].Text())
+		}
+	})
+
+	t.Run("transfer_must_start_with_soa", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		NoError(t, err)
+		defer ln.Close()
+		go servePrimary(t, ln, []string{"www.rpz.example. A 1.2.3.4", "www.rpz.example. A 1.2.3.5", "www.rpz.example. A 1.2.3.6"})
+
+		_, err = resolver.Transfer(context.Background(), ln.Addr().String(), "rpz.example")
+		Error(t, err)
+	})
+}
@@ -0,0 +1,144 @@
+// Package rpz applies Response Policy Zones to client queries. Only QNAME
+// triggers are supported, IP and name server triggers are skipped.
+package rpz
+
+import (
+	"context"
+	"sync"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/zone"
+	"github.com/pkg/errors"
+)
+
+// Action is what a matching rule does with the query.
+type Action int
+
+const (
+	// ActionNXDomain answers that the name doesn't exist
+	ActionNXDomain Action = iota
+	// ActionNoData answers that the name has no records of the type
+	ActionNoData
+	// ActionPassthru resolves the query normally and stops evaluating
+	// further rules
+	ActionPassthru
+	// ActionLocalData answers with the records of the rule
+	ActionLocalData
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionNXDomain:
+		return "NXDOMAIN"
+	case ActionNoData:
+		return "NODATA"
+	case ActionPassthru:
+		return "PASSTHRU"
+	default:
+		return "Local-Data"
+	}
+}
+
+// Hit is the rule a query matched.
+type Hit struct {
+	Zone    string
+	Trigger string
+	Action  Action
+	// SOA of the policy zone, answers rewritten by it carry it in the
+	// authority section
+	SOA     *dns.DNSRecord
+	records []*dns.DNSRecord
+}
+
+// Records returns the local data answering a query for name and qtype, a
+// CNAME answers queries of any type.
+func (h *Hit) Records(name string, qtype dns.QueryType) []*dns.DNSRecord {
+	answers := make([]*dns.DNSRecord, 0)
+	for _, r := range h.records {
+		if r.QType != qtype && r.QType != dns.CNAMEQueryType {
+			continue
+		}
+
+		answer := *r
+		answer.Domain = buffer.NewDomainName(name)
+		answers = append(answers, &answer)
+	}
+
+	return answers
+}
+
+// Policy holds the policy zones, it is safe for concurrent use and can be
+// reloaded while queries are matched.
+type Policy struct {
+	sources zone.Sources
+
+	mu    sync.RWMutex
+	zones []*Zone
+}
+
+// New returns the policy of the zones, they are consulted in the order given.
+func New(sources zone.Sources) *Policy {
+	return &Policy{
+		sources: sources,
+		zones:   make([]*Zone, len(sources)),
+	}
+}
+
+// Load (re)loads every policy zone. A zone that fails to load keeps its
+// previous rules, the first error is returned.
+func (p *Policy) Load(ctx context.Context) error {
+	var first error
+	for i, source := range p.sources {
+		z, err := loadZone(ctx, source)
+		if err != nil {
+			if first == nil {
+				first = errors.Wrapf(err, "loading policy zone %s", source.Zone)
+			}
+			continue
+		}
+
+		p.mu.Lock()
+		p.zones[i] = z
+		p.mu.Unlock()
+	}
+
+	return first
+}
+
+// Zones returns the loaded policy zones in the order they are consulted.
+func (p *Policy) Zones() []*Zone {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	zones := make([]*Zone, 0, len(p.zones))
+	for _, z := range p.zones {
+		if z != nil {
+			zones = append(zones, z)
+		}
+	}
+
+	return zones
+}
+
+// Match returns the rule applying to name or nil. The first zone with a
+// matching rule decides, inside a zone an exact trigger wins over wildcards
+// and longer wildcards win over shorter ones.
+func (p *Policy) Match(name string) *Hit {
+	for _, z := range p.Zones() {
+		if hit := z.match(name); hit != nil {
+			return hit
+		}
+	}
+
+	return nil
+}
+
+func loadZone(ctx context.Context, source zone.Source) (*Zone, error) {
+	records, err := source.Records(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewZone(source.Zone, records), nil
+}
@@ -0,0 +1,106 @@
+package rpz_test
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/rpz"
+	"github.com/msarvar/godns/pkg/zone"
+)
+
+const policyZone = `$TTL 300
+@ SOA ns.rpz.example. admin.rpz.example. 1 3600 600 86400 60
+@ NS ns.rpz.example.
+bad.example.com CNAME .
+*.bad.example.com CNAME .
+empty.example.com CNAME *.
+ok.bad.example.com CNAME rpz-passthru.
+*.example.com A 10.0.0.1
+*.example.com AAAA ::1
+garden.example.net CNAME walled.example.org.
+drop.example.com CNAME rpz-drop.
+32.1.0.0.127.rpz-ip CNAME .
+`
+
+func loadPolicy(t *testing.T, zones ...string) *rpz.Policy {
+	sources := make(zone.Sources, 0)
+	for i, zone := range zones {
+		path := filepath.Join(t.TempDir(), "policy.zone")
+		NoError(t, ioutil.WriteFile(path, []byte(zone), 0644))
+		NoError(t, sources.Set([]string{"rpz.example", "second.example"}[i]+"="+path))
+	}
+
+	p := rpz.New(sources)
+	NoError(t, p.Load(context.Background()))
+	return p
+}
+
+func TestPolicy(t *testing.T) {
+	t.Run("actions_and_precedence", func(t *testing.T) {
+		p := loadPolicy(t, policyZone)
+
+		z := p.Zones()[0]
+		Equal(t, "rpz.example", z.Name)
+		Equal(t, 6, z.Len())
+		Equal(t, 2, z.Skipped)
+
+		for name, action := range map[string]rpz.Action{
+			"bad.example.com":     rpz.ActionNXDomain,
+			"x.y.bad.example.com": rpz.ActionNXDomain,
+			"EMPTY.example.com.":  rpz.ActionNoData,
+			"ok.bad.example.com":  rpz.ActionPassthru,
+			"www.example.com":     rpz.ActionLocalData,
+			"garden.example.net":  rpz.ActionLocalData,
+		} {
+			hit := p.Match(name)
+			if NotNil(t, hit, name) {
+				Equal(t, action, hit.Action, name)
+			}
+		}
+
+		Nil(t, p.Match("example.com"))
+		Nil(t, p.Match("drop.example.net"))
+		Equal(t, "*.bad.example.com", p.Match("x.bad.example.com").Trigger)
+	})
+
+	t.Run("local_data_answers", func(t *testing.T) {
+		p := loadPolicy(t, policyZone)
+
+		records := p.Match("www.example.com").Records("www.example.com", dns.AAAAQueryType)
+		if Len(t, records, 1) {
+			Equal(t, "www.example.com. 300 IN AAAA ::1", records[0].Text())
+		}
+
+		records = p.Match("garden.example.net").Records("garden.example.net", dns.AQueryType)
+		if Len(t, records, 1) {
+			Equal(t, "garden.example.net. 300 IN CNAME walled.example.org.", records[0].Text())
+		}
+
+		Empty(t, p.Match("www.example.com").Records("www.example.com", dns.MXQueryType))
+	})
+
+	t.Run("first_zone_wins", func(t *testing.T) {
+		p := loadPolicy(t, "www.example.com CNAME rpz-passthru.\n", policyZone)
+
+		Equal(t, rpz.ActionPassthru, p.Match("www.example.com").Action)
+		Equal(t, rpz.ActionLocalData, p.Match("mail.example.com").Action)
+	})
+
+	t.Run("failed_reload_keeps_rules", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "policy.zone")
+		NoError(t, ioutil.WriteFile(path, []byte(policyZone), 0644))
+
+		p := rpz.New(zone.Sources{{Zone: "rpz.example", Location: path}})
+		NoError(t, p.Load(context.Background()))
+
+		NoError(t, ioutil.WriteFile(path, []byte("www A not-an-address\n"), 0644))
+		Error(t, p.Load(context.Background()))
+		NotNil(t, p.Match("bad.example.com"))
+	})
+
+}
@@ -0,0 +1,136 @@
+package rpz
+
+import (
+	"strings"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+// unsupportedTriggers are the trigger kinds other than QNAME, rules using
+// them are skipped.
+var unsupportedTriggers = []string{"rpz-ip", "rpz-nsip", "rpz-nsdname", "rpz-client-ip"}
+
+// Zone is a loaded policy zone. Its rules are keyed by trigger, the owner
+// name without the zone suffix like "ads.example.com" or "*.example.com".
+type Zone struct {
+	Name string
+	// Skipped counts the records that use unsupported triggers or actions
+	Skipped int
+
+	soa   *dns.DNSRecord
+	rules map[string]*rule
+}
+
+type rule struct {
+	action  Action
+	records []*dns.DNSRecord
+}
+
+// NewZone builds the rules from the records of the policy zone. A CNAME to
+// "." means NXDOMAIN, to "*." NODATA and to "rpz-passthru." PASSTHRU, any
+// other records are local data.
+func NewZone(name string, records []*dns.DNSRecord) *Zone {
+	z := &Zone{
+		Name:  buffer.NewDomainName(name).Normalized(),
+		rules: make(map[string]*rule),
+	}
+
+	for _, r := range records {
+		owner := r.Domain.Normalized()
+		if owner == z.Name {
+			if r.QType == dns.SOAQueryType {
+				z.soa = r
+			}
+			continue
+		}
+
+		if !r.Domain.IsSubdomainOf(buffer.NewDomainName(z.Name)) {
+			z.Skipped++
+			continue
+		}
+
+		trigger := strings.TrimSuffix(strings.TrimSuffix(owner, z.Name), ".")
+		if unsupportedTrigger(trigger) {
+			z.Skipped++
+			continue
+		}
+
+		action := ActionLocalData
+		if r.QType == dns.CNAMEQueryType {
+			switch target := r.Host.Normalized(); {
+			case target == "":
+				action = ActionNXDomain
+			case target == "*":
+				action = ActionNoData
+			case target == "rpz-passthru":
+				action = ActionPassthru
+			case strings.HasPrefix(target, "rpz-"), strings.HasPrefix(target, "*."):
+				// rpz-drop, rpz-tcp-only and wildcard CNAMEs aren't supported
+				z.Skipped++
+				continue
+			}
+		}
+
+		ru, ok := z.rules[trigger]
+		if !ok {
+			ru = &rule{action: action}
+			z.rules[trigger] = ru
+		}
+
+		if action != ActionLocalData {
+			ru.action = action
+			ru.records = nil
+			continue
+		}
+		if ru.action == ActionLocalData {
+			ru.records = append(ru.records, r)
+		}
+	}
+
+	return z
+}
+
+func unsupportedTrigger(trigger string) bool {
+	for _, t := range unsupportedTriggers {
+		if trigger == t || strings.HasSuffix(trigger, "."+t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Len returns the number of rules.
+func (z *Zone) Len() int {
+	return len(z.rules)
+}
+
+func (z *Zone) match(name string) *Hit {
+	labels := buffer.NewDomainName(name).SplitLabels()
+	normalized := strings.ToLower(strings.Join(labels, "."))
+
+	if ru, ok := z.rules[normalized]; ok {
+		return z.hit(normalized, ru)
+	}
+
+	// Wildcards match names below their parent but not the parent itself
+	for i := 1; i < len(labels); i++ {
+		trigger := "*." + strings.ToLower(strings.Join(labels[i:], "."))
+		if ru, ok := z.rules[trigger]; ok {
+			return z.hit(trigger, ru)
+		}
+	}
+
+	return nil
+}
+
+func (z *Zone) hit(trigger string, ru *rule) *Hit {
+	return &Hit{
+		Zone:    z.Name,
+		Trigger: trigger,
+		Action:  ru.action,
+		SOA:     z.soa,
+		records: ru.records,
+	}
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/msarvar/godns/pkg/logger"
+	"github.com/pkg/errors"
+)
+
+// serveAdmin starts the admin API on AdminAddress.
+func (s *Server) serveAdmin() (io.Closer, error) {
+	network, address := "tcp", s.config.AdminAddress
+	if strings.HasPrefix(address, "unix:") {
+		network, address = "unix", strings.TrimPrefix(address, "unix:")
+		// A socket left behind by an unclean shutdown blocks listening
+		os.Remove(address)
+	} else if s.config.AdminToken == "" {
+		return nil, errors.New("admin api on tcp requires a token")
+	}
+
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "listening on admin address %s", s.config.AdminAddress)
+	}
+
+	admin := &http.Server{Handler: s.adminHandler()}
+	go admin.Serve(ln)
+
+	return admin, nil
+}
+
+// adminHandler serves the operations inspecting and changing the running
+// server:
+//
+//	POST /cache/flush[?name=NAME]  drops the whole cache or the entries of NAME
+//	POST /blocklist/reload         rereads the blocklist file
+//	GET|POST /blocking[?enabled=]  shows or toggles blocking
+//	POST /rpz/reload               reloads the response policy zones
+//	POST /zones/reload             reloads the authoritative zones
+//	GET|POST /log-level[?level=]   shows or changes the log level
+//	GET /stats                     reports counters as "name value" lines
+//	GET /upstreams                 reports whether each forwarder is up
+//	GET /dump                      reports caches, resolutions in progress,
+//	                               upstream round trip times and goroutines
+//	GET /traffic                   reports query rates and the top names and
+//	                               clients as JSON, with the dashboard
+//	GET /dashboard                 serves the dashboard, without a token
+//
+// Flushes, reloads and toggling blocking are repeated on the configured peers.
+func (s *Server) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/cache/flush", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		n := 0
+		for _, c := range s.caches() {
+			if name := r.URL.Query().Get("name"); name != "" {
+				n += c.FlushName(name)
+			} else {
+				n += c.Flush()
+			}
+		}
+
+		logger.Infof("Flushed %d cached responses\n", n)
+		fmt.Fprintf(w, "flushed %d\n", n)
+	})
+
+	mux.HandleFunc("/blocklist/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !s.hasBlocklist() {
+			http.Error(w, "no blocklist configured", http.StatusNotFound)
+			return
+		}
+
+		n, err := s.loadBlocklists()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		logger.Infof("Reloaded %d blocked domains\n", n)
+		fmt.Fprintf(w, "loaded %d\n", n)
+	})
+
+	mux.HandleFunc("/rpz/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if s.policy == nil {
+			http.Error(w, "no policy zones configured", http.StatusNotFound)
+			return
+		}
+
+		if err := s.loadPolicy(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		fmt.Fprintf(w, "loaded %d\n", len(s.policy.Zones()))
+	})
+
+	mux.HandleFunc("/zones/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !s.hasZones() {
+			http.Error(w, "no zones configured", http.StatusNotFound)
+			return
+		}
+
+		n, err := s.loadZones(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		fmt.Fprintf(w, "loaded %d\n", n)
+	})
+
+	mux.HandleFunc("/blocking", func(w http.ResponseWriter, r *http.Request) {
+		if !s.hasBlocklist() {
+			http.Error(w, "no blocklist configured", http.StatusNotFound)
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+			if err != nil {
+				http.Error(w, "enabled must be true or false", http.StatusBadRequest)
+				return
+			}
+
+			var value int32
+			if enabled {
+				value = 1
+			}
+			atomic.StoreInt32(&s.blocking, value)
+			logger.Infof("Blocking enabled: %t\n", enabled)
+		}
+
+		fmt.Fprintln(w, atomic.LoadInt32(&s.blocking) == 1)
+	})
+
+	mux.HandleFunc("/log-level", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			var level logger.Level
+			if err := level.Set(r.URL.Query().Get("level")); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			logger.SetLevel(level)
+		}
+
+		fmt.Fprintln(w, logger.GetLevel())
+	})
+
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		s.stats.write(w, s)
+	})
+
+	mux.HandleFunc("/upstreams", func(w http.ResponseWriter, r *http.Request) {
+		for _, h := range s.upstreamHealth() {
+			state := "up"
+			if !h.Healthy {
+				state = "down"
+			}
+			fmt.Fprintf(w, "%s %s %s failures %d", h.view, h.Addr, state, h.Failures)
+			if h.LastError != "" {
+				fmt.Fprintf(w, " error %q", h.LastError)
+			}
+			fmt.Fprintln(w)
+		}
+	})
+
+	mux.HandleFunc("/dump", func(w http.ResponseWriter, r *http.Request) {
+		s.dump(w, time.Now())
+	})
+
+	mux.HandleFunc("/traffic", func(w http.ResponseWriter, r *http.Request) {
+		if s.traffic == nil {
+			http.Error(w, "dashboard not enabled", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.traffic.report(time.Now(), dashboardTop))
+	})
+
+	api := s.authorize(s.propagating(mux))
+	if s.traffic == nil {
+		return api
+	}
+
+	root := http.NewServeMux()
+	root.Handle("/", api)
+	root.HandleFunc("/dashboard", serveDashboard)
+	return root
+}
+
+// authorize rejects requests without the admin token, without a token only
+// access to the unix socket protects the API.
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.config.AdminToken != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/logger"
+)
+
+func TestAdminHandler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	NoError(t, ioutil.WriteFile(path, []byte("ads.example.com\n"), 0644))
+
+	cfg := DefaultConfig()
+	cfg.BlocklistFile = path
+	cfg.AdminToken = "secret"
+	s := NewServer(cfg)
+	handler := s.adminHandler()
+
+	request := func(method string, target string, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("requires_token", func(t *testing.T) {
+		Equal(t, http.StatusUnauthorized, request(http.MethodPost, "/cache/flush", "").Code)
+		Equal(t, http.StatusUnauthorized, request(http.MethodPost, "/cache/flush", "wrong").Code)
+	})
+
+	t.Run("flushes_cache_by_name", func(t *testing.T) {
+		response := dns.NewDNSPacket()
+		r, _ := dns.ParseRecord("www.example.com. 300 IN A 1.2.3.4", 0)
+		response.Answers = append(response.Answers, r)
+		s.defaultView().cache.Put("www.example.com", dns.AQueryType, nil, response, time.Now())
+		s.defaultView().cache.Put("www.example.org", dns.AQueryType, nil, response, time.Now())
+
+		w := request(http.MethodPost, "/cache/flush?name=WWW.example.com.", "secret")
+		Equal(t, http.StatusOK, w.Code)
+		Equal(t, "flushed 1\n", w.Body.String())
+
+		Equal(t, "flushed 1\n", request(http.MethodPost, "/cache/flush", "secret").Body.String())
+		Equal(t, http.StatusMethodNotAllowed, request(http.MethodGet, "/cache/flush", "secret").Code)
+	})
+
+	t.Run("reloads_and_toggles_blocking", func(t *testing.T) {
+		Equal(t, "loaded 1\n", request(http.MethodPost, "/blocklist/reload", "secret").Body.String())
+		True(t, s.blocked(s.defaultView(), nil, "ads.example.com"))
+
+		Equal(t, "false\n", request(http.MethodPost, "/blocking?enabled=false", "secret").Body.String())
+		False(t, s.blocked(s.defaultView(), nil, "ads.example.com"))
+
+		Equal(t, "true\n", request(http.MethodPost, "/blocking?enabled=true", "secret").Body.String())
+		True(t, s.blocked(s.defaultView(), nil, "ads.example.com"))
+	})
+
+	t.Run("reports_upstream_health", func(t *testing.T) {
+		dead, err := net.ListenPacket("udp", "127.0.0.1:0")
+		NoError(t, err)
+		dead.Close()
+
+		cfg := DefaultConfig()
+		cfg.AdminToken = "secret"
+		NoError(t, cfg.Forwarders.Set(dead.LocalAddr().String()))
+		s := NewServer(cfg)
+		for i := 0; i < 3; i++ {
+			s.defaultView().resolver.ProbeForwarders(context.Background())
+		}
+
+		r := httptest.NewRequest(http.MethodGet, "/upstreams", nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		s.adminHandler().ServeHTTP(w, r)
+		True(t, strings.HasPrefix(w.Body.String(), "default "+dead.LocalAddr().String()+" down failures 3 error "), w.Body.String())
+	})
+
+	t.Run("changes_log_level", func(t *testing.T) {
+		defer logger.SetLevel(logger.GetLevel())
+
+		w := request(http.MethodPost, "/log-level?level=error", "secret")
+		Equal(t, "error\n", w.Body.String())
+		Equal(t, logger.LevelError, logger.GetLevel())
+
+		w = request(http.MethodPost, "/log-level?level=loud", "secret")
+		Equal(t, http.StatusBadRequest, w.Code)
+		True(t, strings.Contains(w.Body.String(), "unknown log level"))
+	})
+
+	t.Run("reports_stats", func(t *testing.T) {
+		w := request(http.MethodGet, "/stats", "secret")
+		Equal(t, http.StatusOK, w.Code)
+		Contains(t, w.Body.String(), "queries 0\n")
+		Contains(t, w.Body.String(), "blocking true\n")
+		Contains(t, w.Body.String(), "blocklist_fetch_failures 0\n")
+	})
+
+	t.Run("dumps_state", func(t *testing.T) {
+		response := dns.NewDNSPacket()
+		r, _ := dns.ParseRecord("www.example.com. 300 IN A 1.2.3.4", 0)
+		response.Answers = append(response.Answers, r)
+		s.defaultView().cache.Put("www.example.com", dns.AQueryType, nil, response, time.Now())
+		s.defaultView().cache.Get("www.example.com", dns.AQueryType, nil, time.Now())
+
+		w := request(http.MethodGet, "/dump", "secret")
+		Equal(t, http.StatusOK, w.Code)
+		Contains(t, w.Body.String(), "cache default entries 1 ")
+		Contains(t, w.Body.String(), "cache_types default A=1\n")
+		Contains(t, w.Body.String(), "cache_hot default www.example.com A hits 1\n")
+		Contains(t, w.Body.String(), "goroutine profile:")
+	})
+}
+
+func TestPeers(t *testing.T) {
+	t.Run("parse_peers", func(t *testing.T) {
+		var ps Peers
+		NoError(t, ps.Set("http://10.0.0.2:8053"))
+		NoError(t, ps.Set("https://godns-2.example/admin"))
+		Equal(t, "http://10.0.0.2:8053,https://godns-2.example/admin", ps.String())
+
+		for _, value := range []string{"10.0.0.2:8053", "unix:/run/godns.sock", "http://"} {
+			Error(t, ps.Set(value), value)
+		}
+	})
+
+	// b and c are peers of a, c is also a peer of b and must not loop
+	servers := make([]*Server, 3)
+	urls := make([]string, 3)
+	for i := range servers {
+		cfg := DefaultConfig()
+		cfg.AdminToken = "secret"
+		servers[i] = NewServer(cfg)
+
+		peer := httptest.NewServer(servers[i].adminHandler())
+		defer peer.Close()
+		urls[i] = peer.URL
+	}
+	a, b, c := servers[0], servers[1], servers[2]
+	NoError(t, a.config.Peers.Set(urls[1]))
+	NoError(t, a.config.Peers.Set(urls[2]))
+	NoError(t, b.config.Peers.Set(urls[2]))
+
+	response := dns.NewDNSPacket()
+	r, _ := dns.ParseRecord("www.example.com. 300 IN A 1.2.3.4", 0)
+	response.Answers = append(response.Answers, r)
+	for _, s := range servers {
+		s.defaultView().cache.Put("www.example.com", dns.AQueryType, nil, response, time.Now())
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/cache/flush", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	a.adminHandler().ServeHTTP(w, req)
+	Equal(t, "flushed 1\n", w.Body.String())
+
+	t.Run("flush_reaches_peers", func(t *testing.T) {
+		for _, s := range []*Server{b, c} {
+			cache := s.defaultView().cache
+			Eventually(t, func() bool { return cache.Len() == 0 }, time.Second, 10*time.Millisecond)
+		}
+	})
+}
+
+func TestDashboard(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "secret"
+	cfg.Dashboard = true
+	s := NewServer(cfg)
+	handler := s.adminHandler()
+
+	request := func(target string, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("page_needs_no_token", func(t *testing.T) {
+		w := request("/dashboard", "")
+		Equal(t, http.StatusOK, w.Code)
+		Contains(t, w.Body.String(), "<title>godns</title>")
+	})
+
+	t.Run("reports_traffic", func(t *testing.T) {
+		response := dns.NewDNSPacket()
+		response.Header.Response = true
+		response.Questions = append(response.Questions, dns.NewDNSQuestion("www.example.com", dns.AQueryType))
+		resBuffer := buffer.NewBytePacketBuffer()
+		NoError(t, response.Write(resBuffer))
+		data, err := resBuffer.GetRangeAtPos()
+		NoError(t, err)
+		s.logQuery(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5353}, data, time.Now())
+
+		Equal(t, http.StatusUnauthorized, request("/traffic", "").Code)
+
+		w := request("/traffic", "secret")
+		Equal(t, http.StatusOK, w.Code)
+		var report trafficReport
+		NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+		Equal(t, []topEntry{{"www.example.com", 1}}, report.TopNames)
+		Equal(t, []topEntry{{"192.0.2.1", 1}}, report.TopClients)
+		Equal(t, uint64(1), report.Rates[len(report.Rates)-1])
+	})
+
+	t.Run("disabled_without_the_flag", func(t *testing.T) {
+		cfg := DefaultConfig()
+		cfg.AdminToken = "secret"
+		handler := NewServer(cfg).adminHandler()
+
+		r := httptest.NewRequest(http.MethodGet, "/dashboard", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/logger"
+)
+
+// otherClass reports whether q is asked in another class than IN, those
+// questions are answered by answerClass and never resolved.
+func otherClass(q *dns.DNSQuestion) bool {
+	return q.Class != dns.ClassIN && q.Class != dns.ClassANY
+}
+
+// answerClass answers a question of another class than IN. CHAOS TXT
+// queries for version.bind and hostname.bind, or their RFC4892 names
+// version.server and id.server, get the configured strings, everything else
+// is refused.
+func (s *Server) answerClass(packet *dns.DNSPacket, q *dns.DNSQuestion) []*dns.ExtendedError {
+	pq := *q
+	packet.Questions = append(packet.Questions, &pq)
+	logger.Infof("Received query: %s\n", q)
+
+	var text string
+	if q.Class == dns.ClassCH && (q.QType == dns.TXTQueryType || q.QType == dns.ANYQueryType) {
+		switch strings.ToLower(q.Name.String()) {
+		case "version.bind", "version.server":
+			text = s.config.ChaosVersion
+		case "hostname.bind", "id.server":
+			text = s.config.ChaosHostname
+		}
+	}
+
+	if text == "" {
+		packet.Header.ResCode = dns.Refused
+		return []*dns.ExtendedError{{Code: dns.EDENotSupported}}
+	}
+
+	record, err := dns.NewTXTRecord(q.Name.String(), 0, text)
+	if err != nil {
+		logger.Errorf("Error: answering %s: %s\n", q, err)
+		packet.Header.ResCode = dns.ServFail
+		return []*dns.ExtendedError{{Code: dns.EDEOther}}
+	}
+	record.Class = dns.ClassCH
+
+	packet.Header.AuthoritativeAnswer = true
+	packet.Answers = append(packet.Answers, record)
+	return nil
+}
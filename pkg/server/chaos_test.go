@@ -0,0 +1,54 @@
+package server
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+func TestChaos(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ChaosVersion = "godns 1.0"
+	s := NewServer(cfg)
+
+	query := func(name string, class uint16, qtype dns.QueryType) *dns.DNSPacket {
+		request := dns.NewDNSPacket()
+		request.Header.ID = 4660
+		request.Header.RecursionDesired = true
+		q := dns.NewDNSQuestion(name, qtype)
+		q.Class = class
+		request.Questions = append(request.Questions, q)
+		request.Resources = append(request.Resources, dns.NewOPTRecord(dns.DefaultUDPPayloadSize))
+		return exchange(t, s, request)
+	}
+
+	t.Run("version", func(t *testing.T) {
+		for _, name := range []string{"version.bind", "VERSION.SERVER"} {
+			response := query(name, dns.ClassCH, dns.TXTQueryType)
+			Equal(t, dns.NoError, response.Header.ResCode)
+			True(t, response.Header.AuthoritativeAnswer)
+			Equal(t, dns.ClassCH, response.Questions[0].Class)
+			if Len(t, response.Answers, 1) {
+				Equal(t, dns.ClassCH, response.Answers[0].Class)
+				texts, err := response.Answers[0].TXT()
+				NoError(t, err)
+				Equal(t, []string{"godns 1.0"}, texts)
+			}
+		}
+	})
+
+	t.Run("unset_strings_are_refused", func(t *testing.T) {
+		response := query("hostname.bind", dns.ClassCH, dns.TXTQueryType)
+		Equal(t, dns.Refused, response.Header.ResCode)
+		Empty(t, response.Answers)
+		Equal(t, []*dns.ExtendedError{{Code: dns.EDENotSupported}}, response.ExtendedErrors())
+	})
+
+	t.Run("other_classes_are_refused", func(t *testing.T) {
+		Equal(t, dns.Refused, query("authors.bind", dns.ClassCH, dns.TXTQueryType).Header.ResCode)
+		Equal(t, dns.Refused, query("version.bind", dns.ClassCH, dns.AQueryType).Header.ResCode)
+		Equal(t, dns.Refused, query("www.example.com", dns.ClassHS, dns.TXTQueryType).Header.ResCode)
+	})
+}
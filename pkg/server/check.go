@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+
+	"github.com/msarvar/godns/pkg/resolver"
+	"github.com/pkg/errors"
+)
+
+// Check loads what Serve loads, the zones, blocklists, hosts and lease files
+// and policy zones, and makes sure the upstreams answer, without binding any
+// listener. Every problem found is returned rather than only the first, a
+// deployment can be held back until there are none.
+func (s *Server) Check(ctx context.Context) []error {
+	var problems []error
+	fail := func(err error) {
+		if err != nil {
+			problems = append(problems, err)
+		}
+	}
+
+	if s.config.Proxy && len(s.config.Forwarders) == 0 {
+		fail(errors.New("proxy mode needs forwarders, add -forward"))
+	}
+
+	for _, v := range s.views {
+		if v.blocklist != nil {
+			_, err := v.blocklist.Load()
+			fail(errors.Wrapf(err, "loading blocklist of view %s", v.name))
+		}
+		if v.allowlist != nil {
+			_, err := v.allowlist.Load()
+			fail(errors.Wrapf(err, "loading allowlist of view %s", v.name))
+		}
+		if v.zones != nil {
+			fail(errors.Wrapf(v.zones.Load(ctx), "view %s", v.name))
+		}
+	}
+
+	for _, g := range s.groups {
+		if g.blocklist != nil {
+			_, err := g.blocklist.Load()
+			fail(errors.Wrapf(err, "loading blocklist of client group %s", g.name))
+		}
+		if g.allowlist != nil {
+			_, err := g.allowlist.Load()
+			fail(errors.Wrapf(err, "loading allowlist of client group %s", g.name))
+		}
+	}
+
+	if s.hosts != nil {
+		_, err := s.hosts.Load()
+		fail(err)
+	}
+	if s.leases != nil {
+		_, err := s.leases.Load()
+		fail(err)
+	}
+	if s.policy != nil {
+		fail(s.policy.Load(ctx))
+	}
+
+	seen := make(map[*resolver.Resolver]bool)
+	for _, v := range s.views {
+		if seen[v.resolver] {
+			continue
+		}
+		seen[v.resolver] = true
+
+		problems = append(problems, checkUpstreams(ctx, v)...)
+	}
+
+	return problems
+}
+
+// checkUpstreams makes sure every forwarder of the view answers, or one of
+// the root servers when it resolves recursively.
+func checkUpstreams(ctx context.Context, v *view) []error {
+	health := v.resolver.Health()
+	if len(health) == 0 {
+		if err := v.resolver.ProbeRoots(ctx); err != nil {
+			return []error{errors.Wrapf(err, "no root server answered view %s, check the network or add -forward", v.name)}
+		}
+		return nil
+	}
+
+	v.resolver.ProbeForwarders(ctx)
+
+	var problems []error
+	for _, h := range v.resolver.Health() {
+		if h.Failures > 0 {
+			problems = append(problems, errors.Errorf("forwarder %s of view %s doesn't answer: %s", h.Addr, v.name, h.LastError))
+		}
+	}
+
+	return problems
+}
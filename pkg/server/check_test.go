@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/dnstest"
+)
+
+func TestCheck(t *testing.T) {
+	zone := `
+@	IN SOA	ns.example.com. hostmaster.example.com. 1 7200 3600 1209600 300
+@	IN NS	ns.example.com.
+ns	IN A	192.0.2.53
+`
+	ns := dnstest.NewServer(t, map[string]string{"example.com": zone})
+
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.zone")
+	NoError(t, ioutil.WriteFile(good, []byte(zone), 0644))
+	bad := filepath.Join(dir, "bad.zone")
+	NoError(t, ioutil.WriteFile(bad, []byte("www IN A not-an-address\n"), 0644))
+
+	t.Run("valid_config_has_no_problems", func(t *testing.T) {
+		cfg := DefaultConfig()
+		NoError(t, cfg.Zones.Set("example.com="+good))
+		NoError(t, cfg.Forwarders.Set(ns.Addr.String()))
+
+		Empty(t, NewServer(cfg).Check(context.Background()))
+	})
+
+	t.Run("every_problem_is_reported", func(t *testing.T) {
+		dead, err := net.ListenPacket("udp", "127.0.0.1:0")
+		NoError(t, err)
+		dead.Close()
+
+		cfg := DefaultConfig()
+		NoError(t, cfg.Zones.Set("example.com="+bad))
+		NoError(t, cfg.Forwarders.Set(ns.Addr.String()))
+		NoError(t, cfg.Forwarders.Set(dead.LocalAddr().String()))
+		cfg.BlocklistFile = filepath.Join(dir, "missing.txt")
+
+		problems := NewServer(cfg).Check(context.Background())
+		if Len(t, problems, 3) {
+			Contains(t, problems[0].Error(), "blocklist")
+			Contains(t, problems[1].Error(), "zone example.com")
+			Contains(t, problems[2].Error(), dead.LocalAddr().String())
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/msarvar/godns/pkg/logger"
+	"github.com/pkg/errors"
+)
+
+// propagatedHeader marks admin requests repeated by a peer, they aren't
+// repeated again so that a full mesh of peers doesn't loop.
+const propagatedHeader = "X-Godns-Propagated"
+
+// peerTimeout bounds a request to the admin API of a peer.
+const peerTimeout = 5 * time.Second
+
+// propagatedPaths are the admin operations every peer repeats, those changing
+// what queries are answered with.
+var propagatedPaths = map[string]bool{
+	"/cache/flush":      true,
+	"/blocklist/reload": true,
+	"/blocking":         true,
+	"/rpz/reload":       true,
+	"/zones/reload":     true,
+}
+
+// Peers implements flag.Value, every use of the flag adds the admin API of
+// another instance as an http or https URL.
+type Peers []*url.URL
+
+func (ps *Peers) String() string {
+	peers := make([]string, 0, len(*ps))
+	for _, p := range *ps {
+		peers = append(peers, p.String())
+	}
+
+	return strings.Join(peers, ",")
+}
+
+func (ps *Peers) Set(value string) error {
+	u, err := url.Parse(value)
+	if err != nil {
+		return errors.Wrapf(err, "parsing peer %q", value)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.Errorf("peer %q is not an http or https URL", value)
+	}
+
+	*ps = append(*ps, u)
+	return nil
+}
+
+// statusRecorder remembers the status a handler responded with.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// propagating repeats successful admin operations on every peer, so that
+// cache flushes, blocklist updates and zone reloads reach the whole cluster.
+func (s *Server) propagating(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		if len(s.config.Peers) == 0 || r.Method != http.MethodPost || rec.status != http.StatusOK {
+			return
+		}
+		if !propagatedPaths[r.URL.Path] || r.Header.Get(propagatedHeader) != "" {
+			return
+		}
+
+		for _, peer := range s.config.Peers {
+			go s.propagate(peer, r.URL.Path, r.URL.RawQuery)
+		}
+	})
+}
+
+// propagate sends the admin operation to peer, which must share the admin
+// token.
+func (s *Server) propagate(peer *url.URL, path string, query string) {
+	target := *peer
+	target.Path = strings.TrimSuffix(target.Path, "/") + path
+	target.RawQuery = query
+
+	req, err := http.NewRequest(http.MethodPost, target.String(), nil)
+	if err != nil {
+		logger.Errorf("Error: propagating %s to %s: %s\n", path, peer, err)
+		return
+	}
+	req.Header.Set(propagatedHeader, "1")
+	if s.config.AdminToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.config.AdminToken)
+	}
+
+	client := &http.Client{Timeout: peerTimeout}
+	res, err := client.Do(req)
+	if err != nil {
+		logger.Errorf("Error: propagating %s to %s: %s\n", path, peer, err)
+		return
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		logger.Errorf("Error: propagating %s to %s: %s\n", path, peer, res.Status)
+		return
+	}
+
+	logger.Debugf("Propagated %s to %s\n", path, peer)
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"time"
+
+	"github.com/msarvar/godns/pkg/dnssec"
+	"github.com/msarvar/godns/pkg/docker"
+	"github.com/msarvar/godns/pkg/pcap"
+	"github.com/msarvar/godns/pkg/querylog"
+	"github.com/msarvar/godns/pkg/records"
+	"github.com/msarvar/godns/pkg/resolver"
+	"github.com/msarvar/godns/pkg/zone"
+)
+
+// Config holds the server settings.
+type Config struct {
+	// Listeners are the endpoints queries are accepted on
+	Listeners         Listeners
+	AddressPreference resolver.AddressPreference
+	// CaptureDir saves upstream messages for debugging, see resolver.Config
+	CaptureDir string
+	// Pcap records client and upstream exchanges when set
+	Pcap *pcap.Writer
+	// QueryLog stores the queries answered when set
+	QueryLog *querylog.Log
+	// DumpFile receives the state dump written on SIGUSR1, the dump is
+	// logged when empty
+	DumpFile string
+	// DNS64 enables AAAA synthesis for IPv6-only clients with this prefix
+	DNS64 *DNS64Prefix
+	// ECSForward passes the EDNS Client Subnet of clients on to upstreams,
+	// shortened to at most ECSPrefixV4 and ECSPrefixV6 bits
+	ECSForward  bool
+	ECSPrefixV4 uint8
+	ECSPrefixV6 uint8
+	// MaxStale enables answering from expired cache entries up to this long
+	// when upstreams fail, see cache.Config
+	MaxStale time.Duration
+	// Recursion enables resolving names and answering from the cache for
+	// clients that ask for it, without it only zones and policies answer
+	Recursion bool
+	// MaxUDPSize bounds the payload size of UDP responses to EDNS clients,
+	// larger answers are truncated. Clients without EDNS get 512 bytes
+	MaxUDPSize uint16
+	// MinimalResponses leaves out authority and additional records that
+	// aren't needed to use the answer
+	MinimalResponses bool
+	// MinTTL and MaxTTL bound the TTLs of upstream records, a zero MaxTTL
+	// leaves them uncapped
+	MinTTL time.Duration
+	MaxTTL time.Duration
+	// PrefetchHits enables refreshing cache entries requested at least this
+	// often shortly before they expire
+	PrefetchHits int
+	// CacheMaxEntries and CacheMaxBytes bound the cache of every view, the
+	// least recently used responses are evicted first. Zero leaves them
+	// unbounded
+	CacheMaxEntries int
+	CacheMaxBytes   int
+	// CacheFile keeps the cache across restarts, it is loaded on startup and
+	// saved every CacheSaveInterval and on shutdown
+	CacheFile         string
+	CacheSaveInterval time.Duration
+
+	// BlocklistFile lists domains answered with NXDOMAIN, one per line or in
+	// hosts file format. http and https URLs are downloaded again every
+	// BlocklistRefresh when they changed
+	BlocklistFile    string
+	BlocklistRefresh time.Duration
+	// AllowlistFile exempts names from the blocklist, exact names and
+	// wildcards like *.example.com one per line
+	AllowlistFile string
+	// HostsFile answers A, AAAA and PTR queries for the names and addresses
+	// in it before recursion, e.g. /etc/hosts. It is reloaded when it changes
+	HostsFile string
+	// LeaseFile is the lease file of dnsmasq or ISC dhcpd, the hostnames of
+	// DHCP clients are answered under LeaseDomain like the hosts file. It is
+	// reloaded when it changes
+	LeaseFile   string
+	LeaseDomain string
+	// Containers lists containers whose names are answered under
+	// ContainerSuffix, e.g. the Docker API or a static mapping. It is polled
+	// for changes
+	Containers      docker.Source
+	ContainerSuffix string
+	// Registry serves the A, AAAA, SRV and TXT records of services
+	// registered in Consul or etcd, updated as they change
+	Registry records.Source
+	// QueryRules refuse, drop, rewrite or answer NXDOMAIN to queries by
+	// client, name, type or an expression, the first matching rule applies
+	QueryRules []*QueryRule
+	// Rewrites resolve names as other names, the first matching rewrite
+	// applies
+	Rewrites NameRewrites
+	// SafeSearch answers queries for search engines with a CNAME to their
+	// safe search endpoints for every client, client groups may enable it
+	// for their clients only
+	SafeSearch bool
+	// RPZ lists the response policy zones, the first zone matching a query
+	// decides how it is answered
+	RPZ zone.Sources
+	// Zones are answered authoritatively instead of being resolved, those
+	// transferred from a primary are refreshed when it sends a NOTIFY
+	Zones zone.Sources
+	// TransferClients may transfer the zones of their view with AXFR over
+	// TCP, everyone else is refused
+	TransferClients Networks
+	// SigningKeys sign the zones they belong to with DNSSEC when they are
+	// loaded, the zones are signed again periodically
+	SigningKeys dnssec.Keys
+	// Catalogs are catalog zones (RFC9432) transferred from a primary, the
+	// zones they list are transferred from it too and answered like Zones.
+	// Members are added and removed as the catalog changes on reload
+	Catalogs zone.Sources
+	// Forwarders resolve names instead of iterating from the root servers
+	Forwarders resolver.Forwarders
+	// Strategies override how the names of their domains are resolved, e.g.
+	// recursively first and through a forwarder when that fails
+	Strategies resolver.Strategies
+	// Proxy relays queries to the forwarders without decoding and encoding
+	// them, options and records godns doesn't understand reach the
+	// forwarders. Only blocking, policies and zones still apply
+	Proxy bool
+	// QueryTimeout bounds the work done for a query over UDP and
+	// TCPQueryTimeout for one over TCP, whose clients wait longer. Set them
+	// to how long clients wait for an answer, zero leaves only the
+	// resolver's own limit
+	QueryTimeout    time.Duration
+	TCPQueryTimeout time.Duration
+	// UDPWorkers bounds the UDP queries answered at once on each listener,
+	// datagrams arriving while every worker is busy wait in the socket
+	// buffer. Zero answers one at a time
+	UDPWorkers int
+	// MaxTCPConnections bounds the TCP connections open at once, more are
+	// closed as soon as they are accepted. TCPIdleTimeout closes connections
+	// waiting this long for a complete query, clients learn it with the
+	// edns-tcp-keepalive option (RFC7828). MaxTCPQueries closes connections
+	// after that many queries. Zero disables a limit
+	MaxTCPConnections int
+	TCPIdleTimeout    time.Duration
+	MaxTCPQueries     int
+	// Privacy decides whether forwarders fall back to plain DNS when their
+	// encrypted transport fails or can't be verified
+	Privacy resolver.Privacy
+	// DiscoverDesignated upgrades forwarders reached over plain DNS to the
+	// DoT or DoH resolvers they designate (RFC9462) at startup
+	DiscoverDesignated bool
+	// UpstreamCheckInterval is how often the forwarders are probed to fail
+	// over from and back to them, zero disables probing
+	UpstreamCheckInterval time.Duration
+	// Views give groups of clients their own zones, forwarders and
+	// blocklist, the settings above apply to everyone else
+	Views []*View
+	// ClientGroups block more for some clients, by blocklist and time of
+	// day, e.g. parental controls on a home gateway
+	ClientGroups []*ClientGroup
+
+	// AdminAddress enables the admin API on a TCP address or on a unix socket
+	// given as "unix:/path". AdminToken must be sent as a bearer token, it is
+	// required on TCP
+	AdminAddress string
+	AdminToken   string
+	// Dashboard serves a web page graphing queries and toggling blocking at
+	// /dashboard of the admin API
+	Dashboard bool
+	// Peers are the admin APIs of the other instances of a cluster, cache
+	// flushes, blocklist updates and zone reloads are repeated on them. They
+	// must share AdminToken
+	Peers Peers
+	// ChaosVersion and ChaosHostname answer CHAOS class TXT queries for
+	// version.bind and hostname.bind, or version.server and id.server. Those
+	// queries are refused when they are empty, like every other class than
+	// IN
+	ChaosVersion  string
+	ChaosHostname string
+	// NSID identifies the instance to clients asking with the NSID option
+	// (RFC5001), e.g. which server of an anycast pool answered. Relayed
+	// responses carry the NSID of the upstream
+	NSID string
+	// UpstreamFaults degrade the UDP exchanges with upstreams on purpose, to
+	// test retries, validation and timeouts against a bad network
+	UpstreamFaults resolver.Faults
+
+	// Plugins are Go plugins loaded on startup to inspect and modify queries
+	// and responses, see Plugin
+	Plugins PluginFiles
+
+	// HealthAddress enables the /healthz and /readyz HTTP endpoints
+	HealthAddress string
+	// ReadinessSelfQuery makes /readyz send a query to the UDP listener
+	ReadinessSelfQuery bool
+}
+
+// DefaultListener is used when no listeners are configured.
+var DefaultListener = Listener{Address: ":2053", UDP: true}
+
+func DefaultConfig() *Config {
+	return &Config{
+		AddressPreference: resolver.PreferIPv4,
+		// RFC7871 11.1 recommends revealing no more than these
+		ECSPrefixV4:           24,
+		ECSPrefixV6:           56,
+		CacheSaveInterval:     5 * time.Minute,
+		BlocklistRefresh:      6 * time.Hour,
+		Recursion:             true,
+		UpstreamCheckInterval: 10 * time.Second,
+		// Stub resolvers commonly retry UDP after 5s, TCP clients wait longer
+		QueryTimeout:    5 * time.Second,
+		TCPQueryTimeout: 10 * time.Second,
+		// A slow recursion doesn't hold up the queries behind it
+		UDPWorkers: 256,
+		// Slow or idle clients can't hold every connection (RFC7766 6.2.3)
+		MaxTCPConnections: 1024,
+		TCPIdleTimeout:    10 * time.Second,
+		// Avoids IP fragmentation on common paths (DNS flag day 2020)
+		MaxUDPSize: 1232,
+		// Bounds memory use when clients ask for random names
+		CacheMaxBytes:   64 << 20,
+		LeaseDomain:     "lan",
+		ContainerSuffix: "docker",
+	}
+}
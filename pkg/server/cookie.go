@@ -0,0 +1,68 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+const (
+	serverCookieVersion = 1
+	// serverCookieLifetime is how long a server cookie handed to a client is
+	// accepted, RFC7873 recommends at most an hour.
+	serverCookieLifetime = time.Hour
+)
+
+// cookieJar mints and validates the server cookies handed to our clients.
+type cookieJar struct {
+	secret []byte
+}
+
+func newCookieJar() *cookieJar {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+
+	return &cookieJar{
+		secret: secret,
+	}
+}
+
+func (j *cookieJar) mac(parts ...[]byte) []byte {
+	h := hmac.New(sha256.New, j.secret)
+	for _, p := range parts {
+		h.Write(p)
+	}
+
+	return h.Sum(nil)
+}
+
+// newServerCookie mints a server cookie in the RFC9018 layout: version,
+// reserved bytes, timestamp and a hash binding the cookie to the client.
+func (j *cookieJar) newServerCookie(client []byte, addr net.IP, now time.Time) []byte {
+	cookie := make([]byte, 8, 16)
+	cookie[0] = serverCookieVersion
+	binary.BigEndian.PutUint32(cookie[4:], uint32(now.Unix()))
+
+	return append(cookie, j.mac(client, cookie, addr.To16())[:8]...)
+}
+
+func (j *cookieJar) validServerCookie(c *dns.Cookie, addr net.IP, now time.Time) bool {
+	if len(c.Server) != 16 || c.Server[0] != serverCookieVersion {
+		return false
+	}
+
+	issued := time.Unix(int64(binary.BigEndian.Uint32(c.Server[4:8])), 0)
+	if now.Sub(issued) > serverCookieLifetime || issued.Sub(now) > 5*time.Minute {
+		return false
+	}
+
+	expected := j.mac(c.Client, c.Server[:8], addr.To16())[:8]
+	return hmac.Equal(expected, c.Server[8:])
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	_ "embed"
+	"io"
+	"net/http"
+)
+
+// dashboardTop is how many names and clients the dashboard lists.
+const dashboardTop = 10
+
+// serveDashboard serves the dashboard page. It holds no data, the page asks
+// for the admin token and calls the admin API with it.
+func serveDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	io.WriteString(w, dashboardHTML)
+}
+
+// dashboardHTML graphs the query rate and lists the top names and clients
+// from /traffic, shows the counters of /stats and toggles /blocking.
+//
+//go:embed static/dashboard.html
+var dashboardHTML string
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"net"
+
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/pkg/errors"
+)
+
+// DefaultDNS64Prefix is the well-known NAT64 prefix of RFC6052.
+const DefaultDNS64Prefix = "64:ff9b::/96"
+
+// DNS64Prefix is the NAT64 prefix IPv4 addresses are embedded into when
+// synthesizing AAAA records (RFC6147).
+type DNS64Prefix struct {
+	net.IPNet
+}
+
+// ParseDNS64Prefix parses an IPv6 prefix of one of the lengths RFC6052
+// allows: 32, 40, 48, 56, 64 or 96 bits.
+func ParseDNS64Prefix(value string) (*DNS64Prefix, error) {
+	_, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing dns64 prefix %q", value)
+	}
+
+	ones, bits := network.Mask.Size()
+	if bits != 8*net.IPv6len || network.IP.To4() != nil {
+		return nil, errors.Errorf("dns64 prefix %q is not an IPv6 prefix", value)
+	}
+
+	switch ones {
+	case 32, 40, 48, 56, 64, 96:
+	default:
+		return nil, errors.Errorf("dns64 prefix %q has unsupported length %d", value, ones)
+	}
+
+	return &DNS64Prefix{IPNet: *network}, nil
+}
+
+// Embed returns the IPv6 address representing addr behind the NAT64 gateway.
+// Bits 64 to 71 are reserved and stay zero, which splits the IPv4 address for
+// prefixes shorter than 64 bits.
+func (p *DNS64Prefix) Embed(addr net.IP) net.IP {
+	v4 := addr.To4()
+	ones, _ := p.Mask.Size()
+
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, p.IP.To16())
+
+	pos := ones / 8
+	for _, b := range v4 {
+		if pos == 8 {
+			pos++
+		}
+		ip[pos] = b
+		pos++
+	}
+
+	return ip
+}
+
+// synthesizeDNS64 answers an AAAA query for a name without AAAA records with
+// its A records embedded into the NAT64 prefix. Any other response is
+// returned unchanged, including NXDOMAIN.
+func (s *Server) synthesizeDNS64(ctx context.Context, v *view, q *dns.DNSQuestion, result *dns.DNSPacket, ecs *dns.ClientSubnet) *dns.DNSPacket {
+	if q.QType != dns.AAAAQueryType || result.Header.ResCode != dns.NoError {
+		return result
+	}
+
+	for _, ans := range result.Answers {
+		if ans.QType == dns.AAAAQueryType {
+			return result
+		}
+	}
+
+	aResult, err := v.resolver.ResolveSubnet(ctx, q.Name.String(), dns.AQueryType, ecs)
+	if err != nil || aResult.Header.ResCode != dns.NoError {
+		return result
+	}
+
+	answers := make([]*dns.DNSRecord, 0, len(aResult.Answers))
+	synthesized := false
+	for _, ans := range aResult.Answers {
+		if ans.QType != dns.AQueryType {
+			// Keep the CNAME chain leading to the addresses
+			answers = append(answers, ans)
+			continue
+		}
+
+		aaaa := *ans
+		aaaa.QType = dns.AAAAQueryType
+		aaaa.Addr = s.config.DNS64.Embed(ans.Addr)
+		answers = append(answers, &aaaa)
+		synthesized = true
+	}
+
+	if !synthesized {
+		return result
+	}
+
+	synthetic := *aResult
+	synthetic.Answers = answers
+
+	return &synthetic
+}
@@ -0,0 +1,40 @@
+package server_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/msarvar/godns/pkg/server"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestDNS64Prefix(t *testing.T) {
+	t.Run("well_known_prefix", func(t *testing.T) {
+		p, err := server.ParseDNS64Prefix(server.DefaultDNS64Prefix)
+		NoError(t, err)
+		Equal(t, net.ParseIP("64:ff9b::c000:221"), p.Embed(net.ParseIP("192.0.2.33")))
+	})
+
+	t.Run("prefixes_skip_reserved_octet", func(t *testing.T) {
+		// Examples from RFC6052 2.4
+		for prefix, expected := range map[string]string{
+			"2001:db8::/32":         "2001:db8:c000:221::",
+			"2001:db8:100::/40":     "2001:db8:1c0:2:21::",
+			"2001:db8:122::/48":     "2001:db8:122:c000:2:2100::",
+			"2001:db8:122:300::/56": "2001:db8:122:3c0:0:221::",
+			"2001:db8:122:344::/64": "2001:db8:122:344:c0:2:2100:0",
+		} {
+			p, err := server.ParseDNS64Prefix(prefix)
+			NoError(t, err, prefix)
+			Equal(t, net.ParseIP(expected), p.Embed(net.ParseIP("192.0.2.33")), prefix)
+		}
+	})
+
+	t.Run("invalid_prefixes", func(t *testing.T) {
+		_, err := server.ParseDNS64Prefix("64:ff9b::/80")
+		Error(t, err)
+
+		_, err = server.ParseDNS64Prefix("192.0.2.0/24")
+		Error(t, err)
+	})
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/msarvar/godns/pkg/cache"
+	"github.com/msarvar/godns/pkg/logger"
+	"github.com/msarvar/godns/pkg/resolver"
+)
+
+// dumpHottest is how many of the most hit cache entries a dump lists.
+const dumpHottest = 10
+
+// dump writes what the server is doing at now for debugging stuck
+// resolutions: the contents of the caches, the resolutions in progress, the
+// round trip times of the upstreams and the goroutines grouped by stack.
+func (s *Server) dump(w io.Writer, now time.Time) {
+	fmt.Fprintf(w, "time %s\n", now.Format(time.RFC3339))
+	fmt.Fprintf(w, "uptime %s\n", now.Sub(s.stats.started).Truncate(time.Second))
+	fmt.Fprintf(w, "goroutines %d\n", runtime.NumGoroutine())
+
+	caches := make(map[*cache.Memory]bool)
+	resolvers := make(map[*resolver.Resolver]bool)
+	for _, v := range s.views {
+		if !caches[v.cache] {
+			caches[v.cache] = true
+			dumpCache(w, v.name, v.cache.Summarize(now, dumpHottest))
+		}
+
+		if resolvers[v.resolver] {
+			continue
+		}
+		resolvers[v.resolver] = true
+
+		for _, f := range v.resolver.InFlight() {
+			fmt.Fprintf(w, "in_flight %s %s running %s waiters %d\n", v.name, f.Query, now.Sub(f.Started).Truncate(time.Millisecond), f.Waiters)
+		}
+		for _, rtt := range v.resolver.RTTs() {
+			fmt.Fprintf(w, "rtt %s %s smoothed %s last %s samples %d\n", v.name, rtt.Addr,
+				rtt.Smoothed.Truncate(time.Microsecond), rtt.Last.Truncate(time.Microsecond), rtt.Samples)
+		}
+	}
+
+	fmt.Fprintln(w)
+	pprof.Lookup("goroutine").WriteTo(w, 1)
+}
+
+func dumpCache(w io.Writer, view string, summary cache.Summary) {
+	fmt.Fprintf(w, "cache %s entries %d bytes %d hits %d misses %d evictions %d expired %d\n", view,
+		summary.Entries, summary.Bytes, summary.Hits, summary.Misses, summary.Evictions, summary.Expired)
+
+	types := make([]string, 0, len(summary.Types))
+	for qtype, n := range summary.Types {
+		types = append(types, fmt.Sprintf("%s=%d", qtype, n))
+	}
+	sort.Strings(types)
+	if len(types) > 0 {
+		fmt.Fprintf(w, "cache_types %s %s\n", view, strings.Join(types, " "))
+	}
+
+	for _, e := range summary.Hottest {
+		fmt.Fprintf(w, "cache_hot %s %s %s hits %d\n", view, e.Name, e.Type, e.Hits)
+	}
+}
+
+// writeDump writes a dump to DumpFile, replacing the previous one, or to the
+// log when there is none.
+func (s *Server) writeDump() {
+	var dump bytes.Buffer
+	s.dump(&dump, time.Now())
+
+	if s.config.DumpFile == "" {
+		logger.Infof("State dump:\n%s", dump.String())
+		return
+	}
+
+	if err := ioutil.WriteFile(s.config.DumpFile, dump.Bytes(), 0644); err != nil {
+		logger.Errorf("Error: writing state dump: %s\n", err)
+		return
+	}
+	logger.Infof("Dumped state to %s\n", s.config.DumpFile)
+}
@@ -0,0 +1,28 @@
+//go:build !windows
+// +build !windows
+
+package server
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// dumpOnSignal writes a state dump whenever the process receives SIGUSR1
+// until ctx is cancelled.
+func (s *Server) dumpOnSignal(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-signals:
+			s.writeDump()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
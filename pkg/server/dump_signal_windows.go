@@ -0,0 +1,7 @@
+package server
+
+import "context"
+
+// dumpOnSignal does nothing, Windows has no SIGUSR1. Dumps are available
+// from the admin API.
+func (s *Server) dumpOnSignal(ctx context.Context) {}
@@ -0,0 +1,299 @@
+package server
+
+import (
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/pkg/errors"
+)
+
+// Expr is a condition on a query written in a small expression language,
+// for what the fixed conditions of query rules can't say, e.g.
+//
+//	qname endswith ".ads" and (group == "kids" or client in "10.9.0.0/16")
+//
+// Comparisons take a variable on the left and a quoted string on the right.
+// The variables are qname and qtype, the question, client, the address of
+// the client, group, the name of its client group, and view, the name of
+// its view. Strings compare with ==, !=, startswith, endswith, contains and
+// matches, a regular expression, ignoring case. client compares with == and
+// != to an address and with in to a network. Comparisons combine with and,
+// or, not and parentheses.
+type Expr struct {
+	source string
+	root   exprNode
+}
+
+// ExprEnv holds the values of the variables an Expr is evaluated with.
+type ExprEnv struct {
+	Name   string
+	Type   dns.QueryType
+	Client net.IP
+	Group  string
+	View   string
+}
+
+// ParseExpr parses an expression.
+func ParseExpr(source string) (*Expr, error) {
+	p := &exprParser{}
+	if err := p.tokenize(source); err != nil {
+		return nil, errors.Wrapf(err, "parsing expression %q", source)
+	}
+
+	root, err := p.or()
+	if err == nil && p.pos < len(p.tokens) {
+		err = errors.Errorf("unexpected %s", p.tokens[p.pos])
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing expression %q", source)
+	}
+
+	return &Expr{source: source, root: root}, nil
+}
+
+func (e *Expr) String() string {
+	return e.source
+}
+
+// Matches reports whether the expression holds for env.
+func (e *Expr) Matches(env *ExprEnv) bool {
+	return e.root.eval(env)
+}
+
+type exprNode interface {
+	eval(env *ExprEnv) bool
+}
+
+type andNode struct{ left, right exprNode }
+
+func (n *andNode) eval(env *ExprEnv) bool { return n.left.eval(env) && n.right.eval(env) }
+
+type orNode struct{ left, right exprNode }
+
+func (n *orNode) eval(env *ExprEnv) bool { return n.left.eval(env) || n.right.eval(env) }
+
+type notNode struct{ operand exprNode }
+
+func (n *notNode) eval(env *ExprEnv) bool { return !n.operand.eval(env) }
+
+// compareNode compares a variable to a value.
+type compareNode struct {
+	variable string
+	op       string
+	value    string
+	pattern  *regexp.Regexp
+	ip       net.IP
+	network  *net.IPNet
+}
+
+func (n *compareNode) eval(env *ExprEnv) bool {
+	if n.variable == "client" {
+		switch n.op {
+		case "in":
+			return env.Client != nil && n.network.Contains(env.Client)
+		case "==":
+			return n.ip.Equal(env.Client)
+		default:
+			return !n.ip.Equal(env.Client)
+		}
+	}
+
+	var actual string
+	switch n.variable {
+	case "qname":
+		actual = normalizeName(env.Name)
+	case "qtype":
+		actual = strings.ToLower(env.Type.String())
+	case "group":
+		actual = strings.ToLower(env.Group)
+	case "view":
+		actual = strings.ToLower(env.View)
+	}
+
+	switch n.op {
+	case "==":
+		return actual == n.value
+	case "!=":
+		return actual != n.value
+	case "startswith":
+		return strings.HasPrefix(actual, n.value)
+	case "endswith":
+		return strings.HasSuffix(actual, n.value)
+	case "contains":
+		return strings.Contains(actual, n.value)
+	default:
+		return n.pattern.MatchString(actual)
+	}
+}
+
+// normalizeName lowercases name and removes its trailing dot, names compare
+// the same however they are written.
+func normalizeName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
+
+var (
+	stringOps = map[string]bool{"==": true, "!=": true, "startswith": true, "endswith": true, "contains": true, "matches": true}
+	clientOps = map[string]bool{"==": true, "!=": true, "in": true}
+)
+
+// exprParser is a recursive descent parser over the tokens of an
+// expression, not binds tighter than and, and tighter than or.
+type exprParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *exprParser) tokenize(source string) error {
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')':
+			p.tokens = append(p.tokens, string(c))
+			i++
+		case c == '=' || c == '!':
+			if i+1 >= len(source) || source[i+1] != '=' {
+				return errors.Errorf("unexpected %q at %d", c, i)
+			}
+			p.tokens = append(p.tokens, source[i:i+2])
+			i += 2
+		case c == '"':
+			end := i + 1
+			for end < len(source) && source[end] != '"' {
+				if source[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(source) {
+				return errors.Errorf("unterminated string at %d", i)
+			}
+			p.tokens = append(p.tokens, source[i:end+1])
+			i = end + 1
+		case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_':
+			end := i
+			for end < len(source) && (source[end] >= 'a' && source[end] <= 'z' || source[end] >= 'A' && source[end] <= 'Z' || source[end] == '_') {
+				end++
+			}
+			p.tokens = append(p.tokens, strings.ToLower(source[i:end]))
+			i = end
+		default:
+			return errors.Errorf("unexpected %q at %d", c, i)
+		}
+	}
+
+	return nil
+}
+
+// next returns the next token, "" at the end.
+func (p *exprParser) next() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	p.pos++
+	return p.tokens[p.pos-1]
+}
+
+func (p *exprParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) or() (exprNode, error) {
+	left, err := p.and()
+	for err == nil && p.peek() == "or" {
+		p.next()
+		var right exprNode
+		right, err = p.and()
+		left = &orNode{left, right}
+	}
+
+	return left, err
+}
+
+func (p *exprParser) and() (exprNode, error) {
+	left, err := p.unary()
+	for err == nil && p.peek() == "and" {
+		p.next()
+		var right exprNode
+		right, err = p.unary()
+		left = &andNode{left, right}
+	}
+
+	return left, err
+}
+
+func (p *exprParser) unary() (exprNode, error) {
+	switch p.peek() {
+	case "not":
+		p.next()
+		operand, err := p.unary()
+		return &notNode{operand}, err
+	case "(":
+		p.next()
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, errors.New("missing )")
+		}
+		return inner, nil
+	default:
+		return p.comparison()
+	}
+}
+
+func (p *exprParser) comparison() (exprNode, error) {
+	variable, op, quoted := p.next(), p.next(), p.next()
+	if variable == "" {
+		return nil, errors.New("unexpected end")
+	}
+
+	ops := stringOps
+	switch variable {
+	case "client":
+		ops = clientOps
+	case "qname", "qtype", "group", "view":
+	default:
+		return nil, errors.Errorf("unknown variable %s", variable)
+	}
+	if !ops[op] {
+		return nil, errors.Errorf("%s can't be compared with %q", variable, op)
+	}
+
+	if !strings.HasPrefix(quoted, `"`) {
+		return nil, errors.Errorf("%s %s must be followed by a quoted string", variable, op)
+	}
+	value, err := strconv.Unquote(quoted)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing string %s", quoted)
+	}
+
+	n := &compareNode{variable: variable, op: op, value: strings.ToLower(value)}
+	switch {
+	case variable == "qname" && op != "matches":
+		n.value = normalizeName(value)
+	case op == "matches":
+		if n.pattern, err = regexp.Compile("(?i)" + value); err != nil {
+			return nil, errors.Wrapf(err, "parsing pattern %s", quoted)
+		}
+	case op == "in":
+		if _, n.network, err = net.ParseCIDR(value); err != nil {
+			return nil, errors.Wrapf(err, "parsing network %s", quoted)
+		}
+	case variable == "client":
+		if n.ip = net.ParseIP(value); n.ip == nil {
+			return nil, errors.Errorf("%s is not an IP address", quoted)
+		}
+	}
+
+	return n, nil
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+func TestExpr(t *testing.T) {
+	env := &ExprEnv{Name: "Tracker.Example.ADS.", Type: dns.AQueryType, Client: net.ParseIP("10.9.1.2"), Group: "kids", View: "default"}
+
+	for source, want := range map[string]bool{
+		`qname endswith ".ads"`:                          true,
+		`qname == "tracker.example.ads"`:                 true,
+		`qname != "tracker.example.ads"`:                 false,
+		`qname startswith "tracker."`:                    true,
+		`qname contains "example"`:                       true,
+		`qname matches "^tr[a-z]+\\."`:                   true,
+		`qtype == "a"`:                                   true,
+		`qtype == "AAAA"`:                                false,
+		`client in "10.9.0.0/16"`:                        true,
+		`client in "192.168.0.0/16"`:                     false,
+		`client == "10.9.1.2"`:                           true,
+		`client != "10.9.1.2"`:                           false,
+		`group == "kids" and view == "default"`:          true,
+		`group == "adults" or not view == "guests"`:      true,
+		`not (group == "kids" or qtype == "MX")`:         false,
+		`qname endswith ".com" or qname endswith ".ads"`: true,
+		// and binds tighter than or
+		`group == "kids" or qtype == "MX" and qtype == "TXT"`:   true,
+		`(group == "kids" or qtype == "MX") and qtype == "TXT"`: false,
+	} {
+		e, err := ParseExpr(source)
+		if NoError(t, err, source) {
+			Equal(t, want, e.Matches(env), source)
+			Equal(t, source, e.String())
+		}
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		for _, source := range []string{
+			``,
+			`qname`,
+			`qname endswith`,
+			`qname endswith .ads`,
+			`qname in "10.0.0.0/8"`,
+			`client endswith ".1"`,
+			`client in "10.0.0.0"`,
+			`client == "host"`,
+			`qname matches "("`,
+			`sender == "x"`,
+			`qname == "x" and`,
+			`(qname == "x"`,
+			`qname == "x")`,
+			`qname = "x"`,
+			`qname == "x`,
+		} {
+			_, err := ParseExpr(source)
+			Error(t, err, source)
+		}
+	})
+}
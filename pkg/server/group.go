@@ -0,0 +1,351 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/msarvar/godns/pkg/blocklist"
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/pkg/errors"
+)
+
+// arpTable lists the MAC addresses of the IPv4 neighbors on Linux.
+const arpTable = "/proc/net/arp"
+
+// neighborsMaxAge is how long the neighbor table is used before it is read
+// again.
+const neighborsMaxAge = 30 * time.Second
+
+// ClientGroup gives clients, picked by network or by the MAC address of
+// their IPv4 address, a blocklist and times of day their queries are
+// blocked, e.g. the devices of children on a home network. Groups block on
+// top of the view of the client, the first group of a client applies.
+type ClientGroup struct {
+	Name    string
+	Clients []*net.IPNet
+	MACs    []net.HardwareAddr
+	// BlocklistFile is a file or an http or https URL, see Config
+	BlocklistFile string
+	AllowlistFile string
+	Schedules     []*Schedule
+	// SafeSearch sends the clients to the safe search of search engines
+	SafeSearch bool
+}
+
+// Schedule blocks Domains, and the names below them, or every name when it
+// has none between From and To on Days. Windows ending before they start
+// end on the next day, e.g. 21:00 to 07:00.
+type Schedule struct {
+	// Days are indexed by time.Weekday
+	Days [7]bool
+	// From and To are the time since midnight
+	From    time.Duration
+	To      time.Duration
+	Domains []*buffer.DomainName
+}
+
+// Active reports whether t, in local time, falls in the schedule.
+func (sc *Schedule) Active(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	since := t.Sub(midnight)
+
+	if sc.From <= sc.To {
+		return sc.Days[t.Weekday()] && since >= sc.From && since < sc.To
+	}
+
+	yesterday := (t.Weekday() + 6) % 7
+	return sc.Days[t.Weekday()] && since >= sc.From || sc.Days[yesterday] && since < sc.To
+}
+
+// Blocks reports whether the schedule blocks name at t.
+func (sc *Schedule) Blocks(name string, t time.Time) bool {
+	if !sc.Active(t) {
+		return false
+	}
+	if len(sc.Domains) == 0 {
+		return true
+	}
+
+	domain := buffer.NewDomainName(name)
+	for _, d := range sc.Domains {
+		if domain.IsSubdomainOf(d) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// groupFile is the JSON form of a client group:
+//
+//	{"name": "kids", "clients": ["192.168.1.64/28"],
+//	 "macs": ["aa:bb:cc:dd:ee:ff"], "blocklist": "/etc/godns/kids.txt",
+//	 "safe_search": true,
+//	 "schedules": [{"days": ["sun", "mon", "tue", "wed", "thu"],
+//	                "from": "21:00", "to": "07:00"},
+//	               {"days": ["mon"], "from": "08:00", "to": "15:00",
+//	                "domains": ["youtube.com"]}]}
+type groupFile struct {
+	Name      string         `json:"name"`
+	Clients   []string       `json:"clients"`
+	MACs      []string       `json:"macs"`
+	Blocklist string         `json:"blocklist"`
+	Allowlist string         `json:"allowlist"`
+	Schedules []scheduleFile `json:"schedules"`
+	// SafeSearch enforces safe search for the group
+	SafeSearch bool `json:"safe_search"`
+}
+
+type scheduleFile struct {
+	Days    []string `json:"days"`
+	From    string   `json:"from"`
+	To      string   `json:"to"`
+	Domains []string `json:"domains"`
+}
+
+// LoadClientGroups reads a JSON list of client groups.
+func LoadClientGroups(path string) ([]*ClientGroup, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading client groups")
+	}
+
+	var files []groupFile
+	if err := json.Unmarshal(data, &files); err != nil {
+		return nil, errors.Wrap(err, "parsing client groups")
+	}
+
+	groups := make([]*ClientGroup, 0, len(files))
+	for _, f := range files {
+		g := &ClientGroup{Name: f.Name, BlocklistFile: f.Blocklist, AllowlistFile: f.Allowlist, SafeSearch: f.SafeSearch}
+		if len(f.Clients) == 0 && len(f.MACs) == 0 {
+			return nil, errors.Errorf("client group %q has no clients", f.Name)
+		}
+
+		for _, c := range f.Clients {
+			_, network, err := net.ParseCIDR(c)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parsing clients of client group %q", f.Name)
+			}
+			g.Clients = append(g.Clients, network)
+		}
+
+		for _, m := range f.MACs {
+			mac, err := net.ParseMAC(m)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parsing MAC addresses of client group %q", f.Name)
+			}
+			g.MACs = append(g.MACs, mac)
+		}
+
+		for _, sf := range f.Schedules {
+			sc, err := parseSchedule(sf)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parsing schedules of client group %q", f.Name)
+			}
+			g.Schedules = append(g.Schedules, sc)
+		}
+
+		groups = append(groups, g)
+	}
+
+	return groups, nil
+}
+
+// parseSchedule parses days by their three letter English names and times as
+// HH:MM, a schedule without days applies every day.
+func parseSchedule(f scheduleFile) (*Schedule, error) {
+	sc := &Schedule{}
+
+	for _, day := range f.Days {
+		found := false
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			if strings.EqualFold(day, d.String()[:3]) {
+				sc.Days[d], found = true, true
+			}
+		}
+		if !found {
+			return nil, errors.Errorf("unknown day %q", day)
+		}
+	}
+	if len(f.Days) == 0 {
+		for d := range sc.Days {
+			sc.Days[d] = true
+		}
+	}
+
+	var err error
+	if sc.From, err = parseTimeOfDay(f.From); err != nil {
+		return nil, err
+	}
+	if sc.To, err = parseTimeOfDay(f.To); err != nil {
+		return nil, err
+	}
+	if sc.From == sc.To {
+		return nil, errors.Errorf("schedule from %s to %s is empty", f.From, f.To)
+	}
+
+	for _, d := range f.Domains {
+		sc.Domains = append(sc.Domains, buffer.NewDomainName(d))
+	}
+
+	return sc, nil
+}
+
+// parseTimeOfDay parses HH:MM, 24:00 is the end of the day.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	if s == "24:00" {
+		return 24 * time.Hour, nil
+	}
+
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, errors.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// group holds what a client group blocks with while the server runs.
+type group struct {
+	name    string
+	clients []*net.IPNet
+	macs    map[string]bool
+	// blocklist and allowlist are nil when the group has none
+	blocklist  *blocklist.Blocklist
+	allowlist  *blocklist.Allowlist
+	schedules  []*Schedule
+	safeSearch bool
+}
+
+func newGroup(g *ClientGroup) *group {
+	rg := &group{
+		name:       g.Name,
+		clients:    g.Clients,
+		macs:       make(map[string]bool),
+		schedules:  g.Schedules,
+		safeSearch: g.SafeSearch,
+	}
+
+	for _, mac := range g.MACs {
+		rg.macs[mac.String()] = true
+	}
+
+	if g.BlocklistFile != "" {
+		rg.blocklist = blocklist.New(g.BlocklistFile)
+	}
+
+	if g.AllowlistFile != "" {
+		rg.allowlist = blocklist.NewAllowlist(g.AllowlistFile)
+	}
+
+	return rg
+}
+
+// blocks reports whether a schedule active at now or the blocklist of the
+// group blocks name. Schedules aren't lifted by the allowlist.
+func (g *group) blocks(name string, now time.Time) bool {
+	for _, sc := range g.schedules {
+		if sc.Blocks(name, now) {
+			return true
+		}
+	}
+
+	if g.blocklist == nil || !g.blocklist.Blocked(name) {
+		return false
+	}
+
+	return g.allowlist == nil || !g.allowlist.Allowed(name)
+}
+
+// groupFor returns the first group the client belongs to or nil.
+func (s *Server) groupFor(ip net.IP) *group {
+	var mac string
+	for _, g := range s.groups {
+		for _, network := range g.clients {
+			if network.Contains(ip) {
+				return g
+			}
+		}
+
+		if len(g.macs) > 0 {
+			if mac == "" {
+				mac = s.neighbors.mac(ip, time.Now())
+			}
+			if g.macs[mac] {
+				return g
+			}
+		}
+	}
+
+	return nil
+}
+
+// neighbors maps the IPv4 addresses of clients on the local network to their
+// MAC addresses from the kernel's neighbor table.
+type neighbors struct {
+	path string
+
+	mu   sync.Mutex
+	macs map[string]string
+	read time.Time
+}
+
+// mac returns the MAC address of ip, empty when it isn't a neighbor. The
+// table is read again once it is older than neighborsMaxAge or misses ip.
+func (n *neighbors) mac(ip net.IP, now time.Time) string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	mac, ok := n.macs[ip.String()]
+	if ok && now.Sub(n.read) < neighborsMaxAge {
+		return mac
+	}
+	// New clients appear in the table right after they talk to us, but
+	// reading it for every unknown client would be too much
+	if !ok && now.Sub(n.read) < time.Second {
+		return ""
+	}
+
+	macs, err := readNeighbors(n.path)
+	if err != nil {
+		n.read = now
+		return mac
+	}
+	n.macs, n.read = macs, now
+
+	return macs[ip.String()]
+}
+
+// readNeighbors reads the ARP table format of /proc/net/arp.
+func readNeighbors(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	macs := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+
+		ip := net.ParseIP(fields[0])
+		mac, err := net.ParseMAC(fields[3])
+		if ip == nil || err != nil || mac.String() == "00:00:00:00:00:00" {
+			continue
+		}
+		macs[ip.String()] = mac.String()
+	}
+
+	return macs, scanner.Err()
+}
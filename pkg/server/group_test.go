@@ -0,0 +1,101 @@
+package server
+
+import (
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestClientGroups(t *testing.T) {
+	dir := t.TempDir()
+	blocked := filepath.Join(dir, "kids.txt")
+	NoError(t, ioutil.WriteFile(blocked, []byte("games.example.com\n"), 0644))
+
+	groups := filepath.Join(dir, "groups.json")
+	NoError(t, ioutil.WriteFile(groups, []byte(`[
+		{"name": "kids", "clients": ["192.168.1.64/28"], "macs": ["AA:BB:CC:DD:EE:FF"],
+		 "blocklist": "`+blocked+`",
+		 "schedules": [{"days": ["sun", "mon"], "from": "21:00", "to": "07:00"},
+		               {"days": ["tue"], "from": "08:00", "to": "15:00", "domains": ["video.example.com"]}]}
+	]`), 0644))
+
+	// 2021-03-01 is a Monday
+	at := func(day int, hour int, minute int) time.Time {
+		return time.Date(2021, 3, day, hour, minute, 0, 0, time.Local)
+	}
+
+	t.Run("load_client_groups", func(t *testing.T) {
+		loaded, err := LoadClientGroups(groups)
+		NoError(t, err)
+		if Len(t, loaded, 1) {
+			Equal(t, "kids", loaded[0].Name)
+			Equal(t, "192.168.1.64/28", loaded[0].Clients[0].String())
+			Equal(t, "aa:bb:cc:dd:ee:ff", loaded[0].MACs[0].String())
+			Len(t, loaded[0].Schedules, 2)
+		}
+
+		for _, invalid := range []string{
+			`[{"name": "none"}]`,
+			`[{"name": "kids", "clients": ["192.168.1.64/28"], "schedules": [{"days": ["someday"], "from": "21:00", "to": "07:00"}]}]`,
+			`[{"name": "kids", "clients": ["192.168.1.64/28"], "schedules": [{"from": "9pm", "to": "07:00"}]}]`,
+			`[{"name": "kids", "clients": ["192.168.1.64/28"], "schedules": [{"from": "07:00", "to": "07:00"}]}]`,
+			`[{"name": "kids", "macs": ["not-a-mac"]}]`,
+		} {
+			path := filepath.Join(dir, "invalid.json")
+			NoError(t, ioutil.WriteFile(path, []byte(invalid), 0644))
+			_, err := LoadClientGroups(path)
+			Error(t, err, invalid)
+		}
+	})
+
+	t.Run("schedules_span_midnight", func(t *testing.T) {
+		sc, err := parseSchedule(scheduleFile{Days: []string{"sun", "mon"}, From: "21:00", To: "07:00"})
+		NoError(t, err)
+
+		True(t, sc.Active(at(1, 22, 0)))
+		True(t, sc.Active(at(1, 3, 0)), "sunday night")
+		True(t, sc.Active(at(2, 6, 59)), "monday night")
+		False(t, sc.Active(at(2, 7, 0)))
+		False(t, sc.Active(at(2, 22, 0)), "tuesday")
+		False(t, sc.Active(at(1, 20, 59)))
+	})
+
+	t.Run("groups_block_their_clients", func(t *testing.T) {
+		loaded, err := LoadClientGroups(groups)
+		NoError(t, err)
+
+		arp := filepath.Join(dir, "arp")
+		NoError(t, ioutil.WriteFile(arp, []byte(
+			"IP address       HW type     Flags       HW address            Mask     Device\n"+
+				"192.168.1.20     0x1         0x2         aa:bb:cc:dd:ee:ff     *        eth0\n"+
+				"192.168.1.30     0x1         0x0         00:00:00:00:00:00     *        eth0\n"), 0644))
+
+		cfg := DefaultConfig()
+		cfg.ClientGroups = loaded
+		s := NewServer(cfg)
+		s.neighbors.path = arp
+		_, err = s.loadBlocklists()
+		NoError(t, err)
+
+		kids := s.groupFor(net.ParseIP("192.168.1.70"))
+		if NotNil(t, kids) {
+			Equal(t, "kids", kids.name)
+			True(t, kids.blocks("games.example.com", at(2, 12, 0)))
+			False(t, kids.blocks("www.example.com", at(2, 12, 0)))
+			True(t, kids.blocks("www.example.com", at(1, 23, 0)), "bedtime")
+			True(t, kids.blocks("www.video.example.com", at(2, 9, 0)), "school")
+			False(t, kids.blocks("www.example.com", at(2, 9, 0)))
+		}
+
+		Same(t, kids, s.groupFor(net.ParseIP("192.168.1.20")), "by MAC address")
+		Nil(t, s.groupFor(net.ParseIP("192.168.1.30")), "incomplete entry")
+		Nil(t, s.groupFor(net.ParseIP("192.168.1.1")))
+
+		True(t, s.blocked(s.defaultView(), net.ParseIP("192.168.1.70"), "games.example.com"))
+		False(t, s.blocked(s.defaultView(), net.ParseIP("192.168.1.1"), "games.example.com"))
+	})
+}
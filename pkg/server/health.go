@@ -0,0 +1,121 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+// selfQueryTimeout bounds the readiness self query against the UDP listener.
+const selfQueryTimeout = 2 * time.Second
+
+// healthHandler serves the probes used by load balancers and Kubernetes.
+// /healthz reports that the process is alive, /readyz that the listeners are
+// bound and, when enabled, that the UDP listener answers queries.
+func (s *Server) healthHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&s.ready) == 0 {
+			http.Error(w, "listeners not ready", http.StatusServiceUnavailable)
+			return
+		}
+
+		if s.config.ReadinessSelfQuery {
+			if err := s.selfQuery(); err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		fmt.Fprintln(w, "ok")
+	})
+
+	return mux
+}
+
+// selfQuery sends a query without questions to the first UDP listener. The
+// server answers it with FORMERR without recursing, which is enough to prove
+// the read loop is alive.
+func (s *Server) selfQuery() error {
+	var target string
+	for _, l := range s.config.Listeners {
+		if l.UDP {
+			target = l.Address
+			break
+		}
+	}
+
+	if target == "" {
+		return nil
+	}
+
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return errors.Wrap(err, "parsing udp listener address")
+	}
+
+	// Wildcard listeners are reachable through loopback
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+		if ip != nil && ip.To4() == nil {
+			host = "::1"
+		}
+	}
+
+	conn, err := net.Dial("udp", net.JoinHostPort(host, port))
+	if err != nil {
+		return errors.Wrap(err, "dialing udp listener")
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(selfQueryTimeout))
+
+	id, err := utils.RandomUint16()
+	if err != nil {
+		return errors.Wrap(err, "generating query id")
+	}
+
+	query := dns.NewDNSPacket()
+	query.Header.ID = id
+
+	reqBuffer := buffer.NewBytePacketBuffer()
+	if err := query.Write(reqBuffer); err != nil {
+		return errors.Wrap(err, "preparing self query")
+	}
+
+	req, err := reqBuffer.GetRangeAtPos()
+	if err != nil {
+		return errors.Wrap(err, "retrieving buffer")
+	}
+
+	if _, err := conn.Write(req); err != nil {
+		return errors.Wrap(err, "sending self query")
+	}
+
+	resBuffer := buffer.NewBytePacketBuffer()
+	if _, err := conn.Read(resBuffer.Buf); err != nil {
+		return errors.Wrap(err, "reading self query response")
+	}
+
+	response, err := dns.DNSPacketFromBuffer(resBuffer)
+	if err != nil {
+		return errors.Wrap(err, "parsing self query response")
+	}
+
+	if response.Header.ID != id {
+		return errors.New("self query response id mismatch")
+	}
+
+	return nil
+}
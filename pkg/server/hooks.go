@@ -0,0 +1,190 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+// QueryEvent describes a query received from a client.
+type QueryEvent struct {
+	Time   time.Time
+	Client net.Addr
+	// View is the name of the view answering the client
+	View string
+	ID   uint16
+	Name string
+	Type dns.QueryType
+}
+
+// ResponseEvent describes the response sent for a query. Dropped queries get
+// none.
+type ResponseEvent struct {
+	Query   *QueryEvent
+	ResCode dns.ResultCode
+	Answers int
+	Size    int
+	Elapsed time.Duration
+}
+
+// CacheHitEvent describes a query answered from the cache.
+type CacheHitEvent struct {
+	Query *QueryEvent
+}
+
+// BlockEvent describes a query that was blocked instead of being resolved.
+type BlockEvent struct {
+	Query *QueryEvent
+	// Reason is "blocklist", "rule" or "policy"
+	Reason string
+	// Detail is the action of the rule or the zone and trigger of the policy
+	Detail string
+}
+
+// hooks holds the callbacks registered by embedders, they are called
+// outside the lock so that hooks may register others.
+type hooks struct {
+	mu        sync.RWMutex
+	queries   []func(*QueryEvent)
+	responses []func(*ResponseEvent)
+	cacheHits []func(*CacheHitEvent)
+	blocks    []func(*BlockEvent)
+}
+
+// OnQuery registers fn to be called with every query before it is answered.
+// Hooks run on the goroutine answering the query and must return quickly,
+// they may be registered while the server runs. Events must not be modified.
+func (s *Server) OnQuery(fn func(*QueryEvent)) {
+	s.hooks.mu.Lock()
+	defer s.hooks.mu.Unlock()
+	s.hooks.queries = append(s.hooks.queries, fn)
+}
+
+// OnResponse registers fn to be called with every response once it is built,
+// like OnQuery.
+func (s *Server) OnResponse(fn func(*ResponseEvent)) {
+	s.hooks.mu.Lock()
+	defer s.hooks.mu.Unlock()
+	s.hooks.responses = append(s.hooks.responses, fn)
+}
+
+// OnCacheHit registers fn to be called with every query answered from the
+// cache, like OnQuery.
+func (s *Server) OnCacheHit(fn func(*CacheHitEvent)) {
+	s.hooks.mu.Lock()
+	defer s.hooks.mu.Unlock()
+	s.hooks.cacheHits = append(s.hooks.cacheHits, fn)
+}
+
+// OnBlock registers fn to be called with every query blocked by a
+// blocklist, a query rule or a response policy, like OnQuery.
+func (s *Server) OnBlock(fn func(*BlockEvent)) {
+	s.hooks.mu.Lock()
+	defer s.hooks.mu.Unlock()
+	s.hooks.blocks = append(s.hooks.blocks, fn)
+}
+
+func (h *hooks) registered() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return len(h.queries)+len(h.responses)+len(h.cacheHits)+len(h.blocks) > 0
+}
+
+type queryEventKey struct{}
+
+// queryEvent returns the event of the query answered under ctx, nil when no
+// hook is registered.
+func queryEvent(ctx context.Context) *QueryEvent {
+	e, _ := ctx.Value(queryEventKey{}).(*QueryEvent)
+	return e
+}
+
+// startQuery reports the query in msg to the hooks and returns the context
+// carrying its event to the later ones, ctx as is without hooks.
+func (s *Server) startQuery(ctx context.Context, msg []byte, addr net.Addr) context.Context {
+	if !s.hooks.registered() {
+		return ctx
+	}
+
+	request, err := dns.ParseLazy(msg)
+	if err != nil {
+		return ctx
+	}
+
+	e := &QueryEvent{Time: time.Now(), Client: addr, View: s.viewFor(addrIP(addr)).name, ID: request.Header.ID}
+	if len(request.Questions) > 0 {
+		e.Name = request.Questions[0].Name.String()
+		e.Type = request.Questions[0].QType
+	}
+
+	s.hooks.mu.RLock()
+	fns := s.hooks.queries
+	s.hooks.mu.RUnlock()
+	for _, fn := range fns {
+		fn(e)
+	}
+
+	return context.WithValue(ctx, queryEventKey{}, e)
+}
+
+// finishQuery reports the response in data to the hooks.
+func (s *Server) finishQuery(ctx context.Context, data []byte) {
+	q := queryEvent(ctx)
+	if q == nil || data == nil {
+		return
+	}
+
+	response, err := dns.ParseLazy(data)
+	if err != nil {
+		return
+	}
+
+	e := &ResponseEvent{
+		Query:   q,
+		ResCode: response.Header.ResCode,
+		Answers: int(response.Header.Answers),
+		Size:    len(data),
+		Elapsed: time.Since(q.Time),
+	}
+
+	s.hooks.mu.RLock()
+	fns := s.hooks.responses
+	s.hooks.mu.RUnlock()
+	for _, fn := range fns {
+		fn(e)
+	}
+}
+
+// cacheHit reports that the query of ctx was answered from the cache.
+func (s *Server) cacheHit(ctx context.Context) {
+	q := queryEvent(ctx)
+	if q == nil {
+		return
+	}
+
+	s.hooks.mu.RLock()
+	fns := s.hooks.cacheHits
+	s.hooks.mu.RUnlock()
+	for _, fn := range fns {
+		fn(&CacheHitEvent{Query: q})
+	}
+}
+
+// block reports that the query of ctx was blocked.
+func (s *Server) block(ctx context.Context, reason, detail string) {
+	q := queryEvent(ctx)
+	if q == nil {
+		return
+	}
+
+	s.hooks.mu.RLock()
+	fns := s.hooks.blocks
+	s.hooks.mu.RUnlock()
+	for _, fn := range fns {
+		fn(&BlockEvent{Query: q, Reason: reason, Detail: detail})
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/dnstest"
+)
+
+func TestHooks(t *testing.T) {
+	ns := dnstest.NewServer(t, map[string]string{"example.com": `
+@	IN SOA	ns.example.com. hostmaster.example.com. 1 7200 3600 1209600 300
+@	IN NS	ns.example.com.
+www	IN A	192.0.2.1
+`})
+
+	rules := filepath.Join(t.TempDir(), "rules.json")
+	NoError(t, ioutil.WriteFile(rules, []byte(`[{"types": ["ANY"], "action": "refuse"}]`), 0644))
+	loaded, err := LoadQueryRules(rules)
+	if !NoError(t, err) {
+		return
+	}
+
+	cfg := DefaultConfig()
+	cfg.QueryRules = loaded
+	NoError(t, cfg.Forwarders.Set(ns.Addr.String()))
+	s := NewServer(cfg)
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353}
+
+	var mu sync.Mutex
+	var events []string
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}
+	s.OnQuery(func(e *QueryEvent) { record("query " + e.Name + " " + e.Type.String()) })
+	s.OnResponse(func(e *ResponseEvent) { record("response " + e.Query.Name + " " + e.ResCode.String()) })
+	s.OnCacheHit(func(e *CacheHitEvent) { record("cache " + e.Query.Name) })
+	s.OnBlock(func(e *BlockEvent) { record("block " + e.Query.Name + " " + e.Reason + " " + e.Detail) })
+
+	answer := func(name string, qtype dns.QueryType) []string {
+		request := dns.NewDNSPacket()
+		request.Header.ID = 4660
+		request.Header.RecursionDesired = true
+		request.Questions = append(request.Questions, dns.NewDNSQuestion(name, qtype))
+		reqBuffer := buffer.NewBytePacketBuffer()
+		NoError(t, request.Write(reqBuffer))
+		msg := append([]byte(nil), reqBuffer.Buf[:reqBuffer.Pos()]...)
+		reqBuffer.Seek(0)
+
+		mu.Lock()
+		events = nil
+		mu.Unlock()
+		NotNil(t, s.answer(context.Background(), reqBuffer, buffer.NewBytePacketBuffer(), msg, addr))
+
+		mu.Lock()
+		defer mu.Unlock()
+		return events
+	}
+
+	t.Run("queries_and_responses", func(t *testing.T) {
+		Equal(t, []string{
+			"query www.example.com A",
+			"response www.example.com NOERROR",
+		}, answer("www.example.com", dns.AQueryType))
+	})
+
+	t.Run("cache_hits", func(t *testing.T) {
+		Equal(t, []string{
+			"query www.example.com A",
+			"cache www.example.com",
+			"response www.example.com NOERROR",
+		}, answer("www.example.com", dns.AQueryType))
+	})
+
+	t.Run("blocked_queries", func(t *testing.T) {
+		Equal(t, []string{
+			"query www.example.com ANY",
+			"block www.example.com rule refuse",
+			"response www.example.com REFUSED",
+		}, answer("www.example.com", dns.ANYQueryType))
+	})
+
+	t.Run("hooks_may_register_hooks", func(t *testing.T) {
+		s := NewServer(DefaultConfig())
+		s.OnQuery(func(*QueryEvent) { s.OnQuery(func(*QueryEvent) {}) })
+		request := dns.NewDNSPacket()
+		request.Questions = append(request.Questions, dns.NewDNSQuestion("www.example.com", dns.AQueryType))
+		reqBuffer := buffer.NewBytePacketBuffer()
+		NoError(t, request.Write(reqBuffer))
+		NotNil(t, queryEvent(s.startQuery(context.Background(), reqBuffer.Buf[:reqBuffer.Pos()], addr)))
+	})
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"time"
+
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+// hostsCheckInterval is how often the hosts and lease files are checked for
+// changes and containers are listed.
+const hostsCheckInterval = 5 * time.Second
+
+// lookupHosts answers A, AAAA and PTR questions from the hosts file, the DHCP
+// leases and the containers and then A, AAAA, SRV and TXT questions from the
+// service registry, false means none has the name and the query is resolved.
+func (s *Server) lookupHosts(request *dns.DNSPacket) ([]*dns.DNSRecord, bool) {
+	if len(request.Questions) != 1 {
+		return nil, false
+	}
+
+	q := request.Questions[0]
+	switch q.QType {
+	case dns.AQueryType, dns.AAAAQueryType, dns.PTRQueryType:
+		if s.hosts != nil {
+			if records, ok := s.hosts.Lookup(q.Name.String(), q.QType); ok {
+				return records, true
+			}
+		}
+
+		if s.leases != nil {
+			if records, ok := s.leases.Lookup(q.Name.String(), q.QType, time.Now()); ok {
+				return records, true
+			}
+		}
+
+		if s.containers != nil {
+			if records, ok := s.containers.Lookup(q.Name.String(), q.QType); ok {
+				return records, true
+			}
+		}
+	}
+
+	switch q.QType {
+	case dns.AQueryType, dns.AAAAQueryType, dns.SRVQueryType, dns.TXTQueryType:
+		if s.registry != nil {
+			return s.registry.Lookup(q.Name.String(), q.QType)
+		}
+	}
+
+	return nil, false
+}
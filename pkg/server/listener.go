@@ -0,0 +1,263 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/logger"
+	"github.com/pkg/errors"
+)
+
+// Listener is a single endpoint the server accepts queries on.
+type Listener struct {
+	Address string
+	UDP     bool
+	TCP     bool
+}
+
+// ParseListener parses endpoints like "udp://127.0.0.1:53", "tcp://[::1]:53"
+// or "udp+tcp://0.0.0.0:8053". Without a scheme both transports are enabled.
+func ParseListener(value string) (Listener, error) {
+	scheme := "udp+tcp"
+	address := value
+	if i := strings.Index(value, "://"); i >= 0 {
+		scheme = value[:i]
+		address = value[i+3:]
+	}
+
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return Listener{}, errors.Wrapf(err, "parsing listen address %q", value)
+	}
+
+	l := Listener{Address: address}
+	for _, transport := range strings.Split(scheme, "+") {
+		switch transport {
+		case "udp":
+			l.UDP = true
+		case "tcp":
+			l.TCP = true
+		default:
+			return Listener{}, errors.Errorf("unknown transport %q in %q", transport, value)
+		}
+	}
+
+	return l, nil
+}
+
+func (l Listener) String() string {
+	transports := make([]string, 0, 2)
+	if l.UDP {
+		transports = append(transports, "udp")
+	}
+	if l.TCP {
+		transports = append(transports, "tcp")
+	}
+
+	return fmt.Sprintf("%s://%s", strings.Join(transports, "+"), l.Address)
+}
+
+// Listeners implements flag.Value, every use of the flag adds an endpoint.
+type Listeners []Listener
+
+func (ls *Listeners) String() string {
+	endpoints := make([]string, 0, len(*ls))
+	for _, l := range *ls {
+		endpoints = append(endpoints, l.String())
+	}
+
+	return strings.Join(endpoints, ",")
+}
+
+func (ls *Listeners) Set(value string) error {
+	l, err := ParseListener(value)
+	if err != nil {
+		return err
+	}
+
+	*ls = append(*ls, l)
+	return nil
+}
+
+// serveUDP reads datagrams while a worker is free to answer them, so that a
+// slow recursion only holds up its own client and identical queries share
+// one resolution. It returns once the answers in progress are sent.
+func (s *Server) serveUDP(ctx context.Context, conn net.PacketConn) {
+	workers := s.config.UDPWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	free := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		free <- struct{}{}
+		logger.Debugf("Waiting for requests...\n")
+		// Clients may send queries as large as the payload size advertised
+		// to them
+		reqBuffer := buffer.AcquireBytePacketBuffer()
+		reqBuffer.SetSize(int(s.maxUDPSize()))
+
+		n, addr, err := conn.ReadFrom(reqBuffer.Buf)
+		if err != nil {
+			reqBuffer.Release()
+			<-free
+			// Listener was closed on shutdown
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logAndExitIfErr("Error: reading request: %s\n", err)
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-free
+				wg.Done()
+			}()
+			s.serveDatagram(ctx, conn, reqBuffer, n, addr)
+		}()
+	}
+}
+
+// serveDatagram answers the query of n bytes read into reqBuffer and releases
+// the buffer.
+func (s *Server) serveDatagram(ctx context.Context, conn net.PacketConn, reqBuffer *buffer.BytePacketBuffer, n int, addr net.Addr) {
+	defer reqBuffer.Release()
+
+	s.capture(addr, conn.LocalAddr(), reqBuffer.Buf[:n])
+	// Parsing must not run into the zeroed rest of the buffer
+	reqBuffer.SetSize(n)
+
+	started := time.Now()
+	resBuffer := buffer.AcquireBytePacketBuffer()
+	defer resBuffer.Release()
+	data := s.answer(ctx, reqBuffer, resBuffer, reqBuffer.Buf[:n], addr)
+	if data != nil {
+		s.capture(conn.LocalAddr(), addr, data)
+		s.logQuery(addr, data, started)
+
+		_, err := conn.WriteTo(data, addr)
+		logAndExitIfErr("Error: sending response: %s\n", err)
+	}
+}
+
+func (s *Server) serveTCP(ctx context.Context, ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logAndExitIfErr("Error: accepting connection: %s\n", err)
+			continue
+		}
+
+		// Connections past the limit are closed rather than left waiting,
+		// the client retries elsewhere or later
+		open := atomic.AddInt32(&s.tcpConns, 1)
+		if s.config.MaxTCPConnections > 0 && open > int32(s.config.MaxTCPConnections) {
+			atomic.AddInt32(&s.tcpConns, -1)
+			logger.Debugf("Closing connection from %s, %d connections are open\n", conn.RemoteAddr(), open-1)
+			conn.Close()
+			continue
+		}
+
+		go func() {
+			defer atomic.AddInt32(&s.tcpConns, -1)
+			s.serveTCPConn(ctx, conn)
+		}()
+	}
+}
+
+// serveTCPConn answers queries on a connection until the client closes it,
+// stays idle for TCPIdleTimeout or has sent MaxTCPQueries. Every message is
+// prefixed with its length as two bytes (RFC1035 4.2.2).
+func (s *Server) serveTCPConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	var prefix [2]byte
+
+	for queries := 0; s.config.MaxTCPQueries <= 0 || queries < s.config.MaxTCPQueries; queries++ {
+		// A client sending its query slowly holds the connection as long as
+		// an idle one
+		if s.config.TCPIdleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(s.config.TCPIdleTimeout))
+		}
+
+		var length uint16
+		err := binary.Read(conn, binary.BigEndian, &length)
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, os.ErrDeadlineExceeded) {
+				logAndExitIfErr("Error: reading request length: %s\n", err)
+			}
+			return
+		}
+
+		reqBuffer := buffer.AcquireBytePacketBuffer()
+		reqBuffer.SetSize(int(length))
+		_, err = io.ReadFull(conn, reqBuffer.Buf)
+		if err != nil {
+			reqBuffer.Release()
+			if !errors.Is(err, os.ErrDeadlineExceeded) {
+				logAndExitIfErr("Error: reading request: %s\n", err)
+			}
+			return
+		}
+		// Answering may take longer than the client may stay idle
+		conn.SetReadDeadline(time.Time{})
+
+		s.capture(conn.RemoteAddr(), conn.LocalAddr(), reqBuffer.Buf[:length])
+
+		started := time.Now()
+		resBuffer := buffer.AcquireBytePacketBuffer()
+		// Zone transfers take as many messages as the zone needs, anything
+		// else is answered with one
+		messages := s.transfer(reqBuffer.Buf[:length], conn.RemoteAddr())
+		if messages == nil {
+			data := s.answer(ctx, reqBuffer, resBuffer, reqBuffer.Buf[:length], conn.RemoteAddr())
+			if data != nil {
+				messages = [][]byte{data}
+			}
+		}
+
+		for i, data := range messages {
+			if data == nil {
+				continue
+			}
+
+			s.capture(conn.LocalAddr(), conn.RemoteAddr(), data)
+			if i == 0 {
+				s.logQuery(conn.RemoteAddr(), data, started)
+			}
+
+			// A client reading responses slowly is cut off like an idle one
+			if s.config.TCPIdleTimeout > 0 {
+				conn.SetWriteDeadline(time.Now().Add(s.config.TCPIdleTimeout))
+			}
+			binary.BigEndian.PutUint16(prefix[:], uint16(len(data)))
+			msg := net.Buffers{prefix[:], data}
+			if _, err = msg.WriteTo(conn); err != nil {
+				break
+			}
+		}
+
+		reqBuffer.Release()
+		resBuffer.Release()
+
+		if err != nil {
+			logAndExitIfErr("Error: sending response: %s\n", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package server_test
+
+import (
+	"testing"
+
+	"github.com/msarvar/godns/pkg/server"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestParseListener(t *testing.T) {
+	t.Run("default_transports", func(t *testing.T) {
+		l, err := server.ParseListener("127.0.0.1:53")
+		NoError(t, err)
+		Equal(t, server.Listener{Address: "127.0.0.1:53", UDP: true, TCP: true}, l)
+	})
+
+	t.Run("single_transport_ipv6", func(t *testing.T) {
+		l, err := server.ParseListener("tcp://[::1]:53")
+		NoError(t, err)
+		Equal(t, server.Listener{Address: "[::1]:53", TCP: true}, l)
+		Equal(t, "tcp://[::1]:53", l.String())
+	})
+
+	t.Run("invalid_endpoints", func(t *testing.T) {
+		_, err := server.ParseListener("sctp://127.0.0.1:53")
+		Error(t, err)
+
+		_, err = server.ParseListener("udp://127.0.0.1")
+		Error(t, err)
+	})
+}
@@ -2,128 +2,134 @@ package server
 
 import (
 	"context"
-	"fmt"
-	"io/ioutil"
-	"math/rand"
+	"io"
 	"net"
-	"path/filepath"
+	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/msarvar/godns/pkg/buffer"
 	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/dnssec"
+	"github.com/msarvar/godns/pkg/docker"
+	"github.com/msarvar/godns/pkg/hosts"
+	"github.com/msarvar/godns/pkg/leases"
+	"github.com/msarvar/godns/pkg/logger"
+	"github.com/msarvar/godns/pkg/querylog"
+	"github.com/msarvar/godns/pkg/records"
+	"github.com/msarvar/godns/pkg/resolver"
+	"github.com/msarvar/godns/pkg/rpz"
 	"github.com/pkg/errors"
 )
 
-func lookup(qname string, qtype dns.QueryType, server net.IP) (*dns.DNSPacket, error) {
-	remote := &net.UDPAddr{
-		IP:   server,
-		Port: 53,
-	}
+// Server answers client queries by resolving them recursively.
+type Server struct {
+	config  *Config
+	cookies *cookieJar
+	// views are the configured views followed by the default view
+	views []*view
+	// groups are the client groups, neighbors finds the MAC addresses of
+	// clients for them
+	groups    []*group
+	neighbors *neighbors
+	// blocking toggles the blocklists of every view at runtime
+	blocking int32
+	// policy is nil without response policy zones
+	policy *rpz.Policy
+	// hosts, leases, containers and registry are nil unless configured
+	hosts      *hosts.Hosts
+	leases     *leases.Leases
+	containers *docker.Containers
+	registry   *records.Records
+
+	stats stats
+	// traffic is nil without the dashboard
+	traffic *traffic
+
+	// ready is set once all listeners are bound
+	ready int32
+	hooks hooks
+	// plugins modify queries and responses, in order
+	plugins []Plugin
+
+	// tcpConns counts the open TCP connections
+	tcpConns int32
+}
 
-	conn, err := net.Dial("udp", remote.String())
-	if err != nil {
-		return nil, errors.Wrap(err, "creating UDP connection")
+func NewServer(cfg *Config) *Server {
+	s := &Server{
+		config:   cfg,
+		cookies:  newCookieJar(),
+		stats:    stats{started: time.Now()},
+		blocking: 1,
 	}
-	defer conn.Close()
-
-	packet := dns.NewDNSPacket()
-	q := dns.NewDNSQuestion(qname, qtype)
-
-	rand.Seed(time.Now().UnixNano())
 
-	packet.Header.ID = uint16(10000 + rand.Intn(100000-5000))
-	packet.Header.RecursionDesired = true
-	packet.Questions = append(packet.Questions, q)
+	defaultView := s.newView(&View{
+		Name:          "default",
+		Zones:         cfg.Zones,
+		Catalogs:      cfg.Catalogs,
+		Forwarders:    cfg.Forwarders,
+		BlocklistFile: cfg.BlocklistFile,
+		AllowlistFile: cfg.AllowlistFile,
+	}, nil)
+
+	for _, v := range cfg.Views {
+		s.views = append(s.views, s.newView(v, defaultView))
+	}
+	s.views = append(s.views, defaultView)
 
-	reqBuffer := buffer.NewBytePacketBuffer()
-	err = packet.Write(reqBuffer)
-	if err != nil {
-		return nil, errors.Wrap(err, "preparing dns request packet")
+	for _, g := range cfg.ClientGroups {
+		s.groups = append(s.groups, newGroup(g))
 	}
+	s.neighbors = &neighbors{path: arpTable}
 
-	req, err := reqBuffer.GetRangeAtPos()
-	if err != nil {
-		return nil, errors.Wrap(err, "retrieving buffer")
+	if len(cfg.RPZ) > 0 {
+		s.policy = rpz.New(cfg.RPZ)
 	}
 
-	ioutil.WriteFile("query.txt", req, 0666)
-	_, err = conn.Write(req)
-	if err != nil {
-		return nil, errors.Wrap(err, "sending dns request")
+	if cfg.HostsFile != "" {
+		s.hosts = hosts.New(cfg.HostsFile)
 	}
 
-	// Receive DNS response
-	resBuffer := buffer.NewBytePacketBuffer()
+	if cfg.LeaseFile != "" {
+		s.leases = leases.New(cfg.LeaseFile, cfg.LeaseDomain)
+	}
 
-	_, err = conn.Read(resBuffer.Buf)
-	if err != nil {
-		return nil, errors.Wrap(err, "reading dns server response")
+	if cfg.Containers != nil {
+		s.containers = docker.New(cfg.Containers, cfg.ContainerSuffix)
 	}
 
-	resPacket, err := dns.DNSPacketFromBuffer(resBuffer)
-	if err != nil {
-		return nil, errors.Wrap(err, "parsing dns server response")
+	if cfg.Registry != nil {
+		s.registry = records.New(cfg.Registry)
 	}
 
-	res, _ := resBuffer.GetRangeAtPos()
-	ioutil.WriteFile("response.txt", res, 0666)
+	if cfg.Dashboard {
+		s.traffic = newTraffic()
+	}
 
-	return resPacket, nil
+	return s
 }
 
-func recursiveLookup(qName string, qType dns.QueryType) (*dns.DNSPacket, error) {
-	ns := net.ParseIP("198.41.0.4")
-
-	for {
-		fmt.Printf("Attempting to lookup %s %s with ns %s\n", qType, qName, ns)
-		nsCopy := ns
-		response, err := lookup(qName, qType, nsCopy)
-		if err != nil {
-			return nil, errors.Wrap(err, "looking up query name")
-		}
-
-		// if there are answers and no errors return the response
-		if len(response.Answers) != 0 && response.Header.ResCode == dns.NoError {
-			return response, nil
-		}
-
-		// If response code is NXDomain it means domain name doesn't exists, we
-		// return the response
-		if response.Header.ResCode == dns.NxDomain {
-			fmt.Println("domain not found")
-			return response, nil
-		}
-
-		// Get new name server for a query
-		if newNS := response.GetResolverNS(qName); newNS != nil {
-			ns = newNS
-			continue
-		}
-
-		newNSName := response.GetUnresolvedNS(qName)
-		if newNSName == "" {
-			fmt.Println("no new name servers to traverse")
-			return response, nil
-		}
-
-		recursiveResponse, err := recursiveLookup(newNSName, dns.AQueryType)
-		if err != nil {
-			return nil, errors.New("recursive lookup")
-		}
+// handleQuery answers the request read into reqBuffer and writes the response
+// into resBuffer, the returned slice points into it. It is shared by every
+// listener regardless of transport, ctx bounds the resolution. Responses,
+// queries dropped by a rule and malformed messages without a readable header
+// get nil.
+func (s *Server) handleQuery(ctx context.Context, reqBuffer *buffer.BytePacketBuffer, resBuffer *buffer.BytePacketBuffer, addr net.Addr) []byte {
+	atomic.AddUint64(&s.stats.queries, 1)
 
-		newNs := recursiveResponse.GetRandomA()
-		if newNs != nil {
-			ns = newNs
-		} else {
-			fmt.Println("nothing to do returning")
-			return response, nil
-		}
-	}
-}
-
-func handleQuery(udpConn net.PacketConn, reqBuffer *buffer.BytePacketBuffer, addr net.Addr) {
 	request, err := dns.DNSPacketFromBuffer(reqBuffer)
-	logAndExitIfErr("Error: initializing response: %s\n", err)
+	if err != nil {
+		// Clients get FORMERR right away instead of waiting for a timeout
+		logger.Infof("Malformed query from %s: %s\n", addr, err)
+		return s.errorFor(reqBuffer.Buf, dns.FormErr)
+	}
+	// Answering a response could reflect it to a spoofed source or start a
+	// loop between servers, it is dropped like errorFor does
+	if request.Header.Response {
+		logger.Infof("Dropping response from %s\n", addr)
+		return nil
+	}
 
 	// Uncomment for fixture generation
 	// d, _ := reqBuffer.GetRangeAtPos()
@@ -134,49 +140,237 @@ func handleQuery(udpConn net.PacketConn, reqBuffer *buffer.BytePacketBuffer, add
 	// )
 	// ioutil.WriteFile(requestFile, d, 0666)
 
+	clientIP := addrIP(addr)
+	rule := s.matchRule(clientIP, request)
+	if rule != nil && rule.Action != RuleAllow {
+		logger.Infof("Query rule %s matched %s from %s\n", rule.Action, request.Questions[0], clientIP)
+		if rule.Action == RuleDrop || rule.Action == RuleRefuse || rule.Action == RuleNXDomain {
+			s.block(ctx, "rule", rule.Action.String())
+		}
+		if rule.Action == RuleDrop {
+			return nil
+		}
+	}
+
+	// The question is resolved under the rewritten name, the client gets
+	// the answer under the one it asked for
+	var original *buffer.DomainName
+	rewritten := s.rewriteName(request)
+	if rewritten != nil {
+		original = request.Questions[0].Name
+		request.Questions[0].Name = rewritten
+	}
+
 	packet := dns.NewDNSPacket()
 	packet.Header.ID = request.Header.ID
-	packet.Header.RecursionDesired = true
-	packet.Header.RecursionAvailable = true
+	packet.Header.RecursionDesired = request.Header.RecursionDesired
+	packet.Header.RecursionAvailable = s.config.Recursion
 	packet.Header.Response = true
 
+	v := s.viewFor(clientIP)
+	cookie, cookieErr := request.Cookie()
+	ecs, ecsErr := request.ClientSubnet()
+	keepaliveErr := checkTCPKeepalive(request, addr)
+	safe := s.safeSearch(clientIP, request)
+	hit := s.matchPolicy(request)
+	local := v.answerLocally(request)
+	fromHosts, inHosts := s.lookupHosts(request)
+	var edes []*dns.ExtendedError
+
+	switch {
+	case cookieErr != nil || ecsErr != nil || keepaliveErr != nil:
+		packet.Header.ResCode = dns.FormErr
+	// Client presented a server cookie we didn't issue or that expired, it gets
+	// a fresh one with BADCOOKIE and has to retry before we do any recursion.
+	case cookie != nil && cookie.Server != nil && !s.cookies.validServerCookie(cookie, clientIP, time.Now()):
+		packet.Header.ResCode = dns.BadCookie
+	case request.Header.Opcode == dns.OpcodeNotify:
+		for _, q := range request.Questions {
+			pq := *q
+			packet.Questions = append(packet.Questions, &pq)
+		}
+		packet.Header.Opcode = dns.OpcodeNotify
+		packet.Header.AuthoritativeAnswer = true
+		packet.Header.ResCode = s.notify(clientIP, request)
+	case request.Header.Opcode != dns.OpcodeQuery:
+		for _, q := range request.Questions {
+			pq := *q
+			packet.Questions = append(packet.Questions, &pq)
+		}
+		packet.Header.Opcode = request.Header.Opcode
+		packet.Header.ResCode = dns.NoTimp
+	case len(request.Questions) == 1 && otherClass(request.Questions[0]):
+		edes = append(edes, s.answerClass(packet, request.Questions[0])...)
+	case rule != nil && rule.Action == RuleRefuse:
+		q := *request.Questions[0]
+		packet.Questions = append(packet.Questions, &q)
+		packet.Header.ResCode = dns.Refused
+		edes = append(edes, &dns.ExtendedError{Code: dns.EDEProhibited})
+	case rule != nil && rule.Action == RuleNXDomain:
+		q := *request.Questions[0]
+		packet.Questions = append(packet.Questions, &q)
+		packet.Header.ResCode = dns.NxDomain
+		edes = append(edes, &dns.ExtendedError{Code: dns.EDEBlocked})
+	case rule != nil && rule.Action == RuleRewrite:
+		q := *request.Questions[0]
+		packet.Questions = append(packet.Questions, &q)
+		packet.Answers = rule.answer(&q)
+		edes = append(edes, &dns.ExtendedError{Code: dns.EDEForgedAnswer})
+	case len(request.Questions) == 1 && s.blocked(v, clientIP, request.Questions[0].Name.String()):
+		q := *request.Questions[0]
+		logger.Infof("Blocked query: %s\n", &q)
+		atomic.AddUint64(&s.stats.blocked, 1)
+		s.block(ctx, "blocklist", "")
+
+		packet.Questions = append(packet.Questions, &q)
+		packet.Header.ResCode = dns.NxDomain
+		edes = append(edes, &dns.ExtendedError{Code: dns.EDEBlocked})
+	case safe != nil:
+		edes = append(edes, s.answerSafeSearch(ctx, v, packet, request.Questions[0], safe))
+	case hit != nil && hit.Action != rpz.ActionPassthru:
+		edes = append(edes, s.applyPolicy(ctx, v, packet, request.Questions[0], hit))
+	case local != nil:
+		pq := *request.Questions[0]
+		logger.Infof("Received query: %s\n", &pq)
+
+		packet.Questions = append(packet.Questions, &pq)
+		packet.Header.AuthoritativeAnswer = true
+		packet.Header.ResCode = local.Header.ResCode
+		packet.Answers = local.Answers
+		packet.Authorities = local.Authorities
+		packet.Resources = local.Resources
+	case inHosts:
+		pq := *request.Questions[0]
+		logger.Infof("Answered from local names: %s\n", &pq)
+
+		packet.Questions = append(packet.Questions, &pq)
+		packet.Answers = fromHosts
+	// Without recursion only what is already known is answered (RFC1034 4.3.1)
+	case len(request.Questions) == 1 && !(request.Header.RecursionDesired && s.config.Recursion):
+		q := request.Questions[0]
+		logger.Infof("Received non-recursive query: %s\n", q)
+
+		pq := *q
+		packet.Questions = append(packet.Questions, &pq)
+
+		cached, ok := v.resolver.Cached(q.Name.String(), q.QType, s.upstreamClientSubnet(ecs))
+		if ok && s.config.Recursion {
+			s.cacheHit(ctx)
+			packet.Header.ResCode = cached.Header.ResCode
+			edes = append(edes, relayAnswer(packet, cached)...)
+		} else {
+			packet.Header.ResCode = dns.Refused
+			edes = append(edes, &dns.ExtendedError{Code: dns.EDENotAuthoritative})
+		}
 	// only handling cases where there is 1 question
-	if len(request.Questions) == 1 {
+	case len(request.Questions) == 1:
 		q := request.Questions[0]
-		fmt.Println(fmt.Sprintf("Received query: %+v", q))
+		logger.Infof("Received query: %s\n", q)
+		if hit != nil {
+			logPolicyHit(q, hit)
+		}
 
-		result, err := recursiveLookup(q.Name.String(), q.QType)
+		upstreamECS := s.upstreamClientSubnet(ecs)
+		var cached bool
+		result, err := v.resolver.ResolveSubnet(resolver.WithCacheReport(ctx, &cached), q.Name.String(), q.QType, upstreamECS)
+		if cached {
+			s.cacheHit(ctx)
+		}
+		if err == nil && s.config.DNS64 != nil {
+			result = s.synthesizeDNS64(ctx, v, q, result, upstreamECS)
+		}
 		if err == nil {
 			pq := *q
 			packet.Questions = append(packet.Questions, &pq)
 			packet.Header.Questions = uint16(len(packet.Questions))
 			packet.Header.ResCode = result.Header.ResCode
 
-			for _, ans := range result.Answers {
-				packet.Answers = append(packet.Answers, ans)
+			// The client learns the scope the upstream answered for
+			if ecs != nil && upstreamECS != nil {
+				if scoped, err := result.ClientSubnet(); err == nil && scoped != nil {
+					ecs.ScopePrefix = scoped.ScopePrefix
+				}
 			}
 
-			for _, auth := range result.Authorities {
-				packet.Authorities = append(packet.Authorities, auth)
-			}
-
-			for _, res := range result.Resources {
-				packet.Resources = append(packet.Resources, res)
-			}
+			edes = append(edes, relayAnswer(packet, result)...)
 		} else {
-			fmt.Println(err)
+			logger.Errorf("Error: %s\n", err)
 			packet.Header.ResCode = dns.ServFail
+			atomic.AddUint64(&s.stats.failures, 1)
+			edes = append(edes, extendedError(err))
 		}
-	} else {
+	default:
 		packet.Header.ResCode = dns.FormErr
 	}
 
-	resBuffer := buffer.NewBytePacketBuffer()
-	err = packet.Write(resBuffer)
-	logAndExitIfErr("Error: generating dns response packet: %s\n", err)
+	// Every response, errors included, names the question it answers and
+	// errors vouch for no data
+	if len(packet.Questions) == 0 {
+		for _, q := range request.Questions {
+			pq := *q
+			packet.Questions = append(packet.Questions, &pq)
+		}
+	}
+	packet.Header.Opcode = request.Header.Opcode
+	packet.Header.CheckingDisabled = request.Header.CheckingDisabled
+	switch packet.Header.ResCode {
+	case dns.FormErr, dns.ServFail, dns.NoTimp, dns.Refused, dns.BadCookie:
+		packet.Header.AuthoritativeAnswer = false
+		packet.Header.AuthedData = false
+	}
+
+	if rewritten != nil {
+		restoreName(packet, original, rewritten)
+	}
+
+	if s.config.MinimalResponses {
+		minimize(packet)
+	}
+
+	if request.OPT() != nil {
+		opt := dns.NewOPTRecord(s.maxUDPSize())
+		opt.SetDNSSECOK(request.OPT().DNSSECOK())
+		packet.Resources = append(packet.Resources, opt)
+
+		// Clients without EDNS can't receive extended errors
+		for _, ede := range edes {
+			packet.AddExtendedError(ede)
+		}
+
+		if _, ok := request.NSID(); ok && s.config.NSID != "" {
+			packet.SetNSID([]byte(s.config.NSID))
+		}
+
+		// The option is meaningless over UDP and mustn't be sent there
+		_, tcp := addr.(*net.TCPAddr)
+		if _, ok := request.TCPKeepalive(); ok && tcp && keepaliveErr == nil && s.config.TCPIdleTimeout > 0 {
+			packet.SetTCPKeepalive(s.config.TCPIdleTimeout)
+		}
+	}
+
+	if ecs != nil {
+		packet.SetClientSubnet(ecs)
+	}
+
+	if cookie != nil {
+		packet.SetCookie(&dns.Cookie{
+			Client: cookie.Client,
+			Server: s.cookies.newServerCookie(cookie.Client, clientIP, time.Now()),
+		})
+	}
+
+	// Answers that don't fit are truncated, the client retries over TCP and
+	// is answered from the cache
+	if err := writeResponse(packet, resBuffer, s.responseSize(request, addr)); err != nil {
+		logger.Errorf("Error: generating dns response packet: %s\n", err)
+		return s.errorFor(reqBuffer.Buf, dns.ServFail)
+	}
 
 	data, err := resBuffer.GetRangeAtPos()
-	logAndExitIfErr("Error: generating dns response packet: %s\n", err)
+	if err != nil {
+		logger.Errorf("Error: generating dns response packet: %s\n", err)
+		return s.errorFor(reqBuffer.Buf, dns.ServFail)
+	}
 
 	// Uncomment for fixture generation
 	// responseFile := filepath.Join(
@@ -186,30 +380,484 @@ func handleQuery(udpConn net.PacketConn, reqBuffer *buffer.BytePacketBuffer, add
 	// )
 	// ioutil.WriteFile(responseFile, data, 0666)
 
-	_, err = udpConn.WriteTo(data, addr)
-	logAndExitIfErr("Error: sending response: %s\n", err)
+	return data
 }
 
-func Serve(ctx context.Context) {
-	// ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
-	// defer cancel()
-	udpConn, err := net.ListenPacket("udp", ":2053")
-	logAndExitIfErr("Error: receiving udp request: %s\n", err)
-	defer udpConn.Close()
+// Serve starts a server with the given config and blocks serving queries.
+func Serve(ctx context.Context, cfg *Config) error {
+	return NewServer(cfg).Serve(ctx)
+}
+
+// Serve binds every configured listener and serves queries until ctx is
+// cancelled. Each listener runs its own read loop feeding handleQuery.
+func (s *Server) Serve(ctx context.Context) error {
+	if s.config.Proxy && len(s.config.Forwarders) == 0 {
+		return errors.New("proxy mode needs forwarders")
+	}
+
+	if s.config.CacheFile != "" {
+		loaded, err := s.defaultView().cache.LoadFile(s.config.CacheFile, time.Now())
+		if err != nil {
+			logger.Errorf("Error: warming cache: %s\n", err)
+		}
+		logger.Infof("Loaded %d cached responses from %s\n", loaded, s.config.CacheFile)
+
+		defer s.saveCache()
+		go s.saveCachePeriodically(ctx)
+	}
+
+	if _, err := s.loadBlocklists(); err != nil {
+		return err
+	}
+	if err := s.loadPlugins(); err != nil {
+		return err
+	}
+	if s.config.BlocklistRefresh > 0 {
+		go s.refreshBlocklistsPeriodically(ctx)
+	}
+
+	if s.config.QueryLog != nil {
+		go s.config.QueryLog.Maintain(ctx, queryLogPruneInterval)
+	}
+	go s.dumpOnSignal(ctx)
+
+	if s.hosts != nil {
+		n, err := s.hosts.Load()
+		if err != nil {
+			return err
+		}
+		logger.Infof("Loaded %d names from hosts file %s\n", n, s.config.HostsFile)
+		go s.hosts.Watch(ctx, hostsCheckInterval)
+	}
+
+	if s.leases != nil {
+		n, err := s.leases.Load()
+		if err != nil {
+			return err
+		}
+		logger.Infof("Loaded %d DHCP leases from %s\n", n, s.config.LeaseFile)
+		go s.leases.Watch(ctx, hostsCheckInterval)
+	}
+
+	// Containers appear once the daemon answers, it needn't run yet
+	if s.containers != nil {
+		n, err := s.containers.Refresh(ctx)
+		if err != nil {
+			logger.Errorf("Error: %s\n", err)
+		}
+		logger.Infof("Found %d container names\n", n)
+		go s.containers.Watch(ctx, hostsCheckInterval)
+	}
+
+	if s.registry != nil {
+		n, err := s.registry.Refresh(ctx)
+		if err != nil {
+			logger.Errorf("Error: %s\n", err)
+		}
+		logger.Infof("Loaded %d registry records\n", n)
+		go s.registry.Watch(ctx)
+	}
+
+	if _, err := s.loadZones(ctx); err != nil {
+		return err
+	}
+	s.maintainZones(ctx)
+	if len(s.config.SigningKeys) > 0 {
+		go s.resignPeriodically(ctx)
+	}
+
+	if s.policy != nil {
+		if err := s.loadPolicy(ctx); err != nil {
+			return err
+		}
+	}
+
+	if s.config.DiscoverDesignated {
+		s.discoverDesignated(ctx)
+	}
+	if s.config.UpstreamCheckInterval > 0 {
+		s.checkUpstreams(ctx)
+	}
+
+	closers := make([]io.Closer, 0)
+	defer func() {
+		for _, c := range closers {
+			c.Close()
+		}
+	}()
+
+	loops := make([]func(), 0)
+	for _, l := range s.config.Listeners {
+		if l.UDP {
+			conn, err := net.ListenPacket("udp", l.Address)
+			if err != nil {
+				return errors.Wrapf(err, "listening on udp %s", l.Address)
+			}
+			closers = append(closers, conn)
+			loops = append(loops, func() { s.serveUDP(ctx, conn) })
+		}
+
+		if l.TCP {
+			ln, err := net.Listen("tcp", l.Address)
+			if err != nil {
+				return errors.Wrapf(err, "listening on tcp %s", l.Address)
+			}
+			closers = append(closers, ln)
+			loops = append(loops, func() { s.serveTCP(ctx, ln) })
+		}
+	}
+
+	if len(loops) == 0 {
+		return errors.New("no listeners configured")
+	}
+
+	for _, loop := range loops {
+		go loop()
+	}
+	atomic.StoreInt32(&s.ready, 1)
+
+	if s.config.HealthAddress != "" {
+		health := &http.Server{Addr: s.config.HealthAddress, Handler: s.healthHandler()}
+		ln, err := net.Listen("tcp", s.config.HealthAddress)
+		if err != nil {
+			return errors.Wrapf(err, "listening on health address %s", s.config.HealthAddress)
+		}
+		closers = append(closers, health)
+
+		go health.Serve(ln)
+	}
+
+	if s.config.AdminAddress != "" {
+		admin, err := s.serveAdmin()
+		if err != nil {
+			return err
+		}
+		closers = append(closers, admin)
+	}
+
+	<-ctx.Done()
+	return nil
+}
+
+func (s *Server) saveCache() {
+	err := s.defaultView().cache.SaveFile(s.config.CacheFile, time.Now())
+	logAndExitIfErr("Error: saving cache: %s\n", err)
+}
+
+func (s *Server) saveCachePeriodically(ctx context.Context) {
+	if s.config.CacheSaveInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.config.CacheSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.saveCache()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// refreshBlocklistsPeriodically downloads the blocklists of every view and
+// client group given as URLs again, they are replaced only when they changed.
+func (s *Server) refreshBlocklistsPeriodically(ctx context.Context) {
+	ticker := time.NewTicker(s.config.BlocklistRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, v := range s.views {
+				if v.blocklist == nil || !v.blocklist.Remote() {
+					continue
+				}
+
+				n, err := v.blocklist.Load()
+				if err != nil {
+					logger.Errorf("Error: refreshing blocklist of view %s: %s\n", v.name, err)
+					continue
+				}
+				logger.Infof("Refreshed %d blocked domains for view %s\n", n, v.name)
+			}
+
+			for _, g := range s.groups {
+				if g.blocklist == nil || !g.blocklist.Remote() {
+					continue
+				}
+
+				n, err := g.blocklist.Load()
+				if err != nil {
+					logger.Errorf("Error: refreshing blocklist of client group %s: %s\n", g.name, err)
+					continue
+				}
+				logger.Infof("Refreshed %d blocked domains for client group %s\n", n, g.name)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// resignPeriodically reloads the zones, signing them again, long before their
+// signatures expire.
+func (s *Server) resignPeriodically(ctx context.Context) {
+	ticker := time.NewTicker(dnssec.SignatureValidity / 4)
+	defer ticker.Stop()
 
 	for {
-		fmt.Println("Waiting for requests...")
-		reqBuffer := buffer.NewBytePacketBuffer()
+		select {
+		case <-ticker.C:
+			if _, err := s.loadZones(ctx); err != nil {
+				logger.Errorf("Error: signing zones: %s\n", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// relayAnswer copies the sections of a resolved response into packet and
+// returns its extended errors, which are relayed unlike the rest of OPT.
+func relayAnswer(packet *dns.DNSPacket, result *dns.DNSPacket) []*dns.ExtendedError {
+	packet.Answers = append(packet.Answers, result.Answers...)
+	packet.Authorities = append(packet.Authorities, result.Authorities...)
+
+	for _, res := range result.Resources {
+		// OPT is hop-by-hop and must not be relayed
+		if res.QType == dns.OPTQueryType {
+			continue
+		}
+		packet.Resources = append(packet.Resources, res)
+	}
+
+	return result.ExtendedErrors()
+}
+
+// minimize drops the records the client didn't ask for. Negative answers keep
+// their SOA, which tells how long to cache them, and referrals their name
+// servers and glue. NSEC records proving an answer and their signatures are
+// kept too.
+func minimize(packet *dns.DNSPacket) {
+	if len(packet.Answers) > 0 {
+		packet.Authorities = keepTypes(packet.Authorities, dns.NSECQueryType)
+		packet.Resources = nil
+		return
+	}
+
+	if soa := keepTypes(packet.Authorities, dns.SOAQueryType); len(soa) > 0 {
+		packet.Authorities = keepTypes(packet.Authorities, dns.SOAQueryType, dns.NSECQueryType)
+		packet.Resources = nil
+	}
+}
+
+// keepTypes returns the records of the types and the signatures covering
+// them.
+func keepTypes(records []*dns.DNSRecord, types ...dns.QueryType) []*dns.DNSRecord {
+	kept := make([]*dns.DNSRecord, 0, len(records))
+	for _, r := range records {
+		for _, t := range types {
+			if r.QType == t || dnssec.TypeCovered(r) == t {
+				kept = append(kept, r)
+				break
+			}
+		}
+	}
+
+	return kept
+}
+
+// blocked reports whether blocking is enabled and name is on the blocklist of
+// the view but not on its allowlist, or blocked by the group of the client.
+func (s *Server) blocked(v *view, ip net.IP, name string) bool {
+	if atomic.LoadInt32(&s.blocking) == 0 {
+		return false
+	}
+
+	if g := s.groupFor(ip); g != nil && g.blocks(name, time.Now()) {
+		return true
+	}
+
+	if v.blocklist == nil || !v.blocklist.Blocked(name) {
+		return false
+	}
+
+	return v.allowlist == nil || !v.allowlist.Allowed(name)
+}
+
+// extendedError explains a failed resolution to the client (RFC8914).
+func extendedError(err error) *dns.ExtendedError {
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, resolver.ErrNoReachableServers):
+		return &dns.ExtendedError{Code: dns.EDENoReachableAuthority}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &dns.ExtendedError{Code: dns.EDENoReachableAuthority, Text: "query budget exhausted"}
+	case errors.Is(err, resolver.ErrLimitExceeded):
+		return &dns.ExtendedError{Code: dns.EDEOther, Text: resolver.ErrLimitExceeded.Error()}
+	case errors.As(err, &netErr):
+		return &dns.ExtendedError{Code: dns.EDENetworkError}
+	default:
+		return &dns.ExtendedError{Code: dns.EDEOther}
+	}
+}
+
+// upstreamClientSubnet returns the client subnet to send upstream, shortened
+// to the configured prefix lengths. Nothing is sent unless forwarding is
+// enabled.
+func (s *Server) upstreamClientSubnet(ecs *dns.ClientSubnet) *dns.ClientSubnet {
+	if ecs == nil || !s.config.ECSForward {
+		return nil
+	}
+
+	if ecs.Address.To4() != nil {
+		return ecs.Truncate(s.config.ECSPrefixV4)
+	}
+
+	return ecs.Truncate(s.config.ECSPrefixV6)
+}
+
+// capture appends a client exchange to the pcap capture when one is
+// configured.
+func (s *Server) capture(src net.Addr, dst net.Addr, msg []byte) {
+	if s.config.Pcap == nil {
+		return
+	}
+
+	err := s.config.Pcap.WriteUDP(time.Now(), src, dst, msg)
+	logAndExitIfErr("Error: %s\n", err)
+}
+
+// queryLogPruneInterval is how often the query log is checked for queries
+// past their retention when no queries rotate it.
+const queryLogPruneInterval = time.Minute
+
+// logQuery stores the question and outcome of a response sent to a client in
+// the query log when one is configured and counts it for the dashboard.
+func (s *Server) logQuery(addr net.Addr, response []byte, started time.Time) {
+	if s.config.QueryLog == nil && s.traffic == nil {
+		return
+	}
+
+	packet, err := dns.ParseLazy(response)
+	if err != nil || len(packet.Questions) == 0 {
+		return
+	}
+
+	q := packet.Questions[0]
+	if s.traffic != nil {
+		s.traffic.record(q.Name.String(), addrIP(addr).String(), time.Now())
+	}
+	if s.config.QueryLog == nil {
+		return
+	}
+
+	err = s.config.QueryLog.Write(&querylog.Entry{
+		Time:    started,
+		Client:  addrIP(addr).String(),
+		Name:    q.Name.String(),
+		Type:    q.QType.String(),
+		ResCode: packet.Header.ResCode.String(),
+		Answers: int(packet.Header.Answers),
+		Elapsed: time.Since(started).Microseconds(),
+	})
+	if err != nil {
+		logger.Errorf("Error: %s\n", err)
+	}
+}
+
+// maxUDPSize is the payload size advertised to EDNS clients.
+func (s *Server) maxUDPSize() uint16 {
+	if s.config.MaxUDPSize < dns.DefaultUDPPayloadSize {
+		return dns.DefaultUDPPayloadSize
+	}
 
-		_, addr, err := udpConn.ReadFrom(reqBuffer.Buf)
-		logAndExitIfErr("Error: reading request: %s\n", err)
+	return s.config.MaxUDPSize
+}
+
+// checkTCPKeepalive rejects an edns-tcp-keepalive option with a timeout in
+// a query over TCP, only servers send one (RFC7828 3.2.1). Over UDP the
+// option is ignored.
+func checkTCPKeepalive(request *dns.DNSPacket, addr net.Addr) error {
+	if _, ok := addr.(*net.TCPAddr); !ok {
+		return nil
+	}
+	opt := request.OPT()
+	if opt == nil {
+		return nil
+	}
+
+	if o := opt.Option(dns.TCPKeepaliveOptionCode); o != nil && len(o.Data) > 0 {
+		return errors.New("edns-tcp-keepalive option with a timeout in a query")
+	}
+
+	return nil
+}
+
+// writeResponse writes the packet into resBuffer, at most size bytes of it.
+// Large sizes, those of TCP responses, are only allocated when the packet
+// doesn't fit a pooled buffer.
+func writeResponse(packet *dns.DNSPacket, resBuffer *buffer.BytePacketBuffer, size int) error {
+	if size <= buffer.MaxPooledSize || packet.Header.TruncatedMessage {
+		resBuffer.SetSize(size)
+		return packet.Write(resBuffer)
+	}
+
+	resBuffer.SetSize(buffer.MaxPooledSize)
+	if err := packet.Write(resBuffer); err != nil {
+		return err
+	}
+
+	// Write leaves the packet alone, whether it fit shows in the header
+	// written
+	written := *resBuffer
+	written.Seek(0)
+	header := dns.NewDNSHeader()
+	if err := header.Read(&written); err != nil || !header.TruncatedMessage {
+		return err
+	}
+
+	resBuffer.Reset()
+	resBuffer.SetSize(size)
+	return packet.Write(resBuffer)
+}
+
+// responseSize is how large the response to request may get. Responses over
+// TCP may use the whole message size, over UDP the payload size the client
+// advertised up to maxUDPSize, or 512 bytes without EDNS (RFC6891 6.2.5).
+func (s *Server) responseSize(request *dns.DNSPacket, addr net.Addr) int {
+	if _, ok := addr.(*net.TCPAddr); ok {
+		return dns.MaxMessageSize
+	}
+
+	opt := request.OPT()
+	if opt == nil || opt.UDPSize() < dns.DefaultUDPPayloadSize {
+		return dns.DefaultUDPPayloadSize
+	}
+	if opt.UDPSize() > s.maxUDPSize() {
+		return int(s.maxUDPSize())
+	}
+
+	return int(opt.UDPSize())
+}
 
-		handleQuery(udpConn, reqBuffer, addr)
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.TCPAddr:
+		return a.IP
+	default:
+		return nil
 	}
 }
 
 func logAndExitIfErr(msg string, err error) {
 	if err != nil {
-		fmt.Printf(msg, err)
+		logger.Errorf(msg, err)
 	}
 }
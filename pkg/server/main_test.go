@@ -0,0 +1,1035 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/dnssec"
+	"github.com/msarvar/godns/pkg/dnstest"
+	"github.com/msarvar/godns/pkg/docker"
+	"github.com/msarvar/godns/pkg/querylog"
+	"github.com/msarvar/godns/pkg/resolver"
+	"github.com/pkg/errors"
+)
+
+func TestExtendedError(t *testing.T) {
+	t.Run("classifies_resolution_failures", func(t *testing.T) {
+		err := errors.Wrap(resolver.ErrNoReachableServers, "looking up query name")
+		Equal(t, dns.EDENoReachableAuthority, extendedError(err).Code)
+
+		err = errors.Wrap(&net.OpError{Op: "read", Err: errors.New("i/o timeout")}, "reading dns server response")
+		Equal(t, dns.EDENetworkError, extendedError(err).Code)
+
+		err = errors.Wrap(resolver.ErrLimitExceeded, "more than 100 upstream queries")
+		Equal(t, &dns.ExtendedError{Code: dns.EDEOther, Text: "resolution limit exceeded"}, extendedError(err))
+
+		Equal(t, dns.EDEOther, extendedError(errors.New("boom")).Code)
+	})
+}
+
+// exchange runs the request through the handler as if sent from 127.0.0.1.
+func exchange(t *testing.T, s *Server, request *dns.DNSPacket) *dns.DNSPacket {
+	reqBuffer := buffer.NewBytePacketBuffer()
+	if err := request.Write(reqBuffer); err != nil {
+		t.Fatal(err)
+	}
+	reqBuffer.Seek(0)
+
+	resBuffer := buffer.NewBytePacketBuffer()
+	s.handleQuery(context.Background(), reqBuffer, resBuffer, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353})
+	resBuffer.Seek(0)
+
+	response, err := dns.DNSPacketFromBuffer(resBuffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return response
+}
+
+func TestRecursion(t *testing.T) {
+	query := func(rd bool) *dns.DNSPacket {
+		request := dns.NewDNSPacket()
+		request.Header.ID = 4660
+		request.Header.RecursionDesired = rd
+		request.Questions = append(request.Questions, dns.NewDNSQuestion("www.example.com", dns.AQueryType))
+		request.Resources = append(request.Resources, dns.NewOPTRecord(dns.DefaultUDPPayloadSize))
+		return request
+	}
+
+	t.Run("non_recursive_query_misses_cache", func(t *testing.T) {
+		s := NewServer(DefaultConfig())
+
+		response := exchange(t, s, query(false))
+		Equal(t, dns.Refused, response.Header.ResCode)
+		False(t, response.Header.RecursionDesired)
+		True(t, response.Header.RecursionAvailable)
+		Equal(t, []*dns.ExtendedError{{Code: dns.EDENotAuthoritative}}, response.ExtendedErrors())
+	})
+
+	t.Run("non_recursive_query_hits_cache", func(t *testing.T) {
+		s := NewServer(DefaultConfig())
+
+		cached := dns.NewDNSPacket()
+		cached.Header.Response = true
+		cached.Questions = append(cached.Questions, dns.NewDNSQuestion("www.example.com", dns.AQueryType))
+		cached.Answers = append(cached.Answers, dnstest.Record(t, "www.example.com. 300 IN A 192.0.2.1"))
+		s.defaultView().cache.Put("www.example.com", dns.AQueryType, nil, cached, time.Now())
+
+		response := exchange(t, s, query(false))
+		Equal(t, dns.NoError, response.Header.ResCode)
+		if Len(t, response.Answers, 1) {
+			Equal(t, "192.0.2.1", response.Answers[0].Addr.String())
+		}
+	})
+
+	t.Run("recursion_disabled", func(t *testing.T) {
+		cfg := DefaultConfig()
+		cfg.Recursion = false
+		s := NewServer(cfg)
+
+		response := exchange(t, s, query(true))
+		Equal(t, dns.Refused, response.Header.ResCode)
+		True(t, response.Header.RecursionDesired)
+		False(t, response.Header.RecursionAvailable)
+	})
+}
+
+func TestForwardedQueries(t *testing.T) {
+	ns := dnstest.NewServer(t, map[string]string{"example.com": `
+@	IN SOA	ns.example.com. hostmaster.example.com. 1 7200 3600 1209600 300
+@	IN NS	ns.example.com.
+ns	IN A	192.0.2.53
+www	IN A	192.0.2.1
+`})
+
+	cfg := DefaultConfig()
+	NoError(t, cfg.Forwarders.Set(ns.Addr.String()))
+	s := NewServer(cfg)
+
+	query := func(name string) *dns.DNSPacket {
+		request := dns.NewDNSPacket()
+		request.Header.ID = 4660
+		request.Header.RecursionDesired = true
+		request.Questions = append(request.Questions, dns.NewDNSQuestion(name, dns.AQueryType))
+		return request
+	}
+
+	t.Run("answer", func(t *testing.T) {
+		response := exchange(t, s, query("www.example.com"))
+		Equal(t, dns.NoError, response.Header.ResCode)
+		if Len(t, response.Answers, 1) {
+			Equal(t, "192.0.2.1", response.Answers[0].Addr.String())
+		}
+	})
+
+	t.Run("nxdomain", func(t *testing.T) {
+		response := exchange(t, s, query("missing.example.com"))
+		Equal(t, dns.NxDomain, response.Header.ResCode)
+		Empty(t, response.Answers)
+	})
+
+	t.Run("cached_after_first_query", func(t *testing.T) {
+		before := len(ns.Queries())
+		exchange(t, s, query("www.example.com"))
+		Equal(t, before, len(ns.Queries()))
+	})
+}
+
+func TestProxy(t *testing.T) {
+	ns := dnstest.NewServer(t, map[string]string{"example.com": `
+@	IN SOA	ns.example.com. hostmaster.example.com. 1 7200 3600 1209600 300
+@	IN NS	ns.example.com.
+www	IN A	192.0.2.1
+`})
+
+	cfg := DefaultConfig()
+	cfg.Proxy = true
+	NoError(t, cfg.Forwarders.Set(ns.Addr.String()))
+	s := NewServer(cfg)
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353}
+
+	request := dns.NewDNSPacket()
+	request.Header.ID = 4660
+	request.Header.RecursionDesired = true
+	request.Questions = append(request.Questions, dns.NewDNSQuestion("www.example.com", dns.AQueryType))
+	reqBuffer := buffer.NewBytePacketBuffer()
+	NoError(t, request.Write(reqBuffer))
+	msg := reqBuffer.Buf[:reqBuffer.Pos()]
+
+	t.Run("relays_upstream_answer", func(t *testing.T) {
+		data, relayed := s.relay(context.Background(), msg, addr)
+		True(t, relayed)
+
+		lazy, err := dns.ParseLazy(data)
+		NoError(t, err)
+		response, err := lazy.Packet()
+		NoError(t, err)
+		Equal(t, uint16(4660), response.Header.ID)
+		True(t, response.Header.AuthoritativeAnswer, "the upstream flags are kept")
+		if Len(t, response.Answers, 1) {
+			Equal(t, "192.0.2.1", response.Answers[0].Addr.String())
+		}
+	})
+
+	t.Run("not_cached", func(t *testing.T) {
+		before := len(ns.Queries())
+		s.relay(context.Background(), msg, addr)
+		Equal(t, before+1, len(ns.Queries()))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		_, relayed := NewServer(DefaultConfig()).relay(context.Background(), msg, addr)
+		False(t, relayed)
+	})
+
+	t.Run("aaaa_left_to_dns64", func(t *testing.T) {
+		cfg := DefaultConfig()
+		cfg.Proxy = true
+		NoError(t, cfg.Forwarders.Set(ns.Addr.String()))
+		prefix, err := ParseDNS64Prefix(DefaultDNS64Prefix)
+		NoError(t, err)
+		cfg.DNS64 = prefix
+		s := NewServer(cfg)
+
+		request := dns.NewDNSPacket()
+		request.Header.ID = 4660
+		request.Header.RecursionDesired = true
+		request.Questions = append(request.Questions, dns.NewDNSQuestion("www.example.com", dns.AAAAQueryType))
+		reqBuffer := buffer.NewBytePacketBuffer()
+		NoError(t, request.Write(reqBuffer))
+
+		_, relayed := s.relay(context.Background(), reqBuffer.Buf[:reqBuffer.Pos()], addr)
+		False(t, relayed)
+		_, relayed = s.relay(context.Background(), msg, addr)
+		True(t, relayed, "other types are still relayed")
+	})
+}
+
+func TestResponseSize(t *testing.T) {
+	zoneFile := filepath.Join(t.TempDir(), "example.zone")
+	content := "@ SOA ns.example.com. admin.example.com. 1 2 3 4 5\n"
+	for i := 1; i <= 60; i++ {
+		content += fmt.Sprintf("big A 192.0.2.%d\n", i)
+	}
+	NoError(t, ioutil.WriteFile(zoneFile, []byte(content), 0644))
+
+	cfg := DefaultConfig()
+	NoError(t, cfg.Zones.Set("example.com="+zoneFile))
+	s := NewServer(cfg)
+	_, err := s.loadZones(context.Background())
+	NoError(t, err)
+
+	query := func(addr net.Addr, udpSize uint16) ([]byte, *dns.DNSPacket) {
+		request := dns.NewDNSPacket()
+		request.Header.ID = 4660
+		request.Questions = append(request.Questions, dns.NewDNSQuestion("big.example.com", dns.AQueryType))
+		if udpSize > 0 {
+			request.Resources = append(request.Resources, dns.NewOPTRecord(udpSize))
+		}
+
+		reqBuffer := buffer.NewBytePacketBuffer()
+		NoError(t, request.Write(reqBuffer))
+		reqBuffer.Seek(0)
+
+		data := s.handleQuery(context.Background(), reqBuffer, buffer.NewBytePacketBuffer(), addr)
+		response, err := dns.ReadPacket(bytes.NewReader(data))
+		NoError(t, err)
+		return data, response
+	}
+
+	udp := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353}
+	tcp := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353}
+
+	t.Run("plain_udp_truncated", func(t *testing.T) {
+		data, response := query(udp, 0)
+		LessOrEqual(t, len(data), 512)
+		True(t, response.Header.TruncatedMessage)
+		Less(t, len(response.Answers), 60)
+	})
+
+	t.Run("edns_size_honored", func(t *testing.T) {
+		data, response := query(udp, 4096)
+		Greater(t, len(data), 512)
+		False(t, response.Header.TruncatedMessage)
+		Len(t, response.Answers, 60)
+		Equal(t, cfg.MaxUDPSize, response.OPT().UDPSize())
+	})
+
+	t.Run("edns_size_capped", func(t *testing.T) {
+		s.config.MaxUDPSize = 600
+		defer func() { s.config.MaxUDPSize = 1232 }()
+
+		data, response := query(udp, 4096)
+		LessOrEqual(t, len(data), 600)
+		True(t, response.Header.TruncatedMessage)
+	})
+
+	t.Run("tcp_not_truncated", func(t *testing.T) {
+		_, response := query(tcp, 0)
+		False(t, response.Header.TruncatedMessage)
+		Len(t, response.Answers, 60)
+	})
+}
+
+func TestWriteResponse(t *testing.T) {
+	packet := func(n int) *dns.DNSPacket {
+		p := dns.NewDNSPacket()
+		p.Header.Response = true
+		p.Questions = append(p.Questions, dns.NewDNSQuestion("big.example.com", dns.AQueryType))
+		for i := 0; i < n; i++ {
+			record, err := dns.NewARecord("big.example.com", net.IPv4(192, 0, 2, byte(i)), 300)
+			NoError(t, err)
+			p.Answers = append(p.Answers, record)
+		}
+		return p
+	}
+
+	t.Run("small_tcp_response_pooled", func(t *testing.T) {
+		resBuffer := buffer.NewBytePacketBuffer()
+		NoError(t, writeResponse(packet(10), resBuffer, dns.MaxMessageSize))
+		LessOrEqual(t, cap(resBuffer.Buf), buffer.MaxPooledSize)
+	})
+
+	t.Run("large_tcp_response_complete", func(t *testing.T) {
+		p := packet(400)
+		resBuffer := buffer.NewBytePacketBuffer()
+		NoError(t, writeResponse(p, resBuffer, dns.MaxMessageSize))
+		False(t, p.Header.TruncatedMessage)
+
+		data, err := resBuffer.GetRangeAtPos()
+		NoError(t, err)
+		Greater(t, len(data), buffer.MaxPooledSize)
+		response, err := dns.ReadPacket(bytes.NewReader(data))
+		NoError(t, err)
+		False(t, response.Header.TruncatedMessage)
+		Len(t, response.Answers, 400)
+	})
+}
+
+func TestDNSSEC(t *testing.T) {
+	zoneFile := filepath.Join(t.TempDir(), "example.zone")
+	NoError(t, ioutil.WriteFile(zoneFile, []byte("@ SOA ns.example.com. admin.example.com. 1 2 3 4 5\nwww A 192.0.2.1\n"), 0644))
+
+	key, err := dnssec.GenerateKey("example.com", true)
+	NoError(t, err)
+
+	cfg := DefaultConfig()
+	NoError(t, cfg.Zones.Set("example.com="+zoneFile))
+	cfg.SigningKeys = dnssec.Keys{key}
+	s := NewServer(cfg)
+	_, err = s.loadZones(context.Background())
+	NoError(t, err)
+
+	query := func(name string, do bool) *dns.DNSPacket {
+		request := dns.NewDNSPacket()
+		request.Header.ID = 4660
+		request.Questions = append(request.Questions, dns.NewDNSQuestion(name, dns.AQueryType))
+		opt := dns.NewOPTRecord(1232)
+		opt.SetDNSSECOK(do)
+		request.Resources = append(request.Resources, opt)
+
+		return exchange(t, s, request)
+	}
+	types := func(records []*dns.DNSRecord) []dns.QueryType {
+		out := make([]dns.QueryType, 0)
+		for _, r := range records {
+			out = append(out, r.QType)
+		}
+		return out
+	}
+
+	t.Run("signatures_for_do_clients", func(t *testing.T) {
+		response := query("www.example.com", true)
+		Equal(t, []dns.QueryType{dns.AQueryType, dns.RRSIGQueryType}, types(response.Answers))
+		True(t, response.OPT().DNSSECOK())
+
+		response = query("missing.example.com", true)
+		Equal(t, dns.NxDomain, response.Header.ResCode)
+		Contains(t, types(response.Authorities), dns.NSECQueryType)
+	})
+
+	t.Run("no_signatures_without_do", func(t *testing.T) {
+		response := query("www.example.com", false)
+		Equal(t, []dns.QueryType{dns.AQueryType}, types(response.Answers))
+		False(t, response.OPT().DNSSECOK())
+	})
+}
+
+func TestHosts(t *testing.T) {
+	hostsFile := filepath.Join(t.TempDir(), "hosts")
+	NoError(t, ioutil.WriteFile(hostsFile, []byte("10.0.0.1 nas.lan nas\n"), 0644))
+
+	leaseFile := filepath.Join(t.TempDir(), "dnsmasq.leases")
+	NoError(t, ioutil.WriteFile(leaseFile, []byte("0 00:11:22:33:44:55 10.0.0.2 laptop *\n"), 0644))
+
+	cfg := DefaultConfig()
+	cfg.HostsFile = hostsFile
+	cfg.LeaseFile = leaseFile
+	cfg.Containers = docker.Static{"web": {net.ParseIP("172.18.0.2")}}
+	srv, err := dns.NewSRVRecord("_http._tcp.web.lan", dns.SRV{Priority: 1, Weight: 1, Port: 80, Target: "web.docker"}, 5)
+	NoError(t, err)
+	cfg.Registry = staticRegistry{srv}
+	s := NewServer(cfg)
+	_, err = s.hosts.Load()
+	NoError(t, err)
+	_, err = s.leases.Load()
+	NoError(t, err)
+	_, err = s.containers.Refresh(context.Background())
+	NoError(t, err)
+	_, err = s.registry.Refresh(context.Background())
+	NoError(t, err)
+
+	query := func(name string, qtype dns.QueryType) *dns.DNSPacket {
+		request := dns.NewDNSPacket()
+		request.Header.ID = 4660
+		request.Header.RecursionDesired = true
+		request.Questions = append(request.Questions, dns.NewDNSQuestion(name, qtype))
+		return exchange(t, s, request)
+	}
+
+	t.Run("addresses_and_reverse_names", func(t *testing.T) {
+		response := query("nas.lan", dns.AQueryType)
+		Equal(t, dns.NoError, response.Header.ResCode)
+		Len(t, response.Answers, 1)
+		Equal(t, "nas.lan. 60 IN A 10.0.0.1", response.Answers[0].Text())
+
+		response = query("1.0.0.10.in-addr.arpa", dns.PTRQueryType)
+		Len(t, response.Answers, 1)
+		Equal(t, "1.0.0.10.in-addr.arpa. 60 IN PTR nas.lan.", response.Answers[0].Text())
+	})
+
+	t.Run("dhcp_clients_under_lease_domain", func(t *testing.T) {
+		response := query("laptop.lan", dns.AQueryType)
+		Len(t, response.Answers, 1)
+		Equal(t, "laptop.lan. 60 IN A 10.0.0.2", response.Answers[0].Text())
+	})
+
+	t.Run("containers_under_suffix", func(t *testing.T) {
+		response := query("web.docker", dns.AQueryType)
+		Len(t, response.Answers, 1)
+		Equal(t, "web.docker. 10 IN A 172.18.0.2", response.Answers[0].Text())
+	})
+
+	t.Run("registry_services", func(t *testing.T) {
+		response := query("_http._tcp.web.lan", dns.SRVQueryType)
+		Len(t, response.Answers, 1)
+		Equal(t, srv.Data, response.Answers[0].Data)
+	})
+
+	t.Run("missing_type_has_no_data", func(t *testing.T) {
+		response := query("nas", dns.AAAAQueryType)
+		Equal(t, dns.NoError, response.Header.ResCode)
+		Empty(t, response.Answers)
+	})
+
+	t.Run("answered_locally_in_proxy_mode", func(t *testing.T) {
+		s.config.Proxy = true
+		defer func() { s.config.Proxy = false }()
+
+		relayed := func(name string, qtype dns.QueryType) bool {
+			request := dns.NewDNSPacket()
+			request.Header.ID = 4660
+			request.Header.RecursionDesired = true
+			request.Questions = append(request.Questions, dns.NewDNSQuestion(name, qtype))
+			reqBuffer := buffer.NewBytePacketBuffer()
+			NoError(t, request.Write(reqBuffer))
+
+			_, ok := s.relay(context.Background(), reqBuffer.Buf[:reqBuffer.Pos()], &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353})
+			return ok
+		}
+
+		False(t, relayed("nas.lan", dns.AQueryType))
+		False(t, relayed("laptop.lan", dns.AQueryType))
+		False(t, relayed("web.docker", dns.AQueryType))
+		False(t, relayed("_http._tcp.web.lan", dns.SRVQueryType))
+		True(t, relayed("www.example.com", dns.AQueryType))
+	})
+}
+
+// staticRegistry is a registry whose records never change.
+type staticRegistry []*dns.DNSRecord
+
+func (r staticRegistry) Records(ctx context.Context) ([]*dns.DNSRecord, error) {
+	return r, nil
+}
+
+func (r staticRegistry) Wait(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestNotify(t *testing.T) {
+	const version = "@ SOA ns.example.com. admin.example.com. %d 2 3 4 5\nwww A %s\n"
+	primary := dnstest.NewServer(t, map[string]string{
+		"example.com": fmt.Sprintf(version, 1, "192.0.2.1"),
+	})
+
+	cfg := DefaultConfig()
+	NoError(t, cfg.Zones.Set("example.com=axfr://"+primary.Addr.String()))
+	s := NewServer(cfg)
+	_, err := s.loadZones(context.Background())
+	NoError(t, err)
+
+	notify := func(name string) *dns.DNSPacket {
+		request := dns.NewDNSPacket()
+		request.Header.ID = 4660
+		request.Header.Opcode = dns.OpcodeNotify
+		request.Header.AuthoritativeAnswer = true
+		request.Questions = append(request.Questions, dns.NewDNSQuestion(name, dns.SOAQueryType))
+		return request
+	}
+
+	t.Run("primary_triggers_transfer", func(t *testing.T) {
+		primary.SetZone(t, "example.com", fmt.Sprintf(version, 2, "192.0.2.2"))
+
+		response := exchange(t, s, notify("example.com"))
+		Equal(t, dns.NoError, response.Header.ResCode)
+		Equal(t, dns.OpcodeNotify, response.Header.Opcode)
+		True(t, response.Header.Response)
+		Len(t, response.Questions, 1)
+
+		Eventually(t, func() bool {
+			answer := s.defaultView().zones.Find("www.example.com").Answer("www.example.com", dns.AQueryType)
+			return answer.Answers[0].Addr.String() == "192.0.2.2"
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("unknown_zones_and_senders", func(t *testing.T) {
+		Equal(t, dns.NotAuth, exchange(t, s, notify("example.org")).Header.ResCode)
+		Equal(t, dns.Refused, s.notify(net.IPv4(192, 0, 2, 99), notify("example.com")))
+	})
+}
+
+func TestMinimize(t *testing.T) {
+	record := func(text string) *dns.DNSRecord {
+		r, err := dns.ParseRecord(text, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	soa := record("example.com. 300 IN SOA ns.example.com. admin.example.com. 1 7200 3600 1209600 300")
+	ns := record("example.com. 3600 IN NS ns.example.com.")
+	glue := record("ns.example.com. 3600 IN A 192.0.2.53")
+
+	t.Run("answers_lose_other_sections", func(t *testing.T) {
+		packet := dns.NewDNSPacket()
+		packet.Answers = []*dns.DNSRecord{record("www.example.com. 300 IN A 192.0.2.1")}
+		packet.Authorities = []*dns.DNSRecord{ns}
+		packet.Resources = []*dns.DNSRecord{glue}
+
+		minimize(packet)
+		Len(t, packet.Answers, 1)
+		Empty(t, packet.Authorities)
+		Empty(t, packet.Resources)
+	})
+
+	t.Run("negative_answers_keep_soa", func(t *testing.T) {
+		packet := dns.NewDNSPacket()
+		packet.Header.ResCode = dns.NxDomain
+		packet.Authorities = []*dns.DNSRecord{ns, soa}
+		packet.Resources = []*dns.DNSRecord{glue}
+
+		minimize(packet)
+		Equal(t, []*dns.DNSRecord{soa}, packet.Authorities)
+		Empty(t, packet.Resources)
+	})
+
+	t.Run("referrals_keep_name_servers_and_glue", func(t *testing.T) {
+		packet := dns.NewDNSPacket()
+		packet.Authorities = []*dns.DNSRecord{ns}
+		packet.Resources = []*dns.DNSRecord{glue}
+
+		minimize(packet)
+		Equal(t, []*dns.DNSRecord{ns}, packet.Authorities)
+		Equal(t, []*dns.DNSRecord{glue}, packet.Resources)
+	})
+}
+
+// BenchmarkHandleQuery measures the handler on a query that is answered
+// without recursion, so that only parsing, encoding and buffer handling are
+// measured.
+func BenchmarkHandleQuery(b *testing.B) {
+	s := NewServer(DefaultConfig())
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353}
+
+	query := dns.NewDNSPacket()
+	query.Header.ID = 4660
+	query.SetCookie(&dns.Cookie{Client: []byte{1, 2, 3, 4, 5, 6, 7, 8}})
+
+	queryBuffer := buffer.NewBytePacketBuffer()
+	if err := query.Write(queryBuffer); err != nil {
+		b.Fatal(err)
+	}
+	msg, _ := queryBuffer.GetRangeAtPos()
+
+	b.Run("fresh_buffers", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			reqBuffer := buffer.NewBytePacketBuffer()
+			copy(reqBuffer.Buf, msg)
+			s.handleQuery(context.Background(), reqBuffer, buffer.NewBytePacketBuffer(), addr)
+		}
+	})
+
+	b.Run("pooled_buffers", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			reqBuffer := buffer.AcquireBytePacketBuffer()
+			resBuffer := buffer.AcquireBytePacketBuffer()
+			copy(reqBuffer.Buf, msg)
+			s.handleQuery(context.Background(), reqBuffer, resBuffer, addr)
+			reqBuffer.Release()
+			resBuffer.Release()
+		}
+	})
+}
+
+func TestQueryLog(t *testing.T) {
+	dir := t.TempDir()
+	zoneFile := filepath.Join(dir, "corp.zone")
+	NoError(t, ioutil.WriteFile(zoneFile, []byte("@ SOA ns.corp.example. admin.corp.example. 1 2 3 4 5\nwww A 10.0.0.1\n"), 0644))
+
+	path := filepath.Join(dir, "queries.log")
+	log, err := querylog.Open(querylog.Config{Path: path})
+	NoError(t, err)
+	defer log.Close()
+
+	cfg := DefaultConfig()
+	cfg.QueryLog = log
+	NoError(t, cfg.Zones.Set("corp.example="+zoneFile))
+	s := NewServer(cfg)
+	_, err = s.loadZones(context.Background())
+	NoError(t, err)
+
+	request := dns.NewDNSPacket()
+	request.Questions = append(request.Questions, dns.NewDNSQuestion("www.corp.example", dns.AQueryType))
+	reqBuffer := buffer.NewBytePacketBuffer()
+	NoError(t, request.Write(reqBuffer))
+	reqBuffer.Seek(0)
+
+	addr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5353}
+	s.logQuery(addr, s.handleQuery(context.Background(), reqBuffer, buffer.NewBytePacketBuffer(), addr), time.Now())
+
+	entries, err := querylog.Tail(path, &querylog.Filter{Client: "192.0.2.1"}, 10)
+	NoError(t, err)
+	if Len(t, entries, 1) {
+		Equal(t, "www.corp.example", entries[0].Name)
+		Equal(t, "A", entries[0].Type)
+		Equal(t, "NOERROR", entries[0].ResCode)
+		Equal(t, 1, entries[0].Answers)
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	// Nothing listens on the forwarder
+	dead, err := net.ListenPacket("udp", "127.0.0.1:0")
+	NoError(t, err)
+	dead.Close()
+
+	cfg := DefaultConfig()
+	NoError(t, cfg.Forwarders.Set(dead.LocalAddr().String()))
+	s := NewServer(cfg)
+
+	query := func() *dns.DNSPacket {
+		request := dns.NewDNSPacket()
+		request.Header.ID = 4660
+		request.Header.RecursionDesired = true
+		request.Header.CheckingDisabled = true
+		request.Questions = append(request.Questions, dns.NewDNSQuestion("www.example.com", dns.AQueryType))
+		return request
+	}
+
+	check := func(t *testing.T, response *dns.DNSPacket, rcode dns.ResultCode) {
+		Equal(t, rcode, response.Header.ResCode)
+		Equal(t, uint16(4660), response.Header.ID)
+		True(t, response.Header.Response)
+		True(t, response.Header.CheckingDisabled)
+		False(t, response.Header.AuthoritativeAnswer)
+		False(t, response.Header.AuthedData)
+		if Len(t, response.Questions, 1) {
+			Equal(t, "www.example.com", response.Questions[0].Name.String())
+			Equal(t, dns.AQueryType, response.Questions[0].QType)
+		}
+	}
+
+	t.Run("servfail_echoes_question", func(t *testing.T) {
+		check(t, exchange(t, s, query()), dns.ServFail)
+	})
+
+	t.Run("badcookie_echoes_question", func(t *testing.T) {
+		request := query()
+		request.SetCookie(&dns.Cookie{Client: []byte{1, 2, 3, 4, 5, 6, 7, 8}, Server: make([]byte, 16)})
+		check(t, exchange(t, s, request), dns.BadCookie)
+	})
+
+	t.Run("unknown_opcodes_get_notimp", func(t *testing.T) {
+		request := query()
+		request.Header.Opcode = 2
+		response := exchange(t, s, request)
+		check(t, response, dns.NoTimp)
+		Equal(t, uint8(2), response.Header.Opcode)
+	})
+
+	t.Run("responses_are_dropped", func(t *testing.T) {
+		request := query()
+		request.Header.Response = true
+		reqBuffer := buffer.NewBytePacketBuffer()
+		NoError(t, request.Write(reqBuffer))
+		reqBuffer.Seek(0)
+
+		Nil(t, s.handleQuery(context.Background(), reqBuffer, buffer.NewBytePacketBuffer(), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353}))
+	})
+}
+
+func TestQueryBudget(t *testing.T) {
+	// Receives queries and never answers them
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	NoError(t, err)
+	defer silent.Close()
+
+	cfg := DefaultConfig()
+	NoError(t, cfg.Forwarders.Set(silent.LocalAddr().String()))
+	cfg.QueryTimeout = 100 * time.Millisecond
+	s := NewServer(cfg)
+
+	t.Run("budget_depends_on_transport", func(t *testing.T) {
+		Equal(t, 100*time.Millisecond, s.queryBudget(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353}))
+		Equal(t, 10*time.Second, s.queryBudget(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353}))
+	})
+
+	t.Run("exhausted_budget_answers_servfail", func(t *testing.T) {
+		request := dns.NewDNSPacket()
+		request.Header.ID = 4660
+		request.Header.RecursionDesired = true
+		request.Questions = append(request.Questions, dns.NewDNSQuestion("www.example.com", dns.AQueryType))
+		request.Resources = append(request.Resources, dns.NewOPTRecord(dns.DefaultUDPPayloadSize))
+		reqBuffer := buffer.NewBytePacketBuffer()
+		NoError(t, request.Write(reqBuffer))
+		msg, err := reqBuffer.GetRangeAtPos()
+		NoError(t, err)
+		reqBuffer.Seek(0)
+
+		started := time.Now()
+		data := s.answer(context.Background(), reqBuffer, buffer.NewBytePacketBuffer(), msg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353})
+		Less(t, int64(time.Since(started)), int64(time.Second))
+
+		lazy, err := dns.ParseLazy(data)
+		NoError(t, err)
+		response, err := lazy.Packet()
+		if NoError(t, err) {
+			Equal(t, dns.ServFail, response.Header.ResCode)
+			Equal(t, uint16(4660), response.Header.ID)
+			if edes := response.ExtendedErrors(); Len(t, edes, 1) {
+				Equal(t, dns.EDENoReachableAuthority, edes[0].Code)
+			}
+		}
+	})
+}
+
+func TestNSID(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Recursion = false
+	cfg.NSID = "dns1.fra"
+
+	query := func(s *Server, nsid bool) *dns.DNSPacket {
+		request := dns.NewDNSPacket()
+		request.Header.ID = 4660
+		request.Questions = append(request.Questions, dns.NewDNSQuestion("www.example.com", dns.AQueryType))
+		request.Resources = append(request.Resources, dns.NewOPTRecord(dns.DefaultUDPPayloadSize))
+		if nsid {
+			request.SetNSID(nil)
+		}
+		return exchange(t, s, request)
+	}
+
+	t.Run("sent_when_asked", func(t *testing.T) {
+		id, ok := query(NewServer(cfg), true).NSID()
+		True(t, ok)
+		Equal(t, []byte("dns1.fra"), id)
+	})
+
+	t.Run("not_sent_unasked", func(t *testing.T) {
+		_, ok := query(NewServer(cfg), false).NSID()
+		False(t, ok)
+	})
+
+	t.Run("not_sent_unconfigured", func(t *testing.T) {
+		_, ok := query(NewServer(DefaultConfig()), true).NSID()
+		False(t, ok)
+	})
+}
+
+func TestTCPLimits(t *testing.T) {
+	serve := func(t *testing.T, cfg *Config) string {
+		cfg.ChaosVersion = "godns"
+		s := NewServer(cfg)
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if !NoError(t, err) {
+			t.FailNow()
+		}
+		t.Cleanup(func() { ln.Close() })
+		go s.serveTCP(context.Background(), ln)
+
+		return ln.Addr().String()
+	}
+
+	dial := func(t *testing.T, addr string) net.Conn {
+		conn, err := net.Dial("tcp", addr)
+		if !NoError(t, err) {
+			t.FailNow()
+		}
+		t.Cleanup(func() { conn.Close() })
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return conn
+	}
+
+	// queryOptions asks for the version with the EDNS options
+	queryOptions := func(conn net.Conn, options map[dns.EDNSOptionCode][]byte) (*dns.DNSPacket, error) {
+		request := dns.NewDNSPacket()
+		request.Header.ID = 4660
+		q := dns.NewDNSQuestion("version.bind", dns.TXTQueryType)
+		q.Class = dns.ClassCH
+		request.Questions = append(request.Questions, q)
+		request.Resources = append(request.Resources, dns.NewOPTRecord(dns.DefaultUDPPayloadSize))
+		for code, data := range options {
+			request.OPT().SetOption(code, data)
+		}
+
+		if _, err := request.WriteTo(conn); err != nil {
+			return nil, err
+		}
+		return dns.ReadPacket(conn)
+	}
+	query := func(conn net.Conn, keepalive bool) (*dns.DNSPacket, error) {
+		if keepalive {
+			return queryOptions(conn, map[dns.EDNSOptionCode][]byte{dns.TCPKeepaliveOptionCode: nil})
+		}
+		return queryOptions(conn, nil)
+	}
+
+	// closed tells whether the server closed the connection
+	closed := func(conn net.Conn) bool {
+		_, err := conn.Read(make([]byte, 1))
+		return errors.Is(err, io.EOF)
+	}
+
+	t.Run("idle_connections_are_closed", func(t *testing.T) {
+		cfg := DefaultConfig()
+		cfg.TCPIdleTimeout = 100 * time.Millisecond
+		conn := dial(t, serve(t, cfg))
+
+		_, err := query(conn, false)
+		NoError(t, err)
+
+		started := time.Now()
+		True(t, closed(conn))
+		Less(t, time.Since(started), 2*time.Second)
+	})
+
+	t.Run("slow_queries_are_closed", func(t *testing.T) {
+		cfg := DefaultConfig()
+		cfg.TCPIdleTimeout = 100 * time.Millisecond
+		conn := dial(t, serve(t, cfg))
+
+		// Only the length of the query ever arrives
+		_, err := conn.Write([]byte{0, 30})
+		NoError(t, err)
+		True(t, closed(conn))
+	})
+
+	t.Run("connections_past_the_limit_are_closed", func(t *testing.T) {
+		cfg := DefaultConfig()
+		cfg.MaxTCPConnections = 1
+		addr := serve(t, cfg)
+
+		first := dial(t, addr)
+		_, err := query(first, false)
+		NoError(t, err)
+
+		second := dial(t, addr)
+		True(t, closed(second))
+
+		// The place is free again once the first one is gone
+		first.Close()
+		Eventually(t, func() bool {
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				return false
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(time.Second))
+			_, err = query(conn, false)
+			return err == nil
+		}, 2*time.Second, 20*time.Millisecond)
+	})
+
+	t.Run("connections_are_closed_after_max_queries", func(t *testing.T) {
+		cfg := DefaultConfig()
+		cfg.MaxTCPQueries = 2
+		conn := dial(t, serve(t, cfg))
+
+		for i := 0; i < 2; i++ {
+			response, err := query(conn, false)
+			if NoError(t, err) {
+				Len(t, response.Answers, 1)
+			}
+		}
+		True(t, closed(conn))
+	})
+
+	t.Run("keepalive_tells_the_idle_timeout", func(t *testing.T) {
+		cfg := DefaultConfig()
+		cfg.TCPIdleTimeout = 15 * time.Second
+		conn := dial(t, serve(t, cfg))
+
+		response, err := query(conn, true)
+		if NoError(t, err) {
+			timeout, ok := response.TCPKeepalive()
+			True(t, ok)
+			Equal(t, 15*time.Second, timeout)
+		}
+
+		response, err = query(conn, false)
+		if NoError(t, err) {
+			_, ok := response.TCPKeepalive()
+			False(t, ok, "only clients asking get the option")
+		}
+
+		// Never over UDP
+		s := NewServer(cfg)
+		request := dns.NewDNSPacket()
+		q := dns.NewDNSQuestion("version.bind", dns.TXTQueryType)
+		q.Class = dns.ClassCH
+		request.Questions = append(request.Questions, q)
+		request.Resources = append(request.Resources, dns.NewOPTRecord(dns.DefaultUDPPayloadSize))
+		request.OPT().SetOption(dns.TCPKeepaliveOptionCode, nil)
+		_, ok := exchange(t, s, request).TCPKeepalive()
+		False(t, ok)
+	})
+
+	t.Run("keepalive_with_a_timeout_gets_formerr", func(t *testing.T) {
+		conn := dial(t, serve(t, DefaultConfig()))
+
+		response, err := queryOptions(conn, map[dns.EDNSOptionCode][]byte{dns.TCPKeepaliveOptionCode: {0, 100}})
+		if NoError(t, err) {
+			Equal(t, dns.FormErr, response.Header.ResCode)
+			_, ok := response.TCPKeepalive()
+			False(t, ok)
+		}
+	})
+
+	t.Run("queries_larger_than_512_bytes", func(t *testing.T) {
+		conn := dial(t, serve(t, DefaultConfig()))
+
+		// Padding (RFC7830) takes the query past 512 bytes
+		response, err := queryOptions(conn, map[dns.EDNSOptionCode][]byte{12: make([]byte, 1000)})
+		if NoError(t, err) {
+			Equal(t, dns.NoError, response.Header.ResCode)
+			Len(t, response.Answers, 1)
+		}
+	})
+}
+
+func TestUDPWorkers(t *testing.T) {
+	// Counts the queries it receives and never answers them
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if !NoError(t, err) {
+		return
+	}
+	defer silent.Close()
+	upstream := make(chan struct{}, 10)
+	go func() {
+		msg := make([]byte, dns.MaxMessageSize)
+		for {
+			if _, _, err := silent.ReadFrom(msg); err != nil {
+				return
+			}
+			upstream <- struct{}{}
+		}
+	}()
+
+	cfg := DefaultConfig()
+	NoError(t, cfg.Forwarders.Set(silent.LocalAddr().String()))
+	cfg.ChaosVersion = "godns"
+	cfg.QueryTimeout = 2 * time.Second
+	s := NewServer(cfg)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if !NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	go s.serveUDP(context.Background(), conn)
+
+	send := func(name string, class uint16, qtype dns.QueryType) net.Conn {
+		client, err := net.Dial("udp", conn.LocalAddr().String())
+		if !NoError(t, err) {
+			t.FailNow()
+		}
+		t.Cleanup(func() { client.Close() })
+		client.SetDeadline(time.Now().Add(time.Second))
+
+		request := dns.NewDNSPacket()
+		request.Header.ID = 4660
+		request.Header.RecursionDesired = true
+		q := dns.NewDNSQuestion(name, qtype)
+		q.Class = class
+		request.Questions = append(request.Questions, q)
+		_, err = request.WriteTo(client)
+		NoError(t, err)
+		return client
+	}
+
+	// Both wait on the silent upstream, sharing one resolution
+	send("www.example.com", dns.ClassIN, dns.AQueryType)
+	send("www.example.com", dns.ClassIN, dns.AQueryType)
+	<-upstream
+
+	// Meanwhile other clients are answered
+	response, err := dns.ReadPacket(send("version.bind", dns.ClassCH, dns.TXTQueryType))
+	if NoError(t, err) {
+		Len(t, response.Answers, 1)
+	}
+	Empty(t, upstream)
+
+	// Queries may be as large as the payload size advertised
+	request := dns.NewDNSPacket()
+	request.Header.ID = 4660
+	q := dns.NewDNSQuestion("version.bind", dns.TXTQueryType)
+	q.Class = dns.ClassCH
+	request.Questions = append(request.Questions, q)
+	request.Resources = append(request.Resources, dns.NewOPTRecord(cfg.MaxUDPSize))
+	request.OPT().SetOption(12, make([]byte, 1000))
+	packetBuffer := buffer.NewBytePacketBuffer()
+	packetBuffer.SetSize(int(cfg.MaxUDPSize))
+	NoError(t, request.Write(packetBuffer))
+	Greater(t, packetBuffer.Pos(), 512)
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if !NoError(t, err) {
+		return
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(time.Second))
+	_, err = client.Write(packetBuffer.Buf[:packetBuffer.Pos()])
+	NoError(t, err)
+	response, err = dns.ReadPacket(client)
+	if NoError(t, err) {
+		Len(t, response.Answers, 1)
+	}
+}
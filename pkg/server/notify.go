@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/logger"
+	"github.com/msarvar/godns/pkg/zone"
+)
+
+// refreshTimeout bounds the SOA check and transfer a NOTIFY triggers.
+const refreshTimeout = time.Minute
+
+// zoneCheckInterval is how often secondary zones are checked for elapsed SOA
+// refresh, retry and expire timers.
+const zoneCheckInterval = 10 * time.Second
+
+// maintainZones refreshes and expires the secondary zones of every view by
+// their SOA timers until ctx is cancelled, NOTIFY only speeds this up.
+func (s *Server) maintainZones(ctx context.Context) {
+	started := make(map[*zone.Set]bool)
+	for _, v := range s.views {
+		if v.zones != nil && !started[v.zones] {
+			started[v.zones] = true
+			go v.zones.Maintain(ctx, zoneCheckInterval)
+		}
+	}
+}
+
+// notify handles a NOTIFY announcing a change of a secondary zone (RFC1996),
+// the zone is refreshed right away in every view transferring it from the
+// sender. The result code answers the NOTIFY.
+func (s *Server) notify(ip net.IP, request *dns.DNSPacket) dns.ResultCode {
+	if len(request.Questions) != 1 || request.Questions[0].QType != dns.SOAQueryType {
+		return dns.FormErr
+	}
+	name := request.Questions[0].Name.String()
+
+	known := false
+	refreshed := make(map[*zone.Set]bool)
+	for _, v := range s.views {
+		if v.zones == nil || refreshed[v.zones] {
+			continue
+		}
+
+		source, ok := v.zones.Secondary(name)
+		if !ok {
+			continue
+		}
+		known = true
+
+		// Anyone could otherwise make us transfer zones over and over
+		if !fromPrimary(source, ip) {
+			continue
+		}
+
+		refreshed[v.zones] = true
+		go refresh(v, name)
+	}
+
+	switch {
+	case !known:
+		logger.Infof("Ignoring NOTIFY for %s from %s, not a secondary zone\n", name, ip)
+		return dns.NotAuth
+	case len(refreshed) == 0:
+		logger.Infof("Refusing NOTIFY for %s from %s, not its primary\n", name, ip)
+		return dns.Refused
+	default:
+		logger.Infof("Received NOTIFY for %s from %s\n", name, ip)
+		return dns.NoError
+	}
+}
+
+// fromPrimary reports whether ip is an address of the primary of the zone.
+func fromPrimary(source zone.Source, ip net.IP) bool {
+	host, _, err := net.SplitHostPort(source.Primary())
+	if err != nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		logger.Errorf("Error: resolving primary %s: %s\n", host, err)
+		return false
+	}
+
+	for _, addr := range addrs {
+		if addr.IP.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// refresh transfers the zone of the view again if its serial changed.
+func refresh(v *view, name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+	defer cancel()
+
+	transferred, err := v.zones.Refresh(ctx, name)
+	switch {
+	case err != nil:
+		logger.Errorf("Error: refreshing zone %s of view %s: %s\n", name, v.name, err)
+	case transferred:
+		logger.Infof("Transferred zone %s of view %s after NOTIFY\n", name, v.name)
+	default:
+		logger.Debugf("Zone %s of view %s is up to date\n", name, v.name)
+	}
+}
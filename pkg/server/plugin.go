@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"net"
+	"plugin"
+	"strings"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/logger"
+	"github.com/pkg/errors"
+)
+
+// Plugin inspects and modifies the queries and responses of the server
+// without recompiling it. Plugins run in the order they were added, on the
+// goroutine answering the query.
+type Plugin interface {
+	// Query is called with every query before it is answered and may modify
+	// it. Returning a response answers the query with it, later plugins and
+	// the resolver are skipped.
+	Query(ctx context.Context, client net.Addr, query *dns.DNSPacket) *dns.DNSPacket
+	// Response is called with every response before it is sent and may
+	// modify it.
+	Response(ctx context.Context, client net.Addr, query, response *dns.DNSPacket)
+}
+
+// PluginSymbol is the function a Go plugin exports to be loaded, of type
+// func() (server.Plugin, error).
+const PluginSymbol = "NewPlugin"
+
+// LoadPlugin opens the Go plugin built with -buildmode=plugin at path. The
+// plugin must be built with the same Go version and godns sources as the
+// server, and plugins only load on Linux, macOS and FreeBSD.
+func LoadPlugin(path string) (Plugin, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening plugin %s", path)
+	}
+
+	sym, err := p.Lookup(PluginSymbol)
+	if err != nil {
+		return nil, errors.Wrapf(err, "loading plugin %s", path)
+	}
+	newPlugin, ok := sym.(func() (Plugin, error))
+	if !ok {
+		return nil, errors.Errorf("plugin %s: %s is a %T, not a func() (server.Plugin, error)", path, PluginSymbol, sym)
+	}
+
+	loaded, err := newPlugin()
+	if err != nil {
+		return nil, errors.Wrapf(err, "starting plugin %s", path)
+	}
+
+	return loaded, nil
+}
+
+// PluginFiles implements flag.Value, every use of the flag adds the path of
+// a Go plugin.
+type PluginFiles []string
+
+func (ps *PluginFiles) String() string {
+	return strings.Join(*ps, ",")
+}
+
+func (ps *PluginFiles) Set(value string) error {
+	if value == "" {
+		return errors.New("empty plugin path")
+	}
+
+	*ps = append(*ps, value)
+	return nil
+}
+
+// Use adds p after the plugins already in use, it must be called before
+// Serve.
+func (s *Server) Use(p Plugin) {
+	s.plugins = append(s.plugins, p)
+}
+
+// loadPlugins loads the plugins of the configuration after those added with
+// Use.
+func (s *Server) loadPlugins() error {
+	for _, path := range s.config.Plugins {
+		p, err := LoadPlugin(path)
+		if err != nil {
+			return err
+		}
+		logger.Infof("Loaded plugin %s\n", path)
+		s.Use(p)
+	}
+
+	return nil
+}
+
+// pluginQuery runs the query in msg, read into reqBuffer, through the
+// plugins. A query they modify is written back to reqBuffer and returned as
+// the new msg, a query they answer gets its response. The parsed query is
+// returned for pluginResponse, nil without plugins or when it doesn't parse.
+func (s *Server) pluginQuery(ctx context.Context, reqBuffer *buffer.BytePacketBuffer, msg []byte, addr net.Addr) (*dns.DNSPacket, []byte, []byte) {
+	if len(s.plugins) == 0 {
+		return nil, msg, nil
+	}
+
+	lazy, err := dns.ParseLazy(msg)
+	if err != nil {
+		return nil, msg, nil
+	}
+	query, err := lazy.Packet()
+	if err != nil {
+		return nil, msg, nil
+	}
+
+	for _, p := range s.plugins {
+		if response := p.Query(ctx, addr, query); response != nil {
+			response.Header.ID = query.Header.ID
+			response.Header.Response = true
+			return query, msg, s.pluginResponse(ctx, addr, query, encodePacket(response))
+		}
+	}
+
+	data := encodePacket(query)
+	if data == nil {
+		return query, msg, nil
+	}
+	reqBuffer.Reset()
+	reqBuffer.SetSize(len(data))
+	copy(reqBuffer.Buf, data)
+
+	return query, reqBuffer.Buf, nil
+}
+
+// pluginResponse runs the response in data to query through the plugins and
+// returns it as they left it. Dropped queries stay dropped.
+func (s *Server) pluginResponse(ctx context.Context, addr net.Addr, query *dns.DNSPacket, data []byte) []byte {
+	if query == nil || data == nil {
+		return data
+	}
+
+	lazy, err := dns.ParseLazy(data)
+	if err != nil {
+		return data
+	}
+	response, err := lazy.Packet()
+	if err != nil {
+		return data
+	}
+
+	for _, p := range s.plugins {
+		p.Response(ctx, addr, query, response)
+	}
+
+	if modified := encodePacket(response); modified != nil {
+		return modified
+	}
+	return data
+}
+
+// encodePacket returns the wire form of packet, nil when it can't be
+// written.
+func encodePacket(packet *dns.DNSPacket) []byte {
+	packetBuffer := buffer.NewBytePacketBuffer()
+	packetBuffer.Buf = make([]byte, dns.MaxMessageSize)
+	if err := packet.Write(packetBuffer); err != nil {
+		logger.Errorf("Error: writing packet: %s\n", err)
+		return nil
+	}
+
+	data, err := packetBuffer.GetRangeAtPos()
+	if err != nil {
+		logger.Errorf("Error: writing packet: %s\n", err)
+		return nil
+	}
+
+	return data
+}
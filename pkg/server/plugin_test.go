@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/dnstest"
+)
+
+// testPlugin answers queries for plugin.test itself, sends queries for
+// alias.example.com to www.example.com and caps the TTL of answers.
+type testPlugin struct {
+	responses int
+}
+
+func (p *testPlugin) Query(ctx context.Context, client net.Addr, query *dns.DNSPacket) *dns.DNSPacket {
+	switch query.Questions[0].Name.String() {
+	case "plugin.test":
+		response := dns.NewDNSPacket()
+		response.Questions = query.Questions
+		record, _ := dns.ParseRecord("plugin.test. 60 IN A 192.0.2.99", 0)
+		response.Answers = append(response.Answers, record)
+		return response
+	case "alias.example.com":
+		query.Questions[0] = dns.NewDNSQuestion("www.example.com", query.Questions[0].QType)
+	}
+
+	return nil
+}
+
+func (p *testPlugin) Response(ctx context.Context, client net.Addr, query, response *dns.DNSPacket) {
+	p.responses++
+	for _, record := range response.Answers {
+		if record.TTL > 30 {
+			record.TTL = 30
+		}
+	}
+}
+
+func TestPlugins(t *testing.T) {
+	ns := dnstest.NewServer(t, map[string]string{"example.com": `
+@	IN SOA	ns.example.com. hostmaster.example.com. 1 7200 3600 1209600 300
+@	IN NS	ns.example.com.
+www	IN A	192.0.2.1
+`})
+
+	cfg := DefaultConfig()
+	NoError(t, cfg.Forwarders.Set(ns.Addr.String()))
+	s := NewServer(cfg)
+	p := &testPlugin{}
+	s.Use(p)
+
+	answer := func(name string) *dns.DNSPacket {
+		request := dns.NewDNSPacket()
+		request.Header.ID = 4660
+		request.Header.RecursionDesired = true
+		request.Questions = append(request.Questions, dns.NewDNSQuestion(name, dns.AQueryType))
+		reqBuffer := buffer.NewBytePacketBuffer()
+		NoError(t, request.Write(reqBuffer))
+		msg := append([]byte(nil), reqBuffer.Buf[:reqBuffer.Pos()]...)
+		reqBuffer.SetSize(len(msg))
+		reqBuffer.Seek(0)
+
+		data := s.answer(context.Background(), reqBuffer, buffer.NewBytePacketBuffer(), msg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353})
+		lazy, err := dns.ParseLazy(data)
+		if !NoError(t, err) {
+			t.FailNow()
+		}
+		response, err := lazy.Packet()
+		if !NoError(t, err) {
+			t.FailNow()
+		}
+		Equal(t, uint16(4660), response.Header.ID)
+		True(t, response.Header.Response)
+		return response
+	}
+
+	t.Run("responses_are_modified", func(t *testing.T) {
+		response := answer("www.example.com")
+		if Len(t, response.Answers, 1) {
+			Equal(t, "192.0.2.1", response.Answers[0].Addr.String())
+			Equal(t, uint32(30), response.Answers[0].TTL)
+		}
+		Equal(t, 1, p.responses)
+	})
+
+	t.Run("queries_are_modified", func(t *testing.T) {
+		response := answer("alias.example.com")
+		if Len(t, response.Answers, 1) {
+			Equal(t, "www.example.com", response.Answers[0].Domain.String())
+			Equal(t, "192.0.2.1", response.Answers[0].Addr.String())
+		}
+	})
+
+	t.Run("queries_are_answered", func(t *testing.T) {
+		before := len(ns.Queries())
+		response := answer("plugin.test")
+		if Len(t, response.Answers, 1) {
+			Equal(t, "192.0.2.99", response.Answers[0].Addr.String())
+			Equal(t, uint32(30), response.Answers[0].TTL)
+		}
+		Equal(t, before, len(ns.Queries()))
+	})
+
+	t.Run("loading", func(t *testing.T) {
+		var ps PluginFiles
+		NoError(t, ps.Set("/usr/lib/godns/a.so"))
+		NoError(t, ps.Set("b.so"))
+		Equal(t, "/usr/lib/godns/a.so,b.so", ps.String())
+		Error(t, ps.Set(""))
+
+		_, err := LoadPlugin(filepath.Join(t.TempDir(), "missing.so"))
+		Error(t, err)
+
+		cfg := DefaultConfig()
+		cfg.Plugins = PluginFiles{filepath.Join(t.TempDir(), "missing.so")}
+		Error(t, NewServer(cfg).loadPlugins())
+	})
+}
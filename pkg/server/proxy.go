@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/logger"
+)
+
+// relay answers the query in msg in proxy mode, the message goes to the
+// forwarders of the client's view unmodified and their answer comes back as
+// is. Queries that are blocked, rewritten, sent to safe search, hit a rule
+// or policy or are answered by a local zone or from the hosts, leases,
+// containers or registry aren't relayed and false is returned so that
+// handleQuery answers them, like AAAA queries when DNS64 is enabled.
+func (s *Server) relay(ctx context.Context, msg []byte, addr net.Addr) ([]byte, bool) {
+	if !s.config.Proxy {
+		return nil, false
+	}
+
+	request, err := dns.ParseLazy(msg)
+	if err != nil || request.Header.Response || request.Header.Opcode != dns.OpcodeQuery || len(request.Questions) != 1 {
+		return nil, false
+	}
+
+	q := request.Questions[0]
+	if otherClass(q) {
+		return nil, false
+	}
+	v := s.viewFor(addrIP(addr))
+	if s.blocked(v, addrIP(addr), q.Name.String()) {
+		return nil, false
+	}
+
+	// Rules, rewrites, policies and zones only look at the question
+	question := dns.NewDNSPacket()
+	question.Questions = request.Questions
+	if rule := s.matchRule(addrIP(addr), question); rule != nil && rule.Action != RuleAllow {
+		return nil, false
+	}
+	if s.rewriteName(question) != nil || s.safeSearch(addrIP(addr), question) != nil {
+		return nil, false
+	}
+	if s.matchPolicy(question) != nil || v.answerLocally(question) != nil {
+		return nil, false
+	}
+	// DNS64 synthesizes AAAA records from the decoded answer
+	if s.config.DNS64 != nil && q.QType == dns.AAAAQueryType {
+		return nil, false
+	}
+	// Names of hosts, leases, containers and the registry are ours to answer
+	if _, ok := s.lookupHosts(question); ok {
+		return nil, false
+	}
+
+	atomic.AddUint64(&s.stats.queries, 1)
+	logger.Infof("Relaying query: %s\n", q)
+
+	response, err := v.resolver.Relay(ctx, msg)
+	if err != nil {
+		logger.Errorf("Error: %s\n", err)
+		atomic.AddUint64(&s.stats.failures, 1)
+		return s.errorFor(msg, dns.ServFail), true
+	}
+
+	return response, true
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"net"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/logger"
+)
+
+// answer answers the query in msg, read into reqBuffer, relaying it in proxy
+// mode. The work stops when ctx is cancelled or the budget of the client's
+// transport is spent, clients have given up by then. A panic while answering
+// is logged and the client gets SERVFAIL, the listener keeps serving
+// everyone else.
+func (s *Server) answer(ctx context.Context, reqBuffer *buffer.BytePacketBuffer, resBuffer *buffer.BytePacketBuffer, msg []byte, addr net.Addr) (data []byte) {
+	if budget := s.queryBudget(addr); budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, budget)
+		defer cancel()
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			atomic.AddUint64(&s.stats.panics, 1)
+			logger.Errorf("Error: panic answering query from %s: %v\n%s", addr, r, debug.Stack())
+			data = s.errorFor(msg, dns.ServFail)
+		}
+	}()
+
+	ctx = s.startQuery(ctx, msg, addr)
+	query, msg, data := s.pluginQuery(ctx, reqBuffer, msg, addr)
+	if data == nil {
+		var relayed bool
+		data, relayed = s.relay(ctx, msg, addr)
+		if !relayed {
+			data = s.handleQuery(ctx, reqBuffer, resBuffer, addr)
+		}
+		data = s.pluginResponse(ctx, addr, query, data)
+	}
+	s.finishQuery(ctx, data)
+
+	return data
+}
+
+// queryBudget returns how long answering a query from addr may take.
+func (s *Server) queryBudget(addr net.Addr) time.Duration {
+	if _, ok := addr.(*net.TCPAddr); ok {
+		return s.config.TCPQueryTimeout
+	}
+
+	return s.config.QueryTimeout
+}
+
+// errorFor builds a response with rcode to the query in msg from as much of
+// it as can be read, the question is echoed when it parses. Messages too
+// short to have an id and responses get nil, they are dropped rather than
+// answered. Recursion is available as configured, like in other responses.
+func (s *Server) errorFor(msg []byte, rcode dns.ResultCode) []byte {
+	request, err := dns.ParseLazy(msg)
+	if err != nil {
+		header := dns.NewDNSHeader()
+		packetBuffer := buffer.NewBytePacketBuffer()
+		packetBuffer.Buf = msg
+		if header.Read(packetBuffer) != nil {
+			return nil
+		}
+		request = &dns.LazyPacket{Header: header}
+	}
+	if request.Header.Response {
+		return nil
+	}
+
+	packet := dns.NewDNSPacket()
+	packet.Header.ID = request.Header.ID
+	packet.Header.Opcode = request.Header.Opcode
+	packet.Header.RecursionDesired = request.Header.RecursionDesired
+	packet.Header.CheckingDisabled = request.Header.CheckingDisabled
+	packet.Header.RecursionAvailable = s.config.Recursion
+	packet.Header.Response = true
+	packet.Header.ResCode = rcode
+	packet.Questions = request.Questions
+
+	resBuffer := buffer.NewBytePacketBuffer()
+	if err := packet.Write(resBuffer); err != nil {
+		logger.Errorf("Error: generating dns response packet: %s\n", err)
+		return nil
+	}
+
+	data, err := resBuffer.GetRangeAtPos()
+	if err != nil {
+		logger.Errorf("Error: generating dns response packet: %s\n", err)
+		return nil
+	}
+
+	return data
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+func TestRecover(t *testing.T) {
+	request := dns.NewDNSPacket()
+	request.Header.ID = 4660
+	request.Header.RecursionDesired = true
+	request.Questions = append(request.Questions, dns.NewDNSQuestion("www.example.com", dns.AQueryType))
+	reqBuffer := buffer.NewBytePacketBuffer()
+	NoError(t, request.Write(reqBuffer))
+	msg := reqBuffer.Buf[:reqBuffer.Pos()]
+
+	parse := func(data []byte) *dns.DNSPacket {
+		lazy, err := dns.ParseLazy(data)
+		NoError(t, err)
+		packet, err := lazy.Packet()
+		NoError(t, err)
+		return packet
+	}
+
+	t.Run("panics_answer_servfail", func(t *testing.T) {
+		// A server that wasn't set up panics on every query
+		s := &Server{config: DefaultConfig()}
+		reqBuffer.Seek(0)
+
+		data := s.answer(context.Background(), reqBuffer, buffer.NewBytePacketBuffer(), msg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353})
+		if NotNil(t, data) {
+			response := parse(data)
+			Equal(t, uint16(4660), response.Header.ID)
+			Equal(t, dns.ServFail, response.Header.ResCode)
+			True(t, response.Header.Response)
+			True(t, response.Header.RecursionDesired)
+			if Len(t, response.Questions, 1) {
+				Equal(t, "www.example.com", response.Questions[0].Name.String())
+			}
+		}
+		Equal(t, uint64(1), atomic.LoadUint64(&s.stats.panics))
+	})
+
+	t.Run("malformed_queries_get_formerr", func(t *testing.T) {
+		s := NewServer(DefaultConfig())
+
+		// The question is cut off in the middle of the name
+		malformed := buffer.NewBytePacketBuffer()
+		copy(malformed.Buf, msg[:20])
+		malformed.SetSize(20)
+
+		data := s.handleQuery(context.Background(), malformed, buffer.NewBytePacketBuffer(), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353})
+		if NotNil(t, data) {
+			response := parse(data)
+			Equal(t, uint16(4660), response.Header.ID)
+			Equal(t, dns.FormErr, response.Header.ResCode)
+			True(t, response.Header.Response)
+		}
+	})
+
+	t.Run("error_responses_from_partial_messages", func(t *testing.T) {
+		s := NewServer(DefaultConfig())
+
+		// The question is cut off, the header is still answered
+		response := parse(s.errorFor(msg[:14], dns.FormErr))
+		Equal(t, uint16(4660), response.Header.ID)
+		Equal(t, dns.FormErr, response.Header.ResCode)
+		True(t, response.Header.RecursionAvailable)
+		Empty(t, response.Questions)
+
+		Nil(t, s.errorFor(msg[:5], dns.FormErr))
+
+		answered := append([]byte(nil), msg...)
+		answered[2] |= 0x80
+		Nil(t, s.errorFor(answered, dns.FormErr))
+	})
+
+	t.Run("error_responses_follow_recursion", func(t *testing.T) {
+		cfg := DefaultConfig()
+		cfg.Recursion = false
+		s := NewServer(cfg)
+
+		response := parse(s.errorFor(msg, dns.ServFail))
+		Equal(t, dns.ServFail, response.Header.ResCode)
+		False(t, response.Header.RecursionAvailable)
+	})
+}
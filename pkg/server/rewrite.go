@@ -0,0 +1,173 @@
+package server
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/logger"
+	"github.com/pkg/errors"
+)
+
+// RewriteKind is how a rewrite matches names.
+type RewriteKind int
+
+const (
+	// RewriteExact maps a single name
+	RewriteExact RewriteKind = iota
+	// RewriteSuffix maps a name and every name below it, keeping the labels
+	// in front of the suffix
+	RewriteSuffix
+	// RewriteRegex maps names matching a regular expression, the target may
+	// refer to submatches as $1
+	RewriteRegex
+)
+
+func (k RewriteKind) String() string {
+	switch k {
+	case RewriteSuffix:
+		return "suffix"
+	case RewriteRegex:
+		return "regex"
+	default:
+		return "exact"
+	}
+}
+
+// NameRewrite resolves names as other names, the client gets the answer
+// under the name it asked for.
+type NameRewrite struct {
+	Kind RewriteKind
+	From string
+	To   string
+
+	re *regexp.Regexp
+}
+
+// ParseNameRewrite parses a rewrite given as "KIND:FROM=TO", e.g.
+// "suffix:corp.example=corp.staging.example" or
+// `regex:^(.+)\.old\.example$=$1.new.example`.
+func ParseNameRewrite(value string) (*NameRewrite, error) {
+	i := strings.Index(value, ":")
+	j := strings.Index(value, "=")
+	if i < 0 || j < i {
+		return nil, errors.Errorf("rewrite %q is not KIND:FROM=TO", value)
+	}
+
+	r := &NameRewrite{
+		From: strings.TrimSuffix(value[i+1:j], "."),
+		To:   strings.TrimSuffix(value[j+1:], "."),
+	}
+	if r.From == "" || r.To == "" {
+		return nil, errors.Errorf("rewrite %q is not KIND:FROM=TO", value)
+	}
+
+	switch kind := value[:i]; kind {
+	case "exact":
+		r.Kind = RewriteExact
+	case "suffix":
+		r.Kind = RewriteSuffix
+	case "regex":
+		r.Kind = RewriteRegex
+		re, err := regexp.Compile("(?i)" + r.From)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing rewrite %q", value)
+		}
+		r.re = re
+	default:
+		return nil, errors.Errorf("unknown rewrite kind %q", kind)
+	}
+
+	return r, nil
+}
+
+func (r *NameRewrite) String() string {
+	return r.Kind.String() + ":" + r.From + "=" + r.To
+}
+
+// Rewrite returns the name name is resolved as, false when the rewrite
+// doesn't apply to it.
+func (r *NameRewrite) Rewrite(name string) (string, bool) {
+	switch r.Kind {
+	case RewriteSuffix:
+		if !buffer.NewDomainName(name).IsSubdomainOf(buffer.NewDomainName(r.From)) {
+			return "", false
+		}
+		return name[:len(name)-len(r.From)] + r.To, true
+	case RewriteRegex:
+		if !r.re.MatchString(name) {
+			return "", false
+		}
+		return r.re.ReplaceAllString(name, r.To), true
+	default:
+		if !strings.EqualFold(name, r.From) {
+			return "", false
+		}
+		return r.To, true
+	}
+}
+
+// NameRewrites implements flag.Value, every use of the flag adds a rewrite.
+type NameRewrites []*NameRewrite
+
+func (rs *NameRewrites) String() string {
+	rewrites := make([]string, 0, len(*rs))
+	for _, r := range *rs {
+		rewrites = append(rewrites, r.String())
+	}
+
+	return strings.Join(rewrites, ",")
+}
+
+func (rs *NameRewrites) Set(value string) error {
+	r, err := ParseNameRewrite(value)
+	if err != nil {
+		return err
+	}
+
+	*rs = append(*rs, r)
+	return nil
+}
+
+// rewriteName returns the name the question is resolved as by the first
+// matching rewrite, nil when no rewrite applies.
+func (s *Server) rewriteName(request *dns.DNSPacket) *buffer.DomainName {
+	if len(request.Questions) != 1 {
+		return nil
+	}
+
+	name := request.Questions[0].Name.String()
+	for _, r := range s.config.Rewrites {
+		if rewritten, ok := r.Rewrite(name); ok {
+			logger.Debugf("Rewriting %s to %s\n", name, rewritten)
+			return buffer.NewDomainName(rewritten)
+		}
+	}
+
+	return nil
+}
+
+// restoreName puts the name the client asked for back into the response,
+// in the question and in place of the rewritten name of the records owned by
+// it. Records are copied as they may be shared with the cache.
+func restoreName(packet *dns.DNSPacket, original *buffer.DomainName, rewritten *buffer.DomainName) {
+	for _, q := range packet.Questions {
+		if q.Name.Equal(rewritten) {
+			q.Name = original
+		}
+	}
+
+	for _, records := range []*[]*dns.DNSRecord{&packet.Answers, &packet.Authorities} {
+		restored := make([]*dns.DNSRecord, 0, len(*records))
+		for _, record := range *records {
+			if record.Domain.Equal(rewritten) {
+				r := *record
+				r.Domain = original
+				record = &r
+			}
+			restored = append(restored, record)
+		}
+		*records = restored
+	}
+}
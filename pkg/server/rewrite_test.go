@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+func TestNameRewrite(t *testing.T) {
+	tests := []struct {
+		rewrite   string
+		name      string
+		rewritten string
+		ok        bool
+	}{
+		{"exact:www.example.com=www.staging.example.com", "www.example.com", "www.staging.example.com", true},
+		{"exact:www.example.com.=www.staging.example.com.", "WWW.example.com", "www.staging.example.com", true},
+		{"exact:www.example.com=www.staging.example.com", "api.example.com", "", false},
+		{"suffix:example.com=staging.example.net", "api.v1.example.com", "api.v1.staging.example.net", true},
+		{"suffix:example.com=staging.example.net", "example.com", "staging.example.net", true},
+		{"suffix:example.com=staging.example.net", "notexample.com", "", false},
+		{`regex:^(.+)\.old\.example$=$1.new.example`, "db.old.example", "db.new.example", true},
+		{`regex:^(.+)\.old\.example$=$1.new.example`, "old.example", "", false},
+	}
+
+	for _, test := range tests {
+		r, err := ParseNameRewrite(test.rewrite)
+		if !NoError(t, err, test.rewrite) {
+			continue
+		}
+
+		rewritten, ok := r.Rewrite(test.name)
+		Equal(t, test.ok, ok, test.name)
+		Equal(t, test.rewritten, rewritten, test.name)
+	}
+
+	for _, invalid := range []string{"www.example.com=www.example.net", "exact:www.example.com", "glob:*.example.com=example.net", "regex:(=example.net", "exact:=example.net"} {
+		_, err := ParseNameRewrite(invalid)
+		Error(t, err, invalid)
+	}
+}
+
+func TestRewrittenQueries(t *testing.T) {
+	zoneFile := filepath.Join(t.TempDir(), "staging.zone")
+	NoError(t, ioutil.WriteFile(zoneFile, []byte("@ SOA ns.staging.example. admin.staging.example. 1 2 3 4 5\nwww A 10.0.0.1\nalias CNAME www.staging.example.\n"), 0644))
+
+	cfg := DefaultConfig()
+	NoError(t, cfg.Zones.Set("staging.example="+zoneFile))
+	NoError(t, cfg.Rewrites.Set("suffix:corp.example=staging.example"))
+	s := NewServer(cfg)
+	_, err := s.loadZones(context.Background())
+	NoError(t, err)
+
+	query := func(name string) *dns.DNSPacket {
+		request := dns.NewDNSPacket()
+		request.Header.ID = 4660
+		request.Questions = append(request.Questions, dns.NewDNSQuestion(name, dns.AQueryType))
+		return exchange(t, s, request)
+	}
+
+	t.Run("answer_under_original_name", func(t *testing.T) {
+		response := query("www.corp.example")
+		Equal(t, dns.NoError, response.Header.ResCode)
+		if Len(t, response.Questions, 1) {
+			Equal(t, "www.corp.example", response.Questions[0].Name.String())
+		}
+		if Len(t, response.Answers, 1) {
+			Equal(t, "www.corp.example", response.Answers[0].Domain.String())
+			Equal(t, "10.0.0.1", response.Answers[0].Addr.String())
+		}
+	})
+
+	t.Run("chain_keeps_target_names", func(t *testing.T) {
+		response := query("alias.corp.example")
+		if Len(t, response.Answers, 2) {
+			Equal(t, "alias.corp.example", response.Answers[0].Domain.String())
+			Equal(t, "www.staging.example", response.Answers[0].Host.String())
+			Equal(t, "www.staging.example", response.Answers[1].Domain.String())
+		}
+	})
+
+	t.Run("zone_records_unchanged", func(t *testing.T) {
+		query("www.corp.example")
+		response := query("www.staging.example")
+		if Len(t, response.Answers, 1) {
+			Equal(t, "www.staging.example", response.Answers[0].Domain.String())
+		}
+	})
+}
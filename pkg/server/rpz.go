@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/logger"
+	"github.com/msarvar/godns/pkg/rpz"
+)
+
+// matchPolicy returns the response policy rule applying to the query or nil.
+func (s *Server) matchPolicy(request *dns.DNSPacket) *rpz.Hit {
+	if s.policy == nil || len(request.Questions) != 1 {
+		return nil
+	}
+
+	return s.policy.Match(request.Questions[0].Name.String())
+}
+
+func logPolicyHit(q *dns.DNSQuestion, hit *rpz.Hit) {
+	logger.Infof("Policy %s of %s matched %s via %s\n", hit.Action, hit.Zone, q, hit.Trigger)
+}
+
+// applyPolicy answers the query as the rule demands instead of resolving it.
+// A local CNAME is followed so that clients get the addresses they asked for.
+func (s *Server) applyPolicy(ctx context.Context, v *view, packet *dns.DNSPacket, q *dns.DNSQuestion, hit *rpz.Hit) *dns.ExtendedError {
+	logPolicyHit(q, hit)
+	if hit.Action == rpz.ActionNXDomain || hit.Action == rpz.ActionNoData {
+		s.block(ctx, "policy", hit.Zone+" "+hit.Trigger)
+	}
+
+	pq := *q
+	packet.Questions = append(packet.Questions, &pq)
+
+	if hit.Action == rpz.ActionNXDomain {
+		packet.Header.ResCode = dns.NxDomain
+	}
+
+	if hit.Action == rpz.ActionLocalData {
+		packet.Answers = hit.Records(q.Name.String(), q.QType)
+	}
+
+	if len(packet.Answers) == 0 {
+		if hit.SOA != nil {
+			packet.Authorities = append(packet.Authorities, hit.SOA)
+		}
+		return &dns.ExtendedError{Code: dns.EDEBlocked}
+	}
+
+	last := packet.Answers[len(packet.Answers)-1]
+	if last.QType == dns.CNAMEQueryType && q.QType != dns.CNAMEQueryType {
+		result, err := v.resolver.Resolve(ctx, last.Host.String(), q.QType)
+		if err == nil {
+			packet.Answers = append(packet.Answers, result.Answers...)
+		}
+	}
+
+	return &dns.ExtendedError{Code: dns.EDEForgedAnswer}
+}
+
+// loadPolicy (re)loads the response policy zones and logs their sizes.
+func (s *Server) loadPolicy(ctx context.Context) error {
+	err := s.policy.Load(ctx)
+	for _, z := range s.policy.Zones() {
+		logger.Infof("Loaded %d rules from policy zone %s, skipped %d records\n", z.Len(), z.Name, z.Skipped)
+	}
+
+	return err
+}
@@ -0,0 +1,261 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/pkg/errors"
+)
+
+// defaultRuleTTL is the TTL of rewrite answers that don't give one.
+const defaultRuleTTL = 60
+
+// RuleAction is what a query rule does with the queries it matches.
+type RuleAction int
+
+const (
+	// RuleAllow answers the query as if no rule matched
+	RuleAllow RuleAction = iota
+	// RuleRefuse answers REFUSED
+	RuleRefuse
+	// RuleDrop doesn't answer at all
+	RuleDrop
+	// RuleRewrite answers with the records of the rule
+	RuleRewrite
+	// RuleNXDomain answers that the name doesn't exist, like blocklists
+	RuleNXDomain
+)
+
+func (a RuleAction) String() string {
+	switch a {
+	case RuleRefuse:
+		return "refuse"
+	case RuleDrop:
+		return "drop"
+	case RuleRewrite:
+		return "rewrite"
+	case RuleNXDomain:
+		return "nxdomain"
+	default:
+		return "allow"
+	}
+}
+
+// ParseRuleAction parses the name of an action as printed by String.
+func ParseRuleAction(s string) (RuleAction, error) {
+	for _, a := range []RuleAction{RuleAllow, RuleRefuse, RuleDrop, RuleRewrite, RuleNXDomain} {
+		if a.String() == s {
+			return a, nil
+		}
+	}
+
+	return RuleAllow, errors.Errorf("unknown rule action %q", s)
+}
+
+// QueryRule decides what happens to the queries of some clients for some
+// names and types. Empty conditions match every query.
+type QueryRule struct {
+	Clients []*net.IPNet
+	// Names match themselves and every name below them
+	Names []*buffer.DomainName
+	Types []dns.QueryType
+	// When must also hold for the query, it is checked after the other
+	// conditions
+	When *Expr
+
+	Action RuleAction
+	// Answers of a rewrite rule, their owner is replaced by the queried name
+	Answers []*dns.DNSRecord
+}
+
+// Matches reports whether the rule applies to the question of client ip.
+func (r *QueryRule) Matches(ip net.IP, q *dns.DNSQuestion) bool {
+	if len(r.Clients) > 0 && !containsIP(r.Clients, ip) {
+		return false
+	}
+
+	if len(r.Names) > 0 {
+		matched := false
+		for _, name := range r.Names {
+			if q.Name.IsSubdomainOf(name) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if len(r.Types) > 0 {
+		for _, t := range r.Types {
+			if t == q.QType {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// answer returns the rewrite answers of the rule for the question, those of
+// the queried type or a CNAME.
+func (r *QueryRule) answer(q *dns.DNSQuestion) []*dns.DNSRecord {
+	answers := make([]*dns.DNSRecord, 0, len(r.Answers))
+	for _, a := range r.Answers {
+		if a.QType != q.QType && a.QType != dns.CNAMEQueryType && q.QType != dns.ANYQueryType {
+			continue
+		}
+
+		record := *a
+		record.Domain = buffer.NewDomainName(q.Name.String())
+		answers = append(answers, &record)
+	}
+
+	return answers
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// queryRuleFile is the JSON form of a query rule:
+//
+//	{"clients": ["10.9.0.0/16"], "names": ["example.com"],
+//	 "types": ["TXT"], "action": "refuse"}
+//
+// Conditions the fields can't express are written as an Expr, e.g.
+// {"when": "qname endswith \".ads\" and group == \"kids\"", "action": "nxdomain"}.
+//
+// Rewrite rules list their answers as record data with an optional TTL,
+// e.g. "answers": ["300 A 192.0.2.80"].
+type queryRuleFile struct {
+	Clients []string `json:"clients"`
+	Names   []string `json:"names"`
+	Types   []string `json:"types"`
+	When    string   `json:"when"`
+	Action  string   `json:"action"`
+	Answers []string `json:"answers"`
+}
+
+// LoadQueryRules reads a JSON list of query rules, a query is handled by the
+// first rule matching it.
+func LoadQueryRules(path string) ([]*QueryRule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading query rules")
+	}
+
+	var files []queryRuleFile
+	if err := json.Unmarshal(data, &files); err != nil {
+		return nil, errors.Wrap(err, "parsing query rules")
+	}
+
+	rules := make([]*QueryRule, 0, len(files))
+	for i, f := range files {
+		rule, err := f.rule()
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing query rule %d", i+1)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+func (f *queryRuleFile) rule() (*QueryRule, error) {
+	action, err := ParseRuleAction(f.Action)
+	if err != nil {
+		return nil, err
+	}
+	rule := &QueryRule{Action: action}
+
+	for _, c := range f.Clients {
+		_, network, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing clients")
+		}
+		rule.Clients = append(rule.Clients, network)
+	}
+
+	for _, name := range f.Names {
+		rule.Names = append(rule.Names, buffer.NewDomainName(name))
+	}
+
+	for _, t := range f.Types {
+		qtype, err := dns.ParseQueryType(t)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing types")
+		}
+		rule.Types = append(rule.Types, qtype)
+	}
+
+	if f.When != "" {
+		if rule.When, err = ParseExpr(f.When); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, a := range f.Answers {
+		record, err := dns.ParseRecord(". "+a, defaultRuleTTL)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing answers")
+		}
+		rule.Answers = append(rule.Answers, record)
+	}
+
+	if action == RuleRewrite && len(rule.Answers) == 0 {
+		return nil, errors.New("rewrite rule has no answers")
+	}
+
+	return rule, nil
+}
+
+// matchRule returns the first query rule matching the request, nil when none
+// does.
+func (s *Server) matchRule(ip net.IP, request *dns.DNSPacket) *QueryRule {
+	if len(request.Questions) != 1 {
+		return nil
+	}
+
+	q := request.Questions[0]
+	var env *ExprEnv
+	for _, rule := range s.config.QueryRules {
+		if !rule.Matches(ip, q) {
+			continue
+		}
+		if rule.When != nil {
+			// The group and view are only looked up for expressions
+			if env == nil {
+				env = s.exprEnv(ip, q)
+			}
+			if !rule.When.Matches(env) {
+				continue
+			}
+		}
+		return rule
+	}
+
+	return nil
+}
+
+// exprEnv returns the variables of expressions for the question of client
+// ip.
+func (s *Server) exprEnv(ip net.IP, q *dns.DNSQuestion) *ExprEnv {
+	env := &ExprEnv{Name: q.Name.String(), Type: q.QType, Client: ip, View: s.viewFor(ip).name}
+	if g := s.groupFor(ip); g != nil {
+		env.Group = g.name
+	}
+
+	return env
+}
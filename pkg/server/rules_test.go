@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+func TestQueryRules(t *testing.T) {
+	dir := t.TempDir()
+	zoneFile := filepath.Join(dir, "corp.zone")
+	NoError(t, ioutil.WriteFile(zoneFile, []byte("@ SOA ns.corp.example. admin.corp.example. 1 2 3 4 5\nwww A 10.0.0.1\nwww MX 10 mail.corp.example.\n"), 0644))
+
+	rules := filepath.Join(dir, "rules.json")
+	NoError(t, ioutil.WriteFile(rules, []byte(`[
+		{"clients": ["10.0.0.0/8"], "types": ["ANY", "AXFR"], "action": "allow"},
+		{"types": ["ANY", "AXFR"], "action": "refuse"},
+		{"clients": ["192.168.0.0/16"], "types": ["MX"], "action": "drop"},
+		{"names": ["portal.corp.example"], "action": "rewrite", "answers": ["300 A 10.0.0.80"]},
+		{"when": "qname endswith \".ads\" and group == \"kids\"", "action": "nxdomain"}
+	]`), 0644))
+
+	t.Run("load_query_rules", func(t *testing.T) {
+		loaded, err := LoadQueryRules(rules)
+		NoError(t, err)
+		if Len(t, loaded, 5) {
+			Equal(t, RuleAllow, loaded[0].Action)
+			Equal(t, []dns.QueryType{dns.ANYQueryType, dns.AXFRQueryType}, loaded[1].Types)
+			if Len(t, loaded[3].Answers, 1) {
+				Equal(t, uint32(300), loaded[3].Answers[0].TTL)
+			}
+		}
+
+		invalid := filepath.Join(dir, "invalid.json")
+		for _, content := range []string{
+			`[{"action": "deny"}]`,
+			`[{"clients": ["10.0.0.0"], "action": "refuse"}]`,
+			`[{"types": ["BOGUS"], "action": "refuse"}]`,
+			`[{"action": "rewrite"}]`,
+			`[{"action": "rewrite", "answers": ["A not-an-address"]}]`,
+			`[{"when": "qname endswith", "action": "nxdomain"}]`,
+			`{}`,
+		} {
+			NoError(t, ioutil.WriteFile(invalid, []byte(content), 0644))
+			_, err := LoadQueryRules(invalid)
+			Error(t, err, content)
+		}
+	})
+
+	loaded, err := LoadQueryRules(rules)
+	NoError(t, err)
+	cfg := DefaultConfig()
+	cfg.QueryRules = loaded
+	_, kids, _ := net.ParseCIDR("172.16.0.0/12")
+	cfg.ClientGroups = []*ClientGroup{{Name: "kids", Clients: []*net.IPNet{kids}}}
+	NoError(t, cfg.Zones.Set("corp.example="+zoneFile))
+	s := NewServer(cfg)
+	_, err = s.loadZones(context.Background())
+	NoError(t, err)
+
+	query := func(client string, name string, qtype dns.QueryType) *dns.DNSPacket {
+		request := dns.NewDNSPacket()
+		request.Header.ID = 4660
+		request.Questions = append(request.Questions, dns.NewDNSQuestion(name, qtype))
+		reqBuffer := buffer.NewBytePacketBuffer()
+		NoError(t, request.Write(reqBuffer))
+		reqBuffer.Seek(0)
+
+		resBuffer := buffer.NewBytePacketBuffer()
+		data := s.handleQuery(context.Background(), reqBuffer, resBuffer, &net.UDPAddr{IP: net.ParseIP(client), Port: 5353})
+		if data == nil {
+			return nil
+		}
+
+		resBuffer.Seek(0)
+		response, err := dns.DNSPacketFromBuffer(resBuffer)
+		NoError(t, err)
+		return response
+	}
+
+	t.Run("refuse", func(t *testing.T) {
+		response := query("192.0.2.1", "www.corp.example", dns.ANYQueryType)
+		Equal(t, dns.Refused, response.Header.ResCode)
+		Empty(t, response.Answers)
+
+		response = query("192.0.2.1", "corp.example", dns.AXFRQueryType)
+		Equal(t, dns.Refused, response.Header.ResCode)
+	})
+
+	t.Run("allow_before_refuse", func(t *testing.T) {
+		response := query("10.1.2.3", "www.corp.example", dns.ANYQueryType)
+		Equal(t, dns.NoError, response.Header.ResCode)
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		Nil(t, query("192.168.1.10", "www.corp.example", dns.MXQueryType))
+
+		response := query("192.0.2.1", "www.corp.example", dns.MXQueryType)
+		Len(t, response.Answers, 1, "other clients are answered")
+	})
+
+	t.Run("rewrite", func(t *testing.T) {
+		response := query("192.0.2.1", "portal.corp.example", dns.AQueryType)
+		Equal(t, dns.NoError, response.Header.ResCode)
+		if Len(t, response.Answers, 1) {
+			Equal(t, "portal.corp.example", response.Answers[0].Domain.String())
+			Equal(t, "10.0.0.80", response.Answers[0].Addr.String())
+		}
+
+		response = query("192.0.2.1", "portal.corp.example", dns.AAAAQueryType)
+		Empty(t, response.Answers)
+	})
+
+	t.Run("expression", func(t *testing.T) {
+		response := query("172.16.0.5", "tracker.ads", dns.AQueryType)
+		Equal(t, dns.NxDomain, response.Header.ResCode)
+
+		response = query("192.0.2.1", "tracker.ads", dns.AQueryType)
+		NotEqual(t, dns.NxDomain, response.Header.ResCode)
+	})
+
+	t.Run("no_match", func(t *testing.T) {
+		response := query("192.0.2.1", "www.corp.example", dns.AQueryType)
+		if Len(t, response.Answers, 1) {
+			Equal(t, "10.0.0.1", response.Answers[0].Addr.String())
+		}
+	})
+}
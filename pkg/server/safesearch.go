@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/logger"
+)
+
+// safeSearchTTL is the TTL of the CNAME sending search engines to their safe
+// search endpoints.
+const safeSearchTTL = 300
+
+// safeSearchNames maps the names of search engines to the endpoints that
+// enforce their safe search for everyone resolving them. Google's country
+// domains are handled by safeSearchTarget.
+var safeSearchNames = map[string]string{
+	"www.bing.com":             "strict.bing.com",
+	"bing.com":                 "strict.bing.com",
+	"duckduckgo.com":           "safe.duckduckgo.com",
+	"www.duckduckgo.com":       "safe.duckduckgo.com",
+	"youtube.com":              "restrict.youtube.com",
+	"www.youtube.com":          "restrict.youtube.com",
+	"m.youtube.com":            "restrict.youtube.com",
+	"youtubei.googleapis.com":  "restrict.youtube.com",
+	"youtube.googleapis.com":   "restrict.youtube.com",
+	"www.youtube-nocookie.com": "restrict.youtube.com",
+	"google.com":               "forcesafesearch.google.com",
+	"www.google.com":           "forcesafesearch.google.com",
+}
+
+// safeSearchTarget returns the safe search endpoint name is resolved as, empty
+// when it isn't a search engine. Google is also found as google.de,
+// www.google.co.uk and the like.
+func safeSearchTarget(name string) string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if target, ok := safeSearchNames[name]; ok {
+		return target
+	}
+
+	labels := strings.Split(strings.TrimPrefix(name, "www."), ".")
+	if len(labels) < 2 || len(labels) > 3 || labels[0] != "google" {
+		return ""
+	}
+
+	for _, l := range labels[1:] {
+		if len(l) != 2 && l != "com" {
+			return ""
+		}
+	}
+
+	return "forcesafesearch.google.com"
+}
+
+// safeSearch returns the name the question is resolved as when safe search
+// is enforced for the client, for everyone or by its client group, nil
+// otherwise.
+func (s *Server) safeSearch(ip net.IP, request *dns.DNSPacket) *buffer.DomainName {
+	if len(request.Questions) != 1 {
+		return nil
+	}
+
+	if !s.config.SafeSearch {
+		if g := s.groupFor(ip); g == nil || !g.safeSearch {
+			return nil
+		}
+	}
+
+	target := safeSearchTarget(request.Questions[0].Name.String())
+	if target == "" {
+		return nil
+	}
+
+	return buffer.NewDomainName(target)
+}
+
+// answerSafeSearch answers the query with a CNAME to the safe search endpoint
+// followed by the records of the endpoint the client asked for.
+func (s *Server) answerSafeSearch(ctx context.Context, v *view, packet *dns.DNSPacket, q *dns.DNSQuestion, target *buffer.DomainName) *dns.ExtendedError {
+	logger.Infof("Enforcing safe search for %s via %s\n", q, target)
+
+	pq := *q
+	packet.Questions = append(packet.Questions, &pq)
+
+	cname, err := dns.NewCNAMERecord(q.Name.String(), target.String(), safeSearchTTL)
+	if err != nil {
+		logger.Errorf("Error: %s\n", err)
+		packet.Header.ResCode = dns.ServFail
+		return extendedError(err)
+	}
+	packet.Answers = append(packet.Answers, cname)
+
+	if q.QType != dns.CNAMEQueryType {
+		result, err := v.resolver.Resolve(ctx, target.String(), q.QType)
+		if err != nil {
+			logger.Errorf("Error: %s\n", err)
+			packet.Header.ResCode = dns.ServFail
+			atomic.AddUint64(&s.stats.failures, 1)
+			return extendedError(err)
+		}
+		packet.Answers = append(packet.Answers, result.Answers...)
+	}
+
+	return &dns.ExtendedError{Code: dns.EDEFiltered}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/dnstest"
+)
+
+func TestSafeSearchTarget(t *testing.T) {
+	for name, target := range map[string]string{
+		"www.google.com":      "forcesafesearch.google.com",
+		"WWW.Google.COM.":     "forcesafesearch.google.com",
+		"google.de":           "forcesafesearch.google.com",
+		"www.google.co.uk":    "forcesafesearch.google.com",
+		"www.google.com.au":   "forcesafesearch.google.com",
+		"www.bing.com":        "strict.bing.com",
+		"duckduckgo.com":      "safe.duckduckgo.com",
+		"m.youtube.com":       "restrict.youtube.com",
+		"mail.google.com":     "",
+		"google.example.com":  "",
+		"www.example.com":     "",
+		"google":              "",
+		"forcesafesearch.com": "",
+	} {
+		Equal(t, target, safeSearchTarget(name), name)
+	}
+}
+
+func TestSafeSearch(t *testing.T) {
+	ns := dnstest.NewServer(t, map[string]string{"google.com": `
+@	IN SOA	ns.google.com. hostmaster.google.com. 1 7200 3600 1209600 300
+@	IN NS	ns.google.com.
+ns	IN A	192.0.2.53
+www	IN A	192.0.2.1
+forcesafesearch	IN A	192.0.2.2
+`})
+
+	query := func(s *Server, qtype dns.QueryType) *dns.DNSPacket {
+		request := dns.NewDNSPacket()
+		request.Header.ID = 4660
+		request.Header.RecursionDesired = true
+		request.Questions = append(request.Questions, dns.NewDNSQuestion("www.google.com", qtype))
+		return exchange(t, s, request)
+	}
+
+	t.Run("cname_to_safe_search", func(t *testing.T) {
+		cfg := DefaultConfig()
+		cfg.SafeSearch = true
+		NoError(t, cfg.Forwarders.Set(ns.Addr.String()))
+		s := NewServer(cfg)
+
+		response := query(s, dns.AQueryType)
+		Equal(t, dns.NoError, response.Header.ResCode)
+		if Len(t, response.Answers, 2) {
+			Equal(t, "www.google.com", response.Answers[0].Domain.String())
+			Equal(t, "forcesafesearch.google.com", response.Answers[0].Host.String())
+			Equal(t, "192.0.2.2", response.Answers[1].Addr.String())
+		}
+
+		response = query(s, dns.CNAMEQueryType)
+		Len(t, response.Answers, 1)
+	})
+
+	t.Run("only_for_client_groups_with_safe_search", func(t *testing.T) {
+		cfg := DefaultConfig()
+		NoError(t, cfg.Forwarders.Set(ns.Addr.String()))
+		cfg.ClientGroups = []*ClientGroup{{
+			Name:       "kids",
+			Clients:    []*net.IPNet{{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(8, 32)}},
+			SafeSearch: true,
+		}}
+		s := NewServer(cfg)
+
+		// exchange queries from 127.0.0.1
+		response := query(s, dns.AQueryType)
+		if Len(t, response.Answers, 1) {
+			Equal(t, "192.0.2.1", response.Answers[0].Addr.String())
+		}
+
+		request := dns.NewDNSPacket()
+		request.Questions = append(request.Questions, dns.NewDNSQuestion("www.google.com", dns.AQueryType))
+		NotNil(t, s.safeSearch(net.ParseIP("10.1.2.3"), request))
+		Nil(t, s.safeSearch(net.ParseIP("192.0.2.10"), request))
+	})
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/msarvar/godns/pkg/cache"
+)
+
+// stats counts what the server did since it started, reported by the admin
+// API.
+type stats struct {
+	started time.Time
+
+	queries  uint64
+	blocked  uint64
+	failures uint64
+	// panics counts the queries answered with SERVFAIL after a panic
+	panics uint64
+}
+
+func (st *stats) write(w io.Writer, s *Server) {
+	fmt.Fprintf(w, "uptime %s\n", time.Since(st.started).Truncate(time.Second))
+	fmt.Fprintf(w, "queries %d\n", atomic.LoadUint64(&st.queries))
+	fmt.Fprintf(w, "blocked %d\n", atomic.LoadUint64(&st.blocked))
+	fmt.Fprintf(w, "failures %d\n", atomic.LoadUint64(&st.failures))
+	fmt.Fprintf(w, "panics %d\n", atomic.LoadUint64(&st.panics))
+
+	var cached cache.Stats
+	for _, c := range s.caches() {
+		st := c.Stats()
+		cached.Entries += st.Entries
+		cached.Bytes += st.Bytes
+		cached.Hits += st.Hits
+		cached.Misses += st.Misses
+		cached.Evictions += st.Evictions
+	}
+	fmt.Fprintf(w, "cached %d\n", cached.Entries)
+	fmt.Fprintf(w, "cache_bytes %d\n", cached.Bytes)
+	fmt.Fprintf(w, "cache_hits %d\n", cached.Hits)
+	fmt.Fprintf(w, "cache_misses %d\n", cached.Misses)
+	fmt.Fprintf(w, "cache_evictions %d\n", cached.Evictions)
+
+	if health := s.upstreamHealth(); len(health) > 0 {
+		down := 0
+		for _, h := range health {
+			if !h.Healthy {
+				down++
+			}
+		}
+		fmt.Fprintf(w, "upstreams %d\n", len(health))
+		fmt.Fprintf(w, "upstreams_down %d\n", down)
+	}
+
+	if s.hasBlocklist() {
+		blocked := 0
+		var failures uint64
+		for _, v := range s.views {
+			if v.blocklist != nil {
+				blocked += v.blocklist.Len()
+				failures += v.blocklist.Failures()
+			}
+		}
+		fmt.Fprintf(w, "blocklist %d\n", blocked)
+		fmt.Fprintf(w, "blocklist_fetch_failures %d\n", failures)
+		fmt.Fprintf(w, "blocking %t\n", atomic.LoadInt32(&s.blocking) == 1)
+	}
+}
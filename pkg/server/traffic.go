@@ -0,0 +1,115 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// trafficMinutes is how many minutes of query rates are kept
+	trafficMinutes = 60
+	// trafficTracked bounds the names and clients counted for the top
+	// lists, past it the counts are halved and the rarest forgotten
+	trafficTracked = 10000
+)
+
+// traffic counts the queries answered per minute and per name and client
+// for the dashboard.
+type traffic struct {
+	mu sync.Mutex
+	// counts holds the queries of the minute in stamps at the same index
+	counts  [trafficMinutes]uint64
+	stamps  [trafficMinutes]int64
+	names   map[string]uint64
+	clients map[string]uint64
+}
+
+// topEntry is a name or client and how many queries it accounts for.
+type topEntry struct {
+	Key     string `json:"key"`
+	Queries uint64 `json:"queries"`
+}
+
+// trafficReport is what the dashboard graphs and lists.
+type trafficReport struct {
+	// Rates are the queries per minute of the last hour, oldest first
+	Rates      []uint64   `json:"rates"`
+	TopNames   []topEntry `json:"top_names"`
+	TopClients []topEntry `json:"top_clients"`
+}
+
+func newTraffic() *traffic {
+	return &traffic{names: make(map[string]uint64), clients: make(map[string]uint64)}
+}
+
+// record counts a query for name from client answered at now.
+func (t *traffic) record(name string, client string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	minute := now.Unix() / 60
+	i := minute % trafficMinutes
+	if t.stamps[i] != minute {
+		t.stamps[i], t.counts[i] = minute, 0
+	}
+	t.counts[i]++
+
+	count(t.names, name)
+	count(t.clients, client)
+}
+
+func count(counts map[string]uint64, key string) {
+	counts[key]++
+	if len(counts) <= trafficTracked {
+		return
+	}
+
+	// Keys queried once since the last halving go first
+	for k, n := range counts {
+		if n /= 2; n == 0 {
+			delete(counts, k)
+		} else {
+			counts[k] = n
+		}
+	}
+}
+
+// report returns the rates up to now and the n most queried names and
+// clients.
+func (t *traffic) report(now time.Time, n int) *trafficReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	report := &trafficReport{Rates: make([]uint64, trafficMinutes)}
+	minute := now.Unix() / 60
+	for k := range report.Rates {
+		m := minute - int64(trafficMinutes-1-k)
+		if i := m % trafficMinutes; t.stamps[i] == m {
+			report.Rates[k] = t.counts[i]
+		}
+	}
+
+	report.TopNames = top(t.names, n)
+	report.TopClients = top(t.clients, n)
+	return report
+}
+
+func top(counts map[string]uint64, n int) []topEntry {
+	entries := make([]topEntry, 0, len(counts))
+	for k, c := range counts {
+		entries = append(entries, topEntry{Key: k, Queries: c})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Queries != entries[j].Queries {
+			return entries[i].Queries > entries[j].Queries
+		}
+		return entries[i].Key < entries[j].Key
+	})
+
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries
+}
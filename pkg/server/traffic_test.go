@@ -0,0 +1,54 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestTraffic(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 30, 0, 0, time.UTC)
+
+	t.Run("rates_per_minute", func(t *testing.T) {
+		tr := newTraffic()
+		tr.record("www.example.com", "192.0.2.1", now.Add(-2*time.Hour))
+		tr.record("www.example.com", "192.0.2.1", now.Add(-59*time.Minute))
+		tr.record("www.example.com", "192.0.2.1", now.Add(-time.Minute))
+		tr.record("www.example.com", "192.0.2.1", now)
+		tr.record("www.example.com", "192.0.2.1", now.Add(10*time.Second))
+
+		rates := tr.report(now, 10).Rates
+		if Len(t, rates, trafficMinutes) {
+			Equal(t, uint64(1), rates[0])
+			Equal(t, uint64(1), rates[trafficMinutes-2])
+			Equal(t, uint64(2), rates[trafficMinutes-1])
+		}
+	})
+
+	t.Run("top_names_and_clients", func(t *testing.T) {
+		tr := newTraffic()
+		tr.record("a.example.com", "192.0.2.1", now)
+		tr.record("b.example.com", "192.0.2.1", now)
+		tr.record("b.example.com", "192.0.2.2", now)
+		tr.record("c.example.com", "192.0.2.1", now)
+
+		report := tr.report(now, 2)
+		Equal(t, []topEntry{{"b.example.com", 2}, {"a.example.com", 1}}, report.TopNames)
+		Equal(t, []topEntry{{"192.0.2.1", 3}, {"192.0.2.2", 1}}, report.TopClients)
+	})
+
+	t.Run("tracked_names_are_bounded", func(t *testing.T) {
+		tr := newTraffic()
+		for i := 0; i < 5; i++ {
+			tr.record("popular.example.com", "192.0.2.1", now)
+		}
+		for i := 0; i <= trafficTracked; i++ {
+			tr.record(fmt.Sprintf("host%d.example.com", i), "192.0.2.1", now)
+		}
+
+		LessOrEqual(t, len(tr.names), trafficTracked)
+		Equal(t, "popular.example.com", tr.report(now, 1).TopNames[0].Key)
+	})
+}
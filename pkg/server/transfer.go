@@ -0,0 +1,108 @@
+package server
+
+import (
+	"net"
+	"strings"
+
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/logger"
+	"github.com/pkg/errors"
+)
+
+// Networks implements flag.Value, every use of the flag adds a network like
+// 10.0.0.0/8 or a single address.
+type Networks []*net.IPNet
+
+func (ns *Networks) String() string {
+	networks := make([]string, 0, len(*ns))
+	for _, n := range *ns {
+		networks = append(networks, n.String())
+	}
+
+	return strings.Join(networks, ",")
+}
+
+func (ns *Networks) Set(value string) error {
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return errors.Errorf("invalid address %q", value)
+		}
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		*ns = append(*ns, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		return nil
+	}
+
+	_, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return errors.Wrapf(err, "parsing network %q", value)
+	}
+
+	*ns = append(*ns, network)
+	return nil
+}
+
+// transfer answers an AXFR query for a zone of the client's view with the
+// whole zone, streamed over as many messages as it needs (RFC5936 2.2). It
+// returns nil when msg isn't a transfer, those are answered as any query.
+// Clients outside TransferClients are refused.
+func (s *Server) transfer(msg []byte, addr net.Addr) [][]byte {
+	lazy, err := dns.ParseLazy(msg)
+	if err != nil || lazy.Header.Response || lazy.Header.Opcode != dns.OpcodeQuery ||
+		len(lazy.Questions) != 1 || lazy.Questions[0].QType != dns.AXFRQueryType {
+		return nil
+	}
+	q := lazy.Questions[0]
+	ip := addrIP(addr)
+
+	v := s.viewFor(ip)
+	if v.zones == nil {
+		return nil
+	}
+	z := v.zones.Find(q.Name.String())
+	if z == nil || z.Name != q.Name.Normalized() {
+		return nil
+	}
+
+	if !containsIP(s.config.TransferClients, ip) {
+		logger.Infof("Refusing transfer of %s to %s\n", z.Name, ip)
+		return [][]byte{s.errorFor(msg, dns.Refused)}
+	}
+
+	request, err := lazy.Packet()
+	if err != nil {
+		return [][]byte{s.errorFor(msg, dns.FormErr)}
+	}
+
+	packet := dns.NewDNSPacket()
+	packet.Header.ID = request.Header.ID
+	packet.Header.Response = true
+	packet.Header.AuthoritativeAnswer = true
+	packet.Questions = request.Questions
+	// The SOA starts and ends the transfer
+	packet.Answers = append(z.Records(), z.SOA)
+	if request.OPT() != nil {
+		packet.Resources = append(packet.Resources, dns.NewOPTRecord(s.config.MaxUDPSize))
+	}
+
+	messages, err := packet.Messages(dns.MaxMessageSize)
+	if err != nil {
+		logger.Errorf("Error: transferring %s: %s\n", z.Name, err)
+		return [][]byte{s.errorFor(msg, dns.ServFail)}
+	}
+
+	logger.Infof("Transferring %s to %s in %d messages\n", z.Name, ip, len(messages))
+	data := make([][]byte, 0, len(messages))
+	for _, message := range messages {
+		encoded := encodePacket(message)
+		if encoded == nil {
+			return [][]byte{s.errorFor(msg, dns.ServFail)}
+		}
+		data = append(data, encoded)
+	}
+
+	return data
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/resolver"
+)
+
+func TestTransfer(t *testing.T) {
+	// A zone of a few thousand records takes several messages
+	var zone strings.Builder
+	zone.WriteString("@ SOA ns.big.example. admin.big.example. 7 2 3 4 5\n")
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&zone, "host%d A 10.0.%d.%d\n", i, i/256, i%256)
+	}
+	file := filepath.Join(t.TempDir(), "big.zone")
+	NoError(t, ioutil.WriteFile(file, []byte(zone.String()), 0644))
+
+	serve := func(t *testing.T, clients ...string) string {
+		cfg := DefaultConfig()
+		NoError(t, cfg.Zones.Set("big.example="+file))
+		for _, c := range clients {
+			NoError(t, cfg.TransferClients.Set(c))
+		}
+		s := NewServer(cfg)
+		_, err := s.loadZones(context.Background())
+		NoError(t, err)
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if !NoError(t, err) {
+			t.FailNow()
+		}
+		t.Cleanup(func() { ln.Close() })
+		go s.serveTCP(context.Background(), ln)
+
+		return ln.Addr().String()
+	}
+
+	t.Run("zone_is_streamed_over_several_messages", func(t *testing.T) {
+		addr := serve(t, "127.0.0.1")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		records, err := resolver.Transfer(ctx, addr, "big.example")
+		NoError(t, err)
+		Len(t, records, 5001)
+		Equal(t, dns.SOAQueryType, records[0].QType)
+	})
+
+	t.Run("every_message_has_the_query_id", func(t *testing.T) {
+		addr := serve(t, "127.0.0.0/8")
+
+		conn, err := net.Dial("tcp", addr)
+		if !NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		query := dns.NewDNSPacket()
+		query.Header.ID = 4242
+		query.Questions = append(query.Questions, dns.NewDNSQuestion("big.example", dns.AXFRQueryType))
+		_, err = query.WriteTo(conn)
+		NoError(t, err)
+
+		messages, soas := 0, 0
+		for soas < 2 {
+			response, err := dns.ReadPacket(conn)
+			if !NoError(t, err) {
+				return
+			}
+			messages++
+
+			Equal(t, uint16(4242), response.Header.ID)
+			True(t, response.Header.AuthoritativeAnswer)
+			Equal(t, messages == 1, len(response.Questions) == 1)
+			for _, r := range response.Answers {
+				if r.QType == dns.SOAQueryType {
+					soas++
+				}
+			}
+		}
+		Greater(t, messages, 1)
+	})
+
+	t.Run("other_clients_are_refused", func(t *testing.T) {
+		addr := serve(t, "10.0.0.0/8")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err := resolver.Transfer(ctx, addr, "big.example")
+		if Error(t, err) {
+			Contains(t, err.Error(), "REFUSED")
+		}
+	})
+
+	t.Run("networks", func(t *testing.T) {
+		var networks Networks
+		NoError(t, networks.Set("10.0.0.0/8"))
+		NoError(t, networks.Set("192.0.2.1"))
+		NoError(t, networks.Set("2001:db8::1"))
+		Error(t, networks.Set("example.com"))
+		Error(t, networks.Set("10.0.0.0/33"))
+		Equal(t, "10.0.0.0/8,192.0.2.1/32,2001:db8::1/128", networks.String())
+	})
+}
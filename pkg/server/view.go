@@ -0,0 +1,343 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+
+	"github.com/msarvar/godns/pkg/blocklist"
+	"github.com/msarvar/godns/pkg/cache"
+	"github.com/msarvar/godns/pkg/logger"
+	"github.com/msarvar/godns/pkg/resolver"
+	"github.com/msarvar/godns/pkg/zone"
+	"github.com/pkg/errors"
+)
+
+// View answers a group of clients from its own zones, forwarders and
+// blocklist, which is how split-horizon DNS is set up. Clients outside every
+// view get the zones, forwarders and blocklist of the server config.
+type View struct {
+	Name    string
+	Clients []*net.IPNet
+	Zones   zone.Sources
+	// Catalogs add the zones they list, see Config
+	Catalogs zone.Sources
+	// Forwarders resolve the names of the view, without them the view
+	// shares the recursive resolver and cache of the server
+	Forwarders resolver.Forwarders
+	// BlocklistFile is a file or an http or https URL, see Config
+	BlocklistFile string
+	AllowlistFile string
+}
+
+// viewFile is the JSON form of a view:
+//
+//	{"name": "internal", "clients": ["10.0.0.0/8"],
+//	 "zones": ["corp.example=/etc/godns/corp.zone"],
+//	 "catalogs": ["catalog.corp.example=axfr://10.0.0.2:53"],
+//	 "forwarders": ["10.0.0.1"], "blocklist": "/etc/godns/internal.txt",
+//	 "allowlist": "/etc/godns/internal-allowed.txt"}
+type viewFile struct {
+	Name       string   `json:"name"`
+	Clients    []string `json:"clients"`
+	Zones      []string `json:"zones"`
+	Catalogs   []string `json:"catalogs"`
+	Forwarders []string `json:"forwarders"`
+	Blocklist  string   `json:"blocklist"`
+	Allowlist  string   `json:"allowlist"`
+}
+
+// LoadViews reads a JSON list of views, a client gets the first view
+// containing its address.
+func LoadViews(path string) ([]*View, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading views")
+	}
+
+	var files []viewFile
+	if err := json.Unmarshal(data, &files); err != nil {
+		return nil, errors.Wrap(err, "parsing views")
+	}
+
+	views := make([]*View, 0, len(files))
+	for _, f := range files {
+		v := &View{Name: f.Name, BlocklistFile: f.Blocklist, AllowlistFile: f.Allowlist}
+		if len(f.Clients) == 0 {
+			return nil, errors.Errorf("view %q has no clients", f.Name)
+		}
+
+		for _, c := range f.Clients {
+			_, network, err := net.ParseCIDR(c)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parsing clients of view %q", f.Name)
+			}
+			v.Clients = append(v.Clients, network)
+		}
+
+		for _, z := range f.Zones {
+			if err := v.Zones.Set(z); err != nil {
+				return nil, errors.Wrapf(err, "parsing zones of view %q", f.Name)
+			}
+		}
+
+		for _, c := range f.Catalogs {
+			if err := v.Catalogs.Set(c); err != nil {
+				return nil, errors.Wrapf(err, "parsing catalogs of view %q", f.Name)
+			}
+		}
+
+		for _, fw := range f.Forwarders {
+			if err := v.Forwarders.Set(fw); err != nil {
+				return nil, errors.Wrapf(err, "parsing forwarders of view %q", f.Name)
+			}
+		}
+
+		views = append(views, v)
+	}
+
+	return views, nil
+}
+
+// view holds what a view answers from while the server runs.
+type view struct {
+	name    string
+	clients []*net.IPNet
+
+	resolver *resolver.Resolver
+	cache    *cache.Memory
+	// blocklist, allowlist and zones are nil when the view has none
+	blocklist *blocklist.Blocklist
+	allowlist *blocklist.Allowlist
+	zones     *zone.Set
+}
+
+// newView builds the view, views without forwarders share the resolver of
+// shared.
+func (s *Server) newView(v *View, shared *view) *view {
+	rv := &view{
+		name:    v.Name,
+		clients: v.Clients,
+	}
+
+	if shared != nil && len(v.Forwarders) == 0 {
+		rv.resolver, rv.cache = shared.resolver, shared.cache
+	} else {
+		rv.cache = cache.New(&cache.Config{
+			MaxStale:     s.config.MaxStale,
+			PrefetchHits: s.config.PrefetchHits,
+			MaxEntries:   s.config.CacheMaxEntries,
+			MaxBytes:     s.config.CacheMaxBytes,
+		})
+		rv.resolver = resolver.NewResolver(&resolver.Config{
+			Cache:             rv.cache,
+			AddressPreference: s.config.AddressPreference,
+			CaptureDir:        s.config.CaptureDir,
+			Pcap:              s.config.Pcap,
+			Forwarders:        v.Forwarders,
+			Strategies:        s.config.Strategies,
+			Privacy:           s.config.Privacy,
+			MinTTL:            s.config.MinTTL,
+			MaxTTL:            s.config.MaxTTL,
+			Faults:            s.config.UpstreamFaults,
+			MaxUDPSize:        s.maxUDPSize(),
+		})
+	}
+
+	if len(v.Zones) > 0 || len(v.Catalogs) > 0 {
+		rv.zones = zone.NewSet(v.Zones, v.Catalogs)
+		rv.zones.SignWith(s.config.SigningKeys)
+	}
+
+	if v.BlocklistFile != "" {
+		rv.blocklist = blocklist.New(v.BlocklistFile)
+	}
+
+	if v.AllowlistFile != "" {
+		rv.allowlist = blocklist.NewAllowlist(v.AllowlistFile)
+	}
+
+	return rv
+}
+
+// viewFor returns the view answering the client, the default view is last
+// and matches everyone.
+func (s *Server) viewFor(ip net.IP) *view {
+	for _, v := range s.views {
+		if v.clients == nil {
+			return v
+		}
+		for _, network := range v.clients {
+			if network.Contains(ip) {
+				return v
+			}
+		}
+	}
+
+	return s.views[len(s.views)-1]
+}
+
+// defaultView is the view of clients outside every configured view, its cache
+// is the one persisted to CacheFile.
+func (s *Server) defaultView() *view {
+	return s.views[len(s.views)-1]
+}
+
+// viewHealth is the state of a forwarder of a view.
+type viewHealth struct {
+	view string
+	resolver.UpstreamHealth
+}
+
+// upstreamHealth returns the state of the forwarders of every view, views
+// sharing the resolver of the default view don't repeat its forwarders.
+func (s *Server) upstreamHealth() []viewHealth {
+	health := make([]viewHealth, 0)
+	for _, v := range s.views {
+		if v != s.defaultView() && v.resolver == s.defaultView().resolver {
+			continue
+		}
+
+		for _, h := range v.resolver.Health() {
+			health = append(health, viewHealth{view: v.name, UpstreamHealth: h})
+		}
+	}
+
+	return health
+}
+
+// checkUpstreams probes the forwarders of every view until ctx is cancelled.
+func (s *Server) checkUpstreams(ctx context.Context) {
+	seen := make(map[*resolver.Resolver]bool)
+	for _, v := range s.views {
+		if !seen[v.resolver] && len(v.resolver.Health()) > 0 {
+			seen[v.resolver] = true
+			go v.resolver.CheckHealth(ctx, s.config.UpstreamCheckInterval)
+		}
+	}
+}
+
+// discoverDesignated upgrades the forwarders of every resolver to the
+// encrypted resolvers they designate, queries go over plain DNS meanwhile.
+func (s *Server) discoverDesignated(ctx context.Context) {
+	seen := make(map[*resolver.Resolver]bool)
+	for _, v := range s.views {
+		if !seen[v.resolver] && len(v.resolver.Health()) > 0 {
+			seen[v.resolver] = true
+			go v.resolver.DiscoverDesignated(ctx)
+		}
+	}
+}
+
+// caches returns the distinct caches of the views.
+func (s *Server) caches() []*cache.Memory {
+	caches := make([]*cache.Memory, 0, len(s.views))
+	seen := make(map[*cache.Memory]bool)
+	for _, v := range s.views {
+		if !seen[v.cache] {
+			seen[v.cache] = true
+			caches = append(caches, v.cache)
+		}
+	}
+
+	return caches
+}
+
+// loadBlocklists (re)loads the blocklists and allowlists of every view and
+// client group and returns how many domains the blocklists hold together.
+func (s *Server) loadBlocklists() (int, error) {
+	total := 0
+	for _, v := range s.views {
+		if v.allowlist != nil {
+			n, err := v.allowlist.Load()
+			if err != nil {
+				return total, errors.Wrapf(err, "loading allowlist of view %s", v.name)
+			}
+			logger.Infof("Loaded %d allowed domains for view %s\n", n, v.name)
+		}
+
+		if v.blocklist == nil {
+			continue
+		}
+
+		n, err := v.blocklist.Load()
+		if err != nil {
+			return total, errors.Wrapf(err, "loading blocklist of view %s", v.name)
+		}
+		logger.Infof("Loaded %d blocked domains for view %s\n", n, v.name)
+		total += n
+	}
+
+	for _, g := range s.groups {
+		if g.allowlist != nil {
+			n, err := g.allowlist.Load()
+			if err != nil {
+				return total, errors.Wrapf(err, "loading allowlist of client group %s", g.name)
+			}
+			logger.Infof("Loaded %d allowed domains for client group %s\n", n, g.name)
+		}
+
+		if g.blocklist == nil {
+			continue
+		}
+
+		n, err := g.blocklist.Load()
+		if err != nil {
+			return total, errors.Wrapf(err, "loading blocklist of client group %s", g.name)
+		}
+		logger.Infof("Loaded %d blocked domains for client group %s\n", n, g.name)
+		total += n
+	}
+
+	return total, nil
+}
+
+// loadZones (re)loads the authoritative zones of every view and logs their
+// sizes.
+func (s *Server) loadZones(ctx context.Context) (int, error) {
+	total := 0
+	for _, v := range s.views {
+		if v.zones == nil {
+			continue
+		}
+
+		err := v.zones.Load(ctx)
+		for _, z := range v.zones.Zones() {
+			logger.Infof("Loaded %d names of zone %s for view %s\n", z.Len(), z.Name, v.name)
+		}
+		total += len(v.zones.Zones())
+
+		if err != nil {
+			return total, errors.Wrapf(err, "view %s", v.name)
+		}
+	}
+
+	return total, nil
+}
+
+func (s *Server) hasBlocklist() bool {
+	for _, v := range s.views {
+		if v.blocklist != nil {
+			return true
+		}
+	}
+
+	for _, g := range s.groups {
+		if g.blocklist != nil || len(g.schedules) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Server) hasZones() bool {
+	for _, v := range s.views {
+		if v.zones != nil {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+func TestViews(t *testing.T) {
+	dir := t.TempDir()
+	internal := filepath.Join(dir, "internal.zone")
+	NoError(t, ioutil.WriteFile(internal, []byte("@ SOA ns.corp.example. admin.corp.example. 1 2 3 4 5\nwww A 10.0.0.1\n"), 0644))
+	public := filepath.Join(dir, "public.zone")
+	NoError(t, ioutil.WriteFile(public, []byte("@ SOA ns.corp.example. admin.corp.example. 1 2 3 4 5\nwww A 192.0.2.1\n"), 0644))
+
+	views := filepath.Join(dir, "views.json")
+	NoError(t, ioutil.WriteFile(views, []byte(`[
+		{"name": "internal", "clients": ["10.0.0.0/8", "fd00::/8"],
+		 "zones": ["corp.example=`+internal+`"], "forwarders": ["10.0.0.53"]}
+	]`), 0644))
+
+	t.Run("load_views", func(t *testing.T) {
+		loaded, err := LoadViews(views)
+		NoError(t, err)
+		if Len(t, loaded, 1) {
+			Equal(t, "internal", loaded[0].Name)
+			Len(t, loaded[0].Clients, 2)
+			Equal(t, "10.0.0.53:53", loaded[0].Forwarders.String())
+		}
+
+		invalid := filepath.Join(dir, "invalid.json")
+		for _, content := range []string{
+			`[{"name": "none"}]`,
+			`[{"name": "bad", "clients": ["10.0.0.0"]}]`,
+			`[{"name": "bad", "clients": ["10.0.0.0/8"], "forwarders": ["resolver.example"]}]`,
+			`{}`,
+		} {
+			NoError(t, ioutil.WriteFile(invalid, []byte(content), 0644))
+			_, err := LoadViews(invalid)
+			Error(t, err, content)
+		}
+	})
+
+	t.Run("clients_get_the_zones_of_their_view", func(t *testing.T) {
+		loaded, err := LoadViews(views)
+		NoError(t, err)
+
+		cfg := DefaultConfig()
+		cfg.Views = loaded
+		NoError(t, cfg.Zones.Set("corp.example="+public))
+		s := NewServer(cfg)
+		_, err = s.loadZones(context.Background())
+		NoError(t, err)
+
+		request := dns.NewDNSPacket()
+		request.Questions = append(request.Questions, dns.NewDNSQuestion("www.corp.example", dns.AQueryType))
+
+		for ip, addr := range map[string]string{
+			"10.1.2.3":    "10.0.0.1",
+			"fd00::1":     "10.0.0.1",
+			"192.0.2.200": "192.0.2.1",
+		} {
+			v := s.viewFor(net.ParseIP(ip))
+			answer := v.answerLocally(request)
+			if NotNil(t, answer, ip) && Len(t, answer.Answers, 1, ip) {
+				Equal(t, addr, answer.Answers[0].Addr.String(), ip)
+			}
+		}
+
+		NotSame(t, s.viewFor(net.ParseIP("10.1.2.3")).cache, s.defaultView().cache)
+		Len(t, s.caches(), 2)
+	})
+	t.Run("allowlists_exempt_names_per_view", func(t *testing.T) {
+		blocked := filepath.Join(dir, "blocked.txt")
+		NoError(t, ioutil.WriteFile(blocked, []byte("ads.example.com\n"), 0644))
+		allowed := filepath.Join(dir, "allowed.txt")
+		NoError(t, ioutil.WriteFile(allowed, []byte("*.ads.example.com\n"), 0644))
+
+		cfg := DefaultConfig()
+		cfg.BlocklistFile = blocked
+		cfg.Views = []*View{{
+			Name:          "internal",
+			Clients:       []*net.IPNet{{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(8, 32)}},
+			BlocklistFile: blocked,
+			AllowlistFile: allowed,
+		}}
+		s := NewServer(cfg)
+		_, err := s.loadBlocklists()
+		NoError(t, err)
+
+		internal := s.viewFor(net.ParseIP("10.1.2.3"))
+		True(t, s.blocked(internal, nil, "ads.example.com"))
+		False(t, s.blocked(internal, nil, "cdn.ads.example.com"))
+		True(t, s.blocked(s.defaultView(), nil, "cdn.ads.example.com"))
+	})
+}
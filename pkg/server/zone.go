@@ -0,0 +1,32 @@
+package server
+
+import (
+	"github.com/msarvar/godns/pkg/dns"
+)
+
+// answerLocally answers queries for names in the zones we are authoritative
+// for, nil means the query has to be resolved. Referrals to child zones are
+// resolved too since our clients expect recursion. Clients setting the DO
+// flag get the DNSSEC records of signed zones.
+func (v *view) answerLocally(request *dns.DNSPacket) *dns.DNSPacket {
+	if v.zones == nil || len(request.Questions) != 1 {
+		return nil
+	}
+
+	q := request.Questions[0]
+	z := v.zones.Find(q.Name.String())
+	if z == nil {
+		return nil
+	}
+
+	answer := z.Answer(q.Name.String(), q.QType)
+	if !answer.Header.AuthoritativeAnswer {
+		return nil
+	}
+
+	if opt := request.OPT(); opt != nil && opt.DNSSECOK() {
+		z.AddDNSSEC(answer, q.Name.String())
+	}
+
+	return answer
+}
@@ -1,5 +1,10 @@
 package utils
 
+import (
+	"crypto/rand"
+	"encoding/binary"
+)
+
 func BoolToUint8(value bool) uint8 {
 	var converted uint8
 	if value {
@@ -8,3 +13,14 @@ func BoolToUint8(value bool) uint8 {
 
 	return converted
 }
+
+// RandomUint16 returns a cryptographically random number, used for query ids
+// and source ports that must not be guessable.
+func RandomUint16() (uint16, error) {
+	b := make([]byte, 2)
+	if _, err := rand.Read(b); err != nil {
+		return 0, err
+	}
+
+	return binary.BigEndian.Uint16(b), nil
+}
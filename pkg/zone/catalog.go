@@ -0,0 +1,57 @@
+package zone
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/pkg/errors"
+)
+
+// catalogVersion is the only catalog zone schema understood (RFC9432 4.2.1).
+const catalogVersion = "2"
+
+// CatalogMembers returns the member zones listed by a catalog zone. Every
+// member is a PTR record at a unique label below "zones" of the catalog
+// (RFC9432 4.3), properties further down like "coo" are ignored.
+func CatalogMembers(catalog *Zone) ([]string, error) {
+	version := ""
+	if set := catalog.rrset(joinName("version", catalog.Name), dns.TXTQueryType); set != nil && len(set.Records) == 1 {
+		texts, err := set.Records[0].TXT()
+		if err != nil {
+			return nil, errors.Wrap(err, "reading catalog version")
+		}
+		version = strings.Join(texts, "")
+	}
+	if version != catalogVersion {
+		return nil, errors.Errorf("catalog %s has unsupported version %q", catalog.Name, version)
+	}
+
+	zones := joinName("zones", catalog.Name)
+	members := make([]string, 0)
+	for owner, sets := range catalog.nodes {
+		i := strings.Index(owner, ".")
+		if i < 0 || owner[i+1:] != zones {
+			continue
+		}
+
+		for _, set := range sets {
+			// A unique label listing several zones is broken, none of them
+			// is a member (RFC9432 4.3)
+			if set.Type == dns.PTRQueryType && len(set.Records) == 1 {
+				members = append(members, set.Records[0].Host.Normalized())
+			}
+		}
+	}
+
+	sort.Strings(members)
+	return members, nil
+}
+
+func joinName(label string, origin string) string {
+	if origin == "" {
+		return label
+	}
+
+	return label + "." + origin
+}
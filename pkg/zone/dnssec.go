@@ -0,0 +1,114 @@
+package zone
+
+import (
+	"sort"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/dnssec"
+)
+
+// Signed reports whether the zone is signed with DNSSEC.
+func (z *Zone) Signed() bool {
+	return len(z.nsec) > 0
+}
+
+// AddDNSSEC adds what DNSSEC aware clients need to validate the answer to
+// name (RFC4035 3.1): the signatures of every RRset and the NSEC records
+// proving names or types don't exist and that no closer name than a wildcard
+// matched. Answers of unsigned zones are left alone.
+func (z *Zone) AddDNSSEC(packet *dns.DNSPacket, name string) {
+	if !z.Signed() {
+		return
+	}
+
+	owner := buffer.NewDomainName(name).Normalized()
+	switch {
+	case packet.Header.ResCode == dns.NxDomain:
+		packet.Authorities = appendNew(packet.Authorities, z.covering(owner)...)
+		packet.Authorities = appendNew(packet.Authorities, z.covering(z.wildcardOf(owner))...)
+	case len(packet.Answers) == 0:
+		// Empty non-terminals have no NSEC record, the one before them
+		// proves they have no types
+		if nsec := z.records(owner, dns.NSECQueryType); len(nsec) > 0 {
+			packet.Authorities = appendNew(packet.Authorities, nsec...)
+		} else if _, ok := z.nodes[owner]; ok {
+			packet.Authorities = appendNew(packet.Authorities, z.covering(owner)...)
+		} else {
+			packet.Authorities = appendNew(packet.Authorities, z.covering(owner)...)
+			packet.Authorities = appendNew(packet.Authorities, z.covering(z.wildcardOf(owner))...)
+		}
+	default:
+		for _, set := range dns.GroupRRsets(packet.Answers) {
+			if _, ok := z.nodes[set.Key().Name]; !ok {
+				packet.Authorities = appendNew(packet.Authorities, z.covering(set.Key().Name)...)
+			}
+		}
+	}
+
+	for _, section := range []*[]*dns.DNSRecord{&packet.Answers, &packet.Authorities, &packet.Resources} {
+		for _, set := range dns.GroupRRsets(*section) {
+			*section = append(*section, z.signatures(set.Key().Name, set.Type)...)
+		}
+	}
+}
+
+// signatures returns the RRSIG records covering the RRset of the type at
+// owner, those of the wildcard renamed to owner when it was synthesized.
+func (z *Zone) signatures(owner string, qtype dns.QueryType) []*dns.DNSRecord {
+	source := owner
+	if _, ok := z.nodes[owner]; !ok {
+		source = z.wildcardOf(owner)
+	}
+
+	sigs := make([]*dns.DNSRecord, 0)
+	for _, sig := range z.records(source, dns.RRSIGQueryType) {
+		if dnssec.TypeCovered(sig) != qtype {
+			continue
+		}
+		if source != owner {
+			copied := *sig
+			copied.Domain = buffer.NewDomainName(owner)
+			sig = &copied
+		}
+		sigs = append(sigs, sig)
+	}
+
+	return sigs
+}
+
+// wildcardOf returns the wildcard at the closest encloser of owner, the
+// name that would have been expanded for it.
+func (z *Zone) wildcardOf(owner string) string {
+	for _, parent := range append(z.ancestors(owner), z.Name) {
+		if _, ok := z.nodes[parent]; ok {
+			return joinName("*", parent)
+		}
+	}
+
+	return joinName("*", z.Name)
+}
+
+// covering returns the NSEC record whose owner is name or precedes it in
+// canonical order, proving names between it and the next owner don't exist.
+func (z *Zone) covering(name string) []*dns.DNSRecord {
+	i := sort.Search(len(z.nsec), func(i int) bool {
+		return dns.CompareNames(z.nsec[i], name) > 0
+	}) - 1
+	if i < 0 {
+		i = len(z.nsec) - 1
+	}
+
+	return z.records(z.nsec[i], dns.NSECQueryType)
+}
+
+// appendNew appends the records not in records yet.
+func appendNew(records []*dns.DNSRecord, more ...*dns.DNSRecord) []*dns.DNSRecord {
+	for _, r := range more {
+		if !containsAll(records, []*dns.DNSRecord{r}) {
+			records = append(records, r)
+		}
+	}
+
+	return records
+}
@@ -0,0 +1,83 @@
+// Package zone serves the zones the server is authoritative for.
+package zone
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/resolver"
+	"github.com/pkg/errors"
+)
+
+// defaultTTL applies to records of zone files without $TTL.
+const defaultTTL = 3600
+
+// Source names a zone and where it is loaded from, a zone file path or
+// "axfr://host:port" to transfer it from a primary.
+type Source struct {
+	Zone     string
+	Location string
+}
+
+// ParseSource parses "ZONE=LOCATION", e.g. "example.com=/etc/example.zone".
+func ParseSource(value string) (Source, error) {
+	i := strings.Index(value, "=")
+	if i <= 0 || i == len(value)-1 {
+		return Source{}, errors.Errorf("zone %q is not ZONE=LOCATION", value)
+	}
+
+	return Source{Zone: value[:i], Location: value[i+1:]}, nil
+}
+
+func (s Source) String() string {
+	return s.Zone + "=" + s.Location
+}
+
+// Primary returns the "host:port" of the primary the zone is transferred
+// from, empty for zone files.
+func (s Source) Primary() string {
+	if !strings.HasPrefix(s.Location, "axfr://") {
+		return ""
+	}
+
+	return strings.TrimPrefix(s.Location, "axfr://")
+}
+
+// Records reads the records of the zone from its location.
+func (s Source) Records(ctx context.Context) ([]*dns.DNSRecord, error) {
+	if primary := s.Primary(); primary != "" {
+		return resolver.Transfer(ctx, primary, s.Zone)
+	}
+
+	f, err := os.Open(s.Location)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening zone file")
+	}
+	defer f.Close()
+
+	return dns.ReadZone(f, s.Zone, defaultTTL)
+}
+
+// Sources implements flag.Value, every use of the flag adds a zone.
+type Sources []Source
+
+func (ss *Sources) String() string {
+	sources := make([]string, 0, len(*ss))
+	for _, s := range *ss {
+		sources = append(sources, s.String())
+	}
+
+	return strings.Join(sources, ",")
+}
+
+func (ss *Sources) Set(value string) error {
+	s, err := ParseSource(value)
+	if err != nil {
+		return err
+	}
+
+	*ss = append(*ss, s)
+	return nil
+}
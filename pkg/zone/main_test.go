@@ -0,0 +1,404 @@
+package zone_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/dnssec"
+	"github.com/msarvar/godns/pkg/dnstest"
+	"github.com/msarvar/godns/pkg/zone"
+)
+
+const exampleZone = `$TTL 3600
+@ SOA ns.example.com. admin.example.com. 1 7200 3600 1209600 300
+@ NS ns.example.com.
+ns A 10.0.0.53
+www A 10.0.0.1
+alias CNAME www.example.com.
+*.apps A 10.0.0.2
+a.b.deep TYPE16 \# 1 00
+child NS ns.child.example.com.
+ns.child A 10.0.1.53
+`
+
+func exampleZoneFor(t *testing.T) *zone.Zone {
+	records, err := dns.ReadZone(strings.NewReader(exampleZone), "example.com", 3600)
+	NoError(t, err)
+
+	z, err := zone.New("example.com", records)
+	NoError(t, err)
+	return z
+}
+
+func TestZone(t *testing.T) {
+	z := exampleZoneFor(t)
+
+	t.Run("answers_records_and_follows_cnames", func(t *testing.T) {
+		answer := z.Answer("ALIAS.example.com", dns.AQueryType)
+		True(t, answer.Header.AuthoritativeAnswer)
+		Equal(t, dns.NoError, answer.Header.ResCode)
+		Equal(t, []string{
+			"alias.example.com. 3600 IN CNAME www.example.com.",
+			"www.example.com. 3600 IN A 10.0.0.1",
+		}, dnstest.Texts(answer.Answers))
+	})
+
+	t.Run("positive_answers_carry_name_servers_and_glue", func(t *testing.T) {
+		answer := z.Answer("www.example.com", dns.AQueryType)
+		Equal(t, []string{"example.com. 3600 IN NS ns.example.com."}, dnstest.Texts(answer.Authorities))
+		Equal(t, []string{"ns.example.com. 3600 IN A 10.0.0.53"}, dnstest.Texts(answer.Resources))
+
+		// The name servers aren't repeated when they are the answer
+		answer = z.Answer("example.com", dns.NSQueryType)
+		Equal(t, []string{"example.com. 3600 IN NS ns.example.com."}, dnstest.Texts(answer.Answers))
+		Empty(t, answer.Authorities)
+		Equal(t, []string{"ns.example.com. 3600 IN A 10.0.0.53"}, dnstest.Texts(answer.Resources))
+	})
+
+	t.Run("nxdomain_carries_soa_with_minimum_ttl", func(t *testing.T) {
+		answer := z.Answer("missing.example.com", dns.AQueryType)
+		Equal(t, dns.NxDomain, answer.Header.ResCode)
+		True(t, answer.Header.AuthoritativeAnswer)
+		Empty(t, answer.Answers)
+		Equal(t, []string{
+			"example.com. 300 IN SOA ns.example.com. admin.example.com. 1 7200 3600 1209600 300",
+		}, dnstest.Texts(answer.Authorities))
+	})
+
+	t.Run("nodata_for_missing_types_and_empty_non_terminals", func(t *testing.T) {
+		for _, name := range []string{"www.example.com", "b.deep.example.com", "deep.example.com"} {
+			answer := z.Answer(name, dns.AAAAQueryType)
+			Equal(t, dns.NoError, answer.Header.ResCode, name)
+			Empty(t, answer.Answers, name)
+			Len(t, answer.Authorities, 1, name)
+		}
+	})
+
+	t.Run("wildcards_synthesize_below_closest_encloser", func(t *testing.T) {
+		answer := z.Answer("x.y.apps.example.com", dns.AQueryType)
+		Equal(t, []string{"x.y.apps.example.com. 3600 IN A 10.0.0.2"}, dnstest.Texts(answer.Answers))
+
+		Equal(t, dns.NxDomain, z.Answer("x.www.example.com", dns.AQueryType).Header.ResCode)
+	})
+
+	t.Run("delegations_are_referrals", func(t *testing.T) {
+		answer := z.Answer("www.child.example.com", dns.AQueryType)
+		False(t, answer.Header.AuthoritativeAnswer)
+		Equal(t, []string{"child.example.com. 3600 IN NS ns.child.example.com."}, dnstest.Texts(answer.Authorities))
+		Equal(t, []string{"ns.child.example.com. 3600 IN A 10.0.1.53"}, dnstest.Texts(answer.Resources))
+	})
+
+	t.Run("rrsets_share_lowest_ttl_without_duplicates", func(t *testing.T) {
+		records, err := dns.ReadZone(strings.NewReader(`$TTL 3600
+@ SOA ns.example.com. admin.example.com. 1 7200 3600 1209600 300
+www 600 A 10.0.0.1
+www A 10.0.0.2
+www 60 A 10.0.0.1
+`), "example.com", 3600)
+		NoError(t, err)
+		z, err := zone.New("example.com", records)
+		NoError(t, err)
+
+		Equal(t, []string{
+			"www.example.com. 600 IN A 10.0.0.1",
+			"www.example.com. 600 IN A 10.0.0.2",
+		}, dnstest.Texts(z.Answer("www.example.com", dns.AQueryType).Answers))
+	})
+
+	t.Run("invalid_zones", func(t *testing.T) {
+		soa, _ := dns.ParseRecord("example.com. SOA ns.example.com. admin.example.com. 1 2 3 4 5", 60)
+		outside, _ := dns.ParseRecord("www.example.org. A 1.2.3.4", 60)
+
+		_, err := zone.New("example.com", nil)
+		Error(t, err)
+		_, err = zone.New("example.com", []*dns.DNSRecord{soa, outside})
+		Error(t, err)
+		_, err = zone.New("example.com", []*dns.DNSRecord{soa, soa})
+		Error(t, err)
+	})
+}
+
+func TestDNSSEC(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "example.zone")
+	NoError(t, ioutil.WriteFile(path, []byte(exampleZone), 0644))
+
+	var sources zone.Sources
+	NoError(t, sources.Set("example.com="+path))
+
+	key, err := dnssec.GenerateKey("example.com", true)
+	NoError(t, err)
+
+	set := zone.NewSet(sources, nil)
+	set.SignWith(dnssec.Keys{key})
+	NoError(t, set.Load(context.Background()))
+	z := set.Find("example.com")
+	True(t, z.Signed())
+
+	// answer looks name up with DNSSEC records and checks every signature
+	answer := func(name string, qtype dns.QueryType) *dns.DNSPacket {
+		packet := z.Answer(name, qtype)
+		z.AddDNSSEC(packet, name)
+
+		for _, records := range [][]*dns.DNSRecord{packet.Answers, packet.Authorities} {
+			signed := make(map[dns.QueryType]bool)
+			for _, r := range records {
+				if r.QType == dns.RRSIGQueryType {
+					signed[dnssec.TypeCovered(r)] = true
+					rrset := dns.GroupRRsets(records)
+					for _, s := range rrset {
+						if s.Key().Name == r.Domain.Normalized() && s.Type == dnssec.TypeCovered(r) {
+							NoError(t, dnssec.Verify(s, r, key.DNSKEY(3600), time.Now()), r.Text())
+						}
+					}
+				}
+			}
+			for _, r := range records {
+				True(t, r.QType == dns.RRSIGQueryType || signed[r.QType], r.Text())
+			}
+		}
+
+		return packet
+	}
+	nsecOwners := func(records []*dns.DNSRecord) []string {
+		owners := make([]string, 0)
+		for _, r := range records {
+			if r.QType == dns.NSECQueryType {
+				owners = append(owners, r.Domain.String())
+			}
+		}
+		return owners
+	}
+
+	t.Run("answers_are_signed", func(t *testing.T) {
+		packet := answer("www.example.com", dns.AQueryType)
+		Len(t, packet.Answers, 2)
+		Empty(t, nsecOwners(packet.Authorities))
+
+		packet = answer("example.com", dns.DNSKEYQueryType)
+		Len(t, packet.Answers, 2)
+	})
+
+	t.Run("nxdomain_is_proven", func(t *testing.T) {
+		packet := answer("missing.example.com", dns.AQueryType)
+		Equal(t, dns.NxDomain, packet.Header.ResCode)
+		// missing sorts after a.b.deep, *.example.com before everything
+		// but the apex
+		Equal(t, []string{"a.b.deep.example.com", "example.com"}, nsecOwners(packet.Authorities))
+	})
+
+	t.Run("nodata_is_proven", func(t *testing.T) {
+		packet := answer("www.example.com", dns.AAAAQueryType)
+		Equal(t, []string{"www.example.com"}, nsecOwners(packet.Authorities))
+
+		packet = answer("deep.example.com", dns.AAAAQueryType)
+		Equal(t, []string{"child.example.com"}, nsecOwners(packet.Authorities))
+	})
+
+	t.Run("wildcard_expansions_are_proven", func(t *testing.T) {
+		packet := answer("x.apps.example.com", dns.AQueryType)
+		Equal(t, "x.apps.example.com", packet.Answers[1].Domain.String())
+		Equal(t, []string{"*.apps.example.com"}, nsecOwners(packet.Authorities))
+	})
+
+	t.Run("unsigned_zones_are_left_alone", func(t *testing.T) {
+		z := exampleZoneFor(t)
+		False(t, z.Signed())
+
+		packet := z.Answer("missing.example.com", dns.AQueryType)
+		z.AddDNSSEC(packet, "missing.example.com")
+		Len(t, packet.Authorities, 1)
+	})
+}
+
+func TestSet(t *testing.T) {
+	t.Run("finds_most_specific_zone", func(t *testing.T) {
+		dir := t.TempDir()
+		parent := filepath.Join(dir, "example.zone")
+		child := filepath.Join(dir, "sub.zone")
+		NoError(t, ioutil.WriteFile(parent, []byte(exampleZone), 0644))
+		NoError(t, ioutil.WriteFile(child, []byte("@ SOA ns.example.com. admin.example.com. 1 2 3 4 5\n"), 0644))
+
+		var sources zone.Sources
+		NoError(t, sources.Set("example.com="+parent))
+		NoError(t, sources.Set("sub.example.com.="+child))
+
+		set := zone.NewSet(sources, nil)
+		NoError(t, set.Load(context.Background()))
+
+		Equal(t, "sub.example.com", set.Find("a.SUB.example.com").Name)
+		Equal(t, "example.com", set.Find("example.com").Name)
+		Nil(t, set.Find("example.org"))
+	})
+
+	t.Run("invalid_sources", func(t *testing.T) {
+		var sources zone.Sources
+		for _, value := range []string{"", "example.com", "=file", "example.com="} {
+			Error(t, sources.Set(value), value)
+		}
+	})
+}
+
+func TestCatalog(t *testing.T) {
+	const catalog = `
+@ SOA invalid. admin.example.com. 1 3600 600 86400 60
+@ NS invalid.
+version TXT \# 2 0132
+a1.zones PTR one.example.
+b2.zones PTR two.example.
+coo.a1.zones PTR other.catalog.example.
+`
+	member := "@ SOA ns.example.com. admin.example.com. 1 2 3 4 5\nwww A 10.0.0.1\n"
+	primary := dnstest.NewServer(t, map[string]string{
+		"catalog.example": catalog,
+		"one.example":     member,
+		"two.example":     member,
+	})
+
+	t.Run("members", func(t *testing.T) {
+		records, err := dns.ReadZone(strings.NewReader(catalog), "catalog.example", 3600)
+		NoError(t, err)
+		z, err := zone.New("catalog.example", records)
+		NoError(t, err)
+
+		members, err := zone.CatalogMembers(z)
+		NoError(t, err)
+		Equal(t, []string{"one.example", "two.example"}, members)
+
+		records, err = dns.ReadZone(strings.NewReader(strings.Replace(catalog, "0132", "0131", 1)), "catalog.example", 3600)
+		NoError(t, err)
+		z, err = zone.New("catalog.example", records)
+		NoError(t, err)
+		_, err = zone.CatalogMembers(z)
+		Error(t, err, "version 1 isn't supported")
+	})
+
+	var catalogs zone.Sources
+	NoError(t, catalogs.Set("catalog.example=axfr://"+primary.Addr.String()))
+	set := zone.NewSet(nil, catalogs)
+
+	t.Run("members_are_transferred", func(t *testing.T) {
+		NoError(t, set.Load(context.Background()))
+
+		NotNil(t, set.Find("www.one.example"))
+		NotNil(t, set.Find("www.two.example"))
+		Nil(t, set.Find("a1.zones.catalog.example"), "the catalog itself isn't answered from")
+	})
+
+	t.Run("removed_members_are_dropped", func(t *testing.T) {
+		primary.SetZone(t, "catalog.example", strings.Replace(catalog, "b2.zones PTR two.example.", "", 1))
+		NoError(t, set.Load(context.Background()))
+
+		NotNil(t, set.Find("www.one.example"))
+		Nil(t, set.Find("www.two.example"))
+	})
+
+	t.Run("catalog_from_file", func(t *testing.T) {
+		var sources zone.Sources
+		NoError(t, sources.Set("catalog.example=/etc/godns/catalog.zone"))
+		Error(t, zone.NewSet(nil, sources).Load(context.Background()))
+	})
+}
+
+func TestRefresh(t *testing.T) {
+	const version = "@ SOA ns.example.com. admin.example.com. %d 2 3 4 5\nwww A %s\n"
+	primary := dnstest.NewServer(t, map[string]string{
+		"example.com": fmt.Sprintf(version, 1, "10.0.0.1"),
+	})
+
+	path := filepath.Join(t.TempDir(), "example.org.zone")
+	NoError(t, ioutil.WriteFile(path, []byte(fmt.Sprintf(version, 1, "10.0.0.1")), 0644))
+
+	var sources zone.Sources
+	NoError(t, sources.Set("example.com=axfr://"+primary.Addr.String()))
+	NoError(t, sources.Set("example.org="+path))
+	set := zone.NewSet(sources, nil)
+	NoError(t, set.Load(context.Background()))
+
+	address := func() string {
+		return dnstest.Texts(set.Find("example.com").Answer("www.example.com", dns.AQueryType).Answers)[0]
+	}
+
+	t.Run("secondaries", func(t *testing.T) {
+		source, ok := set.Secondary("Example.com.")
+		True(t, ok)
+		Equal(t, primary.Addr.String(), source.Primary())
+
+		_, ok = set.Secondary("example.org")
+		False(t, ok, "loaded from a file")
+		_, ok = set.Secondary("example.net")
+		False(t, ok)
+	})
+
+	t.Run("same_serial_isnt_transferred", func(t *testing.T) {
+		primary.SetZone(t, "example.com", fmt.Sprintf(version, 1, "10.0.0.2"))
+
+		transferred, err := set.Refresh(context.Background(), "example.com")
+		NoError(t, err)
+		False(t, transferred)
+		Equal(t, "www.example.com. 3600 IN A 10.0.0.1", address())
+	})
+
+	t.Run("newer_serial_is_transferred", func(t *testing.T) {
+		primary.SetZone(t, "example.com", fmt.Sprintf(version, 2, "10.0.0.2"))
+
+		transferred, err := set.Refresh(context.Background(), "example.com")
+		NoError(t, err)
+		True(t, transferred)
+		Equal(t, "www.example.com. 3600 IN A 10.0.0.2", address())
+	})
+
+	t.Run("only_secondaries_are_refreshed", func(t *testing.T) {
+		_, err := set.Refresh(context.Background(), "example.org")
+		Error(t, err)
+	})
+}
+
+func TestRefreshDue(t *testing.T) {
+	// Refresh after 2 minutes, retry after 1 and expire after 10
+	const version = "@ SOA ns.example.com. admin.example.com. %d 120 60 600 5\nwww A %s\n"
+	primary := dnstest.NewServer(t, map[string]string{
+		"example.com": fmt.Sprintf(version, 1, "10.0.0.1"),
+	})
+
+	var sources zone.Sources
+	NoError(t, sources.Set("example.com=axfr://"+primary.Addr.String()))
+	set := zone.NewSet(sources, nil)
+	start := time.Now()
+	NoError(t, set.Load(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	address := func() string {
+		return dnstest.Texts(set.Find("example.com").Answer("www.example.com", dns.AQueryType).Answers)[0]
+	}
+
+	t.Run("refreshed_after_refresh_interval", func(t *testing.T) {
+		primary.SetZone(t, "example.com", fmt.Sprintf(version, 2, "10.0.0.2"))
+
+		set.RefreshDue(ctx, start.Add(time.Minute))
+		Equal(t, "www.example.com. 3600 IN A 10.0.0.1", address())
+
+		set.RefreshDue(ctx, start.Add(3*time.Minute))
+		Equal(t, "www.example.com. 3600 IN A 10.0.0.2", address())
+	})
+
+	t.Run("kept_while_not_expired", func(t *testing.T) {
+		primary.Close()
+
+		set.RefreshDue(ctx, start.Add(6*time.Minute))
+		NotNil(t, set.Find("www.example.com"))
+	})
+
+	t.Run("expired_after_expire_interval", func(t *testing.T) {
+		set.RefreshDue(ctx, start.Add(14*time.Minute))
+		Nil(t, set.Find("www.example.com"))
+	})
+}
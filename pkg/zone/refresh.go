@@ -0,0 +1,132 @@
+package zone
+
+import (
+	"context"
+	"time"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/logger"
+)
+
+// minInterval keeps SOAs with tiny timers from hammering the primary.
+const minInterval = time.Minute
+
+// timers track a secondary zone by the SOA timers of RFC1035 3.3.13: it is
+// checked every refresh interval, every retry interval while its primary
+// can't be reached and expires when that lasts the expire interval.
+type timers struct {
+	// soa is the SOA of the zone loaded, nil until it is or once it expired
+	soa *dns.DNSRecord
+	// synced is when the primary last answered
+	synced time.Time
+	next   time.Time
+}
+
+// RefreshDue refreshes the secondary zones and catalogs whose refresh or
+// retry interval elapsed at now and expires those whose primary didn't
+// answer for their expire interval, expired zones are no longer answered
+// from until a transfer succeeds again.
+func (s *Set) RefreshDue(ctx context.Context, now time.Time) {
+	for _, name := range s.secondaries() {
+		s.mu.RLock()
+		t := s.timers[name]
+		s.mu.RUnlock()
+		if t != nil && now.Before(t.next) {
+			continue
+		}
+
+		if _, err := s.refresh(ctx, name, now); err != nil {
+			logger.Errorf("Error: refreshing zone %s: %s\n", name, err)
+		}
+	}
+}
+
+// Maintain runs RefreshDue every interval until ctx is cancelled.
+func (s *Set) Maintain(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.RefreshDue(ctx, time.Now())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// secondaries returns the names of the secondary zones, catalogs and
+// catalog members.
+func (s *Set) secondaries() []string {
+	names := make([]string, 0, len(s.sources)+len(s.catalogs))
+	seen := make(map[string]bool)
+	for _, source := range append(append(Sources(nil), s.sources...), s.catalogs...) {
+		name := buffer.NewDomainName(source.Zone).Normalized()
+		if source.Primary() != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for member := range s.members {
+		if !seen[member] {
+			seen[member] = true
+			names = append(names, member)
+		}
+	}
+
+	return names
+}
+
+// soa returns the SOA of the secondary zone loaded or nil, s.mu must be held.
+func (s *Set) soa(name string) *dns.DNSRecord {
+	if t := s.timers[name]; t != nil {
+		return t.soa
+	}
+
+	return nil
+}
+
+// synced records that the primary of the zone answered at now with the SOA
+// loaded, s.mu must be held.
+func (s *Set) synced(name string, soa *dns.DNSRecord, now time.Time) {
+	s.timers[name] = &timers{
+		soa:    soa,
+		synced: now,
+		next:   now.Add(interval(soa.Refresh)),
+	}
+}
+
+// failed records that the zone couldn't be refreshed at now and expires it
+// when its primary didn't answer for too long, s.mu must be held.
+func (s *Set) failed(name string, now time.Time) {
+	t := s.timers[name]
+	if t == nil {
+		t = &timers{}
+		s.timers[name] = t
+	}
+	if t.soa == nil {
+		t.next = now.Add(minInterval)
+		return
+	}
+
+	t.next = now.Add(interval(t.soa.Retry))
+	if expire := interval(t.soa.Expire); now.Sub(t.synced) >= expire {
+		logger.Infof("Zone %s expired, its primary didn't answer for %s\n", name, expire)
+		t.soa = nil
+		delete(s.zones, name)
+	}
+}
+
+// interval converts an SOA timer in seconds, raised to minInterval.
+func interval(seconds uint32) time.Duration {
+	if d := time.Duration(seconds) * time.Second; d > minInterval {
+		return d
+	}
+
+	return minInterval
+}
@@ -0,0 +1,297 @@
+package zone
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/msarvar/godns/pkg/dnssec"
+	"github.com/msarvar/godns/pkg/logger"
+	"github.com/msarvar/godns/pkg/resolver"
+	"github.com/pkg/errors"
+)
+
+// Set holds the zones the server is authoritative for. It is safe for
+// concurrent use and can be reloaded while queries are answered.
+type Set struct {
+	sources Sources
+	// catalogs list further zones transferred from the primary of the
+	// catalog, the catalogs themselves aren't answered from
+	catalogs Sources
+	// keys sign the zones they belong to whenever they are loaded
+	keys dnssec.Keys
+
+	mu    sync.RWMutex
+	zones map[string]*Zone
+	// members maps the zones added by a catalog to its name
+	members map[string]string
+	// timers schedule the refreshes of secondary zones and catalogs
+	timers map[string]*timers
+}
+
+func NewSet(sources Sources, catalogs Sources) *Set {
+	return &Set{
+		sources:  sources,
+		catalogs: catalogs,
+		zones:    make(map[string]*Zone),
+		members:  make(map[string]string),
+		timers:   make(map[string]*timers),
+	}
+}
+
+// Load (re)loads every zone and catalog. A zone that fails to load keeps its
+// previous records, the first error is returned. Members a catalog no longer
+// lists are removed.
+func (s *Set) Load(ctx context.Context) error {
+	now := time.Now()
+	var first error
+	fail := func(err error) {
+		if first == nil {
+			first = err
+		}
+	}
+
+	for _, source := range s.sources {
+		z, err := s.load(ctx, source)
+		if err != nil {
+			if source.Primary() != "" {
+				s.mu.Lock()
+				s.failed(buffer.NewDomainName(source.Zone).Normalized(), now)
+				s.mu.Unlock()
+			}
+			fail(errors.Wrapf(err, "loading zone %s", source.Zone))
+			continue
+		}
+
+		s.mu.Lock()
+		s.zones[z.Name] = z
+		if source.Primary() != "" {
+			s.synced(z.Name, z.SOA, now)
+		}
+		s.mu.Unlock()
+	}
+
+	for _, catalog := range s.catalogs {
+		if err := s.loadCatalog(ctx, catalog, now, fail); err != nil {
+			s.mu.Lock()
+			s.failed(buffer.NewDomainName(catalog.Zone).Normalized(), now)
+			s.mu.Unlock()
+			fail(errors.Wrapf(err, "loading catalog %s", catalog.Zone))
+		}
+	}
+
+	return first
+}
+
+// loadCatalog transfers the catalog and then each of its members from the
+// same primary. A catalog that fails to load keeps its previous members.
+func (s *Set) loadCatalog(ctx context.Context, catalog Source, now time.Time, fail func(error)) error {
+	if catalog.Primary() == "" {
+		return errors.New("catalogs must be transferred from a primary")
+	}
+
+	z, err := s.load(ctx, catalog)
+	if err != nil {
+		return err
+	}
+
+	members, err := CatalogMembers(z)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.synced(z.Name, z.SOA, now)
+	s.mu.Unlock()
+
+	listed := make(map[string]bool, len(members))
+	for _, member := range members {
+		listed[member] = true
+
+		source := Source{Zone: member, Location: catalog.Location}
+		mz, err := s.load(ctx, source)
+		if err != nil {
+			s.mu.Lock()
+			if s.members[member] == z.Name {
+				s.failed(member, now)
+			}
+			s.mu.Unlock()
+			fail(errors.Wrapf(err, "loading zone %s of catalog %s", member, z.Name))
+			continue
+		}
+
+		s.mu.Lock()
+		if owner, ok := s.members[member]; ok || s.zones[member] == nil {
+			if ok && owner != z.Name {
+				logger.Infof("Zone %s moved from catalog %s to %s\n", member, owner, z.Name)
+			}
+			s.zones[member] = mz
+			s.members[member] = z.Name
+			s.synced(member, mz.SOA, now)
+		}
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for member, owner := range s.members {
+		if owner == z.Name && !listed[member] {
+			logger.Infof("Removing zone %s, catalog %s no longer lists it\n", member, z.Name)
+			delete(s.zones, member)
+			delete(s.members, member)
+			delete(s.timers, member)
+		}
+	}
+
+	return nil
+}
+
+// Secondary returns the source a secondary zone or catalog is transferred
+// from, false for zone files and zones the set doesn't have. Members are
+// transferred from the primary of their catalog.
+func (s *Set) Secondary(name string) (Source, bool) {
+	name = buffer.NewDomainName(name).Normalized()
+
+	for _, source := range append(append(Sources(nil), s.sources...), s.catalogs...) {
+		if buffer.NewDomainName(source.Zone).Normalized() == name {
+			return source, source.Primary() != ""
+		}
+	}
+
+	s.mu.RLock()
+	catalog, ok := s.members[name]
+	s.mu.RUnlock()
+	if !ok {
+		return Source{}, false
+	}
+
+	for _, c := range s.catalogs {
+		if buffer.NewDomainName(c.Zone).Normalized() == catalog {
+			return Source{Zone: name, Location: c.Location}, true
+		}
+	}
+
+	return Source{}, false
+}
+
+// Refresh transfers a secondary zone again when its primary has a newer SOA
+// serial than the one loaded and reports whether it did. Refreshing a catalog
+// also transfers its members.
+func (s *Set) Refresh(ctx context.Context, name string) (bool, error) {
+	return s.refresh(ctx, name, time.Now())
+}
+
+// refresh is Refresh at now, zones that fail to refresh are retried after
+// the retry interval of their SOA and expire in the end.
+func (s *Set) refresh(ctx context.Context, name string, now time.Time) (bool, error) {
+	source, ok := s.Secondary(name)
+	if !ok {
+		return false, errors.Errorf("%s is not a secondary zone", name)
+	}
+	name = buffer.NewDomainName(name).Normalized()
+
+	fail := func(err error) (bool, error) {
+		s.mu.Lock()
+		s.failed(name, now)
+		s.mu.Unlock()
+		return false, err
+	}
+
+	soa, err := resolver.QuerySOA(ctx, source.Primary(), source.Zone)
+	if err != nil {
+		return fail(err)
+	}
+
+	s.mu.Lock()
+	loaded := s.soa(name)
+	if loaded != nil && dns.CompareSerials(soa.Serial, loaded.Serial) <= 0 {
+		s.synced(name, loaded, now)
+		s.mu.Unlock()
+		return false, nil
+	}
+	s.mu.Unlock()
+
+	for _, c := range s.catalogs {
+		if c != source {
+			continue
+		}
+
+		// Members that fail are retried on their own
+		var first error
+		if err := s.loadCatalog(ctx, source, now, func(err error) {
+			if first == nil {
+				first = err
+			}
+		}); err != nil {
+			return fail(err)
+		}
+		return true, first
+	}
+
+	z, err := s.load(ctx, source)
+	if err != nil {
+		return fail(errors.Wrapf(err, "loading zone %s", name))
+	}
+
+	s.mu.Lock()
+	s.zones[z.Name] = z
+	s.synced(z.Name, z.SOA, now)
+	s.mu.Unlock()
+
+	return true, nil
+}
+
+// SignWith makes the set sign the zones it has keys for with DNSSEC when
+// they are loaded. Signatures last dnssec.SignatureValidity, the zones must
+// be loaded again before.
+func (s *Set) SignWith(keys dnssec.Keys) {
+	s.keys = keys
+}
+
+func (s *Set) load(ctx context.Context, source Source) (*Zone, error) {
+	records, err := source.Records(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if keys := s.keys.ForZone(source.Zone); len(keys) > 0 {
+		records, err = dnssec.Sign(source.Zone, records, keys, time.Now())
+		if err != nil {
+			return nil, errors.Wrap(err, "signing zone")
+		}
+	}
+
+	return New(source.Zone, records)
+}
+
+// Find returns the most specific zone containing name or nil.
+func (s *Set) Find(name string) *Zone {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	labels := buffer.NewDomainName(name).SplitLabels()
+	for i := 0; i <= len(labels); i++ {
+		if z, ok := s.zones[strings.ToLower(strings.Join(labels[i:], "."))]; ok {
+			return z
+		}
+	}
+
+	return nil
+}
+
+// Zones returns the loaded zones.
+func (s *Set) Zones() []*Zone {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	zones := make([]*Zone, 0, len(s.zones))
+	for _, z := range s.zones {
+		zones = append(zones, z)
+	}
+
+	return zones
+}
@@ -0,0 +1,316 @@
+package zone
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/msarvar/godns/pkg/buffer"
+	"github.com/msarvar/godns/pkg/dns"
+	"github.com/pkg/errors"
+)
+
+// maxCNAMEChain bounds how many CNAMEs inside the zone are followed.
+const maxCNAMEChain = 8
+
+// Zone holds the RRsets of a zone keyed by normalized owner name. Empty
+// non-terminals, names existing only because names below them do, have an
+// empty slice.
+type Zone struct {
+	Name string
+	SOA  *dns.DNSRecord
+
+	nodes map[string][]*dns.RRset
+	// nsec has the owners of the NSEC records of signed zones in canonical
+	// order, it is empty for unsigned zones
+	nsec []string
+}
+
+// New builds the zone, the records must all belong to it and include the SOA
+// at its apex. Records of an RRset get the lowest TTL among them.
+func New(name string, records []*dns.DNSRecord) (*Zone, error) {
+	origin := buffer.NewDomainName(name)
+	z := &Zone{
+		Name:  origin.Normalized(),
+		nodes: map[string][]*dns.RRset{origin.Normalized(): {}},
+	}
+
+	for _, r := range records {
+		if !r.Domain.IsSubdomainOf(origin) {
+			return nil, errors.Errorf("record %s is outside of zone %s", r.Domain, name)
+		}
+
+		owner := r.Domain.Normalized()
+		if r.QType == dns.SOAQueryType {
+			if owner != z.Name {
+				return nil, errors.Errorf("SOA of zone %s at %s", name, r.Domain)
+			}
+			if z.SOA != nil {
+				return nil, errors.Errorf("zone %s has several SOA records", name)
+			}
+			z.SOA = r
+		}
+
+		if set := z.rrset(owner, r.QType); set != nil {
+			if err := set.Add(r); err != nil {
+				return nil, err
+			}
+		} else {
+			z.nodes[owner] = append(z.nodes[owner], dns.NewRRset(r))
+		}
+
+		for _, parent := range z.ancestors(owner) {
+			if _, ok := z.nodes[parent]; !ok {
+				z.nodes[parent] = []*dns.RRset{}
+			}
+		}
+	}
+
+	if z.SOA == nil {
+		return nil, errors.Errorf("zone %s has no SOA record", name)
+	}
+
+	for owner, sets := range z.nodes {
+		for _, set := range sets {
+			set.HarmonizeTTL()
+			if set.Type == dns.NSECQueryType {
+				z.nsec = append(z.nsec, owner)
+			}
+		}
+	}
+	sort.Slice(z.nsec, func(i, j int) bool {
+		return dns.CompareNames(z.nsec[i], z.nsec[j]) < 0
+	})
+
+	return z, nil
+}
+
+// Records returns every record of the zone, the SOA first as in a zone
+// transfer.
+func (z *Zone) Records() []*dns.DNSRecord {
+	records := []*dns.DNSRecord{z.SOA}
+	for _, sets := range z.nodes {
+		for _, set := range sets {
+			if set.Type == dns.SOAQueryType {
+				continue
+			}
+			records = append(records, set.Records...)
+		}
+	}
+
+	return records
+}
+
+// Len returns the number of names in the zone.
+func (z *Zone) Len() int {
+	return len(z.nodes)
+}
+
+// rrset returns the RRset of the type at owner or nil.
+func (z *Zone) rrset(owner string, qtype dns.QueryType) *dns.RRset {
+	for _, set := range z.nodes[owner] {
+		if set.Type == qtype {
+			return set
+		}
+	}
+
+	return nil
+}
+
+// records returns the records of the RRset of the type at owner.
+func (z *Zone) records(owner string, qtype dns.QueryType) []*dns.DNSRecord {
+	if set := z.rrset(owner, qtype); set != nil {
+		return set.Records
+	}
+
+	return nil
+}
+
+// ancestors returns the names between owner and the apex, closest first and
+// excluding both.
+func (z *Zone) ancestors(owner string) []string {
+	names := make([]string, 0)
+	for owner != z.Name {
+		i := strings.IndexByte(owner, '.')
+		if i < 0 {
+			owner = ""
+		} else {
+			owner = owner[i+1:]
+		}
+		if owner == z.Name {
+			break
+		}
+		names = append(names, owner)
+	}
+
+	return names
+}
+
+// Answer looks name up authoritatively. Names without records of the type get
+// NODATA, names that don't exist NXDOMAIN, both with the SOA in the authority
+// section (RFC2308). Names below a delegation get a referral. Positive answers
+// carry the name servers of the zone and their addresses like those of any
+// authoritative server.
+func (z *Zone) Answer(name string, qtype dns.QueryType) *dns.DNSPacket {
+	packet := dns.NewDNSPacket()
+	packet.Header.Response = true
+	packet.Header.AuthoritativeAnswer = true
+
+	z.lookup(packet, name, qtype)
+
+	if packet.Header.AuthoritativeAnswer && len(packet.Answers) > 0 {
+		ns := z.records(z.Name, dns.NSQueryType)
+		if !containsAll(packet.Answers, ns) {
+			packet.Authorities = append(packet.Authorities, ns...)
+		}
+		packet.Resources = append(packet.Resources, z.glue(ns)...)
+	}
+
+	return packet
+}
+
+// lookup fills the sections of packet answering name and qtype.
+func (z *Zone) lookup(packet *dns.DNSPacket, name string, qtype dns.QueryType) {
+	for i := 0; i < maxCNAMEChain; i++ {
+		owner := buffer.NewDomainName(name).Normalized()
+
+		if ns := z.delegation(owner); ns != nil {
+			// Only the first name may be referred, a CNAME into a child zone
+			// is left to the client
+			if i == 0 {
+				packet.Header.AuthoritativeAnswer = false
+				packet.Authorities = ns
+				packet.Resources = z.glue(ns)
+			}
+			return
+		}
+
+		sets, ok := z.nodes[owner]
+		if !ok {
+			sets, ok = z.wildcard(owner, name)
+		}
+		if !ok {
+			if i == 0 {
+				packet.Header.ResCode = dns.NxDomain
+				packet.Authorities = append(packet.Authorities, z.negativeSOA())
+			}
+			return
+		}
+
+		var cname *dns.DNSRecord
+		answered := false
+		for _, set := range sets {
+			if set.Type == qtype {
+				packet.Answers = append(packet.Answers, set.Records...)
+				answered = true
+			}
+			if set.Type == dns.CNAMEQueryType {
+				cname = set.Records[0]
+			}
+		}
+
+		if answered || cname == nil {
+			if !answered && i == 0 {
+				packet.Authorities = append(packet.Authorities, z.negativeSOA())
+			}
+			return
+		}
+
+		packet.Answers = append(packet.Answers, cname)
+		if !cname.Host.IsSubdomainOf(buffer.NewDomainName(z.Name)) {
+			return
+		}
+		name = cname.Host.String()
+	}
+}
+
+// delegation returns the NS records of the zone cut at or above owner.
+func (z *Zone) delegation(owner string) []*dns.DNSRecord {
+	if owner == z.Name {
+		return nil
+	}
+	names := append([]string{owner}, z.ancestors(owner)...)
+
+	// The cut closest to the apex wins, names below it are occluded
+	for i := len(names) - 1; i >= 0; i-- {
+		if ns := z.records(names[i], dns.NSQueryType); len(ns) > 0 {
+			return ns
+		}
+	}
+
+	return nil
+}
+
+// containsAll reports whether every record of want is in records.
+func containsAll(records []*dns.DNSRecord, want []*dns.DNSRecord) bool {
+	for _, w := range want {
+		found := false
+		for _, r := range records {
+			if r == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// glue returns the addresses of the name servers that are inside the zone.
+func (z *Zone) glue(ns []*dns.DNSRecord) []*dns.DNSRecord {
+	glue := make([]*dns.DNSRecord, 0)
+	for _, n := range ns {
+		glue = append(glue, z.records(n.Host.Normalized(), dns.AQueryType)...)
+		glue = append(glue, z.records(n.Host.Normalized(), dns.AAAAQueryType)...)
+	}
+
+	return glue
+}
+
+// wildcard returns the RRsets of the wildcard at the closest encloser of
+// owner (RFC4592) renamed to name.
+func (z *Zone) wildcard(owner string, name string) ([]*dns.RRset, bool) {
+	for _, parent := range append(z.ancestors(owner), z.Name) {
+		if _, ok := z.nodes[parent]; !ok {
+			continue
+		}
+
+		wildcard := "*"
+		if parent != "" {
+			wildcard += "." + parent
+		}
+
+		sets, ok := z.nodes[wildcard]
+		if !ok {
+			return nil, false
+		}
+
+		owner := buffer.NewDomainName(name)
+		synthesized := make([]*dns.RRset, 0, len(sets))
+		for _, set := range sets {
+			renamed := &dns.RRset{Name: owner, Type: set.Type, Class: set.Class}
+			for _, r := range set.Records {
+				copied := *r
+				copied.Domain = owner
+				renamed.Records = append(renamed.Records, &copied)
+			}
+			synthesized = append(synthesized, renamed)
+		}
+		return synthesized, true
+	}
+
+	return nil, false
+}
+
+// negativeSOA returns the SOA for negative answers, its TTL is capped by the
+// minimum field as it limits how long the answer is cached.
+func (z *Zone) negativeSOA() *dns.DNSRecord {
+	soa := *z.SOA
+	if soa.Minimum < soa.TTL {
+		soa.TTL = soa.Minimum
+	}
+
+	return &soa
+}